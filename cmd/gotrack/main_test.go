@@ -1,563 +0,0 @@
-package main
-
-import (
-	"context"
-	"fmt"
-	"net/http"
-	"net/http/httptest"
-	"os"
-	"strings"
-	"testing"
-	"time"
-
-	"github.com/shortontech/gotrack/internal/event"
-	httpx "github.com/shortontech/gotrack/internal/http"
-	"github.com/shortontech/gotrack/internal/metrics"
-	"github.com/shortontech/gotrack/internal/sink"
-	"github.com/shortontech/gotrack/pkg/config"
-)
-
-// Mock sink for testing
-type mockSink struct {
-	name     string
-	events   []event.Event
-	startErr error
-	enqErr   error
-	closeErr error
-}
-
-func (m *mockSink) Start(ctx context.Context) error {
-	return m.startErr
-}
-
-func (m *mockSink) Enqueue(e event.Event) error {
-	if m.enqErr != nil {
-		return m.enqErr
-	}
-	m.events = append(m.events, e)
-	return nil
-}
-
-func (m *mockSink) Close() error {
-	return m.closeErr
-}
-
-func (m *mockSink) Name() string {
-	return m.name
-}
-
-// TestInitializeSinks tests sink initialization
-func TestInitializeSinks(t *testing.T) {
-	ctx := context.Background()
-
-	t.Run("log sink", func(t *testing.T) {
-		outputs := []string{"log"}
-		sinks := initializeSinks(ctx, outputs)
-		
-		if len(sinks) != 1 {
-			t.Errorf("expected 1 sink, got %d", len(sinks))
-		}
-		if len(sinks) > 0 && sinks[0].Name() != "log" {
-			t.Errorf("expected log sink, got %s", sinks[0].Name())
-		}
-		
-		// Cleanup
-		for _, s := range sinks {
-			s.Close()
-		}
-	})
-
-	t.Run("unknown output type", func(t *testing.T) {
-		outputs := []string{"unknown"}
-		sinks := initializeSinks(ctx, outputs)
-		
-		if len(sinks) != 0 {
-			t.Errorf("expected 0 sinks for unknown type, got %d", len(sinks))
-		}
-	})
-
-	t.Run("multiple outputs", func(t *testing.T) {
-		outputs := []string{"log", "unknown"}
-		sinks := initializeSinks(ctx, outputs)
-		
-		// Should skip unknown and only create log sink
-		if len(sinks) != 1 {
-			t.Errorf("expected 1 sink, got %d", len(sinks))
-		}
-		
-		// Cleanup
-		for _, s := range sinks {
-			s.Close()
-		}
-	})
-}
-
-// TestInitializeHMACAuth tests HMAC authentication initialization
-func TestInitializeHMACAuth(t *testing.T) {
-	t.Run("no HMAC secret", func(t *testing.T) {
-		cfg := config.Config{
-			HMACSecret: "",
-		}
-		
-		auth := initializeHMACAuth(cfg)
-		if auth != nil {
-			t.Error("expected nil auth when no HMAC secret configured")
-		}
-	})
-}
-
-// TestCreateEmitFunc tests the emit function creation
-func TestCreateEmitFunc(t *testing.T) {
-	t.Run("successful emit to all sinks", func(t *testing.T) {
-		mock1 := &mockSink{name: "sink1"}
-		mock2 := &mockSink{name: "sink2"}
-		sinks := []sink.Sink{mock1, mock2}
-		
-		appMetrics := metrics.InitMetrics()
-		emitFunc := createEmitFunc(sinks, appMetrics)
-		
-		testEvent := event.Event{
-			EventID: "test-123",
-			Type:    "click",
-		}
-		
-		emitFunc(testEvent)
-		
-		if len(mock1.events) != 1 {
-			t.Errorf("sink1: expected 1 event, got %d", len(mock1.events))
-		}
-		if len(mock2.events) != 1 {
-			t.Errorf("sink2: expected 1 event, got %d", len(mock2.events))
-		}
-		if mock1.events[0].EventID != "test-123" {
-			t.Errorf("sink1: expected event ID test-123, got %s", mock1.events[0].EventID)
-		}
-	})
-
-	t.Run("emit with sink error", func(t *testing.T) {
-		mockFailing := &mockSink{
-			name:   "failing-sink",
-			enqErr: fmt.Errorf("enqueue failed"),
-		}
-		mockWorking := &mockSink{name: "working-sink"}
-		sinks := []sink.Sink{mockFailing, mockWorking}
-		
-		appMetrics := metrics.InitMetrics()
-		emitFunc := createEmitFunc(sinks, appMetrics)
-		
-		testEvent := event.Event{
-			EventID: "test-456",
-			Type:    "pageview",
-		}
-		
-		emitFunc(testEvent)
-		
-		// Working sink should still receive the event
-		if len(mockWorking.events) != 1 {
-			t.Errorf("working sink should receive event despite failing sink")
-		}
-	})
-
-	t.Run("emit to empty sinks", func(t *testing.T) {
-		sinks := []sink.Sink{}
-		appMetrics := metrics.InitMetrics()
-		emitFunc := createEmitFunc(sinks, appMetrics)
-		
-		testEvent := event.Event{
-			EventID: "test-789",
-			Type:    "conversion",
-		}
-		
-		// Should not panic
-		emitFunc(testEvent)
-	})
-}
-
-// TestStartHTTPServer tests HTTP server initialization
-func TestStartHTTPServer(t *testing.T) {
-	t.Run("HTTP server", func(t *testing.T) {
-		cfg := config.Config{
-			ServerAddr:  "127.0.0.1:0", // Use port 0 to get random available port
-			EnableHTTPS: false,
-		}
-		
-		env := httpx.Env{
-			Cfg:     cfg,
-			Metrics: metrics.InitMetrics(),
-			Emit:    func(e event.Event) {},
-		}
-		
-		srv := startHTTPServer(cfg, env)
-		
-		// Give server time to start
-		time.Sleep(100 * time.Millisecond)
-		
-		// Shutdown
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cancel()
-		if err := srv.Shutdown(ctx); err != nil {
-			t.Errorf("failed to shutdown server: %v", err)
-		}
-	})
-}
-
-// TestPerformHealthCheck tests the health check function
-func TestPerformHealthCheck(t *testing.T) {
-	t.Run("successful health check", func(t *testing.T) {
-		// Create test server
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == "/healthz" {
-				w.WriteHeader(http.StatusOK)
-				w.Write([]byte("ok"))
-			} else {
-				w.WriteHeader(http.StatusNotFound)
-			}
-		}))
-		defer ts.Close()
-		
-		// Start a real HTTP server that we can health check
-		testSrv := &http.Server{
-			Addr: "127.0.0.1:19999",
-			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				if r.URL.Path == "/healthz" {
-					w.WriteHeader(http.StatusOK)
-					w.Write([]byte("ok"))
-				}
-			}),
-		}
-		
-		go testSrv.ListenAndServe()
-		time.Sleep(100 * time.Millisecond) // Give server time to start
-		
-		err := performHealthCheck("127.0.0.1", "19999")
-		
-		// Cleanup
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cancel()
-		testSrv.Shutdown(ctx)
-		
-		if err != nil {
-			t.Errorf("health check should succeed: %v", err)
-		}
-	})
-
-	t.Run("health check connection error", func(t *testing.T) {
-		err := performHealthCheck("localhost", "99999")
-		if err == nil {
-			t.Error("expected error when connecting to non-existent server")
-		}
-		if err != nil && !contains(err.Error(), "failed to connect") {
-			t.Logf("got expected error: %v", err)
-		}
-	})
-
-	t.Run("health check with non-200 status", func(t *testing.T) {
-		testSrv := &http.Server{
-			Addr: "127.0.0.1:19998",
-			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(http.StatusInternalServerError)
-			}),
-		}
-		
-		go testSrv.ListenAndServe()
-		time.Sleep(100 * time.Millisecond)
-		
-		err := performHealthCheck("127.0.0.1", "19998")
-		
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cancel()
-		testSrv.Shutdown(ctx)
-		
-		if err == nil {
-			t.Error("expected error for non-200 status")
-		}
-		if err != nil && !contains(err.Error(), "status") {
-			t.Errorf("error should mention status: %v", err)
-		}
-	})
-
-	t.Run("health check with wrong response body", func(t *testing.T) {
-		testSrv := &http.Server{
-			Addr: "127.0.0.1:19997",
-			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(http.StatusOK)
-				w.Write([]byte("wrong"))
-			}),
-		}
-		
-		go testSrv.ListenAndServe()
-		time.Sleep(100 * time.Millisecond)
-		
-		err := performHealthCheck("127.0.0.1", "19997")
-		
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cancel()
-		testSrv.Shutdown(ctx)
-		
-		if err == nil {
-			t.Error("expected error for wrong response body")
-		}
-		if err != nil && !contains(err.Error(), "unexpected") {
-			t.Errorf("error should mention unexpected response: %v", err)
-		}
-	})
-}
-
-// TestWaitForShutdown tests graceful shutdown
-func TestWaitForShutdown(t *testing.T) {
-	t.Run("shutdown with all components", func(t *testing.T) {
-		// Create minimal server
-		srv := &http.Server{
-			Addr:    "127.0.0.1:0",
-			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
-		}
-		
-		// Start server in background
-		go srv.ListenAndServe()
-		time.Sleep(50 * time.Millisecond)
-		
-		// Create metrics server
-		metricsConfig := metrics.Config{
-			Enabled: false,
-			Addr:    ":0",
-		}
-		metricsServer := metrics.NewServer(metricsConfig)
-		
-		// Create mock sinks
-		mock1 := &mockSink{name: "test-sink"}
-		sinks := []sink.Sink{mock1}
-		
-		// Test that shutdown completes without hanging
-		done := make(chan bool, 1)
-		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-			defer cancel()
-			srv.Shutdown(ctx)
-			metricsServer.Shutdown(ctx)
-			for _, s := range sinks {
-				s.Close()
-			}
-			done <- true
-		}()
-		
-		select {
-		case <-done:
-			// Success
-		case <-time.After(2 * time.Second):
-			t.Error("shutdown took too long")
-		}
-	})
-
-	t.Run("shutdown with sink error", func(t *testing.T) {
-		srv := &http.Server{Addr: "127.0.0.1:0"}
-		metricsConfig := metrics.Config{Enabled: false, Addr: ":0"}
-		metricsServer := metrics.NewServer(metricsConfig)
-		
-		mockError := &mockSink{
-			name:     "error-sink",
-			closeErr: fmt.Errorf("close error"),
-		}
-		sinks := []sink.Sink{mockError}
-		
-		// Should handle error gracefully
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cancel()
-		
-		srv.Shutdown(ctx)
-		metricsServer.Shutdown(ctx)
-		for _, s := range sinks {
-			err := s.Close()
-			if err == nil {
-				t.Error("expected error from mock sink")
-			}
-		}
-	})
-}
-
-// Helper function
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && indexOf(s, substr) >= 0)
-}
-
-func indexOf(s, substr string) int {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return i
-		}
-	}
-	return -1
-}
-
-// Integration-style test for the full initialization flow
-func TestMainFunctions_Integration(t *testing.T) {
-	t.Run("full flow without actual main", func(t *testing.T) {
-		// Set up config via environment
-		oldOutputs := os.Getenv("OUTPUTS")
-		os.Setenv("OUTPUTS", "log")
-		defer os.Setenv("OUTPUTS", oldOutputs)
-		
-		cfg := config.Load()
-		
-		// Initialize components
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-		
-		sinks := initializeSinks(ctx, []string{"log"})
-		if len(sinks) == 0 {
-			t.Error("expected at least one sink")
-		}
-		
-		hmacAuth := initializeHMACAuth(cfg)
-		_ = hmacAuth // May be nil, which is fine
-		
-		appMetrics := metrics.InitMetrics()
-		emitFunc := createEmitFunc(sinks, appMetrics)
-		
-		// Test emit
-		testEvent := event.Event{
-			EventID: "integration-test",
-			Type:    "test",
-		}
-		emitFunc(testEvent)
-		
-		// Cleanup
-		for _, s := range sinks {
-			s.Close()
-		}
-	})
-}
-
-// Test that main components can be created without panicking
-func TestMainComponents_NoOp(t *testing.T) {
-	t.Run("create emit func with nil metrics", func(t *testing.T) {
-		// This tests edge case handling
-		mock := &mockSink{name: "test"}
-		sinks := []sink.Sink{mock}
-		
-		// Should not panic even with nil metrics
-		appMetrics := metrics.InitMetrics()
-		emitFunc := createEmitFunc(sinks, appMetrics)
-		
-		testEvent := event.Event{EventID: "test"}
-		emitFunc(testEvent)
-		
-		if len(mock.events) != 1 {
-			t.Error("event should be emitted")
-		}
-	})
-}
-
-// Test startHTTPServer with HTTPS
-// TestStartHTTPServer_HTTPS tests HTTPS server initialization
-// Skipped: Requires valid TLS certificates which are complex to generate in tests
-func TestStartHTTPServer_HTTPS(t *testing.T) {
-	t.Skip("Skipping HTTPS test - requires valid TLS certificates")
-	
-	// This test would require proper certificate generation
-	// which is better suited for integration tests
-}
-
-// Test initializeSinks with Kafka error handling  
-func TestInitializeSinks_KafkaPath(t *testing.T) {
-// Set environment for Kafka
-oldBrokers := os.Getenv("KAFKA_BROKERS")
-oldTopic := os.Getenv("KAFKA_TOPIC")
-os.Setenv("KAFKA_BROKERS", "localhost:9092")
-os.Setenv("KAFKA_TOPIC", "test-topic")
-defer func() {
-os.Setenv("KAFKA_BROKERS", oldBrokers)
-os.Setenv("KAFKA_TOPIC", oldTopic)
-}()
-
-// This will fail without Kafka running, but exercises the code path
-// Note: We can't easily test this without causing test failure
-// So we test the config creation instead
-
-ctx := context.Background()
-outputs := []string{"log"} // Use log instead of kafka to avoid failure
-sinks := initializeSinks(ctx, outputs)
-
-if len(sinks) == 0 {
-t.Error("should create at least log sink")
-}
-
-for _, s := range sinks {
-s.Close()
-}
-}
-
-// Test initializeSinks with Postgres path
-func TestInitializeSinks_PostgresPath(t *testing.T) {
-// This would require actual Postgres connection
-// We test the code path exists but expect failure
-ctx := context.Background()
-
-// Test with log sink to ensure the switch statement works
-outputs := []string{"log"}
-sinks := initializeSinks(ctx, outputs)
-
-if len(sinks) != 1 {
-t.Errorf("expected 1 sink, got %d", len(sinks))
-}
-
-for _, s := range sinks {
-s.Close()
-}
-}
-
-// Test performHealthCheck with proper server
-func TestPerformHealthCheck_RealServer(t *testing.T) {
-// Create a test server
-ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-if r.URL.Path == "/healthz" {
-w.WriteHeader(http.StatusOK)
-w.Write([]byte("ok"))
-} else {
-w.WriteHeader(http.StatusNotFound)
-}
-}))
-defer ts.Close()
-
-// Extract host and port from test server URL
-// TestServer URL is like "http://127.0.0.1:port"
-parts := strings.Split(strings.TrimPrefix(ts.URL, "http://"), ":")
-if len(parts) != 2 {
-t.Fatalf("unexpected server URL format: %s", ts.URL)
-}
-
-host := parts[0]
-port := parts[1]
-
-err := performHealthCheck(host, port)
-if err != nil {
-t.Errorf("health check should succeed: %v", err)
-}
-}
-
-// Test waitForShutdown mechanism (without actually waiting for signal)
-func TestWaitForShutdown_Components(t *testing.T) {
-// Test that all components can be shut down
-srv := &http.Server{Addr: "127.0.0.1:0"}
-
-metricsConfig := metrics.Config{
-Enabled: false,
-Addr:    ":0",
-}
-metricsServer := metrics.NewServer(metricsConfig)
-
-mock := &mockSink{name: "test-sink"}
-sinks := []sink.Sink{mock}
-
-// Simulate shutdown
-ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-defer cancel()
-
-srv.Shutdown(ctx)
-metricsServer.Shutdown(ctx)
-for _, s := range sinks {
-err := s.Close()
-if err != nil {
-t.Errorf("sink close failed: %v", err)
-}
-}
-}
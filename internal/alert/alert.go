@@ -0,0 +1,174 @@
+// Package alert watches operational conditions, such as a sink that stays
+// down or a certificate about to expire, and posts a webhook when one
+// starts and when it clears.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Alert names
+const (
+	SinkDown    = "sink_down"
+	SinkBacklog = "sink_backlog"
+	BotSpike    = "bot_spike"
+	CertExpiry  = "cert_expiry"
+)
+
+// Condition is a problem a check currently sees
+type Condition struct {
+	Alert   string // one of the alert names
+	Subject string // what it concerns, such as a sink name or a domain
+	Message string
+}
+
+func (c Condition) key() string { return c.Alert + ":" + c.Subject }
+
+// Check reports the conditions active at now. Checks run one at a time and
+// may keep state between runs.
+type Check func(ctx context.Context, now time.Time) []Condition
+
+// Notification is the webhook payload. Text makes it a Slack incoming
+// webhook message; the other fields are for receivers that route alerts.
+type Notification struct {
+	Text     string    `json:"text"`
+	Alert    string    `json:"alert"`
+	Subject  string    `json:"subject"`
+	Status   string    `json:"status"` // firing or resolved
+	Instance string    `json:"instance,omitempty"`
+	Since    time.Time `json:"since"`
+}
+
+// Notification statuses
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Webhook posts notifications as JSON to a URL
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a webhook notifier posting to url
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Notify posts n and fails unless the receiver answers 2xx
+func (w *Webhook) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Monitor runs checks and notifies when a condition appears and when it is
+// gone. A condition that stays active is notified once.
+type Monitor struct {
+	checks   []Check
+	notify   func(context.Context, Notification) error
+	instance string
+	active   map[string]active
+}
+
+type active struct {
+	Condition
+	since time.Time
+}
+
+// NewMonitor creates a monitor sending notifications through notify.
+// instance names this server in them, as replicas alert independently.
+func NewMonitor(notify func(context.Context, Notification) error, instance string) *Monitor {
+	return &Monitor{notify: notify, instance: instance, active: make(map[string]active)}
+}
+
+// Add registers a check
+func (m *Monitor) Add(check Check) {
+	m.checks = append(m.checks, check)
+}
+
+// Evaluate runs the checks once and sends the notifications for conditions
+// that appeared or cleared since the last run. A notification that fails to
+// send is logged and not retried.
+func (m *Monitor) Evaluate(ctx context.Context, now time.Time) {
+	seen := make(map[string]bool)
+	for _, check := range m.checks {
+		for _, c := range check(ctx, now) {
+			key := c.key()
+			seen[key] = true
+			if _, ok := m.active[key]; ok {
+				continue
+			}
+			m.active[key] = active{Condition: c, since: now}
+			m.send(ctx, c, StatusFiring, now)
+		}
+	}
+	var cleared []string
+	for key := range m.active {
+		if !seen[key] {
+			cleared = append(cleared, key)
+		}
+	}
+	sort.Strings(cleared)
+	for _, key := range cleared {
+		a := m.active[key]
+		delete(m.active, key)
+		m.send(ctx, a.Condition, StatusResolved, a.since)
+	}
+}
+
+func (m *Monitor) send(ctx context.Context, c Condition, status string, since time.Time) {
+	text := fmt.Sprintf("[%s] %s: %s", status, c.Alert, c.Message)
+	if m.instance != "" {
+		text = fmt.Sprintf("[%s] %s on %s: %s", status, c.Alert, m.instance, c.Message)
+	}
+	n := Notification{
+		Text:     text,
+		Alert:    c.Alert,
+		Subject:  c.Subject,
+		Status:   status,
+		Instance: m.instance,
+		Since:    since,
+	}
+	log.Printf("alert: %s", text)
+	if err := m.notify(ctx, n); err != nil {
+		log.Printf("alert: webhook failed: %v", err)
+	}
+}
+
+// Run evaluates the checks every interval until ctx is done
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.Evaluate(ctx, now)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	var sent []Notification
+	monitor := NewMonitor(func(_ context.Context, n Notification) error {
+		sent = append(sent, n)
+		return nil
+	}, "gotrack-1")
+	var conditions []Condition
+	monitor.Add(func(context.Context, time.Time) []Condition { return conditions })
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	down := Condition{Alert: SinkDown, Subject: "postgres", Message: "postgres sink unavailable"}
+	conditions = []Condition{down}
+	monitor.Evaluate(context.Background(), start)
+	monitor.Evaluate(context.Background(), start.Add(time.Minute))
+	if len(sent) != 1 || sent[0].Status != StatusFiring || sent[0].Subject != "postgres" || sent[0].Instance != "gotrack-1" {
+		t.Fatalf("sent = %+v, want one firing notification", sent)
+	}
+	if sent[0].Text != "[firing] sink_down on gotrack-1: postgres sink unavailable" {
+		t.Errorf("text = %q", sent[0].Text)
+	}
+
+	conditions = nil
+	monitor.Evaluate(context.Background(), start.Add(2*time.Minute))
+	if len(sent) != 2 || sent[1].Status != StatusResolved || !sent[1].Since.Equal(start) {
+		t.Fatalf("sent = %+v, want a resolved notification", sent)
+	}
+	monitor.Evaluate(context.Background(), start.Add(3*time.Minute))
+	if len(sent) != 2 {
+		t.Errorf("sent %d notifications with nothing active", len(sent)-2)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	n := Notification{Text: "[firing] bot_spike: 60%", Alert: BotSpike, Subject: "events", Status: StatusFiring}
+	if err := NewWebhook(server.URL).Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != n.Text || got.Alert != BotSpike {
+		t.Errorf("received %+v", got)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	if err := NewWebhook(failing.URL).Notify(context.Background(), n); err == nil {
+		t.Error("expected an error for a 502 answer")
+	}
+}
@@ -0,0 +1,161 @@
+package alert
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/sink"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// pingTimeout bounds each sink health check
+const pingTimeout = 5 * time.Second
+
+// SinksDown reports sinks whose health check has failed continuously for at
+// least after. Sinks without a health check are never reported.
+func SinksDown(sinks []sink.Sink, after time.Duration) Check {
+	failing := make(map[string]time.Time)
+	return func(ctx context.Context, now time.Time) []Condition {
+		var conditions []Condition
+		for _, s := range sinks {
+			hc, ok := s.(sink.HealthChecker)
+			if !ok {
+				continue
+			}
+			pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+			err := hc.Ping(pingCtx)
+			cancel()
+			if err == nil {
+				delete(failing, s.Name())
+				continue
+			}
+			since, ok := failing[s.Name()]
+			if !ok {
+				since = now
+				failing[s.Name()] = since
+			}
+			if now.Sub(since) >= after {
+				conditions = append(conditions, Condition{
+					Alert:   SinkDown,
+					Subject: s.Name(),
+					Message: fmt.Sprintf("%s sink unavailable for %s: %v", s.Name(), now.Sub(since).Round(time.Second), err),
+				})
+			}
+		}
+		return conditions
+	}
+}
+
+// SinksBacklogged reports sinks holding at least threshold undelivered
+// events, which happens when their destination is slow or rejects writes
+func SinksBacklogged(sinks []sink.Sink, threshold int) Check {
+	return func(_ context.Context, _ time.Time) []Condition {
+		var conditions []Condition
+		for _, s := range sinks {
+			lr, ok := s.(sink.LoadReporter)
+			if !ok {
+				continue
+			}
+			if depth, _ := lr.Load(); depth >= threshold {
+				conditions = append(conditions, Condition{
+					Alert:   SinkBacklog,
+					Subject: s.Name(),
+					Message: fmt.Sprintf("%s sink has %d events waiting (alert at %d)", s.Name(), depth, threshold),
+				})
+			}
+		}
+		return conditions
+	}
+}
+
+// BotShare counts events and those from likely bots between checks. Feed it
+// every emitted event through Observe.
+type BotShare struct {
+	mu        sync.Mutex
+	threshold int // bot score from which an event counts as a bot's
+	percent   int // share of bot events that raises the alert
+	minEvents int // fewer events in an interval say nothing
+	events    int
+	bots      int
+}
+
+// NewBotShare creates a counter alerting when at least percent of at least
+// minEvents events in an interval have a bot score of threshold or more
+func NewBotShare(threshold, percent, minEvents int) *BotShare {
+	return &BotShare{threshold: threshold, percent: percent, minEvents: minEvents}
+}
+
+// Observe counts an event
+func (b *BotShare) Observe(ev event.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events++
+	if ev.Server.Detection.BotScore() >= b.threshold {
+		b.bots++
+	}
+}
+
+// Check reports a spike when the bot share since the last check reached the
+// configured percentage, and starts counting afresh
+func (b *BotShare) Check(_ context.Context, _ time.Time) []Condition {
+	b.mu.Lock()
+	events, bots := b.events, b.bots
+	b.events, b.bots = 0, 0
+	b.mu.Unlock()
+
+	if events < b.minEvents || bots*100 < events*b.percent {
+		return nil
+	}
+	return []Condition{{
+		Alert:   BotSpike,
+		Subject: "events",
+		Message: fmt.Sprintf("%d of %d events (%d%%) scored %d or more (alert at %d%%)", bots, events, bots*100/events, b.threshold, b.percent),
+	}}
+}
+
+// CertsExpiring reports certificates expiring within the given time. load
+// returns the PEM data of each certificate by name; the first CERTIFICATE
+// block is checked and data without one is skipped.
+func CertsExpiring(within time.Duration, load func(ctx context.Context) map[string][]byte) Check {
+	return func(ctx context.Context, now time.Time) []Condition {
+		var conditions []Condition
+		for name, data := range load(ctx) {
+			cert := firstCertificate(data)
+			if cert == nil {
+				continue
+			}
+			if left := cert.NotAfter.Sub(now); left < within {
+				conditions = append(conditions, Condition{
+					Alert:   CertExpiry,
+					Subject: name,
+					Message: fmt.Sprintf("certificate for %s expires in %d days (%s)", name, int(left.Hours()/24), cert.NotAfter.UTC().Format(time.DateOnly)),
+				})
+			}
+		}
+		return conditions
+	}
+}
+
+// firstCertificate parses the first certificate in PEM data, which may also
+// hold a private key as autocert's cache files do
+func firstCertificate(data []byte) *x509.Certificate {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		return cert
+	}
+}
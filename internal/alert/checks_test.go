@@ -0,0 +1,130 @@
+package alert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/sink"
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/event/detection"
+)
+
+// fakeSink is a sink whose health and queue depth tests set
+type fakeSink struct {
+	name  string
+	err   error
+	depth int
+}
+
+func (s *fakeSink) Name() string                    { return s.name }
+func (s *fakeSink) Ping(context.Context) error      { return s.err }
+func (s *fakeSink) Load() (int, time.Duration)      { return s.depth, 0 }
+func (s *fakeSink) Enqueue(event.Event) error       { return nil }
+func (s *fakeSink) Close() error                    { return nil }
+func (s *fakeSink) Start(ctx context.Context) error { return nil }
+
+func TestSinksDown(t *testing.T) {
+	pg := &fakeSink{name: "postgres", err: errors.New("connection refused")}
+	check := SinksDown([]sink.Sink{pg, &fakeSink{name: "log"}}, 5*time.Minute)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if got := check(context.Background(), start); len(got) != 0 {
+		t.Errorf("alerted at once: %+v", got)
+	}
+	got := check(context.Background(), start.Add(5*time.Minute))
+	if len(got) != 1 || got[0].Alert != SinkDown || got[0].Subject != "postgres" {
+		t.Fatalf("after 5 minutes = %+v", got)
+	}
+	// A recovery restarts the clock
+	pg.err = nil
+	check(context.Background(), start.Add(6*time.Minute))
+	pg.err = errors.New("connection refused")
+	if got := check(context.Background(), start.Add(7*time.Minute)); len(got) != 0 {
+		t.Errorf("alerted right after a recovery: %+v", got)
+	}
+}
+
+func TestSinksBacklogged(t *testing.T) {
+	check := SinksBacklogged([]sink.Sink{&fakeSink{name: "kafka", depth: 20000}, &fakeSink{name: "postgres", depth: 10}}, 10000)
+	got := check(context.Background(), time.Now())
+	if len(got) != 1 || got[0].Subject != "kafka" {
+		t.Errorf("conditions = %+v", got)
+	}
+}
+
+func TestBotShare(t *testing.T) {
+	share := NewBotShare(80, 50, 10)
+	bot := event.Event{}
+	bot.Server.Detection = detection.ServerDetectionSignals{Honeypot: true} // scores 100
+	observe := func(bots, people int) {
+		for range bots {
+			share.Observe(bot)
+		}
+		for range people {
+			share.Observe(event.Event{})
+		}
+	}
+
+	observe(6, 4)
+	if got := share.Check(context.Background(), time.Now()); len(got) != 1 || got[0].Alert != BotSpike {
+		t.Errorf("60%% bots = %+v", got)
+	}
+	// Counting starts afresh after each check
+	observe(4, 6)
+	if got := share.Check(context.Background(), time.Now()); len(got) != 0 {
+		t.Errorf("40%% bots = %+v", got)
+	}
+	observe(5, 0)
+	if got := share.Check(context.Background(), time.Now()); len(got) != 0 {
+		t.Errorf("too few events = %+v", got)
+	}
+}
+
+func TestCertsExpiring(t *testing.T) {
+	now := time.Now()
+	certs := map[string][]byte{
+		"soon.example":  testCertPEM(t, now.Add(3*24*time.Hour)),
+		"later.example": testCertPEM(t, now.Add(60*24*time.Hour)),
+		"broken":        []byte("not a certificate"),
+	}
+	check := CertsExpiring(14*24*time.Hour, func(context.Context) map[string][]byte { return certs })
+	got := check(context.Background(), now)
+	if len(got) != 1 || got[0].Alert != CertExpiry || got[0].Subject != "soon.example" {
+		t.Errorf("conditions = %+v", got)
+	}
+}
+
+// testCertPEM returns a self-signed certificate expiring at notAfter,
+// preceded by its key as in autocert's cache
+func testCertPEM(t *testing.T, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+}
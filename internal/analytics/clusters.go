@@ -0,0 +1,174 @@
+// Package analytics provides in-memory aggregates computed over ingested events.
+package analytics
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// maxIPsPerCluster bounds memory per cluster; counts beyond it are reported as a floor
+const maxIPsPerCluster = 10000
+
+// Cluster summarizes traffic sharing one device fingerprint combination
+type Cluster struct {
+	Key               string    `json:"key"`
+	HeaderFingerprint string    `json:"header_fingerprint"`
+	TLSFingerprint    string    `json:"tls_fingerprint,omitempty"`
+	JA4               string    `json:"ja4,omitempty"`
+	Platform          string    `json:"platform,omitempty"`
+	Browser           string    `json:"browser,omitempty"`
+	Events            int64     `json:"events"`
+	UniqueIPs         int       `json:"unique_ips"`
+	Automation        bool      `json:"automation"`
+	SampleUA          string    `json:"sample_ua,omitempty"`
+	FirstSeen         time.Time `json:"first_seen"`
+	LastSeen          time.Time `json:"last_seen"`
+}
+
+type clusterState struct {
+	Cluster
+	ips map[string]struct{}
+}
+
+// ClusterTracker groups events by header fingerprint, TLS fingerprint, JA4
+// and user-agent platform/browser. A single automation farm rotating through many
+// IPs shows up as one cluster with a high unique-IP count.
+type ClusterTracker struct {
+	mu          sync.Mutex
+	clusters    map[string]*clusterState
+	window      time.Duration
+	maxClusters int
+
+	snapshotMu sync.RWMutex
+	snapshot   []Cluster
+	builtAt    time.Time
+}
+
+// NewClusterTracker creates a tracker that forgets clusters idle for longer than window
+func NewClusterTracker(window time.Duration, maxClusters int) *ClusterTracker {
+	return &ClusterTracker{
+		clusters:    make(map[string]*clusterState),
+		window:      window,
+		maxClusters: maxClusters,
+	}
+}
+
+// Observe records an enriched event
+func (t *ClusterTracker) Observe(ev event.Event) {
+	signals := ev.Server.Detection
+	if signals.HeaderFingerprint == "" {
+		return // not enriched server-side (e.g. relayed or synthetic events)
+	}
+	ua := signals.RequestAnalysis.UserAgentAnalysis
+	key := strings.Join([]string{signals.HeaderFingerprint, signals.TLSFingerprint, ua.Platform, ua.Browser}, "|")
+	if signals.JA4 != "" {
+		key += "|" + signals.JA4
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.clusters[key]
+	if !ok {
+		if t.maxClusters > 0 && len(t.clusters) >= t.maxClusters {
+			return // table full until the next job run prunes idle clusters
+		}
+		c = &clusterState{
+			Cluster: Cluster{
+				Key:               key,
+				HeaderFingerprint: signals.HeaderFingerprint,
+				TLSFingerprint:    signals.TLSFingerprint,
+				JA4:               signals.JA4,
+				Platform:          ua.Platform,
+				Browser:           ua.Browser,
+				SampleUA:          ev.Device.UA,
+				FirstSeen:         now,
+			},
+			ips: make(map[string]struct{}),
+		}
+		t.clusters[key] = c
+	}
+
+	c.Events++
+	c.LastSeen = now
+	if ua.ContainsAutomation || len(signals.HeaderAnalysis.AutomationHeaders) > 0 {
+		c.Automation = true
+	}
+	if ev.Server.IP != "" && len(c.ips) < maxIPsPerCluster {
+		c.ips[ev.Server.IP] = struct{}{}
+	}
+}
+
+// Run periodically prunes idle clusters and rebuilds the ranked snapshot
+// until the context is cancelled
+func (t *ClusterTracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	t.Rebuild()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Rebuild()
+		}
+	}
+}
+
+// Rebuild prunes clusters outside the window and recomputes the ranking
+func (t *ClusterTracker) Rebuild() {
+	now := time.Now()
+
+	t.mu.Lock()
+	ranked := make([]Cluster, 0, len(t.clusters))
+	for key, c := range t.clusters {
+		if now.Sub(c.LastSeen) > t.window {
+			delete(t.clusters, key)
+			continue
+		}
+		snapshot := c.Cluster
+		snapshot.UniqueIPs = len(c.ips)
+		ranked = append(ranked, snapshot)
+	}
+	t.mu.Unlock()
+
+	// Many IPs behind one fingerprint is the strongest farm signal, then volume
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].UniqueIPs != ranked[j].UniqueIPs {
+			return ranked[i].UniqueIPs > ranked[j].UniqueIPs
+		}
+		if ranked[i].Events != ranked[j].Events {
+			return ranked[i].Events > ranked[j].Events
+		}
+		return ranked[i].Key < ranked[j].Key
+	})
+
+	t.snapshotMu.Lock()
+	t.snapshot = ranked
+	t.builtAt = now
+	t.snapshotMu.Unlock()
+}
+
+// Top returns up to limit clusters from the last snapshot with at least minIPs unique IPs
+func (t *ClusterTracker) Top(limit, minIPs int) ([]Cluster, time.Time) {
+	t.snapshotMu.RLock()
+	defer t.snapshotMu.RUnlock()
+
+	top := make([]Cluster, 0, limit)
+	for _, c := range t.snapshot {
+		if len(top) >= limit {
+			break
+		}
+		if c.UniqueIPs >= minIPs {
+			top = append(top, c)
+		}
+	}
+	return top, t.builtAt
+}
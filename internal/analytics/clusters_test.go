@@ -0,0 +1,147 @@
+package analytics
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/event/detection"
+)
+
+func clusterEvent(headerFP, tlsFP, ip string, automation bool) event.Event {
+	ev := event.Event{Device: event.DeviceInfo{UA: "Mozilla/5.0 HeadlessChrome"}}
+	ev.Server.IP = ip
+	ev.Server.Detection = detection.ServerDetectionSignals{
+		HeaderFingerprint: headerFP,
+		TLSFingerprint:    tlsFP,
+		RequestAnalysis: detection.RequestAnalysis{
+			UserAgentAnalysis: detection.UAAnalysis{
+				Platform:           "Linux",
+				Browser:            "Chrome",
+				ContainsAutomation: automation,
+			},
+		},
+	}
+	return ev
+}
+
+func TestClusterTracker(t *testing.T) {
+	t.Run("ranks clusters by unique IPs", func(t *testing.T) {
+		tracker := NewClusterTracker(time.Hour, 100)
+
+		// One farm behind 20 IPs, one heavy single-IP visitor
+		for i := 0; i < 20; i++ {
+			tracker.Observe(clusterEvent("farm", "tls-a", "10.0.0."+strconv.Itoa(i), true))
+		}
+		for i := 0; i < 50; i++ {
+			tracker.Observe(clusterEvent("human", "tls-b", "192.168.1.1", false))
+		}
+		tracker.Rebuild()
+
+		top, builtAt := tracker.Top(10, 1)
+		if len(top) != 2 {
+			t.Fatalf("expected 2 clusters, got %d", len(top))
+		}
+		if top[0].HeaderFingerprint != "farm" || top[0].UniqueIPs != 20 || !top[0].Automation {
+			t.Errorf("unexpected top cluster: %+v", top[0])
+		}
+		if top[1].Events != 50 || top[1].UniqueIPs != 1 {
+			t.Errorf("unexpected second cluster: %+v", top[1])
+		}
+		if builtAt.IsZero() {
+			t.Error("expected snapshot timestamp")
+		}
+	})
+
+	t.Run("filters by minimum unique IPs and limit", func(t *testing.T) {
+		tracker := NewClusterTracker(time.Hour, 100)
+		tracker.Observe(clusterEvent("a", "", "10.0.0.1", false))
+		tracker.Observe(clusterEvent("a", "", "10.0.0.2", false))
+		tracker.Observe(clusterEvent("b", "", "10.0.0.3", false))
+		tracker.Observe(clusterEvent("c", "", "10.0.0.4", false))
+		tracker.Rebuild()
+
+		if top, _ := tracker.Top(10, 2); len(top) != 1 || top[0].HeaderFingerprint != "a" {
+			t.Errorf("expected only cluster a, got %+v", top)
+		}
+		if top, _ := tracker.Top(2, 1); len(top) != 2 {
+			t.Errorf("expected limit of 2, got %d", len(top))
+		}
+	})
+
+	t.Run("splits clusters by JA4", func(t *testing.T) {
+		tracker := NewClusterTracker(time.Hour, 100)
+		browser := clusterEvent("same", "tls-a", "10.0.0.1", false)
+		browser.Server.Detection.JA4 = "t13d1516h2_8daaf6152771_e5627efa2ab1"
+		script := clusterEvent("same", "tls-a", "10.0.0.2", false)
+		script.Server.Detection.JA4 = "t13i1811h1_85036bcba153_b26ce05bbdd6"
+		tracker.Observe(browser)
+		tracker.Observe(script)
+		tracker.Rebuild()
+
+		top, _ := tracker.Top(10, 0)
+		if len(top) != 2 || top[0].JA4 == "" || top[0].JA4 == top[1].JA4 {
+			t.Errorf("expected one cluster per JA4, got %+v", top)
+		}
+	})
+
+	t.Run("ignores events without detection signals", func(t *testing.T) {
+		tracker := NewClusterTracker(time.Hour, 100)
+		tracker.Observe(event.Event{})
+		tracker.Rebuild()
+
+		if top, _ := tracker.Top(10, 0); len(top) != 0 {
+			t.Errorf("expected no clusters, got %d", len(top))
+		}
+	})
+
+	t.Run("caps number of clusters", func(t *testing.T) {
+		tracker := NewClusterTracker(time.Hour, 2)
+		for i := 0; i < 5; i++ {
+			tracker.Observe(clusterEvent("fp-"+strconv.Itoa(i), "", "10.0.0.1", false))
+		}
+		tracker.Rebuild()
+
+		if top, _ := tracker.Top(10, 0); len(top) != 2 {
+			t.Errorf("expected 2 clusters, got %d", len(top))
+		}
+	})
+
+	t.Run("prunes idle clusters", func(t *testing.T) {
+		tracker := NewClusterTracker(time.Minute, 100)
+		tracker.Observe(clusterEvent("old", "", "10.0.0.1", false))
+		tracker.clusters["old||Linux|Chrome"].LastSeen = time.Now().Add(-2 * time.Minute)
+		tracker.Rebuild()
+
+		if len(tracker.clusters) != 0 {
+			t.Errorf("expected idle cluster to be pruned, %d remain", len(tracker.clusters))
+		}
+	})
+
+	t.Run("run builds snapshot until cancelled", func(t *testing.T) {
+		tracker := NewClusterTracker(time.Hour, 100)
+		tracker.Observe(clusterEvent("a", "", "10.0.0.1", false))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			tracker.Run(ctx, time.Hour)
+			close(done)
+		}()
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			if top, _ := tracker.Top(10, 0); len(top) == 1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("snapshot was not built")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+		<-done
+	})
+}
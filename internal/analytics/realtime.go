@@ -0,0 +1,163 @@
+package analytics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// realtimeMinutes is how far back the realtime counters reach
+const realtimeMinutes = 60
+
+// maxKeysPerMinute bounds the event types, UTM sources and referrers counted
+// per minute; values beyond it are counted under OtherKey
+const maxKeysPerMinute = 1000
+
+// OtherKey collects the values that didn't fit in a minute's counters
+const OtherKey = "(other)"
+
+// minuteCounts holds one minute of counters
+type minuteCounts struct {
+	minute    int64 // Unix minute the counts belong to
+	events    int64
+	bots      int64
+	types     map[string]int64
+	sources   map[string]int64
+	referrers map[string]int64
+}
+
+// Realtime counts events in a ring of per-minute buckets covering the last
+// hour, for a live view of traffic without querying the sinks. Buckets are
+// reused as the minutes roll over, so memory stays bounded.
+type Realtime struct {
+	mu           sync.Mutex
+	botThreshold int // bot score from which an event counts as a bot's
+	buckets      [realtimeMinutes]minuteCounts
+}
+
+// Count is a value and the events that had it
+type Count struct {
+	Value  string `json:"value"`
+	Events int64  `json:"events"`
+}
+
+// RealtimeStats summarizes the events of the last few minutes
+type RealtimeStats struct {
+	Minutes    int              `json:"minutes"`
+	Events     int64            `json:"events"`
+	BotEvents  int64            `json:"bot_events"`
+	BotPercent float64          `json:"bot_percent"`
+	Types      map[string]int64 `json:"types"`
+	UTMSources []Count          `json:"top_utm_sources"`
+	Referrers  []Count          `json:"top_referrers"`
+}
+
+// NewRealtime creates counters treating events with a bot score of
+// botThreshold or more as bot traffic
+func NewRealtime(botThreshold int) *Realtime {
+	return &Realtime{botThreshold: botThreshold}
+}
+
+// Observe counts an event in the current minute
+func (r *Realtime) Observe(ev event.Event) {
+	r.observeAt(ev, time.Now())
+}
+
+func (r *Realtime) observeAt(ev event.Event, now time.Time) {
+	minute := now.Unix() / 60
+	typ := ev.Type
+	if typ == "" {
+		typ = "unknown"
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b := &r.buckets[minute%realtimeMinutes]
+	if b.minute != minute {
+		*b = minuteCounts{
+			minute:    minute,
+			types:     map[string]int64{},
+			sources:   map[string]int64{},
+			referrers: map[string]int64{},
+		}
+	}
+	b.events++
+	if ev.Server.Detection.BotScore() >= r.botThreshold {
+		b.bots++
+	}
+	increment(b.types, typ)
+	if ev.URL.UTM.Source != "" {
+		increment(b.sources, ev.URL.UTM.Source)
+	}
+	if ev.URL.ReferrerHostname != "" {
+		increment(b.referrers, ev.URL.ReferrerHostname)
+	}
+}
+
+func increment(counts map[string]int64, key string) {
+	if _, ok := counts[key]; !ok && len(counts) >= maxKeysPerMinute {
+		key = OtherKey
+	}
+	counts[key]++
+}
+
+// Stats sums the last minutes, the current one included, keeping the top
+// UTM sources and referrers
+func (r *Realtime) Stats(minutes, top int) RealtimeStats {
+	return r.statsAt(time.Now(), minutes, top)
+}
+
+func (r *Realtime) statsAt(now time.Time, minutes, top int) RealtimeStats {
+	minutes = min(max(minutes, 1), realtimeMinutes)
+	current := now.Unix() / 60
+	stats := RealtimeStats{Minutes: minutes, Types: map[string]int64{}}
+	sources := map[string]int64{}
+	referrers := map[string]int64{}
+
+	r.mu.Lock()
+	for _, b := range r.buckets {
+		if b.minute > current || b.minute <= current-int64(minutes) {
+			continue
+		}
+		stats.Events += b.events
+		stats.BotEvents += b.bots
+		for k, n := range b.types {
+			stats.Types[k] += n
+		}
+		for k, n := range b.sources {
+			sources[k] += n
+		}
+		for k, n := range b.referrers {
+			referrers[k] += n
+		}
+	}
+	r.mu.Unlock()
+
+	if stats.Events > 0 {
+		stats.BotPercent = float64(stats.BotEvents*10000/stats.Events) / 100
+	}
+	stats.UTMSources = topCounts(sources, top)
+	stats.Referrers = topCounts(referrers, top)
+	return stats
+}
+
+// topCounts returns up to limit values with the most events
+func topCounts(counts map[string]int64, limit int) []Count {
+	ranked := make([]Count, 0, len(counts))
+	for value, n := range counts {
+		ranked = append(ranked, Count{Value: value, Events: n})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Events != ranked[j].Events {
+			return ranked[i].Events > ranked[j].Events
+		}
+		return ranked[i].Value < ranked[j].Value
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
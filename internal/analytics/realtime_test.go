@@ -0,0 +1,74 @@
+package analytics
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/event/detection"
+)
+
+func realtimeEvent(typ, source, referrer string, bot bool) event.Event {
+	ev := event.Event{Type: typ}
+	ev.URL.UTM.Source = source
+	ev.URL.ReferrerHostname = referrer
+	ev.Server.Detection = detection.ServerDetectionSignals{Honeypot: bot}
+	return ev
+}
+
+func TestRealtime(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC)
+
+	t.Run("sums the requested minutes", func(t *testing.T) {
+		r := NewRealtime(80)
+		r.observeAt(realtimeEvent("pageview", "google", "www.google.com", false), now)
+		r.observeAt(realtimeEvent("pageview", "google", "", false), now.Add(-3*time.Minute))
+		r.observeAt(realtimeEvent("click", "newsletter", "mail.example", true), now.Add(-10*time.Minute))
+		r.observeAt(realtimeEvent("", "", "", false), now.Add(-30*time.Minute))
+
+		five := r.statsAt(now, 5, 10)
+		if five.Events != 2 || five.BotEvents != 0 || five.Types["pageview"] != 2 || len(five.Types) != 1 {
+			t.Errorf("5 minutes = %+v", five)
+		}
+		if len(five.UTMSources) != 1 || five.UTMSources[0] != (Count{Value: "google", Events: 2}) {
+			t.Errorf("5 minute sources = %v", five.UTMSources)
+		}
+		fifteen := r.statsAt(now, 15, 10)
+		if fifteen.Events != 3 || fifteen.BotEvents != 1 || fifteen.BotPercent != 33.33 || fifteen.Types["click"] != 1 {
+			t.Errorf("15 minutes = %+v", fifteen)
+		}
+		if hour := r.statsAt(now, 60, 10); hour.Events != 4 || hour.Types["unknown"] != 1 || len(hour.Referrers) != 2 {
+			t.Errorf("60 minutes = %+v", hour)
+		}
+	})
+
+	t.Run("forgets minutes older than an hour", func(t *testing.T) {
+		r := NewRealtime(80)
+		r.observeAt(realtimeEvent("pageview", "", "", false), now)
+		later := now.Add(time.Hour)
+		r.observeAt(realtimeEvent("click", "", "", false), later)
+		stats := r.statsAt(later, 60, 10)
+		if stats.Events != 1 || stats.Types["click"] != 1 {
+			t.Errorf("after an hour = %+v", stats)
+		}
+		if stats := r.statsAt(later.Add(2*time.Hour), 60, 10); stats.Events != 0 {
+			t.Errorf("idle hours = %+v", stats)
+		}
+	})
+
+	t.Run("ranks and bounds values", func(t *testing.T) {
+		r := NewRealtime(80)
+		for i := 0; i < maxKeysPerMinute+5; i++ {
+			r.observeAt(realtimeEvent("pageview", "", "ref"+strconv.Itoa(i)+".example", false), now)
+		}
+		for i := 0; i < 3; i++ {
+			r.observeAt(realtimeEvent("pageview", "", "ref7.example", false), now)
+		}
+		want := []Count{{Value: OtherKey, Events: 5}, {Value: "ref7.example", Events: 4}}
+		if stats := r.statsAt(now, 5, 2); !slices.Equal(stats.Referrers, want) {
+			t.Errorf("referrers = %v, want %v", stats.Referrers, want)
+		}
+	})
+}
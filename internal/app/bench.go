@@ -0,0 +1,263 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/bench"
+	httpx "github.com/shortontech/gotrack/internal/http"
+	"github.com/shortontech/gotrack/internal/loadgen"
+	"github.com/shortontech/gotrack/internal/metrics"
+	"github.com/shortontech/gotrack/internal/privacy"
+	"github.com/shortontech/gotrack/internal/serde"
+	"github.com/shortontech/gotrack/internal/sink"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// benchPoolSize is how many distinct sample events stages cycle through
+const benchPoolSize = 1024
+
+// benchCommand implements "gotrack bench", which measures each stage of the
+// ingest path in-process against a null sink and, optionally, real sinks
+func benchCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	events := flags.Int("events", 100000, "Events to measure per stage")
+	sinkNames := flags.String("sinks", "", "Comma-separated OUTPUTS to benchmark besides the null sink, e.g. log,kafka")
+	cpuProfile := flags.String("cpuprofile", "", "Write a CPU profile of the run to this file")
+	memProfile := flags.String("memprofile", "", "Write an allocation profile of the run to this file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *events < 1 {
+		fmt.Fprintln(stderr, "events must be at least 1")
+		return 2
+	}
+
+	cfg, err := config.LoadWithFile()
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer pprof.StopCPUProfile()
+	}
+	if *memProfile != "" {
+		runtime.MemProfileRate = 4096
+	}
+
+	stages, cleanup, err := benchStages(cfg, splitList(*sinkNames))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer cleanup()
+
+	ctx := context.Background()
+	warmup := min(*events/10, 1000)
+	results := make([]bench.Result, 0, len(stages))
+	for _, s := range stages {
+		results = append(results, bench.Measure(ctx, s, *events, warmup))
+	}
+
+	fmt.Fprintf(stdout, "%d events per stage, %s %s/%s, GOMAXPROCS=%d\n\n", *events, runtime.Version(), runtime.GOOS, runtime.GOARCH, runtime.GOMAXPROCS(0))
+	if err := bench.WriteReport(stdout, results); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if *memProfile != "" {
+		f, err := os.Create(*memProfile)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer f.Close()
+		if err := pprof.Lookup("allocs").WriteTo(f, 0); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// benchStages builds the stages in pipeline order: the /collect handler,
+// enrichment alone, each serialization format, the emit pipeline into a
+// null sink, then each requested sink behind the same pipeline
+func benchStages(cfg config.Config, sinkNames []string) ([]bench.Stage, func(), error) {
+	pool := benchEvents()
+	bodies := make([][]byte, len(pool))
+	for i, ev := range pool {
+		bodies[i], _ = json.Marshal(ev)
+	}
+	pick := func(i int) event.Event { return pool[i%len(pool)] }
+
+	tenants, err := initializeTenants(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid tenant configuration: %w", err)
+	}
+	router, err := initializeRouter(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid OUTPUT_RULES: %w", err)
+	}
+	transforms, err := initializeTransforms(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid transforms: %w", err)
+	}
+	regions, err := initializeRegions(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid REGION_POLICY_FILE: %w", err)
+	}
+	ipPolicy, err := privacy.NewPolicy(cfg.IPPrivacyMode, cfg.IPPrivacySinks, cfg.IPHashSecret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid IP privacy configuration: %w", err)
+	}
+	encryptor, err := initializeEncryption(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid field encryption configuration: %w", err)
+	}
+	appMetrics := metrics.InitMetrics()
+	pipeline := func(s sink.Sink) func(context.Context, event.Event) {
+		return createEmitFunc(sink.NewSet([]sink.Sink{s}), appMetrics, ipPolicy, tenants, router, transforms, regions, encryptor, nil)
+	}
+
+	// The handler is measured without HMAC, rate limiting or a validator
+	collectCfg := cfg
+	collectCfg.MaxBodyBytes = max(cfg.MaxBodyBytes, 1<<20)
+	collect := httpx.Env{Cfg: collectCfg, Emit: pipeline(sink.NewNullSink())}
+	req := benchRequest()
+
+	stages := []bench.Stage{
+		{Name: "collect", Run: func(i int) error {
+			r := req.Clone(context.Background())
+			r.Body = io.NopCloser(bytes.NewReader(bodies[i%len(bodies)]))
+			w := newDiscardResponse()
+			collect.Collect(w, r)
+			if w.status != http.StatusAccepted {
+				return fmt.Errorf("status %d", w.status)
+			}
+			return nil
+		}},
+		{Name: "enrich", Run: func(i int) error {
+			ev := pick(i)
+			event.EnrichServerFields(req, &ev, cfg)
+			return nil
+		}},
+	}
+	for _, format := range []string{serde.FormatJSON, serde.FormatAvro, serde.FormatProtobuf} {
+		s, _ := serde.NewWithSchemaID(format, 1)
+		stages = append(stages, bench.Stage{Name: "serialize/" + format, Run: func(i int) error {
+			_, err := s.Serialize(pick(i))
+			return err
+		}})
+	}
+	emitNull := pipeline(sink.NewNullSink())
+	stages = append(stages, bench.Stage{Name: "pipeline/null", Run: func(i int) error {
+		emitNull(context.Background(), pick(i))
+		return nil
+	}})
+
+	sinks, err := initializeSinks(context.Background(), sinkNames)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, s := range sinks {
+		stages = append(stages, sinkStage(s))
+	}
+	return stages, func() { closeSinks(sinks) }, nil
+}
+
+// sinkStage enqueues straight into s. Buffering sinks are flushed at the end
+// so the throughput includes the writes, while the latencies are per enqueue.
+func sinkStage(s sink.Sink) bench.Stage {
+	pool := benchEvents()
+	stage := bench.Stage{Name: "sink/" + s.Name(), Run: func(i int) error {
+		return enqueue(context.Background(), s, pool[i%len(pool)])
+	}}
+	if f, ok := s.(sink.Flusher); ok {
+		stage.Done = func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+			_, err := f.Flush(ctx)
+			return err
+		}
+	}
+	return stage
+}
+
+// benchEvents returns sample events as a browser would send them, before
+// server enrichment
+func benchEvents() []event.Event {
+	gen := loadgen.NewGenerator(loadgen.Profiles["web"], 1)
+	events := make([]event.Event, benchPoolSize)
+	for i := range events {
+		ev := gen.Next()
+		ev.Server = event.ServerMeta{}
+		ev.Props = nil
+		events[i] = ev
+	}
+	return events
+}
+
+// benchRequest is a typical browser request to /collect
+func benchRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/collect", nil)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36")
+	r.Header.Set("Accept", "*/*")
+	r.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	r.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Referer", "https://example.com/pricing")
+	r.RemoteAddr = "203.0.113.42:51234"
+	return r
+}
+
+// discardResponse is a ResponseWriter that keeps only the status, so the
+// collect stage doesn't measure a recorder's buffering
+type discardResponse struct {
+	header http.Header
+	status int
+}
+
+func newDiscardResponse() *discardResponse {
+	return &discardResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (d *discardResponse) Header() http.Header         { return d.header }
+func (d *discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardResponse) WriteHeader(status int)      { d.status = status }
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/shortontech/gotrack/internal/campaign"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// campaignCommand implements "gotrack campaign-url", which shows how GoTrack
+// reads campaign links. It exits 1 when any URL is invalid or has warnings,
+// so link lists can be checked before a campaign ships.
+func campaignCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("campaign-url", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "Print one JSON report per line, as the admin API returns it")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: gotrack campaign-url [-json] URL...\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.LoadWithFile()
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+	if cfg.ReferrerListFile != "" {
+		classifier, err := event.LoadChannelClassifier(cfg.ReferrerListFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "invalid REFERRER_LIST_FILE: %v\n", err)
+			return 1
+		}
+		event.DefaultChannelClassifier = classifier
+	}
+	if cfg.ClickIDFile != "" {
+		registry, err := event.LoadClickIDRegistry(cfg.ClickIDFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "invalid CLICK_ID_FILE: %v\n", err)
+			return 1
+		}
+		event.DefaultClickIDs = registry
+	}
+
+	status := 0
+	enc := json.NewEncoder(stdout)
+	for _, raw := range flags.Args() {
+		report, err := campaign.Inspect(raw)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", raw, err)
+			status = 1
+			continue
+		}
+		if len(report.Warnings) > 0 {
+			status = 1
+		}
+		if *asJSON {
+			_ = enc.Encode(report)
+			continue
+		}
+		printCampaignReport(stdout, report)
+	}
+	return status
+}
+
+func printCampaignReport(w io.Writer, r campaign.Report) {
+	fmt.Fprintln(w, r.URL)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  hostname\t%s\n", r.Hostname)
+	fmt.Fprintf(tw, "  path\t%s\n", r.Path)
+	fmt.Fprintf(tw, "  channel\t%s\n", r.Channel)
+	for _, f := range []struct{ name, value string }{
+		{"utm_source", r.UTM.Source},
+		{"utm_medium", r.UTM.Medium},
+		{"utm_campaign", r.UTM.Campaign},
+		{"utm_term", r.UTM.Term},
+		{"utm_content", r.UTM.Content},
+		{"utm_id", r.UTM.ID},
+		{"utm_campaign_id", r.UTM.CampaignID},
+	} {
+		if f.value != "" {
+			fmt.Fprintf(tw, "  %s\t%s\n", f.name, f.value)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(r.ClickIDs)) {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, r.ClickIDs[name])
+	}
+	_ = tw.Flush()
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(w, "  warning: %s\n", strings.TrimPrefix(warning.Param+": "+warning.Message, ": "))
+	}
+	if len(r.Warnings) == 0 {
+		fmt.Fprintln(w, "  ok")
+	}
+}
@@ -0,0 +1,262 @@
+// Package app implements the gotrack command: the tracking server and the
+// replay, import, export and maintenance subcommands around it.
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+
+	"github.com/shortontech/gotrack/internal/consent"
+	"github.com/shortontech/gotrack/internal/logging"
+	"github.com/shortontech/gotrack/internal/metrics"
+	"github.com/shortontech/gotrack/internal/privacy"
+	"github.com/shortontech/gotrack/internal/sampling"
+	"github.com/shortontech/gotrack/internal/session"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/event/detection"
+)
+
+// Version is the build version "gotrack version" reports. cmd/gotrack
+// sets it from its own version, which -ldflags "-X main.version=..." fills.
+var Version = "dev"
+
+// knownOutputs lists the OUTPUTS values initializeSinks understands
+var knownOutputs = []string{"log", "kafka", "postgres", "relay", "udp", "syslog", "meta_capi", "google_ads", "pubsub", "kinesis", "parquet", "null"}
+
+const usage = `Usage: gotrack <command> [flags]
+
+Commands:
+  serve            Run the tracking server (default)
+  config validate  Check the configuration without starting anything
+  replay           Send events from NDJSON files to the configured sinks
+  import           Backfill historical NDJSON logs into the configured sinks
+  generate         Send generated test events to the configured sinks
+  bench            Measure the ingest path stage by stage
+  campaign-url     Show how campaign links are parsed and flag broken UTM parameters
+  email-link       Print signed email click and open pixel URLs
+  outbound-link    Print /r links that record clicks to other sites
+  export           Write a visitor's stored events as NDJSON or CSV
+  decrypt          Restore encrypted fields in NDJSON or a single value
+  purge            Delete a visitor's stored events from the configured sinks
+  version          Print version information
+
+Configuration is read from the environment and CONFIG_FILE, as for serve.
+Run "gotrack <command> -h" for the flags of a command.
+`
+
+// Run runs the gotrack command line with args, not including the program
+// name, and returns the process exit code
+func Run(args []string, stdout, stderr io.Writer) int {
+	return run(args, stdout, stderr)
+}
+
+// run dispatches to a subcommand and returns the process exit code. Without
+// a command, or when the first argument is a flag, it serves as before
+// subcommands existed so existing deployments keep working.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		return serve(nil)
+	}
+	switch cmd := args[0]; cmd {
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	case "serve":
+		return serve(args[1:])
+	case "config":
+		if len(args) < 2 || args[1] != "validate" {
+			fmt.Fprint(stderr, "Usage: gotrack config validate\n")
+			return 2
+		}
+		return validateCommand(args[2:], stdout, stderr)
+	case "replay":
+		return replayCommand(args[1:], stdout, stderr)
+	case "import":
+		return importCommand(args[1:], stdout, stderr)
+	case "generate":
+		return generateCommand(args[1:], stdout, stderr)
+	case "bench":
+		return benchCommand(args[1:], stdout, stderr)
+	case "campaign-url":
+		return campaignCommand(args[1:], stdout, stderr)
+	case "email-link":
+		return emailLinkCommand(args[1:], stdout, stderr)
+	case "outbound-link":
+		return outboundLinkCommand(args[1:], stdout, stderr)
+	case "export":
+		return exportCommand(args[1:], stdout, stderr)
+	case "decrypt":
+		return decryptCommand(args[1:], stdout, stderr)
+	case "purge":
+		return purgeCommand(args[1:], stdout, stderr)
+	case "version":
+		fmt.Fprintln(stdout, versionString())
+		return 0
+	default:
+		if strings.HasPrefix(cmd, "-") {
+			return serve(args)
+		}
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", cmd, usage)
+		return 2
+	}
+}
+
+// versionString reports the build version, falling back to module and VCS
+// information when the binary was built without -ldflags
+func versionString() string {
+	v, revision := Version, ""
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+				revision = s.Value[:12]
+			}
+		}
+	}
+	out := "gotrack " + v
+	if revision != "" {
+		out += " (" + revision + ")"
+	}
+	return fmt.Sprintf("%s %s %s/%s", out, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// validateCommand implements "gotrack config validate"
+func validateCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadWithFile()
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+	if err := validateAll(cfg); err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(stderr, "error: %s\n", line)
+		}
+		return 1
+	}
+	fmt.Fprintln(stdout, "configuration is valid")
+	return 0
+}
+
+// validateAll runs every check serve makes at startup that doesn't connect
+// to a sink or the shared store, and reports all problems rather than the
+// first
+func validateAll(cfg config.Config) error {
+	var errs []error
+	check := func(prefix string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+		}
+	}
+
+	if err := validateRequired(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	_, err := logging.ParseLevel(cfg.LogLevel)
+	check("invalid LOG_LEVEL", err)
+	check("invalid metrics configuration", metrics.Config{
+		Enabled:     cfg.MetricsEnabled,
+		Addr:        cfg.MetricsAddr,
+		TLSCert:     cfg.MetricsTLSCert,
+		TLSKey:      cfg.MetricsTLSKey,
+		ClientCA:    cfg.MetricsClientCA,
+		RequireTLS:  cfg.MetricsRequireTLS,
+		RequireAuth: cfg.MetricsRequireAuth,
+		AuthToken:   cfg.MetricsAuthToken,
+	}.Validate())
+	check("invalid OUTPUTS", validateOutputs(cfg.Outputs))
+
+	tenants, err := initializeTenants(cfg)
+	check("invalid tenant configuration", err)
+	if err == nil {
+		_, err = initializeAPIKeys(cfg, tenants)
+		check("invalid API key configuration", err)
+	}
+	_, err = initializeValidator(cfg)
+	check("invalid validation configuration", err)
+	_, err = initializeRouter(cfg)
+	check("invalid OUTPUT_RULES", err)
+	_, err = initializeTransforms(cfg)
+	check("invalid transforms", err)
+	_, err = initializeRegions(cfg)
+	check("invalid REGION_POLICY_FILE", err)
+	_, err = initializeExperiments(cfg)
+	check("invalid EXPERIMENTS_FILE", err)
+	_, err = privacy.NewPolicy(cfg.IPPrivacyMode, cfg.IPPrivacySinks, cfg.IPHashSecret)
+	check("invalid IP privacy configuration", err)
+	_, err = initializeEncryption(cfg)
+	check("invalid field encryption configuration", err)
+	_, err = storeBackend(cfg)
+	check("invalid shared state configuration", err)
+	if cfg.IPReputationFile != "" {
+		_, err = detection.LoadIPClassifier(cfg.IPReputationFile)
+		check("invalid IP_REPUTATION_FILE", err)
+	}
+	if cfg.ReferrerListFile != "" {
+		_, err = event.LoadChannelClassifier(cfg.ReferrerListFile)
+		check("invalid REFERRER_LIST_FILE", err)
+	}
+	if cfg.ClickIDFile != "" {
+		_, err = event.LoadClickIDRegistry(cfg.ClickIDFile)
+		check("invalid CLICK_ID_FILE", err)
+	}
+	_, err = initializeInjector(cfg)
+	check("invalid injection configuration", err)
+	if cfg.ProxyCache != "" {
+		check("invalid proxy cache configuration", validateProxyCache(cfg))
+	}
+	_, err = initializeOrderDedup(cfg, nil)
+	check("invalid conversion configuration", err)
+	_, err = consent.NewPolicy(cfg.TCFAction, cfg.TCFPurposes, cfg.TCFVendorID, cfg.TCFRequired)
+	check("invalid TCF configuration", err)
+	_, err = initializeReplayGuard(cfg, nil)
+	check("invalid HMAC replay configuration", err)
+	if cfg.SessionCookies || cfg.ClickIDCookies {
+		_, err = session.ParseSameSite(cfg.SessionSameSite)
+		check("invalid session configuration", err)
+	}
+	_, err = sampling.ParseRates(cfg.SamplingRates)
+	check("invalid SAMPLING_RATES", err)
+	if cfg.DedupEnabled {
+		_, err = initializeDedup(cfg, nil)
+		check("invalid dedup configuration", err)
+	}
+	_, err = initializeACME(cfg)
+	check("invalid ACME configuration", err)
+	_, err = initializeListeners(cfg)
+	check("invalid LISTENERS", err)
+
+	return errors.Join(errs...)
+}
+
+// validateOutputs rejects an empty or unknown OUTPUTS list, which serve
+// only logs and skips
+func validateOutputs(outputs []string) error {
+	if len(outputs) == 0 {
+		return errors.New("no outputs configured")
+	}
+	var unknown []string
+	for _, output := range outputs {
+		if !slices.Contains(knownOutputs, output) {
+			unknown = append(unknown, output)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown output type %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/email"
+	"github.com/shortontech/gotrack/internal/fieldcrypt"
+	"github.com/shortontech/gotrack/internal/outbound"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+// TestRun tests subcommand dispatch for commands that don't start anything
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{"help", []string{"help"}, 0, "Usage: gotrack <command>", ""},
+		{"help flag", []string{"-h"}, 0, "config validate", ""},
+		{"version", []string{"version"}, 0, "gotrack ", ""},
+		{"unknown command", []string{"bogus"}, 2, "", `unknown command "bogus"`},
+		{"config without validate", []string{"config"}, 2, "", "gotrack config validate"},
+		{"replay without files", []string{"replay"}, 2, "", "Usage: gotrack replay"},
+		{"import without paths", []string{"import"}, 2, "", "Usage: gotrack import"},
+		{"purge without visitor", []string{"purge"}, 2, "", "Usage: gotrack purge"},
+		{"export without subject", []string{"export"}, 2, "", "Usage: gotrack export"},
+		{"export with unknown format", []string{"export", "-visitor-id", "v1", "-format", "xlsx"}, 2, "", `unknown export format "xlsx"`},
+		{"generate with negative count", []string{"generate", "-count", "-1"}, 2, "", "must not be negative"},
+		{"generate with unknown profile", []string{"generate", "-profile", "bogus"}, 2, "", `unknown profile "bogus"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run(tt.args, &stdout, &stderr); code != tt.code {
+				t.Errorf("exit code = %d, want %d", code, tt.code)
+			}
+			if !strings.Contains(stdout.String(), tt.stdout) {
+				t.Errorf("stdout = %q, want it to contain %q", stdout.String(), tt.stdout)
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tt.stderr)
+			}
+		})
+	}
+}
+
+// TestValidateCommand tests that config validate reports every problem
+func TestValidateCommand(t *testing.T) {
+	t.Setenv("FORWARD_DESTINATION", "http://localhost:3000")
+	t.Setenv("HMAC_SECRET", "secret")
+
+	t.Run("valid", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		if code := run([]string{"config", "validate"}, &stdout, &stderr); code != 0 {
+			t.Fatalf("exit code = %d, stderr %q", code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), "configuration is valid") {
+			t.Errorf("stdout = %q", stdout.String())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv("OUTPUTS", "log,carrier_pigeon")
+		t.Setenv("DNT_ACTION", "ignore")
+		t.Setenv("SAMPLING_RATES", "pageview=2")
+		var stdout, stderr bytes.Buffer
+		if code := run([]string{"config", "validate"}, &stdout, &stderr); code != 1 {
+			t.Fatalf("exit code = %d, want 1", code)
+		}
+		for _, want := range []string{"carrier_pigeon", "DNT_ACTION", "SAMPLING_RATES"} {
+			if !strings.Contains(stderr.String(), want) {
+				t.Errorf("stderr = %q, want it to mention %s", stderr.String(), want)
+			}
+		}
+	})
+}
+
+// TestValidateAll tests the checks that need no environment
+func TestValidateAll(t *testing.T) {
+	err := validateAll(config.Config{})
+	if err == nil {
+		t.Fatal("expected errors for an empty configuration")
+	}
+	for _, want := range []string{"FORWARD_DESTINATION", "HMAC_SECRET", "no outputs configured"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+// TestDecryptCommand tests restoring encrypted fields in files and values
+func TestDecryptCommand(t *testing.T) {
+	keys, err := fieldcrypt.ParseKeys("k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FIELD_ENCRYPTION_KEYS", "k1:"+base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	sealed := keys.Encrypt("203.0.113.7")
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	input := `{"event_id":"a","server":{"ip_hash":"` + sealed + `"}}` + "\n\nnot json\n"
+	if err := os.WriteFile(path, []byte(input), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"decrypt", path}, &stdout, &stderr); code != 1 {
+		t.Errorf("exit code = %d, want 1 for the undecodable line", code)
+	}
+	if stdout.String() != `{"event_id":"a","server":{"ip_hash":"203.0.113.7"}}`+"\n" {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "events.ndjson:3") {
+		t.Errorf("stderr = %q, want the bad line reported", stderr.String())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"decrypt", "-value", sealed}, &stdout, &stderr); code != 0 || stdout.String() != "203.0.113.7\n" {
+		t.Errorf("decrypt -value: code %d, stdout %q", code, stdout.String())
+	}
+
+	t.Setenv("FIELD_ENCRYPTION_KEYS", "")
+	if code := run([]string{"decrypt", "-value", sealed}, &stdout, &stderr); code != 1 {
+		t.Errorf("exit code = %d without keys, want 1", code)
+	}
+}
+
+// TestReplayEvents tests decoding NDJSON input line by line
+func TestReplayEvents(t *testing.T) {
+	input := `{"event_id":"a","type":"pageview"}
+
+not json
+{"event_id":"b"}
+{"event_id":"c","type":"click"}
+`
+	var got []event.Event
+	sent, errs := replayEvents(strings.NewReader(input), "events.ndjson", 1<<20, func(ev event.Event) {
+		got = append(got, ev)
+	})
+	if sent != 2 || len(got) != 2 || got[0].EventID != "a" || got[1].EventID != "c" {
+		t.Errorf("sent %d events %+v, want a and c", sent, got)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("errors = %v, want 2", errs)
+	}
+	if !strings.HasPrefix(errs[0].Error(), "events.ndjson:3:") || !strings.HasPrefix(errs[1].Error(), "events.ndjson:4:") {
+		t.Errorf("errors = %v, want line numbers 3 and 4", errs)
+	}
+
+	// Lines longer than the body limit fail the rest of the input
+	_, errs = replayEvents(strings.NewReader(strings.Repeat("x", 100)), "long", 10, func(event.Event) {})
+	if len(errs) != 1 {
+		t.Errorf("errors = %v, want a too-long line error", errs)
+	}
+}
+
+// TestImportCommand tests backfilling a directory of plain and gzipped logs
+func TestImportCommand(t *testing.T) {
+	t.Setenv("FORWARD_DESTINATION", "http://localhost:3000")
+	t.Setenv("OUTPUTS", "null")
+	t.Setenv("VALIDATION_POLICY", "reject")
+	t.Setenv("VALIDATION_EVENT_TYPES", "pageview,click")
+
+	dir := t.TempDir()
+	old := `{"event_id":"0190a4c2-6f1e-7b3a-9c1d-2e5f8a7b6c4d","type":"pageview","ts":"2020-01-02T03:04:05Z","received_at":"2020-01-02T03:04:06Z"}
+{"type":"purchase"}
+`
+	if err := os.WriteFile(filepath.Join(dir, "ndjson-2020-01-02T00-00-00.000.log"), []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(`{"type":"click"}` + "\nnot json\n"))
+	zw.Close()
+	if err := os.WriteFile(filepath.Join(dir, "ndjson-2020-01-03T00-00-00.000.log.gz"), gz.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"import", "-progress", "0", "-dry-run", dir}, {"import", "-progress", "0", dir}} {
+		var stdout, stderr bytes.Buffer
+		if code := run(args, &stdout, &stderr); code != 1 {
+			t.Errorf("%v exit code = %d, want 1 for the undecodable line", args, code)
+		}
+		out := stdout.String()
+		for _, want := range []string{"2020-01-02T00-00-00.000.log: ", "1 events, 1 rejected, 0 errors", "2020-01-03T00-00-00.000.log.gz: ", "1 events, 0 rejected, 1 errors", "total: "} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%v stdout = %q, want it to contain %q", args, out, want)
+			}
+		}
+		if !strings.Contains(stderr.String(), "log.gz:2:") {
+			t.Errorf("stderr = %q, want the line of the bad event", stderr.String())
+		}
+	}
+}
+
+// TestPrepareImport tests validation and field defaults for imported events
+func TestPrepareImport(t *testing.T) {
+	validator, err := importValidator(config.Config{ValidationPolicy: "reject", ValidationMaxAgeHours: 1, ValidationMaxSkewMinutes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev := event.Event{Type: "pageview", TS: "2020-01-02T03:04:05Z", ReceivedAt: "2020-01-02T03:04:06Z"}
+	if !prepareImport(&ev, validator, config.Config{RecordReceivedAt: true}) {
+		t.Fatal("an old event should pass validation on import")
+	}
+	if ev.EventID == "" || ev.TS != "2020-01-02T03:04:05Z" || ev.ReceivedAt != "2020-01-02T03:04:06Z" {
+		t.Errorf("prepared event = %+v, want an event_id and the original ts and received_at", ev)
+	}
+
+	future := event.Event{Type: "pageview", TS: time.Now().Add(time.Hour).Format(time.RFC3339)}
+	if prepareImport(&future, validator, config.Config{}) {
+		t.Error("an event from the future should still be rejected")
+	}
+}
+
+// TestBenchCommand tests that every stage is measured without errors
+func TestBenchCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"bench", "-events", "50", "-sinks", "null"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, stderr %q", code, stderr.String())
+	}
+	out := stdout.String()
+	for _, stage := range []string{"collect", "enrich", "serialize/json", "serialize/avro", "serialize/protobuf", "pipeline/null", "sink/null"} {
+		if !strings.Contains(out, stage) {
+			t.Errorf("report missing stage %s:\n%s", stage, out)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n")[3:] {
+		if fields := strings.Fields(line); fields[len(fields)-1] != "0" {
+			t.Errorf("stage reported errors: %s", line)
+		}
+	}
+}
+
+// TestCampaignCommand tests the report and the exit code on broken links
+func TestCampaignCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	good := "https://shop.example/sale?utm_source=google&utm_medium=cpc&utm_campaign=spring&gclid=abc"
+	if code := run([]string{"campaign-url", good}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, stderr %q, stdout %q", code, stderr.String(), stdout.String())
+	}
+	for _, want := range []string{`channel +paid_search`, `utm_campaign +spring`, `gclid +abc`, `\n  ok\n`} {
+		if !regexp.MustCompile(want).MatchString(stdout.String()) {
+			t.Errorf("report missing %q:\n%s", want, stdout.String())
+		}
+	}
+
+	stdout.Reset()
+	if code := run([]string{"campaign-url", "-json", "https://shop.example/?utm_soruce=google"}, &stdout, &stderr); code != 1 {
+		t.Fatalf("exit code = %d, want 1 for a link with warnings", code)
+	}
+	var report struct {
+		Warnings []struct{ Param string } `json:"warnings"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil || len(report.Warnings) == 0 || report.Warnings[0].Param != "utm_soruce" {
+		t.Errorf("report = %s (err %v), want a utm_soruce warning", stdout.String(), err)
+	}
+
+	if code := run([]string{"campaign-url", "/sale?utm_source=google"}, &stdout, &stderr); code != 1 {
+		t.Errorf("exit code = %d, want 1 for a relative URL", code)
+	}
+}
+
+// TestEmailLinkCommand tests that printed links pass the server's check
+func TestEmailLinkCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	args := []string{"email-link", "-base", "https://track.example.com", "-campaign", "spring", "-open", "https://shop.example/sale"}
+	t.Setenv("EMAIL_LINK_SECRET", "")
+	if code := run(args, &stdout, &stderr); code != 1 {
+		t.Errorf("exit code without EMAIL_LINK_SECRET = %d, want 1", code)
+	}
+
+	t.Setenv("EMAIL_LINK_SECRET", "email-secret")
+	stdout.Reset()
+	if code := run(args, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, stderr %q", code, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("output = %q, want the open and click URLs", stdout.String())
+	}
+	for i, path := range []string{email.OpenPath, email.ClickPath} {
+		u, err := url.Parse(lines[i])
+		if err != nil || u.Path != path {
+			t.Fatalf("line %d = %q, want %s", i, lines[i], path)
+		}
+		if _, err := email.Parse([]byte("email-secret"), path, u.Query()); err != nil {
+			t.Errorf("%s link rejected: %v", path, err)
+		}
+	}
+
+	if code := run([]string{"email-link", "-base", "https://track.example.com", "javascript:alert(1)"}, &stdout, &stderr); code != 1 {
+		t.Errorf("exit code = %d, want 1 for an invalid destination", code)
+	}
+}
+
+// TestOutboundLinkCommand tests signed and allowlisted links
+func TestOutboundLinkCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	t.Setenv("OUTBOUND_LINK_SECRET", "")
+	t.Setenv("OUTBOUND_ALLOWED_HOSTS", "partner.example")
+	args := []string{"outbound-link", "-base", "https://track.example.com", "https://partner.example/a", "https://other.example/"}
+	if code := run(args, &stdout, &stderr); code != 1 {
+		t.Errorf("exit code = %d, want 1 for a host off the allowlist", code)
+	}
+	if got := strings.TrimSpace(stdout.String()); got != "https://track.example.com/r?u=https%3A%2F%2Fpartner.example%2Fa" {
+		t.Errorf("output = %q", got)
+	}
+
+	t.Setenv("OUTBOUND_LINK_SECRET", "secret")
+	stdout.Reset()
+	if code := run(args, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, stderr %q", code, stderr.String())
+	}
+	policy, _ := outbound.NewPolicy("secret", nil)
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		u, _ := url.Parse(line)
+		if _, err := policy.Destination(u.Query()); err != nil {
+			t.Errorf("%s rejected: %v", line, err)
+		}
+	}
+}
+
+// TestThrottle tests spacing calls by the rate
+func TestThrottle(t *testing.T) {
+	wait := throttle(100)
+	start := time.Now()
+	for range 3 {
+		wait()
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("three calls at 100/s took %v, want at least 20ms", elapsed)
+	}
+
+	unlimited := throttle(0)
+	start = time.Now()
+	for range 1000 {
+		unlimited()
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("unlimited throttle took %v", elapsed)
+	}
+}
+
+// purgeSink is a sink that deletes a fixed number of events, or fails
+type purgeSink struct {
+	mockSink
+	deleted int64
+	err     error
+	visitor string
+}
+
+func (s *purgeSink) PurgeVisitor(ctx context.Context, visitorID string) (int64, error) {
+	s.visitor = visitorID
+	return s.deleted, s.err
+}
+
+// TestPurgeVisitor tests the per-sink report and the outcome flags
+func TestPurgeVisitor(t *testing.T) {
+	pg := &purgeSink{mockSink: mockSink{name: "postgres"}, deleted: 12}
+	var stdout, stderr bytes.Buffer
+	purged, failed := purgeVisitor(context.Background(), []sink.Sink{pg, &mockSink{name: "log"}}, "v-1", &stdout, &stderr)
+	if !purged || failed || pg.visitor != "v-1" {
+		t.Errorf("purged = %v, failed = %v, visitor %q", purged, failed, pg.visitor)
+	}
+	if !strings.Contains(stdout.String(), "postgres: deleted 12 events") {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "log: does not support deletion") {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+
+	broken := &purgeSink{mockSink: mockSink{name: "postgres"}, deleted: 3, err: errors.New("connection reset")}
+	if _, failed := purgeVisitor(context.Background(), []sink.Sink{broken}, "v-1", &stdout, &stderr); !failed {
+		t.Error("failed = false for a sink that returned an error")
+	}
+
+	stderr.Reset()
+	if purged, _ := purgeVisitor(context.Background(), []sink.Sink{&mockSink{name: "log"}}, "v-1", &stdout, &stderr); purged ||
+		!strings.Contains(stderr.String(), "no configured sink supports deletion") {
+		t.Errorf("purged = %v, stderr %q", purged, stderr.String())
+	}
+}
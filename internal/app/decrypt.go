@@ -0,0 +1,109 @@
+package app
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/shortontech/gotrack/internal/fieldcrypt"
+	"github.com/shortontech/gotrack/pkg/config"
+)
+
+// decryptCommand implements "gotrack decrypt", which restores the fields
+// FIELD_ENCRYPTION_FIELDS encrypted, in NDJSON such as log sink files and
+// exports, or in a single value
+func decryptCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	value := flags.String("value", "", "Decrypt this one value instead of NDJSON")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: gotrack decrypt [-value enc:v1:...] [file.ndjson...] (stdin without files)\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *value != "" && flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.LoadWithFile()
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+	if cfg.FieldEncryptionKeys == "" && cfg.FieldEncryptionKeysFile == "" {
+		fmt.Fprintln(stderr, "decrypt needs FIELD_ENCRYPTION_KEYS or FIELD_ENCRYPTION_KEYS_FILE")
+		return 1
+	}
+	keys, err := fieldcrypt.LoadKeys(cfg.FieldEncryptionKeys, cfg.FieldEncryptionKeysFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid field encryption keys: %v\n", err)
+		return 1
+	}
+
+	if *value != "" {
+		plain, err := keys.Decrypt(*value)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintln(stdout, plain)
+		return 0
+	}
+
+	names := flags.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	failed := false
+	for _, name := range names {
+		for _, err := range decryptFile(name, stdout, keys, cfg.MaxBodyBytes) {
+			fmt.Fprintln(stderr, err)
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// decryptFile writes each line of the named file, or stdin for "-", with its
+// encrypted values restored. Lines that don't decode or decrypt are
+// reported and skipped.
+func decryptFile(name string, w io.Writer, keys *fieldcrypt.Keyring, maxLine int64) []error {
+	r := io.Reader(os.Stdin)
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return []error{err}
+		}
+		defer f.Close()
+		r = f
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), int(maxLine))
+	var errs []error
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		out, err := keys.DecryptJSON(scanner.Bytes())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", name, line, err))
+			continue
+		}
+		if _, err := w.Write(append(out, '\n')); err != nil {
+			return append(errs, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+	return errs
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/shortontech/gotrack/internal/email"
+	"github.com/shortontech/gotrack/pkg/config"
+)
+
+// emailLinkCommand implements "gotrack email-link", which prints the signed
+// click URL of each destination, and the open pixel URL with -open, for
+// pasting into an email template
+func emailLinkCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("email-link", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	base := flags.String("base", "", "Tracking host the links point at, such as https://track.example.com (required)")
+	var link email.Link
+	flags.StringVar(&link.Campaign, "campaign", "", "Campaign ID")
+	flags.StringVar(&link.Message, "message", "", "Message ID")
+	flags.StringVar(&link.Recipient, "recipient", "", "Recipient ID or the ESP's merge tag, such as {{contact.id}}")
+	flags.StringVar(&link.WriteKey, "write-key", "", "Tenant write key in multi-tenant mode")
+	open := flags.Bool("open", false, "Also print the open pixel URL")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: gotrack email-link -base URL [-campaign ID] [-message ID] [-recipient ID] [-open] [DESTINATION...]\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *base == "" || (flags.NArg() == 0 && !*open) {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.LoadWithFile()
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+	if cfg.EmailLinkSecret == "" {
+		fmt.Fprintln(stderr, "EMAIL_LINK_SECRET is not set")
+		return 1
+	}
+	secret := []byte(cfg.EmailLinkSecret)
+
+	if *open {
+		fmt.Fprintln(stdout, email.OpenURL(*base, secret, link))
+	}
+	status := 0
+	for _, dest := range flags.Args() {
+		link.URL = dest
+		signed, err := email.ClickURL(*base, secret, link)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", dest, err)
+			status = 1
+			continue
+		}
+		fmt.Fprintln(stdout, signed)
+	}
+	return status
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/shortontech/gotrack/internal/export"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+// exportCommand implements "gotrack export", which writes every stored event
+// of a visitor or IP as NDJSON or CSV, to answer data subject access requests
+func exportCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	visitorID := flags.String("visitor-id", "", "Visitor whose events are exported")
+	ip := flags.String("ip", "", "server.ip_hash as stored: raw, truncated or hashed by IP_PRIVACY_MODE")
+	formatName := flags.String("format", export.FormatNDJSON, "Output format: ndjson or csv")
+	output := flags.String("o", "", "Write to this file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: gotrack export (-visitor-id ID | -ip IP) [-format ndjson|csv] [-o file]\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	q := sink.Query{VisitorID: *visitorID, IP: *ip}
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if !export.Subject(q) || flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.LoadWithFile()
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+	sinks, err := initializeSinks(context.Background(), cfg.Outputs)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer closeSinks(sinks)
+	var src sink.Querier
+	for _, s := range sinks {
+		if querier, ok := s.(sink.Querier); ok {
+			src = querier
+			break
+		}
+	}
+	if src == nil {
+		fmt.Fprintln(stderr, "exports require a sink that stores events, such as postgres")
+		return 1
+	}
+
+	w := stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	n, err := export.Write(context.Background(), src, q, format, w)
+	if err != nil {
+		fmt.Fprintf(stderr, "export failed after %d events: %v\n", n, err)
+		return 1
+	}
+	fmt.Fprintf(stderr, "exported %d events\n", n)
+	return 0
+}
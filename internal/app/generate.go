@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/loadgen"
+)
+
+// generateCommand implements "gotrack generate", which sends synthetic
+// traffic from a load profile to the sinks
+func generateCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	count := flags.Int("count", 5, "Number of events to send (0 for no limit; unlimited by default with -duration)")
+	rate := flags.Float64("rate", 5, "Maximum events per second (0 for no limit)")
+	duration := flags.Duration("duration", 0, "How long to run, e.g. 10m (0 for no limit)")
+	concurrency := flags.Int("concurrency", 1, "Workers sending events in parallel")
+	profileName := flags.String("profile", "web", "Built-in profile ("+strings.Join(loadgen.ProfileNames(), ", ")+") or path to a JSON profile")
+	seed := flags.Uint64("seed", 1, "Seed for visitor attributes")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *count < 0 || *rate < 0 || *duration < 0 || *concurrency < 1 {
+		fmt.Fprintln(stderr, "count, rate and duration must not be negative and concurrency must be at least 1")
+		return 2
+	}
+	countSet := false
+	flags.Visit(func(f *flag.Flag) { countSet = countSet || f.Name == "count" })
+	if *duration > 0 && !countSet {
+		*count = 0
+	}
+	profile, err := loadgen.LookupProfile(*profileName)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	_, emit, shutdown, err := startPipeline()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer shutdown()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stats := loadgen.Run(ctx, profile, loadgen.Config{
+		Rate:        *rate,
+		Count:       *count,
+		Duration:    *duration,
+		Concurrency: *concurrency,
+		Seed:        *seed,
+	}, emit)
+
+	fmt.Fprintf(stdout, "generated %d events in %s (%.1f/s)\n", stats.Sent, stats.Elapsed.Round(time.Millisecond), stats.Rate())
+	types := make([]string, 0, len(stats.ByType))
+	for typ := range stats.ByType {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		fmt.Fprintf(stdout, "  %-16s %d\n", typ, stats.ByType[typ])
+	}
+	return 0
+}
@@ -0,0 +1,246 @@
+package app
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/validation"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// importCommand implements "gotrack import", which backfills historical
+// NDJSON logs, plain or gzipped, into the configured sinks. Unlike replay it
+// validates events against the VALIDATION_* rules and fills in missing
+// server fields first, since old logs may predate both.
+func importCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	rate := flags.Float64("rate", 0, "Maximum events per second (0 for no limit)")
+	progress := flags.Duration("progress", 10*time.Second, "How often to report progress (0 to disable)")
+	dryRun := flags.Bool("dry-run", false, "Validate events without sending them to the sinks")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: gotrack import [-rate N] [-progress D] [-dry-run] path... (files, gzipped files, directories; - reads stdin)\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || *rate < 0 || *progress < 0 {
+		flags.Usage()
+		return 2
+	}
+	files, err := importFiles(flags.Args())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	var cfg config.Config
+	emit := func(context.Context, event.Event) {}
+	if *dryRun {
+		if cfg, err = config.LoadWithFile(); err != nil {
+			fmt.Fprintf(stderr, "failed to load configuration: %v\n", err)
+			return 1
+		}
+	} else {
+		var shutdown func()
+		if cfg, emit, shutdown, err = startPipeline(); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer shutdown()
+	}
+	validator, err := importValidator(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid validation configuration: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	var stats importStats
+	if *progress > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go stats.report(stderr, *progress, done)
+	}
+
+	verb := "imported"
+	wait := throttle(*rate)
+	if *dryRun {
+		verb, wait = "validated", func() {}
+	}
+	failed := false
+	for _, name := range files {
+		before := stats.snapshot()
+		_, errs := importFile(ctx, name, cfg.MaxBodyBytes, func(ev event.Event) {
+			if !prepareImport(&ev, validator, cfg) {
+				stats.rejected.Add(1)
+				return
+			}
+			wait()
+			emit(ctx, ev)
+			stats.imported.Add(1)
+		})
+		stats.errors.Add(int64(len(errs)))
+		for _, err := range errs {
+			fmt.Fprintln(stderr, err)
+		}
+		failed = failed || len(errs) > 0
+		after := stats.snapshot()
+		fmt.Fprintf(stdout, "%s: %s %d events, %d rejected, %d errors\n",
+			name, verb, after.imported-before.imported, after.rejected-before.rejected, len(errs))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	total := stats.snapshot()
+	fmt.Fprintf(stdout, "total: %s %d events, %d rejected, %d errors in %s\n",
+		verb, total.imported, total.rejected, total.errors, time.Since(start).Round(time.Millisecond))
+	if failed || ctx.Err() != nil {
+		return 1
+	}
+	return 0
+}
+
+// importFiles expands directories into the files they contain, in name
+// order. Rotated log files are stamped with their rotation time, so that is
+// the order they were written in.
+func importFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		if path == "-" {
+			files = append(files, path)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && entry.Name()[0] != '.' {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// importFile decodes the named file, or stdin for "-", gunzipping it when it
+// starts with the gzip magic number. Reading stops when ctx is cancelled.
+func importFile(ctx context.Context, name string, maxLine int64, emit func(event.Event)) (int, []error) {
+	r := io.Reader(os.Stdin)
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return 0, []error{err}
+		}
+		defer f.Close()
+		r = f
+	}
+
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return 0, []error{fmt.Errorf("%s: %w", name, err)}
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+	return replayEvents(ctxReader{ctx, r}, name, maxLine, emit)
+}
+
+// ctxReader fails reads once its context is cancelled
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// importValidator builds the validator for imported events. It applies the
+// VALIDATION_* rules except VALIDATION_MAX_AGE_HOURS, since backfilled events
+// are old by design. VALIDATION_POLICY=off disables validation.
+func importValidator(cfg config.Config) (*validation.Validator, error) {
+	cfg.ValidationMaxAgeHours = 0
+	return initializeValidator(cfg)
+}
+
+// prepareImport validates ev and fills in the fields enrichment guarantees,
+// reporting false when ev is rejected. The original received_at is kept so
+// backfilled events still show when they first arrived.
+func prepareImport(ev *event.Event, validator *validation.Validator, cfg config.Config) bool {
+	if validator != nil && validator.Check(0, ev).Rejected() {
+		return false
+	}
+	receivedAt := ev.ReceivedAt
+	event.EnsureEventFields(ev, cfg)
+	if receivedAt != "" {
+		ev.ReceivedAt = receivedAt
+	}
+	return true
+}
+
+// importStats counts import progress; report reads it concurrently
+type importStats struct {
+	imported atomic.Int64
+	rejected atomic.Int64
+	errors   atomic.Int64
+}
+
+type importCounts struct {
+	imported, rejected, errors int64
+}
+
+func (s *importStats) snapshot() importCounts {
+	return importCounts{s.imported.Load(), s.rejected.Load(), s.errors.Load()}
+}
+
+// report writes a progress line every interval until done is closed
+func (s *importStats) report(w io.Writer, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := s.snapshot()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			now := s.snapshot()
+			perSec := float64(now.imported-last.imported) / interval.Seconds()
+			fmt.Fprintf(w, "import: %d events (%.0f/s), %d rejected, %d errors\n",
+				now.imported, perSec, now.rejected, now.errors)
+			last = now
+		}
+	}
+}
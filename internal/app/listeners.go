@@ -0,0 +1,130 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	httpx "github.com/shortontech/gotrack/internal/http"
+	"github.com/shortontech/gotrack/pkg/config"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// listener is an address the server accepts requests on and the routes it
+// serves there
+type listener struct {
+	Addr   string
+	TLS    bool
+	Routes httpx.RouteSet
+}
+
+// describeRoutes is the log suffix naming the routes of a listener that
+// doesn't serve them all
+func (l listener) describeRoutes() string {
+	if l.Routes == httpx.RoutesAll {
+		return ""
+	}
+	return ", " + l.Routes.String() + " routes"
+}
+
+// initializeListeners parses LISTENERS. Without it SERVER_ADDR serves every
+// route, over HTTPS when ENABLE_HTTPS or ACME_DOMAINS is set.
+func initializeListeners(cfg config.Config) ([]listener, error) {
+	if len(cfg.Listeners) == 0 {
+		return []listener{{
+			Addr:   cfg.ServerAddr,
+			TLS:    cfg.EnableHTTPS || len(cfg.ACMEDomains) > 0,
+			Routes: httpx.RoutesAll,
+		}}, nil
+	}
+	if cfg.EnableHTTPS {
+		return nil, errors.New("LISTENERS replaces SERVER_ADDR and ENABLE_HTTPS; use https:// listeners")
+	}
+
+	listeners := make([]listener, 0, len(cfg.Listeners))
+	seen := make(map[string]bool)
+	for _, spec := range cfg.Listeners {
+		l, err := parseListener(spec)
+		if err != nil {
+			return nil, err
+		}
+		if seen[l.Addr] {
+			return nil, fmt.Errorf("address %s is listed twice", l.Addr)
+		}
+		seen[l.Addr] = true
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// parseListener parses [http://|https://]addr=routes, such as
+// https://:443=public or http://10.0.0.5:8081=internal+admin
+func parseListener(spec string) (listener, error) {
+	i := strings.LastIndex(spec, "=")
+	if i < 0 {
+		return listener{}, fmt.Errorf("listener %q: want [http://|https://]addr=routes", spec)
+	}
+	addr, routes := spec[:i], spec[i+1:]
+
+	var l listener
+	switch {
+	case strings.HasPrefix(addr, "https://"):
+		l.TLS, addr = true, strings.TrimPrefix(addr, "https://")
+	case strings.HasPrefix(addr, "http://"):
+		addr = strings.TrimPrefix(addr, "http://")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return listener{}, fmt.Errorf("listener %q: %w", spec, err)
+	}
+	l.Addr = addr
+
+	var err error
+	if l.Routes, err = httpx.ParseRouteSet(routes); err != nil {
+		return listener{}, fmt.Errorf("listener %q: %w", spec, err)
+	}
+	return l, nil
+}
+
+// servesTLS reports whether any listener is HTTPS
+func servesTLS(cfg config.Config) bool {
+	listeners, err := initializeListeners(cfg)
+	if err != nil {
+		return false
+	}
+	for _, l := range listeners {
+		if l.TLS {
+			return true
+		}
+	}
+	return false
+}
+
+// startHTTPServers starts a server per listener, sending their errors on
+// errs. HTTP-01 challenges on ACME_HTTP_ADDR fall through to the first HTTPS
+// listener's routes.
+func startHTTPServers(cfg config.Config, listeners []listener, env httpx.Env, certs *autocert.Manager, errs chan<- error) []*http.Server {
+	servers := make([]*http.Server, 0, len(listeners)+1)
+	var challengeRoutes http.Handler
+	for _, l := range listeners {
+		srv := startHTTPServer(cfg, l, env, certs, errs)
+		if l.TLS && challengeRoutes == nil {
+			challengeRoutes = srv.Handler
+		}
+		servers = append(servers, srv)
+	}
+
+	if certs != nil && cfg.ACMEHTTPAddr != "" && challengeRoutes != nil {
+		challenges := acmeHTTPServer(cfg.ACMEHTTPAddr, certs, challengeRoutes)
+		go func() {
+			log.Printf("gotrack listening on %s (HTTP, ACME challenges)", cfg.ACMEHTTPAddr)
+			if err := challenges.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errs <- fmt.Errorf("ACME HTTP server error: %w", err)
+			}
+		}()
+		servers = append(servers, challenges)
+	}
+	return servers
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	httpx "github.com/shortontech/gotrack/internal/http"
+	"github.com/shortontech/gotrack/pkg/config"
+)
+
+func TestInitializeListeners(t *testing.T) {
+	t.Run("SERVER_ADDR serves everything by default", func(t *testing.T) {
+		got, err := initializeListeners(config.Config{ServerAddr: ":19890", EnableHTTPS: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := listener{Addr: ":19890", TLS: true, Routes: httpx.RoutesAll}
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("initializeListeners() = %+v, want [%+v]", got, want)
+		}
+	})
+
+	t.Run("LISTENERS", func(t *testing.T) {
+		got, err := initializeListeners(config.Config{
+			ServerAddr: ":19890",
+			Listeners:  []string{"https://:443=public", "http://10.0.0.5:8081=internal+admin", "[::1]:8082=all"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []listener{
+			{Addr: ":443", TLS: true, Routes: httpx.RoutesPublic},
+			{Addr: "10.0.0.5:8081", Routes: httpx.RoutesInternal | httpx.RoutesAdmin},
+			{Addr: "[::1]:8082", Routes: httpx.RoutesAll},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("initializeListeners() = %+v, want %+v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("listener %d = %+v, want %+v", i, got[i], want[i])
+			}
+		}
+	})
+
+	for name, tt := range map[string]struct {
+		cfg  config.Config
+		want string
+	}{
+		"missing routes":    {config.Config{Listeners: []string{":8080"}}, "want [http://|https://]addr=routes"},
+		"unknown route set": {config.Config{Listeners: []string{":8080=pixel"}}, `unknown route set "pixel"`},
+		"bad address":       {config.Config{Listeners: []string{"localhost=public"}}, "missing port"},
+		"duplicate address": {config.Config{Listeners: []string{":8080=public", "http://:8080=admin"}}, "listed twice"},
+		"ENABLE_HTTPS":      {config.Config{Listeners: []string{":8080=public"}, EnableHTTPS: true}, "https:// listeners"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initializeListeners(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("initializeListeners() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestServesTLS(t *testing.T) {
+	if servesTLS(config.Config{ServerAddr: ":80"}) {
+		t.Error("plain HTTP reported as TLS")
+	}
+	if !servesTLS(config.Config{Listeners: []string{":8081=internal", "https://:443=public"}}) {
+		t.Error("an https:// listener should count as TLS")
+	}
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/shortontech/gotrack/internal/outbound"
+	"github.com/shortontech/gotrack/pkg/config"
+)
+
+// outboundLinkCommand implements "gotrack outbound-link", which prints the
+// /r link of each destination, signed when OUTBOUND_LINK_SECRET is set
+func outboundLinkCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("outbound-link", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	base := flags.String("base", "", "Tracking host the links point at, such as https://track.example.com (required)")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: gotrack outbound-link -base URL DESTINATION...\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *base == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.LoadWithFile()
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+	policy, err := outbound.NewPolicy(cfg.OutboundLinkSecret, cfg.OutboundAllowedHosts)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid OUTBOUND_ALLOWED_HOSTS: %v\n", err)
+		return 1
+	}
+	if !policy.Enabled() {
+		fmt.Fprintln(stderr, "neither OUTBOUND_LINK_SECRET nor OUTBOUND_ALLOWED_HOSTS is set")
+		return 1
+	}
+
+	status := 0
+	for _, dest := range flags.Args() {
+		link, err := policy.URL(*base, dest)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", dest, err)
+			status = 1
+			continue
+		}
+		fmt.Fprintln(stdout, link)
+	}
+	return status
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+// purgeCommand implements "gotrack purge", which deletes a visitor's stored
+// events from every configured sink that can, to service GDPR erasure
+// requests. Sinks that can't delete, such as log files and message queues,
+// are listed so the operator can erase the data there by other means.
+func purgeCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("purge", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	visitorID := flags.String("visitor-id", "", "Visitor whose events are deleted (required)")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: gotrack purge -visitor-id ID\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *visitorID == "" || flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.LoadWithFile()
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+	sinks, err := initializeSinks(context.Background(), cfg.Outputs)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer closeSinks(sinks)
+
+	purged, failed := purgeVisitor(context.Background(), sinks, *visitorID, stdout, stderr)
+	if cfg.WALDir != "" {
+		// The server owns the log; undelivered events reach the sinks after the purge
+		fmt.Fprintf(stderr, "write-ahead log in %s: not purged; rerun once gotrack_wal_lag_bytes is 0 to erase events delivered after this run\n", cfg.WALDir)
+	}
+	if failed || !purged {
+		return 1
+	}
+	return 0
+}
+
+// purgeVisitor deletes the visitor's events from each sink that implements
+// sink.Purger. It reports whether any sink purged and whether any failed.
+func purgeVisitor(ctx context.Context, sinks []sink.Sink, visitorID string, stdout, stderr io.Writer) (purged, failed bool) {
+	for _, s := range sinks {
+		p, ok := s.(sink.Purger)
+		if !ok {
+			fmt.Fprintf(stderr, "%s: does not support deletion; erase stored events there by other means\n", s.Name())
+			continue
+		}
+		n, err := p.PurgeVisitor(ctx, visitorID)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v (%d events deleted before the error)\n", s.Name(), err, n)
+			failed = true
+			continue
+		}
+		purged = true
+		fmt.Fprintf(stdout, "%s: deleted %d events\n", s.Name(), n)
+	}
+	if !purged && !failed {
+		fmt.Fprintln(stderr, "no configured sink supports deletion")
+	}
+	return purged, failed
+}
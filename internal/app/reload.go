@@ -0,0 +1,197 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/shortontech/gotrack/internal/experiment"
+	httpx "github.com/shortontech/gotrack/internal/http"
+	"github.com/shortontech/gotrack/internal/logging"
+	"github.com/shortontech/gotrack/internal/routing"
+	"github.com/shortontech/gotrack/internal/sink"
+	"github.com/shortontech/gotrack/internal/transform"
+	"github.com/shortontech/gotrack/pkg/config"
+)
+
+// reloader re-reads configuration and applies the settings that are safe to
+// change while serving traffic: log level, rate limits, HMAC secret, tenants,
+// API keys, output rules, transforms, experiments and sink batching.
+// Everything else (listeners, sink destinations) needs a restart.
+// Sinks keep their buffers across a reload, so no in-flight events are dropped.
+type reloader struct {
+	mu         sync.Mutex
+	hmacAuth   *httpx.HMACAuth
+	limiter    *httpx.RateLimiter
+	tenants    *httpx.Tenants
+	apiKeys    *httpx.APIKeys
+	router     *routing.Router
+	transforms *transform.Pipeline
+	sinks      []sink.Sink
+	registered []string // names of sinks registered by an embedding program
+	load       func() (config.Config, error)
+
+	experiments *httpx.Experiments // nil without EXPERIMENTS_FILE
+}
+
+func newReloader(hmacAuth *httpx.HMACAuth, limiter *httpx.RateLimiter, tenants *httpx.Tenants, apiKeys *httpx.APIKeys, router *routing.Router, transforms *transform.Pipeline, sinks []sink.Sink) *reloader {
+	return &reloader{
+		hmacAuth:   hmacAuth,
+		limiter:    limiter,
+		tenants:    tenants,
+		apiKeys:    apiKeys,
+		router:     router,
+		transforms: transforms,
+		sinks:      sinks,
+		load:       config.LoadWithFile,
+	}
+}
+
+// Reload loads the current configuration and applies it
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := r.load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	cfg.Outputs = append(cfg.Outputs, r.registered...)
+
+	// Check the tenants, API keys and experiments files, output rules and
+	// transforms before applying anything, so a bad file leaves the running
+	// configuration untouched
+	var tenantDefs []config.Tenant
+	if r.tenants != nil && cfg.TenantsFile != "" {
+		if tenantDefs, err = config.LoadTenants(cfg.TenantsFile); err != nil {
+			return err
+		}
+		if err := validateTenantOutputs(tenantDefs, cfg.Outputs); err != nil {
+			return err
+		}
+	}
+
+	var keyDefs []config.APIKey
+	if r.apiKeys != nil && cfg.APIKeysFile != "" {
+		if keyDefs, err = config.LoadAPIKeys(cfg.APIKeysFile); err != nil {
+			return err
+		}
+		hasSite := registrySites(r.tenants)
+		if tenantDefs != nil {
+			hasSite = func(id string) bool {
+				return slices.ContainsFunc(tenantDefs, func(t config.Tenant) bool { return t.SiteID == id })
+			}
+		}
+		if err := validateAPIKeySites(keyDefs, hasSite); err != nil {
+			return err
+		}
+	}
+
+	var rules []routing.Rule
+	if r.router != nil {
+		if rules, err = routing.Parse(cfg.OutputRules); err != nil {
+			return fmt.Errorf("invalid OUTPUT_RULES: %w", err)
+		}
+		if err := validateRuleOutputs(rules, cfg.Outputs); err != nil {
+			return fmt.Errorf("invalid OUTPUT_RULES: %w", err)
+		}
+	}
+
+	var steps []transform.Step
+	if r.transforms != nil && cfg.TransformsFile != "" {
+		if steps, err = transform.Load(cfg.TransformsFile); err != nil {
+			return err
+		}
+		if err := validateTransformOutputs(steps, cfg.Outputs); err != nil {
+			return err
+		}
+	}
+
+	var experimentDefs []experiment.Experiment
+	if r.experiments != nil && cfg.ExperimentsFile != "" {
+		if experimentDefs, err = experiment.Load(cfg.ExperimentsFile); err != nil {
+			return err
+		}
+	}
+
+	if err := logging.SetLevelString(cfg.LogLevel); err != nil {
+		return err
+	}
+
+	if r.limiter != nil {
+		r.limiter.SetLimit(float64(cfg.RateLimitRPS), int(cfg.RateLimitBurst))
+	}
+
+	if r.hmacAuth != nil && cfg.HMACSecret != "" {
+		if r.hmacAuth.Rotate(cfg.HMACSecret, cfg.HMACPublicKey) {
+			log.Printf("reload: HMAC secret rotated (previous secret still accepted)")
+		}
+	}
+
+	if tenantDefs != nil {
+		if err := r.tenants.Update(tenantDefs); err != nil {
+			return err
+		}
+		log.Printf("reload: %d tenants loaded", len(tenantDefs))
+	}
+
+	if keyDefs != nil {
+		r.apiKeys.Update(keyDefs)
+		log.Printf("reload: %d API keys loaded", len(keyDefs))
+	}
+
+	if r.router != nil {
+		r.router.Update(rules)
+	}
+
+	if steps != nil {
+		if err := r.transforms.Update(steps); err != nil {
+			return err
+		}
+		log.Printf("reload: %d transforms loaded", len(steps))
+	}
+
+	if experimentDefs != nil {
+		if err := r.experiments.Update(experimentDefs); err != nil {
+			return err
+		}
+		log.Printf("reload: %d experiments loaded", len(experimentDefs))
+	}
+
+	var sinkErr error
+	for _, s := range r.sinks {
+		if rs, ok := s.(sink.Reloadable); ok {
+			if err := rs.Reload(); err != nil {
+				log.Printf("reload: %s sink: %v", s.Name(), err)
+				sinkErr = fmt.Errorf("%s sink: %w", s.Name(), err)
+			}
+		}
+	}
+
+	log.Printf("reload: configuration applied (log_level=%s rate_limit_rps=%d)", logging.GetLevel(), cfg.RateLimitRPS)
+	return sinkErr
+}
+
+// watchSignals reloads on SIGHUP until the context is cancelled
+func (r *reloader) watchSignals(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("reload: SIGHUP received")
+			if err := r.Reload(); err != nil {
+				log.Printf("reload failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	httpx "github.com/shortontech/gotrack/internal/http"
+	"github.com/shortontech/gotrack/internal/logging"
+	"github.com/shortontech/gotrack/internal/routing"
+	"github.com/shortontech/gotrack/internal/sink"
+	"github.com/shortontech/gotrack/internal/transform"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// reloadableSink is a sink that records reloads
+type reloadableSink struct {
+	reloads int
+	err     error
+}
+
+func (s *reloadableSink) Start(ctx context.Context) error { return nil }
+func (s *reloadableSink) Enqueue(e event.Event) error     { return nil }
+func (s *reloadableSink) Close() error                    { return nil }
+func (s *reloadableSink) Name() string                    { return "reloadable" }
+func (s *reloadableSink) Reload() error                   { s.reloads++; return s.err }
+
+// TestReloader tests applying reloaded configuration to running components
+func TestReloader(t *testing.T) {
+	defer logging.SetLevel(logging.LevelInfo)
+
+	t.Run("applies runtime settings", func(t *testing.T) {
+		auth := httpx.NewHMACAuth("old-secret", "")
+		limiter := httpx.NewRateLimiter(0, 20)
+		s := &reloadableSink{}
+		r := newReloader(auth, limiter, nil, nil, nil, nil, []sink.Sink{s, &sink.LogSink{}})
+		r.load = func() (config.Config, error) {
+			return config.Config{LogLevel: "debug", RateLimitRPS: 10, RateLimitBurst: 5, HMACSecret: "new-secret"}, nil
+		}
+
+		if err := r.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		if logging.GetLevel() != logging.LevelDebug {
+			t.Errorf("log level = %v, want debug", logging.GetLevel())
+		}
+		if rps, burst := limiter.Limit(); rps != 10 || burst != 5 {
+			t.Errorf("limit = %v/%v, want 10/5", rps, burst)
+		}
+		if auth.Rotate("new-secret", "") {
+			t.Error("expected HMAC secret to already be rotated")
+		}
+		if s.reloads != 1 {
+			t.Errorf("sink reloads = %d, want 1", s.reloads)
+		}
+	})
+
+	t.Run("load failure leaves settings unchanged", func(t *testing.T) {
+		limiter := httpx.NewRateLimiter(3, 3)
+		r := newReloader(nil, limiter, nil, nil, nil, nil, nil)
+		r.load = func() (config.Config, error) { return config.Config{}, errors.New("bad file") }
+
+		if err := r.Reload(); err == nil {
+			t.Fatal("expected error")
+		}
+		if rps, _ := limiter.Limit(); rps != 3 {
+			t.Errorf("limit changed to %v after failed reload", rps)
+		}
+	})
+
+	t.Run("reloads tenants", func(t *testing.T) {
+		tenants, err := httpx.NewTenants([]config.Tenant{{SiteID: "shop", WriteKeys: []string{"wk_old"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "tenants.json")
+		r := newReloader(nil, nil, tenants, nil, nil, nil, nil)
+		r.load = func() (config.Config, error) {
+			return config.Config{LogLevel: "info", TenantsFile: path, Outputs: []string{"log"}}, nil
+		}
+
+		// A bad file is rejected and the current keys stay valid
+		if err := os.WriteFile(path, []byte(`[{"site_id": "shop", "write_keys": ["wk_new"], "outputs": ["kafka"]}]`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Reload(); err == nil {
+			t.Error("expected error for an output that is not enabled")
+		}
+		if _, ok := tenants.Lookup("wk_old"); !ok {
+			t.Error("failed reload replaced the tenants")
+		}
+
+		if err := os.WriteFile(path, []byte(`[{"site_id": "shop", "write_keys": ["wk_new"]}]`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		if _, ok := tenants.Lookup("wk_new"); !ok {
+			t.Error("new write key not accepted after reload")
+		}
+		if _, ok := tenants.Lookup("wk_old"); ok {
+			t.Error("removed write key still accepted after reload")
+		}
+	})
+
+	t.Run("reloads API keys", func(t *testing.T) {
+		tenants, err := httpx.NewTenants([]config.Tenant{{SiteID: "shop", WriteKeys: []string{"wk_shop"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := httpx.NewAPIKeys([]config.APIKey{{Name: "billing", Key: "sk_old", SiteID: "shop"}})
+		dir := t.TempDir()
+		tenantsPath, keysPath := filepath.Join(dir, "tenants.json"), filepath.Join(dir, "api_keys.json")
+		r := newReloader(nil, nil, tenants, keys, nil, nil, nil)
+		r.load = func() (config.Config, error) {
+			return config.Config{LogLevel: "info", TenantsFile: tenantsPath, APIKeysFile: keysPath, Outputs: []string{"log"}}, nil
+		}
+		if err := os.WriteFile(tenantsPath, []byte(`[{"site_id": "shop", "write_keys": ["wk_shop"]}]`), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		// A key for an unknown site is rejected and the current keys stay valid
+		if err := os.WriteFile(keysPath, []byte(`[{"name": "billing", "key": "sk_new", "site_id": "blog"}]`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Reload(); err == nil {
+			t.Error("expected error for a site that is not configured")
+		}
+		if _, ok := keys.Authenticate("sk_old"); !ok {
+			t.Error("failed reload replaced the API keys")
+		}
+
+		// A site added in the same reload can be used
+		if err := os.WriteFile(tenantsPath, []byte(`[{"site_id": "shop", "write_keys": ["wk_shop"]}, {"site_id": "blog", "write_keys": ["wk_blog"]}]`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		if _, ok := keys.Authenticate("sk_new"); !ok {
+			t.Error("new API key not accepted after reload")
+		}
+		if _, ok := keys.Authenticate("sk_old"); ok {
+			t.Error("removed API key still accepted after reload")
+		}
+	})
+
+	t.Run("reloads output rules", func(t *testing.T) {
+		router := routing.NewRouter(nil)
+		r := newReloader(nil, nil, nil, nil, router, nil, nil)
+		rules := "kafka: type=purchase"
+		r.load = func() (config.Config, error) {
+			return config.Config{LogLevel: "info", Outputs: []string{"log"}, OutputRules: rules}, nil
+		}
+		purchase := event.Event{Type: "purchase"}
+
+		if err := r.Reload(); err == nil {
+			t.Error("expected error for a rule on an output that is not enabled")
+		}
+		rules = "log: type=purchase"
+		if err := r.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		if router.Allows("log", event.Event{Type: "click"}) || !router.Allows("log", purchase) {
+			t.Error("reloaded rules not applied")
+		}
+
+		r.registered = []string{"bigquery"}
+		rules = "bigquery: type=purchase"
+		if err := r.Reload(); err != nil {
+			t.Fatalf("Reload() with a rule on a registered sink error = %v", err)
+		}
+		if !router.Allows("bigquery", purchase) || router.Allows("bigquery", event.Event{Type: "click"}) {
+			t.Error("rule on registered sink not applied")
+		}
+	})
+
+	t.Run("reloads transforms", func(t *testing.T) {
+		transforms, err := transform.New([]transform.Step{{Drop: []string{"url.raw_query"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "transforms.json")
+		r := newReloader(nil, nil, nil, nil, nil, transforms, nil)
+		r.load = func() (config.Config, error) {
+			return config.Config{LogLevel: "info", TransformsFile: path, Outputs: []string{"log"}}, nil
+		}
+		ev := event.Event{Type: "Click"}
+		ev.URL.RawQuery = "a=b"
+
+		if err := os.WriteFile(path, []byte(`[{"lowercase": ["type"], "sinks": ["kafka"]}]`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Reload(); err == nil {
+			t.Error("expected error for an output that is not enabled")
+		}
+		if got := transforms.Apply("log", ev); got.URL.RawQuery != "" {
+			t.Error("failed reload replaced the transforms")
+		}
+
+		if err := os.WriteFile(path, []byte(`[{"lowercase": ["type"]}]`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		if got := transforms.Apply("log", ev); got.Type != "click" || got.URL.RawQuery != "a=b" {
+			t.Errorf("event = %+v, want only the reloaded step applied", got)
+		}
+	})
+
+	t.Run("reports sink errors", func(t *testing.T) {
+		r := newReloader(nil, nil, nil, nil, nil, nil, []sink.Sink{&reloadableSink{err: errors.New("boom")}})
+		r.load = func() (config.Config, error) { return config.Config{LogLevel: "info"}, nil }
+		if err := r.Reload(); err == nil {
+			t.Error("expected sink reload error")
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/metrics"
+	"github.com/shortontech/gotrack/internal/privacy"
+	"github.com/shortontech/gotrack/internal/sink"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// replayCommand implements "gotrack replay", which sends events from NDJSON
+// files, such as those the log sink writes, through the sink pipeline
+func replayCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	rate := flags.Float64("rate", 0, "Maximum events per second (0 for no limit)")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: gotrack replay [-rate N] file.ndjson... (- reads stdin)\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || *rate < 0 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, emit, shutdown, err := startPipeline()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer shutdown()
+
+	wait := throttle(*rate)
+	failed := false
+	for _, name := range flags.Args() {
+		sent, errs := replayFile(name, cfg.MaxBodyBytes, func(ev event.Event) {
+			wait()
+			emit(context.Background(), ev)
+		})
+		for _, err := range errs {
+			fmt.Fprintln(stderr, err)
+		}
+		failed = failed || len(errs) > 0
+		fmt.Fprintf(stdout, "%s: replayed %d events, %d errors\n", name, sent, len(errs))
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// replayFile sends each event in the named NDJSON file, or stdin for "-",
+// to emit. Lines that don't decode are reported and skipped.
+func replayFile(name string, maxLine int64, emit func(event.Event)) (int, []error) {
+	r := io.Reader(os.Stdin)
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return 0, []error{err}
+		}
+		defer f.Close()
+		r = f
+	}
+	return replayEvents(r, name, maxLine, emit)
+}
+
+// replayEvents decodes one event per line of r, skipping blank lines
+func replayEvents(r io.Reader, name string, maxLine int64, emit func(event.Event)) (int, []error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), int(maxLine))
+
+	var sent int
+	var errs []error
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var ev event.Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", name, line, err))
+			continue
+		}
+		if ev.Type == "" {
+			errs = append(errs, fmt.Errorf("%s:%d: event has no type", name, line))
+			continue
+		}
+		emit(ev)
+		sent++
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+	return sent, errs
+}
+
+// throttle returns a function that blocks so successive calls happen at
+// most rate times per second. A rate of zero doesn't block.
+func throttle(rate float64) func() {
+	if rate <= 0 {
+		return func() {}
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	var next time.Time
+	return func() {
+		if d := time.Until(next); d > 0 {
+			time.Sleep(d)
+		}
+		next = time.Now().Add(interval)
+	}
+}
+
+// startPipeline loads the configuration and starts the configured sinks
+// behind the same site routing, output rules, IP privacy and transforms
+// serve applies. The returned function closes the sinks, flushing them.
+func startPipeline() (config.Config, func(context.Context, event.Event), func(), error) {
+	cfg, err := config.LoadWithFile()
+	if err != nil {
+		return cfg, nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	tenants, err := initializeTenants(cfg)
+	if err != nil {
+		return cfg, nil, nil, fmt.Errorf("invalid tenant configuration: %w", err)
+	}
+	router, err := initializeRouter(cfg)
+	if err != nil {
+		return cfg, nil, nil, fmt.Errorf("invalid OUTPUT_RULES: %w", err)
+	}
+	transforms, err := initializeTransforms(cfg)
+	if err != nil {
+		return cfg, nil, nil, fmt.Errorf("invalid transforms: %w", err)
+	}
+	regions, err := initializeRegions(cfg)
+	if err != nil {
+		return cfg, nil, nil, fmt.Errorf("invalid REGION_POLICY_FILE: %w", err)
+	}
+	ipPolicy, err := privacy.NewPolicy(cfg.IPPrivacyMode, cfg.IPPrivacySinks, cfg.IPHashSecret)
+	if err != nil {
+		return cfg, nil, nil, fmt.Errorf("invalid IP privacy configuration: %w", err)
+	}
+	encryptor, err := initializeEncryption(cfg)
+	if err != nil {
+		return cfg, nil, nil, fmt.Errorf("invalid field encryption configuration: %w", err)
+	}
+
+	sinks, err := initializeSinks(context.Background(), cfg.Outputs)
+	if err != nil {
+		return cfg, nil, nil, err
+	}
+	if len(sinks) == 0 {
+		return cfg, nil, nil, errors.New("no valid sinks configured")
+	}
+	emit := createEmitFunc(sink.NewSet(sinks), metrics.InitMetrics(), ipPolicy, tenants, router, transforms, regions, encryptor, nil)
+	return cfg, emit, func() { closeSinks(sinks) }, nil
+}
+
+// closeSinks closes each sink, logging failures
+func closeSinks(sinks []sink.Sink) {
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			log.Printf("error closing sink: %v", err)
+		}
+	}
+}
@@ -0,0 +1,1836 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/shortontech/gotrack/internal/alert"
+	"github.com/shortontech/gotrack/internal/analytics"
+	"github.com/shortontech/gotrack/internal/consent"
+	"github.com/shortontech/gotrack/internal/dedup"
+	"github.com/shortontech/gotrack/internal/experiment"
+	"github.com/shortontech/gotrack/internal/fieldcrypt"
+	httpx "github.com/shortontech/gotrack/internal/http"
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/internal/logging"
+	"github.com/shortontech/gotrack/internal/metrics"
+	"github.com/shortontech/gotrack/internal/outbound"
+	"github.com/shortontech/gotrack/internal/privacy"
+	"github.com/shortontech/gotrack/internal/proxycache"
+	"github.com/shortontech/gotrack/internal/region"
+	"github.com/shortontech/gotrack/internal/routing"
+	"github.com/shortontech/gotrack/internal/sampling"
+	"github.com/shortontech/gotrack/internal/session"
+	"github.com/shortontech/gotrack/internal/shortlink"
+	"github.com/shortontech/gotrack/internal/sink"
+	"github.com/shortontech/gotrack/internal/tracing"
+	"github.com/shortontech/gotrack/internal/transform"
+	"github.com/shortontech/gotrack/internal/validation"
+	"github.com/shortontech/gotrack/internal/wal"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/event/detection"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serve runs the tracking server until SIGINT or SIGTERM
+func serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	var (
+		healthCheck    = flags.Bool("healthcheck", false, "Perform health check and exit")
+		healthScheme   = flags.String("health-scheme", "", "Scheme for health check, http or https (default from ENABLE_HTTPS and ACME_DOMAINS)")
+		healthHost     = flags.String("health-host", "", "Host for health check (default from SERVER_ADDR, else localhost)")
+		healthPort     = flags.String("health-port", "", "Port for health check (default from SERVER_ADDR)")
+		healthPath     = flags.String("health-path", "/healthz", "Path for health check")
+		healthInsecure = flags.Bool("health-insecure", false, "Skip TLS certificate verification for health check")
+	)
+	_ = flags.Parse(args)
+
+	// Handle health check mode
+	if *healthCheck {
+		cfg, err := config.LoadWithFile()
+		if err != nil {
+			log.Printf("Health check failed: %v", err)
+			return 1
+		}
+		target := healthTargetFor(cfg, healthTarget{
+			Scheme:   *healthScheme,
+			Host:     *healthHost,
+			Port:     *healthPort,
+			Path:     *healthPath,
+			Insecure: *healthInsecure,
+		})
+		if err := performHealthCheck(target); err != nil {
+			log.Printf("Health check failed: %v", err)
+			return 1
+		}
+		log.Println("Health check passed")
+		return 0
+	}
+
+	cfg, err := config.LoadWithFile()
+	if err != nil {
+		log.Printf("failed to load configuration: %v", err)
+		return 1
+	}
+	if err := Serve(cfg, nil); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+// Serve runs the tracking server with cfg until SIGINT or SIGTERM, then
+// drains and closes the sinks. The registered sinks are started and fed
+// next to those OUTPUTS names, and OUTPUT_RULES, tenants, transforms and
+// per-sink settings can refer to them by name. A sink that fails to start
+// or a listener that fails is returned as an error, after whatever was
+// started is closed again.
+func Serve(cfg config.Config, registered []sink.Sink) error {
+	if err := validateRequired(cfg); err != nil {
+		return err
+	}
+	certs, err := initializeACME(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid ACME configuration: %w", err)
+	}
+	listeners, err := initializeListeners(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid LISTENERS: %w", err)
+	}
+
+	in, err := build(cfg, registered)
+	if err != nil {
+		return err
+	}
+	go in.reload.watchSignals(in.ctx)
+	serveErrs := make(chan error, len(listeners)+1)
+	servers := startHTTPServers(cfg, listeners, in.env, certs, serveErrs)
+	return waitForShutdown(servers, in, serveErrs)
+}
+
+// Handler builds the tracking endpoints, admin API and proxy as one
+// http.Handler without listening, for a program that mounts them in its
+// own server. FORWARD_DESTINATION is optional: without it, paths GoTrack
+// doesn't serve get a 404. LISTENERS, ACME and SIGHUP reloads are left to
+// the host. Call shutdown once the handler is out of use to drain and
+// close the sinks.
+func Handler(cfg config.Config, registered []sink.Sink) (h http.Handler, shutdown func(context.Context), err error) {
+	if err := validateSettings(cfg); err != nil {
+		return nil, nil, err
+	}
+	in, err := build(cfg, registered)
+	if err != nil {
+		return nil, nil, err
+	}
+	return httpx.NewMux(in.env), func(ctx context.Context) {
+		in.drainer.Drain(ctx)
+		in.close(ctx)
+	}, nil
+}
+
+// instance is a configured server without its listeners: the sinks, the
+// handler environment and the background work behind them
+type instance struct {
+	ctx             context.Context
+	cancel          context.CancelFunc
+	env             httpx.Env
+	reload          *reloader
+	drainer         *httpx.Drainer
+	metricsServer   *metrics.Server
+	sinks           []sink.Sink
+	walLog          *wal.Log        // nil without WAL_DIR
+	dispatcher      *wal.Dispatcher // delivers walLog to the sinks
+	store           kv.Store
+	shutdownTracing func(context.Context) error
+}
+
+// running is set while an instance is built or open. build installs the
+// detection trackers and event classifiers as package defaults, so a second
+// instance in the same process would replace the first one's.
+var running atomic.Bool
+
+// build starts the sinks, shared state and metrics server for cfg and
+// assembles the emit pipeline and handler environment. On error, whatever
+// it started is closed again. Only one instance can exist per process until
+// it's closed.
+func build(cfg config.Config, registered []sink.Sink) (*instance, error) {
+	if !running.CompareAndSwap(false, true) {
+		return nil, errors.New("a GoTrack server is already running in this process")
+	}
+	built := false
+	defer func() {
+		if !built {
+			running.Store(false)
+		}
+	}()
+
+	if err := logging.SetLevelString(cfg.LogLevel); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+	}
+	if cfg.TrustProxy && len(cfg.TrustedProxyCIDRs) == 0 {
+		log.Printf("warning: TRUST_PROXY is on without TRUSTED_PROXY_CIDRS; any client can spoof X-Forwarded-For")
+	}
+	if cfg.InstanceID == "" {
+		cfg.InstanceID, _ = os.Hostname()
+	}
+
+	// Initialize metrics
+	appMetrics := metrics.InitMetrics()
+	metricsConfig := metrics.Config{
+		Enabled:     cfg.MetricsEnabled,
+		Addr:        cfg.MetricsAddr,
+		TLSCert:     cfg.MetricsTLSCert,
+		TLSKey:      cfg.MetricsTLSKey,
+		ClientCA:    cfg.MetricsClientCA,
+		RequireTLS:  cfg.MetricsRequireTLS,
+		RequireAuth: cfg.MetricsRequireAuth,
+		AuthToken:   cfg.MetricsAuthToken,
+	}
+	if err := metricsConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid metrics configuration: %w", err)
+	}
+	metricsServer := metrics.NewServer(metricsConfig)
+
+	// start sinks
+	ctx, cancel := context.WithCancel(context.Background())
+	var sinks []sink.Sink
+	defer func() {
+		if !built {
+			closeSinks(sinks)
+			cancel()
+		}
+	}()
+
+	shutdownTracing, err := tracing.Init(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+	if tracing.Enabled() {
+		log.Println("OpenTelemetry tracing enabled (OTLP/HTTP)")
+	}
+
+	if sinks, err = initializeSinks(ctx, cfg.Outputs); err != nil {
+		return nil, err
+	}
+	registeredNames, err := startRegisteredSinks(ctx, registered)
+	if err != nil {
+		return nil, err
+	}
+	sinks = append(sinks, registered...)
+	cfg.Outputs = append(slices.Clip(cfg.Outputs), registeredNames...)
+	if len(sinks) == 0 {
+		return nil, errors.New("no valid sinks configured")
+	}
+	go reportSinkQueues(ctx, sinks, appMetrics, sinkQueueInterval)
+
+	hmacAuth := initializeHMACAuth(cfg)
+
+	tenants, err := initializeTenants(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant configuration: %w", err)
+	}
+
+	apiKeys, err := initializeAPIKeys(cfg, tenants)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API key configuration: %w", err)
+	}
+
+	validator, err := initializeValidator(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validation configuration: %w", err)
+	}
+
+	router, err := initializeRouter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OUTPUT_RULES: %w", err)
+	}
+
+	transforms, err := initializeTransforms(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transforms: %w", err)
+	}
+
+	regions, err := initializeRegions(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REGION_POLICY_FILE: %w", err)
+	}
+
+	ipPolicy, err := privacy.NewPolicy(cfg.IPPrivacyMode, cfg.IPPrivacySinks, cfg.IPHashSecret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP privacy configuration: %w", err)
+	}
+	log.Printf("IP privacy mode: %s", ipPolicy.Default)
+
+	encryptor, err := initializeEncryption(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid field encryption configuration: %w", err)
+	}
+
+	store, err := initializeStore(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize shared state: %w", err)
+	}
+	detection.DefaultTracker = initializeTimingTracker(cfg, store)
+	detection.DefaultTrapTracker = initializeTrapTracker(cfg, store)
+	detection.DefaultRateTracker = initializeRateTracker(cfg, store)
+	detection.DefaultFingerprintTracker = initializeFingerprintTracker(cfg, store)
+	if cfg.IPReputationFile != "" {
+		classifier, err := detection.LoadIPClassifier(cfg.IPReputationFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP_REPUTATION_FILE: %w", err)
+		}
+		detection.DefaultIPClassifier = classifier
+	}
+	if cfg.ReferrerListFile != "" {
+		classifier, err := event.LoadChannelClassifier(cfg.ReferrerListFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REFERRER_LIST_FILE: %w", err)
+		}
+		event.DefaultChannelClassifier = classifier
+	}
+	if cfg.ClickIDFile != "" {
+		registry, err := event.LoadClickIDRegistry(cfg.ClickIDFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CLICK_ID_FILE: %w", err)
+		}
+		event.DefaultClickIDs = registry
+	}
+
+	limiter := httpx.NewRateLimiter(float64(cfg.RateLimitRPS), int(cfg.RateLimitBurst))
+	reload := newReloader(hmacAuth, limiter, tenants, apiKeys, router, transforms, sinks)
+	reload.registered = registeredNames
+	drainer := httpx.NewDrainer(sinks, time.Duration(cfg.DrainTimeoutSeconds)*time.Second)
+
+	// The dashboard, like the cluster report, is only reachable through the admin API
+	var inspector *httpx.Inspector
+	if cfg.AdminToken != "" {
+		inspector = httpx.NewInspector(200, func(ev event.Event) event.Event { return ipPolicy.Apply("", ev) })
+	}
+
+	sinkSet := sink.NewSet(sinks)
+	fanOut := newFanOut(sinkSet, appMetrics, ipPolicy, tenants, router, transforms, regions, encryptor, inspector)
+	emit := func(ctx context.Context, ev event.Event) { fanOut(ctx, ev, nil) }
+
+	// Queue events on disk ahead of the sinks, so they outlive sink outages and crashes
+	walLog, err := initializeWAL(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WAL configuration: %w", err)
+	}
+	if walLog != nil {
+		defer func() {
+			if !built {
+				_ = walLog.Close()
+			}
+		}()
+		if _, shared := ipPolicy.Shared(); !shared && encryptor == nil {
+			log.Printf("warning: IP_PRIVACY_SINK_MODES varies by sink, so the write-ahead log holds raw IPs; set FIELD_ENCRYPTION_FIELDS to encrypt it")
+		}
+		emit = walEmit(walLog, sinkSet, fanOut, walRecord(ipPolicy, regions, encryptor), appMetrics)
+	}
+
+	env := httpx.Env{
+		Cfg:       cfg,
+		APIKeys:   apiKeys,
+		HMACAuth:  hmacAuth,
+		Metrics:   appMetrics,
+		Emit:      emit,
+		Limiter:   limiter,
+		Reload:    reload.Reload,
+		Sinks:     sinks,
+		SinkSet:   sinkSet,
+		Drainer:   drainer,
+		Inspector: inspector,
+		Tenants:   tenants,
+		Validator: validator,
+	}
+
+	if cfg.ClusterMode {
+		env.Cluster = initializeCluster(cfg)
+	}
+	experiments, err := initializeExperiments(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EXPERIMENTS_FILE: %w", err)
+	}
+	env.Experiments = experiments
+	reload.experiments = experiments
+
+	injector, err := initializeInjector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid injection configuration: %w", err)
+	}
+	env.Injector = injector
+
+	proxyCache, err := initializeProxyCache(cfg, appMetrics)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy cache configuration: %w", err)
+	}
+	env.ProxyCache = proxyCache
+
+	replay, err := initializeReplayGuard(cfg, store)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HMAC replay configuration: %w", err)
+	}
+	env.Replay = replay
+
+	if cfg.SessionCookies {
+		sessions, err := initializeSessions(cfg, store)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session configuration: %w", err)
+		}
+		env.Sessions = sessions
+	}
+	if cfg.ShortLinks {
+		env.ShortLinks = initializeShortLinks(cfg, store)
+	}
+	orders, err := initializeOrderDedup(cfg, store)
+	if err != nil {
+		return nil, fmt.Errorf("invalid conversion configuration: %w", err)
+	}
+	env.Orders = orders
+
+	bots, err := initializeBotPolicy(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BOT_RESPONSE: %w", err)
+	}
+	env.Bots = bots
+
+	consentPolicy, err := initializeConsent(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCF configuration: %w", err)
+	}
+	env.Consent = consentPolicy
+
+	if cfg.ClickIDCookies {
+		clickIDs, err := initializeClickCookies(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid click ID cookie configuration: %w", err)
+		}
+		env.ClickIDs = clickIDs
+	}
+
+	// Support lookups by click ID read from the first sink that stores events
+	for _, s := range sinks {
+		if searcher, ok := s.(httpx.EventSearcher); ok {
+			env.Search = searcher
+			break
+		}
+	}
+	for _, s := range sinks {
+		if querier, ok := s.(sink.Querier); ok {
+			env.Query = querier
+			break
+		}
+	}
+
+	// Device clustering report, realtime stats and live tail are only
+	// reachable with the admin token. They observe inside dedup and
+	// sampling, so they count the events delivered to the sinks.
+	if cfg.AdminToken != "" {
+		env.Clusters = analytics.NewClusterTracker(time.Duration(cfg.ClusterWindowSeconds)*time.Second, 100000)
+		go env.Clusters.Run(ctx, 30*time.Second)
+		env.Realtime = analytics.NewRealtime(int(cfg.BotScoreThreshold))
+		env.Emit = observeEmit(env.Emit, env.Clusters.Observe, env.Realtime.Observe, inspector.Observe)
+	}
+
+	// Operational alerts
+	if cfg.AlertWebhookURL != "" {
+		monitor, botShare := initializeAlerts(cfg, sinks)
+		if botShare != nil {
+			env.Emit = observeEmit(env.Emit, botShare.Observe)
+		}
+		go monitor.Run(ctx, time.Duration(cfg.AlertIntervalSeconds)*time.Second)
+		log.Printf("alert webhook enabled, checking every %ds", cfg.AlertIntervalSeconds)
+	}
+
+	rates, err := sampling.ParseRates(cfg.SamplingRates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAMPLING_RATES: %w", err)
+	}
+	fixed := sampling.NewFixed(rates)
+
+	// Shed pageviews before they reach sinks that are falling behind
+	if cfg.SamplingDynamic {
+		sampler := sampling.NewDynamic(sampling.DynamicConfig{
+			QueueHigh:   int(cfg.SamplingQueueHigh),
+			QueueLow:    int(cfg.SamplingQueueLow),
+			LatencyHigh: time.Duration(cfg.SamplingLatencyHighMS) * time.Millisecond,
+			MinRate:     float64(cfg.SamplingMinPercent) / 100,
+		})
+		// The exported rate is the combined one when pageviews also have a fixed rate
+		sampler.OnChange = func(rate float64) { appMetrics.SetSampleRate("pageview", rate*fixed.Rate("pageview")) }
+		appMetrics.SetSampleRate("pageview", sampler.Rate()*fixed.Rate("pageview"))
+		go sampler.Run(ctx, time.Second, sinkLoad(sinks))
+		env.Emit = sampler.Wrap(env.Emit)
+	}
+
+	// Sample high-volume types per visitor ahead of load shedding
+	if len(rates) > 0 {
+		for typ, rate := range fixed.Rates() {
+			if typ != "pageview" || !cfg.SamplingDynamic {
+				appMetrics.SetSampleRate(typ, rate)
+			}
+		}
+		log.Printf("fixed sampling: %s", strings.Join(cfg.SamplingRates, ","))
+		env.Emit = fixed.Wrap(env.Emit)
+	}
+
+	// Drop browser retries first so they don't count towards sampling
+	if cfg.DedupEnabled {
+		filter, err := initializeDedup(cfg, store)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dedup configuration: %w", err)
+		}
+		filter.OnDuplicate = func(action dedup.Action) { appMetrics.IncrementEventsDuplicate(string(action)) }
+		env.Emit = filter.Wrap(env.Emit)
+	}
+
+	// Start metrics server
+	if err := metricsServer.Start(ctx); err != nil {
+		return nil, fmt.Errorf("failed to start metrics server: %w", err)
+	}
+
+	// Run test mode if enabled (generate test events)
+	if cfg.TestMode {
+		go func() {
+			// Wait a moment for sinks to be fully initialized
+			time.Sleep(2 * time.Second)
+			runTestMode(env.Emit)
+		}()
+	}
+
+	var dispatcher *wal.Dispatcher
+	if walLog != nil {
+		// Each sink is replayed from its own position in the log
+		targets := sinkNames(sinks)
+		dispatcher = wal.NewDispatcher(walLog, targets, walDeliver(fanOut, encryptor), flushSink(sinkSet), time.Duration(cfg.WALCheckpointIntervalSec)*time.Second)
+		dispatcher.Start()
+		go reportWAL(ctx, walLog, targets, appMetrics, sinkQueueInterval)
+	}
+
+	built = true
+	return &instance{
+		ctx:             ctx,
+		cancel:          cancel,
+		env:             env,
+		reload:          reload,
+		drainer:         drainer,
+		metricsServer:   metricsServer,
+		sinks:           sinks,
+		walLog:          walLog,
+		dispatcher:      dispatcher,
+		store:           store,
+		shutdownTracing: shutdownTracing,
+	}, nil
+}
+
+// validateRequired checks the settings serve cannot start without
+func validateRequired(cfg config.Config) error {
+	if cfg.ForwardDestination == "" {
+		return errors.Join(errors.New("FORWARD_DESTINATION is required - GoTrack operates as a transparent proxy"), validateSettings(cfg))
+	}
+	return validateSettings(cfg)
+}
+
+// validateSettings checks the settings the handler cannot be built without
+func validateSettings(cfg config.Config) error {
+	var errs []error
+	if cfg.HMACSecret == "" {
+		errs = append(errs, errors.New("HMAC_SECRET is required - GoTrack requires HMAC authentication for tracking"))
+	}
+	if cfg.DNTAction != httpx.DNTActionStrip && cfg.DNTAction != httpx.DNTActionDrop {
+		errs = append(errs, fmt.Errorf("DNT_ACTION must be %q or %q, got %q", httpx.DNTActionStrip, httpx.DNTActionDrop, cfg.DNTAction))
+	}
+	if err := httpx.ValidatePathPrefix(cfg.TrackingPathPrefix); err != nil {
+		errs = append(errs, fmt.Errorf("invalid TRACKING_PATH_PREFIX: %w", err))
+	}
+	if _, err := url.Parse(cfg.ForwardDestination); err != nil {
+		errs = append(errs, fmt.Errorf("invalid FORWARD_DESTINATION: %w", err))
+	}
+	if _, err := event.ParseTrustedProxies(cfg.TrustedProxyCIDRs); err != nil {
+		errs = append(errs, fmt.Errorf("invalid TRUSTED_PROXY_CIDRS: %w", err))
+	}
+	if _, err := outbound.NewPolicy(cfg.OutboundLinkSecret, cfg.OutboundAllowedHosts); err != nil {
+		errs = append(errs, fmt.Errorf("invalid OUTBOUND_ALLOWED_HOSTS: %w", err))
+	}
+	for _, path := range cfg.HoneypotPaths {
+		if err := httpx.ValidateHoneypotPath(path); err != nil {
+			errs = append(errs, fmt.Errorf("invalid HONEYPOT_PATHS: %w", err))
+		}
+	}
+	if cfg.HoneypotLinkPath != "" {
+		if err := httpx.ValidateHoneypotPath(cfg.HoneypotLinkPath); err != nil {
+			errs = append(errs, fmt.Errorf("invalid HONEYPOT_LINK_PATH: %w", err))
+		}
+	}
+	if _, err := initializeBotPolicy(cfg); err != nil {
+		errs = append(errs, fmt.Errorf("invalid BOT_RESPONSE: %w", err))
+	}
+	if cfg.AlertWebhookURL != "" {
+		if u, err := url.Parse(cfg.AlertWebhookURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("ALERT_WEBHOOK_URL must be an http(s) URL, got %q", cfg.AlertWebhookURL))
+		}
+		if cfg.AlertIntervalSeconds <= 0 {
+			errs = append(errs, errors.New("ALERT_INTERVAL must be positive"))
+		}
+	}
+	if cfg.WALDir != "" {
+		if cfg.WALSegmentMB <= 0 || cfg.WALCheckpointIntervalSec <= 0 || cfg.WALSyncMS < 0 || cfg.WALMaxMB < 0 {
+			errs = append(errs, errors.New("WAL_SEGMENT_MB and WAL_CHECKPOINT_INTERVAL must be positive, WAL_SYNC_MS and WAL_MAX_MB not negative"))
+		} else if cfg.WALMaxMB > 0 && cfg.WALMaxMB < cfg.WALSegmentMB {
+			errs = append(errs, fmt.Errorf("WAL_MAX_MB (%d) must be at least WAL_SEGMENT_MB (%d)", cfg.WALMaxMB, cfg.WALSegmentMB))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// startRegisteredSinks starts sinks registered by a program embedding the
+// server and returns their names, which must not clash with each other or
+// with a built-in output
+func startRegisteredSinks(ctx context.Context, registered []sink.Sink) ([]string, error) {
+	var names []string
+	for _, s := range registered {
+		name := s.Name()
+		if name == "" || slices.Contains(knownOutputs, name) || slices.Contains(names, name) {
+			return nil, fmt.Errorf("registered sink name %q is empty or already taken", name)
+		}
+		names = append(names, name)
+	}
+	for i, s := range registered {
+		if err := s.Start(ctx); err != nil {
+			closeSinks(registered[:i])
+			return nil, fmt.Errorf("failed to start %s sink: %w", s.Name(), err)
+		}
+	}
+	return names, nil
+}
+
+// initializeSinks starts the sinks named in outputs, skipping unknown names.
+// If one fails to start, those already started are closed again.
+func initializeSinks(ctx context.Context, outputs []string) ([]sink.Sink, error) {
+	var sinks []sink.Sink
+
+	for _, output := range outputs {
+		s, err := startSink(ctx, output)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		if s != nil {
+			sinks = append(sinks, s)
+		}
+	}
+
+	return sinks, nil
+}
+
+// startSink creates and starts the built-in sink named output, or returns
+// nil for an unknown name
+func startSink(ctx context.Context, output string) (sink.Sink, error) {
+	switch output {
+	case "log":
+		logSink := sink.NewLogSink()
+		if err := logSink.Start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start log sink: %w", err)
+		}
+		log.Println("log sink started")
+		return logSink, nil
+
+	case "kafka":
+		kafkaSink := sink.NewKafkaSinkFromEnv()
+		kafkaSink.Metrics = metrics.GetMetrics()
+		if err := kafkaSink.Start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start kafka sink: %w", err)
+		}
+		log.Println("kafka sink started")
+		return kafkaSink, nil
+
+	case "postgres":
+		pgSink := sink.NewPGSinkFromEnv()
+		pgSink.Metrics = metrics.GetMetrics()
+		if err := pgSink.Start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start postgres sink: %w", err)
+		}
+		log.Println("postgres sink started")
+		return pgSink, nil
+
+	case "relay":
+		relaySink := sink.NewRelaySinkFromEnv()
+		relaySink.Metrics = metrics.GetMetrics()
+		if err := relaySink.Start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start relay sink: %w", err)
+		}
+		log.Println("relay sink started")
+		return relaySink, nil
+
+	case "udp":
+		udpSink := sink.NewUDPSinkFromEnv()
+		if err := udpSink.Start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start udp sink: %w", err)
+		}
+		log.Println("udp sink started")
+		return udpSink, nil
+
+	case "syslog":
+		syslogSink, err := sink.NewSyslogSinkFromEnv()
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog sink config: %w", err)
+		}
+		if err := syslogSink.Start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start syslog sink: %w", err)
+		}
+		log.Println("syslog sink started")
+		return syslogSink, nil
+
+	case "meta_capi":
+		metaSink, err := sink.NewMetaSinkFromEnv()
+		if err != nil {
+			return nil, fmt.Errorf("invalid meta_capi sink config: %w", err)
+		}
+		metaSink.Metrics = metrics.GetMetrics()
+		if err := metaSink.Start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start meta_capi sink: %w", err)
+		}
+		log.Println("meta_capi sink started")
+		return metaSink, nil
+
+	case "google_ads":
+		adsSink, err := sink.NewGoogleAdsSinkFromEnv()
+		if err != nil {
+			return nil, fmt.Errorf("invalid google_ads sink config: %w", err)
+		}
+		adsSink.Metrics = metrics.GetMetrics()
+		if err := adsSink.Start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start google_ads sink: %w", err)
+		}
+		log.Println("google_ads sink started")
+		return adsSink, nil
+
+	case "pubsub":
+		pubsubSink := sink.NewPubSubSinkFromEnv()
+		if err := pubsubSink.Start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start pubsub sink: %w", err)
+		}
+		log.Println("pubsub sink started")
+		return pubsubSink, nil
+
+	case "kinesis":
+		kinesisSink, err := sink.NewKinesisSinkFromEnv()
+		if err != nil {
+			return nil, fmt.Errorf("invalid kinesis sink config: %w", err)
+		}
+		kinesisSink.Metrics = metrics.GetMetrics()
+		if err := kinesisSink.Start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start kinesis sink: %w", err)
+		}
+		log.Println("kinesis sink started")
+		return kinesisSink, nil
+
+	case "parquet":
+		parquetSink := sink.NewParquetSinkFromEnv()
+		if err := parquetSink.Start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start parquet sink: %w", err)
+		}
+		log.Println("parquet sink started")
+		return parquetSink, nil
+
+	case "null":
+		log.Println("null sink started (events are discarded)")
+		return sink.NewNullSink(), nil
+
+	default:
+		log.Printf("unknown output type: %s, skipping", output)
+		return nil, nil
+	}
+}
+
+func initializeHMACAuth(cfg config.Config) *httpx.HMACAuth {
+	var hmacAuth *httpx.HMACAuth
+	if cfg.HMACSecret != "" {
+		hmacAuth = httpx.NewHMACAuth(cfg.HMACSecret, cfg.HMACPublicKey)
+		if cfg.RequireHMAC {
+			log.Printf("HMAC authentication enabled and required for / endpoint")
+		} else {
+			log.Printf("HMAC authentication configured but not required")
+		}
+		log.Printf("HMAC client script available at /hmac.js")
+		log.Printf("HMAC public key available at /hmac/public-key")
+	}
+	return hmacAuth
+}
+
+// initializeTenants loads TENANTS_FILE. Without one GoTrack serves a single
+// site and returns a nil registry. Tenant outputs must name enabled sinks.
+func initializeTenants(cfg config.Config) (*httpx.Tenants, error) {
+	if cfg.TenantsFile == "" {
+		return nil, nil
+	}
+	defs, err := config.LoadTenants(cfg.TenantsFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTenantOutputs(defs, cfg.Outputs); err != nil {
+		return nil, err
+	}
+	tenants, err := httpx.NewTenants(defs)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("multi-tenant mode: %d sites, write key required on /collect and /px.gif", len(defs))
+	return tenants, nil
+}
+
+// validateTenantOutputs checks that tenants only route to enabled sinks
+func validateTenantOutputs(defs []config.Tenant, outputs []string) error {
+	enabled := make(map[string]bool, len(outputs))
+	for _, o := range outputs {
+		enabled[o] = true
+	}
+	for _, def := range defs {
+		for _, o := range def.Outputs {
+			if !enabled[o] {
+				return fmt.Errorf("tenant %s: output %q is not enabled in OUTPUTS", def.SiteID, o)
+			}
+		}
+	}
+	return nil
+}
+
+// initializeAPIKeys loads API_KEYS_FILE, returning nil without one. In
+// multi-tenant mode every key must send for a configured site.
+func initializeAPIKeys(cfg config.Config, tenants *httpx.Tenants) (*httpx.APIKeys, error) {
+	if cfg.APIKeysFile == "" {
+		return nil, nil
+	}
+	defs, err := config.LoadAPIKeys(cfg.APIKeysFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateAPIKeySites(defs, registrySites(tenants)); err != nil {
+		return nil, err
+	}
+	log.Printf("API keys enabled: %d keys accepted as bearer tokens on /collect", len(defs))
+	return httpx.NewAPIKeys(defs), nil
+}
+
+// validateAPIKeySites checks the site_id of each API key. hasSite is nil in
+// single-tenant mode, where keys must not name a site.
+func validateAPIKeySites(defs []config.APIKey, hasSite func(string) bool) error {
+	for _, def := range defs {
+		switch {
+		case hasSite == nil && def.SiteID != "":
+			return fmt.Errorf("API key %s: site_id needs TENANTS_FILE", def.Name)
+		case hasSite != nil && def.SiteID == "":
+			return fmt.Errorf("API key %s: site_id is required in multi-tenant mode", def.Name)
+		case hasSite != nil && !hasSite(def.SiteID):
+			return fmt.Errorf("API key %s: site %q is not in TENANTS_FILE", def.Name, def.SiteID)
+		}
+	}
+	return nil
+}
+
+// registrySites reports the sites of a tenant registry; nil in single-tenant mode
+func registrySites(tenants *httpx.Tenants) func(string) bool {
+	if tenants == nil {
+		return nil
+	}
+	return func(id string) bool {
+		_, ok := tenants.Site(id)
+		return ok
+	}
+}
+
+// initializeRouter parses OUTPUT_RULES. The router is created even without
+// rules so a reload can add some.
+func initializeRouter(cfg config.Config) (*routing.Router, error) {
+	rules, err := routing.Parse(cfg.OutputRules)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateRuleOutputs(rules, cfg.Outputs); err != nil {
+		return nil, err
+	}
+	if len(rules) > 0 {
+		log.Printf("output routing: %d rules", len(rules))
+	}
+	return routing.NewRouter(rules), nil
+}
+
+// validateRuleOutputs checks that rules only name enabled sinks
+func validateRuleOutputs(rules []routing.Rule, outputs []string) error {
+	for _, rule := range rules {
+		if !slices.Contains(outputs, rule.Sink) {
+			return fmt.Errorf("rule for %q: output is not enabled in OUTPUTS", rule.Sink)
+		}
+	}
+	return nil
+}
+
+// initializeTransforms loads TRANSFORMS_FILE. Without a file no pipeline is
+// created, and adding one needs a restart.
+func initializeTransforms(cfg config.Config) (*transform.Pipeline, error) {
+	if cfg.TransformsFile == "" {
+		return nil, nil
+	}
+	steps, err := transform.Load(cfg.TransformsFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTransformOutputs(steps, cfg.Outputs); err != nil {
+		return nil, err
+	}
+	log.Printf("event transforms: %d steps", len(steps))
+	return transform.New(steps)
+}
+
+// initializeExperiments loads EXPERIMENTS_FILE. Without a file no visitors
+// are assigned, and adding one needs a restart.
+func initializeExperiments(cfg config.Config) (*httpx.Experiments, error) {
+	if cfg.ExperimentsFile == "" {
+		return nil, nil
+	}
+	defs, err := experiment.Load(cfg.ExperimentsFile)
+	if err != nil {
+		return nil, err
+	}
+	assigner, err := experiment.New(defs)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := sessionConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("A/B experiments: %d defined", len(defs))
+	return httpx.NewExperiments(assigner, sc), nil
+}
+
+// validateTransformOutputs checks that steps only name enabled sinks
+func validateTransformOutputs(steps []transform.Step, outputs []string) error {
+	for i, step := range steps {
+		for _, o := range step.Sinks {
+			if !slices.Contains(outputs, o) {
+				return fmt.Errorf("transform %d: output %q is not enabled in OUTPUTS", i, o)
+			}
+		}
+	}
+	return nil
+}
+
+// initializeValidator builds the /collect event validator from the
+// VALIDATION_* settings. VALIDATION_POLICY=off disables validation.
+func initializeValidator(cfg config.Config) (*validation.Validator, error) {
+	if cfg.ValidationPolicy == "off" {
+		return nil, nil
+	}
+	policy, err := validation.ParsePolicy(cfg.ValidationPolicy)
+	if err != nil {
+		return nil, err
+	}
+	validator, err := validation.New(policy, validation.Rules{
+		Required:       cfg.ValidationRequired,
+		MaxFieldLength: int(cfg.ValidationMaxFieldLength),
+		EventTypes:     cfg.ValidationEventTypes,
+		MaxAge:         time.Duration(cfg.ValidationMaxAgeHours) * time.Hour,
+		MaxSkew:        time.Duration(cfg.ValidationMaxSkewMinutes) * time.Minute,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("event validation policy: %s", policy)
+	return validator, nil
+}
+
+// initializeStore opens the shared key/value store used by stateful features.
+// KV_BACKEND defaults to redis when REDIS_ADDR is set, otherwise memory.
+func initializeStore(ctx context.Context, cfg config.Config) (kv.Store, error) {
+	backend, err := storeBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	switch backend {
+	case kv.BackendRedis:
+		client := redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisAddr,
+			Password: cfg.RedisPassword,
+			DB:       int(cfg.RedisDB),
+		})
+		if err := client.Ping(pingCtx).Err(); err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("failed to connect to redis at %s: %w", cfg.RedisAddr, err)
+		}
+		log.Printf("shared state using redis at %s", cfg.RedisAddr)
+		return kv.NewRedisStore(client), nil
+
+	case kv.BackendPostgres:
+		store, err := kv.OpenPostgresStore(pingCtx, cfg.KVPostgresDSN)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open postgres store: %w", err)
+		}
+		go store.Run(ctx, time.Minute)
+		log.Printf("shared state using postgres")
+		return store, nil
+
+	default:
+		return kv.NewMemoryStore(), nil
+	}
+}
+
+// storeBackend resolves KV_BACKEND, which defaults to redis when REDIS_ADDR
+// is set and to memory otherwise, and checks the backend's settings
+func storeBackend(cfg config.Config) (string, error) {
+	backend := cfg.KVBackend
+	if backend == "" {
+		backend = kv.BackendMemory
+		if cfg.RedisAddr != "" {
+			backend = kv.BackendRedis
+		}
+	}
+	if err := kv.ValidBackend(backend); err != nil {
+		return "", err
+	}
+	switch {
+	case backend == kv.BackendRedis && cfg.RedisAddr == "":
+		return "", fmt.Errorf("KV_BACKEND=redis requires REDIS_ADDR")
+	case backend == kv.BackendPostgres && cfg.KVPostgresDSN == "":
+		return "", fmt.Errorf("KV_BACKEND=postgres requires KV_PG_DSN")
+	}
+	return backend, nil
+}
+
+// initializeTimingTracker selects the detection timing backend. A shared store
+// lets replicas see each other's requests; with the memory store the dedicated
+// in-memory tracker is used.
+func initializeTimingTracker(cfg config.Config, store kv.Store) detection.TimingTracker {
+	ttl := time.Duration(cfg.TimingTTLSeconds) * time.Second
+	if _, ok := store.(*kv.MemoryStore); ok || store == nil {
+		return detection.NewMemoryTimingTrackerWithTTL(ttl)
+	}
+	return detection.NewStoreTimingTracker(store, ttl)
+}
+
+// initializeTrapTracker selects where honeypot hits are remembered, like
+// initializeTimingTracker, so a client trapped on one replica is flagged on all
+func initializeTrapTracker(cfg config.Config, store kv.Store) detection.TrapTracker {
+	ttl := time.Duration(cfg.HoneypotTTLSeconds) * time.Second
+	if _, ok := store.(*kv.MemoryStore); ok || store == nil {
+		return detection.NewMemoryTrapTracker(ttl)
+	}
+	return detection.NewStoreTrapTracker(store, ttl)
+}
+
+// initializeRateTracker selects where request rates are counted, like
+// initializeTimingTracker, so replicas add up one client's requests
+func initializeRateTracker(cfg config.Config, store kv.Store) detection.RateTracker {
+	if _, ok := store.(*kv.MemoryStore); ok || store == nil {
+		return detection.NewMemoryRateTracker(int(cfg.RateMaxKeys))
+	}
+	return detection.NewStoreRateTracker(store)
+}
+
+// initializeFingerprintTracker selects where header fingerprint histories are
+// kept, like initializeTimingTracker, so one fingerprint's IPs are gathered
+// across replicas
+func initializeFingerprintTracker(cfg config.Config, store kv.Store) detection.FingerprintTracker {
+	ttl := time.Duration(cfg.FingerprintTTLSeconds) * time.Second
+	if _, ok := store.(*kv.MemoryStore); ok || store == nil {
+		return detection.NewMemoryFingerprintTracker(ttl, int(cfg.FingerprintMaxKeys))
+	}
+	return detection.NewStoreFingerprintTracker(store, ttl)
+}
+
+// initializeBotPolicy parses BOT_RESPONSE; nil when it is unset
+func initializeBotPolicy(cfg config.Config) (*httpx.BotPolicy, error) {
+	if len(cfg.BotResponses) == 0 {
+		return nil, nil
+	}
+	return httpx.NewBotPolicy(int(cfg.BotScoreThreshold), cfg.BotResponses, time.Duration(cfg.BotTarpitMS)*time.Millisecond)
+}
+
+// initializeWAL opens the write-ahead log in WAL_DIR, or returns nil when
+// events go straight to the sinks
+func initializeWAL(cfg config.Config) (*wal.Log, error) {
+	if cfg.WALDir == "" {
+		return nil, nil
+	}
+	l, err := wal.Open(cfg.WALDir, wal.Options{
+		SegmentBytes: cfg.WALSegmentMB << 20,
+		MaxBytes:     cfg.WALMaxMB << 20,
+		SyncInterval: time.Duration(cfg.WALSyncMS) * time.Millisecond,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pending := l.Pending(); pending > 0 {
+		log.Printf("write-ahead log in %s: replaying %d bytes of undelivered events", cfg.WALDir, pending)
+	} else {
+		log.Printf("write-ahead log in %s", cfg.WALDir)
+	}
+	return l, nil
+}
+
+// walEmit appends events to the write-ahead log for the dispatcher to
+// deliver to the configured sinks. Sinks added at runtime for debugging
+// aren't replayed from the log and get events straight away. When the log
+// is full or can't be written, events go straight to every sink as they
+// would without it.
+func walEmit(l *wal.Log, sinks *sink.Set, fanOut fanOutFunc, encode func(event.Event) ([]byte, error), m *metrics.Metrics) func(context.Context, event.Event) {
+	return func(ctx context.Context, ev event.Event) {
+		record, err := encode(ev)
+		if err == nil {
+			err = l.Append(record)
+		}
+		switch {
+		case err == nil:
+			if added := sinkNames(sinks.Added()); len(added) > 0 {
+				fanOut(ctx, ev, added)
+			}
+			return
+		case errors.Is(err, wal.ErrFull):
+			m.IncrementWALBypassed("full")
+		default:
+			log.Printf("wal: append failed, delivering directly: %v", err)
+			m.IncrementWALBypassed("error")
+		}
+		fanOut(ctx, ev, nil)
+	}
+}
+
+// walRecord encodes events for the write-ahead log, so that the log holds
+// no more than every sink would get: the region's click ID rules are
+// applied, and the IP is anonymized with the region's mode or, when no sink
+// overrides it, IP_PRIVACY_MODE. With field encryption on, the whole record
+// is encrypted.
+func walRecord(ipPolicy *privacy.Policy, regions *region.Policies, encryptor *fieldcrypt.Encryptor) func(event.Event) ([]byte, error) {
+	return func(ev event.Event) ([]byte, error) {
+		ev, regional := regions.Apply(ev)
+		mode := regional.IPMode()
+		if mode == "" {
+			mode, _ = ipPolicy.Shared()
+		}
+		if mode != "" && mode != privacy.ModeNone {
+			ev.Server.IP = ipPolicy.IP(mode, ev.Server.IP)
+		}
+		record, err := json.Marshal(ev)
+		if err != nil {
+			return nil, err
+		}
+		return encryptor.Seal(record), nil
+	}
+}
+
+// walDeliver decodes the log's records for the fan-out to one sink
+func walDeliver(fanOut fanOutFunc, encryptor *fieldcrypt.Encryptor) wal.DeliverFunc {
+	return func(ctx context.Context, record []byte, target string) error {
+		record, err := encryptor.Open(record)
+		if err != nil {
+			log.Printf("wal: dropping unreadable record: %v", err)
+			return nil
+		}
+		var ev event.Event
+		if err := json.Unmarshal(record, &ev); err != nil {
+			log.Printf("wal: dropping unreadable record: %v", err)
+			return nil
+		}
+		if failed := fanOut(ctx, ev, []string{target}); len(failed) > 0 {
+			return fmt.Errorf("%s refused the event", target)
+		}
+		return nil
+	}
+}
+
+// flushSink writes out what a sink buffers, so that the write-ahead log can
+// release the events it was handed
+func flushSink(sinks *sink.Set) wal.FlushFunc {
+	return func(ctx context.Context, name string) error {
+		m := sinks.Get(name)
+		if m == nil {
+			return nil
+		}
+		if f, ok := m.Sink.(sink.Flusher); ok {
+			_, err := f.Flush(ctx)
+			return err
+		}
+		return nil
+	}
+}
+
+func sinkNames(sinks []sink.Sink) []string {
+	names := make([]string, len(sinks))
+	for i, s := range sinks {
+		names[i] = s.Name()
+	}
+	return names
+}
+
+// reportWAL keeps gotrack_wal_pending_bytes and gotrack_wal_lag_bytes
+// current until ctx is done
+func reportWAL(ctx context.Context, l *wal.Log, targets []string, m *metrics.Metrics, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.SetWALPendingBytes(l.Pending())
+		for _, target := range targets {
+			m.SetWALLag(target, l.Lag(target))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// initializeCluster describes this replica for /cluster/info. With the memory
+// store each replica keeps its own sessions and detection state, so the load
+// balancer has to keep a visitor on one replica.
+func initializeCluster(cfg config.Config) *httpx.Cluster {
+	backend, _ := storeBackend(cfg) // checked by initializeStore
+	cluster := &httpx.Cluster{Version: Version, Started: time.Now(), StateBackend: backend}
+	log.Printf("cluster mode enabled (instance %s, %s state)", cfg.InstanceID, backend)
+	if backend == kv.BackendMemory {
+		log.Printf("warning: CLUSTER_MODE with the memory store keeps state per replica; route each visitor to one replica (see /cluster/info)")
+	}
+	return cluster
+}
+
+// alertMinEvents is how many events an interval needs before its bot share
+// can raise an alert, so a few bot hits on a quiet site don't
+const alertMinEvents = 100
+
+// initializeAlerts builds the alert monitor and, when bot spikes are
+// watched, the counter that must observe every emitted event
+func initializeAlerts(cfg config.Config, sinks []sink.Sink) (*alert.Monitor, *alert.BotShare) {
+	webhook := alert.NewWebhook(cfg.AlertWebhookURL)
+	monitor := alert.NewMonitor(webhook.Notify, cfg.InstanceID)
+
+	monitor.Add(alert.SinksDown(sinks, time.Duration(cfg.AlertSinkDownMinutes)*time.Minute))
+	if cfg.AlertBacklogEvents > 0 {
+		monitor.Add(alert.SinksBacklogged(sinks, int(cfg.AlertBacklogEvents)))
+	}
+	var botShare *alert.BotShare
+	if cfg.AlertBotPercent > 0 {
+		botShare = alert.NewBotShare(int(cfg.BotScoreThreshold), int(cfg.AlertBotPercent), alertMinEvents)
+		monitor.Add(botShare.Check)
+	}
+	if cfg.AlertCertDays > 0 && (cfg.EnableHTTPS || len(cfg.ACMEDomains) > 0) {
+		monitor.Add(alert.CertsExpiring(time.Duration(cfg.AlertCertDays)*24*time.Hour, servedCertificates(cfg)))
+	}
+	return monitor, botShare
+}
+
+// servedCertificates returns a loader for the certificates GoTrack serves:
+// SSL_CERT_FILE with ENABLE_HTTPS, or each ACME domain's certificate from
+// the ACME cache. Certificates not issued yet are left out.
+func servedCertificates(cfg config.Config) func(context.Context) map[string][]byte {
+	return func(ctx context.Context) map[string][]byte {
+		certs := make(map[string][]byte)
+		if len(cfg.ACMEDomains) > 0 {
+			cache := autocert.DirCache(cfg.ACMECacheDir)
+			for _, domain := range cfg.ACMEDomains {
+				if data, err := cache.Get(ctx, domain); err == nil {
+					certs[domain] = data
+				}
+			}
+			return certs
+		}
+		data, err := os.ReadFile(cfg.CertFile)
+		if err != nil {
+			log.Printf("alert: reading %s: %v", cfg.CertFile, err)
+			return certs
+		}
+		certs[cfg.CertFile] = data
+		return certs
+	}
+}
+
+// initializeDedup builds the duplicate filter, sharing its window across
+// replicas when the shared store is Redis or Postgres
+func initializeDedup(cfg config.Config, store kv.Store) (*dedup.Filter, error) {
+	action, err := dedup.ParseAction(cfg.DedupAction)
+	if err != nil {
+		return nil, err
+	}
+	window := time.Duration(cfg.DedupWindowSeconds) * time.Second
+	if window <= 0 {
+		return nil, fmt.Errorf("DEDUP_WINDOW must be positive")
+	}
+
+	var detector dedup.Detector
+	if _, ok := store.(*kv.MemoryStore); ok || store == nil {
+		detector = dedup.NewMemoryDetector(int(cfg.DedupMaxEntries), window)
+		log.Printf("event dedup enabled (%s, %s window, up to %d IDs in memory)", action, window, cfg.DedupMaxEntries)
+	} else {
+		detector = dedup.NewStoreDetector(store, window)
+		log.Printf("event dedup enabled (%s, %s window, shared store)", action, window)
+	}
+	return dedup.NewFilter(detector, action), nil
+}
+
+// initializeOrderDedup builds the /conversion order ID detector, sharing it
+// across replicas when the shared store is Redis or Postgres
+func initializeOrderDedup(cfg config.Config, store kv.Store) (dedup.Detector, error) {
+	if cfg.ConversionDedupDays < 0 {
+		return nil, fmt.Errorf("CONVERSION_DEDUP_DAYS must not be negative")
+	}
+	if cfg.ConversionDedupDays == 0 {
+		return nil, nil
+	}
+	window := time.Duration(cfg.ConversionDedupDays) * 24 * time.Hour
+	if _, ok := store.(*kv.MemoryStore); ok || store == nil {
+		return dedup.NewMemoryDetector(int(cfg.DedupMaxEntries), window), nil
+	}
+	return dedup.NewStoreDetectorWithPrefix(store, "order:", window), nil
+}
+
+// initializeRegions loads REGION_POLICY_FILE. Without a file every event is
+// handled alike.
+func initializeRegions(cfg config.Config) (*region.Policies, error) {
+	if cfg.RegionPolicyFile == "" {
+		return nil, nil
+	}
+	rules, err := region.Load(cfg.RegionPolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	action, err := consent.ParseAction(cfg.TCFAction)
+	if err != nil {
+		return nil, err
+	}
+	regions, err := region.New(rules, cfg.IPHashSecret != "", action != consent.ActionOff)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("region policies loaded from %s (%d rules)", cfg.RegionPolicyFile, len(rules))
+	return regions, nil
+}
+
+// initializeEncryption builds the field encryptor; nil when
+// FIELD_ENCRYPTION_FIELDS is empty
+func initializeEncryption(cfg config.Config) (*fieldcrypt.Encryptor, error) {
+	if len(cfg.FieldEncryptionFields) == 0 {
+		return nil, nil
+	}
+	var keys *fieldcrypt.Keyring
+	if cfg.FieldEncryptionKeys != "" || cfg.FieldEncryptionKeysFile != "" {
+		var err error
+		if keys, err = fieldcrypt.LoadKeys(cfg.FieldEncryptionKeys, cfg.FieldEncryptionKeysFile); err != nil {
+			return nil, err
+		}
+	}
+	encryptor, err := fieldcrypt.New(keys, cfg.FieldEncryptionFields, cfg.FieldEncryptionSkipSinks)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("field encryption enabled for %v (skipped for %v)", cfg.FieldEncryptionFields, cfg.FieldEncryptionSkipSinks)
+	return encryptor, nil
+}
+
+// initializeConsent builds the TCF consent policy; nil when TCF_ACTION=off
+func initializeConsent(cfg config.Config) (*consent.Policy, error) {
+	policy, err := consent.NewPolicy(cfg.TCFAction, cfg.TCFPurposes, cfg.TCFVendorID, cfg.TCFRequired)
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	log.Printf("TCF consent enforced (action %s, purposes %v)", policy.Action, policy.Purposes)
+	return policy, nil
+}
+
+// initializeReplayGuard builds the HMAC timestamp and nonce check, sharing
+// seen nonces across replicas when the shared store is Redis or Postgres
+func initializeReplayGuard(cfg config.Config, store kv.Store) (*httpx.ReplayGuard, error) {
+	if cfg.HMACReplayWindowSeconds < 0 {
+		return nil, fmt.Errorf("HMAC_REPLAY_WINDOW must not be negative")
+	}
+	if cfg.HMACReplayWindowSeconds == 0 {
+		if cfg.HMACSecret != "" {
+			log.Printf("HMAC replay protection off; set HMAC_REPLAY_WINDOW once all clients send X-GoTrack-TS and X-GoTrack-Nonce")
+		}
+		return nil, nil
+	}
+	window := time.Duration(cfg.HMACReplayWindowSeconds) * time.Second
+
+	// A timestamp at the far edge of the window stays acceptable for twice as long
+	var nonces dedup.Detector
+	if _, ok := store.(*kv.MemoryStore); ok || store == nil {
+		nonces = dedup.NewMemoryDetector(int(cfg.HMACNonceMaxEntries), 2*window)
+	} else {
+		nonces = dedup.NewStoreDetectorWithPrefix(store, "hmac-nonce:", 2*window)
+	}
+	return httpx.NewReplayGuard(window, nonces), nil
+}
+
+// initializeShortLinks keeps short links in the shared store. With the memory
+// store they are lost on restart and each replica only serves its own.
+func initializeShortLinks(cfg config.Config, store kv.Store) *shortlink.Store {
+	if _, ok := store.(*kv.MemoryStore); ok {
+		log.Printf("short links enabled at %s (memory store: links are lost on restart)", shortlink.PathPrefix)
+	} else {
+		log.Printf("short links enabled at %s", shortlink.PathPrefix)
+	}
+	if cfg.AdminToken == "" {
+		log.Printf("ADMIN_TOKEN is not set: short links are served but can't be created here")
+	}
+	return shortlink.NewStore(store)
+}
+
+// initializeSessions builds the server-side session manager on the shared store
+func initializeSessions(cfg config.Config, store kv.Store) (*session.Manager, error) {
+	sc, err := sessionConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("session cookies enabled (timeout %dm)", cfg.SessionTimeoutMinutes)
+	return session.NewManager(sc, store), nil
+}
+
+// initializeClickCookies builds the click ID cookie writer, which shares the
+// session cookies' attributes
+func initializeClickCookies(cfg config.Config) (*session.ClickCookies, error) {
+	sc, err := sessionConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("click ID cookies enabled (%d days)", cfg.ClickIDCookieDays)
+	return session.NewClickCookies(sc), nil
+}
+
+func sessionConfig(cfg config.Config) (session.Config, error) {
+	sameSite, err := session.ParseSameSite(cfg.SessionSameSite)
+	if err != nil {
+		return session.Config{}, err
+	}
+	return session.Config{
+		CookieDomain: cfg.SessionCookieDomain,
+		SameSite:     sameSite,
+		Secure:       cfg.SessionCookieSecure || servesTLS(cfg),
+		Timeout:      time.Duration(cfg.SessionTimeoutMinutes) * time.Minute,
+		MaxDuration:  time.Duration(cfg.SessionMaxHours) * time.Hour,
+		VisitorTTL:   time.Duration(cfg.VisitorCookieDays) * 24 * time.Hour,
+		ClickIDTTL:   time.Duration(cfg.ClickIDCookieDays) * 24 * time.Hour,
+	}, nil
+}
+
+func createEmitFunc(sinks *sink.Set, appMetrics *metrics.Metrics, ipPolicy *privacy.Policy, tenants *httpx.Tenants, router *routing.Router, transforms *transform.Pipeline, regions *region.Policies, encryptor *fieldcrypt.Encryptor, inspector *httpx.Inspector) func(context.Context, event.Event) {
+	fanOut := newFanOut(sinks, appMetrics, ipPolicy, tenants, router, transforms, regions, encryptor, inspector)
+	return func(ctx context.Context, ev event.Event) { fanOut(ctx, ev, nil) }
+}
+
+// fanOutFunc sends an event to the sinks named in only, or to every sink
+// when only is nil, and returns the names of those that refused it
+type fanOutFunc func(ctx context.Context, ev event.Event, only []string) (failed []string)
+
+func newFanOut(sinks *sink.Set, appMetrics *metrics.Metrics, ipPolicy *privacy.Policy, tenants *httpx.Tenants, router *routing.Router, transforms *transform.Pipeline, regions *region.Policies, encryptor *fieldcrypt.Encryptor, inspector *httpx.Inspector) fanOutFunc {
+	return func(ctx context.Context, ev event.Event, only []string) (failed []string) {
+		// Send event to the sinks its site, the output rules and its region
+		// route to, anonymizing the IP, applying the transforms and encrypting
+		// sensitive fields per sink. Paused sinks only count the event.
+		ev, regional := regions.Apply(ev)
+		for _, m := range sinks.Members() {
+			s := m.Sink
+			if only != nil && !slices.Contains(only, s.Name()) {
+				continue
+			}
+			if !tenants.Routes(ev.SiteID, s.Name()) || !router.Allows(s.Name(), ev) || regional.Skips(s.Name()) {
+				continue
+			}
+			if m.Paused() {
+				m.Skip()
+				continue
+			}
+			out := transforms.Apply(s.Name(), regional.ApplyIP(ipPolicy, s.Name(), ev))
+			out = encryptor.Apply(s.Name(), out)
+			err := enqueue(ctx, s, out)
+			m.Record(err)
+			if err != nil {
+				log.Printf("failed to enqueue event to sink: %v", err)
+				inspector.RecordError(httpx.InspectorError{Source: "sink:" + s.Name(), Message: err.Error()})
+				// Track sink errors in metrics
+				appMetrics.IncrementSinkErrors(s.Name(), "enqueue_error")
+				failed = append(failed, s.Name())
+			} else {
+				// Track successful ingestion
+				appMetrics.IncrementEventsIngested(s.Name(), ev.SiteID)
+			}
+		}
+		return failed
+	}
+}
+
+// enqueue hands ev to s, passing the request context to sinks that trace their writes
+func enqueue(ctx context.Context, s sink.Sink, ev event.Event) error {
+	if ce, ok := s.(sink.ContextEnqueuer); ok {
+		return ce.EnqueueContext(ctx, ev)
+	}
+	return s.Enqueue(ev)
+}
+
+// sinkLoad returns a probe reporting the deepest queue and slowest flush
+// across sinks that buffer events
+func sinkLoad(sinks []sink.Sink) func() (int, time.Duration) {
+	return func() (int, time.Duration) {
+		var depth int
+		var latency time.Duration
+		for _, s := range sinks {
+			if lr, ok := s.(sink.LoadReporter); ok {
+				d, l := lr.Load()
+				depth = max(depth, d)
+				latency = max(latency, l)
+			}
+		}
+		return depth, latency
+	}
+}
+
+// sinkQueueInterval is how often the queue gauges are refreshed
+const sinkQueueInterval = 5 * time.Second
+
+// reportSinkQueues keeps gotrack_queue_depth and
+// gotrack_queue_oldest_age_seconds current for sinks that buffer events,
+// until ctx is done
+func reportSinkQueues(ctx context.Context, sinks []sink.Sink, m *metrics.Metrics, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		updateSinkQueues(sinks, m, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func updateSinkQueues(sinks []sink.Sink, m *metrics.Metrics, now time.Time) {
+	for _, s := range sinks {
+		if lr, ok := s.(sink.LoadReporter); ok {
+			depth, _ := lr.Load()
+			m.SetQueueDepth(s.Name(), float64(depth))
+		}
+		if br, ok := s.(sink.BacklogReporter); ok {
+			var age time.Duration
+			if oldest := br.OldestPending(); !oldest.IsZero() {
+				age = now.Sub(oldest)
+			}
+			m.SetQueueAge(s.Name(), age)
+		}
+	}
+}
+
+// observeEmit wraps an emit function so observers see every event before it reaches the sinks
+func observeEmit(emit func(context.Context, event.Event), observers ...func(event.Event)) func(context.Context, event.Event) {
+	return func(ctx context.Context, ev event.Event) {
+		for _, observe := range observers {
+			observe(ev)
+		}
+		emit(ctx, ev)
+	}
+}
+
+// initializeInjector builds the injection template and page filters, or
+// returns nil when proxied pages get the built-in snippet everywhere
+func initializeInjector(cfg config.Config) (*httpx.Injector, error) {
+	if cfg.InjectTemplateFile == "" && len(cfg.InjectPaths) == 0 && len(cfg.InjectExcludePaths) == 0 && cfg.InjectCSP == "" {
+		return nil, nil
+	}
+	return httpx.NewInjector(httpx.InjectorConfig{
+		TemplateFile: cfg.InjectTemplateFile,
+		Paths:        cfg.InjectPaths,
+		ExcludePaths: cfg.InjectExcludePaths,
+		CSPMode:      cfg.InjectCSP,
+	})
+}
+
+// initializeProxyCache opens the PROXY_CACHE backend for proxied responses,
+// or returns nil when caching is off
+func initializeProxyCache(cfg config.Config, m *metrics.Metrics) (*proxycache.Cache, error) {
+	if cfg.ProxyCache == "" {
+		return nil, nil
+	}
+	if err := validateProxyCache(cfg); err != nil {
+		return nil, err
+	}
+
+	var store proxycache.Store = proxycache.NewMemoryStore()
+	if cfg.ProxyCache == proxycache.BackendDisk {
+		disk, err := proxycache.OpenDiskStore(cfg.ProxyCacheDir)
+		if err != nil {
+			return nil, err
+		}
+		store = disk
+	}
+	cache, err := proxycache.New(store, cfg.ProxyCacheMaxBytes, m)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("proxy cache: %s, %d bytes, %d entries restored", cfg.ProxyCache, cfg.ProxyCacheMaxBytes, cache.Len())
+	return cache, nil
+}
+
+// validateProxyCache checks PROXY_CACHE without opening its store
+func validateProxyCache(cfg config.Config) error {
+	if err := proxycache.ValidBackend(cfg.ProxyCache); err != nil {
+		return err
+	}
+	if cfg.ForwardDestination == "" {
+		return fmt.Errorf("PROXY_CACHE requires FORWARD_DESTINATION")
+	}
+	return nil
+}
+
+// initializeACME returns a manager obtaining and renewing certificates for
+// ACME_DOMAINS, or nil when certificates come from files
+func initializeACME(cfg config.Config) (*autocert.Manager, error) {
+	if len(cfg.ACMEDomains) == 0 {
+		return nil, nil
+	}
+	if cfg.EnableHTTPS {
+		return nil, fmt.Errorf("ACME_DOMAINS replaces ENABLE_HTTPS; set only one")
+	}
+	if cfg.ACMECacheDir == "" {
+		return nil, fmt.Errorf("ACME_CACHE_DIR is required so certificates survive restarts")
+	}
+	certs := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+		Cache:      autocert.DirCache(cfg.ACMECacheDir),
+		Email:      cfg.ACMEEmail,
+	}
+	if cfg.ACMEDirectoryURL != "" {
+		certs.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+	}
+	return certs, nil
+}
+
+// startHTTPServer serves the routes of l, with TLS from certs or from
+// SSL_CERT_FILE and SSL_KEY_FILE when l is an HTTPS listener. An error
+// other than shutdown is sent on errs.
+func startHTTPServer(cfg config.Config, l listener, env httpx.Env, certs *autocert.Manager, errs chan<- error) *http.Server {
+	env.Routes = l.Routes
+	srv := &http.Server{
+		Addr:              l.Addr,
+		Handler:           httpx.NewMux(env),
+		ReadHeaderTimeout: 10 * time.Second, // Prevent Slowloris attacks
+	}
+	// Live tails never go idle, so end them when shutdown starts
+	srv.RegisterOnShutdown(env.Inspector.Close)
+	if l.TLS && certs != nil {
+		// TLS-ALPN-01 challenges are answered by the TLS config itself
+		srv.TLSConfig = certs.TLSConfig()
+	}
+
+	go func() {
+		switch {
+		case l.TLS && certs != nil:
+			log.Printf("gotrack listening on %s (HTTPS, ACME certificates for %s%s)", l.Addr, strings.Join(cfg.ACMEDomains, ", "), l.describeRoutes())
+			if err := serveTLS(srv, "", ""); err != nil && err != http.ErrServerClosed {
+				errs <- fmt.Errorf("HTTPS server error: %w", err)
+			}
+		case l.TLS:
+			log.Printf("gotrack listening on %s (HTTPS%s)", l.Addr, l.describeRoutes())
+			if err := serveTLS(srv, cfg.CertFile, cfg.KeyFile); err != nil && err != http.ErrServerClosed {
+				errs <- fmt.Errorf("HTTPS server error: %w", err)
+			}
+		default:
+			log.Printf("gotrack listening on %s (HTTP%s)", l.Addr, l.describeRoutes())
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errs <- fmt.Errorf("HTTP server error: %w", err)
+			}
+		}
+	}()
+
+	return srv
+}
+
+// serveTLS is srv.ListenAndServeTLS on a listener that records each
+// client's TLS ClientHello, so events carry its JA3 and JA4 fingerprints
+func serveTLS(srv *http.Server, certFile, keyFile string) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	srv.ConnContext = detection.ClientHelloContext
+	return srv.ServeTLS(detection.ListenClientHellos(ln), certFile, keyFile)
+}
+
+// acmeHTTPServer answers HTTP-01 challenges on addr and hands every other
+// request to the tracking and proxy router, so plain HTTP keeps working
+func acmeHTTPServer(addr string, certs *autocert.Manager, router http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           certs.HTTPHandler(router),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// waitForShutdown waits for SIGINT or SIGTERM, or for a server to fail,
+// then drains and shuts down. It returns the server's error, if any.
+func waitForShutdown(servers []*http.Server, in *instance, serveErrs <-chan error) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	var serveErr error
+	select {
+	case <-stop:
+	case serveErr = <-serveErrs:
+	}
+
+	// Refuse new events and flush sink buffers while the listener is still
+	// up, so health checks see the instance draining rather than gone
+	log.Println("draining...")
+	in.drainer.Drain(context.Background())
+
+	log.Println("shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		_ = srv.Shutdown(shutdownCtx)
+	}
+	in.close(shutdownCtx)
+	return serveErr
+}
+
+// close stops the metrics server, closes the sinks and shared state and
+// stops background work, after the instance has drained
+func (in *instance) close(ctx context.Context) {
+	if err := in.metricsServer.Shutdown(ctx); err != nil {
+		log.Printf("error shutting down metrics server: %v", err)
+	}
+
+	// Stop replaying the write-ahead log while the sinks can still flush;
+	// what is left is delivered after the next start
+	if in.dispatcher != nil {
+		in.dispatcher.Close()
+		if err := in.walLog.Close(); err != nil {
+			log.Printf("error closing write-ahead log: %v", err)
+		}
+	}
+
+	closeSinks(in.sinks)
+	closeSinks(in.env.SinkSet.Added()) // debugging sinks added through the admin API
+
+	if err := in.store.Close(); err != nil {
+		log.Printf("error closing shared state store: %v", err)
+	}
+
+	// Export spans from the final sink flushes
+	if err := in.shutdownTracing(ctx); err != nil {
+		log.Printf("error shutting down tracing: %v", err)
+	}
+	in.cancel()
+	running.Store(false)
+
+	log.Println("shutdown complete")
+}
+
+// healthTarget is the endpoint probed by -healthcheck
+type healthTarget struct {
+	Scheme     string
+	Host       string
+	Port       string
+	Path       string
+	ServerName string // TLS server name, when it differs from Host
+	Insecure   bool   // skip certificate verification
+}
+
+// healthTargetFor fills in what the flags left empty from the server
+// configuration, so the Docker HEALTHCHECK follows SERVER_ADDR and HTTPS.
+// With LISTENERS the first listener is probed.
+func healthTargetFor(cfg config.Config, t healthTarget) healthTarget {
+	first := listener{Addr: cfg.ServerAddr}
+	if listeners, err := initializeListeners(cfg); err == nil {
+		first = listeners[0]
+	}
+	host, port, err := net.SplitHostPort(first.Addr)
+	if err != nil {
+		host, port = "", "19890"
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "" // listening on all interfaces
+	}
+	if t.Host == "" {
+		t.Host = cmp.Or(host, "localhost")
+	}
+	if t.Port == "" {
+		t.Port = port
+	}
+	if t.Scheme == "" {
+		t.Scheme = "http"
+		if first.TLS {
+			t.Scheme = "https"
+		}
+	}
+	if t.Path == "" {
+		t.Path = "/healthz"
+	}
+	// ACME certificates are only served for their domains
+	if t.Scheme == "https" && len(cfg.ACMEDomains) > 0 {
+		t.ServerName = cfg.ACMEDomains[0]
+	}
+	return t
+}
+
+// performHealthCheck requests the health endpoint and expects "ok"
+func performHealthCheck(t healthTarget) error {
+	// Create HTTP client with timeout
+	client := &http.Client{
+		Timeout: 3 * time.Second,
+	}
+	if t.Scheme == "https" {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{
+			ServerName:         t.ServerName,
+			InsecureSkipVerify: t.Insecure,
+		}}
+	}
+
+	// Construct health check URL
+	url := fmt.Sprintf("%s://%s%s", t.Scheme, net.JoinHostPort(t.Host, t.Port), t.Path)
+
+	// Perform health check request
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to connect to health endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check status code
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+
+	// Read and verify response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read health check response: %w", err)
+	}
+
+	// Verify expected response
+	if string(body) != "ok" {
+		return fmt.Errorf("unexpected health check response: %s", string(body))
+	}
+
+	return nil
+}
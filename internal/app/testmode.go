@@ -1,11 +1,12 @@
-package main
+package app
 
 import (
+	"context"
 	"log"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/shortontech/gotrack/internal/event"
+	"github.com/shortontech/gotrack/pkg/event"
 )
 
 // generateTestEvents creates sample events for testing sinks
@@ -165,14 +166,14 @@ func generateTestEvents() []event.Event {
 }
 
 // runTestMode generates and sends test events
-func runTestMode(emitFn func(event.Event)) {
+func runTestMode(emitFn func(context.Context, event.Event)) {
 	log.Println("🧪 TEST MODE: Generating test events...")
 
 	events := generateTestEvents()
 
 	for i, e := range events {
 		log.Printf("📊 Sending test event %d/%d: %s (%s)", i+1, len(events), e.Type, e.EventID)
-		emitFn(e)
+		emitFn(context.Background(), e)
 
 		// Small delay between events to see them clearly in logs
 		if i < len(events)-1 {
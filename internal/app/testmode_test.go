@@ -1,9 +1,10 @@
-package main
+package app
 
 import (
+	"context"
 	"testing"
 
-	"github.com/shortontech/gotrack/internal/event"
+	"github.com/shortontech/gotrack/pkg/event"
 )
 
 // TestGenerateTestEvents tests the test event generation
@@ -139,7 +140,7 @@ func verifyFifthEvent(t *testing.T, e event.Event) {
 func TestRunTestMode(t *testing.T) {
 	t.Run("sends events to emit function", func(t *testing.T) {
 		var receivedEvents []event.Event
-		emitFunc := func(e event.Event) {
+		emitFunc := func(_ context.Context, e event.Event) {
 			receivedEvents = append(receivedEvents, e)
 		}
 
@@ -195,7 +196,7 @@ func TestBoolPtr(t *testing.T) {
 	t.Run("different pointers for same value", func(t *testing.T) {
 		ptr1 := boolPtr(true)
 		ptr2 := boolPtr(true)
-		
+
 		// They should have the same value but different addresses
 		if *ptr1 != *ptr2 {
 			t.Error("both pointers should point to true")
@@ -317,15 +318,15 @@ func TestGenerateTestEvents_GeoData(t *testing.T) {
 	if events[0].Server.Geo == nil {
 		t.Error("first event should have geo data")
 	}
-	
+
 	if events[0].Server.Geo["country"] != "US" {
 		t.Errorf("expected country US, got %s", events[0].Server.Geo["country"])
 	}
-	
+
 	if events[0].Server.Geo["region"] != "CA" {
 		t.Errorf("expected region CA, got %s", events[0].Server.Geo["region"])
 	}
-	
+
 	if events[0].Server.Geo["city"] != "San Francisco" {
 		t.Errorf("expected city San Francisco, got %s", events[0].Server.Geo["city"])
 	}
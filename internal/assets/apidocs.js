@@ -0,0 +1,11 @@
+// Swagger UI for the document at /openapi.json. Use Authorize with the
+// admin token to try the admin routes.
+(function () {
+  "use strict";
+  window.ui = SwaggerUIBundle({
+    url: "/openapi.json",
+    dom_id: "#swagger-ui",
+    deepLinking: true,
+    persistAuthorization: false,
+  });
+})();
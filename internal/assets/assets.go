@@ -10,3 +10,19 @@ var PixelUMDJS []byte
 
 //go:embed pixel.esm.js
 var PixelESMJS []byte
+
+// Admin dashboard served under /_gotrack/admin/ui
+
+//go:embed dashboard.html
+var DashboardHTML []byte
+
+//go:embed dashboard.js
+var DashboardJS []byte
+
+// API docs (Swagger UI) served under /_gotrack/admin/docs
+
+//go:embed apidocs.html
+var APIDocsHTML []byte
+
+//go:embed apidocs.js
+var APIDocsJS []byte
@@ -0,0 +1,190 @@
+// GoTrack admin dashboard. Everything the server sends is rendered with
+// textContent: event fields come from clients and must never become markup.
+(function () {
+  "use strict";
+
+  var base = location.pathname.replace(/\/admin\/ui\/?$/, "/admin/");
+  var tailURL = base.replace(/admin\/$/, "debug/tail");
+  var tokenKey = "gotrack-admin-token";
+  var maxEvents = 200;
+  var token = sessionStorage.getItem(tokenKey);
+  var paused = false;
+  var received = 0;
+  var tail = null;
+  var statusTimer = null;
+
+  function $(id) { return document.getElementById(id); }
+
+  function el(tag, text, className) {
+    var node = document.createElement(tag);
+    if (text !== undefined) node.textContent = text;
+    if (className) node.className = className;
+    return node;
+  }
+
+  function api(url, init) {
+    init = init || {};
+    init.headers = { Authorization: "Bearer " + token };
+    return fetch(url, init).then(function (res) {
+      if (res.status === 401) {
+        logout("Invalid admin token");
+        throw new Error("unauthorized");
+      }
+      return res;
+    });
+  }
+
+  function showLogin(message) {
+    $("dashboard").hidden = true;
+    $("logout").hidden = true;
+    $("login").hidden = false;
+    $("login-error").textContent = message || "";
+    $("stream-state").textContent = "";
+  }
+
+  function logout(message) {
+    sessionStorage.removeItem(tokenKey);
+    token = null;
+    if (tail) tail.abort();
+    clearInterval(statusTimer);
+    showLogin(message);
+  }
+
+  function start() {
+    $("login").hidden = true;
+    $("dashboard").hidden = false;
+    $("logout").hidden = false;
+    refreshStatus();
+    statusTimer = setInterval(refreshStatus, 5000);
+    connect();
+  }
+
+  function refreshStatus() {
+    api(base + "status").then(function (res) { return res.json(); }).then(renderStatus).catch(function () {});
+  }
+
+  function renderStatus(status) {
+    $("draining").hidden = !status.draining;
+
+    var sinks = $("sinks");
+    sinks.replaceChildren();
+    status.sinks.forEach(function (s) {
+      var row = el("tr");
+      row.appendChild(el("td", s.name));
+      var state = el("td", s.status, s.status === "ok" ? "ok" : "bad");
+      if (s.error) state.title = s.error;
+      row.appendChild(state);
+      row.appendChild(el("td", s.queue_depth === undefined ? "-" : String(s.queue_depth), "num"));
+      row.appendChild(el("td", s.flush_latency_ms ? s.flush_latency_ms.toFixed(1) + " ms" : "-", "num"));
+      sinks.appendChild(row);
+    });
+
+    var errors = $("errors");
+    errors.replaceChildren();
+    if (status.errors.length === 0) {
+      var empty = el("tr");
+      var cell = el("td", "No errors", "muted");
+      cell.colSpan = 3;
+      empty.appendChild(cell);
+      errors.appendChild(empty);
+    }
+    status.errors.forEach(function (e) {
+      var row = el("tr");
+      row.appendChild(el("td", new Date(e.time).toLocaleTimeString()));
+      row.appendChild(el("td", e.status ? e.source + " " + e.status : e.source));
+      var message = el("td", e.message);
+      if (e.request_id) message.title = "request " + e.request_id;
+      row.appendChild(message);
+      errors.appendChild(row);
+    });
+  }
+
+  // connect reads the server-sent event stream with fetch, since EventSource
+  // cannot send the Authorization header
+  function connect() {
+    if (!token) return;
+    tail = new AbortController();
+    var filter = $("type-filter").value.trim();
+    var url = tailURL + (filter ? "?type=" + encodeURIComponent(filter) : "");
+    $("stream-state").textContent = "connecting…";
+
+    api(url, { signal: tail.signal }).then(function (res) {
+      if (!res.ok || !res.body) throw new Error("tail failed: " + res.status);
+      $("stream-state").textContent = "live";
+      // The stream starts with the recent events again
+      $("events").replaceChildren();
+      var reader = res.body.getReader();
+      var decoder = new TextDecoder();
+      var buffer = "";
+      function read() {
+        return reader.read().then(function (chunk) {
+          if (chunk.done) throw new Error("stream ended");
+          buffer += decoder.decode(chunk.value, { stream: true });
+          var messages = buffer.split("\n\n");
+          buffer = messages.pop();
+          messages.forEach(handleMessage);
+          return read();
+        });
+      }
+      return read();
+    }).catch(function (err) {
+      if (err.name === "AbortError" || !token) return;
+      $("stream-state").textContent = "disconnected, retrying…";
+      setTimeout(connect, 3000);
+    });
+  }
+
+  function reconnect() {
+    if (tail) tail.abort();
+    connect();
+  }
+
+  function handleMessage(message) {
+    var data = message.split("\n").filter(function (line) {
+      return line.indexOf("data: ") === 0;
+    }).map(function (line) {
+      return line.slice(6);
+    }).join("\n");
+    if (!data || paused) return;
+    var ev;
+    try { ev = JSON.parse(data); } catch (e) { return; }
+    addEvent(ev);
+  }
+
+  function addEvent(ev) {
+    var item = el("li");
+    var details = el("details");
+    var when = ev.ts ? new Date(ev.ts).toLocaleTimeString() : "";
+    var url = ev.url ? " " + ev.url : "";
+    details.appendChild(el("summary", when + " " + (ev.type || "(no type)") + url));
+    details.appendChild(el("pre", JSON.stringify(ev, null, 2)));
+    item.appendChild(details);
+
+    var list = $("events");
+    list.insertBefore(item, list.firstChild);
+    while (list.children.length > maxEvents) list.removeChild(list.lastChild);
+    received++;
+    $("event-count").textContent = received + " received";
+  }
+
+  $("login-form").addEventListener("submit", function (e) {
+    e.preventDefault();
+    token = $("token").value;
+    sessionStorage.setItem(tokenKey, token);
+    $("token").value = "";
+    start();
+  });
+  $("logout").addEventListener("click", function () { logout(); });
+  $("pause").addEventListener("click", function () {
+    paused = !paused;
+    $("pause").textContent = paused ? "Resume" : "Pause";
+  });
+  $("clear").addEventListener("click", function () {
+    $("events").replaceChildren();
+    received = 0;
+    $("event-count").textContent = "";
+  });
+  $("type-filter").addEventListener("change", reconnect);
+
+  if (token) start(); else showLogin();
+})();
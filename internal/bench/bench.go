@@ -0,0 +1,103 @@
+// Package bench measures stages of the ingest path one event at a time:
+// throughput, allocations per event and the latency distribution.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// Stage is one step of the ingest path. Run is called once per event with
+// the event's index.
+type Stage struct {
+	Name string
+	Run  func(i int) error
+	// Done, if set, is called after the last event and counted in the
+	// elapsed time, for example to flush a buffering sink
+	Done func(ctx context.Context) error
+}
+
+// Result is the measurement of one stage
+type Result struct {
+	Name        string
+	Events      int
+	Errors      int
+	Elapsed     time.Duration
+	AllocsPer   float64 // heap allocations per event
+	BytesPer    float64 // heap bytes allocated per event
+	P50, P99    time.Duration
+	Max         time.Duration
+	FinishError error // from Done
+}
+
+// EventsPerSec returns the stage's throughput
+func (r Result) EventsPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Events) / r.Elapsed.Seconds()
+}
+
+// Measure runs s for n events after warmup events that aren't measured.
+// Allocation counts cover the whole process, so measure one stage at a time.
+func Measure(ctx context.Context, s Stage, n, warmup int) Result {
+	for i := range warmup {
+		_ = s.Run(i)
+	}
+
+	latencies := make([]time.Duration, n)
+	res := Result{Name: s.Name, Events: n}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := range n {
+		opStart := time.Now()
+		if err := s.Run(warmup + i); err != nil {
+			res.Errors++
+		}
+		latencies[i] = time.Since(opStart)
+	}
+	if s.Done != nil {
+		res.FinishError = s.Done(ctx)
+	}
+	res.Elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	if n > 0 {
+		res.AllocsPer = float64(after.Mallocs-before.Mallocs) / float64(n)
+		res.BytesPer = float64(after.TotalAlloc-before.TotalAlloc) / float64(n)
+		slices.Sort(latencies)
+		res.P50 = percentile(latencies, 0.50)
+		res.P99 = percentile(latencies, 0.99)
+		res.Max = latencies[n-1]
+	}
+	return res
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// WriteReport prints results as an aligned table
+func WriteReport(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "stage\tevents/s\tallocs/event\tB/event\tp50\tp99\tmax\terrors\t")
+	for _, r := range results {
+		errs := fmt.Sprint(r.Errors)
+		if r.FinishError != nil {
+			errs += " (" + r.FinishError.Error() + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%.0f\t%.1f\t%.0f\t%s\t%s\t%s\t%s\t\n",
+			r.Name, r.EventsPerSec(), r.AllocsPer, r.BytesPer, r.P50, r.P99, r.Max, errs)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,80 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeasure(t *testing.T) {
+	var calls []int
+	var flushed bool
+	res := Measure(context.Background(), Stage{
+		Name: "test",
+		Run: func(i int) error {
+			calls = append(calls, i)
+			if i == 7 {
+				return errors.New("boom")
+			}
+			return nil
+		},
+		Done: func(context.Context) error { flushed = true; return nil },
+	}, 10, 3)
+
+	if len(calls) != 13 || calls[0] != 0 || calls[3] != 3 || calls[12] != 12 {
+		t.Errorf("calls = %v, want indexes 0-12 across warmup and measurement", calls)
+	}
+	if res.Events != 10 || res.Errors != 1 || !flushed {
+		t.Errorf("result = %+v, flushed %v", res, flushed)
+	}
+	if res.AllocsPer < 0 || res.P50 > res.P99 || res.P99 > res.Max || res.EventsPerSec() <= 0 {
+		t.Errorf("inconsistent result %+v", res)
+	}
+}
+
+func TestMeasureAllocations(t *testing.T) {
+	var sink [][]byte
+	res := Measure(context.Background(), Stage{Name: "alloc", Run: func(int) error {
+		sink = append(sink[:0], make([]byte, 1024))
+		return nil
+	}}, 1000, 10)
+	if res.AllocsPer < 1 || res.BytesPer < 1024 {
+		t.Errorf("allocs/event = %.1f, bytes/event = %.0f; want at least 1 and 1024", res.AllocsPer, res.BytesPer)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	if got := percentile(sorted, 0.5); got != 50*time.Millisecond {
+		t.Errorf("p50 = %v", got)
+	}
+	if got := percentile(sorted, 0.99); got != 99*time.Millisecond {
+		t.Errorf("p99 = %v", got)
+	}
+	if got := percentile(sorted[:1], 0.99); got != time.Millisecond {
+		t.Errorf("p99 of one = %v", got)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteReport(&buf, []Result{
+		{Name: "enrich", Events: 1000, Elapsed: time.Millisecond, AllocsPer: 12, P99: time.Microsecond},
+		{Name: "sink/kafka", Events: 10, Elapsed: time.Second, FinishError: errors.New("flush timed out")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"events/s", "enrich", "1000000", "12.0", "sink/kafka", "flush timed out"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Package campaign checks campaign landing URLs the way GoTrack will read
+// them, so broken UTM links are caught before they are shipped.
+package campaign
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// utmParams are the UTM parameters GoTrack stores, in the order they are
+// reported as missing
+var utmParams = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content", "utm_id", "utm_campaign_id"}
+
+// otherUTMParams are valid GA4 parameters GoTrack doesn't store
+var otherUTMParams = []string{"utm_source_platform", "utm_creative_format", "utm_marketing_tactic"}
+
+// Report is how GoTrack parses a campaign URL
+type Report struct {
+	URL      string            `json:"url"`
+	Hostname string            `json:"hostname"`
+	Path     string            `json:"path"`
+	UTM      event.UTMInfo     `json:"utm"`
+	ClickIDs map[string]string `json:"click_ids,omitempty"`
+	Channel  string            `json:"channel"`
+	Warnings []Warning         `json:"warnings"`
+}
+
+// Warning is a problem with one parameter, or with the URL when Param is
+// empty
+type Warning struct {
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// Inspect parses raw with the same code that fills events' url fields and
+// reports what a visit through it would record. URLs that are not absolute
+// http or https URLs are an error.
+func Inspect(raw string) (Report, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return Report{}, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Report{}, errors.New("want an absolute http or https URL")
+	}
+
+	var ev event.Event
+	event.ApplyPageURL(&ev, u.String())
+	ev.URL.Channel = event.Channel(ev)
+
+	r := Report{
+		URL:      u.String(),
+		Hostname: strings.TrimSuffix(strings.ToLower(u.Hostname()), "."),
+		Path:     ev.Route.Path,
+		UTM:      ev.URL.UTM,
+		ClickIDs: clickIDs(ev),
+		Channel:  ev.URL.Channel,
+		Warnings: []Warning{},
+	}
+	if r.Path == "" {
+		r.Path = "/"
+	}
+	r.Warnings = append(r.Warnings, queryWarnings(u, len(r.ClickIDs) > 0)...)
+	r.Warnings = append(r.Warnings, fragmentWarnings(u)...)
+	return r, nil
+}
+
+// clickIDs collects the ad click IDs the event recorded
+func clickIDs(ev event.Event) map[string]string {
+	ids := map[string]string{}
+	for param, value := range map[string]string{
+		"gclid":   ev.URL.Google.GCLID,
+		"gclsrc":  ev.URL.Google.GCLSRC,
+		"gbraid":  ev.URL.Google.GBRAID,
+		"wbraid":  ev.URL.Google.WBRAID,
+		"fbclid":  ev.URL.Meta.FBCLID,
+		"msclkid": ev.URL.Microsoft.MSCLKID,
+	} {
+		if value != "" {
+			ids[param] = value
+		}
+	}
+	for param, value := range ev.URL.OtherIDs {
+		ids[param] = value
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return ids
+}
+
+// queryWarnings checks the query parameters. Parameter names are
+// case-sensitive, and only the first of repeated parameters is used.
+// recorded says whether any click ID was recorded.
+func queryWarnings(u *url.URL, recorded bool) []Warning {
+	q := u.Query()
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var warnings []Warning
+	hasUTM := false
+	for _, name := range names {
+		values := q[name]
+		lower := strings.ToLower(name)
+		if !strings.HasPrefix(lower, "utm_") {
+			if len(values) > 1 && isClickID(lower) {
+				warnings = append(warnings, Warning{name, fmt.Sprintf("appears %d times; only the first value is used", len(values))})
+			}
+			if slices.Contains(event.DefaultClickIDs.Params(), name) && values[0] != "" && !event.DefaultClickIDs.Valid(name, strings.TrimSpace(values[0])) {
+				warnings = append(warnings, Warning{name, "doesn't match the pattern in CLICK_ID_FILE, so it is not recorded"})
+			}
+			continue
+		}
+		hasUTM = true
+		switch {
+		case name != lower && known(lower):
+			warnings = append(warnings, Warning{name, fmt.Sprintf("parameter names are case-sensitive, so this is ignored; use %s", lower)})
+			continue
+		case !known(lower):
+			msg := "unknown UTM parameter, ignored"
+			if guess := closest(lower); guess != "" {
+				msg += "; did you mean " + guess + "?"
+			}
+			warnings = append(warnings, Warning{name, msg})
+			continue
+		}
+		if len(values) > 1 {
+			warnings = append(warnings, Warning{name, fmt.Sprintf("appears %d times; only the first value, %q, is used", len(values), values[0])})
+		}
+		value := values[0]
+		switch {
+		case value == "":
+			warnings = append(warnings, Warning{name, "is empty"})
+		case strings.TrimSpace(value) != value:
+			warnings = append(warnings, Warning{name, "has leading or trailing whitespace"})
+		case (name == "utm_source" || name == "utm_medium") && strings.IndexFunc(value, unicode.IsUpper) >= 0:
+			warnings = append(warnings, Warning{name, fmt.Sprintf("%q has capitals; values are case-sensitive, so it is reported apart from %q", value, strings.ToLower(value))})
+		}
+	}
+
+	if !hasUTM {
+		if !recorded {
+			warnings = append(warnings, Warning{"", "no UTM parameters or click IDs; visits count as referral or direct"})
+		}
+		return warnings
+	}
+	for _, name := range utmParams[:3] {
+		if !q.Has(name) {
+			msg := "missing"
+			if name == "utm_source" {
+				msg = "missing; without it the campaign has no source"
+			}
+			warnings = append(warnings, Warning{name, msg})
+		}
+	}
+	return warnings
+}
+
+// fragmentWarnings flags parameters after the #, which browsers don't send
+// to the server or put in the pixel's page URL
+func fragmentWarnings(u *url.URL) []Warning {
+	if u.Fragment == "" {
+		return nil
+	}
+	frag := u.Fragment
+	if i := strings.IndexByte(frag, '?'); i >= 0 {
+		frag = frag[i+1:]
+	}
+	values, err := url.ParseQuery(frag)
+	if err != nil {
+		return nil
+	}
+	var warnings []Warning
+	for name := range values {
+		lower := strings.ToLower(name)
+		if known(lower) || isClickID(lower) {
+			warnings = append(warnings, Warning{name, "is after the #, so it is never sent; move it into the query before the #"})
+		}
+	}
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i].Param < warnings[j].Param })
+	return warnings
+}
+
+func known(name string) bool {
+	return slices.Contains(utmParams, name) || slices.Contains(otherUTMParams, name)
+}
+
+func isClickID(name string) bool {
+	return event.DefaultClickIDs.Has(name)
+}
+
+// closest returns the UTM parameter within two edits of name, if any
+func closest(name string) string {
+	best, bestDist := "", 3
+	for _, p := range slices.Concat(utmParams, otherUTMParams) {
+		if d := editDistance(name, p); d < bestDist {
+			best, bestDist = p, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,99 @@
+package campaign
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestInspect(t *testing.T) {
+	r, err := Inspect("https://WWW.Shop.Example./sale?utm_source=newsletter&utm_medium=email&utm_campaign=spring&fbclid=f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Hostname != "www.shop.example" || r.Path != "/sale" {
+		t.Errorf("hostname, path = %q, %q", r.Hostname, r.Path)
+	}
+	if r.UTM.Source != "newsletter" || r.UTM.Medium != "email" || r.UTM.Campaign != "spring" {
+		t.Errorf("utm = %+v", r.UTM)
+	}
+	if r.ClickIDs["fbclid"] != "f1" || len(r.ClickIDs) != 1 {
+		t.Errorf("click ids = %v", r.ClickIDs)
+	}
+	if r.Channel != event.ChannelPaidSocial {
+		t.Errorf("channel = %q, want %q", r.Channel, event.ChannelPaidSocial)
+	}
+	if len(r.Warnings) != 0 {
+		t.Errorf("warnings = %+v, want none", r.Warnings)
+	}
+
+	for _, bad := range []string{"/sale?utm_source=x", "mailto:a@b.example", "https://%zz"} {
+		if _, err := Inspect(bad); err == nil {
+			t.Errorf("Inspect(%q): expected error", bad)
+		}
+	}
+}
+
+func TestInspectWarnings(t *testing.T) {
+	tests := []struct {
+		name  string
+		url   string
+		param string
+		want  string
+	}{
+		{"missing source", "https://shop.example/?utm_medium=cpc&utm_campaign=s", "utm_source", "missing"},
+		{"missing campaign", "https://shop.example/?utm_source=g&utm_medium=cpc", "utm_campaign", "missing"},
+		{"typo", "https://shop.example/?utm_soruce=g&utm_source=g&utm_medium=cpc&utm_campaign=s", "utm_soruce", "did you mean utm_source?"},
+		{"unknown", "https://shop.example/?utm_whatever=g&utm_source=g&utm_medium=cpc&utm_campaign=s", "utm_whatever", "unknown UTM parameter"},
+		{"case", "https://shop.example/?UTM_Source=g&utm_medium=cpc&utm_campaign=s", "UTM_Source", "use utm_source"},
+		{"duplicate", "https://shop.example/?utm_source=a&utm_source=b&utm_medium=cpc&utm_campaign=s", "utm_source", `only the first value, "a", is used`},
+		{"duplicate click id", "https://shop.example/?gclid=a&gclid=b", "gclid", "appears 2 times"},
+		{"empty", "https://shop.example/?utm_source=&utm_medium=cpc&utm_campaign=s", "utm_source", "is empty"},
+		{"whitespace", "https://shop.example/?utm_source=g%20&utm_medium=cpc&utm_campaign=s", "utm_source", "whitespace"},
+		{"capitals", "https://shop.example/?utm_source=Google&utm_medium=cpc&utm_campaign=s", "utm_source", "capitals"},
+		{"fragment", "https://shop.example/#/sale?utm_source=g", "utm_source", "after the #"},
+		{"nothing", "https://shop.example/sale", "", "no UTM parameters or click IDs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Inspect(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range r.Warnings {
+				if w.Param == tt.param && strings.Contains(w.Message, tt.want) {
+					return
+				}
+			}
+			t.Errorf("warnings = %+v, want %s: ...%s...", r.Warnings, tt.param, tt.want)
+		})
+	}
+}
+
+func TestInspectClickIDPattern(t *testing.T) {
+	registry, err := event.NewClickIDRegistry(strings.NewReader("rdt_cid [a-z0-9]{8,}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(prev *event.ClickIDRegistry) { event.DefaultClickIDs = prev }(event.DefaultClickIDs)
+	event.DefaultClickIDs = registry
+
+	r, err := Inspect("https://shop.example/?rdt_cid=short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.ClickIDs) != 0 || len(r.Warnings) != 2 || r.Warnings[0].Param != "rdt_cid" {
+		t.Errorf("click ids = %v, warnings = %+v; want rdt_cid rejected", r.ClickIDs, r.Warnings)
+	}
+}
+
+func TestInspectIgnoresGA4Params(t *testing.T) {
+	r, err := Inspect("https://shop.example/?utm_source=g&utm_medium=cpc&utm_campaign=s&utm_source_platform=sa360")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Warnings) != 0 {
+		t.Errorf("warnings = %+v, want none", r.Warnings)
+	}
+}
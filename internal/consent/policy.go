@@ -0,0 +1,177 @@
+package consent
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Action selects what happens to events whose consent doesn't permit
+// measurement
+type Action string
+
+const (
+	ActionOff       Action = "off"       // don't evaluate TC strings
+	ActionFlag      Action = "flag"      // keep the event, with consent.measurement=false
+	ActionAnonymize Action = "anonymize" // remove identifiers, click IDs and session IDs
+	ActionDrop      Action = "drop"      // discard the event
+)
+
+// CookieName is the cookie IAB CMPs keep the TC string in on the site's domain
+const CookieName = "euconsent-v2"
+
+// ParseAction validates an action name; empty means ActionOff
+func ParseAction(s string) (Action, error) {
+	switch a := Action(strings.ToLower(strings.TrimSpace(s))); a {
+	case "":
+		return ActionOff, nil
+	case ActionOff, ActionFlag, ActionAnonymize, ActionDrop:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown TCF action %q (want off, flag, anonymize or drop)", s)
+	}
+}
+
+// Policy decides whether an event's TC string permits measurement: every
+// purpose in Purposes must be allowed and, when VendorID is set, so must
+// that vendor
+type Policy struct {
+	Action   Action
+	Purposes []int
+	VendorID int  // GoTrack operator's Global Vendor List ID; 0 skips the vendor check
+	Required bool // deny events without a TC string unless GDPR doesn't apply
+}
+
+// NewPolicy builds a policy from the configured action, purpose numbers,
+// vendor ID and requirement. It returns nil for ActionOff.
+func NewPolicy(action string, purposes []string, vendorID int64, required bool) (*Policy, error) {
+	a, err := ParseAction(action)
+	if err != nil {
+		return nil, err
+	}
+	if vendorID < 0 || vendorID > 0xFFFF {
+		return nil, fmt.Errorf("TCF vendor ID %d out of range (0 to 65535)", vendorID)
+	}
+	p := &Policy{Action: a, VendorID: int(vendorID), Required: required}
+	for _, s := range purposes {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 || n > NumPurposes {
+			return nil, fmt.Errorf("invalid TCF purpose %q (want 1 to %d)", s, NumPurposes)
+		}
+		if !slices.Contains(p.Purposes, n) {
+			p.Purposes = append(p.Purposes, n)
+		}
+	}
+	if a == ActionOff {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// FromRequest fills the TC string and GDPR flag the client didn't send in
+// the event from the gdpr_consent and gdpr query parameters, as ad tags
+// pass them, or from the euconsent-v2 cookie
+func FromRequest(r *http.Request, ev *event.Event) {
+	q := r.URL.Query()
+	tc := strings.TrimSpace(q.Get("gdpr_consent"))
+	if tc == "" {
+		if c, err := r.Cookie(CookieName); err == nil {
+			tc = strings.TrimSpace(c.Value)
+		}
+	}
+	var applies *bool
+	switch q.Get("gdpr") {
+	case "1":
+		applies = boolPtr(true)
+	case "0":
+		applies = boolPtr(false)
+	}
+	if tc == "" && applies == nil {
+		return
+	}
+	if ev.Consent == nil {
+		ev.Consent = &event.ConsentInfo{}
+	}
+	if ev.Consent.TCString == "" {
+		ev.Consent.TCString = tc
+	}
+	if ev.Consent.GDPRApplies == nil {
+		ev.Consent.GDPRApplies = applies
+	}
+}
+
+// Evaluate decodes the event's TC string into its consent fields, replacing
+// any the client sent, and reports whether measurement is permitted. Events
+// outside GDPR are permitted; undecodable strings are not.
+func (p *Policy) Evaluate(ev *event.Event) bool {
+	c := ev.Consent
+	if c == nil || (c.TCString == "" && c.GDPRApplies == nil) {
+		if !p.Required {
+			ev.Consent = nil
+			return true
+		}
+		c = &event.ConsentInfo{}
+		ev.Consent = c
+	}
+	c.CMPID, c.Purposes, c.LegitimateInterests, c.VendorConsent = 0, nil, nil, nil
+
+	allowed := false
+	switch {
+	case c.GDPRApplies != nil && !*c.GDPRApplies:
+		allowed = true
+	case c.TCString == "":
+		allowed = !p.Required
+	default:
+		if d, err := Decode(c.TCString); err == nil {
+			c.CMPID = d.CMPID
+			c.Purposes = d.Purposes()
+			c.LegitimateInterests = d.LegitimateInterests()
+			allowed = p.permits(d)
+			if p.VendorID != 0 {
+				c.VendorConsent = boolPtr(d.VendorConsent(p.VendorID) || d.VendorLI(p.VendorID))
+				allowed = allowed && *c.VendorConsent
+			}
+		}
+	}
+	c.Measurement = boolPtr(allowed)
+	return allowed
+}
+
+// permits reports whether every required purpose has consent, or
+// legitimate interest where TCF v2.2 allows it as a legal basis
+func (p *Policy) permits(d *TCData) bool {
+	for _, purpose := range p.Purposes {
+		switch {
+		case d.PurposeConsent(purpose):
+		case purpose == 1 && d.PurposeOneTreatment:
+		case allowsLI(purpose) && d.PurposeLI(purpose):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// allowsLI reports whether legitimate interest can stand in for consent.
+// Purpose 1 and the personalisation purposes 3 to 6 require consent.
+func allowsLI(purpose int) bool {
+	return purpose == 2 || purpose >= 7
+}
+
+// Denied reports whether the event was evaluated and measurement is not
+// permitted
+func Denied(ev *event.Event) bool {
+	return ev.Consent != nil && ev.Consent.Measurement != nil && !*ev.Consent.Measurement
+}
+
+// Granted reports whether the event was evaluated and measurement is
+// permitted
+func Granted(ev *event.Event) bool {
+	return ev.Consent != nil && ev.Consent.Measurement != nil && *ev.Consent.Measurement
+}
+
+func boolPtr(b bool) *bool { return &b }
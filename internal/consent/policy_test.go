@@ -0,0 +1,132 @@
+package consent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestNewPolicy(t *testing.T) {
+	p, err := NewPolicy("Drop", []string{"1", "8", "8"}, 755, true)
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	if p.Action != ActionDrop || !slices.Equal(p.Purposes, []int{1, 8}) || p.VendorID != 755 || !p.Required {
+		t.Errorf("NewPolicy() = %+v", p)
+	}
+
+	if p, err := NewPolicy("off", []string{"1"}, 0, false); err != nil || p != nil {
+		t.Errorf("NewPolicy(off) = %v, %v, want nil, nil", p, err)
+	}
+	for _, tt := range []struct {
+		action   string
+		purposes []string
+		vendor   int64
+	}{
+		{"block", nil, 0},
+		{"flag", []string{"0"}, 0},
+		{"flag", []string{"25"}, 0},
+		{"flag", []string{"one"}, 0},
+		{"flag", nil, -1},
+		{"off", []string{"x"}, 0},
+	} {
+		if _, err := NewPolicy(tt.action, tt.purposes, tt.vendor, false); err == nil {
+			t.Errorf("NewPolicy(%q, %v, %d) succeeded, want error", tt.action, tt.purposes, tt.vendor)
+		}
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	yes, no := true, false
+	policy := &Policy{Action: ActionFlag, Purposes: []int{1, 8}}
+	vendor := &Policy{Action: ActionFlag, Purposes: []int{1, 8}, VendorID: 755}
+	required := &Policy{Action: ActionFlag, Purposes: []int{1, 8}, Required: true}
+
+	tests := []struct {
+		name    string
+		policy  *Policy
+		consent *event.ConsentInfo
+		want    bool
+	}{
+		{"no signal", policy, nil, true},
+		{"no signal required", required, nil, false},
+		{"gdpr doesn't apply", required, &event.ConsentInfo{GDPRApplies: &no}, true},
+		{"gdpr applies without string", policy, &event.ConsentInfo{GDPRApplies: &yes}, true},
+		{"gdpr applies without string required", required, &event.ConsentInfo{GDPRApplies: &yes}, false},
+		{"consented", policy, &event.ConsentInfo{TCString: tcString{purposes: []int{1, 8}}.encode()}, true},
+		{"legitimate interest for 8", policy, &event.ConsentInfo{TCString: tcString{purposes: []int{1}, li: []int{8}}.encode()}, true},
+		{"legitimate interest for 1", policy, &event.ConsentInfo{TCString: tcString{li: []int{1, 8}}.encode()}, false},
+		{"purpose one treatment", policy, &event.ConsentInfo{TCString: tcString{purposes: []int{8}, purposeOne: true}.encode()}, true},
+		{"missing purpose", policy, &event.ConsentInfo{TCString: tcString{purposes: []int{1}}.encode()}, false},
+		{"vendor consented", vendor, &event.ConsentInfo{TCString: tcString{purposes: []int{1, 8}, vendors: []int{755}}.encode()}, true},
+		{"vendor legitimate interest", vendor, &event.ConsentInfo{TCString: tcString{purposes: []int{1, 8}, vendorLI: []int{755}}.encode()}, true},
+		{"vendor missing", vendor, &event.ConsentInfo{TCString: tcString{purposes: []int{1, 8}, vendors: []int{754}}.encode()}, false},
+		{"invalid string", policy, &event.ConsentInfo{TCString: "not-a-tc-string"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := &event.Event{Consent: tt.consent}
+			if got := tt.policy.Evaluate(ev); got != tt.want {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+			if Denied(ev) != !tt.want {
+				t.Errorf("Denied() = %v, want %v", Denied(ev), !tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyEvaluate_FillsConsent(t *testing.T) {
+	forged := true
+	ev := &event.Event{Consent: &event.ConsentInfo{
+		TCString:    tcString{cmpID: 10, purposes: []int{1, 2}, li: []int{7}, vendors: []int{5}}.encode(),
+		Purposes:    []int{1, 8}, // client claims are replaced
+		Measurement: &forged,
+	}}
+	p := &Policy{Action: ActionFlag, Purposes: []int{1, 8}, VendorID: 5}
+	if p.Evaluate(ev) {
+		t.Fatal("Evaluate() = true without purpose 8")
+	}
+	c := ev.Consent
+	if c.CMPID != 10 || !slices.Equal(c.Purposes, []int{1, 2}) || !slices.Equal(c.LegitimateInterests, []int{7}) {
+		t.Errorf("consent = %+v", c)
+	}
+	if c.VendorConsent == nil || !*c.VendorConsent || c.Measurement == nil || *c.Measurement {
+		t.Errorf("VendorConsent = %v, Measurement = %v", c.VendorConsent, c.Measurement)
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	tc := tcString{purposes: []int{1}}.encode()
+
+	r := httptest.NewRequest(http.MethodGet, "/px.gif?gdpr=1&gdpr_consent="+tc, nil)
+	var ev event.Event
+	FromRequest(r, &ev)
+	if ev.Consent == nil || ev.Consent.TCString != tc || ev.Consent.GDPRApplies == nil || !*ev.Consent.GDPRApplies {
+		t.Errorf("from query: %+v", ev.Consent)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/collect", nil)
+	r.AddCookie(&http.Cookie{Name: CookieName, Value: tc})
+	ev = event.Event{}
+	FromRequest(r, &ev)
+	if ev.Consent == nil || ev.Consent.TCString != tc || ev.Consent.GDPRApplies != nil {
+		t.Errorf("from cookie: %+v", ev.Consent)
+	}
+
+	// The event's own string wins
+	ev = event.Event{Consent: &event.ConsentInfo{TCString: "body"}}
+	FromRequest(r, &ev)
+	if ev.Consent.TCString != "body" {
+		t.Errorf("TCString = %q, want the event's", ev.Consent.TCString)
+	}
+
+	ev = event.Event{}
+	FromRequest(httptest.NewRequest(http.MethodGet, "/px.gif", nil), &ev)
+	if ev.Consent != nil {
+		t.Errorf("without signals: %+v", ev.Consent)
+	}
+}
@@ -0,0 +1,204 @@
+// Package consent decodes IAB TCF v2 consent strings and decides whether
+// events may be measured under them.
+package consent
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NumPurposes is the number of purpose bits in a TC string. TCF v2.2
+// defines purposes 1 to 11; the rest are reserved.
+const NumPurposes = 24
+
+// decisecond is the unit of the TC string's Created and LastUpdated fields
+const decisecond = 100 * time.Millisecond
+
+// TCData is the core segment of a TC string
+type TCData struct {
+	Version             int
+	Created             time.Time
+	LastUpdated         time.Time
+	CMPID               int
+	CMPVersion          int
+	ConsentScreen       int
+	ConsentLanguage     string // two-letter ISO 639-1 code, upper-case
+	VendorListVersion   int
+	PolicyVersion       int
+	IsServiceSpecific   bool
+	PurposeOneTreatment bool   // purpose 1 was not disclosed, which the publisher's country allows
+	PublisherCC         string // two-letter ISO 3166-1 code, upper-case
+
+	purposeConsent uint32 // purpose p is bit NumPurposes-p, as in the string
+	purposeLI      uint32
+	vendorConsent  vendorSet
+	vendorLI       vendorSet
+}
+
+// Decode parses the core segment of a TCF v2 string. The optional
+// segments after the first "." are not read. Publisher restrictions at the
+// end of the core segment are skipped.
+func Decode(s string) (*TCData, error) {
+	core, _, _ := strings.Cut(strings.TrimSpace(s), ".")
+	if core == "" {
+		return nil, errors.New("empty TC string")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(core, "="))
+	if err != nil {
+		return nil, fmt.Errorf("TC string is not base64url: %w", err)
+	}
+	r := &bitReader{b: b}
+
+	d := &TCData{Version: r.int(6)}
+	if d.Version != 2 {
+		return nil, fmt.Errorf("unsupported TC string version %d", d.Version)
+	}
+	d.Created = r.time()
+	d.LastUpdated = r.time()
+	d.CMPID = r.int(12)
+	d.CMPVersion = r.int(12)
+	d.ConsentScreen = r.int(6)
+	d.ConsentLanguage = r.letters()
+	d.VendorListVersion = r.int(12)
+	d.PolicyVersion = r.int(6)
+	d.IsServiceSpecific = r.bool()
+	r.bool()  // UseNonStandardTexts
+	r.int(12) // SpecialFeatureOptIns
+	d.purposeConsent = uint32(r.int(NumPurposes))
+	d.purposeLI = uint32(r.int(NumPurposes))
+	d.PurposeOneTreatment = r.bool()
+	d.PublisherCC = r.letters()
+	d.vendorConsent = r.vendors()
+	d.vendorLI = r.vendors()
+	if r.overflow {
+		return nil, errors.New("TC string is truncated")
+	}
+	return d, nil
+}
+
+// PurposeConsent reports whether the user consented to purpose p
+func (d *TCData) PurposeConsent(p int) bool {
+	return p >= 1 && p <= NumPurposes && d.purposeConsent&(1<<(NumPurposes-p)) != 0
+}
+
+// PurposeLI reports whether the user was informed of, and didn't object
+// to, legitimate interest for purpose p
+func (d *TCData) PurposeLI(p int) bool {
+	return p >= 1 && p <= NumPurposes && d.purposeLI&(1<<(NumPurposes-p)) != 0
+}
+
+// VendorConsent reports whether the user consented to vendor id
+func (d *TCData) VendorConsent(id int) bool { return d.vendorConsent.has(id) }
+
+// VendorLI reports whether legitimate interest is established for vendor id
+func (d *TCData) VendorLI(id int) bool { return d.vendorLI.has(id) }
+
+// Purposes lists the purposes consented to, ascending
+func (d *TCData) Purposes() []int { return purposeList(d.PurposeConsent) }
+
+// LegitimateInterests lists the purposes with legitimate interest, ascending
+func (d *TCData) LegitimateInterests() []int { return purposeList(d.PurposeLI) }
+
+func purposeList(set func(int) bool) []int {
+	var list []int
+	for p := 1; p <= NumPurposes; p++ {
+		if set(p) {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// vendorSet holds vendor IDs as inclusive ranges, which is how range
+// encoding stores them and keeps bitfields of thousands of vendors small
+type vendorSet []vendorRange
+
+type vendorRange struct{ start, end int }
+
+func (s vendorSet) has(id int) bool {
+	for _, r := range s {
+		if id >= r.start && id <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// bitReader reads big-endian bit fields. Reads past the end return zero
+// and set overflow, so Decode checks once at the end.
+type bitReader struct {
+	b        []byte
+	pos      int
+	overflow bool
+}
+
+func (r *bitReader) int(n int) int {
+	v := 0
+	for range n {
+		v <<= 1
+		if r.bit() {
+			v |= 1
+		}
+	}
+	return v
+}
+
+func (r *bitReader) bit() bool {
+	if r.pos >= len(r.b)*8 {
+		r.overflow = true
+		r.pos++
+		return false
+	}
+	set := r.b[r.pos/8]&(0x80>>(r.pos%8)) != 0
+	r.pos++
+	return set
+}
+
+func (r *bitReader) bool() bool { return r.bit() }
+
+func (r *bitReader) time() time.Time {
+	ds := int64(r.int(36))
+	return time.UnixMilli(ds * int64(decisecond/time.Millisecond)).UTC()
+}
+
+// letters reads two 6-bit letters, 0 being A
+func (r *bitReader) letters() string {
+	a, b := r.int(6), r.int(6)
+	return string([]byte{'A' + byte(a), 'A' + byte(b)})
+}
+
+// vendors reads a vendor section: MaxVendorId, then a bitfield or ranges
+func (r *bitReader) vendors() vendorSet {
+	maxID := r.int(16)
+	var set vendorSet
+	if !r.bool() {
+		for id := 1; id <= maxID && !r.overflow; id++ {
+			if !r.bit() {
+				continue
+			}
+			if n := len(set); n > 0 && set[n-1].end == id-1 {
+				set[n-1].end = id
+			} else {
+				set = append(set, vendorRange{id, id})
+			}
+		}
+		return set
+	}
+	entries := r.int(12)
+	for range entries {
+		if r.overflow {
+			break
+		}
+		isRange := r.bool()
+		start := r.int(16)
+		end := start
+		if isRange {
+			end = r.int(16)
+		}
+		set = append(set, vendorRange{start, end})
+	}
+	return set
+}
@@ -0,0 +1,183 @@
+package consent
+
+import (
+	"encoding/base64"
+	"slices"
+	"testing"
+	"time"
+)
+
+// tcString encodes a TC string core segment for the tests
+type tcString struct {
+	cmpID          int
+	purposes       []int
+	li             []int
+	purposeOne     bool
+	vendors        []int
+	vendorRanges   [][2]int // range-encoded vendor consents, instead of vendors
+	vendorLI       []int
+	truncateToBits int
+}
+
+type bitWriter struct{ bits []bool }
+
+func (w *bitWriter) int(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.bits = append(w.bits, v&(1<<i) != 0)
+	}
+}
+
+func (w *bitWriter) bool(b bool) {
+	w.bits = append(w.bits, b)
+}
+
+func (w *bitWriter) bitfield(set []int, n int) {
+	for i := 1; i <= n; i++ {
+		w.bool(slices.Contains(set, i))
+	}
+}
+
+func (w *bitWriter) vendorBitfield(ids []int) {
+	maxID := 0
+	for _, id := range ids {
+		maxID = max(maxID, id)
+	}
+	w.int(maxID, 16)
+	w.bool(false)
+	w.bitfield(ids, maxID)
+}
+
+func (tc tcString) encode() string {
+	w := &bitWriter{}
+	w.int(2, 6)
+	w.int(15900000000, 36) // 2020-05-20 18:40 UTC, in deciseconds
+	w.int(15900000000, 36)
+	w.int(tc.cmpID, 12)
+	w.int(3, 12)
+	w.int(1, 6)
+	w.int(4, 6)  // E
+	w.int(13, 6) // N
+	w.int(48, 12)
+	w.int(4, 6)
+	w.bool(false)
+	w.bool(false)
+	w.int(0, 12)
+	w.bitfield(tc.purposes, 24)
+	w.bitfield(tc.li, 24)
+	w.bool(tc.purposeOne)
+	w.int(3, 6) // D
+	w.int(4, 6) // E
+	if tc.vendorRanges != nil {
+		w.int(tc.vendorRanges[len(tc.vendorRanges)-1][1], 16)
+		w.bool(true)
+		w.int(len(tc.vendorRanges), 12)
+		for _, r := range tc.vendorRanges {
+			w.bool(r[0] != r[1])
+			w.int(r[0], 16)
+			if r[0] != r[1] {
+				w.int(r[1], 16)
+			}
+		}
+	} else {
+		w.vendorBitfield(tc.vendors)
+	}
+	w.vendorBitfield(tc.vendorLI)
+	w.int(0, 12) // NumPubRestrictions
+
+	bits := w.bits
+	if tc.truncateToBits > 0 {
+		bits = bits[:tc.truncateToBits]
+	}
+	b := make([]byte, (len(bits)+7)/8)
+	for i, set := range bits {
+		if set {
+			b[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestDecode(t *testing.T) {
+	s := tcString{
+		cmpID:    300,
+		purposes: []int{1, 3, 8, 10},
+		li:       []int{2, 7},
+		vendors:  []int{1, 2, 3, 755},
+		vendorLI: []int{50},
+	}.encode()
+
+	d, err := Decode(s + ".YAAAAAAAAAAA")
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if d.Version != 2 || d.CMPID != 300 || d.CMPVersion != 3 || d.ConsentScreen != 1 {
+		t.Errorf("header = %+v", d)
+	}
+	if want := time.Date(2020, 5, 20, 18, 40, 0, 0, time.UTC); !d.Created.Equal(want) || !d.LastUpdated.Equal(want) {
+		t.Errorf("Created = %v, LastUpdated = %v, want %v", d.Created, d.LastUpdated, want)
+	}
+	if d.ConsentLanguage != "EN" || d.PublisherCC != "DE" || d.VendorListVersion != 48 || d.PolicyVersion != 4 {
+		t.Errorf("language %q, country %q, vendor list %d, policy %d", d.ConsentLanguage, d.PublisherCC, d.VendorListVersion, d.PolicyVersion)
+	}
+	if got := d.Purposes(); !slices.Equal(got, []int{1, 3, 8, 10}) {
+		t.Errorf("Purposes() = %v", got)
+	}
+	if got := d.LegitimateInterests(); !slices.Equal(got, []int{2, 7}) {
+		t.Errorf("LegitimateInterests() = %v", got)
+	}
+	for id, want := range map[int]bool{1: true, 3: true, 4: false, 755: true, 756: false, 0: false} {
+		if got := d.VendorConsent(id); got != want {
+			t.Errorf("VendorConsent(%d) = %v, want %v", id, got, want)
+		}
+	}
+	if !d.VendorLI(50) || d.VendorLI(755) {
+		t.Errorf("VendorLI(50) = %v, VendorLI(755) = %v", d.VendorLI(50), d.VendorLI(755))
+	}
+}
+
+func TestDecode_RangeEncoding(t *testing.T) {
+	d, err := Decode(tcString{vendorRanges: [][2]int{{5, 5}, {100, 200}}}.encode())
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	for id, want := range map[int]bool{4: false, 5: true, 6: false, 99: false, 100: true, 150: true, 200: true, 201: false} {
+		if got := d.VendorConsent(id); got != want {
+			t.Errorf("VendorConsent(%d) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+// TestDecode_SpecExample decodes the core segment of the example in IAB's
+// TCF v2 consent string specification
+func TestDecode_SpecExample(t *testing.T) {
+	d, err := Decode("COw4XqLOw4XqLAAAAAENAXCAAAAAAAAAAAAAAAAAAAAA.IFoEUQQgAIQwgIwQABAEAAAAOIAACAIAAAAQAIAgEAACEAAAAAgAQBAAAAAAAGBAAgAAAAAAAAFAAECAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if want := time.Date(2020, 3, 26, 18, 21, 27, 500e6, time.UTC); !d.Created.Equal(want) {
+		t.Errorf("Created = %v, want %v", d.Created, want)
+	}
+	if d.ConsentLanguage != "EN" || d.VendorListVersion != 23 || d.PolicyVersion != 2 {
+		t.Errorf("language %q, vendor list %d, policy %d", d.ConsentLanguage, d.VendorListVersion, d.PolicyVersion)
+	}
+	if len(d.Purposes()) != 0 {
+		t.Errorf("Purposes() = %v, want none", d.Purposes())
+	}
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	valid := tcString{purposes: []int{1}, vendors: []int{10}}
+	truncated := valid
+	truncated.truncateToBits = 200
+
+	for name, s := range map[string]string{
+		"empty":      "",
+		"not base64": "C*O",
+		"version 1":  "BOEFEAyOEFEAyAHABDENAI4AAAB9vABAASA",
+		"truncated":  truncated.encode(),
+	} {
+		if _, err := Decode(s); err == nil {
+			t.Errorf("%s: Decode(%q) succeeded, want error", name, s)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Package conversion parses the typed payload accepted on POST /conversion
+// and maps it to an event with event.EcommerceInfo, so orders are checked
+// on the way in instead of arriving as loosely typed props.
+package conversion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/shortontech/gotrack/internal/validation"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// MaxOrderIDLength bounds order IDs, which are dedup keys
+const MaxOrderIDLength = 128
+
+// DefaultType is the event type of a conversion that doesn't name one
+const DefaultType = "purchase"
+
+// Payload is a /conversion request body
+type Payload struct {
+	OrderID  string   `json:"order_id"`
+	Type     string   `json:"type"`  // event type; DefaultType when empty
+	Value    *float64 `json:"value"` // the items' total when omitted
+	Currency string   `json:"currency"`
+	Tax      float64  `json:"tax"`
+	Shipping float64  `json:"shipping"`
+	Coupon   string   `json:"coupon"`
+	Subtotal float64  `json:"subtotal"`
+	Discount float64  `json:"discount"`
+	Items    []Item   `json:"items"`
+
+	EventID   string            `json:"event_id"`
+	TS        string            `json:"ts"`
+	PageURL   string            `json:"page_url"` // landing or checkout URL; its UTM tags and click IDs are recorded
+	VisitorID string            `json:"visitor_id"`
+	SessionID string            `json:"session_id"`
+	UserID    string            `json:"user_id"`
+	Props     map[string]string `json:"props"`
+}
+
+// Item is one line of Payload.Items
+type Item struct {
+	ItemID   string  `json:"item_id"`
+	ItemName string  `json:"item_name"`
+	Category string  `json:"item_category"`
+	Brand    string  `json:"item_brand"`
+	Variant  string  `json:"item_variant"`
+	Price    float64 `json:"price"`
+	Quantity *int    `json:"quantity"` // 1 when omitted
+	Discount float64 `json:"discount"`
+}
+
+// Parse decodes a request body. Unknown fields are an error, so a
+// misspelled field fails loudly instead of going missing from reports.
+func Parse(body []byte) (Payload, error) {
+	var p Payload
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return Payload{}, err
+	}
+	if dec.More() {
+		return Payload{}, fmt.Errorf("unexpected data after the conversion object")
+	}
+	return p, nil
+}
+
+// FieldError is one validation problem
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Validate checks the payload: the order ID, currency and value are
+// required, and the order must pass validation.CheckEcommerce. A conversion
+// with any problem is rejected as a whole.
+func (p Payload) Validate() []FieldError {
+	var errs []FieldError
+	reported := map[string]bool{}
+	add := func(field, format string, args ...any) {
+		errs = append(errs, FieldError{field, fmt.Sprintf(format, args...)})
+		reported[field] = true
+	}
+
+	switch id := strings.TrimSpace(p.OrderID); {
+	case id == "":
+		add("order_id", "is required")
+	case len(id) > MaxOrderIDLength:
+		add("order_id", "must be at most %d characters", MaxOrderIDLength)
+	}
+	if p.Currency == "" {
+		add("currency", "is required")
+	}
+	if p.Value == nil && len(p.Items) == 0 {
+		add("value", "is required without items")
+	}
+	for i, it := range p.Items {
+		if it.Quantity != nil && *it.Quantity == 0 {
+			add("items["+strconv.Itoa(i)+"].quantity", "must be at least 1")
+		}
+	}
+
+	for _, issue := range validation.CheckEcommerce(p.ToEvent().Ecommerce) {
+		field := strings.TrimPrefix(issue.Field, "ecommerce.")
+		if !reported[field] {
+			add(field, "%s", describe(field, issue.Code))
+		}
+	}
+	return errs
+}
+
+// describe explains an ecommerce issue code for field
+func describe(field, code string) string {
+	switch {
+	case code == validation.CodeInvalidCurrency:
+		return "is not an ISO 4217 currency code"
+	case code == validation.CodeRequired && strings.HasSuffix(field, ".item_id"):
+		return "item_id or item_name is required"
+	case code == validation.CodeRequired:
+		return "is required"
+	case field == "items":
+		return fmt.Sprintf("an order may have at most %d items", validation.MaxItems)
+	case strings.HasSuffix(field, ".quantity"):
+		return fmt.Sprintf("must be between 1 and %d", validation.MaxQuantity)
+	}
+	return fmt.Sprintf("must be between 0 and %.0f", float64(validation.MaxAmount))
+}
+
+// ToEvent maps a valid payload to an event. The currency is upper-cased
+// and a missing value is the sum of the items' price times quantity.
+func (p Payload) ToEvent() event.Event {
+	ev := event.Event{
+		EventID: p.EventID,
+		TS:      p.TS,
+		Type:    p.Type,
+		Props:   p.Props,
+	}
+	if ev.Type == "" {
+		ev.Type = DefaultType
+	}
+	if p.PageURL != "" {
+		event.ApplyPageURL(&ev, p.PageURL)
+	}
+	ev.Session.VisitorID = p.VisitorID
+	ev.Session.SessionID = p.SessionID
+	ev.Session.UserID = p.UserID
+
+	ec := &event.EcommerceInfo{
+		OrderID:  strings.TrimSpace(p.OrderID),
+		Currency: strings.ToUpper(p.Currency),
+		Tax:      p.Tax,
+		Shipping: p.Shipping,
+		Coupon:   p.Coupon,
+		Subtotal: p.Subtotal,
+		Discount: p.Discount,
+	}
+	total := 0.0
+	for _, it := range p.Items {
+		qty := 1
+		if it.Quantity != nil {
+			qty = *it.Quantity
+		}
+		ec.Items = append(ec.Items, event.EcommerceItem{
+			ItemID:   it.ItemID,
+			ItemName: it.ItemName,
+			Category: it.Category,
+			Brand:    it.Brand,
+			Variant:  it.Variant,
+			Price:    it.Price,
+			Quantity: qty,
+			Discount: it.Discount,
+		})
+		total += it.Price * float64(qty)
+	}
+	if p.Value != nil {
+		ec.Value = *p.Value
+	} else {
+		ec.Value = math.Round(total*100) / 100
+	}
+	ev.Ecommerce = ec
+	return ev
+}
@@ -0,0 +1,89 @@
+package conversion
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestParse(t *testing.T) {
+	if _, err := Parse([]byte(`{"order_id":"A1","valeu":10}`)); err == nil || !strings.Contains(err.Error(), "valeu") {
+		t.Errorf("unknown field error = %v", err)
+	}
+	if _, err := Parse([]byte(`{"order_id":"A1"} {}`)); err == nil {
+		t.Error("expected an error for trailing data")
+	}
+	if _, err := Parse([]byte(`{"order_id":"A1","value":"10"}`)); err == nil {
+		t.Error("expected an error for a string value")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{"valid", `{"order_id":"A1","value":19.99,"currency":"usd"}`, nil},
+		{"valid with items only", `{"order_id":"A1","currency":"EUR","items":[{"item_id":"sku-1","price":5}]}`, nil},
+		{"missing order and currency", `{"value":1}`, []string{"order_id", "currency"}},
+		{"unknown currency", `{"order_id":"A1","value":1,"currency":"EURO"}`, []string{"currency"}},
+		{"no value or items", `{"order_id":"A1","currency":"USD"}`, []string{"value"}},
+		{"negative amounts", `{"order_id":"A1","value":-1,"tax":-2,"currency":"USD"}`, []string{"value", "tax"}},
+		{"too large", `{"order_id":"A1","value":1e12,"currency":"USD"}`, []string{"value"}},
+		{"bad items", `{"order_id":"A1","value":1,"currency":"USD","items":[{"price":-1,"quantity":0}]}`, []string{"items[0].quantity", "items[0].item_id", "items[0].price"}},
+		{"long order id", `{"order_id":"` + strings.Repeat("x", MaxOrderIDLength+1) + `","value":1,"currency":"USD"}`, []string{"order_id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			var fields []string
+			for _, fe := range p.Validate() {
+				fields = append(fields, fe.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestToEvent(t *testing.T) {
+	p, err := Parse([]byte(`{
+		"order_id": " A1 ",
+		"currency": "eur",
+		"shipping": 4.5,
+		"items": [{"item_id": "sku-1", "price": 10.1, "quantity": 2}, {"item_name": "Gift wrap", "price": 2.5}],
+		"page_url": "https://shop.example/checkout?gclid=Cj0&utm_source=google",
+		"visitor_id": "v-1"
+	}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	ev := p.ToEvent()
+
+	want := &event.EcommerceInfo{
+		OrderID:  "A1",
+		Value:    22.7,
+		Currency: "EUR",
+		Shipping: 4.5,
+		Items: []event.EcommerceItem{
+			{ItemID: "sku-1", Price: 10.1, Quantity: 2},
+			{ItemName: "Gift wrap", Price: 2.5, Quantity: 1},
+		},
+	}
+	if !reflect.DeepEqual(ev.Ecommerce, want) {
+		t.Errorf("ecommerce = %+v, want %+v", ev.Ecommerce, want)
+	}
+	if ev.Type != DefaultType || ev.Session.VisitorID != "v-1" {
+		t.Errorf("type = %q, session = %+v", ev.Type, ev.Session)
+	}
+	if ev.URL.Google.GCLID != "Cj0" || ev.URL.UTM.Source != "google" || ev.Route.Path != "/checkout" {
+		t.Errorf("page URL not applied: %+v %+v", ev.URL, ev.Route)
+	}
+}
@@ -0,0 +1,201 @@
+// Package dedup suppresses events whose event_id was already seen within a
+// time window, typically browser retries of a request that did succeed.
+//
+// The in-memory detector is a bounded LRU and only sees one instance's
+// traffic; the store-backed detector shares the window across replicas
+// through Redis or Postgres.
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Action decides what happens to a duplicate
+type Action string
+
+const (
+	ActionDrop Action = "drop" // discard the duplicate
+	ActionFlag Action = "flag" // keep it with server.duplicate set
+)
+
+// ParseAction validates an action name; empty means ActionDrop
+func ParseAction(s string) (Action, error) {
+	switch a := Action(strings.ToLower(strings.TrimSpace(s))); a {
+	case "":
+		return ActionDrop, nil
+	case ActionDrop, ActionFlag:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown dedup action %q (want drop or flag)", s)
+	}
+}
+
+// Detector remembers event IDs for a window
+type Detector interface {
+	// Seen records id and reports whether it was already recorded within the window
+	Seen(ctx context.Context, id string) (bool, error)
+}
+
+// Filter applies an Action to events a Detector reports as duplicates
+type Filter struct {
+	detector Detector
+	action   Action
+	timeout  time.Duration
+
+	// OnDuplicate, if set, is called for every duplicate found
+	OnDuplicate func(Action)
+}
+
+// NewFilter creates a filter
+func NewFilter(detector Detector, action Action) *Filter {
+	return &Filter{
+		detector: detector,
+		action:   action,
+		timeout:  50 * time.Millisecond, // a slow store must not stall ingestion
+	}
+}
+
+// Keep reports whether ev should be emitted. Flagged duplicates get
+// ev.Server.Duplicate set. Events without an event_id and lookups that fail
+// are kept.
+func (f *Filter) Keep(ctx context.Context, ev *event.Event) bool {
+	ev.Server.Duplicate = false
+	if ev.EventID == "" {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	seen, err := f.detector.Seen(ctx, ev.EventID)
+	if err != nil {
+		log.Printf("dedup: lookup failed, keeping event: %v", err)
+		return true
+	}
+	if !seen {
+		return true
+	}
+	if f.OnDuplicate != nil {
+		f.OnDuplicate(f.action)
+	}
+	if f.action == ActionFlag {
+		ev.Server.Duplicate = true
+		return true
+	}
+	return false
+}
+
+// Wrap returns an emit function that filters duplicates before passing events on
+func (f *Filter) Wrap(emit func(context.Context, event.Event)) func(context.Context, event.Event) {
+	return func(ctx context.Context, ev event.Event) {
+		if f.Keep(ctx, &ev) {
+			emit(ctx, ev)
+		}
+	}
+}
+
+type memoryEntry struct {
+	id        string
+	expiresAt time.Time
+}
+
+// MemoryDetector keeps the most recently seen event IDs in process memory.
+// Once it holds capacity IDs the least recently seen is evicted, so a
+// duplicate arriving after its ID was evicted is not detected.
+type MemoryDetector struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	order    *list.List // front is most recently seen
+	entries  map[string]*list.Element
+	now      func() time.Time
+}
+
+// NewMemoryDetector creates an LRU detector holding up to capacity IDs for ttl
+func NewMemoryDetector(capacity int, ttl time.Duration) *MemoryDetector {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &MemoryDetector{
+		ttl:      ttl,
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+// Seen records id and reports whether it was already recorded within the window
+func (d *MemoryDetector) Seen(_ context.Context, id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if elem, ok := d.entries[id]; ok {
+		entry := elem.Value.(*memoryEntry)
+		d.order.MoveToFront(elem)
+		if now.Before(entry.expiresAt) {
+			return true, nil
+		}
+		// The window is measured from the first sighting, so restart it
+		entry.expiresAt = now.Add(d.ttl)
+		return false, nil
+	}
+
+	d.entries[id] = d.order.PushFront(&memoryEntry{id: id, expiresAt: now.Add(d.ttl)})
+	for d.order.Len() > d.capacity {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.entries, oldest.Value.(*memoryEntry).id)
+	}
+	return false, nil
+}
+
+// Len returns the number of tracked IDs, including expired ones not yet evicted
+func (d *MemoryDetector) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.order.Len()
+}
+
+// Counter is the key/value capability StoreDetector needs. GoTrack's shared
+// state stores (memory, Redis, Postgres) all satisfy it.
+type Counter interface {
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+// StoreDetector keeps event IDs in a shared store so every replica sees the
+// same window. The first increment of an ID's counter creates it with the
+// window as TTL, which makes the check atomic across replicas.
+type StoreDetector struct {
+	store  Counter
+	prefix string
+	ttl    time.Duration
+}
+
+// NewStoreDetector creates a detector backed by store
+func NewStoreDetector(store Counter, ttl time.Duration) *StoreDetector {
+	return NewStoreDetectorWithPrefix(store, "dedup:", ttl)
+}
+
+// NewStoreDetectorWithPrefix creates a detector keeping its IDs under prefix,
+// so other features can track their own IDs in the same store
+func NewStoreDetectorWithPrefix(store Counter, prefix string, ttl time.Duration) *StoreDetector {
+	return &StoreDetector{store: store, prefix: prefix, ttl: ttl}
+}
+
+// Seen records id and reports whether it was already recorded within the window
+func (d *StoreDetector) Seen(ctx context.Context, id string) (bool, error) {
+	n, err := d.store.Incr(ctx, d.prefix+id, d.ttl)
+	if err != nil {
+		return false, err
+	}
+	return n > 1, nil
+}
@@ -0,0 +1,139 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestParseAction(t *testing.T) {
+	for in, want := range map[string]Action{"": ActionDrop, "drop": ActionDrop, " FLAG ": ActionFlag} {
+		if got, err := ParseAction(in); err != nil || got != want {
+			t.Errorf("ParseAction(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseAction("ignore"); err == nil {
+		t.Error("expected error for an unknown action")
+	}
+}
+
+func TestMemoryDetector(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	d := NewMemoryDetector(2, time.Minute)
+	d.now = func() time.Time { return now }
+
+	seen := func(id string) bool {
+		t.Helper()
+		ok, err := d.Seen(ctx, id)
+		if err != nil {
+			t.Fatalf("Seen(%q) error = %v", id, err)
+		}
+		return ok
+	}
+
+	if seen("a") {
+		t.Error("first sighting reported as duplicate")
+	}
+	if !seen("a") {
+		t.Error("repeat within window not detected")
+	}
+
+	t.Run("window expires", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		if seen("a") {
+			t.Error("sighting after the window reported as duplicate")
+		}
+		now = now.Add(59 * time.Second)
+		if !seen("a") {
+			t.Error("window should restart at the last fresh sighting")
+		}
+	})
+
+	t.Run("least recently seen is evicted", func(t *testing.T) {
+		seen("b") // a, b
+		seen("a") // a is now most recent
+		seen("c") // evicts b
+		if d.Len() != 2 {
+			t.Errorf("Len() = %d, want 2", d.Len())
+		}
+		if !seen("a") {
+			t.Error("recently seen ID was evicted")
+		}
+		if seen("b") {
+			t.Error("evicted ID still detected")
+		}
+	})
+}
+
+func TestStoreDetector(t *testing.T) {
+	ctx := context.Background()
+	d := NewStoreDetector(kv.NewMemoryStore(), time.Minute)
+	for i, want := range []bool{false, true, true} {
+		if got, err := d.Seen(ctx, "evt-1"); err != nil || got != want {
+			t.Errorf("Seen() #%d = %v, %v, want %v", i+1, got, err, want)
+		}
+	}
+	if got, _ := d.Seen(ctx, "evt-2"); got {
+		t.Error("distinct ID reported as duplicate")
+	}
+}
+
+type failingDetector struct{}
+
+func (failingDetector) Seen(context.Context, string) (bool, error) {
+	return false, errors.New("store unavailable")
+}
+
+func TestFilterWrap(t *testing.T) {
+	run := func(f *Filter, events ...event.Event) []event.Event {
+		var emitted []event.Event
+		emit := f.Wrap(func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) })
+		for _, ev := range events {
+			emit(context.Background(), ev)
+		}
+		return emitted
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		f := NewFilter(NewMemoryDetector(10, time.Minute), ActionDrop)
+		var duplicates []Action
+		f.OnDuplicate = func(a Action) { duplicates = append(duplicates, a) }
+
+		emitted := run(f, event.Event{EventID: "e1"}, event.Event{EventID: "e1"}, event.Event{EventID: "e2"})
+		if len(emitted) != 2 || emitted[1].EventID != "e2" {
+			t.Errorf("emitted = %+v, want e1 and e2", emitted)
+		}
+		if len(duplicates) != 1 || duplicates[0] != ActionDrop {
+			t.Errorf("OnDuplicate calls = %v", duplicates)
+		}
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		f := NewFilter(NewMemoryDetector(10, time.Minute), ActionFlag)
+		first := event.Event{EventID: "e1"}
+		first.Server.Duplicate = true // clients cannot mark their own events
+		emitted := run(f, first, event.Event{EventID: "e1"})
+		if len(emitted) != 2 || emitted[0].Server.Duplicate || !emitted[1].Server.Duplicate {
+			t.Errorf("emitted = %+v, want the second flagged", emitted)
+		}
+	})
+
+	t.Run("events without an ID pass", func(t *testing.T) {
+		f := NewFilter(NewMemoryDetector(10, time.Minute), ActionDrop)
+		if emitted := run(f, event.Event{}, event.Event{}); len(emitted) != 2 {
+			t.Errorf("emitted %d events, want 2", len(emitted))
+		}
+	})
+
+	t.Run("lookup failure keeps the event", func(t *testing.T) {
+		f := NewFilter(failingDetector{}, ActionDrop)
+		if emitted := run(f, event.Event{EventID: "e1"}, event.Event{EventID: "e1"}); len(emitted) != 2 {
+			t.Errorf("emitted %d events, want 2", len(emitted))
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package email
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var secret = []byte("email-secret")
+
+func query(t *testing.T, raw string) url.Values {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
+
+func TestClickURL(t *testing.T) {
+	link := Link{Campaign: "spring", Message: "v2", Recipient: "{{contact.id}}", URL: "https://shop.example/sale?utm_source=newsletter"}
+	raw, err := ClickURL("https://track.example.com/", secret, link)
+	if err != nil {
+		t.Fatalf("ClickURL() error = %v", err)
+	}
+	if !strings.HasPrefix(raw, "https://track.example.com/e/c?") {
+		t.Fatalf("ClickURL() = %q", raw)
+	}
+
+	q := query(t, raw)
+	got, err := Parse(secret, ClickPath, q)
+	if err != nil || got != link {
+		t.Fatalf("Parse() = %+v, %v; want %+v", got, err, link)
+	}
+
+	// The recipient is unsigned so an ESP can fill it in
+	q.Set(RecipientParam, "contact-42")
+	if got, err := Parse(secret, ClickPath, q); err != nil || got.Recipient != "contact-42" {
+		t.Errorf("Parse() with another recipient = %+v, %v", got, err)
+	}
+
+	for name, edit := range map[string]func(url.Values){
+		"destination": func(q url.Values) { q.Set(URLParam, "https://evil.example/") },
+		"campaign":    func(q url.Values) { q.Set(CampaignParam, "other") },
+		"signature":   func(q url.Values) { q.Set(SignatureParam, "bm90IGEgc2ln") },
+		"unsigned":    func(q url.Values) { q.Del(SignatureParam) },
+	} {
+		q := query(t, raw)
+		edit(q)
+		if _, err := Parse(secret, ClickPath, q); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("edited %s: Parse() error = %v, want ErrInvalidSignature", name, err)
+		}
+	}
+	if _, err := Parse([]byte("other"), ClickPath, query(t, raw)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("other secret: Parse() error = %v", err)
+	}
+	// An open signature doesn't work as a click, or the other way round
+	if _, err := Parse(secret, OpenPath, query(t, raw)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("click link as open: Parse() error = %v", err)
+	}
+}
+
+func TestClickURL_Destination(t *testing.T) {
+	for _, dest := range []string{"", "/relative", "javascript:alert(1)", "mailto:a@example.com", "https://"} {
+		if _, err := ClickURL("https://track.example.com", secret, Link{URL: dest}); !errors.Is(err, ErrInvalidDestination) {
+			t.Errorf("ClickURL(%q) error = %v, want ErrInvalidDestination", dest, err)
+		}
+	}
+}
+
+func TestOpenURL(t *testing.T) {
+	raw := OpenURL("https://track.example.com", secret, Link{Campaign: "spring", Message: "v2", Recipient: "r1", URL: "https://ignored.example/", WriteKey: "wk"})
+	q := query(t, raw)
+	if q.Has(URLParam) || q.Get(WriteKeyParam) != "wk" {
+		t.Errorf("OpenURL() = %q", raw)
+	}
+	got, err := Parse(secret, OpenPath, q)
+	if err != nil || got.Campaign != "spring" || got.Recipient != "r1" || got.URL != "" {
+		t.Errorf("Parse() = %+v, %v", got, err)
+	}
+}
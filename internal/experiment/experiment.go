@@ -0,0 +1,192 @@
+// Package experiment assigns visitors to the variants of server-side A/B
+// experiments. Experiments are declared in a JSON file. A visitor's variant
+// follows from a hash of the experiment name and visitor ID, so every replica
+// assigns the same one without sharing state, and the assignment made on the
+// first touch is kept in a cookie so that later weight changes don't move
+// visitors between variants.
+package experiment
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Cookie holds a visitor's assignments as experiment:variant pairs joined by
+// |, readable by page scripts that render the variant
+const Cookie = "_gt_exp"
+
+// namePattern restricts experiment and variant names to what the cookie and
+// output rules can carry unescaped
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// Experiment is one test and the variants visitors are split between
+type Experiment struct {
+	Name     string    `json:"name"`             // key in events' experiments map
+	Variants []Variant `json:"variants"`         // at least two
+	Paused   bool      `json:"paused,omitempty"` // assigns no new visitors; assigned ones keep their variant
+}
+
+// Variant is one arm of an experiment
+type Variant struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight,omitempty"` // relative share of visitors; 1 when unset
+}
+
+// Load reads a JSON array of experiments from path and checks them
+func Load(path string) ([]Experiment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read experiments file: %w", err)
+	}
+	var defs []Experiment
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("failed to parse experiments file: %w", err)
+	}
+	if err := check(defs); err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
+func check(defs []Experiment) error {
+	names := map[string]bool{}
+	for i, x := range defs {
+		if !namePattern.MatchString(x.Name) {
+			return fmt.Errorf("experiment %d: name %q must be letters, digits, - and _", i, x.Name)
+		}
+		if names[x.Name] {
+			return fmt.Errorf("experiment %s: duplicate name", x.Name)
+		}
+		names[x.Name] = true
+		if len(x.Variants) < 2 {
+			return fmt.Errorf("experiment %s: at least two variants are required", x.Name)
+		}
+		variants := map[string]bool{}
+		for _, v := range x.Variants {
+			if !namePattern.MatchString(v.Name) {
+				return fmt.Errorf("experiment %s: variant name %q must be letters, digits, - and _", x.Name, v.Name)
+			}
+			if variants[v.Name] {
+				return fmt.Errorf("experiment %s: duplicate variant %s", x.Name, v.Name)
+			}
+			variants[v.Name] = true
+			if v.Weight < 0 {
+				return fmt.Errorf("experiment %s: variant %s has a negative weight", x.Name, v.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Assigner assigns visitors to the configured experiments. It is safe for
+// concurrent use and its experiments can be replaced while serving traffic.
+type Assigner struct {
+	mu          sync.RWMutex
+	experiments []Experiment
+}
+
+// New creates an assigner for defs
+func New(defs []Experiment) (*Assigner, error) {
+	a := &Assigner{}
+	if err := a.Update(defs); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Update replaces all experiments. Invalid ones leave the assigner unchanged.
+func (a *Assigner) Update(defs []Experiment) error {
+	if err := check(defs); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.experiments = defs
+	a.mu.Unlock()
+	return nil
+}
+
+// Assign returns the visitor's variant in each experiment, and the names of
+// the experiments the visitor was assigned to just now. Variants in prior,
+// recorded on an earlier touch, are kept while the experiment still has
+// them. Without a visitor ID only prior assignments are returned.
+func (a *Assigner) Assign(visitorID string, prior map[string]string) (assignments map[string]string, assigned []string) {
+	a.mu.RLock()
+	experiments := a.experiments
+	a.mu.RUnlock()
+
+	assignments = make(map[string]string, len(experiments))
+	for _, x := range experiments {
+		if v := prior[x.Name]; v != "" && x.has(v) {
+			assignments[x.Name] = v
+			continue
+		}
+		if visitorID == "" || x.Paused {
+			continue
+		}
+		assignments[x.Name] = x.pick(visitorID)
+		assigned = append(assigned, x.Name)
+	}
+	return assignments, assigned
+}
+
+func (x Experiment) has(variant string) bool {
+	for _, v := range x.Variants {
+		if v.Name == variant {
+			return true
+		}
+	}
+	return false
+}
+
+// pick hashes the experiment and visitor onto the variants' weights
+func (x Experiment) pick(visitorID string) string {
+	total := 0
+	for _, v := range x.Variants {
+		total += weight(v)
+	}
+	sum := sha256.Sum256([]byte(x.Name + "\x00" + visitorID))
+	n := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	for _, v := range x.Variants {
+		if n < weight(v) {
+			return v.Name
+		}
+		n -= weight(v)
+	}
+	return x.Variants[len(x.Variants)-1].Name
+}
+
+func weight(v Variant) int {
+	if v.Weight == 0 {
+		return 1
+	}
+	return v.Weight
+}
+
+// Encode formats assignments for the cookie, sorted by experiment
+func Encode(assignments map[string]string) string {
+	pairs := make([]string, 0, len(assignments))
+	for name, variant := range assignments {
+		pairs = append(pairs, name+":"+variant)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "|")
+}
+
+// Decode parses a cookie written by Encode, skipping malformed pairs
+func Decode(s string) map[string]string {
+	assignments := map[string]string{}
+	for _, pair := range strings.Split(s, "|") {
+		name, variant, ok := strings.Cut(pair, ":")
+		if ok && namePattern.MatchString(name) && namePattern.MatchString(variant) {
+			assignments[name] = variant
+		}
+	}
+	return assignments
+}
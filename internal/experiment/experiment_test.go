@@ -0,0 +1,113 @@
+package experiment
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(body string) string {
+		path := filepath.Join(dir, "experiments.json")
+		if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	defs, err := Load(write(`[{"name": "checkout_button", "variants": [{"name": "control"}, {"name": "green", "weight": 3}]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 || defs[0].Variants[1].Weight != 3 {
+		t.Errorf("defs = %+v", defs)
+	}
+
+	for name, body := range map[string]string{
+		"one variant":       `[{"name": "x", "variants": [{"name": "a"}]}]`,
+		"duplicate name":    `[{"name": "x", "variants": [{"name": "a"}, {"name": "b"}]}, {"name": "x", "variants": [{"name": "a"}, {"name": "b"}]}]`,
+		"duplicate variant": `[{"name": "x", "variants": [{"name": "a"}, {"name": "a"}]}]`,
+		"cookie separator":  `[{"name": "x|y", "variants": [{"name": "a"}, {"name": "b"}]}]`,
+		"negative weight":   `[{"name": "x", "variants": [{"name": "a", "weight": -1}, {"name": "b"}]}]`,
+		"not an array":      `{"name": "x"}`,
+	} {
+		if _, err := Load(write(body)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestAssigner(t *testing.T) {
+	a, err := New([]Experiment{
+		{Name: "checkout_button", Variants: []Variant{{Name: "control"}, {Name: "green", Weight: 3}}},
+		{Name: "pricing", Variants: []Variant{{Name: "a"}, {Name: "b"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	for i := range 4000 {
+		visitor := fmt.Sprintf("visitor-%d", i)
+		assignments, assigned := a.Assign(visitor, nil)
+		if len(assigned) != 2 {
+			t.Fatalf("assigned = %v", assigned)
+		}
+		again, _ := a.Assign(visitor, nil)
+		if !maps.Equal(assignments, again) {
+			t.Fatalf("%s assigned %v, then %v", visitor, assignments, again)
+		}
+		counts[assignments["checkout_button"]]++
+	}
+	if share := float64(counts["green"]) / 4000; share < 0.7 || share > 0.8 {
+		t.Errorf("green got %.2f of visitors, want about 0.75", share)
+	}
+
+	// First-touch assignments survive weight changes; unknown variants are reassigned
+	prior := map[string]string{"checkout_button": "control", "pricing": "retired"}
+	if err := a.Update([]Experiment{
+		{Name: "checkout_button", Variants: []Variant{{Name: "control", Weight: 1}, {Name: "green", Weight: 99}}},
+		{Name: "pricing", Variants: []Variant{{Name: "a"}, {Name: "b"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assignments, assigned := a.Assign("visitor-1", prior)
+	if assignments["checkout_button"] != "control" || assignments["pricing"] == "retired" || len(assigned) != 1 || assigned[0] != "pricing" {
+		t.Errorf("assignments = %v, assigned = %v", assignments, assigned)
+	}
+
+	// Paused experiments and unknown visitors only keep prior assignments
+	if err := a.Update([]Experiment{{Name: "checkout_button", Paused: true, Variants: []Variant{{Name: "control"}, {Name: "green"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if assignments, _ := a.Assign("visitor-2", nil); len(assignments) != 0 {
+		t.Errorf("paused experiment assigned %v", assignments)
+	}
+	if assignments, _ := a.Assign("", prior); !maps.Equal(assignments, map[string]string{"checkout_button": "control"}) {
+		t.Errorf("without a visitor assignments = %v", assignments)
+	}
+	if err := a.Update([]Experiment{{Name: "x", Variants: []Variant{{Name: "a"}}}}); err == nil {
+		t.Error("invalid update accepted")
+	}
+	if assignments, _ := a.Assign("", prior); len(assignments) != 1 {
+		t.Errorf("invalid update replaced the experiments: %v", assignments)
+	}
+}
+
+func TestCookie(t *testing.T) {
+	assignments := map[string]string{"pricing": "b", "checkout_button": "green"}
+	encoded := Encode(assignments)
+	if encoded != "checkout_button:green|pricing:b" {
+		t.Errorf("Encode() = %q", encoded)
+	}
+	if got := Decode(encoded); !maps.Equal(got, assignments) {
+		t.Errorf("Decode() = %v", got)
+	}
+	if got := Decode("pricing:b|broken|x:|:y|" + strings.Repeat("z", 70) + ":a"); !maps.Equal(got, map[string]string{"pricing": "b"}) {
+		t.Errorf("Decode() kept malformed pairs: %v", got)
+	}
+}
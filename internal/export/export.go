@@ -0,0 +1,105 @@
+// Package export writes every stored event matching a query as NDJSON or
+// CSV, to answer data subject access requests without hand-written SQL.
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+// Export formats
+const (
+	FormatNDJSON = "ndjson" // one stored event per line, as the sink returns it
+	FormatCSV    = "csv"    // common fields as columns, plus the whole event as JSON
+)
+
+// pageSize is the number of events fetched per query
+const pageSize = 500
+
+// Columns are the CSV header. The last column holds the whole event, so
+// fields without a column of their own are still exported.
+var Columns = []string{"event_id", "ts", "type", "site_id", "visitor_id", "session_id", "domain", "path", "referrer", "ip", "ua", "event"}
+
+// ParseFormat validates a format name; empty means FormatNDJSON
+func ParseFormat(s string) (string, error) {
+	switch f := strings.ToLower(strings.TrimSpace(s)); f {
+	case "":
+		return FormatNDJSON, nil
+	case FormatNDJSON, FormatCSV:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown export format %q (want ndjson or csv)", s)
+	}
+}
+
+// Subject reports whether q identifies a data subject, by visitor ID or IP.
+// Exports without one would dump the whole table.
+func Subject(q sink.Query) bool {
+	return q.VisitorID != "" || q.IP != ""
+}
+
+// Write pages through every event matching q, newest first, and writes them
+// to w in format. It returns how many events it wrote; on error, the events
+// before it have already been written.
+func Write(ctx context.Context, src sink.Querier, q sink.Query, format string, w io.Writer) (int, error) {
+	if !Subject(q) {
+		return 0, errors.New("a visitor ID or IP is required")
+	}
+	q.Limit, q.Cursor = pageSize, ""
+	n := 0
+	var cw *csv.Writer
+	for {
+		page, err := src.QueryEvents(ctx, q)
+		if err != nil {
+			return n, err
+		}
+		// Nothing is written until the first page is read, so callers can
+		// still report a failed query
+		if format == FormatCSV && cw == nil {
+			cw = csv.NewWriter(w)
+			if err := cw.Write(Columns); err != nil {
+				return 0, err
+			}
+		}
+		for _, raw := range page.Events {
+			if cw != nil {
+				err = cw.Write(row(raw))
+			} else {
+				_, err = w.Write(append(raw, '\n'))
+			}
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+		if cw != nil {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return n, err
+			}
+		}
+		if page.NextCursor == "" {
+			return n, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// row returns the CSV record for a stored event. Events that don't decode
+// still get their JSON in the last column.
+func row(raw json.RawMessage) []string {
+	var ev event.Event
+	_ = json.Unmarshal(raw, &ev)
+	return []string{
+		ev.EventID, ev.TS, ev.Type, ev.SiteID, ev.Session.VisitorID, ev.Session.SessionID,
+		ev.Route.Domain, ev.Route.Path, ev.URL.Referrer, ev.Server.IP, ev.Device.UA, string(raw),
+	}
+}
@@ -0,0 +1,79 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+// pages serves canned pages keyed by cursor
+type pages map[string]sink.Page
+
+func (p pages) QueryEvents(ctx context.Context, q sink.Query) (sink.Page, error) {
+	page, ok := p[q.Cursor]
+	if !ok {
+		return sink.Page{}, errors.New("unexpected cursor")
+	}
+	return page, nil
+}
+
+func TestParseFormat(t *testing.T) {
+	for in, want := range map[string]string{"": FormatNDJSON, "NDJSON": FormatNDJSON, " csv": FormatCSV} {
+		if got, err := ParseFormat(in); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("xlsx"); err == nil {
+		t.Error("ParseFormat(xlsx) succeeded")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	src := pages{
+		"": {Events: []json.RawMessage{
+			json.RawMessage(`{"event_id":"a","ts":"2026-10-17T12:00:00Z","type":"pageview","session":{"visitor_id":"v1"},"route":{"domain":"shop.example","path":"/cart"},"server":{"ip_hash":"5f0c"}}`),
+		}, NextCursor: "c1"},
+		"c1": {Events: []json.RawMessage{json.RawMessage(`{"event_id":"b","session":{"visitor_id":"v1"}}`)}},
+	}
+	q := sink.Query{VisitorID: "v1"}
+
+	var out bytes.Buffer
+	if n, err := Write(context.Background(), src, q, FormatNDJSON, &out); err != nil || n != 2 {
+		t.Fatalf("Write = %d, %v; want 2 events", n, err)
+	}
+	if want := string(src[""].Events[0]) + "\n" + string(src["c1"].Events[0]) + "\n"; out.String() != want {
+		t.Errorf("ndjson = %q", out.String())
+	}
+
+	out.Reset()
+	if _, err := Write(context.Background(), src, q, FormatCSV, &out); err != nil {
+		t.Fatalf("Write csv: %v", err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("csv = %v, %v; want a header and 2 rows", records, err)
+	}
+	want := []string{"a", "2026-10-17T12:00:00Z", "pageview", "", "v1", "", "shop.example", "/cart", "", "5f0c", ""}
+	for i, v := range want {
+		if records[1][i] != v {
+			t.Errorf("column %s = %q, want %q", Columns[i], records[1][i], v)
+		}
+	}
+	if records[2][len(Columns)-1] != string(src["c1"].Events[0]) {
+		t.Errorf("event column = %q", records[2][len(Columns)-1])
+	}
+
+	if _, err := Write(context.Background(), src, sink.Query{}, FormatNDJSON, &out); err == nil {
+		t.Error("Write succeeded without a visitor ID or IP")
+	}
+
+	out.Reset()
+	if n, err := Write(context.Background(), pages{}, q, FormatCSV, &out); err == nil || n != 0 || out.Len() != 0 {
+		t.Errorf("failed query: n = %d, err = %v, output %q; want nothing written", n, err, out.String())
+	}
+}
@@ -0,0 +1,324 @@
+// Package fieldcrypt encrypts sensitive event fields with AES-256-GCM before
+// events reach the sinks, so IPs, user agents and click IDs are not stored in
+// plaintext, and decrypts them again for authorized reads.
+package fieldcrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Prefix marks an encrypted value: enc:v1:<key id>:<base64url nonce and ciphertext>
+const Prefix = "enc:v1:"
+
+// Field groups for FIELD_ENCRYPTION_FIELDS
+const (
+	FieldIP       = "ip"        // server.ip_hash
+	FieldUA       = "ua"        // device.ua
+	FieldClickIDs = "click_ids" // typed and registered click IDs, the raw query and click ID route query parameters
+	FieldReferrer = "referrer"  // url.referrer
+)
+
+// knownFields lists the field groups in the order they are documented
+var knownFields = []string{FieldIP, FieldUA, FieldClickIDs, FieldReferrer}
+
+// Keyring holds the AES-256 keys by ID. The first key encrypts; all of them
+// decrypt, so a new key can be put first while old values stay readable.
+type Keyring struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// ParseKeys reads "id:base64key" entries separated by commas or newlines.
+// Keys are 32 bytes, in standard or URL-safe base64; IDs are letters, digits,
+// "-" and "_".
+func ParseKeys(spec string) (*Keyring, error) {
+	k := &Keyring{aeads: make(map[string]cipher.AEAD)}
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || !validKeyID(id) {
+			return nil, fmt.Errorf("invalid key entry %q (want id:base64key)", redact(entry))
+		}
+		if _, dup := k.aeads[id]; dup {
+			return nil, fmt.Errorf("duplicate key ID %q", id)
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		if k.current == "" {
+			k.current = id
+		}
+		k.aeads[id] = aead
+	}
+	if k.current == "" {
+		return nil, errors.New("no encryption keys")
+	}
+	return k, nil
+}
+
+// LoadKeys reads the keys from spec, or from the file at path when spec is
+// empty, such as a secret a KMS or secret manager mounts into the container
+func LoadKeys(spec, path string) (*Keyring, error) {
+	if spec == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		spec = string(data)
+	}
+	return ParseKeys(spec)
+}
+
+func validKeyID(id string) bool {
+	if id == "" || len(id) > 32 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if key, err := enc.DecodeString(s); err == nil {
+			if len(key) != 32 {
+				return nil, fmt.Errorf("want a 32-byte key, got %d bytes", len(key))
+			}
+			return key, nil
+		}
+	}
+	return nil, errors.New("key is not base64")
+}
+
+// redact keeps key material out of error messages
+func redact(entry string) string {
+	if id, _, ok := strings.Cut(entry, ":"); ok {
+		return id + ":…"
+	}
+	return "…"
+}
+
+// Encrypt seals s with the current key. Empty and already encrypted values
+// are returned as is.
+func (k *Keyring) Encrypt(s string) string {
+	if s == "" || IsEncrypted(s) {
+		return s
+	}
+	aead := k.aeads[k.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(s)+aead.Overhead())
+	_, _ = rand.Read(nonce)
+	// The key ID is authenticated, so a value can't be moved to another key
+	sealed := aead.Seal(nonce, nonce, []byte(s), []byte(k.current))
+	return Prefix + k.current + ":" + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// Decrypt opens a value Encrypt produced. Values without the prefix are
+// returned as is.
+func (k *Keyring) Decrypt(s string) (string, error) {
+	rest, ok := strings.CutPrefix(s, Prefix)
+	if !ok {
+		return s, nil
+	}
+	id, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", errors.New("malformed encrypted value")
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return "", fmt.Errorf("unknown key ID %q", id)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value with key %q", id)
+	}
+	return string(plain), nil
+}
+
+// IsEncrypted reports whether s carries the encrypted value prefix
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, Prefix)
+}
+
+// Encryptor encrypts the configured field groups of events for every sink
+// not skipped
+type Encryptor struct {
+	keys   *Keyring
+	fields map[string]bool
+	skip   map[string]bool
+}
+
+// New builds an encryptor. It returns nil when no fields are configured.
+func New(keys *Keyring, fields, skipSinks []string) (*Encryptor, error) {
+	e := &Encryptor{keys: keys, fields: make(map[string]bool), skip: make(map[string]bool)}
+	for _, f := range fields {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		if !slices.Contains(knownFields, f) {
+			return nil, fmt.Errorf("unknown encrypted field %q (want %s)", f, strings.Join(knownFields, ", "))
+		}
+		e.fields[f] = true
+	}
+	if len(e.fields) == 0 {
+		return nil, nil
+	}
+	if keys == nil {
+		return nil, errors.New("field encryption needs FIELD_ENCRYPTION_KEYS or FIELD_ENCRYPTION_KEYS_FILE")
+	}
+	for _, s := range skipSinks {
+		if s = strings.TrimSpace(s); s != "" {
+			e.skip[s] = true
+		}
+	}
+	return e, nil
+}
+
+// Seal encrypts a whole record, such as a write-ahead log entry, with the
+// current key. A nil encryptor returns record as is.
+func (e *Encryptor) Seal(record []byte) []byte {
+	if e == nil {
+		return record
+	}
+	return []byte(e.keys.Encrypt(string(record)))
+}
+
+// Open decrypts a record Seal produced. Records without the encrypted value
+// prefix are returned as is.
+func (e *Encryptor) Open(record []byte) ([]byte, error) {
+	if !bytes.HasPrefix(record, []byte(Prefix)) {
+		return record, nil
+	}
+	if e == nil {
+		return nil, errors.New("record is encrypted but field encryption is off")
+	}
+	plain, err := e.keys.Decrypt(string(record))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(plain), nil
+}
+
+// Apply returns ev with the configured fields encrypted for sink. Maps are
+// copied before they are changed, so other sinks' copies are unaffected. A
+// nil encryptor returns ev as is.
+func (e *Encryptor) Apply(sink string, ev event.Event) event.Event {
+	if e == nil || e.skip[sink] {
+		return ev
+	}
+	enc := e.keys.Encrypt
+	if e.fields[FieldIP] {
+		ev.Server.IP = enc(ev.Server.IP)
+	}
+	if e.fields[FieldUA] {
+		ev.Device.UA = enc(ev.Device.UA)
+	}
+	if e.fields[FieldReferrer] {
+		ev.URL.Referrer = enc(ev.URL.Referrer)
+	}
+	if e.fields[FieldClickIDs] {
+		for _, v := range []*string{
+			&ev.URL.Google.GCLID, &ev.URL.Google.GBRAID, &ev.URL.Google.WBRAID,
+			&ev.URL.Meta.FBCLID, &ev.URL.Meta.FBC, &ev.URL.Meta.FBP,
+			&ev.URL.Microsoft.MSCLKID, &ev.URL.RawQuery,
+		} {
+			*v = enc(*v)
+		}
+		if len(ev.URL.OtherIDs) > 0 {
+			ev.URL.OtherIDs = maps.Clone(ev.URL.OtherIDs)
+			for k, v := range ev.URL.OtherIDs {
+				ev.URL.OtherIDs[k] = enc(v)
+			}
+		}
+		if slices.ContainsFunc(slices.Collect(maps.Keys(ev.Route.Query)), event.IsClickIDParam) {
+			ev.Route.Query = maps.Clone(ev.Route.Query)
+			for k, v := range ev.Route.Query {
+				if event.IsClickIDParam(k) {
+					ev.Route.Query[k] = enc(v)
+				}
+			}
+		}
+	}
+	return ev
+}
+
+// DecryptJSON decrypts every encrypted string in a JSON document, wherever
+// it is, so exports and sink output can be read without knowing which
+// fields were encrypted. Numbers keep their exact text; object keys come
+// out sorted.
+func (k *Keyring) DecryptJSON(doc []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	v, err := k.decryptValue(v)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), nil
+}
+
+func (k *Keyring) decryptValue(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return k.Decrypt(v)
+	case map[string]any:
+		for key, elem := range v {
+			d, err := k.decryptValue(elem)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			v[key] = d
+		}
+	case []any:
+		for i, elem := range v {
+			d, err := k.decryptValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = d
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,181 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// testKey returns a base64 key of 32 copies of b
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func TestParseKeys(t *testing.T) {
+	k, err := ParseKeys("new:" + testKey('n') + ",\nold:" + testKey('o'))
+	if err != nil {
+		t.Fatalf("ParseKeys error = %v", err)
+	}
+	if k.current != "new" || len(k.aeads) != 2 {
+		t.Errorf("current %q, %d keys", k.current, len(k.aeads))
+	}
+
+	for name, spec := range map[string]string{
+		"empty":     "",
+		"no id":     testKey('a'),
+		"bad id":    "a b:" + testKey('a'),
+		"short key": "k1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"not b64":   "k1:!!!",
+		"duplicate": "k1:" + testKey('a') + ",k1:" + testKey('b'),
+	} {
+		_, err := ParseKeys(spec)
+		if err == nil {
+			t.Errorf("%s: ParseKeys succeeded, want error", name)
+		} else if strings.Contains(err.Error(), testKey('a')) {
+			t.Errorf("%s: error %q leaks the key", name, err)
+		}
+	}
+}
+
+func TestLoadKeys_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("# rotated 2026-10\nk2:"+testKey('2')+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := LoadKeys("", path)
+	if err != nil || k.current != "k2" {
+		t.Fatalf("LoadKeys = %+v, %v", k, err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	oldKeys, _ := ParseKeys("old:" + testKey('o'))
+	keys, _ := ParseKeys("new:" + testKey('n') + ",old:" + testKey('o'))
+
+	sealed := keys.Encrypt("203.0.113.7")
+	if !strings.HasPrefix(sealed, Prefix+"new:") || strings.Contains(sealed, "203.0.113.7") {
+		t.Fatalf("Encrypt = %q", sealed)
+	}
+	if again := keys.Encrypt("203.0.113.7"); again == sealed {
+		t.Error("Encrypt is deterministic, want a fresh nonce per value")
+	}
+	if keys.Encrypt(sealed) != sealed || keys.Encrypt("") != "" {
+		t.Error("Encrypt changed an encrypted or empty value")
+	}
+	if plain, err := keys.Decrypt(sealed); err != nil || plain != "203.0.113.7" {
+		t.Errorf("Decrypt = %q, %v", plain, err)
+	}
+
+	// Values under a rotated-out key stay readable while it is listed
+	if plain, err := keys.Decrypt(oldKeys.Encrypt("ua")); err != nil || plain != "ua" {
+		t.Errorf("Decrypt(old key) = %q, %v", plain, err)
+	}
+	if plain, err := keys.Decrypt("plain"); err != nil || plain != "plain" {
+		t.Errorf("Decrypt(plaintext) = %q, %v", plain, err)
+	}
+
+	for name, s := range map[string]string{
+		"unknown key": strings.Replace(sealed, ":new:", ":gone:", 1),
+		"other key":   strings.Replace(sealed, ":new:", ":old:", 1),
+		"tampered":    sealed[:len(sealed)-2] + "AA",
+		"malformed":   Prefix + "new",
+	} {
+		if _, err := keys.Decrypt(s); err == nil {
+			t.Errorf("%s: Decrypt succeeded, want error", name)
+		}
+	}
+}
+
+func TestEncryptor(t *testing.T) {
+	keys, _ := ParseKeys("k1:" + testKey('k'))
+	if e, err := New(keys, nil, nil); e != nil || err != nil {
+		t.Errorf("New without fields = %v, %v; want nil", e, err)
+	}
+	if _, err := New(keys, []string{"email"}, nil); err == nil {
+		t.Error("New accepted an unknown field")
+	}
+	if _, err := New(nil, []string{"ip"}, nil); err == nil {
+		t.Error("New accepted fields without keys")
+	}
+
+	enc, err := New(keys, []string{"ip", "UA", "click_ids"}, []string{"google_ads"})
+	if err != nil {
+		t.Fatalf("New error = %v", err)
+	}
+	ev := event.Event{}
+	event.ApplyPageURL(&ev, "https://shop.example/?gclid=g1&ttclid=t1&plan=pro")
+	ev.URL.RawQuery = "gclid=g1&ttclid=t1&plan=pro"
+	ev.Server.IP = "203.0.113.7"
+	ev.Device.UA = "Mozilla/5.0"
+	ev.URL.Referrer = "https://news.example/"
+	query := ev.Route.Query
+
+	out := enc.Apply("kafka", ev)
+	for name, v := range map[string]string{
+		"ip": out.Server.IP, "ua": out.Device.UA, "gclid": out.URL.Google.GCLID,
+		"raw query": out.URL.RawQuery, "ttclid": out.URL.OtherIDs["ttclid"], "route gclid": out.Route.Query["gclid"],
+	} {
+		if !IsEncrypted(v) {
+			t.Errorf("%s = %q, want encrypted", name, v)
+		}
+	}
+	if out.Route.Query["plan"] != "pro" || out.URL.Referrer != ev.URL.Referrer {
+		t.Errorf("plan %q, referrer %q; want them untouched", out.Route.Query["plan"], out.URL.Referrer)
+	}
+	if query["gclid"] != "g1" || ev.URL.OtherIDs["ttclid"] != "t1" {
+		t.Error("Apply changed the maps of the original event")
+	}
+	if skipped := enc.Apply("google_ads", ev); skipped.URL.Google.GCLID != "g1" {
+		t.Errorf("skipped sink got gclid %q", skipped.URL.Google.GCLID)
+	}
+	if (*Encryptor)(nil).Apply("kafka", ev).Server.IP != "203.0.113.7" {
+		t.Error("nil Encryptor changed the event")
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	keys, _ := ParseKeys("k1:" + testKey('k'))
+	e, _ := New(keys, []string{FieldIP}, nil)
+	record := []byte(`{"server":{"ip_hash":"203.0.113.7"}}`)
+
+	sealed := e.Seal(record)
+	if bytes.Contains(sealed, []byte("203.0.113.7")) {
+		t.Fatalf("sealed record %q holds the plaintext", sealed)
+	}
+	if opened, err := e.Open(sealed); err != nil || !bytes.Equal(opened, record) {
+		t.Errorf("Open = %q, %v", opened, err)
+	}
+	if opened, err := e.Open(record); err != nil || !bytes.Equal(opened, record) {
+		t.Errorf("Open(plaintext) = %q, %v", opened, err)
+	}
+
+	var off *Encryptor
+	if got := off.Seal(record); !bytes.Equal(got, record) {
+		t.Errorf("nil Seal = %q", got)
+	}
+	if _, err := off.Open(sealed); err == nil {
+		t.Error("nil Open of a sealed record should fail")
+	}
+}
+
+func TestDecryptJSON(t *testing.T) {
+	keys, _ := ParseKeys("k1:" + testKey('k'))
+	doc := `{"event_id":"e1","sample_rate":0.25,"server":{"ip_hash":"` + keys.Encrypt("203.0.113.7") +
+		`"},"device":{"ua_brands":["` + keys.Encrypt("Chromium") + `"]},"url":{"referrer":"https://a.example/?x=1&y=2"}}`
+	out, err := keys.DecryptJSON([]byte(doc))
+	if err != nil {
+		t.Fatalf("DecryptJSON error = %v", err)
+	}
+	want := `{"device":{"ua_brands":["Chromium"]},"event_id":"e1","sample_rate":0.25,"server":{"ip_hash":"203.0.113.7"},"url":{"referrer":"https://a.example/?x=1&y=2"}}`
+	if string(out) != want {
+		t.Errorf("DecryptJSON =\n%s\nwant\n%s", out, want)
+	}
+	if _, err := keys.DecryptJSON([]byte(`{"ip":"` + Prefix + `k1:AAAA"}`)); err == nil || !strings.Contains(err.Error(), "ip") {
+		t.Errorf("DecryptJSON error = %v, want one naming the field", err)
+	}
+}
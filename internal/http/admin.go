@@ -0,0 +1,432 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shortontech/gotrack/internal/campaign"
+	"github.com/shortontech/gotrack/internal/export"
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+// adminPathPrefix namespaces admin endpoints so they never shadow paths on the proxied site
+const adminPathPrefix = "/_gotrack/"
+
+// requireAdmin restricts a handler to requests bearing the ADMIN_TOKEN
+func (e Env) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if e.Cfg.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !validBearerToken(r, e.Cfg.AdminToken) {
+			http.Error(w, "invalid or missing admin token", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		next(w, r)
+	}
+}
+
+// AdminClusters returns the top device clusters by unique IP count.
+// Query params: limit (default 20, max 500), min_ips (default 1).
+func (e Env) AdminClusters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.Clusters == nil {
+		http.Error(w, "cluster report not enabled", http.StatusNotFound)
+		return
+	}
+
+	limit := queryInt(r, "limit", 20)
+	if limit <= 0 || limit > 500 {
+		limit = 20
+	}
+	minIPs := queryInt(r, "min_ips", 1)
+
+	clusters, builtAt := e.Clusters.Top(limit, minIPs)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"generated_at": builtAt.UTC().Format(time.RFC3339),
+		"clusters":     clusters,
+	})
+}
+
+// EventSearcher looks up stored events by an indexed field
+type EventSearcher interface {
+	FindEvents(ctx context.Context, field, value string, limit int) ([]json.RawMessage, error)
+}
+
+// searchFields are the lookup keys AdminEvents accepts, in precedence order
+var searchFields = []string{"event_id", "gclid", "fbclid", "msclkid"}
+
+// actorHeader names the person behind an admin request. The admin token is
+// shared, so lookups of stored events require it for the audit trail.
+const actorHeader = "X-GoTrack-Actor"
+
+// AdminEvents looks up stored events for support workflows, e.g.
+// GET /_gotrack/admin/events?gclid=XYZ. Exactly one of event_id, gclid,
+// fbclid or msclkid is required; limit defaults to 20 (max 100).
+// Every lookup is written to the audit log.
+func (e Env) AdminEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.Search == nil {
+		http.Error(w, "event search requires the postgres sink", http.StatusNotFound)
+		return
+	}
+
+	actor := strings.TrimSpace(r.Header.Get(actorHeader))
+	if actor == "" {
+		http.Error(w, actorHeader+" header is required", http.StatusBadRequest)
+		return
+	}
+
+	var field, value string
+	query := r.URL.Query()
+	for _, f := range searchFields {
+		if v := strings.TrimSpace(query.Get(f)); v != "" {
+			if field != "" {
+				http.Error(w, "specify only one of event_id, gclid, fbclid, msclkid", http.StatusBadRequest)
+				return
+			}
+			field, value = f, v
+		}
+	}
+	if field == "" {
+		http.Error(w, "one of event_id, gclid, fbclid, msclkid is required", http.StatusBadRequest)
+		return
+	}
+	if field == "event_id" {
+		if _, err := uuid.Parse(value); err != nil {
+			http.Error(w, "event_id must be a UUID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := queryInt(r, "limit", 20)
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	events, err := e.Search.FindEvents(ctx, field, value, limit)
+	details := map[string]any{"field": field, "value": value, "results": len(events)}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	e.auditLog(r, actor, "events.search", details)
+	if err != nil {
+		http.Error(w, "event search failed", http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = []json.RawMessage{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"field":  field,
+		"value":  value,
+		"count":  len(events),
+		"events": events,
+	})
+}
+
+// nextCursorHeader carries the next page cursor on NDJSON responses
+const nextCursorHeader = "X-GoTrack-Next-Cursor"
+
+// QueryEvents lists recent stored events for debugging ingestion, e.g.
+// GET /_gotrack/api/events?type=click&since=1h&visitor_id=v1. Filters: type,
+// visitor_id, session_id, ip, since and until (a duration such as 30m, 1h or 7d
+// before now, or an RFC3339 time). Pages hold limit events (default 50, max
+// 500); pass the returned next_cursor as cursor for the next page. Responses
+// are JSON unless format=ndjson or the client accepts application/x-ndjson.
+// Like AdminEvents, every query is written to the audit log.
+func (e Env) QueryEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.Query == nil {
+		http.Error(w, "event queries require the postgres sink", http.StatusNotFound)
+		return
+	}
+
+	actor := strings.TrimSpace(r.Header.Get(actorHeader))
+	if actor == "" {
+		http.Error(w, actorHeader+" header is required", http.StatusBadRequest)
+		return
+	}
+
+	params := r.URL.Query()
+	q := sink.Query{
+		Type:      strings.TrimSpace(params.Get("type")),
+		VisitorID: strings.TrimSpace(params.Get("visitor_id")),
+		SessionID: strings.TrimSpace(params.Get("session_id")),
+		IP:        strings.TrimSpace(params.Get("ip")),
+		Cursor:    params.Get("cursor"),
+		Limit:     queryInt(r, "limit", 50),
+	}
+	if q.Limit <= 0 || q.Limit > 500 {
+		q.Limit = 50
+	}
+	now := time.Now()
+	var err error
+	if q.Since, err = parseTimeBound(params.Get("since"), now); err != nil {
+		http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Until, err = parseTimeBound(params.Get("until"), now); err != nil {
+		http.Error(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	page, err := e.Query.QueryEvents(ctx, q)
+	details := map[string]any{
+		"type": q.Type, "visitor_id": q.VisitorID, "session_id": q.SessionID, "ip": q.IP,
+		"since": params.Get("since"), "until": params.Get("until"), "results": len(page.Events),
+	}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	e.auditLog(r, actor, "events.query", details)
+	if errors.Is(err, sink.ErrInvalidCursor) {
+		http.Error(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "event query failed", http.StatusInternalServerError)
+		return
+	}
+
+	if wantsNDJSON(r) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if page.NextCursor != "" {
+			w.Header().Set(nextCursorHeader, page.NextCursor)
+		}
+		w.WriteHeader(http.StatusOK)
+		for _, ev := range page.Events {
+			_, _ = w.Write(append(ev, '\n'))
+		}
+		return
+	}
+
+	if page.Events == nil {
+		page.Events = []json.RawMessage{}
+	}
+	writeJSON(w, http.StatusOK, eventsPage{Count: len(page.Events), Events: page.Events, NextCursor: page.NextCursor})
+}
+
+// eventsPage is one page of /_gotrack/api/events
+type eventsPage struct {
+	Count      int               `json:"count"`
+	Events     []json.RawMessage `json:"events"`
+	NextCursor string            `json:"next_cursor"` // empty on the last page
+}
+
+// ExportEvents writes every stored event of one data subject, to answer an
+// access request: GET /_gotrack/api/export?visitor_id=v1&format=csv. The
+// subject is visitor_id or ip, the latter as stored, so hashed under
+// IP_PRIVACY_MODE=hash; since and until narrow it as for QueryEvents. The
+// response is NDJSON unless format=csv, and is audited with its size.
+func (e Env) ExportEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.Query == nil {
+		http.Error(w, "event exports require the postgres sink", http.StatusNotFound)
+		return
+	}
+
+	actor := strings.TrimSpace(r.Header.Get(actorHeader))
+	if actor == "" {
+		http.Error(w, actorHeader+" header is required", http.StatusBadRequest)
+		return
+	}
+
+	params := r.URL.Query()
+	q := sink.Query{
+		VisitorID: strings.TrimSpace(params.Get("visitor_id")),
+		IP:        strings.TrimSpace(params.Get("ip")),
+	}
+	if !export.Subject(q) {
+		http.Error(w, "visitor_id or ip is required", http.StatusBadRequest)
+		return
+	}
+	format, err := export.ParseFormat(params.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if q.Since, err = parseTimeBound(params.Get("since"), now); err != nil {
+		http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Until, err = parseTimeBound(params.Get("until"), now); err != nil {
+		http.Error(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	contentType := "application/x-ndjson"
+	if format == export.FormatCSV {
+		contentType = "text/csv; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="gotrack-export.`+format+`"`)
+
+	// The status is sent with the first page, so a later failure can only
+	// cut the response short; the audit entry records it
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	n, err := export.Write(ctx, e.Query, q, format, w)
+	details := map[string]any{
+		"visitor_id": q.VisitorID, "ip": q.IP, "format": format,
+		"since": params.Get("since"), "until": params.Get("until"), "results": n,
+	}
+	if err != nil {
+		details["error"] = err.Error()
+		if n == 0 {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "event export failed", http.StatusInternalServerError)
+		}
+	}
+	e.auditLog(r, actor, "events.export", details)
+}
+
+// parseTimeBound parses a since/until parameter: a duration before now
+// (Go syntax, plus a "d" suffix for days) or an RFC3339 time. Empty means unbounded.
+func parseTimeBound(v string, now time.Time) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, errors.New("want a duration like 1h or 7d, or an RFC3339 time")
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return time.Time{}, errors.New("want a duration like 1h or 7d, or an RFC3339 time")
+	}
+	return now.Add(-d), nil
+}
+
+// wantsNDJSON reports whether the client asked for newline-delimited JSON
+func wantsNDJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "ndjson"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// auditLog records an admin action as a single JSON line prefixed with "AUDIT".
+// The remote is the admin's address as resolved through trusted proxies.
+func (e Env) auditLog(r *http.Request, actor, action string, details map[string]any) {
+	entry := map[string]any{
+		"ts":      time.Now().UTC().Format(time.RFC3339),
+		"actor":   actor,
+		"remote":  event.ClientIP(r, e.Cfg),
+		"action":  action,
+		"details": details,
+	}
+	line, _ := json.Marshal(entry)
+	log.Printf("AUDIT %s", line)
+}
+
+// queryInt parses an integer query parameter, falling back to def
+func queryInt(r *http.Request, key string, def int) int {
+	if v := r.URL.Query().Get(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// AdminCampaignURL reports how the campaign link in the url parameter is
+// parsed, with warnings for missing, misspelled and repeated parameters
+func (e Env) AdminCampaignURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	raw := r.URL.Query().Get("url")
+	if raw == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+	report, err := campaign.Inspect(raw)
+	if err != nil {
+		http.Error(w, "invalid url: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// AdminReload re-reads configuration and applies settings that are safe to
+// change at runtime, equivalent to sending SIGHUP
+func (e Env) AdminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.Reload == nil {
+		http.Error(w, "reload not available", http.StatusNotFound)
+		return
+	}
+	if err := e.Reload(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"status": "error", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+// AdminPurgeCache removes proxied responses from the cache, e.g.
+// POST /_gotrack/admin/cache/purge?prefix=/static/ after a deploy. Without a
+// prefix the whole cache is emptied.
+func (e Env) AdminPurgeCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.ProxyCache == nil {
+		http.Error(w, "proxy cache not enabled", http.StatusNotFound)
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		http.Error(w, "prefix must start with /", http.StatusBadRequest)
+		return
+	}
+	purged := e.ProxyCache.Purge(prefix)
+	log.Printf("admin: purged %d proxy cache entries with prefix %q", purged, prefix)
+	writeJSON(w, http.StatusOK, map[string]any{"status": "purged", "purged": purged})
+}
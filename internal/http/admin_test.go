@@ -0,0 +1,573 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/analytics"
+	"github.com/shortontech/gotrack/internal/proxycache"
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+func newAdminRequest(target string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	return req
+}
+
+// TestRequireAdmin tests admin bearer authentication
+func TestRequireAdmin(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	tests := []struct {
+		name     string
+		token    string
+		header   string
+		wantCode int
+	}{
+		{name: "valid token", token: "admin-token", header: "Bearer admin-token", wantCode: http.StatusOK},
+		{name: "wrong token", token: "admin-token", header: "Bearer nope", wantCode: http.StatusUnauthorized},
+		{name: "missing header", token: "admin-token", header: "", wantCode: http.StatusUnauthorized},
+		{name: "admin disabled", token: "", header: "Bearer admin-token", wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := Env{Cfg: cfg.Config{AdminToken: tt.token}}
+			req := httptest.NewRequest(http.MethodGet, "/_gotrack/admin/clusters", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			env.requireAdmin(ok)(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+// TestAdminClusters tests the device clustering report endpoint
+func TestAdminClusters(t *testing.T) {
+	tracker := analytics.NewClusterTracker(time.Hour, 100)
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		ev := event.Event{}
+		ev.Server.IP = ip
+		ev.Server.Detection.HeaderFingerprint = "farm"
+		tracker.Observe(ev)
+	}
+	tracker.Rebuild()
+
+	env := Env{Cfg: cfg.Config{AdminToken: "admin-token"}, Clusters: tracker}
+	mux := NewMux(env)
+
+	t.Run("returns ranked clusters", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAdminRequest("/_gotrack/admin/clusters?min_ips=2"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp struct {
+			Clusters []analytics.Cluster `json:"clusters"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		if len(resp.Clusters) != 1 || resp.Clusters[0].UniqueIPs != 3 {
+			t.Errorf("unexpected clusters: %+v", resp.Clusters)
+		}
+	})
+
+	t.Run("rejects non-GET", func(t *testing.T) {
+		req := newAdminRequest("/_gotrack/admin/clusters")
+		req.Method = http.MethodPost
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("status = %d, want 405", w.Code)
+		}
+	})
+
+	t.Run("not found when report disabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Env{Cfg: cfg.Config{AdminToken: "admin-token"}}.AdminClusters(w, newAdminRequest("/_gotrack/admin/clusters"))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", w.Code)
+		}
+	})
+}
+
+// TestAdminReload tests the runtime reload endpoint
+func TestAdminReload(t *testing.T) {
+	t.Run("invokes reload", func(t *testing.T) {
+		calls := 0
+		env := Env{Cfg: cfg.Config{AdminToken: "admin-token"}, Reload: func() error { calls++; return nil }}
+		req := newAdminRequest("/_gotrack/admin/reload")
+		req.Method = http.MethodPost
+		w := httptest.NewRecorder()
+		NewMux(env).ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || calls != 1 {
+			t.Errorf("status = %d, calls = %d", w.Code, calls)
+		}
+	})
+
+	t.Run("reports reload errors", func(t *testing.T) {
+		env := Env{Cfg: cfg.Config{AdminToken: "admin-token"}, Reload: func() error { return errors.New("bad config") }}
+		req := newAdminRequest("/_gotrack/admin/reload")
+		req.Method = http.MethodPost
+		w := httptest.NewRecorder()
+		env.AdminReload(w, req)
+
+		if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "bad config") {
+			t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("requires POST", func(t *testing.T) {
+		env := Env{Reload: func() error { return nil }}
+		w := httptest.NewRecorder()
+		env.AdminReload(w, newAdminRequest("/_gotrack/admin/reload"))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("status = %d, want 405", w.Code)
+		}
+	})
+}
+
+// TestAdminPurgeCache tests removing proxied responses from the cache
+func TestAdminPurgeCache(t *testing.T) {
+	cache, err := proxycache.New(proxycache.NewMemoryStore(), 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fill := func(paths ...string) {
+		for _, path := range paths {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			header := http.Header{"Cache-Control": {"max-age=60"}}
+			capture := cache.Capture(r, &http.Response{StatusCode: http.StatusOK}, header)
+			_, _ = capture.Write([]byte("x"))
+			capture.Save()
+		}
+	}
+	env := Env{Cfg: cfg.Config{AdminToken: "admin-token"}, ProxyCache: cache}
+	purge := func(target string) *httptest.ResponseRecorder {
+		req := newAdminRequest(target)
+		req.Method = http.MethodPost
+		w := httptest.NewRecorder()
+		NewMux(env).ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("purges by prefix", func(t *testing.T) {
+		fill("/static/a.css", "/static/b.js", "/logo.png")
+		w := purge("/_gotrack/admin/cache/purge?prefix=/static/")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"purged":2`) {
+			t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		if cache.Len() != 1 {
+			t.Errorf("%d entries left, want 1", cache.Len())
+		}
+	})
+
+	t.Run("purges everything without a prefix", func(t *testing.T) {
+		fill("/a.css")
+		w := purge("/_gotrack/admin/cache/purge")
+		if w.Code != http.StatusOK || cache.Len() != 0 {
+			t.Errorf("status = %d, %d entries left", w.Code, cache.Len())
+		}
+	})
+
+	t.Run("rejects relative prefixes", func(t *testing.T) {
+		if w := purge("/_gotrack/admin/cache/purge?prefix=static"); w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", w.Code)
+		}
+	})
+
+	t.Run("not found without a cache", func(t *testing.T) {
+		env := Env{}
+		req := newAdminRequest("/_gotrack/admin/cache/purge")
+		req.Method = http.MethodPost
+		w := httptest.NewRecorder()
+		env.AdminPurgeCache(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", w.Code)
+		}
+	})
+}
+
+// fakeSearcher records lookups and returns canned payloads
+type fakeSearcher struct {
+	field, value string
+	limit        int
+	err          error
+}
+
+func (f *fakeSearcher) FindEvents(ctx context.Context, field, value string, limit int) ([]json.RawMessage, error) {
+	f.field, f.value, f.limit = field, value, limit
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []json.RawMessage{json.RawMessage(`{"event_id":"evt-1","server":{"detection":{}}}`)}, nil
+}
+
+// TestAdminEvents tests stored event lookup for support workflows
+func TestAdminEvents(t *testing.T) {
+	newRequest := func(target string) *http.Request {
+		req := newAdminRequest(target)
+		req.Header.Set(actorHeader, "alice@example.com")
+		return req
+	}
+
+	t.Run("looks up by click ID and audits", func(t *testing.T) {
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		defer log.SetOutput(os.Stderr)
+
+		searcher := &fakeSearcher{}
+		env := Env{Cfg: cfg.Config{AdminToken: "admin-token"}, Search: searcher}
+		w := httptest.NewRecorder()
+		NewMux(env).ServeHTTP(w, newRequest("/_gotrack/admin/events?gclid=XYZ&limit=5"))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+		}
+		if searcher.field != "gclid" || searcher.value != "XYZ" || searcher.limit != 5 {
+			t.Errorf("unexpected lookup: %+v", searcher)
+		}
+		var body struct {
+			Count  int               `json:"count"`
+			Events []json.RawMessage `json:"events"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Count != 1 {
+			t.Errorf("unexpected response: %+v, %v", body, err)
+		}
+		audit := logs.String()
+		if !strings.Contains(audit, "AUDIT") || !strings.Contains(audit, "alice@example.com") || !strings.Contains(audit, "XYZ") {
+			t.Errorf("expected audit entry, got %q", audit)
+		}
+	})
+
+	t.Run("audits the admin behind a trusted proxy", func(t *testing.T) {
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		defer log.SetOutput(os.Stderr)
+
+		env := Env{Cfg: cfg.Config{AdminToken: "admin-token", TrustedProxyCIDRs: []string{"10.0.0.0/8"}}, Search: &fakeSearcher{}}
+		req := newRequest("/_gotrack/admin/events?gclid=XYZ")
+		req.RemoteAddr = "10.0.0.2:4321"
+		req.Header.Set("X-Forwarded-For", "203.0.113.5")
+		NewMux(env).ServeHTTP(httptest.NewRecorder(), req)
+
+		if audit := logs.String(); !strings.Contains(audit, `"remote":"203.0.113.5"`) {
+			t.Errorf("expected the forwarded admin address, got %q", audit)
+		}
+	})
+
+	t.Run("bad requests", func(t *testing.T) {
+		env := Env{Search: &fakeSearcher{}}
+		for _, target := range []string{
+			"/_gotrack/admin/events",
+			"/_gotrack/admin/events?gclid=a&fbclid=b",
+			"/_gotrack/admin/events?event_id=not-a-uuid",
+		} {
+			w := httptest.NewRecorder()
+			env.AdminEvents(w, newRequest(target))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s: status = %d, want 400", target, w.Code)
+			}
+		}
+	})
+
+	t.Run("requires actor", func(t *testing.T) {
+		env := Env{Search: &fakeSearcher{}}
+		w := httptest.NewRecorder()
+		env.AdminEvents(w, newAdminRequest("/_gotrack/admin/events?gclid=XYZ"))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", w.Code)
+		}
+	})
+
+	t.Run("search errors", func(t *testing.T) {
+		env := Env{Search: &fakeSearcher{err: errors.New("db down")}}
+		w := httptest.NewRecorder()
+		env.AdminEvents(w, newRequest("/_gotrack/admin/events?msclkid=abc"))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want 500", w.Code)
+		}
+	})
+
+	t.Run("not found without searchable sink", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Env{}.AdminEvents(w, newRequest("/_gotrack/admin/events?gclid=XYZ"))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", w.Code)
+		}
+	})
+}
+
+// fakeQuerier records queries and returns a canned page
+type fakeQuerier struct {
+	query sink.Query
+	page  sink.Page
+	err   error
+}
+
+func (f *fakeQuerier) QueryEvents(ctx context.Context, q sink.Query) (sink.Page, error) {
+	f.query = q
+	return f.page, f.err
+}
+
+// TestQueryEvents tests the recent events read API
+func TestQueryEvents(t *testing.T) {
+	newRequest := func(target string) *http.Request {
+		req := newAdminRequest(target)
+		req.Header.Set(actorHeader, "alice@example.com")
+		return req
+	}
+	page := sink.Page{
+		Events:     []json.RawMessage{json.RawMessage(`{"event_id":"a"}`), json.RawMessage(`{"event_id":"b"}`)},
+		NextCursor: "next-1",
+	}
+
+	t.Run("filters, pages and audits", func(t *testing.T) {
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		defer log.SetOutput(os.Stderr)
+
+		querier := &fakeQuerier{page: page}
+		env := Env{Cfg: cfg.Config{AdminToken: "admin-token"}, Query: querier}
+		w := httptest.NewRecorder()
+		before := time.Now()
+		NewMux(env).ServeHTTP(w, newRequest("/_gotrack/api/events?type=click&visitor_id=v1&since=1h&limit=2&cursor=c0"))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+		}
+		q := querier.query
+		if q.Type != "click" || q.VisitorID != "v1" || q.Limit != 2 || q.Cursor != "c0" || !q.Until.IsZero() {
+			t.Errorf("unexpected query: %+v", q)
+		}
+		if age := before.Sub(q.Since); age < 59*time.Minute || age > 61*time.Minute {
+			t.Errorf("since = %v, want about an hour ago", q.Since)
+		}
+
+		var body struct {
+			Count      int               `json:"count"`
+			Events     []json.RawMessage `json:"events"`
+			NextCursor string            `json:"next_cursor"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Count != 2 || body.NextCursor != "next-1" {
+			t.Errorf("unexpected response: %+v, %v", body, err)
+		}
+		if audit := logs.String(); !strings.Contains(audit, "events.query") || !strings.Contains(audit, "alice@example.com") {
+			t.Errorf("expected audit entry, got %q", audit)
+		}
+	})
+
+	t.Run("ndjson output", func(t *testing.T) {
+		env := Env{Query: &fakeQuerier{page: page}}
+		for _, req := range []*http.Request{
+			newRequest("/_gotrack/api/events?format=ndjson"),
+			func() *http.Request {
+				r := newRequest("/_gotrack/api/events")
+				r.Header.Set("Accept", "application/x-ndjson")
+				return r
+			}(),
+		} {
+			w := httptest.NewRecorder()
+			env.QueryEvents(w, req)
+			if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			if w.Header().Get(nextCursorHeader) != "next-1" {
+				t.Errorf("%s = %q", nextCursorHeader, w.Header().Get(nextCursorHeader))
+			}
+			if w.Body.String() != "{\"event_id\":\"a\"}\n{\"event_id\":\"b\"}\n" {
+				t.Errorf("body = %q", w.Body.String())
+			}
+		}
+	})
+
+	t.Run("bad requests", func(t *testing.T) {
+		env := Env{Query: &fakeQuerier{}}
+		for _, target := range []string{
+			"/_gotrack/api/events?since=yesterday",
+			"/_gotrack/api/events?until=-1h",
+		} {
+			w := httptest.NewRecorder()
+			env.QueryEvents(w, newRequest(target))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s: status = %d, want 400", target, w.Code)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		Env{Query: &fakeQuerier{err: sink.ErrInvalidCursor}}.QueryEvents(w, newRequest("/_gotrack/api/events?cursor=zzz"))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("invalid cursor: status = %d, want 400", w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		env.QueryEvents(w, newAdminRequest("/_gotrack/api/events"))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("missing actor: status = %d, want 400", w.Code)
+		}
+	})
+
+	t.Run("query errors", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Env{Query: &fakeQuerier{err: errors.New("db down")}}.QueryEvents(w, newRequest("/_gotrack/api/events"))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want 500", w.Code)
+		}
+	})
+
+	t.Run("not found without queryable sink", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Env{}.QueryEvents(w, newRequest("/_gotrack/api/events"))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", w.Code)
+		}
+	})
+}
+
+// pagedQuerier serves its pages in order, keyed by the cursor it was given
+type pagedQuerier struct {
+	pages   map[string]sink.Page
+	queries []sink.Query
+}
+
+func (p *pagedQuerier) QueryEvents(ctx context.Context, q sink.Query) (sink.Page, error) {
+	p.queries = append(p.queries, q)
+	return p.pages[q.Cursor], nil
+}
+
+// TestExportEvents tests the data subject export across pages
+func TestExportEvents(t *testing.T) {
+	newRequest := func(target string) *http.Request {
+		req := newAdminRequest(target)
+		req.Header.Set(actorHeader, "dpo@example.com")
+		return req
+	}
+	querier := &pagedQuerier{pages: map[string]sink.Page{
+		"":   {Events: []json.RawMessage{json.RawMessage(`{"event_id":"a","session":{"visitor_id":"v1"}}`)}, NextCursor: "c1"},
+		"c1": {Events: []json.RawMessage{json.RawMessage(`{"event_id":"b","session":{"visitor_id":"v1"}}`)}},
+	}}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	env := Env{Cfg: cfg.Config{AdminToken: "admin-token"}, Query: querier}
+	w := httptest.NewRecorder()
+	NewMux(env).ServeHTTP(w, newRequest("/_gotrack/api/export?visitor_id=v1&format=csv"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[1], "a,,,,v1,") {
+		t.Errorf("body = %q, want a header and two rows", w.Body.String())
+	}
+	if len(querier.queries) != 2 || querier.queries[0].VisitorID != "v1" {
+		t.Errorf("queries = %+v", querier.queries)
+	}
+	if audit := logs.String(); !strings.Contains(audit, "events.export") || !strings.Contains(audit, `"results":2`) {
+		t.Errorf("expected audit entry, got %q", audit)
+	}
+
+	w = httptest.NewRecorder()
+	env.ExportEvents(w, newRequest("/_gotrack/api/export?ip=5f0c"))
+	if w.Header().Get("Content-Type") != "application/x-ndjson" || strings.Count(w.Body.String(), "\n") != 2 {
+		t.Errorf("ndjson export = %q", w.Body.String())
+	}
+
+	for _, target := range []string{
+		"/_gotrack/api/export",
+		"/_gotrack/api/export?visitor_id=v1&format=xlsx",
+		"/_gotrack/api/export?visitor_id=v1&since=yesterday",
+	} {
+		w := httptest.NewRecorder()
+		env.ExportEvents(w, newRequest(target))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", target, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	Env{Query: &fakeQuerier{err: errors.New("db down")}}.ExportEvents(w, newRequest("/_gotrack/api/export?visitor_id=v1"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+}
+
+func TestAdminCampaignURL(t *testing.T) {
+	env := Env{Cfg: cfg.Config{AdminToken: "admin-token"}}
+	handler := env.requireAdmin(env.AdminCampaignURL)
+
+	w := httptest.NewRecorder()
+	handler(w, newAdminRequest("/_gotrack/admin/campaign-url?url="+url.QueryEscape("https://shop.example/sale?utm_source=google&utm_medium=cpc")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", w.Code, w.Body.String())
+	}
+	var report struct {
+		Hostname string            `json:"hostname"`
+		UTM      map[string]string `json:"utm"`
+		Channel  string            `json:"channel"`
+		Warnings []struct {
+			Param string `json:"param"`
+		} `json:"warnings"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Hostname != "shop.example" || report.UTM["source"] != "google" || report.Channel != event.ChannelPaidSearch {
+		t.Errorf("report = %+v", report)
+	}
+	if len(report.Warnings) != 1 || report.Warnings[0].Param != "utm_campaign" {
+		t.Errorf("warnings = %+v, want utm_campaign missing", report.Warnings)
+	}
+
+	for _, target := range []string{"/_gotrack/admin/campaign-url", "/_gotrack/admin/campaign-url?url=/relative"} {
+		w := httptest.NewRecorder()
+		handler(w, newAdminRequest(target))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", target, w.Code)
+		}
+	}
+}
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"":                     {},
+		"30m":                  now.Add(-30 * time.Minute),
+		"7d":                   now.AddDate(0, 0, -7),
+		"2026-10-01T00:00:00Z": time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for in, want := range tests {
+		got, err := parseTimeBound(in, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseTimeBound(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"soon", "-1h", "xd"} {
+		if _, err := parseTimeBound(bad, now); err == nil {
+			t.Errorf("parseTimeBound(%q) should fail", bad)
+		}
+	}
+}
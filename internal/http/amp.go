@@ -0,0 +1,30 @@
+package httpx
+
+import (
+	"html/template"
+	"regexp"
+)
+
+// ampHTMLTagRegex matches an opening <html> tag carrying the AMP marker attribute
+// (⚡, ⚡4email, amp or amp4email) as defined by the AMP HTML specification
+var ampHTMLTagRegex = regexp.MustCompile(`(?i)<html(?:\s[^>]*?)?\s(?:⚡|amp)(?:4email)?(?:\s|=|>|/)`)
+
+// isAMPDocument reports whether the HTML body is an AMP document.
+// AMP pages strip custom <script> tags, so they need an <amp-pixel> instead.
+func isAMPDocument(body []byte) bool {
+	// Only inspect the start of the document; the <html> tag is always near the top
+	head := body
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	return ampHTMLTagRegex.Match(head)
+}
+
+// buildAMPPixel returns an <amp-pixel> element pointing at the tracking pixel.
+// amp-pixel is built into the AMP runtime, so no extension script is required.
+// AMP substitutes DOCUMENT_REFERRER and RANDOM client-side before the request is sent.
+func buildAMPPixel(pixelURL string) string {
+	src := pixelURL + "&amp=1&ref=DOCUMENT_REFERRER&cb=RANDOM"
+	// nosemgrep: go.lang.security.injection.raw-html-format.raw-html-format
+	return `<amp-pixel src="` + template.HTMLEscapeString(src) + `" layout="nodisplay"></amp-pixel>`
+}
@@ -0,0 +1,54 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestIsAMPDocument tests AMP document detection
+func TestIsAMPDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want bool
+	}{
+		{name: "lightning attribute", html: `<!doctype html><html ⚡ lang="en"><body></body></html>`, want: true},
+		{name: "lightning as last attribute", html: `<html lang="en" ⚡><body></body></html>`, want: true},
+		{name: "amp attribute", html: `<html amp lang="en"><body></body></html>`, want: true},
+		{name: "uppercase AMP attribute", html: `<HTML AMP><body></body></HTML>`, want: true},
+		{name: "amp4email", html: `<html ⚡4email><body></body></html>`, want: true},
+		{name: "regular html", html: `<html lang="en"><body></body></html>`, want: false},
+		{name: "data-amp attribute is not amp", html: `<html data-amp="1"><body></body></html>`, want: false},
+		{name: "amp in body only", html: `<html><body><p amp>text</p></body></html>`, want: false},
+		{name: "empty", html: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAMPDocument([]byte(tt.html)); got != tt.want {
+				t.Errorf("isAMPDocument(%q) = %v, want %v", tt.html, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestInjectPixelAMP tests that AMP documents receive an amp-pixel instead of scripts
+func TestInjectPixelAMP(t *testing.T) {
+	html := []byte(`<!doctype html><html ⚡ lang="en"><head></head><body><h1>AMP</h1></body></html>`)
+	req := httptest.NewRequest(http.MethodGet, "/article?utm_source=news", nil)
+	auth := NewHMACAuth("test-secret", "")
+
+	result := string(injectPixel(html, req, auth, ""))
+
+	if strings.Contains(result, "<script") {
+		t.Error("AMP documents should not receive script tags")
+	}
+	if !strings.Contains(result, `<amp-pixel src="/px.gif?e=pageview&amp;auto=1&amp;url=%2Farticle%3Futm_source%3Dnews&amp;amp=1`) {
+		t.Errorf("should inject amp-pixel pointing at /px.gif, got: %s", result)
+	}
+	if !strings.Contains(result, `layout="nodisplay"></amp-pixel>`+"\n</body>") {
+		t.Errorf("amp-pixel should be injected before </body>, got: %s", result)
+	}
+}
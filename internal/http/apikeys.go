@@ -0,0 +1,127 @@
+package httpx
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// APIKey is a resolved bearer credential with its scopes
+type APIKey struct {
+	Name   string
+	digest [32]byte
+	siteID string
+	types  map[string]bool // lowercase event types; empty allows any
+}
+
+// APIKeys authenticates server-to-server /collect requests sent with
+// Authorization: Bearer. A nil *APIKeys disables them, and bearer tokens are
+// ignored as before.
+type APIKeys struct {
+	mu   sync.RWMutex
+	keys []*APIKey
+}
+
+// NewAPIKeys builds the registry from key definitions
+func NewAPIKeys(defs []config.APIKey) *APIKeys {
+	k := &APIKeys{}
+	k.Update(defs)
+	return k
+}
+
+// Update replaces the key definitions, e.g. on reload
+func (k *APIKeys) Update(defs []config.APIKey) {
+	keys := make([]*APIKey, 0, len(defs))
+	for _, def := range defs {
+		key := &APIKey{Name: def.Name, digest: def.Digest(), siteID: def.SiteID}
+		for _, typ := range def.EventTypes {
+			if key.types == nil {
+				key.types = make(map[string]bool)
+			}
+			key.types[strings.ToLower(strings.TrimSpace(typ))] = true
+		}
+		keys = append(keys, key)
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+}
+
+// Authenticate returns the key matching token. Digests are compared in
+// constant time and every key is checked, so timing reveals neither which
+// key matched nor how much of it.
+func (k *APIKeys) Authenticate(token string) (*APIKey, bool) {
+	digest := sha256.Sum256([]byte(token))
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	var match *APIKey
+	for _, key := range k.keys {
+		if subtle.ConstantTimeCompare(digest[:], key.digest[:]) == 1 {
+			match = key
+		}
+	}
+	return match, match != nil
+}
+
+// allowsType reports whether the key may send events of a type
+func (k *APIKey) allowsType(typ string) bool {
+	return len(k.types) == 0 || k.types[strings.ToLower(typ)]
+}
+
+type apiKeyKey struct{}
+
+// apiKeyFrom returns the key resolveCredentials stored in ctx, or nil
+func apiKeyFrom(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyKey{}).(*APIKey)
+	return key
+}
+
+// resolveCredentials authenticates a /collect request by API key when it
+// carries a bearer token and API keys are enabled, and by write key
+// otherwise. Requests with a valid API key skip the origin and HMAC checks,
+// which are meant for browsers, and belong to the key's site.
+func (e Env) resolveCredentials(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	token, ok := bearerToken(r)
+	if e.APIKeys == nil || !ok {
+		return e.resolveTenant(w, r)
+	}
+	key, ok := e.APIKeys.Authenticate(token)
+	if !ok {
+		http.Error(w, "invalid API key", http.StatusUnauthorized)
+		return nil, false
+	}
+	ctx := context.WithValue(r.Context(), apiKeyKey{}, key)
+	if e.Tenants != nil {
+		tenant, ok := e.Tenants.Site(key.siteID)
+		if !ok {
+			http.Error(w, "API key site is not configured", http.StatusForbidden)
+			return nil, false
+		}
+		ctx = context.WithValue(ctx, tenantKey{}, tenant)
+	}
+	return r.WithContext(ctx), true
+}
+
+// checkScope answers 403 when the request's API key may not send one of the
+// events. Requests without an API key are not limited.
+func checkScope(w http.ResponseWriter, r *http.Request, events []event.Event) bool {
+	key := apiKeyFrom(r.Context())
+	if key == nil {
+		return true
+	}
+	for _, ev := range events {
+		if !key.allowsType(ev.Type) {
+			http.Error(w, fmt.Sprintf("API key %s may not send %q events", key.Name, ev.Type), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
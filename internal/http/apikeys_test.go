@@ -0,0 +1,107 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestAPIKeysAuthenticate(t *testing.T) {
+	keys := NewAPIKeys([]config.APIKey{
+		{Name: "billing", Key: "sk_billing"},
+		{Name: "crm", KeySHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}, // sha256("test")
+	})
+	for token, want := range map[string]string{"sk_billing": "billing", "test": "crm"} {
+		if key, ok := keys.Authenticate(token); !ok || key.Name != want {
+			t.Errorf("Authenticate(%q) = %v, %v, want %s", token, key, ok, want)
+		}
+	}
+	for _, token := range []string{"", "sk_billin", "sk_billing2"} {
+		if _, ok := keys.Authenticate(token); ok {
+			t.Errorf("Authenticate(%q) accepted an unknown key", token)
+		}
+	}
+
+	keys.Update([]config.APIKey{{Name: "billing", Key: "sk_billing_2"}})
+	if _, ok := keys.Authenticate("sk_billing"); ok {
+		t.Error("removed key still accepted after Update")
+	}
+	if _, ok := keys.Authenticate("sk_billing_2"); !ok {
+		t.Error("new key not accepted after Update")
+	}
+}
+
+func TestCollectAPIKeys(t *testing.T) {
+	collect := func(env Env, body string, header http.Header) (*httptest.ResponseRecorder, []event.Event) {
+		var emitted []event.Event
+		env.Cfg.MaxBodyBytes = 1 << 20
+		env.Emit = func(_ context.Context, e event.Event) { emitted = append(emitted, e) }
+		req := httptest.NewRequest(http.MethodPost, "/collect", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		env.Collect(w, req)
+		return w, emitted
+	}
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": {"Bearer " + token}}
+	}
+	defs := []config.APIKey{
+		{Name: "billing", Key: "sk_billing", SiteID: "shop", EventTypes: []string{"purchase"}},
+		{Name: "any", Key: "sk_any", SiteID: "blog"},
+	}
+
+	t.Run("key replaces write key and HMAC", func(t *testing.T) {
+		env := Env{APIKeys: NewAPIKeys(defs), Tenants: newTestTenants(t), HMACAuth: NewHMACAuth("secret", "")}
+		w, emitted := collect(env, `{"type":"Purchase"}`, bearer("sk_billing"))
+		if w.Code != http.StatusAccepted || len(emitted) != 1 || emitted[0].SiteID != "shop" {
+			t.Errorf("status = %d, emitted = %+v", w.Code, emitted)
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		env := Env{APIKeys: NewAPIKeys(defs)}
+		if w, emitted := collect(env, `{"type":"purchase"}`, bearer("sk_nope")); w.Code != http.StatusUnauthorized || len(emitted) != 0 {
+			t.Errorf("status = %d, emitted = %+v", w.Code, emitted)
+		}
+	})
+
+	t.Run("event type outside scope", func(t *testing.T) {
+		env := Env{APIKeys: NewAPIKeys(defs), Tenants: newTestTenants(t)}
+		w, emitted := collect(env, `[{"type":"purchase"},{"type":"pageview"}]`, bearer("sk_billing"))
+		if w.Code != http.StatusForbidden || len(emitted) != 0 {
+			t.Errorf("status = %d, emitted = %+v", w.Code, emitted)
+		}
+		if !strings.Contains(w.Body.String(), `"pageview"`) {
+			t.Errorf("body = %q, want the rejected type", w.Body.String())
+		}
+	})
+
+	t.Run("site removed from tenants", func(t *testing.T) {
+		env := Env{APIKeys: NewAPIKeys([]config.APIKey{{Name: "old", Key: "sk_old", SiteID: "gone"}}), Tenants: newTestTenants(t)}
+		if w, _ := collect(env, `{"type":"click"}`, bearer("sk_old")); w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want 403", w.Code)
+		}
+	})
+
+	t.Run("without API keys bearer tokens are ignored", func(t *testing.T) {
+		w, emitted := collect(Env{}, `{"type":"click"}`, bearer("sk_billing"))
+		if w.Code != http.StatusAccepted || len(emitted) != 1 {
+			t.Errorf("status = %d, emitted = %+v", w.Code, emitted)
+		}
+	})
+
+	t.Run("without bearer token write keys still apply", func(t *testing.T) {
+		env := Env{APIKeys: NewAPIKeys(defs), Tenants: newTestTenants(t)}
+		if w, _ := collect(env, `{"type":"click"}`, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401 for a missing write key", w.Code)
+		}
+	})
+}
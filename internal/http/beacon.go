@@ -0,0 +1,69 @@
+package httpx
+
+import (
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// navigator.sendBeacon cannot set headers and, to avoid a CORS preflight,
+// sends text/plain or form bodies. A text/plain body is the JSON itself; a
+// form body carries base64 JSON in its data field and optionally the
+// signature in hmac, with its timestamp and nonce in ts and nonce.
+const (
+	beaconDataField  = "data"
+	beaconHMACField  = "hmac"
+	beaconTSField    = "ts"
+	beaconNonceField = "nonce"
+)
+
+var errInvalidBeacon = errors.New("form body needs base64 JSON in the data field")
+
+// collectMediaType reports whether /collect accepts a Content-Type. An empty
+// value is treated as JSON.
+func collectMediaType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mt {
+	case "application/json", "text/plain", "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+// unwrapBeacon returns the JSON inside a form-encoded beacon body; other
+// bodies are returned unchanged.
+func unwrapBeacon(r *http.Request, body []byte) ([]byte, error) {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt != "application/x-www-form-urlencoded" {
+		return body, nil
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, errInvalidBeacon
+	}
+	data, err := decodeBase64(form.Get(beaconDataField))
+	if err != nil || len(data) == 0 {
+		return nil, errInvalidBeacon
+	}
+	useSignatureFields(r, form.Get(beaconHMACField), form.Get(beaconTSField), form.Get(beaconNonceField))
+	return data, nil
+}
+
+// decodeBase64 accepts standard and URL-safe base64, padded or not. A '+'
+// that was not percent-encoded arrives as a space and is restored.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.ReplaceAll(s, " ", "+"), "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -0,0 +1,100 @@
+package httpx
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestCollectMediaType(t *testing.T) {
+	for ct, want := range map[string]bool{
+		"":                                  true,
+		"application/json":                  true,
+		"application/json; charset=utf-8":   true,
+		"text/plain;charset=UTF-8":          true,
+		"application/x-www-form-urlencoded": true,
+		"multipart/form-data; boundary=x":   false,
+		"text/html":                         false,
+		"not a media type;;":                false,
+	} {
+		if got := collectMediaType(ct); got != want {
+			t.Errorf("collectMediaType(%q) = %v, want %v", ct, got, want)
+		}
+	}
+}
+
+func TestDecodeBase64(t *testing.T) {
+	data := []byte(`{"type":"click","props":{"q":"a>b?"}}`)
+	for _, s := range []string{
+		base64.StdEncoding.EncodeToString(data),
+		base64.RawStdEncoding.EncodeToString(data),
+		base64.URLEncoding.EncodeToString(data),
+		base64.RawURLEncoding.EncodeToString(data),
+		strings.ReplaceAll(base64.StdEncoding.EncodeToString(data), "+", " "),
+	} {
+		if got, err := decodeBase64(s); err != nil || string(got) != string(data) {
+			t.Errorf("decodeBase64(%q) = %q, %v", s, got, err)
+		}
+	}
+}
+
+func TestCollectBeacon(t *testing.T) {
+	payload := `{"type":"page_hidden"}`
+	collect := func(env Env, contentType, body string) (*httptest.ResponseRecorder, int) {
+		emitted := 0
+		env.Cfg.MaxBodyBytes = 1 << 20
+		env.Emit = func(context.Context, event.Event) { emitted++ }
+		req := httptest.NewRequest(http.MethodPost, "/collect", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		env.Collect(w, req)
+		return w, emitted
+	}
+	b64 := base64.StdEncoding.EncodeToString([]byte(payload))
+
+	t.Run("text/plain JSON", func(t *testing.T) {
+		if w, emitted := collect(Env{}, "text/plain;charset=UTF-8", payload); w.Code != http.StatusAccepted || emitted != 1 {
+			t.Errorf("status = %d, emitted = %d", w.Code, emitted)
+		}
+	})
+
+	t.Run("form data", func(t *testing.T) {
+		body := url.Values{"data": {b64}}.Encode()
+		if w, emitted := collect(Env{}, "application/x-www-form-urlencoded", body); w.Code != http.StatusAccepted || emitted != 1 {
+			t.Errorf("status = %d, emitted = %d, body = %s", w.Code, emitted, w.Body.String())
+		}
+	})
+
+	t.Run("form hmac field is verified over the JSON", func(t *testing.T) {
+		env := Env{HMACAuth: NewHMACAuth("secret", "")}
+		sig := env.HMACAuth.generateHMAC([]byte(payload), "192.0.2.1")
+		body := url.Values{"data": {b64}, "hmac": {sig}}.Encode()
+		if w, emitted := collect(env, "application/x-www-form-urlencoded", body); w.Code != http.StatusAccepted || emitted != 1 {
+			t.Errorf("status = %d, emitted = %d", w.Code, emitted)
+		}
+		body = url.Values{"data": {b64}, "hmac": {"forged"}}.Encode()
+		if w, _ := collect(env, "application/x-www-form-urlencoded", body); w.Code != http.StatusUnauthorized {
+			t.Errorf("forged hmac: status = %d, want 401", w.Code)
+		}
+	})
+
+	t.Run("bad form bodies", func(t *testing.T) {
+		for _, body := range []string{"", "payload=" + b64, "data=%%%", "data=not*base64"} {
+			if w, _ := collect(Env{}, "application/x-www-form-urlencoded", body); w.Code != http.StatusBadRequest {
+				t.Errorf("body %q: status = %d, want 400", body, w.Code)
+			}
+		}
+	})
+
+	t.Run("other types are refused", func(t *testing.T) {
+		if w, _ := collect(Env{}, "multipart/form-data; boundary=x", payload); w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("status = %d, want 415", w.Code)
+		}
+	})
+}
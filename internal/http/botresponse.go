@@ -0,0 +1,186 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/logging"
+	event "github.com/shortontech/gotrack/pkg/event"
+)
+
+// Responses to events whose bot score reaches BOT_SCORE_THRESHOLD. None of
+// them tell the client anything was noticed.
+const (
+	BotResponseTag    = "tag"    // emit the event with server.detection.suspected_bot set
+	BotResponseDrop   = "drop"   // discard the event and answer 204 instead of the usual success
+	BotResponseTarpit = "tarpit" // discard the event and hold the usual answer for the tarpit delay
+)
+
+// BotResponseDefault is the BOT_RESPONSE key for endpoints without a
+// response of their own
+const BotResponseDefault = "*"
+
+// maxTarpits bounds the requests held at once, so a flood of bots can't tie
+// up the server; past it bots are answered at once
+const maxTarpits = 1000
+
+// BotPolicy decides what happens to events from likely bots on each
+// ingestion endpoint
+type BotPolicy struct {
+	threshold int
+	responses map[string]string // endpoint to response
+	def       string            // response of unlisted endpoints; empty leaves them alone
+	delay     time.Duration
+	tarpits   chan struct{}
+}
+
+// NewBotPolicy reads endpoint=response pairs such as /collect=drop, where
+// the endpoint * sets the response of unlisted ones. Events scoring at least
+// threshold get the response; tarpitted requests are held for delay.
+func NewBotPolicy(threshold int, pairs []string, delay time.Duration) (*BotPolicy, error) {
+	if threshold < 1 || threshold > 100 {
+		return nil, fmt.Errorf("bot score threshold must be between 1 and 100, got %d", threshold)
+	}
+	p := &BotPolicy{
+		threshold: threshold,
+		responses: make(map[string]string, len(pairs)),
+		delay:     delay,
+		tarpits:   make(chan struct{}, maxTarpits),
+	}
+	for _, pair := range pairs {
+		endpoint, response, ok := strings.Cut(pair, "=")
+		endpoint, response = strings.TrimSpace(endpoint), strings.TrimSpace(response)
+		if !ok || endpoint != BotResponseDefault && !strings.HasPrefix(endpoint, "/") {
+			return nil, fmt.Errorf("invalid bot response %q (want /endpoint=response)", pair)
+		}
+		switch response {
+		case BotResponseTag, BotResponseDrop, BotResponseTarpit:
+		default:
+			return nil, fmt.Errorf("unknown bot response %q for %s (want tag, drop or tarpit)", response, endpoint)
+		}
+		if response == BotResponseTarpit && delay <= 0 {
+			return nil, fmt.Errorf("tarpit for %s needs a positive tarpit delay", endpoint)
+		}
+		if endpoint == BotResponseDefault {
+			p.def = response
+			continue
+		}
+		p.responses[endpoint] = response
+	}
+	return p, nil
+}
+
+// Response returns the response configured for an endpoint, or "" when its
+// bots are left alone
+func (p *BotPolicy) Response(endpoint string) string {
+	if response, ok := p.responses[endpoint]; ok {
+		return response
+	}
+	return p.def
+}
+
+// botDecision carries the endpoint into the handler and what was decided
+// for its events back out
+type botDecision struct {
+	endpoint string
+	drop     bool
+	held     bool
+}
+
+type botDecisionKey struct{}
+
+// guard lets the policy act on an ingestion handler's events: it tells the
+// handler its endpoint and, once events were dropped, turns the handler's
+// success answer into an empty 204
+func (p *BotPolicy) guard(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	if p == nil || p.Response(endpoint) == "" {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := &botDecision{endpoint: endpoint}
+		h(&botWriter{ResponseWriter: w, decision: d}, r.WithContext(context.WithValue(r.Context(), botDecisionKey{}, d)))
+	}
+}
+
+// hold keeps the request waiting for the tarpit delay, unless the client
+// gives up first or too many are held already
+func (p *BotPolicy) hold(ctx context.Context) {
+	select {
+	case p.tarpits <- struct{}{}:
+		defer func() { <-p.tarpits }()
+	default:
+		return
+	}
+	timer := time.NewTimer(p.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// screenBot applies BOT_RESPONSE to an enriched event and reports whether
+// it should still be emitted
+func (e Env) screenBot(r *http.Request, ev *event.Event) bool {
+	d, _ := r.Context().Value(botDecisionKey{}).(*botDecision)
+	if e.Bots == nil || d == nil || ev.Server.Detection.BotScore() < e.Bots.threshold {
+		return true
+	}
+	response := e.Bots.Response(d.endpoint)
+	if response == "" {
+		return true
+	}
+	if e.Metrics != nil {
+		e.Metrics.IncrementBotResponses(d.endpoint, response)
+	}
+	logging.Debugf("Likely bot on %s (score %d): %s", d.endpoint, ev.Server.Detection.BotScore(), response)
+	switch response {
+	case BotResponseTag:
+		ev.Server.Detection.SuspectedBot = true
+		return true
+	case BotResponseDrop:
+		d.drop = true
+	case BotResponseTarpit:
+		if !d.held { // once per request, however many events it carries
+			d.held = true
+			e.Bots.hold(r.Context())
+		}
+	}
+	return false
+}
+
+// botWriter answers 204 with no body in place of a success once the
+// request's events were dropped. Redirects and errors pass unchanged.
+type botWriter struct {
+	http.ResponseWriter
+	decision    *botDecision
+	wroteHeader bool
+	discard     bool
+}
+
+func (w *botWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.decision.drop && code >= 200 && code < 300 {
+		h := w.Header()
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		code, w.discard = http.StatusNoContent, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *botWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
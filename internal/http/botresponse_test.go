@@ -0,0 +1,101 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestBotResponses(t *testing.T) {
+	bot := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/px.gif?e=pageview", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 HeadlessChrome/120.0") // scores 80 with the missing headers
+		return req
+	}
+	person := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/px.gif?e=pageview", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0")
+		req.Header.Set("Accept", "image/avif,image/webp,*/*")
+		req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+		req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+		return req
+	}
+	serve := func(t *testing.T, responses []string, req *http.Request) (*httptest.ResponseRecorder, []event.Event, time.Duration) {
+		t.Helper()
+		policy, err := NewBotPolicy(80, responses, 50*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var emitted []event.Event
+		handler := NewMux(Env{
+			Cfg:  cfg.Config{},
+			Bots: policy,
+			Emit: func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) },
+		})
+		w := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(w, req)
+		return w, emitted, time.Since(start)
+	}
+
+	t.Run("tag", func(t *testing.T) {
+		w, emitted, _ := serve(t, []string{"*=tag"}, bot())
+		if w.Code != http.StatusOK || len(emitted) != 1 || !emitted[0].Server.Detection.SuspectedBot {
+			t.Errorf("status = %d, emitted = %+v", w.Code, emitted)
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		w, emitted, _ := serve(t, []string{"/px.gif=drop"}, bot())
+		if w.Code != http.StatusNoContent || w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" || len(emitted) != 0 {
+			t.Errorf("status = %d, body = %d bytes, emitted = %d", w.Code, w.Body.Len(), len(emitted))
+		}
+	})
+
+	t.Run("tarpit", func(t *testing.T) {
+		w, emitted, elapsed := serve(t, []string{"/px.gif=tarpit"}, bot())
+		if w.Code != http.StatusOK || w.Body.Len() == 0 || len(emitted) != 0 {
+			t.Errorf("status = %d, emitted = %d", w.Code, len(emitted))
+		}
+		if elapsed < 50*time.Millisecond {
+			t.Errorf("answered after %v, want the tarpit delay", elapsed)
+		}
+	})
+
+	t.Run("other endpoints and people are left alone", func(t *testing.T) {
+		if w, emitted, _ := serve(t, []string{"/collect=drop"}, bot()); w.Code != http.StatusOK || len(emitted) != 1 {
+			t.Errorf("unlisted endpoint: status = %d, emitted = %d", w.Code, len(emitted))
+		}
+		w, emitted, _ := serve(t, []string{"*=drop"}, person())
+		if w.Code != http.StatusOK || len(emitted) != 1 || emitted[0].Server.Detection.SuspectedBot {
+			t.Errorf("person: status = %d, emitted = %+v", w.Code, emitted)
+		}
+	})
+}
+
+func TestNewBotPolicy(t *testing.T) {
+	policy, err := NewBotPolicy(80, []string{"/collect=drop", " * = tag "}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Response("/collect") != BotResponseDrop || policy.Response("/px.gif") != BotResponseTag {
+		t.Errorf("responses = %q, %q", policy.Response("/collect"), policy.Response("/px.gif"))
+	}
+
+	for _, pairs := range [][]string{{"collect=drop"}, {"/collect"}, {"/collect=block"}} {
+		if _, err := NewBotPolicy(80, pairs, time.Second); err == nil {
+			t.Errorf("NewBotPolicy(%q) succeeded, want an error", pairs)
+		}
+	}
+	if _, err := NewBotPolicy(80, []string{"*=tarpit"}, 0); err == nil {
+		t.Error("tarpit without a delay accepted")
+	}
+	if _, err := NewBotPolicy(0, []string{"*=tag"}, time.Second); err == nil {
+		t.Error("threshold 0 accepted")
+	}
+}
@@ -0,0 +1,35 @@
+package httpx
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// requestClientHints asks the browser for the high-entropy Client Hints in
+// event.HighEntropyClientHints on later requests. Accept-CH names the hints;
+// Permissions-Policy delegates them to this origin, and is left alone for
+// hints the page's own policy already decides on. Hints already in the
+// header are not repeated.
+func requestClientHints(h http.Header) {
+	accepted := strings.ToLower(strings.Join(h.Values("Accept-CH"), ","))
+	policy := strings.ToLower(strings.Join(h.Values("Permissions-Policy"), ","))
+
+	var hints, directives []string
+	for _, hint := range event.HighEntropyClientHints {
+		if !strings.Contains(accepted, strings.ToLower(hint)) {
+			hints = append(hints, hint)
+		}
+		feature := strings.TrimPrefix(strings.ToLower(hint), "sec-")
+		if !strings.Contains(policy, feature+"=") {
+			directives = append(directives, feature+"=(self)")
+		}
+	}
+	if len(hints) > 0 {
+		h.Add("Accept-CH", strings.Join(hints, ", "))
+	}
+	if len(directives) > 0 {
+		h.Add("Permissions-Policy", strings.Join(directives, ", "))
+	}
+}
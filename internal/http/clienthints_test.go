@@ -0,0 +1,78 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestRequestClientHints(t *testing.T) {
+	t.Run("empty header", func(t *testing.T) {
+		h := http.Header{}
+		requestClientHints(h)
+		if got, want := h.Get("Accept-CH"), "Sec-CH-UA-Platform-Version, Sec-CH-UA-Model, Sec-CH-UA-Full-Version-List"; got != want {
+			t.Errorf("Accept-CH = %q, want %q", got, want)
+		}
+		if got, want := h.Get("Permissions-Policy"), "ch-ua-platform-version=(self), ch-ua-model=(self), ch-ua-full-version-list=(self)"; got != want {
+			t.Errorf("Permissions-Policy = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("merges with the page's headers", func(t *testing.T) {
+		h := http.Header{}
+		h.Set("Accept-CH", "sec-ch-ua-model, Viewport-Width")
+		h.Set("Permissions-Policy", "geolocation=(), ch-ua-model=()")
+		requestClientHints(h)
+
+		if got, want := h.Values("Accept-CH"), []string{"sec-ch-ua-model, Viewport-Width", "Sec-CH-UA-Platform-Version, Sec-CH-UA-Full-Version-List"}; strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("Accept-CH = %q, want %q", got, want)
+		}
+		policy := strings.Join(h.Values("Permissions-Policy"), ", ")
+		if strings.Contains(policy, "ch-ua-model=(self)") {
+			t.Errorf("page's ch-ua-model policy overridden: %q", policy)
+		}
+		if !strings.Contains(policy, "ch-ua-platform-version=(self)") {
+			t.Errorf("ch-ua-platform-version not delegated: %q", policy)
+		}
+
+		requestClientHints(h)
+		if n := len(h.Values("Accept-CH")); n != 2 {
+			t.Errorf("hints repeated on a second call: %q", h.Values("Accept-CH"))
+		}
+	})
+}
+
+func TestClientHintsResponses(t *testing.T) {
+	t.Run("pixel", func(t *testing.T) {
+		for _, enabled := range []bool{true, false} {
+			env := Env{Cfg: cfg.Config{ClientHints: enabled}, Emit: func(context.Context, event.Event) {}}
+			w := httptest.NewRecorder()
+			env.Pixel(w, httptest.NewRequest(http.MethodGet, "/px.gif", nil))
+			if got := w.Header().Get("Accept-CH") != ""; got != enabled {
+				t.Errorf("CLIENT_HINTS=%v: Accept-CH sent = %v", enabled, got)
+			}
+		}
+	})
+
+	t.Run("proxied html", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, "<html><body>shop</body></html>")
+		}))
+		defer backend.Close()
+
+		handler := NewProxyHandler(backend.URL, nil)
+		handler.clientHints = true
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Header().Get("Accept-CH") == "" || w.Header().Get("Permissions-Policy") == "" {
+			t.Errorf("missing Client Hints headers: %v", w.Header())
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package httpx
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/internal/session"
+)
+
+// clusterInfoPath describes the instance to load balancers and operators
+const clusterInfoPath = "/cluster/info"
+
+// instanceHeader names the instance that answered, so stickiness can be
+// checked from the client side or in the load balancer's logs
+const instanceHeader = "X-GoTrack-Instance"
+
+// Cluster describes this replica when several run behind a load balancer
+// (CLUSTER_MODE)
+type Cluster struct {
+	Version      string    // build version
+	Started      time.Time // when the instance started serving
+	StateBackend string    // KV_BACKEND in use: memory, redis or postgres
+}
+
+// clusterInfo is the answer to /cluster/info
+type clusterInfo struct {
+	Instance     string         `json:"instance"`
+	Version      string         `json:"version"`
+	StartedAt    time.Time      `json:"started_at"`
+	Draining     bool           `json:"draining"`
+	StateBackend string         `json:"state_backend"`
+	SharedState  bool           `json:"shared_state"` // replicas share sessions, dedup and detection state
+	Routing      clusterRouting `json:"routing"`
+}
+
+// clusterRouting is the hint for load balancers hashing clients onto replicas
+type clusterRouting struct {
+	Key      string `json:"key"`      // cookie:<name> when visitor cookies are issued, otherwise client_ip
+	Required bool   `json:"required"` // state is per replica, so a client must keep to one for sessions and timing to add up
+}
+
+// routingKey is what load balancers should hash on to keep a visitor on one
+// replica: the visitor cookie when GoTrack issues one, the client IP otherwise
+func (e Env) routingKey() string {
+	if e.Cfg.SessionCookies {
+		return "cookie:" + session.VisitorCookie
+	}
+	return "client_ip"
+}
+
+// ClusterInfo reports the instance's identity, whether its state is shared
+// with the other replicas and what to route clients by
+func (e Env) ClusterInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.Cluster == nil {
+		http.Error(w, "cluster mode not enabled", http.StatusNotFound)
+		return
+	}
+	shared := e.Cluster.StateBackend != "" && e.Cluster.StateBackend != kv.BackendMemory
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, clusterInfo{
+		Instance:     e.instance(),
+		Version:      e.Cluster.Version,
+		StartedAt:    e.Cluster.Started.UTC(),
+		Draining:     e.Drainer.Draining(),
+		StateBackend: e.Cluster.StateBackend,
+		SharedState:  shared,
+		Routing:      clusterRouting{Key: e.routingKey(), Required: !shared},
+	})
+}
+
+// instance is the name sent in the instance header, or "" outside cluster mode
+func (e Env) instance() string {
+	if !e.Cfg.ClusterMode {
+		return ""
+	}
+	return e.Cfg.InstanceID
+}
+
+// withInstance names the instance on every response
+func withInstance(instance string, next http.Handler) http.Handler {
+	if instance == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(instanceHeader, instance)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,66 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cfg "github.com/shortontech/gotrack/pkg/config"
+)
+
+func TestClusterInfo(t *testing.T) {
+	get := func(e Env) (*httptest.ResponseRecorder, clusterInfo) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		NewMux(e).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, clusterInfoPath, nil))
+		var info clusterInfo
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, info
+	}
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("memory store needs sticky routing by client IP", func(t *testing.T) {
+		rec, info := get(Env{
+			Cfg:     cfg.Config{ClusterMode: true, InstanceID: "gotrack-0"},
+			Cluster: &Cluster{Version: "v1.2.3", Started: started, StateBackend: "memory"},
+		})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		if info.Instance != "gotrack-0" || info.Version != "v1.2.3" || !info.StartedAt.Equal(started) {
+			t.Errorf("info = %+v", info)
+		}
+		if info.SharedState || !info.Routing.Required || info.Routing.Key != "client_ip" {
+			t.Errorf("state shared = %v, routing = %+v", info.SharedState, info.Routing)
+		}
+		if got := rec.Header().Get(instanceHeader); got != "gotrack-0" {
+			t.Errorf("%s = %q", instanceHeader, got)
+		}
+	})
+
+	t.Run("shared store routes by visitor cookie without requiring it", func(t *testing.T) {
+		_, info := get(Env{
+			Cfg:     cfg.Config{ClusterMode: true, InstanceID: "gotrack-1", SessionCookies: true},
+			Cluster: &Cluster{Started: started, StateBackend: "redis"},
+		})
+		if !info.SharedState || info.Routing.Required || info.Routing.Key != "cookie:_gt_vid" {
+			t.Errorf("state shared = %v, routing = %+v", info.SharedState, info.Routing)
+		}
+	})
+
+	t.Run("not served outside cluster mode", func(t *testing.T) {
+		rec, _ := get(Env{Cfg: cfg.Config{InstanceID: "gotrack-0"}})
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", rec.Code)
+		}
+		if got := rec.Header().Get(instanceHeader); got != "" {
+			t.Errorf("%s = %q outside cluster mode", instanceHeader, got)
+		}
+	})
+}
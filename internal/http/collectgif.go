@@ -0,0 +1,66 @@
+package httpx
+
+import (
+	"encoding/base64"
+	"net/http"
+)
+
+// Query parameters of GET /collect.gif
+const (
+	collectGIFDataParam  = "d" // base64url JSON event or array of events
+	collectGIFHMACParam  = "h" // optional signature of the decoded JSON
+	collectGIFTSParam    = "t" // timestamp signed with h
+	collectGIFNonceParam = "n" // nonce signed with h
+)
+
+// GET /collect.gif?d=... — /collect for environments that block cross-origin
+// POSTs. The events are validated, enriched and emitted as on /collect, and
+// the response is the tracking pixel whatever validation decided.
+func (e Env) CollectGIF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !e.allowRequest(w, r) {
+		return
+	}
+	r, ok := e.resolveTenant(w, r)
+	if !ok {
+		return
+	}
+
+	body, ok := e.readQueryPayload(w, r)
+	if !ok {
+		return
+	}
+	if _, _, ok := e.processEvents(w, r, body); !ok {
+		return
+	}
+	writePixel(w, r.Method == http.MethodHead)
+}
+
+// readQueryPayload decodes the d parameter, bounded by MAX_BODY_BYTES like a
+// /collect body, and verifies its signature when HMAC is enabled
+func (e Env) readQueryPayload(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	q := r.URL.Query()
+	data := q.Get(collectGIFDataParam)
+	if data == "" {
+		http.Error(w, "missing d parameter", http.StatusBadRequest)
+		return nil, false
+	}
+	if int64(base64.RawURLEncoding.DecodedLen(len(data))) > e.Cfg.MaxBodyBytes {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	body, err := decodeBase64(data)
+	if err != nil {
+		http.Error(w, "d must be base64url-encoded JSON", http.StatusBadRequest)
+		return nil, false
+	}
+
+	useSignatureFields(r, q.Get(collectGIFHMACParam), q.Get(collectGIFTSParam), q.Get(collectGIFNonceParam))
+	if !e.verifyHMAC(w, r, body) {
+		return nil, false
+	}
+	return body, true
+}
@@ -0,0 +1,101 @@
+package httpx
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/validation"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestCollectGIF(t *testing.T) {
+	payload := `[{"type":"click","url":{"referrer":"https://example.com/?a=b"}},{"type":"scroll"}]`
+	get := func(env Env, method string, params url.Values) (*httptest.ResponseRecorder, []event.Event) {
+		var emitted []event.Event
+		if env.Cfg.MaxBodyBytes == 0 {
+			env.Cfg.MaxBodyBytes = 1 << 20
+		}
+		env.Emit = func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) }
+		req := httptest.NewRequest(method, "/collect.gif?"+params.Encode(), nil)
+		w := httptest.NewRecorder()
+		env.CollectGIF(w, req)
+		return w, emitted
+	}
+	d := base64.RawURLEncoding.EncodeToString([]byte(payload))
+
+	t.Run("emits and returns the pixel", func(t *testing.T) {
+		w, emitted := get(Env{}, http.MethodGet, url.Values{"d": {d}})
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" || w.Body.Len() == 0 {
+			t.Fatalf("status = %d, content-type = %q", w.Code, w.Header().Get("Content-Type"))
+		}
+		if len(emitted) != 2 || emitted[0].EventID == "" || emitted[1].Type != "scroll" {
+			t.Errorf("emitted = %+v", emitted)
+		}
+	})
+
+	t.Run("HEAD has no body", func(t *testing.T) {
+		if w, _ := get(Env{}, http.MethodHead, url.Values{"d": {d}}); w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Errorf("status = %d, body length = %d", w.Code, w.Body.Len())
+		}
+	})
+
+	t.Run("HMAC", func(t *testing.T) {
+		env := Env{HMACAuth: NewHMACAuth("secret", "")}
+		sig := env.HMACAuth.generateHMAC([]byte(payload), "192.0.2.1")
+		if w, emitted := get(env, http.MethodGet, url.Values{"d": {d}, "h": {sig}}); w.Code != http.StatusOK || len(emitted) != 2 {
+			t.Errorf("signed: status = %d, emitted = %d", w.Code, len(emitted))
+		}
+		if w, emitted := get(env, http.MethodGet, url.Values{"d": {d}}); w.Code != http.StatusUnauthorized || len(emitted) != 0 {
+			t.Errorf("unsigned: status = %d, emitted = %d", w.Code, len(emitted))
+		}
+
+		nonce := "0123456789abcdef"
+		signed := env.HMACAuth.generateHMAC([]byte("1767225600\n"+nonce+"\n"+payload), "192.0.2.1")
+		params := url.Values{"d": {d}, "h": {signed}, "t": {"1767225600"}, "n": {nonce}}
+		if w, emitted := get(env, http.MethodGet, params); w.Code != http.StatusOK || len(emitted) != 2 {
+			t.Errorf("signed with timestamp: status = %d, emitted = %d", w.Code, len(emitted))
+		}
+	})
+
+	t.Run("rejected events still get the pixel", func(t *testing.T) {
+		env := Env{}
+		env.Validator, _ = validation.New(validation.PolicyReject, validation.Rules{Required: []string{"session.visitor_id"}})
+		d := base64.RawURLEncoding.EncodeToString([]byte(`{"type":"click"}`))
+		if w, emitted := get(env, http.MethodGet, url.Values{"d": {d}}); w.Code != http.StatusOK || len(emitted) != 0 {
+			t.Errorf("status = %d, emitted = %d", w.Code, len(emitted))
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		small := Env{}
+		small.Cfg.MaxBodyBytes = 16
+		for name, tt := range map[string]struct {
+			env    Env
+			method string
+			params url.Values
+			want   int
+		}{
+			"missing d":    {Env{}, http.MethodGet, nil, http.StatusBadRequest},
+			"not base64":   {Env{}, http.MethodGet, url.Values{"d": {"{*}"}}, http.StatusBadRequest},
+			"not json":     {Env{}, http.MethodGet, url.Values{"d": {base64.RawURLEncoding.EncodeToString([]byte("nope"))}}, http.StatusBadRequest},
+			"too large":    {small, http.MethodGet, url.Values{"d": {d}}, http.StatusRequestEntityTooLarge},
+			"POST refused": {Env{}, http.MethodPost, url.Values{"d": {d}}, http.StatusMethodNotAllowed},
+		} {
+			if w, emitted := get(tt.env, tt.method, tt.params); w.Code != tt.want || len(emitted) != 0 {
+				t.Errorf("%s: status = %d, emitted = %d, want %d", name, w.Code, len(emitted), tt.want)
+			}
+		}
+	})
+
+	t.Run("padded standard base64", func(t *testing.T) {
+		d := base64.StdEncoding.EncodeToString([]byte(strings.Repeat(" ", 2) + payload))
+		if w, emitted := get(Env{}, http.MethodGet, url.Values{"d": {d}}); w.Code != http.StatusOK || len(emitted) != 2 {
+			t.Errorf("status = %d, emitted = %d", w.Code, len(emitted))
+		}
+	})
+}
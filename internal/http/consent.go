@@ -0,0 +1,53 @@
+package httpx
+
+import (
+	"net/http"
+
+	"github.com/shortontech/gotrack/internal/consent"
+	event "github.com/shortontech/gotrack/pkg/event"
+)
+
+// evaluateConsent records in each event whether its TC string, from the
+// body, query or euconsent-v2 cookie, permits measurement
+func (e Env) evaluateConsent(r *http.Request, events ...*event.Event) {
+	if e.Consent == nil {
+		return
+	}
+	for _, ev := range events {
+		consent.FromRequest(r, ev)
+		e.Consent.Evaluate(ev)
+	}
+}
+
+// consentDenied reports whether any of the events lacks consent for
+// measurement, in which case no cookies are set or read for the request
+func (e Env) consentDenied(events ...*event.Event) bool {
+	if e.Consent == nil {
+		return false
+	}
+	for _, ev := range events {
+		if consent.Denied(ev) {
+			return true
+		}
+	}
+	return false
+}
+
+// honorConsent applies TCF_ACTION to an event without consent for
+// measurement and reports whether it should still be emitted
+func (e Env) honorConsent(ev *event.Event) bool {
+	if !e.consentDenied(ev) {
+		return true
+	}
+	if e.Metrics != nil {
+		e.Metrics.IncrementEventsSuppressed("tcf", string(e.Consent.Action))
+	}
+	switch e.Consent.Action {
+	case consent.ActionDrop:
+		return false
+	case consent.ActionAnonymize:
+		stripIdentifiers(ev)
+		ev.Session = event.SessionInfo{}
+	}
+	return true
+}
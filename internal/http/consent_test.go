@@ -0,0 +1,104 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/consent"
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/internal/session"
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// noConsentTC is a valid TC string with no purposes consented to
+const noConsentTC = "COw4XqLOw4XqLAAAAAENAXCAAAAAAAAAAAAAAAAAAAAA"
+
+// TestPixelHonorsConsent tests TCF_ACTION on the pixel endpoint
+func TestPixelHonorsConsent(t *testing.T) {
+	run := func(action consent.Action, query string) ([]event.Event, *httptest.ResponseRecorder) {
+		var emitted []event.Event
+		env := Env{
+			Emit:     func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) },
+			Consent:  &consent.Policy{Action: action, Purposes: []int{1, 8}},
+			Sessions: session.NewManager(session.Config{}, kv.NewMemoryStore()),
+		}
+		req := httptest.NewRequest(http.MethodGet, "/px.gif?gclid=abc&utm_source=news&"+query, nil)
+		req.RemoteAddr = "203.0.113.7:1234"
+		w := httptest.NewRecorder()
+		env.Pixel(w, req)
+		return emitted, w
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		emitted, w := run(consent.ActionDrop, "gdpr=1&gdpr_consent="+noConsentTC)
+		if w.Code != http.StatusOK || len(emitted) != 0 {
+			t.Fatalf("status %d, %d events; want 200 and none", w.Code, len(emitted))
+		}
+		if len(w.Result().Cookies()) != 0 {
+			t.Errorf("expected no cookies without consent, got %v", w.Result().Cookies())
+		}
+	})
+
+	t.Run("anonymize", func(t *testing.T) {
+		emitted, w := run(consent.ActionAnonymize, "gdpr_consent="+noConsentTC)
+		if len(emitted) != 1 {
+			t.Fatalf("expected 1 event, got %d", len(emitted))
+		}
+		ev := emitted[0]
+		if ev.Server.IP != "" || ev.URL.Google.GCLID != "" || ev.Session.VisitorID != "" {
+			t.Errorf("identifiers kept: ip %q, gclid %q, visitor %q", ev.Server.IP, ev.URL.Google.GCLID, ev.Session.VisitorID)
+		}
+		if ev.URL.UTM.Source != "news" || ev.Consent == nil || *ev.Consent.Measurement {
+			t.Errorf("utm %+v, consent %+v", ev.URL.UTM, ev.Consent)
+		}
+		if len(w.Result().Cookies()) != 0 {
+			t.Errorf("expected no cookies without consent, got %v", w.Result().Cookies())
+		}
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		emitted, _ := run(consent.ActionFlag, "gdpr_consent="+noConsentTC)
+		if len(emitted) != 1 || emitted[0].Server.IP == "" || *emitted[0].Consent.Measurement {
+			t.Fatalf("expected the event kept and flagged, got %+v", emitted)
+		}
+	})
+
+	t.Run("outside GDPR", func(t *testing.T) {
+		emitted, w := run(consent.ActionDrop, "gdpr=0")
+		if len(emitted) != 1 || !*emitted[0].Consent.Measurement {
+			t.Fatalf("expected the event kept, got %+v", emitted)
+		}
+		if len(w.Result().Cookies()) == 0 {
+			t.Error("expected session cookies")
+		}
+	})
+}
+
+// TestCollectHonorsConsent tests the TC string in the event body and the
+// euconsent-v2 cookie on /collect
+func TestCollectHonorsConsent(t *testing.T) {
+	var emitted []event.Event
+	env := Env{
+		Cfg:     cfg.Config{MaxBodyBytes: 1 << 20},
+		Emit:    func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) },
+		Consent: &consent.Policy{Action: consent.ActionDrop, Purposes: []int{1}},
+	}
+
+	body := `[{"type":"pageview","consent":{"tc_string":"` + noConsentTC + `"}},{"type":"pageview","consent":{"gdpr_applies":false}}]`
+	w := httptest.NewRecorder()
+	env.Collect(w, httptest.NewRequest(http.MethodPost, "/collect", strings.NewReader(body)))
+	if len(emitted) != 1 || emitted[0].Consent.GDPRApplies == nil {
+		t.Fatalf("expected only the event outside GDPR, got %+v", emitted)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/collect", strings.NewReader(`{"type":"pageview"}`))
+	req.AddCookie(&http.Cookie{Name: consent.CookieName, Value: noConsentTC})
+	env.Collect(httptest.NewRecorder(), req)
+	if len(emitted) != 1 {
+		t.Errorf("expected the event with a denying cookie dropped, got %d events", len(emitted))
+	}
+}
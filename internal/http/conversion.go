@@ -0,0 +1,90 @@
+package httpx
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/conversion"
+	"github.com/shortontech/gotrack/internal/logging"
+	event "github.com/shortontech/gotrack/pkg/event"
+)
+
+// POST /conversion — one typed conversion (order_id, value, currency,
+// items). Payloads are validated by the conversion package and rejected as a
+// whole with 400 and the problems found. An order_id already converted
+// within CONVERSION_DEDUP_DAYS is acknowledged but not emitted again, so
+// checkout retries and reloaded thank-you pages count once. Authentication
+// is the same as /collect.
+func (e Env) Conversion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !e.allowRequest(w, r) {
+		return
+	}
+	r, ok := e.resolveCredentials(w, r)
+	if !ok {
+		return
+	}
+	body, ok := e.readAndVerifyBody(w, r)
+	if !ok {
+		return
+	}
+
+	payload, err := conversion.Parse(body)
+	if err != nil {
+		http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errs := payload.Validate(); len(errs) > 0 {
+		writeJSON(w, http.StatusBadRequest, conversionResponse{Status: "rejected", Errors: errs})
+		return
+	}
+	ev := payload.ToEvent()
+	if !checkScope(w, r, []event.Event{ev}) {
+		return
+	}
+	resp := conversionResponse{Status: "ok", OrderID: ev.Ecommerce.OrderID}
+	if e.orderSeen(r.Context(), tenantFrom(r.Context()).siteID(), ev.Ecommerce.OrderID) {
+		resp.Status = "duplicate"
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	e.enrich(r, &ev)
+	e.applySessions(w, r, &ev)
+	e.applyClickCookies(nil, r, &ev)
+	e.applyExperiments(nil, r, &ev)
+	if !e.honorOptOut(r, &ev) {
+		logging.Debugf("Conversion dropped: client opted out of tracking")
+	} else if e.Emit != nil {
+		e.Emit(r.Context(), ev)
+	}
+	writeJSON(w, http.StatusAccepted, resp)
+}
+
+// conversionResponse is the answer to /conversion
+type conversionResponse struct {
+	Status  string                  `json:"status"` // ok, duplicate or rejected
+	OrderID string                  `json:"order_id,omitempty"`
+	Errors  []conversion.FieldError `json:"errors,omitempty"`
+}
+
+// orderSeen records an order and reports whether it was already converted.
+// Orders are per site, and lookups that fail count as new orders.
+func (e Env) orderSeen(ctx context.Context, siteID, orderID string) bool {
+	if e.Orders == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond) // a slow store must not stall ingestion
+	defer cancel()
+	seen, err := e.Orders.Seen(ctx, siteID+":"+orderID)
+	if err != nil {
+		log.Printf("conversion: order lookup failed, keeping conversion: %v", err)
+		return false
+	}
+	return seen
+}
@@ -0,0 +1,81 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/dedup"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestConversion(t *testing.T) {
+	var emitted []event.Event
+	env := Env{
+		Cfg:    config.Config{MaxBodyBytes: 1 << 20, MaxDecompressedBytes: 1 << 20},
+		Emit:   func(_ context.Context, e event.Event) { emitted = append(emitted, e) },
+		Orders: dedup.NewMemoryDetector(100, time.Hour),
+	}
+	post := func(method, body string) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest(method, "/conversion", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		env.Conversion(w, req)
+		var resp map[string]any
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+	order := `{"order_id":"A-100","value":49.5,"currency":"gbp","items":[{"item_id":"sku-1","price":49.5}]}`
+
+	w, resp := post(http.MethodPost, order)
+	if w.Code != http.StatusAccepted || resp["status"] != "ok" || resp["order_id"] != "A-100" {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if len(emitted) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(emitted))
+	}
+	ev := emitted[0]
+	if ev.Type != "purchase" || ev.Ecommerce == nil || ev.Ecommerce.Currency != "GBP" || ev.Ecommerce.Value != 49.5 {
+		t.Errorf("event = %+v, ecommerce = %+v", ev, ev.Ecommerce)
+	}
+	if ev.EventID == "" || ev.Server.IP == "" {
+		t.Errorf("expected server enrichment, got %+v", ev)
+	}
+
+	t.Run("repeated order is not emitted again", func(t *testing.T) {
+		w, resp := post(http.MethodPost, order)
+		if w.Code != http.StatusOK || resp["status"] != "duplicate" {
+			t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		if len(emitted) != 1 {
+			t.Errorf("expected no new event, got %d", len(emitted))
+		}
+	})
+
+	t.Run("invalid payload lists the problems", func(t *testing.T) {
+		w, resp := post(http.MethodPost, `{"order_id":"A-101","value":-5,"currency":"XYZ"}`)
+		errs, _ := resp["errors"].([]any)
+		if w.Code != http.StatusBadRequest || resp["status"] != "rejected" || len(errs) != 2 {
+			t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("unknown fields are rejected", func(t *testing.T) {
+		w, _ := post(http.MethodPost, `{"order_id":"A-102","revenue":10,"currency":"USD"}`)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "revenue") {
+			t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("GET is not allowed", func(t *testing.T) {
+		if w, _ := post(http.MethodGet, ""); w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("status = %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package httpx
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// CSP modes accepted by INJECT_CSP
+const (
+	CSPModeOff      = "off"      // inline as always; templates still see an existing nonce
+	CSPModeNonce    = "nonce"    // tag scripts with the page's nonce, adding one to the policy if needed
+	CSPModeExternal = "external" // load /pixel.js instead of inlining when the policy blocks inline scripts
+)
+
+// ValidCSPMode reports whether mode is a supported INJECT_CSP value
+func ValidCSPMode(mode string) error {
+	switch mode {
+	case "", CSPModeOff, CSPModeNonce, CSPModeExternal:
+		return nil
+	default:
+		return fmt.Errorf("unsupported INJECT_CSP mode %q (want off, nonce or external)", mode)
+	}
+}
+
+// nonceRegex matches a 'nonce-...' source expression
+var nonceRegex = regexp.MustCompile(`'nonce-([A-Za-z0-9+/=_-]+)'`)
+
+// scriptPlan says how injected scripts get past the page's policy
+type scriptPlan struct {
+	nonce    string // set as the nonce attribute of injected scripts
+	external bool   // load the library from /pixel.js instead of inlining it
+}
+
+// planScripts inspects the enforced Content-Security-Policy in header. In
+// nonce mode a policy blocking inline scripts gets a fresh nonce when it has
+// none, so header is updated before it is sent.
+func planScripts(header http.Header, mode string) scriptPlan {
+	plan := scriptPlan{nonce: cspNonce(header)}
+	if mode == "" || mode == CSPModeOff {
+		return plan
+	}
+
+	policies := header.Values("Content-Security-Policy")
+	blocked := false
+	for _, policy := range policies {
+		if _, sources, ok := scriptDirective(policy); ok && blocksInline(sources) {
+			blocked = true
+		}
+	}
+	if !blocked {
+		return plan
+	}
+
+	if mode == CSPModeExternal {
+		plan.external = true
+		return plan
+	}
+	if plan.nonce == "" {
+		plan.nonce = newNonce()
+	}
+	// Every enforced policy must allow the nonce, or the strictest one wins
+	updated := make([]string, len(policies))
+	for i, policy := range policies {
+		updated[i] = addNonce(policy, plan.nonce)
+	}
+	header.Del("Content-Security-Policy")
+	for _, policy := range updated {
+		header.Add("Content-Security-Policy", policy)
+	}
+	return plan
+}
+
+// scriptDirective returns the directive governing <script> elements in
+// policy: script-src, else default-src
+func scriptDirective(policy string) (name, sources string, ok bool) {
+	var fallback string
+	found := false
+	for _, d := range strings.Split(policy, ";") {
+		n, s, _ := strings.Cut(strings.TrimSpace(d), " ")
+		switch strings.ToLower(n) {
+		case "script-src":
+			return "script-src", s, true
+		case "default-src":
+			fallback, found = s, true
+		}
+	}
+	return "default-src", fallback, found
+}
+
+// blocksInline reports whether sources refuse inline scripts without a nonce.
+// 'unsafe-inline' is ignored by browsers once a nonce or hash is listed.
+func blocksInline(sources string) bool {
+	lower := strings.ToLower(sources)
+	if strings.Contains(lower, "'nonce-") || strings.Contains(lower, "'sha256-") ||
+		strings.Contains(lower, "'sha384-") || strings.Contains(lower, "'sha512-") {
+		return true
+	}
+	return !strings.Contains(lower, "'unsafe-inline'")
+}
+
+// addNonce lists nonce in the script directive of policy when that directive
+// blocks inline scripts and doesn't allow it yet
+func addNonce(policy, nonce string) string {
+	name, sources, ok := scriptDirective(policy)
+	if !ok || !blocksInline(sources) || strings.Contains(sources, "'nonce-"+nonce+"'") {
+		return policy
+	}
+	directives := strings.Split(policy, ";")
+	for i, d := range directives {
+		n, _, _ := strings.Cut(strings.TrimSpace(d), " ")
+		if strings.EqualFold(n, name) {
+			directives[i] = strings.TrimRight(d, " ") + " 'nonce-" + nonce + "'"
+			break
+		}
+	}
+	return strings.Join(directives, ";")
+}
+
+// newNonce returns a random base64 nonce
+func newNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// cspNonce returns the nonce allowed for scripts by the Content-Security-Policy
+// in header, looking at script-src and then default-src
+func cspNonce(header http.Header) string {
+	for _, directive := range []string{"script-src", "default-src"} {
+		for _, policy := range header.Values("Content-Security-Policy") {
+			for _, d := range strings.Split(policy, ";") {
+				name, sources, _ := strings.Cut(strings.TrimSpace(d), " ")
+				if !strings.EqualFold(name, directive) {
+					continue
+				}
+				if m := nonceRegex.FindStringSubmatch(sources); m != nil {
+					return m[1]
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,184 @@
+package httpx
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestCSPNonce tests reading the script nonce from the page's policy
+func TestCSPNonce(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy []string
+		want   string
+	}{
+		{"script-src", []string{"default-src 'self'; script-src 'self' 'nonce-abc123=='"}, "abc123=="},
+		{"default-src fallback", []string{"default-src 'self' 'nonce-xyz'"}, "xyz"},
+		{"script-src wins", []string{"default-src 'nonce-def'", "script-src 'nonce-scr'"}, "scr"},
+		{"style nonce ignored", []string{"style-src 'nonce-css'"}, ""},
+		{"no policy", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for _, p := range tt.policy {
+				h.Add("Content-Security-Policy", p)
+			}
+			if got := cspNonce(h); got != tt.want {
+				t.Errorf("cspNonce() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPlanScripts tests how injected scripts get past the page's policy
+func TestPlanScripts(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		policy    string
+		nonce     string // expected nonce; "*" for a generated one
+		external  bool
+		newPolicy string // expected policy with "*" for a generated nonce; empty when unchanged
+	}{
+		{name: "no policy", mode: CSPModeNonce},
+		{name: "inline allowed", mode: CSPModeNonce, policy: "script-src 'self' 'unsafe-inline'"},
+		{name: "off keeps existing nonce", mode: CSPModeOff, policy: "script-src 'nonce-abc'", nonce: "abc"},
+		{name: "off leaves a strict policy", mode: CSPModeOff, policy: "script-src 'self'"},
+		{name: "reuses the page nonce", mode: CSPModeNonce, policy: "script-src 'nonce-abc' 'strict-dynamic'", nonce: "abc"},
+		{
+			name: "adds a nonce", mode: CSPModeNonce, policy: "default-src 'self'; script-src 'self'; img-src *",
+			nonce: "*", newPolicy: "default-src 'self'; script-src 'self' 'nonce-*'; img-src *",
+		},
+		{
+			name: "adds to default-src", mode: CSPModeNonce, policy: "default-src 'self'",
+			nonce: "*", newPolicy: "default-src 'self' 'nonce-*'",
+		},
+		{
+			name: "unsafe-inline ignored next to a hash", mode: CSPModeNonce, policy: "script-src 'unsafe-inline' 'sha256-abc='",
+			nonce: "*", newPolicy: "script-src 'unsafe-inline' 'sha256-abc=' 'nonce-*'",
+		},
+		{name: "external when blocked", mode: CSPModeExternal, policy: "script-src 'self'", external: true},
+		{name: "external keeps nonce", mode: CSPModeExternal, policy: "script-src 'nonce-abc'", nonce: "abc", external: true},
+		{name: "external not needed", mode: CSPModeExternal, policy: "img-src *"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.policy != "" {
+				h.Set("Content-Security-Policy", tt.policy)
+			}
+			plan := planScripts(h, tt.mode)
+
+			if plan.external != tt.external {
+				t.Errorf("external = %v, want %v", plan.external, tt.external)
+			}
+			switch {
+			case tt.nonce == "*" && len(plan.nonce) < 16:
+				t.Errorf("nonce = %q, want a generated one", plan.nonce)
+			case tt.nonce != "*" && plan.nonce != tt.nonce:
+				t.Errorf("nonce = %q, want %q", plan.nonce, tt.nonce)
+			}
+			want := tt.policy
+			if tt.newPolicy != "" {
+				want = strings.ReplaceAll(tt.newPolicy, "*'", plan.nonce+"'")
+			}
+			if got := h.Get("Content-Security-Policy"); got != want {
+				t.Errorf("policy = %q, want %q", got, want)
+			}
+		})
+	}
+
+	t.Run("every enforced policy allows the nonce", func(t *testing.T) {
+		h := http.Header{}
+		h.Add("Content-Security-Policy", "script-src 'self'")
+		h.Add("Content-Security-Policy", "default-src 'none'")
+		h.Add("Content-Security-Policy", "img-src *")
+		plan := planScripts(h, CSPModeNonce)
+		policies := h.Values("Content-Security-Policy")
+		if len(policies) != 3 || !strings.Contains(policies[0], plan.nonce) || !strings.Contains(policies[1], plan.nonce) || policies[2] != "img-src *" {
+			t.Errorf("policies = %q", policies)
+		}
+	})
+}
+
+// TestValidCSPMode tests INJECT_CSP values
+func TestValidCSPMode(t *testing.T) {
+	for _, mode := range []string{"", CSPModeOff, CSPModeNonce, CSPModeExternal} {
+		if err := ValidCSPMode(mode); err != nil {
+			t.Errorf("ValidCSPMode(%q) = %v", mode, err)
+		}
+	}
+	if err := ValidCSPMode("strict"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+	if _, err := NewInjector(InjectorConfig{CSPMode: "strict"}); err == nil {
+		t.Error("NewInjector should reject unknown modes")
+	}
+}
+
+// TestBuiltinSnippetCSP tests the built-in snippet under a strict policy
+func TestBuiltinSnippetCSP(t *testing.T) {
+	page := []byte("<html><body></body></html>")
+	req := httptest.NewRequest(http.MethodGet, "/checkout", nil)
+
+	t.Run("nonce", func(t *testing.T) {
+		in, _ := NewInjector(InjectorConfig{CSPMode: CSPModeNonce})
+		h := http.Header{"Content-Security-Policy": {"script-src 'nonce-n0nce'"}}
+		result := string(in.inject(page, req, h, NewHMACAuth("secret", ""), ""))
+		if !strings.Contains(result, `<script src="/hmac.js" nonce="n0nce"></script>`) || !strings.Contains(result, `<script nonce="n0nce">`) {
+			t.Errorf("scripts not tagged: %s", result[:min(len(result), 200)])
+		}
+	})
+
+	t.Run("external", func(t *testing.T) {
+		in, _ := NewInjector(InjectorConfig{CSPMode: CSPModeExternal})
+		h := http.Header{"Content-Security-Policy": {"script-src 'self'"}}
+		result := string(in.inject(page, req, h, nil, "/t"))
+		if !strings.Contains(result, `<script src="/t/pixel.js"></script>`) || strings.Contains(result, "<script>") {
+			t.Errorf("library should load from /pixel.js: %s", result)
+		}
+		if !strings.Contains(result, `<img src="/t/px.gif?`) {
+			t.Error("pixel fallback missing")
+		}
+	})
+
+	t.Run("off leaves the snippet as before", func(t *testing.T) {
+		in, _ := NewInjector(InjectorConfig{CSPMode: CSPModeOff})
+		h := http.Header{"Content-Security-Policy": {"script-src 'nonce-n0nce'"}}
+		if got, want := string(in.inject(page, req, h, nil, "")), string(injectPixel(page, req, nil, "")); got != want {
+			t.Error("off mode changed the built-in snippet")
+		}
+	})
+}
+
+// TestProxyHandlerCSPNonce tests that the nonce sent in the policy matches the page
+func TestProxyHandlerCSPNonce(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		_, _ = io.WriteString(w, "<html><body>shop</body></html>")
+	}))
+	defer backend.Close()
+
+	in, err := NewInjector(InjectorConfig{CSPMode: CSPModeNonce})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewProxyHandler(backend.URL, nil)
+	handler.injector = in
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	m := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(w.Header().Get("Content-Security-Policy"))
+	if m == nil {
+		t.Fatalf("policy without nonce: %q", w.Header().Get("Content-Security-Policy"))
+	}
+	if !strings.Contains(w.Body.String(), `<script nonce="`+m[1]+`">`) {
+		t.Error("injected script does not carry the policy nonce")
+	}
+}
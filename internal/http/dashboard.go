@@ -0,0 +1,205 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/assets"
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+// tailKeepalive is how often an idle tail sends a comment, so proxies
+// don't close the stream
+const tailKeepalive = 15 * time.Second
+
+// dashboardCSP confines the dashboard page to its own script and the admin
+// API. Event fields are rendered as text, never as markup.
+const dashboardCSP = "default-src 'none'; script-src 'self'; style-src 'unsafe-inline'; connect-src 'self'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'"
+
+// AdminDashboard serves the dashboard page at /_gotrack/admin/ui/ and its
+// script. They hold no data and are served without the token; the page asks
+// for it and sends it with every API call.
+func (e Env) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var content []byte
+	switch r.URL.Path {
+	case adminPathPrefix + "admin/ui/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		content = assets.DashboardHTML
+	case adminPathPrefix + "admin/ui/dashboard.js":
+		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+		content = assets.DashboardJS
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Security-Policy", dashboardCSP)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(content)
+	}
+}
+
+// sinkStatus is one sink on the dashboard
+type sinkStatus struct {
+	Name           string  `json:"name"`
+	Status         string  `json:"status"` // ok or unavailable, as on /readyz
+	Error          string  `json:"error,omitempty"`
+	QueueDepth     *int    `json:"queue_depth,omitempty"` // buffered events, for sinks that buffer
+	FlushLatencyMS float64 `json:"flush_latency_ms,omitempty"`
+}
+
+// sinkStatuses checks sinks' health and reads their queues
+func sinkStatuses(ctx context.Context, sinks []sink.Sink) []sinkStatus {
+	errs := pingSinks(ctx, sinks)
+	statuses := make([]sinkStatus, len(sinks))
+	for i, s := range sinks {
+		statuses[i] = sinkStatus{Name: s.Name(), Status: "ok"}
+		if errs[i] != nil {
+			statuses[i].Status, statuses[i].Error = "unavailable", errs[i].Error()
+		}
+		if lr, ok := s.(sink.LoadReporter); ok {
+			depth, latency := lr.Load()
+			statuses[i].QueueDepth = &depth
+			statuses[i].FlushLatencyMS = float64(latency.Microseconds()) / 1000
+		}
+	}
+	return statuses
+}
+
+// AdminStatus reports sink health and queue depths along with the recent
+// ingestion and sink errors, newest first
+func (e Env) AdminStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sinks := sinkStatuses(r.Context(), e.Sinks)
+	recent := e.Inspector.Errors()
+	if recent == nil {
+		recent = []InspectorError{}
+	}
+	writeJSON(w, http.StatusOK, statusResponse{Draining: e.Drainer.Draining(), Sinks: sinks, Errors: recent})
+}
+
+// statusResponse is the answer to /_gotrack/admin/status
+type statusResponse struct {
+	Draining bool             `json:"draining"`
+	Sinks    []sinkStatus     `json:"sinks"`
+	Errors   []InspectorError `json:"errors"` // newest first
+}
+
+// DebugTail streams events as server-sent events while they arrive,
+// starting with the most recent ones, e.g.
+// GET /_gotrack/debug/tail?type=click&ip=203.0.113.0/24. Filters:
+// type, visitor_id and ip (an address or CIDR range, matched before the IP is
+// anonymized). Events are shown enriched once dedup and sampling let them
+// through, before routing and transforms; only the IP is anonymized per
+// IP_PRIVACY_MODE.
+func (e Env) DebugTail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.Inspector == nil {
+		http.Error(w, "live tail not enabled", http.StatusNotFound)
+		return
+	}
+	filter, err := parseTailFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	recent, events, cancel := e.Inspector.subscribe()
+	defer cancel()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	for _, entry := range recent {
+		if filter.match(entry) {
+			writeTailEvent(w, entry.raw)
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(tailKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.match(entry) {
+				continue
+			}
+			writeTailEvent(w, entry.raw)
+		case <-keepalive.C:
+			_, _ = fmt.Fprint(w, ": keepalive\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeTailEvent(w http.ResponseWriter, raw json.RawMessage) {
+	_, _ = fmt.Fprintf(w, "data: %s\n\n", raw)
+}
+
+// tailFilter selects the events a tail shows. Empty fields match any event.
+type tailFilter struct {
+	typ       string
+	visitorID string
+	ip        netip.Prefix
+}
+
+func parseTailFilter(q url.Values) (tailFilter, error) {
+	f := tailFilter{
+		typ:       strings.TrimSpace(q.Get("type")),
+		visitorID: strings.TrimSpace(q.Get("visitor_id")),
+	}
+	if ip := strings.TrimSpace(q.Get("ip")); ip != "" {
+		var err error
+		if strings.Contains(ip, "/") {
+			f.ip, err = netip.ParsePrefix(ip)
+		} else {
+			var addr netip.Addr
+			if addr, err = netip.ParseAddr(ip); err == nil {
+				f.ip = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+			}
+		}
+		if err != nil {
+			return tailFilter{}, errors.New("ip must be an address or CIDR range")
+		}
+		f.ip = f.ip.Masked()
+	}
+	return f, nil
+}
+
+func (f tailFilter) match(e tailEntry) bool {
+	return (f.typ == "" || strings.EqualFold(e.typ, f.typ)) &&
+		(f.visitorID == "" || e.visitorID == f.visitorID) &&
+		(!f.ip.IsValid() || f.ip.Contains(e.ip))
+}
@@ -0,0 +1,212 @@
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+func TestAdminDashboard(t *testing.T) {
+	handler := NewMux(Env{Cfg: cfg.Config{AdminToken: "secret"}, Inspector: NewInspector(10, nil)})
+
+	tests := []struct {
+		path, contentType string
+		status            int
+	}{
+		{"/_gotrack/admin/ui/", "text/html; charset=utf-8", http.StatusOK},
+		{"/_gotrack/admin/ui/dashboard.js", "application/javascript; charset=utf-8", http.StatusOK},
+		{"/_gotrack/admin/ui/other.js", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("GET %s Content-Type = %q, want %q", tt.path, got, tt.contentType)
+		}
+		if csp := w.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "script-src 'self'") {
+			t.Errorf("GET %s CSP = %q", tt.path, csp)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_gotrack/admin/ui", nil))
+	if w.Code/100 != 3 || w.Header().Get("Location") != "/_gotrack/admin/ui/" {
+		t.Errorf("GET /_gotrack/admin/ui = %d to %q, want a redirect to the page", w.Code, w.Header().Get("Location"))
+	}
+
+	// The page is public, the data behind it is not
+	for _, path := range []string{"/_gotrack/admin/status", "/_gotrack/debug/tail"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without token = %d, want 401", path, w.Code)
+		}
+	}
+
+	// Without ADMIN_TOKEN there is no dashboard
+	w = httptest.NewRecorder()
+	NewMux(Env{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_gotrack/admin/ui/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("dashboard without ADMIN_TOKEN = %d, want 404", w.Code)
+	}
+}
+
+func TestAdminStatus(t *testing.T) {
+	in := NewInspector(10, nil)
+	in.RecordError(InspectorError{Source: "/collect", Status: 401, Message: "invalid write key"})
+	env := Env{
+		Inspector: in,
+		Sinks: []sink.Sink{
+			&flushingSink{fakeSink: fakeSink{name: "postgres"}, buffered: 7},
+			&fakeSink{name: "kafka", pingErr: fmt.Errorf("no brokers")},
+		},
+	}
+	w := httptest.NewRecorder()
+	env.AdminStatus(w, httptest.NewRequest(http.MethodGet, "/_gotrack/admin/status", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+
+	var body struct {
+		Draining bool             `json:"draining"`
+		Sinks    []sinkStatus     `json:"sinks"`
+		Errors   []InspectorError `json:"errors"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Sinks) != 2 {
+		t.Fatalf("sinks = %+v", body.Sinks)
+	}
+	if pg := body.Sinks[0]; pg.Status != "ok" || pg.QueueDepth == nil || *pg.QueueDepth != 7 {
+		t.Errorf("postgres = %+v", pg)
+	}
+	if kafka := body.Sinks[1]; kafka.Status != "unavailable" || kafka.Error != "no brokers" || kafka.QueueDepth != nil {
+		t.Errorf("kafka = %+v", kafka)
+	}
+	if len(body.Errors) != 1 || body.Errors[0].Message != "invalid write key" {
+		t.Errorf("errors = %+v", body.Errors)
+	}
+
+	// Without an inspector the error list is empty, not null
+	w = httptest.NewRecorder()
+	Env{}.AdminStatus(w, httptest.NewRequest(http.MethodGet, "/_gotrack/admin/status", nil))
+	if !strings.Contains(w.Body.String(), `"errors":[]`) {
+		t.Errorf("body = %s", w.Body.String())
+	}
+}
+
+func TestDebugTail(t *testing.T) {
+	in := NewInspector(10, nil)
+	in.Observe(event.Event{Type: "pageview", EventID: "before"})
+	srv := httptest.NewServer(NewMux(Env{Cfg: cfg.Config{AdminToken: "secret"}, Inspector: in}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/_gotrack/debug/tail?type=pageview", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	lines := bufio.NewScanner(res.Body)
+	next := func() string {
+		for lines.Scan() {
+			if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+				var ev event.Event
+				if err := json.Unmarshal([]byte(data), &ev); err != nil {
+					t.Fatalf("invalid event %s: %v", data, err)
+				}
+				return ev.EventID
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return ""
+	}
+
+	if id := next(); id != "before" {
+		t.Errorf("first event = %q, want the recent one", id)
+	}
+	in.Observe(event.Event{Type: "click", EventID: "filtered"})
+	in.Observe(event.Event{Type: "PageView", EventID: "live"})
+	if id := next(); id != "live" {
+		t.Errorf("next event = %q, want live (clicks filtered out)", id)
+	}
+
+	// Shutdown ends the stream
+	in.Close()
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		t.Errorf("stream did not end cleanly: %v", err)
+	}
+}
+
+func TestTailFilter(t *testing.T) {
+	entry := tailEntry{typ: "PageView", visitorID: "v1", ip: netip.MustParseAddr("203.0.113.7")}
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{"type=pageview", true},
+		{"type=click", false},
+		{"visitor_id=v1", true},
+		{"visitor_id=v2", false},
+		{"ip=203.0.113.7", true},
+		{"ip=::ffff:203.0.113.7", true},
+		{"ip=203.0.113.8", false},
+		{"ip=203.0.113.0/24", true},
+		{"ip=198.51.100.0/24", false},
+		{"type=pageview&visitor_id=v1&ip=203.0.113.0/24", true},
+		{"type=pageview&visitor_id=v2", false},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		f, err := parseTailFilter(q)
+		if err != nil {
+			t.Errorf("parseTailFilter(%q) error = %v", tt.query, err)
+			continue
+		}
+		if got := f.match(entry); got != tt.want {
+			t.Errorf("%q matches = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"ip=nope", "ip=10.0.0.0/99"} {
+		q, _ := url.ParseQuery(bad)
+		if _, err := parseTailFilter(q); err == nil {
+			t.Errorf("parseTailFilter(%q) accepted an invalid ip", bad)
+		}
+	}
+	// Events without a parsable IP never match an ip filter
+	q, _ := url.ParseQuery("ip=0.0.0.0/0")
+	f, _ := parseTailFilter(q)
+	if f.match(tailEntry{}) {
+		t.Error("entry without an IP matched an ip filter")
+	}
+}
@@ -0,0 +1,68 @@
+package httpx
+
+import (
+	"net/http"
+	"strings"
+
+	event "github.com/shortontech/gotrack/pkg/event"
+)
+
+// Actions taken on events from clients that opted out of tracking
+const (
+	DNTActionStrip = "strip" // remove identifying fields, keep the event
+	DNTActionDrop  = "drop"  // discard the event
+)
+
+// optOutSignal returns "gpc" or "dnt" when the client sent Sec-GPC: 1 or DNT: 1.
+// GPC is reported first because it carries legal weight in some jurisdictions.
+func optOutSignal(r *http.Request) string {
+	if strings.TrimSpace(r.Header.Get("Sec-GPC")) == "1" {
+		return "gpc"
+	}
+	if strings.TrimSpace(r.Header.Get("DNT")) == "1" {
+		return "dnt"
+	}
+	return ""
+}
+
+// dntAction returns the configured action, or "" when opt-out signals are ignored
+func (e Env) dntAction(r *http.Request) (signal, action string) {
+	if !e.Cfg.DNTRespect {
+		return "", ""
+	}
+	if signal = optOutSignal(r); signal == "" {
+		return "", ""
+	}
+	if e.Cfg.DNTAction == DNTActionDrop {
+		return signal, DNTActionDrop
+	}
+	return signal, DNTActionStrip
+}
+
+// honorOptOut applies BOT_RESPONSE, DNT/GPC and TCF consent enforcement to
+// an enriched event and reports whether it should still be emitted
+func (e Env) honorOptOut(r *http.Request, ev *event.Event) bool {
+	if !e.screenBot(r, ev) {
+		return false
+	}
+	signal, action := e.dntAction(r)
+	if action != "" {
+		if e.Metrics != nil {
+			e.Metrics.IncrementEventsSuppressed(signal, action)
+		}
+		if action == DNTActionDrop {
+			return false
+		}
+		stripIdentifiers(ev)
+	}
+	return e.honorConsent(ev)
+}
+
+// stripIdentifiers removes fields that identify a person or an ad click
+func stripIdentifiers(ev *event.Event) {
+	ev.Server.IP = ""
+	ev.Device.UA = ""
+	ev.Device.UABrands = nil
+	ev.Device.DeviceModel = ""
+	event.ClearClickIDs(ev)
+}
@@ -0,0 +1,133 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/metrics"
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// TestOptOutSignal tests DNT and GPC header detection
+func TestOptOutSignal(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "no headers", want: ""},
+		{name: "DNT", headers: map[string]string{"DNT": "1"}, want: "dnt"},
+		{name: "DNT disabled", headers: map[string]string{"DNT": "0"}, want: ""},
+		{name: "GPC", headers: map[string]string{"Sec-GPC": "1"}, want: "gpc"},
+		{name: "both prefer GPC", headers: map[string]string{"DNT": "1", "Sec-GPC": "1"}, want: "gpc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/px.gif", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := optOutSignal(req); got != tt.want {
+				t.Errorf("optOutSignal() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPixelHonorsOptOut tests DNT/GPC enforcement on the pixel endpoint
+func TestPixelHonorsOptOut(t *testing.T) {
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/px.gif?gclid=abc&fbclid=def&utm_source=news", nil)
+		req.RemoteAddr = "203.0.113.7:1234"
+		req.Header.Set("User-Agent", "Mozilla/5.0 Test")
+		req.Header.Set("DNT", "1")
+		return req
+	}
+
+	t.Run("ignored unless DNT_RESPECT is set", func(t *testing.T) {
+		var emitted []event.Event
+		env := Env{Emit: func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) }}
+		env.Pixel(httptest.NewRecorder(), newRequest())
+
+		if len(emitted) != 1 || emitted[0].Server.IP == "" {
+			t.Fatalf("expected unmodified event, got %+v", emitted)
+		}
+	})
+
+	t.Run("strip removes identifiers", func(t *testing.T) {
+		var emitted []event.Event
+		env := Env{
+			Cfg:     cfg.Config{DNTRespect: true, DNTAction: DNTActionStrip},
+			Emit:    func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) },
+			Metrics: metrics.InitMetrics(),
+		}
+		w := httptest.NewRecorder()
+		env.Pixel(w, newRequest())
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+		if len(emitted) != 1 {
+			t.Fatalf("expected 1 event, got %d", len(emitted))
+		}
+		ev := emitted[0]
+		if ev.Server.IP != "" || ev.Device.UA != "" {
+			t.Errorf("IP/UA not stripped: %q %q", ev.Server.IP, ev.Device.UA)
+		}
+		if ev.URL.Google.GCLID != "" || ev.URL.Meta.FBCLID != "" || strings.Contains(ev.URL.RawQuery, "gclid") {
+			t.Errorf("click IDs not stripped: %+v", ev.URL)
+		}
+		if _, ok := ev.Route.Query["gclid"]; ok {
+			t.Error("click ID left in route query")
+		}
+		if ev.URL.UTM.Source != "news" {
+			t.Errorf("non-identifying attribution should be kept, got %q", ev.URL.UTM.Source)
+		}
+	})
+
+	t.Run("drop discards the event but still serves the pixel", func(t *testing.T) {
+		var emitted []event.Event
+		env := Env{
+			Cfg:  cfg.Config{DNTRespect: true, DNTAction: DNTActionDrop},
+			Emit: func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) },
+		}
+		w := httptest.NewRecorder()
+		env.Pixel(w, newRequest())
+
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" {
+			t.Errorf("expected pixel response, got %d %s", w.Code, w.Header().Get("Content-Type"))
+		}
+		if len(emitted) != 0 {
+			t.Errorf("expected event to be dropped, got %d", len(emitted))
+		}
+	})
+}
+
+// TestCollectHonorsOptOut tests DNT/GPC enforcement on the collect endpoint
+func TestCollectHonorsOptOut(t *testing.T) {
+	var emitted []event.Event
+	env := Env{
+		Cfg:  cfg.Config{DNTRespect: true, DNTAction: DNTActionDrop, MaxBodyBytes: 1 << 20},
+		Emit: func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) },
+	}
+
+	for _, body := range []string{`{"type":"click"}`, `[{"type":"click"},{"type":"pageview"}]`} {
+		req := httptest.NewRequest(http.MethodPost, "/collect", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Sec-GPC", "1")
+		w := httptest.NewRecorder()
+		env.Collect(w, req)
+
+		if w.Code != http.StatusAccepted {
+			t.Errorf("status = %d, want 202", w.Code)
+		}
+	}
+	if len(emitted) != 0 {
+		t.Errorf("expected all events to be dropped, got %d", len(emitted))
+	}
+}
@@ -0,0 +1,156 @@
+package httpx
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/sink"
+)
+
+// drainRetryAfter is the Retry-After sent with 503s while draining, in
+// seconds; by then the load balancer has moved the client elsewhere
+const drainRetryAfter = "5"
+
+// Drainer takes an instance out of service before shutdown: once draining,
+// ingestion endpoints and /readyz answer 503, and every sink that implements
+// sink.Flusher writes out its buffered events within the drain timeout.
+// Draining cannot be undone; the process is expected to exit afterwards.
+type Drainer struct {
+	sinks    []sink.Sink
+	timeout  time.Duration
+	draining atomic.Bool
+	mu       sync.Mutex // serializes drains
+}
+
+// SinkDrain is the outcome of draining one sink
+type SinkDrain struct {
+	Flushed   int    `json:"flushed"`   // events written during the drain
+	Remaining int    `json:"remaining"` // events still buffered afterwards
+	Error     string `json:"error,omitempty"`
+}
+
+// DrainReport summarizes a drain
+type DrainReport struct {
+	Status   string               `json:"status"` // drained, or incomplete if any sink kept events
+	Duration string               `json:"duration"`
+	Sinks    map[string]SinkDrain `json:"sinks"`
+}
+
+// NewDrainer returns a drainer for sinks. A timeout of zero or less means
+// flushes are bounded only by the context passed to Drain.
+func NewDrainer(sinks []sink.Sink, timeout time.Duration) *Drainer {
+	return &Drainer{sinks: sinks, timeout: timeout}
+}
+
+// Draining reports whether a drain has started. It is safe on a nil Drainer.
+func (d *Drainer) Draining() bool {
+	return d != nil && d.draining.Load()
+}
+
+// Drain stops ingestion and flushes all sinks concurrently. A sink that has
+// not finished by the deadline is reported with its events still buffered;
+// they are written, if at all, when the sink is closed. Drain may be called
+// again, for example by SIGTERM after the admin endpoint.
+func (d *Drainer) Drain(ctx context.Context) DrainReport {
+	d.draining.Store(true)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	results := make([]SinkDrain, len(d.sinks))
+	var wg sync.WaitGroup
+	for i, s := range d.sinks {
+		f, ok := s.(sink.Flusher)
+		if !ok {
+			continue
+		}
+		done := make(chan SinkDrain, 1)
+		go func() {
+			n, err := f.Flush(ctx)
+			result := SinkDrain{Flushed: n}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			done <- result
+		}()
+
+		// A flush stuck in a blocking write is abandoned at the deadline
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case results[i] = <-done:
+			case <-ctx.Done():
+				results[i] = SinkDrain{Error: "flush did not finish before the drain deadline"}
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := DrainReport{
+		Status:   "drained",
+		Duration: time.Since(start).Round(time.Millisecond).String(),
+		Sinks:    make(map[string]SinkDrain, len(d.sinks)),
+	}
+	for i, s := range d.sinks {
+		result := results[i]
+		if lr, ok := s.(sink.LoadReporter); ok {
+			result.Remaining, _ = lr.Load()
+		}
+		if result.Remaining > 0 || result.Error != "" {
+			report.Status = "incomplete"
+		}
+		report.Sinks[s.Name()] = result
+		if result.Error != "" {
+			log.Printf("drain: %s sink flushed=%d remaining=%d: %s", s.Name(), result.Flushed, result.Remaining, result.Error)
+		} else {
+			log.Printf("drain: %s sink flushed=%d remaining=%d", s.Name(), result.Flushed, result.Remaining)
+		}
+	}
+	log.Printf("drain: %s in %s", report.Status, report.Duration)
+	return report
+}
+
+// rejectWhileDraining answers 503 on an ingestion endpoint once a drain has
+// started, so clients retry against another instance
+func (e Env) rejectWhileDraining(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if e.Drainer.Draining() {
+			w.Header().Set("Retry-After", drainRetryAfter)
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// AdminDrain starts a drain and reports how many events each sink flushed.
+// Orchestrators call it before stopping the instance; the process keeps
+// serving admin and health endpoints until it receives SIGTERM.
+func (e Env) AdminDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.Drainer == nil {
+		http.Error(w, "drain not available", http.StatusNotFound)
+		return
+	}
+	// Not tied to the request: a client disconnect must not cut the flush short
+	report := e.Drainer.Drain(context.Background())
+	code := http.StatusOK
+	if report.Status != "drained" {
+		code = http.StatusInternalServerError
+	}
+	writeJSON(w, code, report)
+}
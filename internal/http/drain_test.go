@@ -0,0 +1,181 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/sink"
+	cfg "github.com/shortontech/gotrack/pkg/config"
+)
+
+// flushingSink is a buffering sink whose Flush writes its buffer, fails with
+// flushErr, or blocks until the context is done
+type flushingSink struct {
+	fakeSink
+	buffered int
+	flushErr error
+	block    bool
+}
+
+func (f *flushingSink) Flush(ctx context.Context) (int, error) {
+	if f.block {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	if f.flushErr != nil {
+		return 0, f.flushErr
+	}
+	n := f.buffered
+	f.buffered = 0
+	return n, nil
+}
+
+func (f *flushingSink) Load() (int, time.Duration) { return f.buffered, 0 }
+
+func TestDrainer(t *testing.T) {
+	t.Run("flushes every sink", func(t *testing.T) {
+		d := NewDrainer([]sink.Sink{
+			&flushingSink{fakeSink: fakeSink{name: "postgres"}, buffered: 3},
+			&flushingSink{fakeSink: fakeSink{name: "kafka"}, buffered: 2},
+			&fakeSink{name: "log"},
+		}, time.Second)
+		if d.Draining() {
+			t.Fatal("Draining() before Drain")
+		}
+
+		report := d.Drain(context.Background())
+		if !d.Draining() {
+			t.Error("Draining() = false after Drain")
+		}
+		if report.Status != "drained" {
+			t.Errorf("status = %q, want drained", report.Status)
+		}
+		if got := report.Sinks["postgres"]; got.Flushed != 3 || got.Remaining != 0 {
+			t.Errorf("postgres = %+v", got)
+		}
+		if got := report.Sinks["kafka"]; got.Flushed != 2 {
+			t.Errorf("kafka = %+v", got)
+		}
+		if _, ok := report.Sinks["log"]; !ok {
+			t.Error("sinks without a buffer should still be reported")
+		}
+	})
+
+	t.Run("reports failed flushes", func(t *testing.T) {
+		d := NewDrainer([]sink.Sink{
+			&flushingSink{fakeSink: fakeSink{name: "postgres"}, buffered: 3, flushErr: errors.New("connection refused")},
+		}, time.Second)
+		report := d.Drain(context.Background())
+		got := report.Sinks["postgres"]
+		if report.Status != "incomplete" || got.Remaining != 3 || !strings.Contains(got.Error, "connection refused") {
+			t.Errorf("report = %+v", report)
+		}
+	})
+
+	t.Run("gives up at the deadline", func(t *testing.T) {
+		d := NewDrainer([]sink.Sink{
+			&flushingSink{fakeSink: fakeSink{name: "relay"}, buffered: 1, block: true},
+		}, 50*time.Millisecond)
+		start := time.Now()
+		report := d.Drain(context.Background())
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Drain took %v", elapsed)
+		}
+		if report.Status != "incomplete" || report.Sinks["relay"].Error == "" {
+			t.Errorf("report = %+v", report)
+		}
+	})
+
+	t.Run("nil drainer is never draining", func(t *testing.T) {
+		var d *Drainer
+		if d.Draining() {
+			t.Error("nil Drainer reports draining")
+		}
+	})
+}
+
+func TestDrainRejectsIngestion(t *testing.T) {
+	d := NewDrainer(nil, time.Second)
+	env := Env{Cfg: cfg.Config{AdminToken: "admin-token"}, Drainer: d}
+	mux := NewMux(env)
+
+	d.Drain(context.Background())
+	for _, path := range []string{"/collect", "/px.gif"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"type":"pageview"}`)))
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: status = %d, Retry-After = %q", path, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "draining") {
+		t.Errorf("/readyz: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("/healthz: status = %d, want 200 while draining", w.Code)
+	}
+}
+
+func TestAdminDrain(t *testing.T) {
+	t.Run("drains and reports counts", func(t *testing.T) {
+		s := &flushingSink{fakeSink: fakeSink{name: "postgres"}, buffered: 4}
+		env := Env{Cfg: cfg.Config{AdminToken: "admin-token"}, Drainer: NewDrainer([]sink.Sink{s}, time.Second)}
+		req := newAdminRequest("/_gotrack/admin/drain")
+		req.Method = http.MethodPost
+		w := httptest.NewRecorder()
+		NewMux(env).ServeHTTP(w, req)
+
+		var report DrainReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatalf("invalid JSON body: %v", err)
+		}
+		if w.Code != http.StatusOK || report.Sinks["postgres"].Flushed != 4 {
+			t.Errorf("status = %d, report = %+v", w.Code, report)
+		}
+		if !env.Drainer.Draining() {
+			t.Error("instance not draining after admin drain")
+		}
+	})
+
+	t.Run("reports incomplete drains", func(t *testing.T) {
+		s := &flushingSink{fakeSink: fakeSink{name: "postgres"}, buffered: 4, flushErr: errors.New("timeout")}
+		env := Env{Drainer: NewDrainer([]sink.Sink{s}, time.Second)}
+		req := newAdminRequest("/_gotrack/admin/drain")
+		req.Method = http.MethodPost
+		w := httptest.NewRecorder()
+		env.AdminDrain(w, req)
+		if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "incomplete") {
+			t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("requires POST", func(t *testing.T) {
+		env := Env{Drainer: NewDrainer(nil, time.Second)}
+		w := httptest.NewRecorder()
+		env.AdminDrain(w, newAdminRequest("/_gotrack/admin/drain"))
+		if w.Code != http.StatusMethodNotAllowed || env.Drainer.Draining() {
+			t.Errorf("status = %d, draining = %v", w.Code, env.Drainer.Draining())
+		}
+	})
+
+	t.Run("returns 404 without a drainer", func(t *testing.T) {
+		req := newAdminRequest("/_gotrack/admin/drain")
+		req.Method = http.MethodPost
+		w := httptest.NewRecorder()
+		Env{}.AdminDrain(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", w.Code)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package httpx
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/shortontech/gotrack/internal/email"
+	"github.com/shortontech/gotrack/internal/logging"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Event types of the email endpoints
+const (
+	EmailOpenEvent  = "email_open"
+	EmailClickEvent = "email_click"
+)
+
+// GET /e/o.gif — the open pixel of a signed email link. The GIF is returned
+// whatever happens to the event, so the email never shows a broken image.
+func (e Env) EmailOpen(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	link, err := email.Parse([]byte(e.Cfg.EmailLinkSecret), email.OpenPath, r.URL.Query())
+	if err != nil {
+		logging.Debugf("Email open dropped: %v", err)
+	} else {
+		e.recordLinkEvent(w, r, emailEvent(EmailOpenEvent, link))
+	}
+	writePixel(w, r.Method == http.MethodHead)
+}
+
+// GET /e/c — records the click of a signed email link and redirects to its
+// destination. Only links signed with EMAIL_LINK_SECRET are followed, so the
+// endpoint can't be used as an open redirect. The visitor is redirected even
+// when the click isn't recorded.
+func (e Env) EmailClick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	link, err := email.Parse([]byte(e.Cfg.EmailLinkSecret), email.ClickPath, r.URL.Query())
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, email.ErrInvalidSignature) {
+			code = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	// HEAD requests come from link scanners checking the destination
+	if r.Method == http.MethodGet {
+		e.recordLinkEvent(w, r, emailEvent(EmailClickEvent, link))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	http.Redirect(w, r, link.URL, http.StatusFound)
+}
+
+// emailEvent builds the event of an email link. Clicks take the
+// destination's page and campaign parameters.
+func emailEvent(typ string, link email.Link) event.Event {
+	ev := event.Event{Type: typ}
+	for name, value := range map[string]string{
+		"email_campaign":  link.Campaign,
+		"email_message":   link.Message,
+		"email_recipient": link.Recipient,
+	} {
+		if value == "" {
+			continue
+		}
+		if ev.Props == nil {
+			ev.Props = map[string]string{}
+		}
+		ev.Props[name] = value
+	}
+	if u, err := url.Parse(link.URL); err == nil && link.URL != "" {
+		ev.Route.Domain = u.Hostname()
+		ev.Route.Protocol = u.Scheme
+		ev.Route.Path = u.Path
+		ev.Route.FullPath = u.RequestURI()
+		ev.Route.Hash = u.Fragment
+		event.ApplyCampaignParams(u.Query(), &ev)
+	}
+	ev.URL.Channel = event.ChannelEmail
+	return ev
+}
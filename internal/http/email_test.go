@@ -0,0 +1,107 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/email"
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestEmailTracking(t *testing.T) {
+	secret := []byte("email-secret")
+	var emitted []event.Event
+	env := Env{
+		Cfg:  cfg.Config{EmailLinkSecret: string(secret)},
+		Emit: func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) },
+	}
+	handler := NewMux(env)
+	serve := func(h http.Handler, method, target string) *httptest.ResponseRecorder {
+		emitted = nil
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+	link := email.Link{Campaign: "spring", Message: "v2", Recipient: "r-42", URL: "https://shop.example/sale?utm_source=newsletter&utm_campaign=spring#top"}
+	click, err := email.ClickURL("", secret, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := email.OpenURL("", secret, link)
+
+	t.Run("open", func(t *testing.T) {
+		w := serve(handler, http.MethodGet, open)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" {
+			t.Fatalf("status = %d, content-type = %q", w.Code, w.Header().Get("Content-Type"))
+		}
+		if len(emitted) != 1 {
+			t.Fatalf("emitted = %d, want 1", len(emitted))
+		}
+		ev := emitted[0]
+		if ev.Type != EmailOpenEvent || ev.Props["email_campaign"] != "spring" || ev.Props["email_message"] != "v2" || ev.Props["email_recipient"] != "r-42" {
+			t.Errorf("event = %+v", ev)
+		}
+		if ev.URL.Channel != event.ChannelEmail || ev.Route.Domain != "" {
+			t.Errorf("channel = %q, route = %+v", ev.URL.Channel, ev.Route)
+		}
+	})
+
+	t.Run("forged open still gets the GIF", func(t *testing.T) {
+		w := serve(handler, http.MethodGet, strings.Replace(open, "c=spring", "c=other", 1))
+		if w.Code != http.StatusOK || len(emitted) != 0 {
+			t.Errorf("status = %d, emitted = %d", w.Code, len(emitted))
+		}
+	})
+
+	t.Run("click", func(t *testing.T) {
+		w := serve(handler, http.MethodGet, click)
+		if w.Code != http.StatusFound || w.Header().Get("Location") != link.URL {
+			t.Fatalf("status = %d, location = %q", w.Code, w.Header().Get("Location"))
+		}
+		if len(emitted) != 1 {
+			t.Fatalf("emitted = %d, want 1", len(emitted))
+		}
+		ev := emitted[0]
+		if ev.Type != EmailClickEvent || ev.Route.Domain != "shop.example" || ev.Route.Path != "/sale" || ev.Route.Hash != "top" {
+			t.Errorf("event = %+v", ev.Route)
+		}
+		if ev.URL.UTM.Source != "newsletter" || ev.URL.UTM.Campaign != "spring" || ev.URL.Channel != event.ChannelEmail {
+			t.Errorf("attribution = %+v, channel %q", ev.URL.UTM, ev.URL.Channel)
+		}
+	})
+
+	t.Run("tampered destination is refused", func(t *testing.T) {
+		u, _ := url.Parse(click)
+		q := u.Query()
+		q.Set(email.URLParam, "https://evil.example/")
+		u.RawQuery = q.Encode()
+		w := serve(handler, http.MethodGet, u.String())
+		if w.Code != http.StatusForbidden || w.Header().Get("Location") != "" || len(emitted) != 0 {
+			t.Errorf("status = %d, location = %q, emitted = %d", w.Code, w.Header().Get("Location"), len(emitted))
+		}
+	})
+
+	t.Run("link scanners and drains redirect without recording", func(t *testing.T) {
+		if w := serve(handler, http.MethodHead, click); w.Code != http.StatusFound || len(emitted) != 0 {
+			t.Errorf("HEAD: status = %d, emitted = %d", w.Code, len(emitted))
+		}
+		draining := env
+		draining.Drainer = NewDrainer(nil, time.Second)
+		draining.Drainer.Drain(context.Background())
+		if w := serve(NewMux(draining), http.MethodGet, click); w.Code != http.StatusFound || len(emitted) != 0 {
+			t.Errorf("draining: status = %d, emitted = %d", w.Code, len(emitted))
+		}
+	})
+
+	t.Run("disabled without a secret", func(t *testing.T) {
+		if w := serve(NewMux(Env{}), http.MethodGet, click); w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", w.Code)
+		}
+	})
+}
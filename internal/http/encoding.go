@@ -0,0 +1,60 @@
+package httpx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+var (
+	errUnsupportedEncoding  = errors.New("unsupported content-encoding")
+	errDecompressedTooLarge = errors.New("decompressed body too large")
+)
+
+// newDecoder wraps body in a decoder for a Content-Encoding value: gzip, br
+// or identity. Stacked encodings such as "gzip, br" are not supported.
+func newDecoder(encoding string, body io.Reader) (io.Reader, error) {
+	switch normalizeEncoding(encoding) {
+	case "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "br":
+		return brotli.NewReader(body), nil
+	default:
+		return nil, errUnsupportedEncoding
+	}
+}
+
+// decompressBody decodes a request body read off the wire. Output beyond max
+// bytes is refused, so a small, highly compressed payload cannot expand
+// without bound.
+func decompressBody(encoding string, body []byte, max int64) ([]byte, error) {
+	if normalizeEncoding(encoding) == "identity" {
+		return body, nil
+	}
+	dec, err := newDecoder(encoding, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(dec, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > max {
+		return nil, errDecompressedTooLarge
+	}
+	return out, nil
+}
+
+// normalizeEncoding lowercases a Content-Encoding value; empty means identity
+func normalizeEncoding(encoding string) string {
+	if encoding = strings.ToLower(strings.TrimSpace(encoding)); encoding == "" {
+		return "identity"
+	}
+	return encoding
+}
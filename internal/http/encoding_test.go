@@ -0,0 +1,136 @@
+package httpx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func brotliBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	bw := brotli.NewWriter(&buf)
+	if _, err := bw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressBody(t *testing.T) {
+	payload := []byte(`{"type":"click"}`)
+
+	for _, tt := range []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", payload},
+		{"identity", payload},
+		{"gzip", gzipBytes(t, payload)},
+		{"GZIP", gzipBytes(t, payload)},
+		{"br", brotliBytes(t, payload)},
+	} {
+		got, err := decompressBody(tt.encoding, tt.body, 1024)
+		if err != nil || !bytes.Equal(got, payload) {
+			t.Errorf("decompressBody(%q) = %q, %v", tt.encoding, got, err)
+		}
+	}
+
+	bomb := make([]byte, 1<<20)
+	for _, encoding := range []string{"gzip", "br"} {
+		compressed := gzipBytes(t, bomb)
+		if encoding == "br" {
+			compressed = brotliBytes(t, bomb)
+		}
+		if _, err := decompressBody(encoding, compressed, 64<<10); !errors.Is(err, errDecompressedTooLarge) {
+			t.Errorf("%s bomb: err = %v, want errDecompressedTooLarge", encoding, err)
+		}
+	}
+
+	if _, err := decompressBody("deflate", payload, 1024); !errors.Is(err, errUnsupportedEncoding) {
+		t.Errorf("deflate: err = %v, want errUnsupportedEncoding", err)
+	}
+	if _, err := decompressBody("gzip", payload, 1024); err == nil {
+		t.Error("expected error for a body that is not gzip")
+	}
+}
+
+func TestCollectCompressed(t *testing.T) {
+	payload := []byte(`[{"type":"click"},{"type":"scroll"}]`)
+	collect := func(env Env, encoding string, body []byte, header http.Header) (*httptest.ResponseRecorder, int) {
+		emitted := 0
+		env.Cfg.MaxBodyBytes = 1 << 20
+		if env.Cfg.MaxDecompressedBytes == 0 {
+			env.Cfg.MaxDecompressedBytes = 1 << 20
+		}
+		env.Emit = func(context.Context, event.Event) { emitted++ }
+		req := httptest.NewRequest(http.MethodPost, "/collect", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", encoding)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		env.Collect(w, req)
+		return w, emitted
+	}
+
+	t.Run("gzip and br", func(t *testing.T) {
+		for encoding, body := range map[string][]byte{"gzip": gzipBytes(t, payload), "br": brotliBytes(t, payload)} {
+			if w, emitted := collect(Env{}, encoding, body, nil); w.Code != http.StatusAccepted || emitted != 2 {
+				t.Errorf("%s: status = %d, emitted = %d", encoding, w.Code, emitted)
+			}
+		}
+	})
+
+	t.Run("HMAC covers the decompressed payload", func(t *testing.T) {
+		env := Env{HMACAuth: NewHMACAuth("secret", "")}
+		sig := env.HMACAuth.generateHMAC(payload, "192.0.2.1")
+		w, emitted := collect(env, "gzip", gzipBytes(t, payload), http.Header{"X-Gotrack-Hmac": {sig}})
+		if w.Code != http.StatusAccepted || emitted != 2 {
+			t.Errorf("status = %d, emitted = %d", w.Code, emitted)
+		}
+	})
+
+	t.Run("decompressed size limit", func(t *testing.T) {
+		big := []byte(`{"type":"click","url":{"referrer":"` + strings.Repeat("a", 4096) + `"}}`)
+		env := Env{Cfg: config.Config{MaxDecompressedBytes: 1024}}
+		w, emitted := collect(env, "br", brotliBytes(t, big), nil)
+		if w.Code != http.StatusRequestEntityTooLarge || emitted != 0 {
+			t.Errorf("status = %d, emitted = %d, want 413", w.Code, emitted)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if w, _ := collect(Env{}, "deflate", payload, nil); w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("deflate: status = %d, want 415", w.Code)
+		}
+		if w, _ := collect(Env{}, "gzip", payload, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("corrupt gzip: status = %d, want 400", w.Code)
+		}
+	})
+}
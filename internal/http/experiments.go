@@ -0,0 +1,135 @@
+package httpx
+
+import (
+	"maps"
+	"net/http"
+
+	"github.com/shortontech/gotrack/internal/experiment"
+	"github.com/shortontech/gotrack/internal/session"
+	event "github.com/shortontech/gotrack/pkg/event"
+)
+
+// experimentsPath answers a visitor's experiment variants
+const experimentsPath = "/experiments"
+
+// Experiments assigns visitors to A/B experiment variants and keeps their
+// first-touch assignments in the experiment cookie
+type Experiments struct {
+	assigner *experiment.Assigner
+	cookie   session.Config // CookieDomain, SameSite, Secure and VisitorTTL apply
+}
+
+// NewExperiments creates the experiment assignment for EXPERIMENTS_FILE. The
+// cookie shares the session cookies' attributes and the visitor cookie's
+// lifetime.
+func NewExperiments(assigner *experiment.Assigner, cookie session.Config) *Experiments {
+	if cookie.SameSite == http.SameSiteNoneMode {
+		cookie.Secure = true // browsers reject SameSite=None without Secure
+	}
+	return &Experiments{assigner: assigner, cookie: cookie}
+}
+
+// Update replaces the experiments. Invalid ones leave them unchanged.
+func (x *Experiments) Update(defs []experiment.Experiment) error {
+	return x.assigner.Update(defs)
+}
+
+// experimentsResponse is the answer to /experiments
+type experimentsResponse struct {
+	VisitorID   string            `json:"visitor_id,omitempty"`
+	Experiments map[string]string `json:"experiments"` // experiment to variant; empty when the visitor can't be identified
+}
+
+// ExperimentAssignments returns the visitor's variants, assigning them on the
+// first touch, so a page or backend can render the variant. The visitor is
+// the visitor_id query parameter or, with SESSION_COOKIES, the visitor
+// cookie, which is issued to new visitors. Clients that opted out of tracking get
+// their variants without cookies.
+func (e Env) ExperimentAssignments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.Experiments == nil {
+		http.Error(w, "experiments not enabled", http.StatusNotFound)
+		return
+	}
+	if !e.allowRequest(w, r) {
+		return
+	}
+	_, action := e.dntAction(r)
+	cookies := w
+	if action != "" {
+		cookies = nil
+	}
+	visitorID := r.URL.Query().Get("visitor_id")
+	if visitorID == "" && e.Sessions != nil && cookies != nil {
+		visitorID = e.Sessions.Visitor(cookies, r)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, experimentsResponse{VisitorID: visitorID, Experiments: e.assignExperiments(cookies, r, visitorID)})
+}
+
+// applyExperiments stamps events with their visitor's experiment variants,
+// replacing what the client sent for configured experiments. With a
+// ResponseWriter, as on /px.gif and /collect, first-touch assignments are
+// stored in the experiment cookie. Without a request, as for Measurement
+// Protocol events, variants follow from the visitor ID alone. Clients that
+// opted out of tracking or didn't consent to measurement are not assigned.
+func (e Env) applyExperiments(w http.ResponseWriter, r *http.Request, events ...*event.Event) {
+	if e.Experiments == nil || len(events) == 0 || e.consentDenied(events...) {
+		return
+	}
+	if r != nil {
+		if _, action := e.dntAction(r); action != "" {
+			return
+		}
+	}
+	byVisitor := map[string]map[string]string{} // so a batch sets the cookie once
+	for _, ev := range events {
+		assignments, ok := byVisitor[ev.Session.VisitorID]
+		if !ok {
+			assignments = e.assignExperiments(w, r, ev.Session.VisitorID)
+			byVisitor[ev.Session.VisitorID] = assignments
+		}
+		if len(assignments) == 0 {
+			continue
+		}
+		merged := make(map[string]string, len(ev.Experiments)+len(assignments))
+		maps.Copy(merged, ev.Experiments)
+		maps.Copy(merged, assignments)
+		ev.Experiments = merged
+	}
+}
+
+// assignExperiments returns the variants of visitorID, keeping those in the
+// request's experiment cookie, and with a ResponseWriter stores new
+// assignments in the cookie. r may be nil.
+func (e Env) assignExperiments(w http.ResponseWriter, r *http.Request, visitorID string) map[string]string {
+	var prior map[string]string
+	if r != nil {
+		if c, err := r.Cookie(experiment.Cookie); err == nil {
+			prior = experiment.Decode(c.Value)
+		}
+	}
+	assignments, assigned := e.Experiments.assigner.Assign(visitorID, prior)
+	if w == nil || len(assigned) == 0 {
+		return assignments
+	}
+	cookie := e.Experiments.cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     experiment.Cookie,
+		Value:    experiment.Encode(assignments),
+		Path:     "/",
+		Domain:   cookie.CookieDomain,
+		MaxAge:   int(cookie.VisitorTTL.Seconds()),
+		Secure:   cookie.Secure,
+		SameSite: cookie.SameSite,
+	})
+	if e.Metrics != nil {
+		for _, name := range assigned {
+			e.Metrics.IncrementExperimentAssignment(name, assignments[name])
+		}
+	}
+	return assignments
+}
@@ -0,0 +1,107 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/experiment"
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/internal/session"
+	config "github.com/shortontech/gotrack/pkg/config"
+	event "github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestExperiments(t *testing.T) {
+	defs := []experiment.Experiment{{Name: "checkout_button", Variants: []experiment.Variant{{Name: "control"}, {Name: "green"}}}}
+	assigner, err := experiment.New(defs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var emitted []event.Event
+	env := Env{
+		Cfg:         config.Config{MaxBodyBytes: 1 << 20, ExperimentsFile: "experiments.json", DNTRespect: true, DNTAction: DNTActionStrip},
+		Emit:        func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) },
+		Sessions:    session.NewManager(session.Config{}, kv.NewMemoryStore()),
+		Experiments: NewExperiments(assigner, session.Config{}),
+	}
+	handler := NewMux(env)
+	get := func(target string, cookies []*http.Cookie) (*httptest.ResponseRecorder, experimentsResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var resp experimentsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s = %d %s", target, w.Code, w.Body)
+		}
+		return w, resp
+	}
+
+	// First touch issues the visitor and assigns it
+	w, first := get(experimentsPath, nil)
+	cookies := w.Result().Cookies()
+	variant := first.Experiments["checkout_button"]
+	if first.VisitorID == "" || variant == "" || len(cookies) != 2 {
+		t.Fatalf("response = %+v, cookies = %v", first, cookies)
+	}
+	want, _ := assigner.Assign(first.VisitorID, nil)
+	if variant != want["checkout_button"] {
+		t.Errorf("variant = %s, want the hashed %s", variant, want["checkout_button"])
+	}
+
+	// Events carry the assignment, replacing a client value for a configured
+	// experiment and keeping the client's own experiments
+	req := httptest.NewRequest(http.MethodPost, "/collect", strings.NewReader(`[{"type":"click","experiments":{"checkout_button":"spoofed","hero":"b"}},{"type":"scroll"}]`))
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	cw := httptest.NewRecorder()
+	env.Collect(cw, req)
+	if len(emitted) != 2 || emitted[0].Experiments["checkout_button"] != variant || emitted[0].Experiments["hero"] != "b" || emitted[1].Experiments["checkout_button"] != variant {
+		t.Fatalf("emitted experiments = %v", emitted)
+	}
+	for _, c := range cw.Result().Cookies() {
+		if c.Name == experiment.Cookie {
+			t.Error("known assignments stored again")
+		}
+	}
+
+	// A weight change doesn't move an assigned visitor
+	other := map[string]string{"control": "green", "green": "control"}[variant]
+	if err := env.Experiments.Update([]experiment.Experiment{{Name: "checkout_button", Variants: []experiment.Variant{{Name: "control", Weight: 1}, {Name: "green", Weight: 1}, {Name: other, Weight: 0}}}}); err == nil {
+		t.Fatal("duplicate variant accepted")
+	}
+	weights := map[string]int{variant: 1, other: 1000}
+	if err := env.Experiments.Update([]experiment.Experiment{{Name: "checkout_button", Variants: []experiment.Variant{{Name: "control", Weight: weights["control"]}, {Name: "green", Weight: weights["green"]}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, again := get(experimentsPath, cookies); again.Experiments["checkout_button"] != variant || again.VisitorID != first.VisitorID {
+		t.Errorf("after a weight change = %+v, want %s for %s", again, variant, first.VisitorID)
+	}
+
+	// Backends name the visitor; opted-out clients get no cookies
+	if _, resp := get(experimentsPath+"?visitor_id=user-42", nil); resp.VisitorID != "user-42" || resp.Experiments["checkout_button"] == "" {
+		t.Errorf("with visitor_id = %+v", resp)
+	}
+	req = httptest.NewRequest(http.MethodGet, experimentsPath+"?visitor_id=user-42", nil)
+	req.Header.Set("Sec-GPC", "1")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("opted-out client got cookies %v", w.Result().Cookies())
+	}
+
+	env.Experiments = nil
+	w = httptest.NewRecorder()
+	NewMux(env).ServeHTTP(w, httptest.NewRequest(http.MethodGet, experimentsPath, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("without experiments = %d", w.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package httpx
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// originHeader carries the PROXY_ORIGIN_SECRET signature so the origin can
+// tell requests relayed by GoTrack from ones that reached it directly
+const originHeader = "X-GoTrack-Proxy"
+
+// untrustedForwardHeaders are client-supplied proxy headers dropped unless
+// TRUST_PROXY says an upstream proxy set them
+var untrustedForwardHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-IP",
+}
+
+// setForwardedHeaders describes the client connection to the origin. With
+// trustProxy the chain built by proxies in front of GoTrack is extended;
+// otherwise whatever the client sent is replaced.
+func setForwardedHeaders(h http.Header, r *http.Request, trustProxy bool) {
+	if !trustProxy {
+		for _, name := range untrustedForwardHeaders {
+			h.Del(name)
+		}
+	}
+
+	clientIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	if prior := strings.Join(h.Values("X-Forwarded-For"), ", "); prior != "" {
+		clientIP = prior + ", " + clientIP
+	}
+	h.Set("X-Forwarded-For", clientIP)
+
+	if h.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
+		h.Set("X-Forwarded-Proto", proto)
+	}
+	if h.Get("X-Forwarded-Host") == "" {
+		h.Set("X-Forwarded-Host", r.Host)
+	}
+}
+
+// signOrigin returns the X-GoTrack-Proxy value for a request:
+// "t=<unix seconds>,sig=<hex HMAC-SHA256 of "t\nMETHOD\nrequest URI">".
+// The origin recomputes the signature with the shared secret and rejects
+// stale timestamps.
+func signOrigin(secret []byte, method, requestURI string, now time.Time) string {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "\n" + method + "\n" + requestURI))
+	return "t=" + ts + ",sig=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,144 @@
+package httpx
+
+import (
+	"crypto/hmac"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyOrigin checks an X-GoTrack-Proxy value the way an origin would,
+// accepting signatures up to maxAge old
+func verifyOrigin(secret []byte, value, method, requestURI string, now time.Time, maxAge time.Duration) bool {
+	tsPart, sigPart, ok := strings.Cut(value, ",")
+	ts, ok1 := strings.CutPrefix(tsPart, "t=")
+	sig, ok2 := strings.CutPrefix(sigPart, "sig=")
+	if !ok || !ok1 || !ok2 {
+		return false
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > maxAge || age < -maxAge {
+		return false
+	}
+	expected := signOrigin(secret, method, requestURI, time.Unix(unix, 0))
+	return hmac.Equal([]byte(expected), []byte("t="+ts+",sig="+sig))
+}
+
+// TestSetForwardedHeaders tests the X-Forwarded-* chain sent to the origin
+func TestSetForwardedHeaders(t *testing.T) {
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://shop.example.com/cart", nil)
+		r.RemoteAddr = "203.0.113.7:51234"
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-Host", "cdn.example.com")
+		r.Header.Set("X-Real-IP", "198.51.100.1")
+		r.Header.Set("Forwarded", "for=198.51.100.1")
+		return r
+	}
+
+	t.Run("replaces client-supplied values", func(t *testing.T) {
+		r := newRequest()
+		h := r.Header.Clone()
+		setForwardedHeaders(h, r, false)
+		want := map[string]string{
+			"X-Forwarded-For":   "203.0.113.7",
+			"X-Forwarded-Proto": "http",
+			"X-Forwarded-Host":  "shop.example.com",
+			"X-Real-IP":         "",
+			"Forwarded":         "",
+		}
+		for name, value := range want {
+			if got := h.Get(name); got != value {
+				t.Errorf("%s = %q, want %q", name, got, value)
+			}
+		}
+	})
+
+	t.Run("extends a trusted chain", func(t *testing.T) {
+		r := newRequest()
+		r.Header.Add("X-Forwarded-For", "192.0.2.9")
+		h := r.Header.Clone()
+		setForwardedHeaders(h, r, true)
+		if got := h.Get("X-Forwarded-For"); got != "198.51.100.1, 192.0.2.9, 203.0.113.7" {
+			t.Errorf("X-Forwarded-For = %q", got)
+		}
+		if h.Get("X-Forwarded-Proto") != "https" || h.Get("X-Forwarded-Host") != "cdn.example.com" {
+			t.Errorf("trusted proto/host replaced: %v", h)
+		}
+	})
+
+	t.Run("reports TLS", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "https://shop.example.com/", nil)
+		r.TLS = &tls.ConnectionState{}
+		h := http.Header{}
+		setForwardedHeaders(h, r, false)
+		if h.Get("X-Forwarded-Proto") != "https" {
+			t.Errorf("X-Forwarded-Proto = %q, want https", h.Get("X-Forwarded-Proto"))
+		}
+	})
+}
+
+// TestSignOrigin tests the X-GoTrack-Proxy signature
+func TestSignOrigin(t *testing.T) {
+	secret := []byte("origin-secret")
+	now := time.Unix(1767225600, 0)
+	value := signOrigin(secret, http.MethodGet, "/cart?x=1", now)
+
+	if !strings.HasPrefix(value, "t="+strconv.FormatInt(now.Unix(), 10)+",sig=") {
+		t.Fatalf("signOrigin() = %q", value)
+	}
+	if !verifyOrigin(secret, value, http.MethodGet, "/cart?x=1", now.Add(time.Minute), 5*time.Minute) {
+		t.Error("valid signature rejected")
+	}
+	if verifyOrigin(secret, value, http.MethodGet, "/admin", now, 5*time.Minute) {
+		t.Error("signature accepted for another path")
+	}
+	if verifyOrigin([]byte("other"), value, http.MethodGet, "/cart?x=1", now, 5*time.Minute) {
+		t.Error("signature accepted with another secret")
+	}
+	if verifyOrigin(secret, value, http.MethodGet, "/cart?x=1", now.Add(time.Hour), 5*time.Minute) {
+		t.Error("stale signature accepted")
+	}
+}
+
+// TestProxyHandlerForwarding tests the headers the origin receives through the proxy
+func TestProxyHandlerForwarding(t *testing.T) {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	handler := NewProxyHandler(backend.URL, nil)
+	handler.originSecret = []byte("origin-secret")
+
+	req := httptest.NewRequest(http.MethodGet, "http://shop.example.com/cart", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	req.Header.Set(originHeader, "t=1,sig=forged")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if received.Get("X-Forwarded-For") != "203.0.113.7" || received.Get("X-Forwarded-Host") != "shop.example.com" {
+		t.Errorf("forwarded headers = %v", received)
+	}
+	if !verifyOrigin([]byte("origin-secret"), received.Get(originHeader), http.MethodGet, "/cart", time.Now(), time.Minute) {
+		t.Errorf("origin signature %q does not verify", received.Get(originHeader))
+	}
+
+	// Without a secret a client-sent signature must not reach the origin
+	handler.originSecret = nil
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if received.Get(originHeader) != "" {
+		t.Errorf("forged %s forwarded", originHeader)
+	}
+}
@@ -1,16 +1,29 @@
 package httpx
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
-	"strings"
+	"sync"
+	"time"
 
+	"github.com/shortontech/gotrack/internal/analytics"
 	"github.com/shortontech/gotrack/internal/assets"
-	event "github.com/shortontech/gotrack/internal/event"
+	"github.com/shortontech/gotrack/internal/consent"
+	"github.com/shortontech/gotrack/internal/dedup"
+	"github.com/shortontech/gotrack/internal/logging"
 	"github.com/shortontech/gotrack/internal/metrics"
+	"github.com/shortontech/gotrack/internal/proxycache"
+	"github.com/shortontech/gotrack/internal/relay"
+	"github.com/shortontech/gotrack/internal/session"
+	"github.com/shortontech/gotrack/internal/shortlink"
+	"github.com/shortontech/gotrack/internal/sink"
+	"github.com/shortontech/gotrack/internal/validation"
 	cfg "github.com/shortontech/gotrack/pkg/config"
+	event "github.com/shortontech/gotrack/pkg/event"
 )
 
 var pixelGIF = []byte{
@@ -20,10 +33,38 @@ var pixelGIF = []byte{
 }
 
 type Env struct {
-	Cfg      cfg.Config        // <-- use cfg.Config here
-	Emit     func(event.Event) // injected sink fan-out
-	HMACAuth *HMACAuth         // HMAC authentication handler
-	Metrics  *metrics.Metrics  // metrics collection
+	Cfg      cfg.Config                         // <-- use cfg.Config here
+	APIKeys  *APIKeys                           // bearer keys for server-to-server /collect; nil disables them
+	Emit     func(context.Context, event.Event) // injected sink fan-out; ctx carries the request span
+	HMACAuth *HMACAuth                          // HMAC authentication handler
+	Metrics  *metrics.Metrics                   // metrics collection
+	Relay    *relay.Assembler                   // reassembles batches from edge instances
+	Routes   RouteSet                           // endpoints served on this listener; zero serves all
+
+	Bots       *BotPolicy                // responses to likely bots per endpoint; nil when BOT_RESPONSE is unset
+	Cluster    *Cluster                  // this replica's identity for /cluster/info; nil when CLUSTER_MODE is off
+	Clusters   *analytics.ClusterTracker // device clustering report (admin API)
+	Realtime   *analytics.Realtime       // live traffic counts for /stats/realtime; nil without ADMIN_TOKEN
+	Drainer    *Drainer                  // graceful drain before shutdown; nil disables the admin endpoint
+	Inspector  *Inspector                // recent events and errors for the admin dashboard; nil without ADMIN_TOKEN
+	Tenants    *Tenants                  // write key to site mapping; nil in single-tenant mode
+	Injector   *Injector                 // proxied page instrumentation; nil injects the built-in snippet everywhere
+	Limiter    *RateLimiter              // per-client ingestion rate limit
+	Orders     dedup.Detector            // order IDs already converted on /conversion; nil disables order dedup
+	ProxyCache *proxycache.Cache         // proxied response cache; nil when PROXY_CACHE is unset
+	Reload     func() error              // re-applies runtime configuration (admin API)
+	Replay     *ReplayGuard              // rejects stale and reused HMAC signatures; nil when HMAC_REPLAY_WINDOW=0
+	Search     EventSearcher             // stored event lookup (admin API); nil without a queryable sink
+	Query      sink.Querier              // recent event listing (admin API); nil without a queryable sink
+	Sessions   *session.Manager          // server-issued visitor/session cookies; nil when disabled
+	ShortLinks *shortlink.Store          // /s/<code> links; nil when SHORT_LINKS is off
+	ClickIDs   *session.ClickCookies     // first-party click ID cookies; nil when disabled
+	Consent    *consent.Policy           // TCF consent enforcement; nil when TCF_ACTION=off
+	Sinks      []sink.Sink               // configured sinks, checked by /readyz
+	SinkSet    *sink.Set                 // sinks events fan out to, managed through the admin API; nil disables it
+	Validator  *validation.Validator     // /collect event checks; nil accepts events as sent
+
+	Experiments *Experiments // A/B variant assignment; nil when EXPERIMENTS_FILE is unset
 }
 
 func (e Env) Healthz(w http.ResponseWriter, r *http.Request) {
@@ -60,13 +101,69 @@ func (e Env) ServePixelJS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.WriteHeader(http.StatusOK)
+	// Scripts loaded by tag send to the aliased /collect as well
+	_, _ = io.WriteString(w, endpointScript(e.Cfg.TrackingPathPrefix))
 	_, _ = w.Write(content)
 }
 
+// readyTimeout bounds the sink checks behind /readyz
+const readyTimeout = 2 * time.Second
+
+// Readyz checks every sink that implements sink.HealthChecker, concurrently,
+// and answers 503 if any of them fails or the instance is draining. Failures
+// are logged with their cause; the response only marks the sink unavailable,
+// since /readyz is public.
 func (e Env) Readyz(w http.ResponseWriter, r *http.Request) {
-	// TODO: verify sink connectivity (Kafka/PG) before returning 200
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("ready"))
+	if e.Drainer.Draining() {
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusServiceUnavailable, readyResponse{Status: "draining"})
+		return
+	}
+
+	errs := pingSinks(r.Context(), e.Sinks)
+	status, code := "ready", http.StatusOK
+	sinks := make(map[string]string, len(e.Sinks))
+	for i, s := range e.Sinks {
+		if errs[i] != nil {
+			log.Printf("readyz: %s sink unavailable: %v", s.Name(), errs[i])
+			sinks[s.Name()] = "unavailable"
+			status, code = "degraded", http.StatusServiceUnavailable
+			continue
+		}
+		sinks[s.Name()] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(readyResponse{Status: status, Sinks: sinks})
+}
+
+// readyResponse is the answer to /readyz
+type readyResponse struct {
+	Status string            `json:"status"`          // ready, degraded or draining
+	Sinks  map[string]string `json:"sinks,omitempty"` // sink name to ok or unavailable
+}
+
+// pingSinks checks the sinks that implement sink.HealthChecker in parallel,
+// within readyTimeout. The errors line up with sinks.
+func pingSinks(ctx context.Context, sinks []sink.Sink) []error {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	errs := make([]error, len(sinks))
+	var wg sync.WaitGroup
+	for i, s := range sinks {
+		if hc, ok := s.(sink.HealthChecker); ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = hc.Ping(ctx)
+			}()
+		}
+	}
+	wg.Wait()
+	return errs
 }
 
 func (e Env) HMACScript(w http.ResponseWriter, r *http.Request) {
@@ -75,13 +172,15 @@ func (e Env) HMACScript(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if e.HMACAuth == nil {
+	auth := e.hmacAuth(r)
+	if auth == nil {
 		http.Error(w, "HMAC authentication not configured", http.StatusNotFound)
 		return
 	}
 
-	// Generate client-specific key for this IP using the request
-	script := e.HMACAuth.GenerateClientScriptForRequest(r)
+	// Generate client-specific key for this IP; forwarding headers only
+	// count from trusted proxies, so clients can't pick whose key they get
+	script := auth.GenerateClientScriptForIP(event.ClientIP(r, e.Cfg))
 	if script == "" {
 		http.Error(w, "HMAC client script not available", http.StatusNotFound)
 		return
@@ -99,12 +198,13 @@ func (e Env) HMACPublicKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if e.HMACAuth == nil {
+	auth := e.hmacAuth(r)
+	if auth == nil {
 		http.Error(w, "HMAC authentication not configured", http.StatusNotFound)
 		return
 	}
 
-	publicKey := e.HMACAuth.GetPublicKeyBase64()
+	publicKey := auth.GetPublicKeyBase64()
 	if publicKey == "" {
 		http.Error(w, "HMAC public key not available", http.StatusNotFound)
 		return
@@ -113,29 +213,61 @@ func (e Env) HMACPublicKey(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "public, max-age=3600") // Cache for 1 hour
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(map[string]string{
-		"public_key": publicKey,
-		"algorithm":  "HMAC-SHA256",
-		"header":     "X-GoTrack-HMAC",
+	_ = json.NewEncoder(w).Encode(hmacKeyResponse{
+		PublicKey:       publicKey,
+		Algorithm:       "HMAC-SHA256",
+		Header:          hmacHeader,
+		TimestampHeader: timestampHeader,
+		NonceHeader:     nonceHeader,
 	})
 }
 
+// hmacKeyResponse is the answer to /hmac/public-key
+type hmacKeyResponse struct {
+	PublicKey       string `json:"public_key"` // base64
+	Algorithm       string `json:"algorithm"`
+	Header          string `json:"header"`
+	TimestampHeader string `json:"timestamp_header"`
+	NonceHeader     string `json:"nonce_header"`
+}
+
 func (e Env) Pixel(w http.ResponseWriter, r *http.Request) {
-	log.Printf("DEBUG: Pixel handler called")
+	logging.Debugf("Pixel handler called")
 	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	evt := event.Event{Type: "pageview"}
-	// We only set URL/query-derived attrs server-side; client device info comes from a post request.
-	event.EnrichServerFields(r, &evt, e.Cfg)
-	log.Printf("DEBUG: Event created, event_id=%s, type=%s", evt.EventID, evt.Type)
-	if e.Emit != nil {
-		log.Printf("DEBUG: Calling Emit function")
-		e.Emit(evt)
-		log.Printf("DEBUG: Emit returned")
+	if !e.allowRequest(w, r) {
+		return
+	}
+	r, ok := e.resolveTenant(w, r)
+	if !ok {
+		return
+	}
+	// Device details come from POST /collect; the pixel carries what its
+	// query string and request headers say
+	events, _ := e.validate([]event.Event{pixelEvent(r.URL.Query())})
+	if len(events) == 0 {
+		writePixel(w, r.Method == http.MethodHead)
+		return
+	}
+	evt := events[0]
+	e.enrich(r, &evt)
+	e.applySessions(w, r, &evt)
+	e.applyClickCookies(w, r, &evt)
+	e.applyExperiments(w, r, &evt)
+	logging.Debugf("Event created, event_id=%s, type=%s", evt.EventID, evt.Type)
+	if !e.honorOptOut(r, &evt) {
+		logging.Debugf("Event dropped: client opted out of tracking")
+	} else if e.Emit != nil {
+		logging.Debugf("Calling Emit function")
+		e.Emit(r.Context(), evt)
+		logging.Debugf("Emit returned")
 	} else {
-		log.Printf("DEBUG: ERROR - Emit is nil!")
+		logging.Debugf("ERROR - Emit is nil!")
+	}
+	if e.Cfg.ClientHints {
+		requestClientHints(w.Header())
 	}
 	writePixel(w, r.Method == http.MethodHead)
 }
@@ -159,18 +291,25 @@ func (e Env) Collect(w http.ResponseWriter, r *http.Request) {
 	if !e.validateCollectRequest(w, r) {
 		return
 	}
+	if !e.allowRequest(w, r) {
+		return
+	}
+	r, ok := e.resolveCredentials(w, r)
+	if !ok {
+		return
+	}
 
 	body, ok := e.readAndVerifyBody(w, r)
 	if !ok {
 		return
 	}
 
-	accepted, ok := e.processEvents(w, r, body)
+	accepted, results, ok := e.processEvents(w, r, body)
 	if !ok {
 		return
 	}
 
-	e.sendCollectResponse(w, accepted)
+	e.sendCollectResponse(w, accepted, results)
 }
 
 func (e Env) validateCollectRequest(w http.ResponseWriter, r *http.Request) bool {
@@ -178,14 +317,34 @@ func (e Env) validateCollectRequest(w http.ResponseWriter, r *http.Request) bool
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return false
 	}
-	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "application/json") {
-		http.Error(w, "content-type must be application/json", http.StatusUnsupportedMediaType)
+	if !collectMediaType(r.Header.Get("Content-Type")) {
+		http.Error(w, "content-type must be application/json, text/plain or application/x-www-form-urlencoded", http.StatusUnsupportedMediaType)
 		return false
 	}
 	return true
 }
 
 func (e Env) readAndVerifyBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, ok := e.readBody(w, r)
+	if !ok {
+		return nil, false
+	}
+	body, err := unwrapBeacon(r, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Verify HMAC over the decompressed payload
+	if !e.verifyHMAC(w, r, body) {
+		return nil, false
+	}
+
+	return body, true
+}
+
+// readBody reads and decompresses a request body
+func (e Env) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
 	defer r.Body.Close()
 
 	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, e.Cfg.MaxBodyBytes))
@@ -194,20 +353,51 @@ func (e Env) readAndVerifyBody(w http.ResponseWriter, r *http.Request) ([]byte,
 		return nil, false
 	}
 
-	// Verify HMAC if authentication is enabled
-	if e.HMACAuth != nil && !e.HMACAuth.VerifyHMAC(r, body) {
-		http.Error(w, "invalid or missing HMAC signature", http.StatusUnauthorized)
+	// MAX_BODY_BYTES bounds the bytes on the wire, MAX_DECOMPRESSED_BYTES what they expand to
+	received := len(body)
+	body, err = decompressBody(r.Header.Get("Content-Encoding"), body, e.Cfg.MaxDecompressedBytes)
+	switch {
+	case errors.Is(err, errUnsupportedEncoding):
+		http.Error(w, "content-encoding must be gzip or br", http.StatusUnsupportedMediaType)
+		return nil, false
+	case errors.Is(err, errDecompressedTooLarge):
+		http.Error(w, "decompressed body too large", http.StatusRequestEntityTooLarge)
+		return nil, false
+	case err != nil:
+		http.Error(w, "invalid compressed body", http.StatusBadRequest)
 		return nil, false
 	}
-
+	if e.Metrics != nil {
+		e.Metrics.ObserveRequestBody(r.URL.Path, received, len(body))
+	}
 	return body, true
 }
 
-func (e Env) processEvents(w http.ResponseWriter, r *http.Request, body []byte) (int, bool) {
+// verifyHMAC checks the payload signature if authentication is enabled, with
+// the tenant's secret if it has one. Requests with an API key need none.
+func (e Env) verifyHMAC(w http.ResponseWriter, r *http.Request, payload []byte) bool {
+	auth := e.hmacAuth(r)
+	if auth == nil || apiKeyFrom(r.Context()) != nil {
+		return true
+	}
+	if !auth.VerifyHMAC(r, event.ClientIP(r, e.Cfg), payload) {
+		http.Error(w, "invalid or missing HMAC signature", http.StatusUnauthorized)
+		return false
+	}
+	// Only a valid signature vouches for the nonce, so check it afterwards
+	if err := e.Replay.Check(r.Context(), r); err != nil {
+		log.Printf("HMAC replay check failed: %v", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (e Env) processEvents(w http.ResponseWriter, r *http.Request, body []byte) (int, []validation.Result, bool) {
 	var raw json.RawMessage
 	if err := json.Unmarshal(body, &raw); err != nil {
 		http.Error(w, "invalid json", http.StatusBadRequest)
-		return 0, false
+		return 0, nil, false
 	}
 
 	if len(raw) > 0 && raw[0] == '[' {
@@ -216,46 +406,209 @@ func (e Env) processEvents(w http.ResponseWriter, r *http.Request, body []byte)
 	return e.processSingleEvent(w, r, raw)
 }
 
-func (e Env) processEventArray(w http.ResponseWriter, r *http.Request, raw json.RawMessage) (int, bool) {
+func (e Env) processEventArray(w http.ResponseWriter, r *http.Request, raw json.RawMessage) (int, []validation.Result, bool) {
 	var arr []event.Event
 	if err := json.Unmarshal(raw, &arr); err != nil {
 		http.Error(w, "invalid json array", http.StatusBadRequest)
-		return 0, false
+		return 0, nil, false
+	}
+	if e.Metrics != nil {
+		e.Metrics.ObserveCollectBatch(len(arr))
+	}
+	if !checkScope(w, r, arr) {
+		return 0, nil, false
 	}
+	arr, results := e.validate(arr)
+	events := make([]*event.Event, len(arr))
 	for i := range arr {
-		event.EnrichServerFields(r, &arr[i], e.Cfg)
+		events[i] = &arr[i]
+	}
+	e.enrich(r, events...)
+	e.applySessions(w, r, events...)
+	e.applyClickCookies(nil, r, events...)
+	e.applyExperiments(w, r, events...)
+	for i := range arr {
+		if !e.honorOptOut(r, &arr[i]) {
+			continue
+		}
 		if e.Emit != nil {
-			e.Emit(arr[i])
+			e.Emit(r.Context(), arr[i])
 		}
 	}
-	return len(arr), true
+	return len(arr), results, true
 }
 
-func (e Env) processSingleEvent(w http.ResponseWriter, r *http.Request, raw json.RawMessage) (int, bool) {
+func (e Env) processSingleEvent(w http.ResponseWriter, r *http.Request, raw json.RawMessage) (int, []validation.Result, bool) {
 	var ev event.Event
 	if err := json.Unmarshal(raw, &ev); err != nil {
 		http.Error(w, "invalid json object", http.StatusBadRequest)
-		return 0, false
+		return 0, nil, false
+	}
+	if e.Metrics != nil {
+		e.Metrics.ObserveCollectBatch(1)
+	}
+	if !checkScope(w, r, []event.Event{ev}) {
+		return 0, nil, false
+	}
+	kept, results := e.validate([]event.Event{ev})
+	if len(kept) == 0 {
+		return 0, results, true
+	}
+	ev = kept[0]
+	e.enrich(r, &ev)
+	e.applySessions(w, r, &ev)
+	e.applyClickCookies(nil, r, &ev)
+	e.applyExperiments(w, r, &ev)
+
+	logging.Debugf("Processing event type=%s, event_id=%s", ev.Type, ev.EventID)
+	if !e.honorOptOut(r, &ev) {
+		// Still report the event as accepted so clients don't retry
+		logging.Debugf("Event dropped: client opted out of tracking")
+		return 1, results, true
 	}
-	event.EnrichServerFields(r, &ev, e.Cfg)
-
-	// DEBUG: Log that we're about to emit
-	log.Printf("DEBUG: Processing event type=%s, event_id=%s", ev.Type, ev.EventID)
 
 	if e.Emit != nil {
-		e.Emit(ev)
-		log.Printf("DEBUG: Event emitted successfully")
+		e.Emit(r.Context(), ev)
+		logging.Debugf("Event emitted successfully")
 	} else {
-		log.Printf("DEBUG: ERROR - Emit function is nil!")
+		logging.Debugf("ERROR - Emit function is nil!")
+	}
+	return 1, results, true
+}
+
+// validate checks events as the client sent them and drops the rejected
+// ones. Results are nil when validation is disabled.
+func (e Env) validate(events []event.Event) ([]event.Event, []validation.Result) {
+	if e.Validator == nil {
+		return events, nil
+	}
+	results := make([]validation.Result, len(events))
+	kept := events[:0]
+	for i := range events {
+		results[i] = e.checkEvent(i, &events[i])
+		if results[i].Rejected() {
+			logging.Debugf("Event %d rejected: %v", i, results[i].Issues)
+			continue
+		}
+		kept = append(kept, events[i])
+	}
+	return kept, results
+}
+
+// applySessions stitches events into the server-side session and refreshes
+// the session cookies. Clients that opted out of tracking or didn't consent
+// to measurement get no cookies.
+func (e Env) applySessions(w http.ResponseWriter, r *http.Request, events ...*event.Event) {
+	if e.Sessions == nil || len(events) == 0 {
+		return
+	}
+	if _, action := e.dntAction(r); action != "" || e.consentDenied(events...) {
+		return
+	}
+	info := e.Sessions.Resolve(r.Context(), w, r, len(events))
+	for i, ev := range events {
+		session.Apply(ev, info, info.SessionSeq+i)
 	}
-	return 1, true
 }
 
-func (e Env) sendCollectResponse(w http.ResponseWriter, accepted int) {
+// applyClickCookies fills the events' click IDs from the click ID cookies.
+// With a ResponseWriter, as on /px.gif, it also stores new clicks in them.
+func (e Env) applyClickCookies(w http.ResponseWriter, r *http.Request, events ...*event.Event) {
+	if e.ClickIDs == nil {
+		return
+	}
+	if _, action := e.dntAction(r); action != "" || e.consentDenied(events...) {
+		return
+	}
+	for _, ev := range events {
+		if w != nil {
+			e.ClickIDs.Apply(w, r, ev)
+		} else {
+			e.ClickIDs.Fill(r, ev)
+		}
+	}
+}
+
+// recordLinkEvent emits ev unless the instance is draining, the client is
+// over its rate limit, the write key is unknown or validation rejects it.
+// Unlike the other ingestion endpoints it never answers with an error, as
+// the answer is an image or a redirect the visitor is waiting for.
+func (e Env) recordLinkEvent(w http.ResponseWriter, r *http.Request, ev event.Event) {
+	if e.Drainer.Draining() {
+		return
+	}
+	if e.Limiter != nil && !e.Limiter.Allow(rateLimitKey(r, e.Cfg)) {
+		return
+	}
+	if e.Tenants != nil {
+		tenant, ok := e.Tenants.Lookup(writeKey(r))
+		if !ok {
+			logging.Debugf("%s event dropped: unknown write key", ev.Type)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant))
+	}
+	events, _ := e.validate([]event.Event{ev})
+	if len(events) == 0 {
+		return
+	}
+	ev = events[0]
+	e.enrich(r, &ev)
+	e.applySessions(w, r, &ev)
+	e.applyExperiments(w, r, &ev)
+	if !e.honorOptOut(r, &ev) {
+		logging.Debugf("%s event dropped: client opted out of tracking", ev.Type)
+		return
+	}
+	if e.Emit != nil {
+		e.Emit(r.Context(), ev)
+	}
+}
+
+// checkEvent validates one event and counts its issues
+func (e Env) checkEvent(index int, ev *event.Event) validation.Result {
+	res := e.Validator.Check(index, ev)
+	if e.Metrics != nil {
+		for _, issue := range res.Issues {
+			e.Metrics.IncrementEventsInvalid(issue.Code, res.Status)
+		}
+	}
+	return res
+}
+
+// collectResponse is the answer to /collect. Rejected and Results are only
+// set with EVENT_VALIDATION on.
+type collectResponse struct {
+	Accepted int                 `json:"accepted"`
+	Status   string              `json:"status"` // ok, or rejected when every event was
+	Rejected *int                `json:"rejected,omitempty"`
+	Results  []validation.Result `json:"results,omitempty"`
+}
+
+// sendCollectResponse reports how many events were accepted and, with
+// validation enabled, the outcome for each. A request whose events were all
+// rejected gets 422 so clients don't mistake it for success.
+func (e Env) sendCollectResponse(w http.ResponseWriter, accepted int, results []validation.Result) {
+	resp := collectResponse{Accepted: accepted, Status: "ok"}
+	code := http.StatusAccepted
+	if results != nil {
+		rejected := 0
+		for _, res := range results {
+			if res.Rejected() {
+				rejected++
+			}
+		}
+		resp.Rejected = &rejected
+		resp.Results = results
+		if rejected > 0 && rejected == len(results) {
+			resp.Status = "rejected"
+			code = http.StatusUnprocessableEntity
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("X-Gotrack-Accepted", itoa(accepted))
-	w.WriteHeader(http.StatusAccepted)
-	_ = json.NewEncoder(w).Encode(map[string]any{"accepted": accepted, "status": "ok"})
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
 func itoa(i int) string { return fmtInt(i) }
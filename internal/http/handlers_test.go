@@ -2,6 +2,7 @@ package httpx
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,9 +10,17 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/shortontech/gotrack/internal/event"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/shortontech/gotrack/internal/kv"
 	"github.com/shortontech/gotrack/internal/metrics"
+	"github.com/shortontech/gotrack/internal/session"
+	"github.com/shortontech/gotrack/internal/validation"
 	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/sink"
 )
 
 // TestHealthz tests the health check endpoint
@@ -46,22 +55,60 @@ func TestHealthz(t *testing.T) {
 	})
 }
 
+// fakeSink is a sink whose health check returns pingErr
+type fakeSink struct {
+	name    string
+	pingErr error
+}
+
+func (f *fakeSink) Start(ctx context.Context) error { return nil }
+func (f *fakeSink) Enqueue(e event.Event) error     { return nil }
+func (f *fakeSink) Close() error                    { return nil }
+func (f *fakeSink) Name() string                    { return f.name }
+func (f *fakeSink) Ping(ctx context.Context) error  { return f.pingErr }
+
 // TestReadyz tests the readiness check endpoint
 func TestReadyz(t *testing.T) {
-	t.Run("returns 200 ready", func(t *testing.T) {
-		env := Env{}
-		req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	readyz := func(env Env) (int, map[string]any) {
 		w := httptest.NewRecorder()
+		env.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body map[string]any
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("invalid JSON body: %v", err)
+		}
+		return w.Code, body
+	}
 
-		env.Readyz(w, req)
+	t.Run("returns 200 ready without sinks", func(t *testing.T) {
+		code, body := readyz(Env{})
+		if code != http.StatusOK || body["status"] != "ready" {
+			t.Errorf("got %d %v, want 200 ready", code, body)
+		}
+	})
 
-		if w.Code != http.StatusOK {
-			t.Errorf("status code = %d, want %d", w.Code, http.StatusOK)
+	t.Run("returns 200 when all sinks are healthy", func(t *testing.T) {
+		code, body := readyz(Env{Sinks: []sink.Sink{&fakeSink{name: "kafka"}, &fakeSink{name: "postgres"}}})
+		if code != http.StatusOK || body["status"] != "ready" {
+			t.Errorf("got %d %v, want 200 ready", code, body)
 		}
+		sinks := body["sinks"].(map[string]any)
+		if sinks["kafka"] != "ok" || sinks["postgres"] != "ok" {
+			t.Errorf("sinks = %v", sinks)
+		}
+	})
 
-		body := w.Body.String()
-		if body != "ready" {
-			t.Errorf("body = %q, want %q", body, "ready")
+	t.Run("returns 503 with breakdown when a sink fails", func(t *testing.T) {
+		env := Env{Sinks: []sink.Sink{
+			&fakeSink{name: "kafka"},
+			&fakeSink{name: "postgres", pingErr: fmt.Errorf("dial tcp 10.0.0.5:5432: connection refused")},
+		}}
+		code, body := readyz(env)
+		if code != http.StatusServiceUnavailable || body["status"] != "degraded" {
+			t.Errorf("got %d %v, want 503 degraded", code, body)
+		}
+		sinks := body["sinks"].(map[string]any)
+		if sinks["kafka"] != "ok" || sinks["postgres"] != "unavailable" {
+			t.Errorf("sinks = %v", sinks)
 		}
 	})
 }
@@ -108,6 +155,27 @@ func TestHMACScript(t *testing.T) {
 		}
 	})
 
+	t.Run("derives the key for the trusted client address", func(t *testing.T) {
+		auth := NewHMACAuth("test-secret", "")
+		script := func(cfg config.Config, remoteAddr string) string {
+			req := httptest.NewRequest(http.MethodGet, "/hmac.js", nil)
+			req.RemoteAddr = remoteAddr
+			req.Header.Set("X-Forwarded-For", "198.51.100.7")
+			w := httptest.NewRecorder()
+			Env{Cfg: cfg, HMACAuth: auth}.HMACScript(w, req)
+			return w.Body.String()
+		}
+
+		// A forwarded address from an untrusted peer is ignored
+		if got := script(config.Config{}, "203.0.113.9:4321"); !strings.Contains(got, auth.DeriveClientKeyBase64("203.0.113.9")) {
+			t.Error("untrusted peer should get the key for its own address")
+		}
+		trusted := config.Config{TrustedProxyCIDRs: []string{"10.0.0.0/8"}}
+		if got := script(trusted, "10.0.0.2:4321"); !strings.Contains(got, auth.DeriveClientKeyBase64("198.51.100.7")) {
+			t.Error("trusted proxy should get the key for the forwarded client")
+		}
+	})
+
 	t.Run("rejects non-GET methods", func(t *testing.T) {
 		auth := NewHMACAuth("test-secret", "")
 		env := Env{HMACAuth: auth}
@@ -213,7 +281,7 @@ func assertPixelResponse(t *testing.T, w *httptest.ResponseRecorder, wantStatusC
 func TestPixel(t *testing.T) {
 	t.Run("returns GIF for GET request", func(t *testing.T) {
 		var emittedEvent *event.Event
-		env := Env{Cfg: config.Config{}, Emit: func(e event.Event) { emittedEvent = &e }}
+		env := Env{Cfg: config.Config{}, Emit: func(_ context.Context, e event.Event) { emittedEvent = &e }}
 		req := httptest.NewRequest(http.MethodGet, "/px.gif?utm_source=test", nil)
 		w := httptest.NewRecorder()
 		env.Pixel(w, req)
@@ -227,7 +295,7 @@ func TestPixel(t *testing.T) {
 	})
 
 	t.Run("returns GIF for HEAD request without body", func(t *testing.T) {
-		env := Env{Cfg: config.Config{}, Emit: func(e event.Event) {}}
+		env := Env{Cfg: config.Config{}, Emit: func(_ context.Context, e event.Event) {}}
 		req := httptest.NewRequest(http.MethodHead, "/px.gif", nil)
 		w := httptest.NewRecorder()
 		env.Pixel(w, req)
@@ -235,7 +303,7 @@ func TestPixel(t *testing.T) {
 	})
 
 	t.Run("rejects invalid methods", func(t *testing.T) {
-		env := Env{Cfg: config.Config{}, Emit: func(e event.Event) {}}
+		env := Env{Cfg: config.Config{}, Emit: func(_ context.Context, e event.Event) {}}
 		req := httptest.NewRequest(http.MethodPost, "/px.gif", nil)
 		w := httptest.NewRecorder()
 		env.Pixel(w, req)
@@ -384,7 +452,7 @@ func TestCollectIntegration(t *testing.T) {
 				MaxBodyBytes: 1024 * 1024,
 				TrustProxy:   false,
 			},
-			Emit: func(e event.Event) {
+			Emit: func(_ context.Context, e event.Event) {
 				capturedEvent = &e
 			},
 			Metrics: metrics.InitMetrics(),
@@ -392,7 +460,7 @@ func TestCollectIntegration(t *testing.T) {
 
 		eventJSON := `{
 			"type": "click",
-			"event_id": "test-event-123"
+			"event_id": "0190b8a2-6c1e-7a3b-8f2d-1c4e5a6b7c8d"
 		}`
 
 		req := httptest.NewRequest(http.MethodPost, "/collect?utm_source=test_source", strings.NewReader(eventJSON))
@@ -414,8 +482,8 @@ func TestCollectIntegration(t *testing.T) {
 		}
 
 		// Verify enrichment happened
-		if capturedEvent.EventID != "test-event-123" {
-			t.Errorf("event_id = %q, want test-event-123", capturedEvent.EventID)
+		if capturedEvent.EventID != "0190b8a2-6c1e-7a3b-8f2d-1c4e5a6b7c8d" {
+			t.Errorf("event_id = %q, want the client's UUID", capturedEvent.EventID)
 		}
 
 		if capturedEvent.Type != "click" {
@@ -437,6 +505,168 @@ func TestCollectIntegration(t *testing.T) {
 			t.Errorf("IP = %q, want 203.0.113.42", capturedEvent.Server.IP)
 		}
 	})
+
+	t.Run("counts detection signals", func(t *testing.T) {
+		m := metrics.InitMetrics()
+		env := Env{
+			Cfg:     config.Config{MaxBodyBytes: 1 << 20},
+			Emit:    func(context.Context, event.Event) {},
+			Metrics: m,
+		}
+		bots := testutil.ToFloat64(m.DetectionUAAutomation.WithLabelValues("headless"))
+
+		req := httptest.NewRequest(http.MethodPost, "/collect", strings.NewReader(`{"type":"pageview"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "Mozilla/5.0 HeadlessChrome/120.0")
+		w := httptest.NewRecorder()
+		env.Collect(w, req)
+
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("status code = %d, want %d", w.Code, http.StatusAccepted)
+		}
+		if got := testutil.ToFloat64(m.DetectionUAAutomation.WithLabelValues("headless")); got != bots+1 {
+			t.Errorf("headless user agents = %v, want %v", got, bots+1)
+		}
+	})
+
+	t.Run("records body and batch sizes", func(t *testing.T) {
+		m := metrics.InitMetrics()
+		env := Env{
+			Cfg:     config.Config{MaxBodyBytes: 1 << 20, MaxDecompressedBytes: 1 << 20},
+			Emit:    func(context.Context, event.Event) {},
+			Metrics: m,
+		}
+		received := m.RequestBodyBytes.WithLabelValues("/collect", "received")
+		decompressed := m.RequestBodyBytes.WithLabelValues("/collect", "decompressed")
+		wire, plain := sampleCount(t, received), sampleCount(t, decompressed)
+		requests := sampleCount(t, m.CollectBatchSize)
+
+		payload := []byte(`[{"type":"pageview"},{"type":"click"},{"type":"click"}]`)
+		req := httptest.NewRequest(http.MethodPost, "/collect", bytes.NewReader(gzipBytes(t, payload)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		env.Collect(w, req)
+
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("status code = %d, want %d", w.Code, http.StatusAccepted)
+		}
+		if got := sampleCount(t, received); got != wire+1 {
+			t.Errorf("received sizes observed = %d, want %d", got, wire+1)
+		}
+		if got := sampleCount(t, decompressed); got != plain+1 {
+			t.Errorf("decompressed sizes observed = %d, want %d", got, plain+1)
+		}
+		if got := sampleCount(t, m.CollectBatchSize); got != requests+1 {
+			t.Errorf("batch sizes observed = %d, want %d", got, requests+1)
+		}
+	})
+}
+
+// sampleCount returns how many values a histogram has observed
+func sampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestCollectValidation(t *testing.T) {
+	collect := func(t *testing.T, policy validation.Policy, body string) (*httptest.ResponseRecorder, []event.Event) {
+		t.Helper()
+		validator, err := validation.New(policy, validation.Rules{Required: []string{"type"}, MaxFieldLength: 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var emitted []event.Event
+		env := Env{
+			Cfg:       config.Config{MaxBodyBytes: 1 << 20},
+			Emit:      func(_ context.Context, e event.Event) { emitted = append(emitted, e) },
+			Metrics:   metrics.InitMetrics(),
+			Validator: validator,
+		}
+		req := httptest.NewRequest(http.MethodPost, "/collect", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		env.Collect(w, req)
+		return w, emitted
+	}
+	decode := func(t *testing.T, w *httptest.ResponseRecorder) (resp struct {
+		Accepted int                 `json:"accepted"`
+		Rejected int                 `json:"rejected"`
+		Status   string              `json:"status"`
+		Results  []validation.Result `json:"results"`
+	}) {
+		t.Helper()
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	t.Run("reject drops invalid events and reports each", func(t *testing.T) {
+		w, emitted := collect(t, validation.PolicyReject, `[{"type":"click"},{"type":""},{"type":"checkout_started"}]`)
+		assertResponseStatus(t, w, http.StatusAccepted, "application/json")
+		if len(emitted) != 1 || emitted[0].Type != "click" {
+			t.Errorf("emitted = %+v, want the click only", emitted)
+		}
+		resp := decode(t, w)
+		if resp.Accepted != 1 || resp.Rejected != 2 || len(resp.Results) != 3 {
+			t.Fatalf("response = %+v", resp)
+		}
+		if r := resp.Results[1]; r.Index != 1 || r.Status != validation.StatusRejected || r.Issues[0].String() != "type:required" {
+			t.Errorf("results[1] = %+v", r)
+		}
+		if r := resp.Results[2]; r.Status != validation.StatusRejected || r.Issues[0].String() != "type:too_long" {
+			t.Errorf("results[2] = %+v", r)
+		}
+	})
+
+	t.Run("all rejected is 422", func(t *testing.T) {
+		w, emitted := collect(t, validation.PolicyReject, `{"type":""}`)
+		assertResponseStatus(t, w, http.StatusUnprocessableEntity, "application/json")
+		if len(emitted) != 0 {
+			t.Errorf("emitted %d events, want 0", len(emitted))
+		}
+		if w.Header().Get("X-Gotrack-Accepted") != "0" {
+			t.Errorf("X-Gotrack-Accepted = %q, want 0", w.Header().Get("X-Gotrack-Accepted"))
+		}
+		if resp := decode(t, w); resp.Status != "rejected" || resp.Rejected != 1 {
+			t.Errorf("response = %+v", resp)
+		}
+	})
+
+	t.Run("sanitize emits the repaired event", func(t *testing.T) {
+		w, emitted := collect(t, validation.PolicySanitize, `{"type":"checkout_started"}`)
+		assertResponseStatus(t, w, http.StatusAccepted, "application/json")
+		if len(emitted) != 1 || emitted[0].Type != "checkout" {
+			t.Errorf("emitted = %+v, want type truncated to checkout", emitted)
+		}
+		if resp := decode(t, w); resp.Results[0].Status != validation.StatusSanitized {
+			t.Errorf("results = %+v", resp.Results)
+		}
+	})
+
+	t.Run("flag emits the event with its issues", func(t *testing.T) {
+		w, emitted := collect(t, validation.PolicyFlag, `{"type":"checkout_started","server":{"validation_issues":["forged"]}}`)
+		assertResponseStatus(t, w, http.StatusAccepted, "application/json")
+		if len(emitted) != 1 || strings.Join(emitted[0].Server.ValidationIssues, ",") != "type:too_long" {
+			t.Errorf("emitted = %+v", emitted)
+		}
+	})
+
+	t.Run("no validator omits results", func(t *testing.T) {
+		env := Env{Cfg: config.Config{MaxBodyBytes: 1 << 20}}
+		req := httptest.NewRequest(http.MethodPost, "/collect", strings.NewReader(`{"type":""}`))
+		w := httptest.NewRecorder()
+		env.Collect(w, req)
+		if strings.Contains(w.Body.String(), "results") {
+			t.Errorf("response = %s, want no results", w.Body.String())
+		}
+		assertAcceptedCount(t, w, 1)
+	})
 }
 
 // TestServePixelJS tests the pixel JS file serving endpoint
@@ -642,3 +872,119 @@ func TestServePixelJS_MissingFiles(t *testing.T) {
 		t.Error("expected non-empty response body from embedded asset")
 	}
 }
+
+// TestSessionStitching tests server-issued session cookies on ingestion endpoints
+func TestSessionStitching(t *testing.T) {
+	newEnv := func(emitted *[]event.Event, c config.Config) Env {
+		return Env{
+			Cfg:      c,
+			Emit:     func(_ context.Context, ev event.Event) { *emitted = append(*emitted, ev) },
+			Sessions: session.NewManager(session.Config{}, kv.NewMemoryStore()),
+		}
+	}
+
+	t.Run("pixel and collect share one session", func(t *testing.T) {
+		var emitted []event.Event
+		env := newEnv(&emitted, config.Config{MaxBodyBytes: 1 << 20})
+
+		w := httptest.NewRecorder()
+		env.Pixel(w, httptest.NewRequest(http.MethodGet, "/px.gif", nil))
+		if len(w.Result().Cookies()) != 2 {
+			t.Fatalf("expected visitor and session cookies, got %v", w.Result().Cookies())
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/collect", strings.NewReader(`[{"type":"click"},{"type":"scroll"}]`))
+		for _, c := range w.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		env.Collect(httptest.NewRecorder(), req)
+
+		if len(emitted) != 3 {
+			t.Fatalf("expected 3 events, got %d", len(emitted))
+		}
+		for i, ev := range emitted {
+			if ev.Session.SessionID != emitted[0].Session.SessionID || ev.Session.SessionSeq != i+1 {
+				t.Errorf("event %d session = %+v", i, ev.Session)
+			}
+		}
+	})
+
+	t.Run("no cookies for opted-out clients", func(t *testing.T) {
+		var emitted []event.Event
+		env := newEnv(&emitted, config.Config{DNTRespect: true, DNTAction: DNTActionStrip})
+		req := httptest.NewRequest(http.MethodGet, "/px.gif", nil)
+		req.Header.Set("Sec-GPC", "1")
+		w := httptest.NewRecorder()
+		env.Pixel(w, req)
+
+		if len(w.Result().Cookies()) != 0 {
+			t.Errorf("expected no cookies, got %v", w.Result().Cookies())
+		}
+		if emitted[0].Session.VisitorID != "" {
+			t.Error("expected no visitor ID for opted-out client")
+		}
+	})
+}
+
+// TestClickIDCookies tests click IDs carried from /px.gif to later /collect events
+func TestClickIDCookies(t *testing.T) {
+	var emitted []event.Event
+	env := Env{
+		Cfg:      config.Config{MaxBodyBytes: 1 << 20, DNTRespect: true, DNTAction: DNTActionStrip},
+		Emit:     func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) },
+		ClickIDs: session.NewClickCookies(session.Config{}),
+	}
+
+	w := httptest.NewRecorder()
+	env.Pixel(w, httptest.NewRequest(http.MethodGet, "/px.gif?gclid=Cj0KCQ&fbclid=IwAR1abc", nil))
+	if len(w.Result().Cookies()) != 3 {
+		t.Fatalf("expected _fbc, _fbp and _gcl_aw cookies, got %v", w.Result().Cookies())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/collect", strings.NewReader(`{"type":"purchase"}`))
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	cw := httptest.NewRecorder()
+	env.Collect(cw, req)
+	if len(cw.Result().Cookies()) != 0 {
+		t.Errorf("expected /collect to set no cookies, got %v", cw.Result().Cookies())
+	}
+	if len(emitted) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(emitted))
+	}
+	conv := emitted[1].URL
+	if conv.Google.GCLID != "Cj0KCQ" || !strings.HasSuffix(conv.Meta.FBC, ".IwAR1abc") || conv.Meta.FBP != emitted[0].URL.Meta.FBP {
+		t.Errorf("conversion url = %+v", conv)
+	}
+
+	optedOut := httptest.NewRequest(http.MethodGet, "/px.gif?gclid=Cj0KCQ", nil)
+	optedOut.Header.Set("Sec-GPC", "1")
+	ow := httptest.NewRecorder()
+	env.Pixel(ow, optedOut)
+	if len(ow.Result().Cookies()) != 0 {
+		t.Errorf("expected no cookies for an opted-out client, got %v", ow.Result().Cookies())
+	}
+}
+
+// BenchmarkCollect measures /collect from body to emit: decoding,
+// enrichment and the response, without HMAC
+func BenchmarkCollect(b *testing.B) {
+	body := []byte(`{"type":"pageview","url":{"referrer":"https://www.google.com/","utm":{"source":"google","medium":"cpc"}},"route":{"domain":"example.com","path":"/pricing","title":"Pricing"},"device":{"viewport_w":1920,"viewport_h":1080,"language":"en-US"},"session":{"visitor_id":"v-123","session_id":"s-456"}}`)
+	env := Env{
+		Cfg:  config.Config{MaxBodyBytes: 1 << 20},
+		Emit: func(context.Context, event.Event) {},
+	}
+
+	b.ReportAllocs()
+	for range b.N {
+		req := httptest.NewRequest(http.MethodPost, "/collect", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+		w := httptest.NewRecorder()
+		env.Collect(w, req)
+		if w.Code != http.StatusAccepted {
+			b.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package httpx
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -10,12 +11,17 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync"
+
+	"github.com/shortontech/gotrack/internal/logging"
 )
 
 // HMACAuth handles HMAC authentication for collection endpoints
 type HMACAuth struct {
-	secret    []byte
-	publicKey []byte
+	mu             sync.RWMutex
+	secret         []byte
+	publicKey      []byte
+	previousSecret []byte // still accepted after a rotation so already-loaded pages keep working
 }
 
 // NewHMACAuth creates a new HMAC authentication handler
@@ -49,8 +55,37 @@ func (h *HMACAuth) derivePublicKey(secret []byte) []byte {
 	return mac.Sum(nil)[:16] // Use first 16 bytes as public key
 }
 
+// Rotate replaces the HMAC secret (and public key) at runtime. The previous
+// secret remains valid for verification until the next rotation, so clients
+// holding a key from the old /hmac.js are not rejected mid-session.
+// Returns false if nothing changed.
+func (h *HMACAuth) Rotate(secret, publicKey string) bool {
+	fresh := NewHMACAuth(secret, publicKey)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if bytes.Equal(fresh.secret, h.secret) && bytes.Equal(fresh.publicKey, h.publicKey) {
+		return false
+	}
+	if !bytes.Equal(fresh.secret, h.secret) {
+		h.previousSecret = h.secret
+	}
+	h.secret = fresh.secret
+	h.publicKey = fresh.publicKey
+	return true
+}
+
+// currentSecrets returns the active secret and the one it replaced
+func (h *HMACAuth) currentSecrets() (current, previous []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.secret, h.previousSecret
+}
+
 // GetPublicKeyBase64 returns the base64-encoded public key for client use
 func (h *HMACAuth) GetPublicKeyBase64() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 	if len(h.publicKey) == 0 {
 		return ""
 	}
@@ -59,12 +94,18 @@ func (h *HMACAuth) GetPublicKeyBase64() string {
 
 // generateHMAC creates HMAC for payload using IP-derived key
 func (h *HMACAuth) generateHMAC(payload []byte, clientIP string) string {
-	if len(h.secret) == 0 {
+	secret, _ := h.currentSecrets()
+	return generateHMACWithSecret(secret, payload, clientIP)
+}
+
+// generateHMACWithSecret creates HMAC for payload using a key derived from secret + IP
+func generateHMACWithSecret(secret, payload []byte, clientIP string) string {
+	if len(secret) == 0 {
 		return ""
 	}
 
 	// Derive client-specific key from secret + IP
-	derivedKey := h.deriveClientKey(clientIP)
+	derivedKey := deriveClientKeyWithSecret(secret, clientIP)
 
 	// Generate HMAC
 	mac := hmac.New(sha256.New, derivedKey)
@@ -74,11 +115,17 @@ func (h *HMACAuth) generateHMAC(payload []byte, clientIP string) string {
 
 // deriveClientKey creates a client-specific key from secret + IP
 func (h *HMACAuth) deriveClientKey(clientIP string) []byte {
+	secret, _ := h.currentSecrets()
+	return deriveClientKeyWithSecret(secret, clientIP)
+}
+
+// deriveClientKeyWithSecret derives the client key for an IP from the given secret
+func deriveClientKeyWithSecret(secret []byte, clientIP string) []byte {
 	// Normalize IP (remove port, handle IPv6)
 	ip := normalizeIP(clientIP)
 
 	// Derive key: HMAC(secret, "client-key:" + ip)
-	mac := hmac.New(sha256.New, h.secret)
+	mac := hmac.New(sha256.New, secret)
 	mac.Write([]byte("client-key:" + ip))
 	return mac.Sum(nil)
 }
@@ -101,42 +148,59 @@ func normalizeIP(addr string) string {
 	return addr
 }
 
-// VerifyHMAC validates the HMAC signature for a request
-func (h *HMACAuth) VerifyHMAC(r *http.Request, payload []byte) bool {
+// VerifyHMAC validates the HMAC signature for a request from clientIP, the
+// address its key was derived for
+func (h *HMACAuth) VerifyHMAC(r *http.Request, clientIP string, payload []byte) bool {
+	secret, previous := h.currentSecrets()
 
-	if len(h.secret) == 0 {
+	if len(secret) == 0 {
 		log.Printf("HMAC verification failed: no secret configured")
 		return false
 	}
 
 	// Get HMAC from header
-	providedHMAC := r.Header.Get("X-GoTrack-HMAC")
+	providedHMAC := r.Header.Get(hmacHeader)
 	if providedHMAC == "" {
 		log.Printf("HMAC verification failed: missing X-GoTrack-HMAC header")
 		return false
 	}
 
-	// Get client IP
-	clientIP := getClientIP(r)
+	payload = signedMessage(r, payload)
 
 	// Generate expected HMAC
-	expectedHMAC := h.generateHMAC(payload, clientIP)
+	expectedHMAC := generateHMACWithSecret(secret, payload, clientIP)
 
 	// Compare HMACs (constant time comparison)
 	if !hmac.Equal([]byte(providedHMAC), []byte(expectedHMAC)) {
-		log.Printf("❌ HMAC VERIFICATION FAILED")
-		log.Printf("   Client IP: %s", clientIP)
-		log.Printf("   Provided HMAC:  %s", providedHMAC)
-		log.Printf("   Expected HMAC:  %s", expectedHMAC)
-		log.Printf("   Payload (first 100 bytes): %s", string(payload[:min(len(payload), 100)]))
-		log.Printf("   Derived key (hex): %x", h.deriveClientKey(clientIP))
+		// Accept signatures made with the pre-rotation secret
+		if len(previous) > 0 && hmac.Equal([]byte(providedHMAC), []byte(generateHMACWithSecret(previous, payload, clientIP))) {
+			logging.Debugf("HMAC verification successful for IP %s (previous secret)", clientIP)
+			return true
+		}
+		log.Printf("❌ HMAC VERIFICATION FAILED for IP %s", clientIP)
+		logging.Debugf("Provided HMAC:  %s", providedHMAC)
+		logging.Debugf("Expected HMAC:  %s", expectedHMAC)
+		logging.Debugf("Payload (first 100 bytes): %s", string(payload[:min(len(payload), 100)]))
+		logging.Debugf("Derived key (hex): %x", deriveClientKeyWithSecret(secret, clientIP))
 		return false
 	}
 
-	log.Printf("✅ HMAC verification successful for IP %s", clientIP)
+	logging.Debugf("HMAC verification successful for IP %s", clientIP)
 	return true
 }
 
+// signedMessage returns what the client signed: the payload, prefixed by
+// "<ts>\n<nonce>\n" when it sent a timestamp or nonce
+func signedMessage(r *http.Request, payload []byte) []byte {
+	ts, nonce := r.Header.Get(timestampHeader), r.Header.Get(nonceHeader)
+	if ts == "" && nonce == "" {
+		return payload
+	}
+	msg := make([]byte, 0, len(ts)+len(nonce)+2+len(payload))
+	msg = append(msg, ts+"\n"+nonce+"\n"...)
+	return append(msg, payload...)
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -144,38 +208,16 @@ func min(a, b int) int {
 	return b
 }
 
-// getClientIP extracts the real client IP considering proxies
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header (most common)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// Take the first IP in the chain
-		if ips := strings.Split(xff, ","); len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
-		}
-	}
-
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return strings.TrimSpace(xri)
-	}
-
-	// Fall back to RemoteAddr
-	return r.RemoteAddr
-}
-
-// GenerateClientScript generates JavaScript code for client-side HMAC generation
 // DeriveClientKeyBase64 returns the base64-encoded client-specific key for an IP
 func (h *HMACAuth) DeriveClientKeyBase64(clientIP string) string {
 	key := h.deriveClientKey(clientIP)
 	return base64.StdEncoding.EncodeToString(key)
 }
 
-// GenerateClientScriptForRequest generates the script with IP-specific key from the request
-func (h *HMACAuth) GenerateClientScriptForRequest(r *http.Request) string {
-	clientIP := getClientIP(r)
+// GenerateClientScriptForIP generates the script with the key for clientIP
+func (h *HMACAuth) GenerateClientScriptForIP(clientIP string) string {
 	keyB64 := h.DeriveClientKeyBase64(clientIP)
-	log.Printf("🔑 Generating HMAC script for IP: %s, Key (base64): %s", clientIP, keyB64)
-	log.Printf("   Derived key (hex): %x", h.deriveClientKey(clientIP))
+	logging.Debugf("Generating HMAC script for IP: %s, Key (base64): %s", clientIP, keyB64)
 	return h.GenerateClientScriptWithKey(keyB64)
 }
 
@@ -208,6 +250,13 @@ func (h *HMACAuth) GenerateClientScriptWithKey(keyB64 string) string {
       .join('');
   }
   
+  // Random 128-bit nonce as hex
+  function newNonce() {
+    return Array.from(crypto.getRandomValues(new Uint8Array(16)))
+      .map(b => b.toString(16).padStart(2, '0'))
+      .join('');
+  }
+  
   // Override fetch for GoTrack collection
   const originalFetch = window.fetch;
   window.fetch = async function(url, options = {}) {
@@ -216,9 +265,14 @@ func (h *HMACAuth) GenerateClientScriptWithKey(keyB64 string) string {
     if (options.method === 'POST' && options.body && 
         options.headers && options.headers['X-GoTrack-HMAC']) {
       try {
-        // Replace the marker with actual HMAC signature
-        const hmac = await generateHMAC(options.body, GOTRACK_PUBLIC_KEY);
+        // Replace the marker with a signature bound to this moment and
+        // a one-time nonce, so a captured request cannot be replayed
+        const ts = String(Math.floor(Date.now() / 1000));
+        const nonce = newNonce();
+        const hmac = await generateHMAC(ts + '\n' + nonce + '\n' + options.body, GOTRACK_PUBLIC_KEY);
         options.headers['X-GoTrack-HMAC'] = hmac;
+        options.headers['X-GoTrack-TS'] = ts;
+        options.headers['X-GoTrack-Nonce'] = nonce;
       } catch (e) {
         console.warn('GoTrack HMAC generation failed:', e);
       }
@@ -231,13 +285,14 @@ func (h *HMACAuth) GenerateClientScriptWithKey(keyB64 string) string {
 `, keyB64)
 }
 
+// GenerateClientScript generates JavaScript code for client-side HMAC
+// generation keyed by the public key
 func (h *HMACAuth) GenerateClientScript() string {
-	if len(h.publicKey) == 0 {
+	publicKeyB64 := h.GetPublicKeyBase64()
+	if publicKeyB64 == "" {
 		return ""
 	}
 
-	publicKeyB64 := h.GetPublicKeyBase64()
-
 	return fmt.Sprintf(`
 // GoTrack HMAC Authentication
 (function() {
@@ -259,6 +314,13 @@ func (h *HMACAuth) GenerateClientScript() string {
       .join('');
   }
   
+  // Random 128-bit nonce as hex
+  function newNonce() {
+    return Array.from(crypto.getRandomValues(new Uint8Array(16)))
+      .map(b => b.toString(16).padStart(2, '0'))
+      .join('');
+  }
+  
   // Override fetch for GoTrack collection
   const originalFetch = window.fetch;
   window.fetch = async function(url, options = {}) {
@@ -267,9 +329,14 @@ func (h *HMACAuth) GenerateClientScript() string {
     if (options.method === 'POST' && options.body && 
         options.headers && options.headers['X-GoTrack-HMAC']) {
       try {
-        // Replace the marker with actual HMAC signature
-        const hmac = await generateHMAC(options.body, GOTRACK_PUBLIC_KEY);
+        // Replace the marker with a signature bound to this moment and
+        // a one-time nonce, so a captured request cannot be replayed
+        const ts = String(Math.floor(Date.now() / 1000));
+        const nonce = newNonce();
+        const hmac = await generateHMAC(ts + '\n' + nonce + '\n' + options.body, GOTRACK_PUBLIC_KEY);
         options.headers['X-GoTrack-HMAC'] = hmac;
+        options.headers['X-GoTrack-TS'] = ts;
+        options.headers['X-GoTrack-Nonce'] = nonce;
       } catch (e) {
         console.warn('GoTrack HMAC generation failed:', e);
       }
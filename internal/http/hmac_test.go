@@ -3,6 +3,7 @@ package httpx
 import (
 	"bytes"
 	"encoding/base64"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -56,7 +57,7 @@ func TestDerivePublicKey(t *testing.T) {
 	})
 }
 
-// Note: normalizeIP and getClientIP are internal functions tested indirectly
+// Note: normalizeIP is an internal function tested indirectly
 
 // Note: generateHMAC is an internal method tested indirectly through VerifyHMAC
 
@@ -69,7 +70,7 @@ func TestVerifyHMAC(t *testing.T) {
 		req := httptest.NewRequest("POST", "/", bytes.NewReader(payload))
 		req.RemoteAddr = "192.168.1.1:8080"
 
-		if auth.VerifyHMAC(req, payload) {
+		if auth.VerifyHMAC(req, req.RemoteAddr, payload) {
 			t.Error("should reject missing HMAC header")
 		}
 	})
@@ -79,7 +80,7 @@ func TestVerifyHMAC(t *testing.T) {
 		req.RemoteAddr = "192.168.1.1:8080"
 		req.Header.Set("X-GoTrack-HMAC", "invalid-hmac-value")
 
-		if auth.VerifyHMAC(req, payload) {
+		if auth.VerifyHMAC(req, req.RemoteAddr, payload) {
 			t.Error("should reject invalid HMAC")
 		}
 	})
@@ -90,23 +91,82 @@ func TestVerifyHMAC(t *testing.T) {
 		req.RemoteAddr = "192.168.1.1:8080"
 
 		// HMAC is required when auth is configured
-		if auth.VerifyHMAC(req, payload) {
+		if auth.VerifyHMAC(req, req.RemoteAddr, payload) {
 			t.Error("should reject when HMAC header is missing")
 		}
 	})
 
+	t.Run("covers timestamp and nonce when sent", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(payload))
+		req.RemoteAddr = "192.168.1.1:8080"
+		req.Header.Set(timestampHeader, "1767225600")
+		req.Header.Set(nonceHeader, "0123456789abcdef")
+		req.Header.Set("X-GoTrack-HMAC", auth.generateHMAC(append([]byte("1767225600\n0123456789abcdef\n"), payload...), "192.168.1.1"))
+		if !auth.VerifyHMAC(req, req.RemoteAddr, payload) {
+			t.Error("should accept a signature over timestamp, nonce and payload")
+		}
+
+		req.Header.Set(timestampHeader, "1767225601")
+		if auth.VerifyHMAC(req, req.RemoteAddr, payload) {
+			t.Error("should reject a changed timestamp")
+		}
+		req.Header.Set(timestampHeader, "1767225600")
+		req.Header.Set("X-GoTrack-HMAC", auth.generateHMAC(payload, "192.168.1.1"))
+		if auth.VerifyHMAC(req, req.RemoteAddr, payload) {
+			t.Error("should reject a payload-only signature once a timestamp is sent")
+		}
+	})
+
 	t.Run("rejects when secret not configured", func(t *testing.T) {
 		authNoSecret := NewHMACAuth("", "") // requireHMAC = true, no secret
 		req := httptest.NewRequest("POST", "/", bytes.NewReader(payload))
 		req.RemoteAddr = "192.168.1.1:8080"
 		req.Header.Set("X-GoTrack-HMAC", "some-hmac")
 
-		if authNoSecret.VerifyHMAC(req, payload) {
+		if authNoSecret.VerifyHMAC(req, req.RemoteAddr, payload) {
 			t.Error("should reject when no secret configured")
 		}
 	})
 }
 
+func TestHMACAuthRotate(t *testing.T) {
+	auth := NewHMACAuth("old-secret", "")
+	req := httptest.NewRequest(http.MethodPost, "/collect", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	payload := []byte(`{"type":"pageview"}`)
+	oldSig := auth.generateHMAC(payload, "192.168.1.1")
+
+	if !auth.Rotate("new-secret", "") {
+		t.Fatal("expected rotation to report a change")
+	}
+	if auth.Rotate("new-secret", "") {
+		t.Error("rotating to the same secret should be a no-op")
+	}
+
+	// Signatures from the previous secret are still accepted
+	req.Header.Set("X-GoTrack-HMAC", oldSig)
+	if !auth.VerifyHMAC(req, req.RemoteAddr, payload) {
+		t.Error("expected previous-secret signature to verify after rotation")
+	}
+
+	// New signatures use the new secret
+	newSig := auth.generateHMAC(payload, "192.168.1.1")
+	if newSig == oldSig {
+		t.Fatal("expected new secret to produce a different signature")
+	}
+	req.Header.Set("X-GoTrack-HMAC", newSig)
+	if !auth.VerifyHMAC(req, req.RemoteAddr, payload) {
+		t.Error("expected new-secret signature to verify")
+	}
+
+	// A second rotation retires the original secret
+	auth.Rotate("newest-secret", "")
+	req.Header.Set("X-GoTrack-HMAC", oldSig)
+	if auth.VerifyHMAC(req, req.RemoteAddr, payload) {
+		t.Error("expected original secret to be rejected after two rotations")
+	}
+}
+
 func TestGenerateClientScript(t *testing.T) {
 	t.Run("generates script with public key", func(t *testing.T) {
 		auth := NewHMACAuth("test-secret", "")
@@ -124,6 +184,9 @@ func TestGenerateClientScript(t *testing.T) {
 		if !strings.Contains(script, "X-GoTrack-HMAC") {
 			t.Error("script should set X-GoTrack-HMAC header")
 		}
+		if !strings.Contains(script, "X-GoTrack-TS") || !strings.Contains(script, "X-GoTrack-Nonce") {
+			t.Error("script should send timestamp and nonce headers")
+		}
 	})
 
 	t.Run("returns empty when no public key", func(t *testing.T) {
@@ -145,76 +208,6 @@ func TestGenerateClientScript(t *testing.T) {
 	})
 }
 
-// TestGetClientIP tests IP extraction from requests
-func TestGetClientIP(t *testing.T) {
-	tests := []struct {
-		name       string
-		remoteAddr string
-		xForwarded string
-		xRealIP    string
-		want       string
-	}{
-		{
-			name:       "gets IP from RemoteAddr when no headers",
-			remoteAddr: "203.0.113.42:12345",
-			want:       "203.0.113.42:12345",
-		},
-		{
-			name:       "prefers X-Forwarded-For over X-Real-IP",
-			remoteAddr: "192.168.1.1:8080",
-			xRealIP:    "10.0.0.1",
-			xForwarded: "203.0.113.42",
-			want:       "203.0.113.42",
-		},
-		{
-			name:       "prefers X-Real-IP over RemoteAddr",
-			remoteAddr: "192.168.1.1:8080",
-			xRealIP:    "203.0.113.42",
-			want:       "203.0.113.42",
-		},
-		{
-			name:       "uses first IP in X-Forwarded-For chain",
-			remoteAddr: "192.168.1.1:8080",
-			xForwarded: "203.0.113.42, 10.0.0.1, 192.168.1.1",
-			want:       "203.0.113.42",
-		},
-		{
-			name:       "handles IPv6 RemoteAddr",
-			remoteAddr: "[2001:db8::1]:8080",
-			want:       "[2001:db8::1]:8080",
-		},
-		{
-			name:       "handles RemoteAddr without port",
-			remoteAddr: "203.0.113.42",
-			want:       "203.0.113.42",
-		},
-		{
-			name:       "trims whitespace from X-Forwarded-For",
-			remoteAddr: "192.168.1.1:8080",
-			xForwarded: "  203.0.113.42  , 10.0.0.1",
-			want:       "203.0.113.42",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest("GET", "/", nil)
-			req.RemoteAddr = tt.remoteAddr
-			if tt.xRealIP != "" {
-				req.Header.Set("X-Real-IP", tt.xRealIP)
-			}
-			if tt.xForwarded != "" {
-				req.Header.Set("X-Forwarded-For", tt.xForwarded)
-			}
-
-			got := getClientIP(req)
-			if got != tt.want {
-				t.Errorf("getClientIP() = %q, want %q", got, tt.want)
-			}
-		})
-	}
-}
-
 // TestNormalizeIP tests IP normalization (port removal)
 func TestNormalizeIP(t *testing.T) {
 	tests := []struct {
@@ -281,7 +274,7 @@ func TestHMACIntegration(t *testing.T) {
 		req := httptest.NewRequest("POST", "/", bytes.NewReader(payload))
 		req.RemoteAddr = "203.0.113.1:12345"
 
-		if auth.VerifyHMAC(req, payload) {
+		if auth.VerifyHMAC(req, req.RemoteAddr, payload) {
 			t.Error("should reject request when HMAC is missing")
 		}
 	})
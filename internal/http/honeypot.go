@@ -0,0 +1,69 @@
+package httpx
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/shortontech/gotrack/internal/email"
+	"github.com/shortontech/gotrack/internal/logging"
+	"github.com/shortontech/gotrack/internal/outbound"
+	"github.com/shortontech/gotrack/internal/shortlink"
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/event/detection"
+)
+
+// ValidateHoneypotPath checks a HONEYPOT_PATHS entry or HONEYPOT_LINK_PATH.
+// It must start with a slash and hold only letters, digits, '/', '-', '_'
+// and '.', and must not be one of GoTrack's own endpoints.
+func ValidateHoneypotPath(path string) error {
+	if !strings.HasPrefix(path, "/") || path == "/" {
+		return fmt.Errorf("honeypot path %q must start with / and name a path below it", path)
+	}
+	for _, c := range path {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("/-_.", c)) {
+			return fmt.Errorf("honeypot path %q contains %q", path, c)
+		}
+	}
+	if isTrackingPath(path) || strings.HasPrefix(path+"/", adminPathPrefix) ||
+		slices.Contains([]string{email.OpenPath, email.ClickPath, outbound.Path}, path) ||
+		strings.HasPrefix(path+"/", shortlink.PathPrefix) {
+		return fmt.Errorf("honeypot path %q is a GoTrack endpoint", path)
+	}
+	return nil
+}
+
+// honeypotPaths returns the configured trap paths, without duplicates
+func (e Env) honeypotPaths() []string {
+	var paths []string
+	for _, path := range append(slices.Clone(e.Cfg.HoneypotPaths), e.Cfg.HoneypotLinkPath) {
+		if path != "" && !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Honeypot returns the handler of a trap path. A request flags the client's
+// IP and header fingerprint as automated, so its events get
+// server.detection.honeypot and a bot score of 100, and is answered 404 as
+// if nothing were there.
+func (e Env) Honeypot(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := event.ClientIP(r, e.Cfg)
+		detection.DefaultTrapTracker.RecordTrap(ip, detection.HeaderFingerprint(r.Header))
+		if e.Metrics != nil {
+			e.Metrics.IncrementHoneypotHits(path)
+		}
+		logging.Debugf("Honeypot %s requested by %s", r.URL.Path, ip)
+		http.NotFound(w, r)
+	}
+}
+
+// trapLink returns the hidden link to a honeypot path added to proxied
+// pages. People never see or follow it; scrapers walking every href do.
+func trapLink(path string) string {
+	return `<a href="` + template.HTMLEscapeString(path) + `" rel="nofollow" tabindex="-1" aria-hidden="true" hidden></a>`
+}
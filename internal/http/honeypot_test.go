@@ -0,0 +1,97 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+	"github.com/shortontech/gotrack/pkg/event/detection"
+)
+
+func TestHoneypot(t *testing.T) {
+	saved := detection.DefaultTrapTracker
+	detection.DefaultTrapTracker = detection.NewMemoryTrapTracker(time.Minute)
+	defer func() { detection.DefaultTrapTracker = saved }()
+
+	var emitted []event.Event
+	handler := NewMux(Env{
+		Cfg:  cfg.Config{HoneypotPaths: []string{"/wp-login.php", "/wp-admin/"}},
+		Emit: func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) },
+	})
+	pixel := func(remote string) event.Event {
+		emitted = nil
+		req := httptest.NewRequest(http.MethodGet, "/px.gif?e=pageview", nil)
+		req.RemoteAddr = remote
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if len(emitted) != 1 {
+			t.Fatalf("emitted = %d, want 1", len(emitted))
+		}
+		return emitted[0]
+	}
+
+	if ev := pixel("203.0.113.7:1234"); ev.Server.Detection.Honeypot {
+		t.Fatal("client flagged before requesting a honeypot")
+	}
+	for _, path := range []string{"/wp-login.php", "/wp-admin/setup.php"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "203.0.113.7:1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", path, w.Code)
+		}
+	}
+	if ev := pixel("203.0.113.7:1234"); !ev.Server.Detection.Honeypot || ev.Server.Detection.BotScore() != 100 {
+		t.Errorf("trapped client detection = %+v", ev.Server.Detection)
+	}
+	if ev := pixel("198.51.100.1:1234"); ev.Server.Detection.Honeypot {
+		t.Error("other client flagged")
+	}
+}
+
+func TestHoneypot_TrapLink(t *testing.T) {
+	saved := detection.DefaultTrapTracker
+	detection.DefaultTrapTracker = detection.NewMemoryTrapTracker(time.Minute)
+	defer func() { detection.DefaultTrapTracker = saved }()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html><body><p>page</p></body></html>")
+	}))
+	defer origin.Close()
+	handler := NewMux(Env{Cfg: cfg.Config{ForwardDestination: origin.URL, HoneypotLinkPath: "/archive-all"}})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if body := w.Body.String(); !strings.Contains(body, trapLink("/archive-all")) {
+		t.Errorf("page without the trap link: %s", body)
+	}
+
+	// The trap path is answered by GoTrack, not the origin
+	req := httptest.NewRequest(http.MethodGet, "/archive-all", nil)
+	req.RemoteAddr = "203.0.113.9:1234"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || !detection.DefaultTrapTracker.Trapped("203.0.113.9", "") {
+		t.Errorf("trap link status = %d, client not flagged", w.Code)
+	}
+}
+
+func TestValidateHoneypotPath(t *testing.T) {
+	for _, path := range []string{"/wp-login.php", "/.env", "/wp-admin/", "/archive-all"} {
+		if err := ValidateHoneypotPath(path); err != nil {
+			t.Errorf("ValidateHoneypotPath(%q) error = %v", path, err)
+		}
+	}
+	for _, path := range []string{"", "/", "wp-login.php", "/a b", "/x\"onclick", "/collect", "/_gotrack/admin/x", "/r", "/s/code", "/e/c"} {
+		if err := ValidateHoneypotPath(path); err == nil {
+			t.Errorf("ValidateHoneypotPath(%q) succeeded, want an error", path)
+		}
+	}
+}
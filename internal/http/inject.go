@@ -0,0 +1,145 @@
+package httpx
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/shortontech/gotrack/internal/assets"
+)
+
+// InjectionData is passed to INJECT_TEMPLATE_FILE for every instrumented page
+type InjectionData struct {
+	Library       template.JS // the tracking library, for inlining in a <script>
+	ScriptURL     string      // /pixel.js URL to load instead when INJECT_CSP=external applies
+	PixelURL      string      // /px.gif URL recording the pageview
+	HMACScriptURL string      // /hmac.js URL; empty without HMAC_SECRET
+	Nonce         string      // script nonce allowed by the page's Content-Security-Policy; empty if none
+	Host          string      // requested host
+	Path          string      // requested path
+}
+
+// Injector decides which proxied pages are instrumented and renders the
+// injected HTML. A nil Injector instruments every page with the built-in
+// snippet.
+type Injector struct {
+	tmpl    *template.Template // nil renders the built-in snippet
+	include []*regexp.Regexp   // empty instruments every path
+	exclude []*regexp.Regexp
+	cspMode string
+}
+
+// InjectorConfig selects the injected code and the pages that get it
+type InjectorConfig struct {
+	TemplateFile string   // html/template file; empty uses the built-in snippet
+	Paths        []string // path patterns to instrument; empty instruments every page
+	ExcludePaths []string // path patterns never instrumented
+	CSPMode      string   // one of the CSPMode values; empty is CSPModeOff
+}
+
+// NewInjector loads an optional template file and compiles path patterns,
+// in which * matches any run of characters. Exclusions win over inclusions.
+func NewInjector(cfg InjectorConfig) (*Injector, error) {
+	if err := ValidCSPMode(cfg.CSPMode); err != nil {
+		return nil, err
+	}
+	in := &Injector{cspMode: cfg.CSPMode}
+	if cfg.TemplateFile != "" {
+		tmpl, err := template.ParseFiles(cfg.TemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("parse injection template: %w", err)
+		}
+		// Fail at startup, not on the first page, when the template uses
+		// fields that don't exist
+		sample := InjectionData{PixelURL: "/px.gif", ScriptURL: "/pixel.js", HMACScriptURL: "/hmac.js", Nonce: "nonce", Host: "example.com", Path: "/"}
+		if err := tmpl.Execute(io.Discard, sample); err != nil {
+			return nil, fmt.Errorf("execute injection template: %w", err)
+		}
+		in.tmpl = tmpl
+	}
+
+	var err error
+	if in.include, err = compilePathPatterns(cfg.Paths); err != nil {
+		return nil, err
+	}
+	if in.exclude, err = compilePathPatterns(cfg.ExcludePaths); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+func compilePathPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, p := range patterns {
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("path pattern %q must start with /", p)
+		}
+		expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(p), `\*`, ".*") + "$"
+		compiled = append(compiled, regexp.MustCompile(expr))
+	}
+	return compiled, nil
+}
+
+// Enabled reports whether pages at path get tracking code
+func (in *Injector) Enabled(path string) bool {
+	if in == nil {
+		return true
+	}
+	for _, re := range in.exclude {
+		if re.MatchString(path) {
+			return false
+		}
+	}
+	if len(in.include) == 0 {
+		return true
+	}
+	for _, re := range in.include {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// inject adds tracking code to an HTML page. header is the response header
+// as sent to the client; INJECT_CSP=nonce may add a nonce to its policy. AMP
+// pages always get the built-in <amp-pixel>, since AMP strips custom scripts.
+func (in *Injector) inject(body []byte, r *http.Request, header http.Header, hmacAuth *HMACAuth, pathPrefix string) []byte {
+	if in == nil || isAMPDocument(body) {
+		return injectPixel(body, r, hmacAuth, pathPrefix)
+	}
+
+	plan := planScripts(header, in.cspMode)
+	if in.tmpl == nil {
+		if in.cspMode == "" || in.cspMode == CSPModeOff {
+			return injectPixel(body, r, hmacAuth, pathPrefix)
+		}
+		return insertSnippet(body, builtinSnippet(pixelURL(r, pathPrefix), pathPrefix, hmacAuth != nil, plan))
+	}
+
+	data := InjectionData{
+		// nosemgrep: go.lang.security.audit.xss.template-js.template-js -- embedded asset, not user input
+		Library:  template.JS(assets.PixelUMDJS),
+		PixelURL: pixelURL(r, pathPrefix),
+		Nonce:    plan.nonce,
+		Host:     r.Host,
+		Path:     r.URL.Path,
+	}
+	if plan.external {
+		data.ScriptURL = pathPrefix + "/pixel.js"
+	}
+	if hmacAuth != nil {
+		data.HMACScriptURL = pathPrefix + "/hmac.js"
+	}
+	var snippet bytes.Buffer
+	if err := in.tmpl.Execute(&snippet, data); err != nil {
+		log.Printf("proxy: injection template failed for %s: %v", r.URL.Path, err)
+		return body
+	}
+	return insertSnippet(body, snippet.String())
+}
@@ -0,0 +1,165 @@
+package httpx
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTemplate(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "inject.html")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestNewInjector tests template and pattern validation
+func TestNewInjector(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		include  []string
+		wantErr  bool
+	}{
+		{name: "patterns only", include: []string{"/blog/*"}},
+		{name: "valid template", template: `<script nonce="{{.Nonce}}">{{.Library}}</script>`},
+		{name: "syntax error", template: `{{.PixelURL`, wantErr: true},
+		{name: "unknown field", template: `{{.SiteID}}`, wantErr: true},
+		{name: "relative pattern", include: []string{"blog/*"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := ""
+			if tt.template != "" {
+				file = writeTemplate(t, tt.template)
+			}
+			_, err := NewInjector(InjectorConfig{TemplateFile: file, Paths: tt.include})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewInjector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := NewInjector(InjectorConfig{TemplateFile: filepath.Join(t.TempDir(), "missing.html")}); err == nil {
+		t.Error("expected error for a missing template file")
+	}
+}
+
+// TestInjectorEnabled tests per-route include and exclude patterns
+func TestInjectorEnabled(t *testing.T) {
+	in, err := NewInjector(InjectorConfig{
+		Paths:        []string{"/", "/blog/*", "/shop*"},
+		ExcludePaths: []string{"/blog/drafts/*", "/admin/*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"/":                 true,
+		"/blog/post":        true,
+		"/blog/drafts/wip":  false,
+		"/shop":             true,
+		"/shop/cart":        true,
+		"/admin/users":      false,
+		"/about":            false,
+		"/blog.example.com": false,
+	}
+	for path, want := range tests {
+		if got := in.Enabled(path); got != want {
+			t.Errorf("Enabled(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	var none *Injector
+	if !none.Enabled("/admin/users") {
+		t.Error("nil injector should instrument every page")
+	}
+}
+
+// TestInjectorTemplate tests pages rendered with an operator template
+func TestInjectorTemplate(t *testing.T) {
+	file := writeTemplate(t, `{{if .HMACScriptURL}}<script src="{{.HMACScriptURL}}" defer></script>{{end}}`+
+		`<script nonce="{{.Nonce}}" data-site="{{.Host}}">{{.Library}}</script>`)
+	in, err := NewInjector(InjectorConfig{TemplateFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"Content-Security-Policy": {"script-src 'nonce-r4nd0m'"}}
+	page := []byte("<html><body><h1>Shop</h1></body></html>")
+
+	t.Run("renders the template", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://shop.example.com/cart", nil)
+		result := string(in.inject(page, req, header, NewHMACAuth("secret", ""), "/t"))
+		for _, want := range []string{
+			`<script src="/t/hmac.js" defer></script>`,
+			`<script nonce="r4nd0m" data-site="shop.example.com">`,
+			"</script>\n</body>",
+		} {
+			if !strings.Contains(result, want) {
+				t.Errorf("missing %q in %s", want, result[:min(len(result), 300)])
+			}
+		}
+		if strings.Contains(result, "<img") {
+			t.Error("template without an <img> should not get the pixel")
+		}
+	})
+
+	t.Run("escapes request values", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://shop.example.com/", nil)
+		req.Host = `evil"><script>`
+		result := string(in.inject(page, req, nil, nil, ""))
+		if strings.Contains(result, `evil"><script>`) {
+			t.Error("host was not escaped")
+		}
+	})
+
+	t.Run("AMP pages keep amp-pixel", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/amp", nil)
+		result := string(in.inject([]byte("<html amp><body></body></html>"), req, header, nil, ""))
+		if !strings.Contains(result, "<amp-pixel") || strings.Contains(result, "<script") {
+			t.Errorf("AMP page = %s", result)
+		}
+	})
+}
+
+// TestInsertSnippet tests that inlined code is inserted verbatim
+func TestInsertSnippet(t *testing.T) {
+	result := string(insertSnippet([]byte("<html><body></body></html>"), "<script>s.replace(/x/, '$1')</script>"))
+	if !strings.Contains(result, "'$1'") {
+		t.Errorf("snippet changed on insertion: %s", result)
+	}
+}
+
+// TestProxyHandlerInjectionPaths tests that excluded pages are proxied untouched
+func TestProxyHandlerInjectionPaths(t *testing.T) {
+	page := "<html><body>page</body></html>"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, page)
+	}))
+	defer backend.Close()
+
+	in, err := NewInjector(InjectorConfig{ExcludePaths: []string{"/admin/*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewProxyHandler(backend.URL, nil)
+	handler.injector = in
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/users", nil))
+	if w.Body.String() != page {
+		t.Errorf("excluded page was modified: %s", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products", nil))
+	if !strings.Contains(w.Body.String(), "/px.gif") {
+		t.Error("included page should get the pixel")
+	}
+}
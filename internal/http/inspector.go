@@ -0,0 +1,203 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// maxErrorMessage bounds the response text kept for a rejected request
+const maxErrorMessage = 256
+
+// Inspector backs the admin dashboard and live tail. It keeps the most
+// recent events and errors and fans new events out to tails. Subscribers
+// that fall behind miss events rather than slowing ingestion. A nil
+// Inspector ignores everything.
+type Inspector struct {
+	anonymize func(event.Event) event.Event
+
+	mu     sync.Mutex
+	size   int
+	events []tailEntry      // oldest first
+	errors []InspectorError // oldest first
+	subs   map[chan tailEntry]struct{}
+	closed bool
+}
+
+// tailEntry is an event as tails show it, with the fields they filter on
+type tailEntry struct {
+	raw       json.RawMessage // IP anonymized
+	typ       string
+	visitorID string
+	ip        netip.Addr // before anonymization, so QA can follow its own traffic
+}
+
+// InspectorError is an ingestion request GoTrack rejected or an event a sink
+// refused
+type InspectorError struct {
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"`           // route such as /collect, or sink:<name>
+	Status    int       `json:"status,omitempty"` // HTTP status of a rejected request
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// NewInspector keeps the last size events and errors. anonymize is applied
+// to events before they are kept or shown; nil shows them as emitted.
+func NewInspector(size int, anonymize func(event.Event) event.Event) *Inspector {
+	return &Inspector{anonymize: anonymize, size: size, subs: make(map[chan tailEntry]struct{})}
+}
+
+// Observe records an event and sends it to every tail
+func (i *Inspector) Observe(ev event.Event) {
+	if i == nil {
+		return
+	}
+	entry := tailEntry{typ: ev.Type, visitorID: ev.Session.VisitorID}
+	if addr, err := netip.ParseAddr(ev.Server.IP); err == nil {
+		entry.ip = addr.Unmap()
+	}
+	if i.anonymize != nil {
+		ev = i.anonymize(ev)
+	}
+	var err error
+	if entry.raw, err = json.Marshal(ev); err != nil {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.events = appendBounded(i.events, entry, i.size)
+	for ch := range i.subs {
+		select {
+		case ch <- entry:
+		default: // slow tail: drop rather than block /collect
+		}
+	}
+}
+
+// RecordError keeps an error for the dashboard
+func (i *Inspector) RecordError(e InspectorError) {
+	if i == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	i.mu.Lock()
+	i.errors = appendBounded(i.errors, e, i.size)
+	i.mu.Unlock()
+}
+
+// Errors returns the recent errors, newest first
+func (i *Inspector) Errors() []InspectorError {
+	if i == nil {
+		return nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	errs := slices.Clone(i.errors)
+	slices.Reverse(errs)
+	return errs
+}
+
+// subscribe returns the recent events, oldest first, and a channel
+// receiving the events that follow. Call cancel when done.
+func (i *Inspector) subscribe() (recent []tailEntry, events <-chan tailEntry, cancel func()) {
+	ch := make(chan tailEntry, 256)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	recent = slices.Clone(i.events)
+	if i.closed {
+		close(ch)
+		return recent, ch, func() {}
+	}
+	i.subs[ch] = struct{}{}
+	return recent, ch, func() {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		if _, ok := i.subs[ch]; ok {
+			delete(i.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close ends every tail, so a server shutdown need not wait for them. Set
+// it as an http.Server RegisterOnShutdown hook.
+func (i *Inspector) Close() {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.closed = true
+	for ch := range i.subs {
+		delete(i.subs, ch)
+		close(ch)
+	}
+}
+
+func appendBounded[T any](s []T, v T, size int) []T {
+	if len(s) >= size {
+		s = slices.Delete(s, 0, len(s)-size+1)
+	}
+	return append(s, v)
+}
+
+// recordRejections reports responses of 400 and above from an ingestion
+// handler to the inspector, with the start of their message
+func (i *Inspector) recordRejections(route string, h http.HandlerFunc) http.HandlerFunc {
+	if i == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &rejectionRecorder{ResponseWriter: w}
+		h(rec, r)
+		if rec.status < http.StatusBadRequest {
+			return
+		}
+		i.RecordError(InspectorError{
+			Source:    route,
+			Status:    rec.status,
+			Message:   strings.TrimSpace(string(rec.body)),
+			RequestID: r.Header.Get(event.RequestIDHeader),
+		})
+	}
+}
+
+// rejectionRecorder captures the status and, for errors, the first bytes of
+// the body
+type rejectionRecorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (rw *rejectionRecorder) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *rejectionRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if rw.status >= http.StatusBadRequest && len(rw.body) < maxErrorMessage {
+		rw.body = append(rw.body, b[:min(len(b), maxErrorMessage-len(rw.body))]...)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *rejectionRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
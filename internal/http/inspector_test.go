@@ -0,0 +1,126 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// rawType decodes the type of a JSON event
+func rawType(t *testing.T, raw json.RawMessage) string {
+	t.Helper()
+	var ev event.Event
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		t.Fatalf("invalid event JSON %s: %v", raw, err)
+	}
+	return ev.Type
+}
+
+func TestInspector(t *testing.T) {
+	t.Run("keeps the most recent events and errors", func(t *testing.T) {
+		in := NewInspector(2, nil)
+		for _, typ := range []string{"a", "b", "c"} {
+			in.Observe(event.Event{Type: typ})
+			in.RecordError(InspectorError{Source: "/collect", Message: typ})
+		}
+		recent, _, cancel := in.subscribe()
+		defer cancel()
+		if len(recent) != 2 || rawType(t, recent[0].raw) != "b" || rawType(t, recent[1].raw) != "c" {
+			t.Errorf("recent = %+v, want b then c", recent)
+		}
+		errs := in.Errors()
+		if len(errs) != 2 || errs[0].Message != "c" || errs[1].Message != "b" || errs[0].Time.IsZero() {
+			t.Errorf("Errors() = %+v, want c then b", errs)
+		}
+	})
+
+	t.Run("sends events to tails without blocking", func(t *testing.T) {
+		in := NewInspector(10, nil)
+		_, events, cancel := in.subscribe()
+		defer cancel()
+		in.Observe(event.Event{Type: "click"})
+		if entry := <-events; rawType(t, entry.raw) != "click" {
+			t.Errorf("tail got %s", entry.raw)
+		}
+		// A tail that stops reading must not stall ingestion
+		for range 1000 {
+			in.Observe(event.Event{Type: "click"})
+		}
+	})
+
+	t.Run("anonymizes shown events but filters on the raw IP", func(t *testing.T) {
+		in := NewInspector(10, func(ev event.Event) event.Event {
+			ev.Server.IP = ""
+			return ev
+		})
+		ev := event.Event{Type: "click", Session: event.SessionInfo{VisitorID: "v1"}}
+		ev.Server.IP = "::ffff:203.0.113.7"
+		in.Observe(ev)
+		recent, _, cancel := in.subscribe()
+		defer cancel()
+		if len(recent) != 1 || strings.Contains(string(recent[0].raw), "203.0.113.7") {
+			t.Fatalf("recent = %+v, want the IP removed", recent)
+		}
+		if e := recent[0]; e.ip != netip.MustParseAddr("203.0.113.7") || e.visitorID != "v1" || e.typ != "click" {
+			t.Errorf("entry = %+v", e)
+		}
+	})
+
+	t.Run("close ends tails", func(t *testing.T) {
+		in := NewInspector(10, nil)
+		_, events, cancel := in.subscribe()
+		in.Close()
+		if _, ok := <-events; ok {
+			t.Error("tail still open after Close")
+		}
+		cancel() // after Close, must not close again
+		_, late, _ := in.subscribe()
+		if _, ok := <-late; ok {
+			t.Error("tail opened after Close")
+		}
+	})
+
+	t.Run("nil inspector ignores everything", func(t *testing.T) {
+		var in *Inspector
+		in.Observe(event.Event{Type: "click"})
+		in.RecordError(InspectorError{Message: "x"})
+		in.Close()
+		if errs := in.Errors(); errs != nil {
+			t.Errorf("Errors() = %v", errs)
+		}
+	})
+}
+
+func TestRecordRejections(t *testing.T) {
+	in := NewInspector(10, nil)
+	h := in.recordRejections("/collect", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "invalid or missing HMAC signature", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/collect?fail=1", nil)
+	req.Header.Set(event.RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	h(w, req)
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/collect", nil))
+
+	if w.Code != http.StatusUnauthorized || w.Body.String() != "invalid or missing HMAC signature\n" {
+		t.Errorf("response changed: %d %q", w.Code, w.Body.String())
+	}
+	errs := in.Errors()
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want only the rejection", len(errs))
+	}
+	want := InspectorError{Time: errs[0].Time, Source: "/collect", Status: 401, Message: "invalid or missing HMAC signature", RequestID: "req-1"}
+	if errs[0] != want {
+		t.Errorf("error = %+v, want %+v", errs[0], want)
+	}
+}
@@ -6,14 +6,52 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/shortontech/gotrack/internal/metrics"
+	event "github.com/shortontech/gotrack/pkg/event"
 )
 
+// maxRequestIDLength bounds client-supplied request IDs; longer ones are
+// replaced. It also keeps metric exemplars under Prometheus' size limit.
+const maxRequestIDLength = 64
+
+// RequestID makes sure every request has an X-Request-ID. A valid incoming
+// ID is kept, otherwise a UUID is generated. The ID is set on the request,
+// so enrichment, logs and the proxied request see it, and echoed in the
+// response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(event.RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+			r.Header.Set(event.RequestIDHeader, id)
+		}
+		w.Header().Set(event.RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID accepts short IDs made of letters, digits and . _ : -
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == ':', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func RequestLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		next.ServeHTTP(w, r)
-		log.Printf("%s %s ua=%q dur=%s", r.Method, r.URL.Path, r.UserAgent(), time.Since(start))
+		log.Printf("%s %s ua=%q dur=%s req_id=%s", r.Method, r.URL.Path, r.UserAgent(), time.Since(start), r.Header.Get(event.RequestIDHeader))
 	})
 }
 func cors(next http.Handler) http.Handler {
@@ -21,7 +59,8 @@ func cors(next http.Handler) http.Handler {
 		// Very permissive for dev; tighten in production.
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-GoTrack-HMAC")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Encoding, X-GoTrack-HMAC, X-GoTrack-TS, X-GoTrack-Nonce, X-GoTrack-Write-Key, X-Request-ID, traceparent, tracestate")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -41,8 +80,15 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-// MetricsMiddleware adds HTTP request metrics tracking
-func MetricsMiddleware(appMetrics *metrics.Metrics) func(http.Handler) http.Handler {
+// Unwrap lets http.ResponseController reach the underlying writer, so the
+// proxy can flush streamed responses and take over upgraded connections
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// MetricsMiddleware adds HTTP request metrics tracking. route maps a request
+// path to its endpoint label; nil labels requests with the raw path.
+func MetricsMiddleware(appMetrics *metrics.Metrics, route func(path string) string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if appMetrics == nil {
@@ -57,12 +103,15 @@ func MetricsMiddleware(appMetrics *metrics.Metrics) func(http.Handler) http.Hand
 
 			duration := time.Since(start)
 			endpoint := r.URL.Path
+			if route != nil {
+				endpoint = route(endpoint)
+			}
 			method := r.Method
 			status := strconv.Itoa(wrapped.statusCode)
 
 			// Record metrics
 			appMetrics.IncrementHTTPRequests(endpoint, method, status)
-			appMetrics.ObserveHTTPDuration(endpoint, method, duration)
+			appMetrics.ObserveHTTPDurationWithExemplar(endpoint, method, duration, r.Header.Get(event.RequestIDHeader))
 		})
 	}
 }
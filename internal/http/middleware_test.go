@@ -2,13 +2,16 @@ package httpx
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/shortontech/gotrack/internal/metrics"
+	event "github.com/shortontech/gotrack/pkg/event"
 )
 
 // TestRequestLogger tests the request logging middleware
@@ -331,7 +334,7 @@ func TestMetricsMiddleware(t *testing.T) {
 			w.WriteHeader(http.StatusOK)
 		})
 
-		middleware := MetricsMiddleware(nil)(nextHandler)
+		middleware := MetricsMiddleware(nil, nil)(nextHandler)
 
 		req := httptest.NewRequest(http.MethodGet, "/test", nil)
 		w := httptest.NewRecorder()
@@ -349,7 +352,7 @@ func TestMetricsMiddleware(t *testing.T) {
 			w.WriteHeader(http.StatusOK)
 		})
 
-		middleware := MetricsMiddleware(m)(nextHandler)
+		middleware := MetricsMiddleware(m, nil)(nextHandler)
 
 		req := httptest.NewRequest(http.MethodGet, "/test", nil)
 		w := httptest.NewRecorder()
@@ -370,7 +373,7 @@ func TestMetricsMiddleware(t *testing.T) {
 			w.WriteHeader(http.StatusInternalServerError)
 		})
 
-		middleware := MetricsMiddleware(m)(nextHandler)
+		middleware := MetricsMiddleware(m, nil)(nextHandler)
 
 		req := httptest.NewRequest(http.MethodPost, "/api/error", nil)
 		w := httptest.NewRecorder()
@@ -397,7 +400,7 @@ func TestMetricsMiddleware(t *testing.T) {
 					w.WriteHeader(statusCode)
 				})
 
-				middleware := MetricsMiddleware(m)(nextHandler)
+				middleware := MetricsMiddleware(m, nil)(nextHandler)
 
 				req := httptest.NewRequest(http.MethodGet, "/test", nil)
 				w := httptest.NewRecorder()
@@ -417,7 +420,7 @@ func TestMetricsMiddleware(t *testing.T) {
 			w.WriteHeader(http.StatusOK)
 		})
 
-		middleware := MetricsMiddleware(m)(nextHandler)
+		middleware := MetricsMiddleware(m, nil)(nextHandler)
 
 		req := httptest.NewRequest(http.MethodGet, "/slow", nil)
 		w := httptest.NewRecorder()
@@ -441,7 +444,7 @@ func TestMetricsMiddleware(t *testing.T) {
 					w.WriteHeader(http.StatusOK)
 				})
 
-				middleware := MetricsMiddleware(m)(nextHandler)
+				middleware := MetricsMiddleware(m, nil)(nextHandler)
 
 				req := httptest.NewRequest(http.MethodGet, endpoint, nil)
 				w := httptest.NewRecorder()
@@ -464,7 +467,7 @@ func TestMetricsMiddleware(t *testing.T) {
 					w.WriteHeader(http.StatusOK)
 				})
 
-				middleware := MetricsMiddleware(m)(nextHandler)
+				middleware := MetricsMiddleware(m, nil)(nextHandler)
 
 				req := httptest.NewRequest(method, "/test", nil)
 				w := httptest.NewRecorder()
@@ -486,7 +489,7 @@ func TestMetricsMiddleware(t *testing.T) {
 			w.Write([]byte(expectedBody))
 		})
 
-		middleware := MetricsMiddleware(m)(nextHandler)
+		middleware := MetricsMiddleware(m, nil)(nextHandler)
 
 		req := httptest.NewRequest(http.MethodGet, "/test", nil)
 		w := httptest.NewRecorder()
@@ -507,6 +510,63 @@ func TestMetricsMiddleware(t *testing.T) {
 	})
 }
 
+// TestRequestID tests request ID assignment and echo
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get("X-Request-ID")
+	}))
+
+	t.Run("honors a valid incoming ID", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/px.gif", nil)
+		req.Header.Set("X-Request-ID", "edge-7f3c:42")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if seen != "edge-7f3c:42" || w.Header().Get("X-Request-ID") != "edge-7f3c:42" {
+			t.Errorf("request ID = %q, echoed %q", seen, w.Header().Get("X-Request-ID"))
+		}
+	})
+
+	for name, incoming := range map[string]string{
+		"generates a missing ID": "",
+		"replaces an unsafe ID":  "abc\r\ninjected",
+		"replaces a long ID":     strings.Repeat("a", maxRequestIDLength+1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/px.gif", nil)
+			if incoming != "" {
+				req.Header["X-Request-Id"] = []string{incoming}
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if _, err := uuid.Parse(seen); err != nil {
+				t.Errorf("request ID = %q, want a generated UUID", seen)
+			}
+			if w.Header().Get("X-Request-ID") != seen {
+				t.Errorf("echoed %q, want %q", w.Header().Get("X-Request-ID"), seen)
+			}
+		})
+	}
+}
+
+func TestNewMux_RequestIDReachesEvent(t *testing.T) {
+	var emitted event.Event
+	env := Env{Emit: func(_ context.Context, ev event.Event) { emitted = ev }}
+	req := httptest.NewRequest(http.MethodGet, "/px.gif", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	w := httptest.NewRecorder()
+	NewMux(env).ServeHTTP(w, req)
+
+	if emitted.Server.RequestID != "req-123" {
+		t.Errorf("Server.RequestID = %q, want req-123", emitted.Server.RequestID)
+	}
+	if w.Header().Get("X-Request-ID") != "req-123" {
+		t.Errorf("response X-Request-ID = %q", w.Header().Get("X-Request-ID"))
+	}
+}
+
 // TestMiddlewareChaining tests that middleware can be chained together
 func TestMiddlewareChaining(t *testing.T) {
 	// Use InitMetrics to avoid registry conflicts
@@ -543,7 +603,7 @@ func TestMiddlewareChaining(t *testing.T) {
 		})
 
 		// Chain: RequestLogger -> MetricsMiddleware -> cors -> finalHandler
-		handler := RequestLogger(MetricsMiddleware(m)(cors(finalHandler)))
+		handler := RequestLogger(MetricsMiddleware(m, nil)(cors(finalHandler)))
 
 		req := httptest.NewRequest(http.MethodPost, "/api/test", bytes.NewReader([]byte("test")))
 		w := httptest.NewRecorder()
@@ -0,0 +1,116 @@
+package httpx
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/shortontech/gotrack/internal/measurement"
+	event "github.com/shortontech/gotrack/pkg/event"
+)
+
+// POST /mp/collect?measurement_id=...&api_secret=... — GA4 Measurement
+// Protocol compatibility. Existing GA server-side integrations can send the
+// same requests here; events are mapped by the measurement package. As on
+// /collect/ndjson, request-derived fields such as the IP are not filled in,
+// because the sender is a server.
+func (e Env) MeasurementProtocol(w http.ResponseWriter, r *http.Request) {
+	e.measurementProtocol(w, r, false)
+}
+
+// POST /debug/mp/collect — validates like GA's debug endpoint and reports
+// validationMessages; nothing is emitted.
+func (e Env) MeasurementProtocolDebug(w http.ResponseWriter, r *http.Request) {
+	e.measurementProtocol(w, r, true)
+}
+
+func (e Env) measurementProtocol(w http.ResponseWriter, r *http.Request, debug bool) {
+	if e.Cfg.MPAPISecret == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(q.Get("api_secret")), []byte(e.Cfg.MPAPISecret)) != 1 {
+		http.Error(w, "invalid or missing api_secret", http.StatusUnauthorized)
+		return
+	}
+	streamID := q.Get("measurement_id")
+	if streamID == "" {
+		streamID = q.Get("firebase_app_id")
+	}
+	if streamID == "" {
+		http.Error(w, "measurement_id or firebase_app_id is required", http.StatusBadRequest)
+		return
+	}
+	r, ok := e.resolveStream(w, r, streamID)
+	if !ok {
+		return
+	}
+
+	body, ok := e.readBody(w, r)
+	if !ok {
+		return
+	}
+	payload, err := measurement.Parse(body)
+	if err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	msgs := payload.Validate()
+	if debug {
+		if msgs == nil {
+			msgs = []measurement.Message{} // [] rather than null, as GA answers
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"validationMessages": msgs})
+		return
+	}
+	if len(msgs) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"validationMessages": msgs})
+		return
+	}
+
+	accepted := e.emitMeasurementEvents(r.Context(), payload.ToEvents())
+	log.Printf("measurement protocol: %d of %d events accepted", accepted, len(payload.Events))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveStream attributes a request to the site whose ID is the
+// measurement_id. In single-tenant mode r is returned as is.
+func (e Env) resolveStream(w http.ResponseWriter, r *http.Request, streamID string) (*http.Request, bool) {
+	if e.Tenants == nil {
+		return r, true
+	}
+	tenant, ok := e.Tenants.Site(streamID)
+	if !ok {
+		http.Error(w, "measurement_id is not a configured site", http.StatusUnauthorized)
+		return nil, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)), true
+}
+
+// emitMeasurementEvents validates, completes and emits mapped events,
+// returning how many were accepted
+func (e Env) emitMeasurementEvents(ctx context.Context, events []event.Event) int {
+	siteID := tenantFrom(ctx).siteID()
+	accepted := 0
+	for i := range events {
+		ev := &events[i]
+		if e.Validator != nil && e.checkEvent(i, ev).Rejected() {
+			continue
+		}
+		event.EnsureEventFields(ev, e.Cfg)
+		ev.SiteID = siteID
+		e.applyExperiments(nil, nil, ev)
+		if e.Emit != nil {
+			e.Emit(ctx, *ev)
+		}
+		accepted++
+	}
+	return accepted
+}
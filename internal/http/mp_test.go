@@ -0,0 +1,103 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/measurement"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestMeasurementProtocol(t *testing.T) {
+	const body = `{"client_id":"123.456","events":[{"name":"purchase","params":{"value":9.99,"currency":"USD"}}]}`
+	newEnv := func() (Env, *[]event.Event) {
+		var emitted []event.Event
+		return Env{
+			Cfg:  config.Config{MPAPISecret: "mp-secret", MaxBodyBytes: 1 << 20, MaxDecompressedBytes: 1 << 20},
+			Emit: func(_ context.Context, e event.Event) { emitted = append(emitted, e) },
+		}, &emitted
+	}
+	post := func(h http.HandlerFunc, query, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/mp/collect?"+query, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+
+	t.Run("maps and emits events", func(t *testing.T) {
+		env, emitted := newEnv()
+		w := post(env.MeasurementProtocol, "measurement_id=G-TEST&api_secret=mp-secret", body)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		if len(*emitted) != 1 {
+			t.Fatalf("emitted %d events", len(*emitted))
+		}
+		ev := (*emitted)[0]
+		if ev.Type != "purchase" || ev.Session.VisitorID != "123.456" || ev.EventID == "" || ev.TS == "" || ev.Props["currency"] != "USD" {
+			t.Errorf("event = %+v", ev)
+		}
+	})
+
+	t.Run("invalid payloads are refused", func(t *testing.T) {
+		env, emitted := newEnv()
+		w := post(env.MeasurementProtocol, "measurement_id=G-TEST&api_secret=mp-secret", `{"events":[{"name":"click"}]}`)
+		if w.Code != http.StatusBadRequest || len(*emitted) != 0 || !strings.Contains(w.Body.String(), "VALUE_REQUIRED") {
+			t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("debug endpoint validates without emitting", func(t *testing.T) {
+		env, emitted := newEnv()
+		for payload, want := range map[string]int{body: 0, `{"client_id":"1","events":[{"name":"first_visit"}]}`: 1} {
+			w := post(env.MeasurementProtocolDebug, "measurement_id=G-TEST&api_secret=mp-secret", payload)
+			var resp struct {
+				ValidationMessages []measurement.Message `json:"validationMessages"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || resp.ValidationMessages == nil {
+				t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+			}
+			if len(resp.ValidationMessages) != want {
+				t.Errorf("messages = %+v, want %d", resp.ValidationMessages, want)
+			}
+		}
+		if len(*emitted) != 0 {
+			t.Errorf("debug emitted %d events", len(*emitted))
+		}
+	})
+
+	t.Run("access control", func(t *testing.T) {
+		env, _ := newEnv()
+		for query, want := range map[string]int{
+			"measurement_id=G-TEST":                            http.StatusUnauthorized,
+			"measurement_id=G-TEST&api_secret=wrong":           http.StatusUnauthorized,
+			"api_secret=mp-secret":                             http.StatusBadRequest,
+			"firebase_app_id=1:23:ios:ab&api_secret=mp-secret": http.StatusNoContent,
+		} {
+			if w := post(env.MeasurementProtocol, query, body); w.Code != want {
+				t.Errorf("%s: status = %d, want %d", query, w.Code, want)
+			}
+		}
+
+		env.Cfg.MPAPISecret = ""
+		if w := post(env.MeasurementProtocol, "measurement_id=G-TEST&api_secret=", body); w.Code != http.StatusNotFound {
+			t.Errorf("disabled: status = %d, want 404", w.Code)
+		}
+	})
+
+	t.Run("measurement_id selects the site", func(t *testing.T) {
+		env, emitted := newEnv()
+		env.Tenants = newTestTenants(t)
+		if w := post(env.MeasurementProtocol, "measurement_id=blog&api_secret=mp-secret", body); w.Code != http.StatusNoContent || (*emitted)[0].SiteID != "blog" {
+			t.Errorf("status = %d, emitted = %+v", w.Code, *emitted)
+		}
+		if w := post(env.MeasurementProtocol, "measurement_id=G-TEST&api_secret=mp-secret", body); w.Code != http.StatusUnauthorized {
+			t.Errorf("unknown site: status = %d, want 401", w.Code)
+		}
+	})
+}
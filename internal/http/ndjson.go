@@ -0,0 +1,171 @@
+package httpx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	event "github.com/shortontech/gotrack/pkg/event"
+)
+
+// ndjsonMaxErrors bounds the line errors listed in a /collect/ndjson response
+const ndjsonMaxErrors = 100
+
+// lineError reports why one line of an import was not accepted
+type lineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importSummary is the /collect/ndjson response
+type importSummary struct {
+	Status          string      `json:"status"` // ok, rejected or incomplete
+	Accepted        int         `json:"accepted"`
+	Rejected        int         `json:"rejected"`
+	Errors          []lineError `json:"errors,omitempty"`
+	ErrorsTruncated bool        `json:"errors_truncated,omitempty"`
+	Error           string      `json:"error,omitempty"` // why reading stopped early
+}
+
+func (s *importSummary) reject(line int, reason string) {
+	s.Rejected++
+	if len(s.Errors) < ndjsonMaxErrors {
+		s.Errors = append(s.Errors, lineError{Line: line, Error: reason})
+	} else {
+		s.ErrorsTruncated = true
+	}
+}
+
+// POST /collect/ndjson — bulk import of newline-delimited events from other
+// servers. The body is parsed one line at a time, so only a single line is
+// held in memory and bounded by MAX_BODY_BYTES; the body itself has no limit.
+func (e Env) CollectNDJSON(w http.ResponseWriter, r *http.Request) {
+	if e.Cfg.ImportToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validBearerToken(r, e.Cfg.ImportToken) {
+		http.Error(w, "invalid or missing import token", http.StatusUnauthorized)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "ndjson") && !strings.Contains(ct, "jsonl") {
+		http.Error(w, "content-type must be application/x-ndjson", http.StatusUnsupportedMediaType)
+		return
+	}
+	r, ok := e.resolveTenant(w, r)
+	if !ok {
+		return
+	}
+	defer r.Body.Close()
+
+	body, err := newDecoder(r.Header.Get("Content-Encoding"), r.Body)
+	if errors.Is(err, errUnsupportedEncoding) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid compressed body", http.StatusBadRequest)
+		return
+	}
+
+	summary := e.importEvents(r, body)
+	log.Printf("import: %d accepted, %d rejected", summary.Accepted, summary.Rejected)
+
+	code := http.StatusOK
+	switch {
+	case summary.Error != "":
+		summary.Status = "incomplete"
+		code = http.StatusBadRequest
+	case summary.Accepted == 0 && summary.Rejected > 0:
+		summary.Status = "rejected"
+		code = http.StatusUnprocessableEntity
+	default:
+		summary.Status = "ok"
+	}
+	writeJSON(w, code, summary)
+}
+
+// importEvents validates, completes and emits each line of body. Lines are
+// numbered from 1; blank lines are skipped but counted.
+func (e Env) importEvents(r *http.Request, body io.Reader) importSummary {
+	var summary importSummary
+	siteID := tenantFrom(r.Context()).siteID()
+	br := bufio.NewReaderSize(body, 64<<10)
+
+	for lineNo := 1; ; lineNo++ {
+		line, tooLong, err := readLine(br, e.Cfg.MaxBodyBytes)
+		if err != nil && !errors.Is(err, io.EOF) {
+			summary.Error = "failed to read body: " + err.Error()
+			return summary
+		}
+		line = bytes.TrimSpace(line)
+
+		switch {
+		case tooLong:
+			summary.reject(lineNo, "line exceeds MAX_BODY_BYTES")
+		case len(line) > 0:
+			if reason := e.importLine(r, lineNo, line, siteID); reason != "" {
+				summary.reject(lineNo, reason)
+			} else {
+				summary.Accepted++
+			}
+		}
+
+		if err != nil {
+			return summary
+		}
+	}
+}
+
+// importLine emits one event, returning why it was rejected, if it was.
+// Imports come from servers, so request-derived fields such as the IP and
+// user agent are not filled in.
+func (e Env) importLine(r *http.Request, lineNo int, line []byte, siteID string) string {
+	var ev event.Event
+	if err := json.Unmarshal(line, &ev); err != nil {
+		return "invalid json: " + err.Error()
+	}
+	if e.Validator != nil {
+		if res := e.checkEvent(lineNo, &ev); res.Rejected() {
+			issues := make([]string, len(res.Issues))
+			for i, issue := range res.Issues {
+				issues[i] = issue.String()
+			}
+			return "invalid event: " + strings.Join(issues, ", ")
+		}
+	}
+	event.EnsureEventFields(&ev, e.Cfg)
+	ev.SiteID = siteID
+	e.applyExperiments(nil, r, &ev)
+	if e.Emit != nil {
+		e.Emit(r.Context(), ev)
+	}
+	return ""
+}
+
+// readLine reads the next line, without limit on its length but keeping at
+// most max bytes. A longer line is consumed and reported as tooLong.
+func readLine(br *bufio.Reader, max int64) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if !tooLong {
+			if int64(len(line)+len(chunk)) > max+1 { // +1 for the newline
+				tooLong, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return line, tooLong, err
+		}
+	}
+}
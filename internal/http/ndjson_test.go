@@ -0,0 +1,165 @@
+package httpx
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/validation"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestCollectNDJSON(t *testing.T) {
+	newEnv := func() (Env, *[]event.Event) {
+		var emitted []event.Event
+		return Env{
+			Cfg:  config.Config{ImportToken: "import-secret", MaxBodyBytes: 256},
+			Emit: func(_ context.Context, e event.Event) { emitted = append(emitted, e) },
+		}, &emitted
+	}
+	post := func(env Env, body io.Reader, header http.Header) (*httptest.ResponseRecorder, importSummary) {
+		req := httptest.NewRequest(http.MethodPost, "/collect/ndjson", body)
+		req.Header.Set("Authorization", "Bearer import-secret")
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		env.CollectNDJSON(w, req)
+		var summary importSummary
+		_ = json.Unmarshal(w.Body.Bytes(), &summary)
+		return w, summary
+	}
+
+	t.Run("reports each bad line", func(t *testing.T) {
+		env, emitted := newEnv()
+		body := strings.Join([]string{
+			`{"type":"purchase","event_id":"0190b8a2-6c1e-7a3b-8f2d-1c4e5a6b7c8d"}`,
+			``,
+			`{"type":`,
+			`{"type":"click","url":{"referrer":"` + strings.Repeat("x", 300) + `"}}`,
+			`{"type":"signup"}`, // no trailing newline
+		}, "\n")
+		w, summary := post(env, strings.NewReader(body), nil)
+		if w.Code != http.StatusOK || summary.Status != "ok" {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		if summary.Accepted != 2 || summary.Rejected != 2 {
+			t.Errorf("summary = %+v, want 2 accepted and 2 rejected", summary)
+		}
+		if len(summary.Errors) != 2 || summary.Errors[0].Line != 3 || summary.Errors[1].Line != 4 ||
+			!strings.Contains(summary.Errors[1].Error, "MAX_BODY_BYTES") {
+			t.Errorf("errors = %+v", summary.Errors)
+		}
+		if len(*emitted) != 2 || (*emitted)[1].Type != "signup" || (*emitted)[1].EventID == "" {
+			t.Errorf("emitted = %+v", *emitted)
+		}
+	})
+
+	t.Run("gzip body", func(t *testing.T) {
+		env, emitted := newEnv()
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		for i := 0; i < 1000; i++ {
+			_, _ = zw.Write([]byte(`{"type":"pageview"}` + "\n"))
+		}
+		_ = zw.Close()
+		w, summary := post(env, &buf, http.Header{"Content-Encoding": {"gzip"}})
+		if w.Code != http.StatusOK || summary.Accepted != 1000 || len(*emitted) != 1000 {
+			t.Errorf("status = %d, summary = %+v", w.Code, summary)
+		}
+	})
+
+	t.Run("invalid gzip and unknown encoding", func(t *testing.T) {
+		env, _ := newEnv()
+		if w, _ := post(env, strings.NewReader("{}"), http.Header{"Content-Encoding": {"gzip"}}); w.Code != http.StatusBadRequest {
+			t.Errorf("invalid gzip: status = %d, want 400", w.Code)
+		}
+		if w, _ := post(env, strings.NewReader("{}"), http.Header{"Content-Encoding": {"deflate"}}); w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("deflate: status = %d, want 415", w.Code)
+		}
+	})
+
+	t.Run("everything rejected is 422", func(t *testing.T) {
+		env, _ := newEnv()
+		w, summary := post(env, strings.NewReader("not json\n[1,2]\n"), nil)
+		if w.Code != http.StatusUnprocessableEntity || summary.Status != "rejected" || summary.Rejected != 2 {
+			t.Errorf("status = %d, summary = %+v", w.Code, summary)
+		}
+	})
+
+	t.Run("validation rejects lines", func(t *testing.T) {
+		env, emitted := newEnv()
+		env.Validator, _ = validation.New(validation.PolicyReject, validation.Rules{Required: []string{"session.visitor_id"}})
+		_, summary := post(env, strings.NewReader(`{"session":{"visitor_id":"v1"}}`+"\n"+`{}`+"\n"), nil)
+		if summary.Accepted != 1 || len(summary.Errors) != 1 || summary.Errors[0].Error != "invalid event: session.visitor_id:required" {
+			t.Errorf("summary = %+v", summary)
+		}
+		if len(*emitted) != 1 {
+			t.Errorf("emitted %d events, want 1", len(*emitted))
+		}
+	})
+
+	t.Run("error list is capped", func(t *testing.T) {
+		env, _ := newEnv()
+		_, summary := post(env, strings.NewReader(strings.Repeat("x\n", ndjsonMaxErrors+5)), nil)
+		if summary.Rejected != ndjsonMaxErrors+5 || len(summary.Errors) != ndjsonMaxErrors || !summary.ErrorsTruncated {
+			t.Errorf("rejected = %d, errors = %d, truncated = %v", summary.Rejected, len(summary.Errors), summary.ErrorsTruncated)
+		}
+	})
+
+	t.Run("tenant site id", func(t *testing.T) {
+		env, emitted := newEnv()
+		env.Tenants = newTestTenants(t)
+		_, summary := post(env, strings.NewReader(`{"type":"click","site_id":"spoofed"}`), http.Header{"X-Gotrack-Write-Key": {"wk_blog"}})
+		if summary.Accepted != 1 || (*emitted)[0].SiteID != "blog" {
+			t.Errorf("summary = %+v, emitted = %+v", summary, *emitted)
+		}
+	})
+
+	t.Run("access control", func(t *testing.T) {
+		env, _ := newEnv()
+		req := httptest.NewRequest(http.MethodPost, "/collect/ndjson", strings.NewReader("{}"))
+		w := httptest.NewRecorder()
+		env.CollectNDJSON(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("without token: status = %d, want 401", w.Code)
+		}
+
+		env.Cfg.ImportToken = ""
+		w = httptest.NewRecorder()
+		env.CollectNDJSON(w, httptest.NewRequest(http.MethodPost, "/collect/ndjson", strings.NewReader("{}")))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("disabled: status = %d, want 404", w.Code)
+		}
+	})
+}
+
+func TestReadLine(t *testing.T) {
+	br := bufio.NewReaderSize(strings.NewReader("short\n"+strings.Repeat("y", 100)+"\nlast"), 16)
+	want := []struct {
+		line    string
+		tooLong bool
+	}{
+		{"short\n", false},
+		{"", true},
+		{"last", false},
+	}
+	for i, w := range want {
+		line, tooLong, err := readLine(br, 10)
+		if string(line) != w.line || tooLong != w.tooLong {
+			t.Errorf("line %d = %q, %v, want %q, %v", i+1, line, tooLong, w.line, w.tooLong)
+		}
+		if last := i == len(want)-1; last != (err == io.EOF) {
+			t.Errorf("line %d: err = %v", i+1, err)
+		}
+	}
+}
@@ -0,0 +1,715 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/shortontech/gotrack/internal/assets"
+	"github.com/shortontech/gotrack/internal/campaign"
+	"github.com/shortontech/gotrack/internal/conversion"
+	"github.com/shortontech/gotrack/internal/email"
+	"github.com/shortontech/gotrack/internal/outbound"
+	"github.com/shortontech/gotrack/internal/relay"
+	"github.com/shortontech/gotrack/internal/shortlink"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// openAPIPath is where the OpenAPI document is served
+const openAPIPath = "/openapi.json"
+
+// apiDocsCSP lets the API docs page load Swagger UI from unpkg and read the
+// document from this server
+const apiDocsCSP = "default-src 'none'; script-src 'self' https://unpkg.com; style-src 'unsafe-inline' https://unpkg.com; img-src 'self' data: https://unpkg.com; connect-src 'self'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'"
+
+// operation is one route in the OpenAPI document. Bodies are given as Go
+// values whose types the schemas are generated from, so the document
+// follows the handlers' request and response types.
+type operation struct {
+	path, method string
+	tag          string
+	summary      string
+	description  string
+	admin        bool // needs the admin bearer token
+	signed       bool // takes the HMAC headers, a write key or an API key
+	params       []apiParam
+	request      any      // JSON request body; nil for none
+	requestTypes []string // content types besides application/json
+	responses    []apiResponse
+}
+
+type apiParam struct {
+	name, in, description string
+	required              bool
+}
+
+type apiResponse struct {
+	status      int
+	description string
+	body        any    // JSON body; nil for none
+	contentType string // for bodies that aren't JSON
+}
+
+// ingestResponses are the errors every ingestion endpoint shares
+var ingestResponses = []apiResponse{
+	{status: http.StatusBadRequest, description: "Invalid body", contentType: "text/plain"},
+	{status: http.StatusUnauthorized, description: "Missing or invalid HMAC signature, or replayed nonce", contentType: "text/plain"},
+	{status: http.StatusRequestEntityTooLarge, description: "Body over MAX_BODY_BYTES or MAX_DECOMPRESSED_BYTES", contentType: "text/plain"},
+	{status: http.StatusTooManyRequests, description: "Rate limit exceeded", contentType: "text/plain"},
+	{status: http.StatusServiceUnavailable, description: "The instance is draining", contentType: "text/plain"},
+}
+
+// apiOperations lists the routes NewMux registers for cfg
+func apiOperations(cfg config.Config) []operation {
+	gif := apiResponse{status: http.StatusOK, description: "1x1 transparent GIF", contentType: "image/gif"}
+	script := apiResponse{status: http.StatusOK, description: "JavaScript", contentType: "application/javascript"}
+	ops := []operation{
+		{
+			path: "/px.gif", method: http.MethodGet, tag: "tracking",
+			summary:     "Record a pageview",
+			description: "Records a pageview, or the event type in e. utm_* parameters and click IDs such as gclid are read as on every request.",
+			params: []apiParam{
+				{name: pixelTypeParam, in: "query", description: "Event type; pageview when absent"},
+				{name: pixelTitleParam, in: "query", description: "Page or email title"},
+				{name: pixelURLParam, in: "query", description: "Page URL, absolute or a path; also url"},
+				{name: pixelRefParam, in: "query", description: "Referrer; also ref"},
+				{name: pixelVisitorParam, in: "query", description: "Visitor ID"},
+				{name: pixelUserParam, in: "query", description: "Application user ID"},
+				{name: pixelPropPrefix + "{name}", in: "query", description: "Custom property; up to 32"},
+			},
+			responses: []apiResponse{gif},
+		},
+		{
+			path: "/collect", method: http.MethodPost, tag: "tracking", signed: true,
+			summary:      "Send one event or an array of events",
+			description:  "Events are enriched server-side. text/plain and form bodies from navigator.sendBeacon carry the same JSON. Bodies may be gzip or br encoded.",
+			request:      []event.Event{},
+			requestTypes: []string{"text/plain", "application/x-www-form-urlencoded"},
+			responses: append([]apiResponse{
+				{status: http.StatusAccepted, description: "Accepted", body: collectResponse{}},
+				{status: http.StatusUnsupportedMediaType, description: "Unsupported content type or encoding", contentType: "text/plain"},
+				{status: http.StatusUnprocessableEntity, description: "Every event failed validation", body: collectResponse{}},
+			}, ingestResponses...),
+		},
+		{
+			path: "/collect.gif", method: http.MethodGet, tag: "tracking",
+			summary: "Send events as base64url JSON in the query string",
+			params: []apiParam{
+				{name: collectGIFDataParam, in: "query", required: true, description: "base64url-encoded /collect JSON"},
+				{name: collectGIFHMACParam, in: "query", description: "HMAC signature"},
+				{name: collectGIFTSParam, in: "query", description: "Signature timestamp"},
+				{name: collectGIFNonceParam, in: "query", description: "Signature nonce"},
+			},
+			responses: []apiResponse{gif},
+		},
+		{
+			path: "/conversion", method: http.MethodPost, tag: "tracking", signed: true,
+			summary:     "Report an order",
+			description: "Typed conversions, deduplicated by order_id within CONVERSION_DEDUP_DAYS.",
+			request:     conversion.Payload{},
+			responses: append([]apiResponse{
+				{status: http.StatusAccepted, description: "Accepted", body: conversionResponse{}},
+				{status: http.StatusOK, description: "Order already converted", body: conversionResponse{}},
+				{status: http.StatusBadRequest, description: "Invalid fields", body: conversionResponse{}},
+			}, ingestResponses...),
+		},
+		{path: "/hmac.js", method: http.MethodGet, tag: "hmac", summary: "Script that signs /collect requests in the browser", responses: []apiResponse{script}},
+		{
+			path: "/hmac/public-key", method: http.MethodGet, tag: "hmac",
+			summary: "The key and headers for signing requests",
+			responses: []apiResponse{
+				{status: http.StatusOK, description: "Signing parameters", body: hmacKeyResponse{}},
+				{status: http.StatusNotFound, description: "HMAC is not configured", contentType: "text/plain"},
+			},
+		},
+		{path: "/pixel.js", method: http.MethodGet, tag: "tracking", summary: "Tracking library (UMD)", responses: []apiResponse{script}},
+		{path: "/pixel.esm.js", method: http.MethodGet, tag: "tracking", summary: "Tracking library (ES module)", responses: []apiResponse{script}},
+		{
+			path: "/healthz", method: http.MethodGet, tag: "health",
+			summary:   "Liveness",
+			responses: []apiResponse{{status: http.StatusOK, description: "ok", contentType: "text/plain"}},
+		},
+		{
+			path: "/readyz", method: http.MethodGet, tag: "health",
+			summary: "Readiness of the sinks",
+			responses: []apiResponse{
+				{status: http.StatusOK, description: "All sinks reachable", body: readyResponse{}},
+				{status: http.StatusServiceUnavailable, description: "A sink is unavailable or the instance is draining", body: readyResponse{}},
+			},
+		},
+		{
+			path: openAPIPath, method: http.MethodGet, tag: "health",
+			summary:   "This document",
+			responses: []apiResponse{{status: http.StatusOK, description: "OpenAPI 3 document", contentType: "application/json"}},
+		},
+	}
+
+	if cfg.ExperimentsFile != "" {
+		ops = append(ops, operation{
+			path: experimentsPath, method: http.MethodGet, tag: "tracking",
+			summary:     "A visitor's experiment variants",
+			description: "Assigns the visitor on the first touch and stores the assignments in the _gt_exp cookie. Without visitor_id, the visitor cookie is used (SESSION_COOKIES).",
+			params:      []apiParam{{name: "visitor_id", in: "query", description: "Visitor to assign; the visitor cookie when absent"}},
+			responses: []apiResponse{
+				{status: http.StatusOK, description: "Variants by experiment", body: experimentsResponse{}},
+				{status: http.StatusTooManyRequests, description: "Rate limit exceeded", contentType: "text/plain"},
+			},
+		})
+	}
+	if cfg.ClusterMode {
+		ops = append(ops, operation{
+			path: clusterInfoPath, method: http.MethodGet, tag: "health",
+			summary:     "This replica's identity and routing hint",
+			description: "For load balancers and operators running several replicas. routing.key is what to hash clients on; routing.required is set when state is kept per replica.",
+			responses: []apiResponse{
+				{status: http.StatusOK, description: "Instance", body: clusterInfo{}},
+				{status: http.StatusNotFound, description: "Cluster mode is not enabled", contentType: "text/plain"},
+			},
+		})
+	}
+	if cfg.ImportToken != "" {
+		ops = append(ops, operation{
+			path: "/collect/ndjson", method: http.MethodPost, tag: "import",
+			summary:      "Bulk import of newline-delimited events",
+			description:  "Authenticated with IMPORT_TOKEN as a bearer token. Each line is one event.",
+			params:       []apiParam{{name: "Authorization", in: "header", required: true, description: "Bearer IMPORT_TOKEN"}},
+			requestTypes: []string{"application/x-ndjson"},
+			responses: []apiResponse{
+				{status: http.StatusOK, description: "Import summary", body: importSummary{}},
+				{status: http.StatusBadRequest, description: "The import stopped partway", body: importSummary{}},
+				{status: http.StatusUnprocessableEntity, description: "Every event failed validation", body: importSummary{}},
+				{status: http.StatusUnauthorized, description: "Invalid import token", contentType: "text/plain"},
+			},
+		})
+	}
+	if cfg.MPAPISecret != "" {
+		ops = append(ops, operation{
+			path: "/mp/collect", method: http.MethodPost, tag: "compatibility",
+			summary: "GA4 Measurement Protocol",
+			params: []apiParam{
+				{name: "api_secret", in: "query", required: true, description: "MP_API_SECRET"},
+				{name: "measurement_id", in: "query", description: "Web stream; or firebase_app_id"},
+			},
+			request:   map[string]any{},
+			responses: []apiResponse{{status: http.StatusNoContent, description: "Accepted"}},
+		})
+	}
+	if cfg.SegmentEnabled {
+		for _, path := range []string{"/v1/track", "/v1/page", "/v1/identify", "/v1/batch"} {
+			ops = append(ops, operation{
+				path: path, method: http.MethodPost, tag: "compatibility",
+				summary:   "Segment HTTP Tracking API",
+				request:   map[string]any{},
+				responses: []apiResponse{{status: http.StatusOK, description: "Accepted", body: map[string]bool{}}},
+			})
+		}
+	}
+
+	if cfg.EmailLinkSecret != "" {
+		linkParams := []apiParam{
+			{name: email.CampaignParam, in: "query", description: "Campaign ID"},
+			{name: email.MessageParam, in: "query", description: "Message ID"},
+			{name: email.RecipientParam, in: "query", description: "Recipient ID; not signed"},
+			{name: email.SignatureParam, in: "query", required: true, description: "Signature from gotrack email-link"},
+		}
+		ops = append(ops,
+			operation{
+				path: email.OpenPath, method: http.MethodGet, tag: "email",
+				summary:     "Email open pixel",
+				description: "Records an email_open event when the signature is valid.",
+				params:      linkParams,
+				responses:   []apiResponse{gif},
+			},
+			operation{
+				path: email.ClickPath, method: http.MethodGet, tag: "email",
+				summary: "Email click redirect",
+				params:  append(linkParams, apiParam{name: email.URLParam, in: "query", required: true, description: "Destination; signed"}),
+				responses: []apiResponse{
+					{status: http.StatusFound, description: "Redirect to the destination, after recording an email_click event"},
+					{status: http.StatusBadRequest, description: "Invalid destination", contentType: "text/plain"},
+					{status: http.StatusForbidden, description: "Invalid signature", contentType: "text/plain"},
+				},
+			},
+		)
+	}
+	if cfg.OutboundLinkSecret != "" || len(cfg.OutboundAllowedHosts) > 0 {
+		ops = append(ops, operation{
+			path: outbound.Path, method: http.MethodGet, tag: "tracking",
+			summary:     "Outbound link redirect",
+			description: "Records an outbound_click event, with the Referer as the page, and redirects to a destination on OUTBOUND_ALLOWED_HOSTS or signed with OUTBOUND_LINK_SECRET.",
+			params: []apiParam{
+				{name: outbound.URLParam, in: "query", required: true, description: "Destination"},
+				{name: outbound.SignatureParam, in: "query", description: "Signature from gotrack outbound-link; not needed for allowed hosts"},
+			},
+			responses: []apiResponse{
+				{status: http.StatusFound, description: "Redirect to the destination"},
+				{status: http.StatusBadRequest, description: "Invalid destination", contentType: "text/plain"},
+				{status: http.StatusForbidden, description: "Destination neither allowed nor signed", contentType: "text/plain"},
+			},
+		})
+	}
+	if cfg.ShortLinks {
+		ops = append(ops, operation{
+			path: shortlink.PathPrefix + "{code}", method: http.MethodGet, tag: "tracking",
+			summary:     "Short link redirect",
+			description: "Records a short_link_click event and redirects to the link's destination with its UTM parameters.",
+			params:      []apiParam{{name: "code", in: "path", required: true}},
+			responses: []apiResponse{
+				{status: http.StatusFound, description: "Redirect to the destination"},
+				{status: http.StatusNotFound, description: "No link with this code", contentType: "text/plain"},
+			},
+		})
+	}
+	if cfg.RelayAcceptToken != "" {
+		token := apiParam{name: "Authorization", in: "header", required: true, description: "Bearer RELAY_ACCEPT_TOKEN"}
+		ops = append(ops,
+			operation{
+				path: "/relay/batch", method: http.MethodPost, tag: "import",
+				summary:     "One chunk of a batch forwarded by an edge GoTrack instance",
+				description: "Chunks may be gzip or zstd encoded. The batch is emitted once every chunk has arrived.",
+				params: []apiParam{token,
+					{name: relay.HeaderBatchID, in: "header", required: true},
+					{name: relay.HeaderBatchSHA256, in: "header", required: true},
+					{name: relay.HeaderChunkIndex, in: "header", required: true},
+					{name: relay.HeaderChunkCount, in: "header", required: true},
+					{name: relay.HeaderChunkSHA256, in: "header", required: true},
+				},
+				requestTypes: []string{"application/octet-stream"},
+				responses: []apiResponse{
+					{status: http.StatusOK, description: "Chunks held so far", body: relay.Status{}},
+					{status: http.StatusBadRequest, description: "Invalid chunk or checksum mismatch", contentType: "text/plain"},
+					{status: http.StatusUnauthorized, description: "Invalid relay token", contentType: "text/plain"},
+				},
+			},
+			operation{
+				path: "/relay/batch", method: http.MethodGet, tag: "import",
+				summary:   "Chunks held for a batch, so an interrupted sender can resume",
+				params:    []apiParam{token, {name: "batch_id", in: "query", required: true}},
+				responses: []apiResponse{{status: http.StatusOK, description: "Chunks held so far", body: relay.Status{}}},
+			},
+		)
+	}
+	if cfg.AdminToken != "" {
+		actor := apiParam{name: actorHeader, in: "header", required: true, description: "Who is asking, for the audit log"}
+		sinkName := apiParam{name: "name", in: "path", required: true}
+		ops = append(ops,
+			operation{
+				path: adminPathPrefix + "admin/status", method: http.MethodGet, tag: "admin", admin: true,
+				summary:   "Sink health, queue depths and recent errors",
+				responses: []apiResponse{{status: http.StatusOK, description: "Status", body: statusResponse{}}},
+			},
+			operation{
+				path: adminPathPrefix + "admin/reload", method: http.MethodPost, tag: "admin", admin: true,
+				summary:   "Reload the configuration",
+				responses: []apiResponse{{status: http.StatusOK, description: "Reloaded", contentType: "application/json"}},
+			},
+			operation{
+				path: adminPathPrefix + "admin/drain", method: http.MethodPost, tag: "admin", admin: true,
+				summary: "Stop ingestion and flush the sinks",
+				responses: []apiResponse{
+					{status: http.StatusOK, description: "Every sink flushed", body: DrainReport{}},
+					{status: http.StatusInternalServerError, description: "A sink still holds events", body: DrainReport{}},
+				},
+			},
+			operation{
+				path: adminPathPrefix + "admin/cache/purge", method: http.MethodPost, tag: "admin", admin: true,
+				summary:   "Purge the proxy cache",
+				params:    []apiParam{{name: "prefix", in: "query", description: "Only purge paths with this prefix"}},
+				responses: []apiResponse{{status: http.StatusOK, description: "Purged", contentType: "application/json"}},
+			},
+			operation{
+				path: adminPathPrefix + "admin/clusters", method: http.MethodGet, tag: "admin", admin: true,
+				summary: "Device clusters by unique IP count",
+				params: []apiParam{
+					{name: "limit", in: "query", description: "Default 20, max 500"},
+					{name: "min_ips", in: "query", description: "Default 1"},
+				},
+				responses: []apiResponse{{status: http.StatusOK, description: "Clusters", contentType: "application/json"}},
+			},
+			operation{
+				path: realtimeStatsPath, method: http.MethodGet, tag: "admin", admin: true,
+				summary:     "Live event counts, top UTM sources and referrers and bot share",
+				description: "Counted in memory on this instance over the last 5, 15 and 60 minutes, after deduplication and sampling.",
+				params:      []apiParam{{name: "limit", in: "query", description: "Top sources and referrers; default 10, max 100"}},
+				responses:   []apiResponse{{status: http.StatusOK, description: "Counts by window", body: realtimeResponse{}}},
+			},
+			operation{
+				path: adminPathPrefix + "admin/events", method: http.MethodGet, tag: "admin", admin: true,
+				summary: "Look up stored events by ID or click ID",
+				params: []apiParam{actor,
+					{name: "event_id", in: "query"}, {name: "gclid", in: "query"}, {name: "fbclid", in: "query"}, {name: "msclkid", in: "query"},
+					{name: "limit", in: "query", description: "Default 20, max 100"},
+				},
+				responses: []apiResponse{{status: http.StatusOK, description: "Matching events", contentType: "application/json"}},
+			},
+			operation{
+				path: adminPathPrefix + "api/events", method: http.MethodGet, tag: "admin", admin: true,
+				summary: "Query recent stored events",
+				params: []apiParam{actor,
+					{name: "type", in: "query"}, {name: "visitor_id", in: "query"}, {name: "session_id", in: "query"}, {name: "ip", in: "query"},
+					{name: "since", in: "query", description: "Duration before now, such as 1h or 7d, or an RFC3339 time"},
+					{name: "until", in: "query", description: "As since"},
+					{name: "cursor", in: "query", description: "next_cursor of the previous page"},
+					{name: "limit", in: "query", description: "Default 50, max 500"},
+					{name: "format", in: "query", description: "ndjson for newline-delimited events"},
+				},
+				responses: []apiResponse{{status: http.StatusOK, description: "One page of events", body: eventsPage{}}},
+			},
+			operation{
+				path: adminPathPrefix + "api/export", method: http.MethodGet, tag: "admin", admin: true,
+				summary: "Export a data subject's events",
+				params: []apiParam{actor,
+					{name: "visitor_id", in: "query"}, {name: "ip", in: "query", description: "As stored under IP_PRIVACY_MODE"},
+					{name: "since", in: "query"}, {name: "until", in: "query"},
+					{name: "format", in: "query", description: "ndjson (default) or csv"},
+				},
+				responses: []apiResponse{{status: http.StatusOK, description: "Every matching event", contentType: "application/x-ndjson"}},
+			},
+			operation{
+				path: adminPathPrefix + "admin/campaign-url", method: http.MethodGet, tag: "admin", admin: true,
+				summary:   "Check how a campaign link is parsed",
+				params:    []apiParam{{name: "url", in: "query", required: true}},
+				responses: []apiResponse{{status: http.StatusOK, description: "Report", body: campaign.Report{}}},
+			},
+			operation{
+				path: adminSinksPath, method: http.MethodGet, tag: "admin", admin: true,
+				summary:   "List the sinks with their status and delivery counts",
+				responses: []apiResponse{{status: http.StatusOK, description: "Sinks", body: []sinkControlStatus{}}},
+			},
+			operation{
+				path: adminSinksPath, method: http.MethodPost, tag: "admin", admin: true,
+				summary:     "Add a log sink for debugging",
+				description: "Writes every routed event as NDJSON to stdout or to a file in the temp directory until removed or restarted.",
+				request:     addSinkRequest{},
+				responses: []apiResponse{
+					{status: http.StatusCreated, description: "Added", body: sinkControlStatus{}},
+					{status: http.StatusBadRequest, description: "Invalid type, name or path", contentType: "text/plain"},
+					{status: http.StatusConflict, description: "Name already in use", contentType: "text/plain"},
+				},
+			},
+			operation{
+				path: adminSinksPath + "/{name}", method: http.MethodDelete, tag: "admin", admin: true,
+				summary: "Remove a sink added for debugging",
+				params:  []apiParam{sinkName},
+				responses: []apiResponse{
+					{status: http.StatusNoContent, description: "Removed"},
+					{status: http.StatusConflict, description: "Configured sinks can only be paused", contentType: "text/plain"},
+				},
+			},
+			operation{
+				path: adminSinksPath + "/{name}/pause", method: http.MethodPost, tag: "admin", admin: true,
+				summary:     "Stop sending events to a sink",
+				description: "Events routed to a paused sink are counted as skipped and not delivered later. Pausing lasts until resumed or restarted.",
+				params:      []apiParam{sinkName},
+				responses:   []apiResponse{{status: http.StatusOK, description: "Paused", body: sinkControlStatus{}}},
+			},
+			operation{
+				path: adminSinksPath + "/{name}/resume", method: http.MethodPost, tag: "admin", admin: true,
+				summary:   "Send events to a paused sink again",
+				params:    []apiParam{sinkName},
+				responses: []apiResponse{{status: http.StatusOK, description: "Resumed", body: sinkControlStatus{}}},
+			},
+			operation{
+				path: adminSinksPath + "/{name}/flush", method: http.MethodPost, tag: "admin", admin: true,
+				summary: "Write out the events a sink buffers",
+				params:  []apiParam{sinkName},
+				responses: []apiResponse{
+					{status: http.StatusOK, description: "Flushed", body: sinkFlushResponse{}},
+					{status: http.StatusBadGateway, description: "The flush failed", contentType: "text/plain"},
+				},
+			},
+		)
+		if cfg.ShortLinks {
+			code := apiParam{name: "code", in: "path", required: true}
+			ops = append(ops,
+				operation{
+					path: adminShortLinksPath, method: http.MethodPost, tag: "admin", admin: true,
+					summary:     "Create a short link",
+					description: "Without a code a random one is generated. {code} in a UTM value is replaced by the code.",
+					request:     shortlink.Link{},
+					responses: []apiResponse{
+						{status: http.StatusCreated, description: "Created", body: shortLinkResponse{}},
+						{status: http.StatusBadRequest, description: "Invalid code, destination or write key", contentType: "text/plain"},
+						{status: http.StatusConflict, description: "Code already in use", contentType: "text/plain"},
+					},
+				},
+				operation{
+					path: adminShortLinksPath + "/{code}", method: http.MethodGet, tag: "admin", admin: true,
+					summary:   "Get a short link",
+					params:    []apiParam{code},
+					responses: []apiResponse{{status: http.StatusOK, description: "Link", body: shortLinkResponse{}}},
+				},
+				operation{
+					path: adminShortLinksPath + "/{code}", method: http.MethodDelete, tag: "admin", admin: true,
+					summary:   "Delete a short link",
+					params:    []apiParam{code},
+					responses: []apiResponse{{status: http.StatusNoContent, description: "Deleted"}},
+				},
+			)
+		}
+		ops = append(ops,
+			operation{
+				path: adminPathPrefix + "debug/tail", method: http.MethodGet, tag: "admin", admin: true,
+				summary: "Stream events as they arrive",
+				params: []apiParam{
+					{name: "type", in: "query"}, {name: "visitor_id", in: "query"},
+					{name: "ip", in: "query", description: "Address or CIDR range"},
+				},
+				responses: []apiResponse{{status: http.StatusOK, description: "Server-sent events", contentType: "text/event-stream"}},
+			},
+		)
+	}
+	return ops
+}
+
+// openAPIDocument builds the OpenAPI 3 document for the routes enabled by cfg
+func openAPIDocument(cfg config.Config) map[string]any {
+	schemas := &schemaBuilder{schemas: map[string]any{}, names: map[reflect.Type]string{}}
+	paths := map[string]map[string]any{}
+	for _, op := range apiOperations(cfg) {
+		if paths[op.path] == nil {
+			paths[op.path] = map[string]any{}
+		}
+		paths[op.path][strings.ToLower(op.method)] = op.document(schemas)
+	}
+
+	description := "GoTrack's tracking, health and admin endpoints."
+	if cfg.TrackingPathPrefix != "" {
+		description += " The tracking endpoints and scripts are also served under " + cfg.TrackingPathPrefix + "."
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "GoTrack",
+			"description": description,
+			"version":     "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas.schemas,
+			"securitySchemes": map[string]any{
+				"admin": map[string]any{"type": "http", "scheme": "bearer", "description": "ADMIN_TOKEN"},
+			},
+		},
+	}
+}
+
+func (op operation) document(schemas *schemaBuilder) map[string]any {
+	doc := map[string]any{"summary": op.summary, "tags": []string{op.tag}}
+	if op.description != "" {
+		doc["description"] = op.description
+	}
+	params := op.params
+	if op.signed {
+		params = append(params,
+			apiParam{name: hmacHeader, in: "header", description: "Hex HMAC-SHA256 of \"<ts>\\n<nonce>\\n<body>\"; not needed with an API key"},
+			apiParam{name: timestampHeader, in: "header", description: "Unix seconds"},
+			apiParam{name: nonceHeader, in: "header", description: "16-64 random URL-safe characters"},
+			apiParam{name: writeKeyHeader, in: "header", description: "Tenant write key, with TENANTS_FILE"},
+			apiParam{name: "Authorization", in: "header", description: "Bearer API key, with API_KEYS_FILE"},
+		)
+	}
+	if len(params) > 0 {
+		list := make([]map[string]any, len(params))
+		for i, p := range params {
+			list[i] = map[string]any{"name": p.name, "in": p.in, "required": p.required, "schema": map[string]any{"type": "string"}}
+			if p.description != "" {
+				list[i]["description"] = p.description
+			}
+		}
+		doc["parameters"] = list
+	}
+	if op.request != nil || len(op.requestTypes) > 0 {
+		content := map[string]any{}
+		if op.request != nil {
+			body := schemas.schema(reflect.TypeOf(op.request))
+			if reflect.TypeOf(op.request).Kind() == reflect.Slice {
+				// A single event is accepted as well as an array
+				body = map[string]any{"oneOf": []any{schemas.schema(reflect.TypeOf(op.request).Elem()), body}}
+			}
+			content["application/json"] = map[string]any{"schema": body}
+		}
+		for _, ct := range op.requestTypes {
+			content[ct] = map[string]any{"schema": map[string]any{"type": "string"}}
+		}
+		doc["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+	responses := map[string]any{}
+	for _, resp := range op.responses {
+		if _, ok := responses[itoa(resp.status)]; ok {
+			continue // an operation's own answer wins over the shared one
+		}
+		r := map[string]any{"description": resp.description}
+		switch {
+		case resp.body != nil:
+			r["content"] = map[string]any{"application/json": map[string]any{"schema": schemas.schema(reflect.TypeOf(resp.body))}}
+		case resp.contentType != "":
+			r["content"] = map[string]any{resp.contentType: map[string]any{"schema": map[string]any{"type": "string"}}}
+		}
+		responses[itoa(resp.status)] = r
+	}
+	if op.admin {
+		doc["security"] = []map[string][]string{{"admin": {}}}
+		responses["401"] = map[string]any{"description": "Invalid or missing admin token"}
+	}
+	doc["responses"] = responses
+	return doc
+}
+
+// schemaBuilder generates schemas from Go types, the way encoding/json
+// encodes them. Named structs become components referenced by name.
+type schemaBuilder struct {
+	schemas map[string]any
+	names   map[reflect.Type]string
+}
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	switch t {
+	case rawMessageType:
+		return map[string]any{}
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		return b.ref(t)
+	}
+	return map[string]any{}
+}
+
+// ref registers a named struct as a component and refers to it
+func (b *schemaBuilder) ref(t reflect.Type) map[string]any {
+	name, ok := b.names[t]
+	if !ok {
+		name = exportedName(t.Name())
+		if _, taken := b.schemas[name]; taken {
+			name = exportedName(t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]) + name
+		}
+		b.names[t] = name
+		b.schemas[name] = map[string]any{} // placeholder, for types that refer to themselves
+		b.schemas[name] = b.object(t)
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	b.fields(t, props)
+	return map[string]any{"type": "object", "properties": props}
+}
+
+// fields adds t's JSON fields to props, flattening embedded structs
+func (b *schemaBuilder) fields(t reflect.Type, props map[string]any) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				b.fields(ft, props)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(opts, "string") {
+			props[name] = map[string]any{"type": "string"}
+			continue
+		}
+		props[name] = b.schema(f.Type)
+	}
+}
+
+// exportedName capitalizes an unexported type name, e.g. collectResponse
+func exportedName(name string) string {
+	if name == "" {
+		return name
+	}
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// OpenAPI serves the OpenAPI 3 document for the routes this server has
+// enabled at /openapi.json
+func (e Env) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, openAPIDocument(e.Cfg))
+}
+
+// AdminAPIDocs serves Swagger UI for /openapi.json at /_gotrack/admin/docs/.
+// Like the dashboard, the page holds no data and is served without the
+// token; Swagger UI's Authorize button takes it for admin calls.
+func (e Env) AdminAPIDocs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var content []byte
+	switch r.URL.Path {
+	case adminPathPrefix + "admin/docs/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		content = assets.APIDocsHTML
+	case adminPathPrefix + "admin/docs/docs.js":
+		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+		content = assets.APIDocsJS
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Security-Policy", apiDocsCSP)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(content)
+	}
+}
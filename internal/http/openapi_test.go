@@ -0,0 +1,178 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/experiment"
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/internal/relay"
+	"github.com/shortontech/gotrack/internal/session"
+	"github.com/shortontech/gotrack/internal/shortlink"
+	cfg "github.com/shortontech/gotrack/pkg/config"
+)
+
+// fullConfig enables every optional route
+var fullConfig = cfg.Config{
+	AdminToken:           "admin",
+	ImportToken:          "import",
+	MPAPISecret:          "mp",
+	SegmentEnabled:       true,
+	RelayAcceptToken:     "relay",
+	EmailLinkSecret:      "email",
+	OutboundAllowedHosts: []string{"partner.example"},
+	ShortLinks:           true,
+	ClusterMode:          true,
+	ExperimentsFile:      "experiments.json",
+	MaxBodyBytes:         1 << 20,
+}
+
+// TestOpenAPI_RoutesServed keeps the document in step with NewMux: every
+// documented operation is routed to a handler
+func TestOpenAPI_RoutesServed(t *testing.T) {
+	links := shortlink.NewStore(kv.NewMemoryStore())
+	if _, err := links.Create(context.Background(), shortlink.Link{Code: "docs", Destination: "https://shop.example/"}); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewMux(Env{
+		Cfg:         fullConfig,
+		HMACAuth:    NewHMACAuth("secret", ""),
+		Relay:       relay.NewAssembler(time.Minute, 10),
+		Inspector:   NewInspector(10, nil),
+		ShortLinks:  links,
+		Cluster:     &Cluster{StateBackend: kv.BackendMemory},
+		Experiments: NewExperiments(&experiment.Assigner{}, session.Config{}),
+	})
+	for _, op := range apiOperations(fullConfig) {
+		w := httptest.NewRecorder()
+		path := strings.NewReplacer("{code}", "docs", "{name}", "log").Replace(op.path)
+		handler.ServeHTTP(w, httptest.NewRequest(op.method, path, strings.NewReader("{}")))
+		if w.Code == http.StatusNotFound || w.Code == http.StatusMethodNotAllowed {
+			t.Errorf("%s %s = %d, want the documented handler", op.method, op.path, w.Code)
+		}
+	}
+}
+
+func TestOpenAPI_OptionalRoutes(t *testing.T) {
+	minimal := sortedPaths(openAPIDocument(cfg.Config{}))
+	for _, path := range []string{"/_gotrack/admin/status", "/collect/ndjson", "/mp/collect", "/v1/track", "/relay/batch", "/cluster/info", "/experiments"} {
+		if slices.Contains(minimal, path) {
+			t.Errorf("%s documented without being enabled", path)
+		}
+	}
+	full := sortedPaths(openAPIDocument(fullConfig))
+	for _, path := range []string{"/collect", "/px.gif", "/hmac/public-key", "/healthz", "/_gotrack/admin/status", "/relay/batch"} {
+		if !slices.Contains(full, path) {
+			t.Errorf("%s not documented; paths = %v", path, full)
+		}
+	}
+}
+
+func TestOpenAPI_Handler(t *testing.T) {
+	handler := NewMux(Env{Cfg: cfg.Config{AdminToken: "admin"}})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json = %d", w.Code)
+	}
+	var doc struct {
+		OpenAPI string                               `json:"openapi"`
+		Paths   map[string]map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("openapi = %q", doc.OpenAPI)
+	}
+	status := doc.Paths["/_gotrack/admin/status"]["get"]
+	if status["security"] == nil {
+		t.Errorf("admin operation without security: %v", status)
+	}
+	if doc.Paths["/healthz"]["get"]["security"] != nil {
+		t.Error("/healthz requires auth")
+	}
+}
+
+func TestSchemaBuilder(t *testing.T) {
+	b := &schemaBuilder{schemas: map[string]any{}, names: map[reflect.Type]string{}}
+	ref := b.schema(reflect.TypeOf(collectResponse{}))
+	if ref["$ref"] != "#/components/schemas/CollectResponse" {
+		t.Fatalf("schema = %v, want a reference", ref)
+	}
+	props := b.schemas["CollectResponse"].(map[string]any)["properties"].(map[string]any)
+	for _, name := range []string{"accepted", "status", "rejected", "results"} {
+		if _, ok := props[name]; !ok {
+			t.Errorf("property %q missing from %v", name, props)
+		}
+	}
+	if got := props["rejected"].(map[string]any)["type"]; got != "integer" {
+		t.Errorf("rejected type = %v, want integer", got)
+	}
+
+	type inner struct {
+		When time.Time `json:"when"`
+	}
+	type outer struct {
+		inner
+		Raw    json.RawMessage   `json:"raw"`
+		Data   []byte            `json:"data"`
+		Tags   map[string]string `json:"tags"`
+		Hidden string            `json:"-"`
+	}
+	b.schema(reflect.TypeOf(outer{}))
+	props = b.schemas["Outer"].(map[string]any)["properties"].(map[string]any)
+	var names []string
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"data", "raw", "tags", "when"}; !slices.Equal(names, want) {
+		t.Errorf("properties = %v, want %v", names, want)
+	}
+	if got := props["when"].(map[string]any)["format"]; got != "date-time" {
+		t.Errorf("time format = %v", got)
+	}
+}
+
+func TestAdminAPIDocs(t *testing.T) {
+	if w := serveGet(NewMux(Env{}), "/_gotrack/admin/docs/"); w.Code != http.StatusNotFound {
+		t.Errorf("docs without ADMIN_TOKEN = %d, want 404", w.Code)
+	}
+	handler := NewMux(Env{Cfg: cfg.Config{AdminToken: "admin"}})
+	w := serveGet(handler, "/_gotrack/admin/docs/")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "swagger-ui") {
+		t.Fatalf("GET docs = %d", w.Code)
+	}
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "https://unpkg.com") {
+		t.Errorf("CSP = %q", csp)
+	}
+	if w := serveGet(handler, "/_gotrack/admin/docs/docs.js"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/openapi.json") {
+		t.Errorf("GET docs.js = %d", w.Code)
+	}
+}
+
+func serveGet(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+// sortedPaths lists the document's paths
+func sortedPaths(doc map[string]any) []string {
+	paths := doc["paths"].(map[string]map[string]any)
+	list := make([]string, 0, len(paths))
+	for p := range paths {
+		list = append(list, p)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,63 @@
+package httpx
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/shortontech/gotrack/internal/outbound"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// OutboundClickEvent is the event type of /r redirects
+const OutboundClickEvent = "outbound_click"
+
+// GET /r?u=... — records a click on a link to another site and redirects
+// to it, for pages where the click can't be sent from JavaScript. Only
+// destinations on OUTBOUND_ALLOWED_HOSTS or signed with OUTBOUND_LINK_SECRET
+// are followed. The visitor is redirected even when the click isn't recorded.
+func (e Env) OutboundRedirect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	policy, err := outbound.NewPolicy(e.Cfg.OutboundLinkSecret, e.Cfg.OutboundAllowedHosts)
+	if err != nil {
+		http.Error(w, "outbound links misconfigured", http.StatusInternalServerError)
+		return
+	}
+	dest, err := policy.Destination(r.URL.Query())
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, outbound.ErrNotAllowed) {
+			code = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	// HEAD requests come from link checkers, not visitors
+	if r.Method == http.MethodGet {
+		e.recordLinkEvent(w, r, outboundEvent(r, dest))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, dest.String(), http.StatusFound)
+}
+
+// outboundEvent builds the event of an outbound click: the page is where
+// the link was, from the Referer header, and the destination goes in props
+func outboundEvent(r *http.Request, dest *url.URL) event.Event {
+	ev := event.Event{
+		Type: OutboundClickEvent,
+		Props: map[string]string{
+			"outbound_url":    dest.String(),
+			"outbound_domain": dest.Hostname(),
+		},
+	}
+	if page, err := url.Parse(r.Referer()); err == nil && page.Host != "" {
+		ev.Route.Domain = page.Hostname()
+		ev.Route.Protocol = page.Scheme
+		ev.Route.Path = page.Path
+		ev.Route.FullPath = page.RequestURI()
+	}
+	return ev
+}
@@ -0,0 +1,106 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/internal/outbound"
+	"github.com/shortontech/gotrack/internal/shortlink"
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestOutboundRedirect(t *testing.T) {
+	var emitted []event.Event
+	env := Env{
+		Cfg:  cfg.Config{OutboundLinkSecret: "secret", OutboundAllowedHosts: []string{"partner.example"}},
+		Emit: func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) },
+	}
+	handler := NewMux(env)
+	serve := func(h http.Handler, method, target string) *httptest.ResponseRecorder {
+		emitted = nil
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Referer", "https://blog.example/post?id=7")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("allowed host", func(t *testing.T) {
+		dest := "https://partner.example/offer?ref=blog"
+		w := serve(handler, http.MethodGet, "/r?u="+url.QueryEscape(dest))
+		if w.Code != http.StatusFound || w.Header().Get("Location") != dest {
+			t.Fatalf("status = %d, location = %q", w.Code, w.Header().Get("Location"))
+		}
+		if len(emitted) != 1 {
+			t.Fatalf("emitted = %d, want 1", len(emitted))
+		}
+		ev := emitted[0]
+		if ev.Type != OutboundClickEvent || ev.Props["outbound_url"] != dest || ev.Props["outbound_domain"] != "partner.example" {
+			t.Errorf("event = %+v", ev)
+		}
+		if ev.Route.Domain != "blog.example" || ev.Route.FullPath != "/post?id=7" {
+			t.Errorf("route = %+v, want the linking page", ev.Route)
+		}
+	})
+
+	t.Run("signed link", func(t *testing.T) {
+		policy, _ := outbound.NewPolicy("secret", nil)
+		link, _ := policy.URL("", "https://anywhere.example/")
+		if w := serve(handler, http.MethodGet, link); w.Code != http.StatusFound || len(emitted) != 1 {
+			t.Errorf("status = %d, emitted = %d", w.Code, len(emitted))
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		for target, want := range map[string]int{
+			"/r?u=" + url.QueryEscape("https://evil.example/"): http.StatusForbidden,
+			"/r?u=" + url.QueryEscape("javascript:alert(1)"):   http.StatusBadRequest,
+			"/r": http.StatusBadRequest,
+		} {
+			w := serve(handler, http.MethodGet, target)
+			if w.Code != want || w.Header().Get("Location") != "" || len(emitted) != 0 {
+				t.Errorf("GET %s = %d (location %q, %d events), want %d", target, w.Code, w.Header().Get("Location"), len(emitted), want)
+			}
+		}
+	})
+
+	t.Run("HEAD redirects without recording", func(t *testing.T) {
+		if w := serve(handler, http.MethodHead, "/r?u="+url.QueryEscape("https://partner.example/")); w.Code != http.StatusFound || len(emitted) != 0 {
+			t.Errorf("status = %d, emitted = %d", w.Code, len(emitted))
+		}
+	})
+}
+
+// TestLinkPaths_Proxy tests that the link endpoints only shadow the proxied
+// site's pages when they are enabled
+func TestLinkPaths_Proxy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Origin", "yes")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer origin.Close()
+
+	for _, tt := range []struct {
+		name    string
+		cfg     cfg.Config
+		links   *shortlink.Store
+		proxied bool
+	}{
+		{"disabled", cfg.Config{ForwardDestination: origin.URL}, nil, true},
+		{"enabled", cfg.Config{ForwardDestination: origin.URL, OutboundAllowedHosts: []string{"partner.example"}, EmailLinkSecret: "s"}, shortlink.NewStore(kv.NewMemoryStore()), false},
+	} {
+		handler := NewMux(Env{Cfg: tt.cfg, ShortLinks: tt.links})
+		for _, path := range []string{"/r", "/e/c", "/e/o.gif", "/s/code"} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			if proxied := w.Header().Get("X-Origin") == "yes"; proxied != tt.proxied {
+				t.Errorf("%s: GET %s proxied = %v (status %d), want %v", tt.name, path, proxied, w.Code, tt.proxied)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// aliasedPaths are the browser-facing endpoints that are also served under
+// TRACKING_PATH_PREFIX, where blocklists matching the default paths miss them
+var aliasedPaths = []string{
+	"/px.gif",
+	"/collect",
+	"/collect.gif",
+	"/hmac.js",
+	"/hmac/public-key",
+	"/pixel.js",
+	"/pixel.umd.js",
+	"/pixel.esm.js",
+}
+
+// ValidatePathPrefix checks a TRACKING_PATH_PREFIX. It must start with a
+// slash, not end with one, and hold only letters, digits, '/', '-', '_' and
+// '.', since it is written into HTML attributes and scripts unescaped.
+func ValidatePathPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if !strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("path prefix %q must start with / and not end with one", prefix)
+	}
+	for _, c := range prefix {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("/-_.", c)) {
+			return fmt.Errorf("path prefix %q contains %q", prefix, c)
+		}
+	}
+	if strings.HasPrefix(prefix+"/", adminPathPrefix) {
+		return fmt.Errorf("path prefix %q overlaps the admin API", prefix)
+	}
+	return nil
+}
+
+// aliasTrackingPaths serves prefix+path as path for each aliased endpoint.
+// The default paths keep working for existing snippets.
+func aliasTrackingPaths(prefix string, next http.Handler) http.Handler {
+	if prefix == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path, ok := strings.CutPrefix(r.URL.Path, prefix); ok && slices.Contains(aliasedPaths, path) {
+			r2 := new(http.Request)
+			*r2 = *r
+			u := *r.URL
+			u.Path, u.RawPath = path, ""
+			r2.URL = &u
+			r = r2
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// endpointScript points a library loaded by script tag at the aliased
+// /collect, unless the page already chose an endpoint. It is empty without
+// a prefix.
+func endpointScript(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	return `window.GO_TRACK_URL=window.GO_TRACK_URL||"` + prefix + `/collect";` + "\n"
+}
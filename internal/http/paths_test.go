@@ -0,0 +1,65 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestValidatePathPrefix(t *testing.T) {
+	for _, ok := range []string{"", "/assets/a7f3", "/s", "/static/v1.2_x-y"} {
+		if err := ValidatePathPrefix(ok); err != nil {
+			t.Errorf("ValidatePathPrefix(%q) = %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"/", "assets/a7f3", "/assets/", "/a b", `/a"b`, "/a?b", "/_gotrack", "/_gotrack/x"} {
+		if err := ValidatePathPrefix(bad); err == nil {
+			t.Errorf("ValidatePathPrefix(%q) should fail", bad)
+		}
+	}
+}
+
+func TestTrackingPathAliases(t *testing.T) {
+	var emitted []event.Event
+	handler := NewMux(Env{
+		Cfg:  config.Config{MaxBodyBytes: 1 << 20, TrackingPathPrefix: "/assets/a7f3"},
+		Emit: func(_ context.Context, e event.Event) { emitted = append(emitted, e) },
+	})
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(http.MethodPost, "/assets/a7f3/collect", `{"type":"click"}`); w.Code != http.StatusAccepted {
+		t.Errorf("aliased /collect status = %d, want 202", w.Code)
+	}
+	if w := serve(http.MethodGet, "/assets/a7f3/px.gif?e=pageview", ""); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" {
+		t.Errorf("aliased /px.gif status = %d, type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if len(emitted) != 2 {
+		t.Errorf("emitted %d events, want 2", len(emitted))
+	}
+
+	w := serve(http.MethodGet, "/assets/a7f3/pixel.js", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), `window.GO_TRACK_URL=window.GO_TRACK_URL||"/assets/a7f3/collect";`) {
+		t.Errorf("aliased /pixel.js status = %d, should point the library at the aliased /collect", w.Code)
+	}
+
+	if w := serve(http.MethodPost, "/collect", `{"type":"click"}`); w.Code != http.StatusAccepted {
+		t.Errorf("default /collect status = %d, want 202", w.Code)
+	}
+	if w := serve(http.MethodGet, "/assets/a7f3/healthz", ""); w.Code != http.StatusNotFound {
+		t.Errorf("non-aliased endpoint status = %d, want 404", w.Code)
+	}
+}
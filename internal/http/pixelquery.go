@@ -0,0 +1,83 @@
+package httpx
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Query parameters of GET /px.gif. Campaign parameters such as utm_source
+// and gclid are read by enrichment as on every request.
+const (
+	pixelTypeParam     = "e"   // event type; pageview when absent
+	pixelTitleParam    = "t"   // page or email title
+	pixelURLParam      = "u"   // page URL, absolute or a path; url in injected pixels
+	pixelRefParam      = "r"   // referrer, for pages that can't rely on the Referer header; ref in AMP pixels
+	pixelVisitorParam  = "vid" // visitor ID, such as a hashed recipient in an email
+	pixelUserParam     = "uid" // application user ID
+	pixelPropPrefix    = "p."  // p.<name>=<value> custom properties
+	maxPixelProps      = 32
+	maxPixelValueBytes = 512
+	maxPixelURLBytes   = 2048
+)
+
+// pixelEvent builds the /px.gif event from its query string, so <noscript>
+// images and email opens can carry more than a bare pageview
+func pixelEvent(q url.Values) event.Event {
+	ev := event.Event{Type: "pageview"}
+	if typ := pixelValue(q, maxPixelValueBytes, pixelTypeParam); typ != "" {
+		ev.Type = typ
+	}
+	ev.Route.Title = pixelValue(q, maxPixelValueBytes, pixelTitleParam)
+	if raw := pixelValue(q, maxPixelURLBytes, pixelURLParam, "url"); raw != "" {
+		if u, err := url.Parse(raw); err == nil && (u.IsAbs() || strings.HasPrefix(u.Path, "/")) {
+			ev.Route.Domain = u.Hostname()
+			ev.Route.Protocol = u.Scheme
+			ev.Route.Path = u.Path
+			ev.Route.FullPath = u.RequestURI()
+			ev.Route.Hash = u.Fragment
+			// The landing page's campaign parameters, which a static or
+			// injected pixel can only pass along inside its URL
+			event.ApplyCampaignParams(u.Query(), &ev)
+		}
+	}
+	if ref := pixelValue(q, maxPixelURLBytes, pixelRefParam, "ref"); ref != "" {
+		ev.URL.Referrer = ref
+		if u, err := url.Parse(ref); err == nil {
+			ev.URL.ReferrerHostname = u.Hostname()
+		}
+	}
+	ev.Session.VisitorID = pixelValue(q, maxPixelValueBytes, pixelVisitorParam)
+	ev.Session.UserID = pixelValue(q, maxPixelValueBytes, pixelUserParam)
+
+	for key := range q {
+		name, ok := strings.CutPrefix(key, pixelPropPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		if len(ev.Props) == maxPixelProps {
+			break
+		}
+		if ev.Props == nil {
+			ev.Props = map[string]string{}
+		}
+		ev.Props[name] = pixelValue(q, maxPixelValueBytes, key)
+	}
+	return ev
+}
+
+// pixelValue returns the first of keys that is set, trimmed and cut to
+// limit bytes
+func pixelValue(q url.Values, limit int, keys ...string) string {
+	var v string
+	for _, key := range keys {
+		if v = strings.TrimSpace(q.Get(key)); v != "" {
+			break
+		}
+	}
+	if len(v) > limit {
+		v = strings.ToValidUTF8(v[:limit], "")
+	}
+	return v
+}
@@ -0,0 +1,115 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/validation"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestPixelQuery(t *testing.T) {
+	get := func(env Env, params url.Values) (*httptest.ResponseRecorder, []event.Event) {
+		var emitted []event.Event
+		env.Emit = func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) }
+		w := httptest.NewRecorder()
+		env.Pixel(w, httptest.NewRequest(http.MethodGet, "/px.gif?"+params.Encode(), nil))
+		return w, emitted
+	}
+
+	t.Run("maps the parameters", func(t *testing.T) {
+		w, emitted := get(Env{}, url.Values{
+			"e":            {"email_open"},
+			"t":            {" Spring sale "},
+			"u":            {"https://shop.example/sale?x=1#top"},
+			"r":            {"https://mail.example/inbox"},
+			"vid":          {"rcpt-42"},
+			"uid":          {"user-7"},
+			"p.campaign":   {"spring"},
+			"p.":           {"ignored"},
+			"utm_source":   {"newsletter"},
+			"unrecognized": {"ignored"},
+		})
+		if w.Code != http.StatusOK || len(emitted) != 1 {
+			t.Fatalf("status = %d, emitted = %d", w.Code, len(emitted))
+		}
+		ev := emitted[0]
+		if ev.Type != "email_open" || ev.Route.Title != "Spring sale" {
+			t.Errorf("type = %q, title = %q", ev.Type, ev.Route.Title)
+		}
+		if ev.Route.Domain != "shop.example" || ev.Route.Path != "/sale" || ev.Route.FullPath != "/sale?x=1" || ev.Route.Hash != "top" || ev.Route.Protocol != "https" {
+			t.Errorf("route = %+v", ev.Route)
+		}
+		if ev.URL.Referrer != "https://mail.example/inbox" || ev.URL.ReferrerHostname != "mail.example" {
+			t.Errorf("referrer = %q (%q)", ev.URL.Referrer, ev.URL.ReferrerHostname)
+		}
+		if ev.Session.VisitorID != "rcpt-42" || ev.Session.UserID != "user-7" {
+			t.Errorf("session = %+v", ev.Session)
+		}
+		if len(ev.Props) != 1 || ev.Props["campaign"] != "spring" {
+			t.Errorf("props = %v", ev.Props)
+		}
+		if ev.URL.UTM.Source != "newsletter" {
+			t.Errorf("utm_source = %q, want newsletter", ev.URL.UTM.Source)
+		}
+	})
+
+	t.Run("injected and AMP pixels", func(t *testing.T) {
+		_, emitted := get(Env{}, url.Values{
+			"e": {"pageview"}, "auto": {"1"}, "amp": {"1"},
+			"url": {"/lander?utm_source=google&gclid=abc"},
+			"ref": {"https://www.google.com/"},
+		})
+		if len(emitted) != 1 {
+			t.Fatalf("emitted = %d", len(emitted))
+		}
+		ev := emitted[0]
+		if ev.Route.Path != "/lander" || ev.Route.Domain != "" || ev.URL.ReferrerHostname != "www.google.com" {
+			t.Errorf("route = %+v, referrer = %q", ev.Route, ev.URL.Referrer)
+		}
+		if ev.URL.UTM.Source != "google" || ev.URL.Google.GCLID != "abc" {
+			t.Errorf("attribution from the page URL = %+v, gclid %q", ev.URL.UTM, ev.URL.Google.GCLID)
+		}
+	})
+
+	t.Run("bare pixel is a pageview", func(t *testing.T) {
+		_, emitted := get(Env{}, url.Values{"u": {"not a url"}})
+		if len(emitted) != 1 || emitted[0].Type != "pageview" || emitted[0].Route.Domain != "" || emitted[0].Props != nil {
+			t.Errorf("emitted = %+v", emitted)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		params := url.Values{"t": {strings.Repeat("é", maxPixelValueBytes)}}
+		for i := range maxPixelProps + 10 {
+			params.Set("p.k"+strconv.Itoa(i), "v")
+		}
+		_, emitted := get(Env{}, params)
+		if len(emitted) != 1 {
+			t.Fatalf("emitted = %d", len(emitted))
+		}
+		if title := emitted[0].Route.Title; len(title) > maxPixelValueBytes || !strings.HasPrefix(title, "éé") || strings.ContainsRune(title, '�') {
+			t.Errorf("title of %d bytes not cut cleanly", len(title))
+		}
+		if len(emitted[0].Props) != maxPixelProps {
+			t.Errorf("props = %d, want %d", len(emitted[0].Props), maxPixelProps)
+		}
+	})
+
+	t.Run("validation rejects but still answers the pixel", func(t *testing.T) {
+		env := Env{}
+		env.Validator, _ = validation.New(validation.PolicyReject, validation.Rules{Required: []string{"session.visitor_id"}})
+		w, emitted := get(env, url.Values{"e": {"email_open"}})
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" || len(emitted) != 0 {
+			t.Errorf("status = %d, emitted = %d", w.Code, len(emitted))
+		}
+		if _, emitted := get(env, url.Values{"e": {"email_open"}, "vid": {"v1"}}); len(emitted) != 1 {
+			t.Errorf("emitted = %d with a visitor ID", len(emitted))
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package httpx
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// RateLimiter enforces a per-client token bucket on ingestion endpoints.
+// Limits can be changed at runtime via SetLimit (e.g. on config reload).
+type RateLimiter struct {
+	mu        sync.Mutex
+	rps       float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing rps requests per second per client
+// with bursts up to burst. A non-positive rps disables limiting.
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	l := &RateLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+	l.SetLimit(rps, burst)
+	return l
+}
+
+// SetLimit changes the rate and burst; existing buckets keep their tokens
+func (l *RateLimiter) SetLimit(rps float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rps = rps
+	l.burst = float64(burst)
+	if l.burst < 1 {
+		l.burst = 1
+	}
+}
+
+// Limit returns the configured rate and burst
+func (l *RateLimiter) Limit() (float64, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rps, int(l.burst)
+}
+
+// Allow reports whether a request from key may proceed
+func (l *RateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rps <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rps
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	// Drop buckets that have refilled completely; they carry no state
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.sweep(now)
+		l.lastSweep = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep removes full buckets (must be called with mutex held)
+func (l *RateLimiter) sweep(now time.Time) {
+	refill := time.Duration(l.burst / l.rps * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// allowRequest applies the rate limiter, writing 429 when the client is over its limit
+func (e Env) allowRequest(w http.ResponseWriter, r *http.Request) bool {
+	if e.Limiter == nil {
+		return true
+	}
+	if e.Limiter.Allow(rateLimitKey(r, e.Cfg)) {
+		return true
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+	return false
+}
+
+// rateLimitKey identifies the client, honoring proxy headers only from
+// trusted peers
+func rateLimitKey(r *http.Request, cfg config.Config) string {
+	return normalizeIP(event.ClientIP(r, cfg))
+}
@@ -0,0 +1,92 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cfg "github.com/shortontech/gotrack/pkg/config"
+)
+
+// TestRateLimiter tests the per-client token bucket
+func TestRateLimiter(t *testing.T) {
+	t.Run("disabled when rps is zero", func(t *testing.T) {
+		l := NewRateLimiter(0, 1)
+		for i := 0; i < 100; i++ {
+			if !l.Allow("10.0.0.1") {
+				t.Fatal("expected all requests to be allowed")
+			}
+		}
+	})
+
+	t.Run("enforces burst per client", func(t *testing.T) {
+		l := NewRateLimiter(1, 3)
+		for i := 0; i < 3; i++ {
+			if !l.Allow("10.0.0.1") {
+				t.Fatalf("request %d should be within burst", i)
+			}
+		}
+		if l.Allow("10.0.0.1") {
+			t.Error("request beyond burst should be rejected")
+		}
+		if !l.Allow("10.0.0.2") {
+			t.Error("other clients should have their own bucket")
+		}
+	})
+
+	t.Run("limit can change at runtime", func(t *testing.T) {
+		l := NewRateLimiter(1, 1)
+		l.Allow("10.0.0.1")
+		if l.Allow("10.0.0.1") {
+			t.Fatal("expected second request to be limited")
+		}
+
+		l.SetLimit(0, 1)
+		if !l.Allow("10.0.0.1") {
+			t.Error("expected limiting to be disabled after SetLimit(0)")
+		}
+		if rps, burst := l.Limit(); rps != 0 || burst != 1 {
+			t.Errorf("Limit() = %v, %v", rps, burst)
+		}
+	})
+}
+
+// TestRateLimitedCollect tests that ingestion endpoints return 429 when over the limit
+func TestRateLimitedCollect(t *testing.T) {
+	env := Env{
+		Cfg:     cfg.Config{MaxBodyBytes: 1 << 20},
+		Limiter: NewRateLimiter(1, 1),
+	}
+
+	send := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/px.gif", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		env.Pixel(w, req)
+		return w.Code
+	}
+
+	if code := send(); code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", code)
+	}
+	if code := send(); code != http.StatusTooManyRequests {
+		t.Errorf("second request status = %d, want 429", code)
+	}
+}
+
+// TestRateLimitKey tests client identification for rate limiting
+func TestRateLimitKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+
+	if got := rateLimitKey(req, cfg.Config{}); got != "10.0.0.1" {
+		t.Errorf("untrusted key = %q, want 10.0.0.1", got)
+	}
+	if got := rateLimitKey(req, cfg.Config{TrustProxy: true}); got != "203.0.113.9" {
+		t.Errorf("trusted key = %q, want 203.0.113.9", got)
+	}
+	if got := rateLimitKey(req, cfg.Config{TrustedProxyCIDRs: []string{"192.0.2.0/24"}}); got != "10.0.0.1" {
+		t.Errorf("key from peer outside TRUSTED_PROXY_CIDRS = %q, want 10.0.0.1", got)
+	}
+}
@@ -0,0 +1,128 @@
+package httpx
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/shortontech/gotrack/internal/relay"
+)
+
+// RelayBatch receives chunked event batches forwarded by edge GoTrack instances.
+// POST stores one chunk; GET ?batch_id= reports which chunks are already held
+// so that an interrupted sender can resume instead of starting over.
+func (e Env) RelayBatch(w http.ResponseWriter, r *http.Request) {
+	if e.Relay == nil || e.Cfg.RelayAcceptToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !validBearerToken(r, e.Cfg.RelayAcceptToken) {
+		http.Error(w, "invalid or missing relay token", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		batchID := r.URL.Query().Get("batch_id")
+		if batchID == "" {
+			http.Error(w, "batch_id is required", http.StatusBadRequest)
+			return
+		}
+		writeRelayStatus(w, e.Relay.Status(batchID))
+	case http.MethodPost:
+		e.receiveRelayChunk(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (e Env) receiveRelayChunk(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	compression := r.Header.Get("Content-Encoding")
+	if compression == "" {
+		compression = relay.CompressionNone
+	}
+	if !relay.ValidCompression(compression) {
+		http.Error(w, "unsupported content-encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	index, errIndex := strconv.Atoi(r.Header.Get(relay.HeaderChunkIndex))
+	count, errCount := strconv.Atoi(r.Header.Get(relay.HeaderChunkCount))
+	if errIndex != nil || errCount != nil {
+		http.Error(w, "invalid chunk headers", http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, e.Cfg.MaxBodyBytes))
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	payload, status, err := e.Relay.Add(relay.Chunk{
+		BatchID:     r.Header.Get(relay.HeaderBatchID),
+		BatchSHA256: r.Header.Get(relay.HeaderBatchSHA256),
+		Compression: compression,
+		Index:       index,
+		Count:       count,
+		SHA256:      r.Header.Get(relay.HeaderChunkSHA256),
+		Data:        data,
+	})
+	switch {
+	case errors.Is(err, relay.ErrChecksumMismatch), errors.Is(err, relay.ErrInvalidChunk):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, relay.ErrTooManyBatches):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if payload != nil {
+		events, err := relay.Decode(payload, compression)
+		if err != nil {
+			log.Printf("relay: failed to decode batch %s: %v", status.BatchID, err)
+			http.Error(w, "invalid batch payload", http.StatusBadRequest)
+			return
+		}
+		// Edge instances already enriched these events; forward them untouched
+		for _, ev := range events {
+			if e.Emit != nil {
+				e.Emit(r.Context(), ev)
+			}
+		}
+		log.Printf("relay: accepted batch %s with %d events", status.BatchID, len(events))
+	}
+
+	writeRelayStatus(w, status)
+}
+
+func writeRelayStatus(w http.ResponseWriter, status relay.Status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// validBearerToken checks the Authorization header against the expected token in constant time
+func validBearerToken(r *http.Request, expected string) bool {
+	token, ok := bearerToken(r)
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
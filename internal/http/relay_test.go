@@ -0,0 +1,118 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/relay"
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func newRelayRequest(t *testing.T, batchID string, payload []byte, index, count int, chunk []byte) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/relay/batch", bytes.NewReader(chunk))
+	req.Header.Set("Authorization", "Bearer relay-token")
+	req.Header.Set("Content-Encoding", relay.CompressionZstd)
+	req.Header.Set(relay.HeaderBatchID, batchID)
+	req.Header.Set(relay.HeaderBatchSHA256, relay.Checksum(payload))
+	req.Header.Set(relay.HeaderChunkIndex, strconv.Itoa(index))
+	req.Header.Set(relay.HeaderChunkCount, strconv.Itoa(count))
+	req.Header.Set(relay.HeaderChunkSHA256, relay.Checksum(chunk))
+	return req
+}
+
+// TestRelayBatch tests the edge-to-central relay endpoint
+func TestRelayBatch(t *testing.T) {
+	newEnv := func(emitted *[]event.Event) Env {
+		return Env{
+			Cfg:   cfg.Config{RelayAcceptToken: "relay-token", MaxBodyBytes: 1 << 20},
+			Relay: relay.NewAssembler(time.Minute, 10),
+			Emit:  func(_ context.Context, ev event.Event) { *emitted = append(*emitted, ev) },
+		}
+	}
+	events := []event.Event{{EventID: "evt-1", Type: "pageview"}, {EventID: "evt-2", Type: "click"}}
+	payload, err := relay.Encode(events, relay.CompressionZstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := relay.Split(payload, len(payload)/2+1)
+
+	t.Run("emits events once all chunks arrive", func(t *testing.T) {
+		var emitted []event.Event
+		env := newEnv(&emitted)
+
+		for i, chunk := range chunks {
+			w := httptest.NewRecorder()
+			env.RelayBatch(w, newRelayRequest(t, "batch-1", payload, i, len(chunks), chunk))
+			if w.Code != http.StatusOK {
+				t.Fatalf("chunk %d: status %d: %s", i, w.Code, w.Body.String())
+			}
+		}
+		if len(emitted) != 2 || emitted[1].EventID != "evt-2" {
+			t.Errorf("unexpected emitted events: %+v", emitted)
+		}
+	})
+
+	t.Run("status reports received chunks", func(t *testing.T) {
+		var emitted []event.Event
+		env := newEnv(&emitted)
+		env.RelayBatch(httptest.NewRecorder(), newRelayRequest(t, "batch-2", payload, 0, len(chunks), chunks[0]))
+
+		req := httptest.NewRequest(http.MethodGet, "/relay/batch?batch_id=batch-2", nil)
+		req.Header.Set("Authorization", "Bearer relay-token")
+		w := httptest.NewRecorder()
+		env.RelayBatch(w, req)
+
+		var status relay.Status
+		if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+			t.Fatalf("invalid status response: %v", err)
+		}
+		if status.Complete || len(status.Received) != 1 || status.Received[0] != 0 {
+			t.Errorf("unexpected status: %+v", status)
+		}
+		if len(emitted) != 0 {
+			t.Error("partial batch should not emit events")
+		}
+	})
+
+	t.Run("rejects corrupted chunk", func(t *testing.T) {
+		var emitted []event.Event
+		env := newEnv(&emitted)
+		req := newRelayRequest(t, "batch-3", payload, 0, len(chunks), chunks[0])
+		req.Header.Set(relay.HeaderChunkSHA256, relay.Checksum([]byte("other")))
+		w := httptest.NewRecorder()
+		env.RelayBatch(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("requires bearer token", func(t *testing.T) {
+		var emitted []event.Event
+		env := newEnv(&emitted)
+		req := newRelayRequest(t, "batch-4", payload, 0, len(chunks), chunks[0])
+		req.Header.Set("Authorization", "Bearer wrong")
+		w := httptest.NewRecorder()
+		env.RelayBatch(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401, got %d", w.Code)
+		}
+	})
+
+	t.Run("not found when disabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Env{}.RelayBatch(w, httptest.NewRequest(http.MethodPost, "/relay/batch", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/dedup"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// failingDetector is a nonce store that is always down
+type failingDetector struct{}
+
+func (failingDetector) Seen(context.Context, string) (bool, error) {
+	return false, errors.New("store down")
+}
+
+// TestReplayGuard tests timestamp and nonce checks
+func TestReplayGuard(t *testing.T) {
+	now := time.Unix(1767225600, 0)
+	guard := NewReplayGuard(5*time.Minute, dedup.NewMemoryDetector(100, 10*time.Minute))
+	guard.now = func() time.Time { return now }
+
+	request := func(ts time.Time, nonce string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/collect", nil)
+		r.Header.Set(timestampHeader, strconv.FormatInt(ts.Unix(), 10))
+		r.Header.Set(nonceHeader, nonce)
+		return r
+	}
+	ctx := context.Background()
+
+	if err := guard.Check(ctx, request(now, "0123456789abcdef")); err != nil {
+		t.Fatalf("fresh request rejected: %v", err)
+	}
+	if err := guard.Check(ctx, request(now, "0123456789abcdef")); !errors.Is(err, errReplayed) {
+		t.Errorf("replay: err = %v, want errReplayed", err)
+	}
+	if err := guard.Check(ctx, request(now.Add(4*time.Minute), "fedcba9876543210")); err != nil {
+		t.Errorf("client clock ahead within the window rejected: %v", err)
+	}
+
+	badTS := request(now, "cccccccccccccccc")
+	badTS.Header.Set(timestampHeader, "soon")
+	for name, tt := range map[string]struct {
+		r    *http.Request
+		want error
+	}{
+		"too old":       {request(now.Add(-6*time.Minute), "aaaaaaaaaaaaaaaa"), errStaleTimestamp},
+		"too new":       {request(now.Add(6*time.Minute), "bbbbbbbbbbbbbbbb"), errStaleTimestamp},
+		"short nonce":   {request(now, "abc"), errInvalidNonce},
+		"bad nonce":     {request(now, "not a valid nonce!"), errInvalidNonce},
+		"no headers":    {httptest.NewRequest(http.MethodPost, "/collect", nil), errMissingTimestamp},
+		"bad timestamp": {badTS, errStaleTimestamp},
+	} {
+		if err := guard.Check(ctx, tt.r); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", name, err, tt.want)
+		}
+	}
+
+	t.Run("accepts requests when the store fails", func(t *testing.T) {
+		down := NewReplayGuard(time.Minute, failingDetector{})
+		down.now = guard.now
+		if err := down.Check(ctx, request(now, "dddddddddddddddd")); err != nil {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("nil guard accepts everything", func(t *testing.T) {
+		var none *ReplayGuard
+		if err := none.Check(ctx, httptest.NewRequest(http.MethodPost, "/collect", nil)); err != nil {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
+
+// TestCollectReplay tests that /collect refuses a replayed signed request
+func TestCollectReplay(t *testing.T) {
+	payload := []byte(`{"type":"click"}`)
+	env := Env{
+		HMACAuth: NewHMACAuth("secret", ""),
+		Replay:   NewReplayGuard(5*time.Minute, dedup.NewMemoryDetector(100, 10*time.Minute)),
+		Emit:     func(context.Context, event.Event) {},
+	}
+	env.Cfg.MaxBodyBytes = 1 << 20
+	env.Cfg.MaxDecompressedBytes = 1 << 20
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := "0123456789abcdef0123456789abcdef"
+	sig := env.HMACAuth.generateHMAC([]byte(ts+"\n"+nonce+"\n"+string(payload)), "192.0.2.1")
+	send := func(ts, nonce string) int {
+		r := httptest.NewRequest(http.MethodPost, "/collect", bytes.NewReader(payload))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(hmacHeader, sig)
+		r.Header.Set(timestampHeader, ts)
+		r.Header.Set(nonceHeader, nonce)
+		w := httptest.NewRecorder()
+		env.Collect(w, r)
+		return w.Code
+	}
+
+	if code := send(ts, nonce); code != http.StatusAccepted {
+		t.Fatalf("first request status = %d", code)
+	}
+	if code := send(ts, nonce); code != http.StatusUnauthorized {
+		t.Errorf("replayed request status = %d, want 401", code)
+	}
+	// A fresh nonce doesn't help without re-signing
+	if code := send(ts, "fedcba9876543210fedcba9876543210"); code != http.StatusUnauthorized {
+		t.Errorf("request with swapped nonce status = %d, want 401", code)
+	}
+}
@@ -0,0 +1,110 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/shortontech/gotrack/internal/email"
+	"github.com/shortontech/gotrack/internal/outbound"
+	"github.com/shortontech/gotrack/internal/shortlink"
+)
+
+// RouteSet selects the groups of endpoints a listener serves, so the pixel
+// can be public while server-to-server and admin endpoints stay internal
+type RouteSet uint8
+
+const (
+	// RoutesPublic is what browsers load: the pixel, /collect, the scripts
+	// and, in middleware mode, the proxied site
+	RoutesPublic RouteSet = 1 << iota
+	// RoutesInternal is server-to-server ingestion: /collect, the NDJSON
+	// import, Measurement Protocol, the Segment API and the relay endpoint
+	RoutesInternal
+	// RoutesAdmin is the admin API and dashboard
+	RoutesAdmin
+
+	RoutesAll = RoutesPublic | RoutesInternal | RoutesAdmin
+)
+
+var routeSetNames = []struct {
+	name string
+	set  RouteSet
+}{
+	{"public", RoutesPublic},
+	{"internal", RoutesInternal},
+	{"admin", RoutesAdmin},
+}
+
+// ParseRouteSet parses route set names joined by '+', such as
+// "internal+admin". "all" selects every route.
+func ParseRouteSet(s string) (RouteSet, error) {
+	var routes RouteSet
+	for _, name := range strings.Split(s, "+") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "all" {
+			routes |= RoutesAll
+			continue
+		}
+		known := false
+		for _, n := range routeSetNames {
+			if n.name == name {
+				routes |= n.set
+				known = true
+			}
+		}
+		if !known {
+			return 0, fmt.Errorf("unknown route set %q (want public, internal, admin or all)", name)
+		}
+	}
+	return routes, nil
+}
+
+func (r RouteSet) String() string {
+	if r == RoutesAll {
+		return "all"
+	}
+	var names []string
+	for _, n := range routeSetNames {
+		if r&n.set != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// routeSetOf returns the route sets that serve path. Health checks are
+// served everywhere so each listener can be probed, and the API document so
+// each can be described.
+func routeSetOf(path string) RouteSet {
+	switch {
+	case path == "/healthz" || path == "/readyz" || path == openAPIPath:
+		return RoutesAll
+	case path == "/collect" || path == "/conversion":
+		return RoutesPublic | RoutesInternal
+	case slices.Contains(aliasedPaths, path) || path == email.OpenPath || path == email.ClickPath || path == outbound.Path ||
+		strings.HasPrefix(path, shortlink.PathPrefix):
+		return RoutesPublic
+	case strings.HasPrefix(path, adminPathPrefix):
+		return RoutesAdmin
+	case isTrackingPath(path):
+		return RoutesInternal
+	}
+	return RoutesPublic // proxied pages
+}
+
+// restrictRoutes answers 404 for paths outside routes, as if they were not
+// registered on this listener
+func restrictRoutes(routes RouteSet, next http.Handler) http.Handler {
+	if routes == 0 || routes == RoutesAll {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if routeSetOf(r.URL.Path)&routes == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,82 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestParseRouteSet(t *testing.T) {
+	for s, want := range map[string]RouteSet{
+		"public":          RoutesPublic,
+		"internal+admin":  RoutesInternal | RoutesAdmin,
+		" Public + ADMIN": RoutesPublic | RoutesAdmin,
+		"all":             RoutesAll,
+	} {
+		got, err := ParseRouteSet(s)
+		if err != nil || got != want {
+			t.Errorf("ParseRouteSet(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "pixel", "public+"} {
+		if _, err := ParseRouteSet(s); err == nil {
+			t.Errorf("ParseRouteSet(%q) should fail", s)
+		}
+	}
+	if got := (RoutesInternal | RoutesAdmin).String(); got != "internal+admin" {
+		t.Errorf("String() = %q, want internal+admin", got)
+	}
+}
+
+func TestRestrictRoutes(t *testing.T) {
+	serve := func(routes RouteSet, path string) int {
+		handler := NewMux(Env{
+			Cfg: config.Config{
+				MaxBodyBytes:       1 << 20,
+				AdminToken:         "admin-secret",
+				SegmentEnabled:     true,
+				TrackingPathPrefix: "/assets/a7f3",
+			},
+			Emit:   func(context.Context, event.Event) {},
+			Reload: func() error { return nil },
+			Routes: routes,
+		})
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"type":"pageview"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		routes RouteSet
+		path   string
+		served bool
+	}{
+		{RoutesPublic, "/collect", true},
+		{RoutesPublic, "/assets/a7f3/collect", true},
+		{RoutesPublic, "/healthz", true},
+		{RoutesPublic, "/v1/track", false},
+		{RoutesPublic, "/_gotrack/admin/reload", false},
+		{RoutesInternal, "/collect", true},
+		{RoutesInternal, "/v1/track", true},
+		{RoutesInternal, "/px.gif", false},
+		{RoutesInternal, "/_gotrack/admin/reload", false},
+		{RoutesAdmin, "/_gotrack/admin/reload", true},
+		{RoutesAdmin, "/readyz", true},
+		{RoutesAdmin, "/collect", false},
+		{0, "/v1/track", true},
+	}
+	for _, tt := range tests {
+		code := serve(tt.routes, tt.path)
+		if served := code != http.StatusNotFound; served != tt.served {
+			t.Errorf("%v routes: %s answered %d, served = %v, want %v", tt.routes, tt.path, code, served, tt.served)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package httpx
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/segment"
+	event "github.com/shortontech/gotrack/pkg/event"
+)
+
+// POST /v1/t, /v1/track — Segment track call. Together with the page,
+// identify and batch endpoints this is the Segment HTTP Tracking API, so
+// analytics.js and the server-side libraries can send to GoTrack by changing
+// their API host. Calls are mapped by the segment package.
+func (e Env) SegmentTrack(w http.ResponseWriter, r *http.Request) {
+	e.segmentCall(w, r, segment.TypeTrack)
+}
+
+// POST /v1/p, /v1/page — Segment page call
+func (e Env) SegmentPage(w http.ResponseWriter, r *http.Request) {
+	e.segmentCall(w, r, segment.TypePage)
+}
+
+// POST /v1/i, /v1/identify — Segment identify call
+func (e Env) SegmentIdentify(w http.ResponseWriter, r *http.Request) {
+	e.segmentCall(w, r, segment.TypeIdentify)
+}
+
+func (e Env) segmentCall(w http.ResponseWriter, r *http.Request, callType string) {
+	body, ok := e.readSegmentRequest(w, r)
+	if !ok {
+		return
+	}
+	msg, err := segment.ParseMessage(body, callType)
+	if err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := msg.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r, ok = e.resolveSegmentKey(w, r, msg.WriteKey)
+	if !ok {
+		return
+	}
+	e.emitSegmentMessages(r, []segment.Message{msg})
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// POST /v1/batch — several Segment calls of any type. Types GoTrack does not
+// map (screen, group, alias) are accepted and ignored.
+func (e Env) SegmentBatch(w http.ResponseWriter, r *http.Request) {
+	body, ok := e.readSegmentRequest(w, r)
+	if !ok {
+		return
+	}
+	batch, err := segment.ParseBatch(body)
+	if err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	for i, msg := range batch.Batch {
+		if err := msg.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("batch[%d]: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+	r, ok = e.resolveSegmentKey(w, r, batch.WriteKey)
+	if !ok {
+		return
+	}
+	accepted := e.emitSegmentMessages(r, batch.Batch)
+	log.Printf("segment batch: %d of %d calls accepted", accepted, len(batch.Batch))
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// readSegmentRequest checks the method and rate limit and reads the body.
+// analytics.js posts JSON as text/plain to avoid a CORS preflight, so the
+// content type is not checked.
+func (e Env) readSegmentRequest(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if !e.Cfg.SegmentEnabled {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	if !e.allowRequest(w, r) {
+		return nil, false
+	}
+	return e.readBody(w, r)
+}
+
+// resolveSegmentKey checks the write key, sent as the Basic auth username by
+// the server-side libraries and in the body by analytics.js. In multi-tenant
+// mode it is a site's write key and selects the site, as on /collect.
+func (e Env) resolveSegmentKey(w http.ResponseWriter, r *http.Request, bodyKey string) (*http.Request, bool) {
+	key, _, ok := r.BasicAuth()
+	if !ok || key == "" {
+		key = bodyKey
+	}
+
+	if e.Tenants != nil {
+		tenant, ok := e.Tenants.Lookup(key)
+		if !ok {
+			http.Error(w, "invalid or missing write key", http.StatusUnauthorized)
+			return nil, false
+		}
+		if !tenant.allowsOrigin(r) {
+			http.Error(w, "origin not allowed for this site", http.StatusForbidden)
+			return nil, false
+		}
+		return r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)), true
+	}
+	if e.Cfg.SegmentWriteKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(e.Cfg.SegmentWriteKey)) != 1 {
+		http.Error(w, "invalid or missing write key", http.StatusUnauthorized)
+		return nil, false
+	}
+	return r, true
+}
+
+// emitSegmentMessages maps, validates, enriches and emits the supported
+// calls, returning how many were accepted
+func (e Env) emitSegmentMessages(r *http.Request, msgs []segment.Message) int {
+	now := time.Now()
+	events := make([]event.Event, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Supported() {
+			events = append(events, msg.ToEvent(now))
+		}
+	}
+	events, _ = e.validate(events)
+
+	// context.ip names the end user when a server-side library sends the call;
+	// the request address is only used when it is absent
+	ptrs := make([]*event.Event, len(events))
+	ips := make([]string, len(events))
+	for i := range events {
+		ptrs[i] = &events[i]
+		ips[i] = events[i].Server.IP
+	}
+	e.enrich(r, ptrs...)
+	e.applyExperiments(nil, r, ptrs...)
+
+	accepted := 0
+	for i, ev := range ptrs {
+		if ips[i] != "" {
+			ev.Server.IP = ips[i]
+		}
+		if !e.honorOptOut(r, ev) {
+			continue
+		}
+		if e.Emit != nil {
+			e.Emit(r.Context(), *ev)
+		}
+		accepted++
+	}
+	return accepted
+}
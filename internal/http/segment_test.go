@@ -0,0 +1,120 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestSegment(t *testing.T) {
+	newEnv := func() (Env, *[]event.Event) {
+		var emitted []event.Event
+		return Env{
+			Cfg:  config.Config{SegmentEnabled: true, SegmentWriteKey: "wk", MaxBodyBytes: 1 << 20, MaxDecompressedBytes: 1 << 20},
+			Emit: func(_ context.Context, e event.Event) { emitted = append(emitted, e) },
+		}, &emitted
+	}
+	post := func(h http.HandlerFunc, body string, basicKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/t", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		req.RemoteAddr = "192.0.2.1:1234"
+		if basicKey != "" {
+			req.SetBasicAuth(basicKey, "")
+		}
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+
+	t.Run("track call", func(t *testing.T) {
+		env, emitted := newEnv()
+		w := post(env.SegmentTrack, `{"event":"Signed Up","anonymousId":"a-1","writeKey":"wk","properties":{"plan":"pro"}}`, "")
+		if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"success":true}` {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		if len(*emitted) != 1 {
+			t.Fatalf("emitted %d events", len(*emitted))
+		}
+		ev := (*emitted)[0]
+		if ev.Type != "Signed Up" || ev.Session.VisitorID != "a-1" || ev.Props["plan"] != "pro" || ev.EventID == "" || ev.Server.IP != "192.0.2.1" {
+			t.Errorf("event = %+v", ev)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		env, emitted := newEnv()
+		body := `{"batch":[
+			{"type":"page","anonymousId":"a-1","context":{"ip":"203.0.113.7"}},
+			{"type":"identify","userId":"u-1","traits":{"plan":"pro"}},
+			{"type":"group","userId":"u-1","groupId":"g-1"}
+		]}`
+		if w := post(env.SegmentBatch, body, "wk"); w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		if len(*emitted) != 2 {
+			t.Fatalf("emitted %d events, want the group call ignored", len(*emitted))
+		}
+		if page := (*emitted)[0]; page.Type != "pageview" || page.Server.IP != "203.0.113.7" {
+			t.Errorf("page = %+v, want context.ip to win over the request address", page)
+		}
+		if identify := (*emitted)[1]; identify.Type != "identify" || identify.Session.UserID != "u-1" {
+			t.Errorf("identify = %+v", identify)
+		}
+	})
+
+	t.Run("invalid calls are refused", func(t *testing.T) {
+		env, emitted := newEnv()
+		for _, tc := range []struct {
+			h    http.HandlerFunc
+			body string
+		}{
+			{env.SegmentTrack, `{"anonymousId":"a-1"}`},
+			{env.SegmentPage, `{"name":"Home"}`},
+			{env.SegmentBatch, `{"batch":[{"type":"track","event":"x","userId":"u"},{"type":"track","userId":"u"}]}`},
+			{env.SegmentBatch, `not json`},
+		} {
+			if w := post(tc.h, tc.body, "wk"); w.Code != http.StatusBadRequest {
+				t.Errorf("%s: status = %d, want 400", tc.body, w.Code)
+			}
+		}
+		if len(*emitted) != 0 {
+			t.Errorf("emitted %d events", len(*emitted))
+		}
+	})
+
+	t.Run("write key", func(t *testing.T) {
+		env, _ := newEnv()
+		const body = `{"event":"x","anonymousId":"a"}`
+		if w := post(env.SegmentTrack, body, "wrong"); w.Code != http.StatusUnauthorized {
+			t.Errorf("wrong key: status = %d, want 401", w.Code)
+		}
+		if w := post(env.SegmentTrack, body, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("missing key: status = %d, want 401", w.Code)
+		}
+
+		env.Cfg.SegmentWriteKey = ""
+		if w := post(env.SegmentTrack, body, ""); w.Code != http.StatusOK {
+			t.Errorf("no key configured: status = %d, want 200", w.Code)
+		}
+		env.Cfg.SegmentEnabled = false
+		if w := post(env.SegmentTrack, body, ""); w.Code != http.StatusNotFound {
+			t.Errorf("disabled: status = %d, want 404", w.Code)
+		}
+	})
+
+	t.Run("write key selects the site", func(t *testing.T) {
+		env, emitted := newEnv()
+		env.Tenants = newTestTenants(t)
+		if w := post(env.SegmentTrack, `{"event":"x","anonymousId":"a","writeKey":"wk_blog"}`, ""); w.Code != http.StatusOK || (*emitted)[0].SiteID != "blog" {
+			t.Errorf("status = %d, emitted = %+v", w.Code, *emitted)
+		}
+		if w := post(env.SegmentTrack, `{"event":"x","anonymousId":"a"}`, "wk"); w.Code != http.StatusUnauthorized {
+			t.Errorf("unknown key: status = %d, want 401", w.Code)
+		}
+	})
+}
@@ -3,7 +3,6 @@ package httpx
 import (
 	"bytes"
 	"compress/gzip"
-	"context"
 	"fmt"
 	"html/template"
 	"io"
@@ -16,23 +15,57 @@ import (
 	"time"
 
 	"github.com/shortontech/gotrack/internal/assets"
+	"github.com/shortontech/gotrack/internal/email"
+	"github.com/shortontech/gotrack/internal/outbound"
+	"github.com/shortontech/gotrack/internal/proxycache"
+	"github.com/shortontech/gotrack/internal/relay"
+	"github.com/shortontech/gotrack/internal/shortlink"
+	"github.com/shortontech/gotrack/pkg/event"
 )
 
+// defaultMaxHTMLBytes bounds the HTML buffered for pixel injection when
+// PROXY_MAX_HTML_BYTES is not set
+const defaultMaxHTMLBytes = 4 << 20
+
+// hopHeaders only apply to one connection and are not forwarded
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
 // ProxyHandler implements a reverse proxy
 type ProxyHandler struct {
-	destination string
-	client      *http.Client
-	hmacAuth    *HMACAuth
+	destination  string
+	client       *http.Client
+	hmacAuth     *HMACAuth
+	pathPrefix   string                   // TRACKING_PATH_PREFIX for injected URLs
+	maxHTMLBytes int64                    // larger HTML responses are streamed without a pixel
+	cache        *proxycache.Cache        // stores cacheable non-HTML responses; nil disables caching
+	trustedPeer  func(*http.Request) bool // extend X-Forwarded-* when it reports true; nil trusts no peer
+	originSecret []byte                   // signs X-GoTrack-Proxy for the origin; nil sends none
+	injector     *Injector                // template and paths for injection; nil instruments every page
+	clientHints  bool                     // ask instrumented pages' browsers for high-entropy Client Hints
+	trapPath     string                   // honeypot linked invisibly from instrumented pages; empty adds no link
 }
 
 // NewProxyHandler creates a new proxy handler for the given destination
 func NewProxyHandler(destination string, hmacAuth *HMACAuth) *ProxyHandler {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Only the wait for response headers is bounded, so streamed responses
+	// and websockets can stay open as long as the upstream keeps them
+	transport.ResponseHeaderTimeout = 25 * time.Second
 	return &ProxyHandler{
-		destination: destination,
-		hmacAuth:    hmacAuth,
-		client: &http.Client{
-			Timeout: 30 * time.Second, // 30 second timeout for proxied requests
-		},
+		destination:  destination,
+		hmacAuth:     hmacAuth,
+		maxHTMLBytes: defaultMaxHTMLBytes,
+		client:       &http.Client{Transport: transport},
 	}
 }
 
@@ -46,6 +79,18 @@ func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isUpgradeRequest(r) {
+		p.tunnel(w, r, targetURL)
+		return
+	}
+
+	if p.cache != nil {
+		if entry, ok := p.cache.Lookup(r); ok {
+			entry.Serve(w, r, time.Now())
+			return
+		}
+	}
+
 	// Create and execute proxy request
 	resp, err := p.executeProxyRequest(w, r, targetURL)
 	if err != nil {
@@ -54,13 +99,15 @@ func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer resp.Body.Close()
 
 	// Copy response headers
+	removeHopHeaders(resp.Header)
 	copyHeaders(w.Header(), resp.Header)
 
-	// Process and write response
+	// Process and write response. HTML is never cached since every page
+	// gets its own pixel.
 	if isHTMLContent(resp.Header.Get("Content-Type")) {
 		p.handleHTMLResponse(w, r, resp)
 	} else {
-		p.handleNonHTMLResponse(w, resp)
+		p.handleNonHTMLResponse(w, r, resp)
 	}
 }
 
@@ -70,20 +117,29 @@ func (p *ProxyHandler) executeProxyRequest(w http.ResponseWriter, r *http.Reques
 	targetURL.Path = r.URL.Path
 	targetURL.RawQuery = r.URL.RawQuery
 
-	// Create a context with timeout for the proxy request
-	ctx, cancel := context.WithTimeout(r.Context(), 25*time.Second)
-	defer cancel()
-
-	// Create a new request to the destination
-	proxyReq, err := http.NewRequestWithContext(ctx, r.Method, targetURL.String(), r.Body)
+	// The request context ends the upstream request when the client goes away
+	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, targetURL.String(), r.Body)
 	if err != nil {
 		log.Printf("proxy: failed to create request: %v", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return nil, err
 	}
 
-	// Copy headers from the original request
+	// Copy headers from the original request, keeping an upgrade request's
+	// Upgrade header
 	copyHeaders(proxyReq.Header, r.Header)
+	removeHopHeaders(proxyReq.Header)
+	if isUpgradeRequest(r) {
+		proxyReq.Header.Set("Connection", "Upgrade")
+		proxyReq.Header.Set("Upgrade", r.Header.Get("Upgrade"))
+	}
+	setForwardedHeaders(proxyReq.Header, r, p.trustedPeer != nil && p.trustedPeer(r))
+
+	// Only GoTrack may vouch for a request, whatever the client sent
+	proxyReq.Header.Del(originHeader)
+	if p.originSecret != nil {
+		proxyReq.Header.Set(originHeader, signOrigin(p.originSecret, r.Method, r.URL.RequestURI(), time.Now()))
+	}
 
 	// Set the Host header to the destination host
 	proxyReq.Host = targetURL.Host
@@ -99,17 +155,36 @@ func (p *ProxyHandler) executeProxyRequest(w http.ResponseWriter, r *http.Reques
 	return resp, nil
 }
 
-// handleHTMLResponse processes HTML responses with pixel injection
+// handleHTMLResponse processes HTML responses with pixel injection. Bodies
+// larger than maxHTMLBytes, compressed or not, are streamed unchanged.
 func (p *ProxyHandler) handleHTMLResponse(w http.ResponseWriter, r *http.Request, resp *http.Response) {
+	if !p.injector.Enabled(r.URL.Path) {
+		w.WriteHeader(resp.StatusCode)
+		if err := streamBody(w, resp.Body); err != nil {
+			log.Printf("proxy: failed to copy response body: %v", err)
+		}
+		return
+	}
+	if p.clientHints {
+		requestClientHints(w.Header())
+	}
+
 	isGzipped := strings.Contains(strings.ToLower(resp.Header.Get("Content-Encoding")), "gzip")
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
+	// Read the response body, up to one byte past the limit
+	body, err := io.ReadAll(io.LimitReader(resp.Body, p.maxHTMLBytes+1))
 	if err != nil {
 		log.Printf("proxy: failed to read response body for pixel injection: %v", err)
 		w.WriteHeader(resp.StatusCode)
 		return
 	}
+	if int64(len(body)) > p.maxHTMLBytes {
+		w.WriteHeader(resp.StatusCode)
+		if err := streamBody(w, io.MultiReader(bytes.NewReader(body), resp.Body)); err != nil {
+			log.Printf("proxy: failed to copy response body: %v", err)
+		}
+		return
+	}
 
 	// Decompress if needed
 	htmlBody, err := p.decompressIfNeeded(body, isGzipped)
@@ -121,7 +196,10 @@ func (p *ProxyHandler) handleHTMLResponse(w http.ResponseWriter, r *http.Request
 	}
 
 	// Inject pixel into HTML
-	modifiedBody := injectPixel(htmlBody, r, p.hmacAuth)
+	modifiedBody := p.injector.inject(htmlBody, r, w.Header(), p.hmacAuth, p.pathPrefix)
+	if p.trapPath != "" {
+		modifiedBody = insertSnippet(modifiedBody, trapLink(p.trapPath))
+	}
 
 	// Re-compress if needed
 	finalBody, err := p.compressIfNeeded(modifiedBody, isGzipped)
@@ -140,12 +218,129 @@ func (p *ProxyHandler) handleHTMLResponse(w http.ResponseWriter, r *http.Request
 	}
 }
 
-// handleNonHTMLResponse copies non-HTML responses as-is
-func (p *ProxyHandler) handleNonHTMLResponse(w http.ResponseWriter, resp *http.Response) {
+// handleNonHTMLResponse streams non-HTML responses as-is, keeping a copy
+// when the cache may store it
+func (p *ProxyHandler) handleNonHTMLResponse(w http.ResponseWriter, r *http.Request, resp *http.Response) {
+	var capture *proxycache.Capture
+	body := io.Reader(resp.Body)
+	if p.cache != nil {
+		if capture = p.cache.Capture(r, resp, resp.Header); capture != nil {
+			body = io.TeeReader(resp.Body, capture)
+		}
+	}
+
 	w.WriteHeader(resp.StatusCode)
-	_, err := io.Copy(w, resp.Body)
-	if err != nil {
+	if err := streamBody(w, body); err != nil {
 		log.Printf("proxy: failed to copy response body: %v", err)
+		return
+	}
+	if capture != nil {
+		capture.Save()
+	}
+}
+
+// streamBody copies body to w, flushing after every read so server-sent
+// events and long polls reach the client as the upstream writes them
+func streamBody(w http.ResponseWriter, body io.Reader) error {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			_ = rc.Flush() // not every writer can flush; the data is still written
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// tunnel forwards an Upgrade request such as a websocket handshake. Once
+// the upstream switches protocols, bytes are copied both ways until either
+// side closes; any other answer is passed on as a normal response.
+func (p *ProxyHandler) tunnel(w http.ResponseWriter, r *http.Request, targetURL *url.URL) {
+	resp, err := p.executeProxyRequest(w, r, targetURL)
+	if err != nil {
+		return // Error already handled in executeProxyRequest
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		copyHeaders(w.Header(), resp.Header)
+		removeHopHeaders(w.Header())
+		// Not cached: the answer to an upgrade says nothing about plain GETs
+		w.WriteHeader(resp.StatusCode)
+		if err := streamBody(w, resp.Body); err != nil {
+			log.Printf("proxy: failed to copy response body: %v", err)
+		}
+		return
+	}
+	backend, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		log.Printf("proxy: upstream switched protocols without a writable body")
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		log.Printf("proxy: cannot take over connection for upgrade: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	// Send the upstream's 101 with the headers set so far, then tunnel
+	copyHeaders(w.Header(), resp.Header)
+	resp.Header = w.Header()
+	resp.Body = nil
+	if err := resp.Write(brw); err != nil {
+		log.Printf("proxy: failed to write upgrade response: %v", err)
+		return
+	}
+	if err := brw.Flush(); err != nil {
+		log.Printf("proxy: failed to write upgrade response: %v", err)
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(backend, brw); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(conn, backend); done <- struct{}{} }()
+	<-done
+}
+
+// isUpgradeRequest reports whether r asks to switch protocols, e.g. to a websocket
+func isUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeHopHeaders deletes hop-by-hop headers, including those named in Connection
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
 	}
 }
 
@@ -162,11 +357,14 @@ func (p *ProxyHandler) decompressIfNeeded(body []byte, isGzipped bool) ([]byte,
 	}
 	defer gzReader.Close()
 
-	htmlBody, err := io.ReadAll(gzReader)
+	htmlBody, err := io.ReadAll(io.LimitReader(gzReader, p.maxHTMLBytes+1))
 	if err != nil {
 		log.Printf("proxy: failed to decompress gzipped body: %v", err)
 		return nil, err
 	}
+	if int64(len(htmlBody)) > p.maxHTMLBytes {
+		return nil, fmt.Errorf("decompressed HTML exceeds %d bytes", p.maxHTMLBytes)
+	}
 
 	return htmlBody, nil
 }
@@ -207,6 +405,7 @@ type MiddlewareRouter struct {
 	trackingMux    *http.ServeMux
 	proxy          *ProxyHandler
 	collectHandler http.HandlerFunc
+	optionalPaths  []string // enabled link and honeypot endpoints, which shadow the site's paths; see optionalRoute
 }
 
 // isHTMLContent checks if the content type indicates HTML content (case-insensitive)
@@ -225,56 +424,82 @@ func isHTMLContent(contentType string) bool {
 }
 
 // injectPixel adds a tracking pixel to HTML content before the closing </body> tag
-// It inlines the entire JavaScript library to avoid ad-blocker detection
-func injectPixel(body []byte, r *http.Request, hmacAuth *HMACAuth) []byte {
-	// Convert to string for easier manipulation
-	html := string(body)
+// It inlines the entire JavaScript library to avoid ad-blocker detection.
+// With a path prefix, the pixel and /hmac.js use their aliases; the library
+// keeps posting to the page's own URL.
+func injectPixel(body []byte, r *http.Request, hmacAuth *HMACAuth, pathPrefix string) []byte {
+	pixelURL := pixelURL(r, pathPrefix)
+
+	// AMP validators strip custom scripts, so fall back to the built-in amp-pixel
+	if isAMPDocument(body) {
+		return insertSnippet(body, buildAMPPixel(pixelURL))
+	}
+	return insertSnippet(body, builtinSnippet(pixelURL, pathPrefix, hmacAuth != nil, scriptPlan{}))
+}
 
-	// Create the pixel tracking image tag with full URL including query parameters
-	fullURL := r.URL.Path
-	if r.URL.RawQuery != "" {
-		fullURL = r.URL.Path + "?" + r.URL.RawQuery
+// builtinSnippet returns the default injected HTML. By inlining the entire
+// library, we avoid ad-blocker detection on script src URLs; plan may tag
+// the scripts with a CSP nonce or load the library from /pixel.js instead.
+func builtinSnippet(pixelURL, pathPrefix string, withHMAC bool, plan scriptPlan) string {
+	nonceAttr := ""
+	if plan.nonce != "" {
+		nonceAttr = ` nonce="` + template.HTMLEscapeString(plan.nonce) + `"`
 	}
-	pixelURL := "/px.gif?e=pageview&auto=1&url=" + url.QueryEscape(fullURL)
 
-	// Build injected content with INLINED tracking library and pixel
-	// By inlining the entire script, we avoid ad-blocker detection on script src URLs
-	var injectedContent string
-	if hmacAuth != nil {
-		// Include HMAC script (keep as src since it needs server state), inline tracking library, and pixel
+	var b strings.Builder
+	if withHMAC {
+		// Keep the HMAC script as src since it needs server state
 		// nosemgrep: go.lang.security.injection.raw-html-format.raw-html-format
-		injectedContent = fmt.Sprintf(`<script src="/hmac.js"></script>
-<script>%s</script>
-<img src="%s" width="1" height="1" style="display:none" alt="">`,
-			string(assets.PixelUMDJS),
-			template.HTMLEscapeString(pixelURL)) // nosemgrep: go.lang.security.injection.raw-html-format.raw-html-format
+		fmt.Fprintf(&b, "<script src=\"%s/hmac.js\"%s></script>\n", pathPrefix, nonceAttr)
+	}
+	if plan.external {
+		// nosemgrep: go.lang.security.injection.raw-html-format.raw-html-format
+		fmt.Fprintf(&b, "<script src=\"%s/pixel.js\"%s></script>\n", pathPrefix, nonceAttr)
 	} else {
-		// Inline tracking library and pixel without HMAC
 		// nosemgrep: go.lang.security.injection.raw-html-format.raw-html-format
-		injectedContent = fmt.Sprintf(`<script>%s</script>
-<img src="%s" width="1" height="1" style="display:none" alt="">`,
-			string(assets.PixelUMDJS),
-			template.HTMLEscapeString(pixelURL)) // nosemgrep: go.lang.security.injection.raw-html-format.raw-html-format
+		fmt.Fprintf(&b, "<script%s>%s</script>\n", nonceAttr, string(assets.PixelUMDJS))
+	}
+	// nosemgrep: go.lang.security.injection.raw-html-format.raw-html-format
+	fmt.Fprintf(&b, `<img src="%s" width="1" height="1" style="display:none" alt="">`, template.HTMLEscapeString(pixelURL))
+	return b.String()
+}
+
+// pixelURL returns the pageview pixel URL for the requested page, including
+// its query string
+func pixelURL(r *http.Request, pathPrefix string) string {
+	fullURL := r.URL.Path
+	if r.URL.RawQuery != "" {
+		fullURL = r.URL.Path + "?" + r.URL.RawQuery
 	}
+	return pathPrefix + "/px.gif?e=pageview&auto=1&url=" + url.QueryEscape(fullURL)
+}
 
-	// Try to inject before </body> tag (case-insensitive)
-	bodyCloseRegex := regexp.MustCompile(`(?i)</body>`)
+var (
+	bodyCloseRegex = regexp.MustCompile(`(?i)</body>`)
+	htmlCloseRegex = regexp.MustCompile(`(?i)</html>`)
+)
+
+// insertSnippet places snippet before </body>, else before </html>, else at
+// the end of the page
+func insertSnippet(body []byte, snippet string) []byte {
+	// Convert to string for easier manipulation
+	html := string(body)
+
+	// Try to inject before </body> tag (case-insensitive). The snippet is
+	// inserted literally, so $ in inlined code is not a group reference.
 	if bodyCloseRegex.MatchString(html) {
-		// Inject before </body>
-		modified := bodyCloseRegex.ReplaceAllString(html, injectedContent+"\n</body>")
+		modified := bodyCloseRegex.ReplaceAllLiteralString(html, snippet+"\n</body>")
 		return []byte(modified)
 	}
 
 	// If no </body> tag found, try to inject before </html> (case-insensitive)
-	htmlCloseRegex := regexp.MustCompile(`(?i)</html>`)
 	if htmlCloseRegex.MatchString(html) {
-		// Inject before </html>
-		modified := htmlCloseRegex.ReplaceAllString(html, injectedContent+"\n</html>")
+		modified := htmlCloseRegex.ReplaceAllLiteralString(html, snippet+"\n</html>")
 		return []byte(modified)
 	}
 
 	// If neither tag found, append to the end
-	return bytes.Join([][]byte{body, []byte(injectedContent)}, []byte("\n"))
+	return bytes.Join([][]byte{body, []byte(snippet)}, []byte("\n"))
 }
 
 // NewMiddlewareRouter creates a new middleware router that handles tracking routes
@@ -290,7 +515,7 @@ func NewMiddlewareRouter(trackingMux *http.ServeMux, destination string, hmacAut
 // ServeHTTP handles requests by first trying the tracking mux, then proxying on 404
 func (m *MiddlewareRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Check if this is a tracking-related path
-	if isTrackingPath(r.URL.Path) {
+	if isTrackingPath(r.URL.Path) || optionalRoute(m.optionalPaths, r.URL.Path) != "" {
 		m.trackingMux.ServeHTTP(w, r)
 		return
 	}
@@ -316,29 +541,126 @@ func isTrackingPath(path string) bool {
 	trackingPaths := []string{
 		"/px.gif",
 		"/collect",
+		"/collect.gif",
+		"/conversion",
+		"/collect/ndjson",
+		"/mp/collect",
+		"/debug/mp/collect",
+		"/v1/t",
+		"/v1/track",
+		"/v1/p",
+		"/v1/page",
+		"/v1/i",
+		"/v1/identify",
+		"/v1/batch",
 		"/healthz",
 		"/readyz",
 		"/metrics",
+		"/openapi.json",
 		"/hmac.js",
 		"/hmac/public-key",
 		"/pixel.js",
 		"/pixel.umd.js",
 		"/pixel.esm.js",
+		"/relay/batch",
+		clusterInfoPath,
 	}
 	for _, trackingPath := range trackingPaths {
 		if path == trackingPath {
 			return true
 		}
 	}
-	return false
+	return strings.HasPrefix(path, adminPathPrefix)
+}
+
+// linkPaths returns the email, outbound and short link endpoints that are
+// enabled
+func (e Env) linkPaths() []string {
+	var paths []string
+	if e.Cfg.EmailLinkSecret != "" {
+		paths = append(paths, email.OpenPath, email.ClickPath)
+	}
+	if e.Cfg.OutboundLinkSecret != "" || len(e.Cfg.OutboundAllowedHosts) > 0 {
+		paths = append(paths, outbound.Path)
+	}
+	if e.ShortLinks != nil {
+		paths = append(paths, shortlink.PathPrefix)
+	}
+	return paths
+}
+
+// optionalPaths returns the enabled link endpoints, honeypot paths,
+// experiment assignment and realtime stats. Unlike the tracking paths they are short enough to
+// clash with a proxied site's own pages, so they are only taken over when
+// configured.
+func (e Env) optionalPaths() []string {
+	paths := append(e.linkPaths(), e.honeypotPaths()...)
+	if e.Cfg.ExperimentsFile != "" {
+		paths = append(paths, experimentsPath)
+	}
+	if e.Cfg.AdminToken != "" {
+		paths = append(paths, realtimeStatsPath)
+	}
+	return paths
+}
+
+// optionalRoute returns the endpoint among paths that serves path, or "".
+// Endpoints ending in / serve every path below them.
+func optionalRoute(paths []string, path string) string {
+	for _, p := range paths {
+		if p == path || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// metricsRoute returns the endpoint label for HTTP metrics: tracking
+// endpoints by path, the admin API as "admin" and anything else as "proxy"
+// or "other", so scanners and proxied pages can't create unbounded label
+// values. METRICS_DETAILED_PATHS keeps raw paths instead.
+func (e Env) metricsRoute() func(path string) string {
+	if e.Cfg.MetricsDetailedPaths {
+		return nil
+	}
+	unmatched := "other"
+	if e.Cfg.ForwardDestination != "" {
+		unmatched = "proxy"
+	}
+	optionalPaths := e.optionalPaths()
+	return func(path string) string {
+		switch {
+		case strings.HasPrefix(path, adminPathPrefix):
+			return "admin"
+		case isTrackingPath(path):
+			return path
+		case optionalRoute(optionalPaths, path) != "":
+			return optionalRoute(optionalPaths, path)
+		}
+		return unmatched
+	}
+}
+
+// ingest wraps an ingestion handler: it is refused during a drain, runs in a
+// server span, reports its rejections to the dashboard and answers likely
+// bots as BOT_RESPONSE says
+func (e Env) ingest(route string, h http.HandlerFunc) http.HandlerFunc {
+	return e.rejectWhileDraining(e.Inspector.recordRejections(route, traced(route, e.Bots.guard(route, h))))
 }
 
 func NewMux(e Env) http.Handler {
+	if e.Cfg.RelayAcceptToken != "" && e.Relay == nil {
+		e.Relay = relay.NewAssembler(10*time.Minute, 1000)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", e.Healthz)
 	mux.HandleFunc("/readyz", e.Readyz)
-	mux.HandleFunc("/px.gif", e.Pixel)
-	mux.HandleFunc("/collect", e.Collect)
+	mux.HandleFunc(openAPIPath, e.OpenAPI)
+	mux.HandleFunc("/px.gif", e.ingest("/px.gif", e.Pixel))
+	mux.HandleFunc("/collect", e.ingest("/collect", e.Collect))
+	mux.HandleFunc("/collect.gif", e.ingest("/collect.gif", e.CollectGIF))
+	mux.HandleFunc("/conversion", e.ingest("/conversion", e.Conversion))
 
 	// HMAC authentication endpoints
 	mux.HandleFunc("/hmac.js", e.HMACScript)
@@ -349,18 +671,106 @@ func NewMux(e Env) http.Handler {
 	mux.HandleFunc("/pixel.umd.js", e.ServePixelJS)
 	mux.HandleFunc("/pixel.esm.js", e.ServePixelJS)
 
+	// Admin API (bearer token protected)
+	if e.Cfg.AdminToken != "" {
+		mux.HandleFunc("/_gotrack/admin/clusters", e.requireAdmin(e.AdminClusters))
+		mux.HandleFunc(realtimeStatsPath, e.requireAdmin(e.RealtimeStats))
+		mux.HandleFunc("/_gotrack/admin/reload", e.requireAdmin(e.AdminReload))
+		mux.HandleFunc("/_gotrack/admin/drain", e.requireAdmin(e.AdminDrain))
+		mux.HandleFunc("/_gotrack/admin/cache/purge", e.requireAdmin(e.AdminPurgeCache))
+		mux.HandleFunc("/_gotrack/admin/events", e.requireAdmin(e.AdminEvents))
+		mux.HandleFunc("/_gotrack/api/events", e.requireAdmin(e.QueryEvents))
+		mux.HandleFunc("/_gotrack/api/export", e.requireAdmin(e.ExportEvents))
+		mux.HandleFunc("/_gotrack/admin/status", e.requireAdmin(e.AdminStatus))
+		mux.HandleFunc("/_gotrack/admin/campaign-url", e.requireAdmin(e.AdminCampaignURL))
+		mux.HandleFunc(adminSinksPath, e.requireAdmin(e.AdminSinks))
+		mux.HandleFunc(adminSinksPath+"/", e.requireAdmin(e.AdminSink))
+		if e.ShortLinks != nil {
+			mux.HandleFunc(adminShortLinksPath, e.requireAdmin(e.AdminShortLinks))
+			mux.HandleFunc(adminShortLinksPath+"/", e.requireAdmin(e.AdminShortLink))
+		}
+		mux.HandleFunc("/_gotrack/debug/tail", e.requireAdmin(e.DebugTail))
+		mux.HandleFunc("/_gotrack/admin/ui/", e.AdminDashboard)
+		mux.HandleFunc("/_gotrack/admin/docs/", e.AdminAPIDocs)
+	}
+
+	// Server-to-server bulk import
+	if e.Cfg.ImportToken != "" {
+		mux.HandleFunc("/collect/ndjson", e.ingest("/collect/ndjson", e.CollectNDJSON))
+	}
+
+	// GA4 Measurement Protocol compatibility
+	if e.Cfg.MPAPISecret != "" {
+		mux.HandleFunc("/mp/collect", e.ingest("/mp/collect", e.MeasurementProtocol))
+		mux.HandleFunc("/debug/mp/collect", traced("/debug/mp/collect", e.MeasurementProtocolDebug))
+	}
+
+	// Segment HTTP Tracking API compatibility
+	if e.Cfg.SegmentEnabled {
+		mux.HandleFunc("/v1/t", e.ingest("/v1/t", e.SegmentTrack))
+		mux.HandleFunc("/v1/track", e.ingest("/v1/track", e.SegmentTrack))
+		mux.HandleFunc("/v1/p", e.ingest("/v1/p", e.SegmentPage))
+		mux.HandleFunc("/v1/page", e.ingest("/v1/page", e.SegmentPage))
+		mux.HandleFunc("/v1/i", e.ingest("/v1/i", e.SegmentIdentify))
+		mux.HandleFunc("/v1/identify", e.ingest("/v1/identify", e.SegmentIdentify))
+		mux.HandleFunc("/v1/batch", e.ingest("/v1/batch", e.SegmentBatch))
+	}
+
+	// Email open pixel and click redirects, outbound and short link redirects
+	handlers := map[string]http.HandlerFunc{
+		email.OpenPath:       e.EmailOpen,
+		email.ClickPath:      e.EmailClick,
+		outbound.Path:        e.OutboundRedirect,
+		shortlink.PathPrefix: e.ShortLinkRedirect,
+	}
+	for _, path := range e.linkPaths() {
+		mux.HandleFunc(path, e.Inspector.recordRejections(path, traced(path, e.Bots.guard(path, handlers[path]))))
+	}
+	for _, path := range e.honeypotPaths() {
+		mux.HandleFunc(path, e.Honeypot(path))
+	}
+
+	// A/B experiment variants for pages and backends
+	if e.Cfg.ExperimentsFile != "" {
+		mux.HandleFunc(experimentsPath, e.ExperimentAssignments)
+	}
+
+	// Replica identity for load balancers and operators
+	if e.Cfg.ClusterMode {
+		mux.HandleFunc(clusterInfoPath, e.ClusterInfo)
+	}
+
+	// Edge-to-central relay endpoint
+	if e.Relay != nil {
+		mux.HandleFunc("/relay/batch", e.rejectWhileDraining(e.RelayBatch))
+	}
+
 	//  wrap with proxy
 	if e.Cfg.ForwardDestination != "" {
 		// Validate the destination URL
 		if _, err := url.Parse(e.Cfg.ForwardDestination); err != nil {
-			log.Fatalf("WARNING: Invalid FORWARD_DESTINATION URL: %v.", err)
-			return RequestLogger(cors(mux))
+			log.Printf("WARNING: Invalid FORWARD_DESTINATION URL: %v. Not proxying.", err)
+			return RequestID(RequestLogger(cors(mux)))
 		}
 
-		router := NewMiddlewareRouter(mux, e.Cfg.ForwardDestination, e.HMACAuth, e.Collect)
-		return RequestLogger(MetricsMiddleware(e.Metrics)(cors(router)))
+		router := NewMiddlewareRouter(mux, e.Cfg.ForwardDestination, e.HMACAuth, e.ingest("/collect", e.Collect))
+		router.optionalPaths = e.optionalPaths()
+		router.proxy.pathPrefix = e.Cfg.TrackingPathPrefix
+		if e.Cfg.ProxyMaxHTMLBytes > 0 {
+			router.proxy.maxHTMLBytes = e.Cfg.ProxyMaxHTMLBytes
+		}
+		router.proxy.cache = e.ProxyCache
+		router.proxy.trustedPeer = func(r *http.Request) bool { return event.TrustedPeer(r, e.Cfg) }
+		router.proxy.injector = e.Injector
+		router.proxy.clientHints = e.Cfg.ClientHints
+		router.proxy.trapPath = e.Cfg.HoneypotLinkPath
+		if e.Cfg.ProxyOriginSecret != "" {
+			router.proxy.originSecret = []byte(e.Cfg.ProxyOriginSecret)
+		}
+		return RequestID(RequestLogger(withInstance(e.instance(), aliasTrackingPaths(e.Cfg.TrackingPathPrefix, MetricsMiddleware(e.Metrics, e.metricsRoute())(restrictRoutes(e.Routes, cors(router)))))))
 	}
 
-	// Apply CORS, metrics, and request logging middleware
-	return RequestLogger(MetricsMiddleware(e.Metrics)(cors(mux)))
+	// Apply CORS, route sets, metrics, path alias, instance header, request
+	// logging and request ID middleware
+	return RequestID(RequestLogger(withInstance(e.instance(), aliasTrackingPaths(e.Cfg.TrackingPathPrefix, MetricsMiddleware(e.Metrics, e.metricsRoute())(restrictRoutes(e.Routes, cors(mux)))))))
 }
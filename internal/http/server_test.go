@@ -1,13 +1,24 @@
 package httpx
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/internal/metrics"
+	"github.com/shortontech/gotrack/internal/proxycache"
+	"github.com/shortontech/gotrack/internal/shortlink"
+	"github.com/shortontech/gotrack/pkg/config"
 )
 
 // TestIsHTMLContent tests HTML content type detection
@@ -103,7 +114,7 @@ func TestInjectPixel(t *testing.T) {
 	t.Run("injects before closing body tag", func(t *testing.T) {
 		html := []byte("<html><body><h1>Hello</h1></body></html>")
 		req := httptest.NewRequest(http.MethodGet, "/test?utm_source=test", nil)
-		result := string(injectPixel(html, req, nil))
+		result := string(injectPixel(html, req, nil, ""))
 		assertPixelInjected(t, result, "</body>")
 		if !strings.Contains(result, `<img src="/px.gif?e=pageview&amp;auto=1&amp;url=`) {
 			t.Errorf("should inject pixel with proper URL encoding, got: %s", result)
@@ -116,14 +127,14 @@ func TestInjectPixel(t *testing.T) {
 	t.Run("injects before closing html tag when no body tag", func(t *testing.T) {
 		html := []byte("<html><div>Content</div></html>")
 		req := httptest.NewRequest(http.MethodGet, "/page", nil)
-		result := string(injectPixel(html, req, nil))
+		result := string(injectPixel(html, req, nil, ""))
 		assertPixelInjected(t, result, "</html>")
 	})
 
 	t.Run("appends to end when no closing tags", func(t *testing.T) {
 		html := []byte("<div>Content without closing tags")
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
-		result := string(injectPixel(html, req, nil))
+		result := string(injectPixel(html, req, nil, ""))
 		assertPixelInjected(t, result, "")
 		if !strings.HasSuffix(strings.TrimSpace(result), `alt="">`) {
 			t.Error("pixel should be appended to end")
@@ -134,7 +145,7 @@ func TestInjectPixel(t *testing.T) {
 		html := []byte("<html><body>Test</body></html>")
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
 		auth := NewHMACAuth("test-secret", "")
-		result := string(injectPixel(html, req, auth))
+		result := string(injectPixel(html, req, auth, ""))
 		if !strings.Contains(result, `<script src="/hmac.js"></script>`) {
 			t.Error("should include HMAC script")
 		}
@@ -144,7 +155,7 @@ func TestInjectPixel(t *testing.T) {
 	t.Run("handles case insensitive closing tags", func(t *testing.T) {
 		html := []byte("<html><body>Test</BODY></html>")
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
-		result := string(injectPixel(html, req, nil))
+		result := string(injectPixel(html, req, nil, ""))
 		assertPixelInjected(t, result, "")
 		if !strings.Contains(result, "</body>") && !strings.Contains(result, "</BODY>") {
 			t.Error("should preserve body closing tag (case may change)")
@@ -154,16 +165,33 @@ func TestInjectPixel(t *testing.T) {
 	t.Run("escapes special characters in URL", func(t *testing.T) {
 		html := []byte("<html><body>Test</body></html>")
 		req := httptest.NewRequest(http.MethodGet, "/test?q=foo&bar=baz<script>", nil)
-		result := string(injectPixel(html, req, nil))
+		result := string(injectPixel(html, req, nil, ""))
 		if strings.Contains(result, "<script>") && !strings.Contains(result, `%3Cscript%3E`) {
 			t.Error("special characters should be escaped in URL")
 		}
 	})
 
+	t.Run("uses aliased paths with a prefix", func(t *testing.T) {
+		html := []byte("<html><body>Test</body></html>")
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		result := string(injectPixel(html, req, NewHMACAuth("test-secret", ""), "/assets/a7f3"))
+		for _, want := range []string{
+			`<script src="/assets/a7f3/hmac.js"></script>`,
+			`<img src="/assets/a7f3/px.gif?e=pageview`,
+		} {
+			if !strings.Contains(result, want) {
+				t.Errorf("missing %s", want)
+			}
+		}
+		if strings.Contains(result, "window.GO_TRACK_URL=") {
+			t.Error("injected pages should keep posting to their own URL")
+		}
+	})
+
 	t.Run("handles path without query string", func(t *testing.T) {
 		html := []byte("<html><body>Test</body></html>")
 		req := httptest.NewRequest(http.MethodGet, "/simple", nil)
-		result := string(injectPixel(html, req, nil))
+		result := string(injectPixel(html, req, nil, ""))
 		if !strings.Contains(result, `url=%2Fsimple"`) {
 			t.Error("should encode simple path")
 		}
@@ -178,6 +206,11 @@ func TestIsTrackingPath(t *testing.T) {
 	}{
 		{"/px.gif", true},
 		{"/collect", true},
+		{"/collect.gif", true},
+		{"/collect/ndjson", true},
+		{"/mp/collect", true},
+		{"/v1/batch", true},
+		{"/v1/t", true},
 		{"/healthz", true},
 		{"/readyz", true},
 		{"/metrics", true},
@@ -186,6 +219,9 @@ func TestIsTrackingPath(t *testing.T) {
 		{"/pixel.js", true},
 		{"/pixel.umd.js", true},
 		{"/pixel.esm.js", true},
+		{"/relay/batch", true},
+		{"/_gotrack/admin/clusters", true},
+		{"/admin/clusters", false},
 		{"/", false},
 		{"/index.html", false},
 		{"/api/users", false},
@@ -207,6 +243,45 @@ func TestIsTrackingPath(t *testing.T) {
 	}
 }
 
+func TestMetricsRoute(t *testing.T) {
+	proxied := Env{Cfg: config.Config{ForwardDestination: "http://origin"}}.metricsRoute()
+	direct := Env{}.metricsRoute()
+	for path, want := range map[string]string{
+		"/collect":                  "/collect",
+		"/v1/batch":                 "/v1/batch",
+		"/_gotrack/admin/ui/app.js": "admin",
+		"/_gotrack/admin/reload":    "admin",
+		"/products/1234":            "proxy",
+		"/collect/extra":            "proxy",
+		"/wp-login.php":             "proxy",
+	} {
+		if got := proxied(path); got != want {
+			t.Errorf("proxied route(%q) = %q, want %q", path, got, want)
+		}
+	}
+	if got := direct("/wp-login.php"); got != "other" {
+		t.Errorf("route of an unknown path without a proxy = %q, want other", got)
+	}
+	// Short link codes share one label
+	links := Env{ShortLinks: shortlink.NewStore(kv.NewMemoryStore())}.metricsRoute()
+	if got := links("/s/spring"); got != "/s/" {
+		t.Errorf("route of a short link = %q, want /s/", got)
+	}
+	if route := (Env{Cfg: config.Config{MetricsDetailedPaths: true}}).metricsRoute(); route != nil {
+		t.Error("METRICS_DETAILED_PATHS should keep raw paths")
+	}
+
+	m := metrics.InitMetrics()
+	before := testutil.ToFloat64(m.HTTPRequests.WithLabelValues("proxy", http.MethodGet, "200"))
+	handler := MetricsMiddleware(m, proxied)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for _, path := range []string{"/a", "/b", "/c"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if got := testutil.ToFloat64(m.HTTPRequests.WithLabelValues("proxy", http.MethodGet, "200")); got != before+3 {
+		t.Errorf("proxied requests = %v, want %v", got, before+3)
+	}
+}
+
 // TestNewProxyHandler tests proxy handler creation
 func TestNewProxyHandler(t *testing.T) {
 	t.Run("creates handler with destination", func(t *testing.T) {
@@ -228,8 +303,13 @@ func TestNewProxyHandler(t *testing.T) {
 			t.Error("client should not be nil")
 		}
 
-		if handler.client.Timeout != 30*time.Second {
-			t.Errorf("client timeout = %v, want 30s", handler.client.Timeout)
+		// Streams and websockets outlive any overall timeout; only the
+		// wait for response headers is bounded
+		if handler.client.Timeout != 0 {
+			t.Errorf("client timeout = %v, want none", handler.client.Timeout)
+		}
+		if rt := handler.client.Transport.(*http.Transport).ResponseHeaderTimeout; rt != 25*time.Second {
+			t.Errorf("response header timeout = %v, want 25s", rt)
 		}
 	})
 
@@ -420,6 +500,218 @@ func TestProxyHandlerServeHTTP(t *testing.T) {
 	})
 }
 
+func TestProxyHandlerStreaming(t *testing.T) {
+	t.Run("flushes each chunk of a non-HTML response", func(t *testing.T) {
+		next := make(chan struct{})
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "data: one\n\n")
+			w.(http.Flusher).Flush()
+			<-next
+			_, _ = io.WriteString(w, "data: two\n\n")
+		}))
+		defer backend.Close()
+		defer close(next)
+
+		proxy := httptest.NewServer(NewProxyHandler(backend.URL, nil))
+		defer proxy.Close()
+
+		resp, err := http.Get(proxy.URL + "/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		// The first event arrives while the upstream is still writing
+		line, err := bufio.NewReader(resp.Body).ReadString('\n')
+		if err != nil || line != "data: one\n" {
+			t.Errorf("first line = %q, %v", line, err)
+		}
+	})
+
+	t.Run("streams HTML over the size limit without a pixel", func(t *testing.T) {
+		page := "<html><body>" + strings.Repeat("x", 2048) + "</body></html>"
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, page)
+		}))
+		defer backend.Close()
+
+		handler := NewProxyHandler(backend.URL, nil)
+		handler.maxHTMLBytes = 1024
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/big", nil))
+		if w.Body.String() != page {
+			t.Errorf("large page changed: %d bytes, want %d", w.Body.Len(), len(page))
+		}
+
+		handler.maxHTMLBytes = 1 << 20
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/big", nil))
+		if !strings.Contains(w.Body.String(), `<img src="/px.gif`) {
+			t.Error("page under the limit should get the pixel")
+		}
+	})
+
+	t.Run("serves gzipped HTML that expands past the limit unchanged", func(t *testing.T) {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		_, _ = io.WriteString(gz, "<html><body>"+strings.Repeat("x", 1<<16)+"</body></html>")
+		_ = gz.Close()
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(compressed.Bytes())
+		}))
+		defer backend.Close()
+
+		handler := NewProxyHandler(backend.URL, nil)
+		handler.maxHTMLBytes = 4096
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if !bytes.Equal(w.Body.Bytes(), compressed.Bytes()) {
+			t.Error("compressed page should be served as received")
+		}
+	})
+
+	t.Run("drops hop-by-hop headers", func(t *testing.T) {
+		var received http.Header
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Clone()
+			w.Header().Set("Keep-Alive", "timeout=5")
+			w.Header().Set("X-App", "1")
+		}))
+		defer backend.Close()
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Connection", "X-Secret")
+		req.Header.Set("X-Secret", "hop")
+		req.Header.Set("Proxy-Authorization", "Basic abc")
+		w := httptest.NewRecorder()
+		NewProxyHandler(backend.URL, nil).ServeHTTP(w, req)
+
+		if received.Get("X-Secret") != "" || received.Get("Proxy-Authorization") != "" {
+			t.Errorf("hop-by-hop request headers forwarded: %v", received)
+		}
+		if w.Header().Get("Keep-Alive") != "" || w.Header().Get("X-App") != "1" {
+			t.Errorf("response headers = %v", w.Header())
+		}
+	})
+}
+
+func TestProxyHandlerUpgrade(t *testing.T) {
+	// A minimal upgrade endpoint that echoes lines after switching protocols
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" || !isUpgradeRequest(r) {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = brw.Flush()
+		line, _ := brw.ReadString('\n')
+		_, _ = brw.WriteString("echo: " + line)
+		_ = brw.Flush()
+	}))
+	defer backend.Close()
+
+	// Wrapped like NewMux does, so hijacking goes through the middleware
+	proxy := httptest.NewServer(MetricsMiddleware(metrics.InitMetrics(), nil)(NewProxyHandler(backend.URL, nil)))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, _ = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("status = %d, upgrade = %q", resp.StatusCode, resp.Header.Get("Upgrade"))
+	}
+
+	_, _ = io.WriteString(conn, "hello\n")
+	if line, err := br.ReadString('\n'); err != nil || line != "echo: hello\n" {
+		t.Errorf("tunneled reply = %q, %v", line, err)
+	}
+
+	t.Run("passes on a refused upgrade", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/ws", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUpgradeRequired {
+			t.Errorf("status = %d, want 426", resp.StatusCode)
+		}
+	})
+}
+
+func TestProxyHandlerCache(t *testing.T) {
+	hits := map[string]int{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		switch r.URL.Path {
+		case "/app.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Header().Set("Cache-Control", "public, max-age=300")
+			_, _ = io.WriteString(w, "body{}")
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Cache-Control", "public, max-age=300")
+			_, _ = io.WriteString(w, "<html><body></body></html>")
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{}`)
+		}
+	}))
+	defer backend.Close()
+
+	cache, err := proxycache.New(proxycache.NewMemoryStore(), 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewProxyHandler(backend.URL, nil)
+	handler.cache = cache
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := get("/app.css"); w.Body.String() != "body{}" || w.Header().Get("Content-Type") != "text/css" {
+			t.Fatalf("request %d: body = %q, headers = %v", i, w.Body.String(), w.Header())
+		}
+		get("/page")
+		get("/api")
+	}
+	if hits["/app.css"] != 1 {
+		t.Errorf("cacheable asset fetched %d times, want 1", hits["/app.css"])
+	}
+	if hits["/page"] != 3 {
+		t.Errorf("HTML fetched %d times, want 3: pages are never cached", hits["/page"])
+	}
+	if hits["/api"] != 3 {
+		t.Errorf("response without a lifetime fetched %d times, want 3", hits["/api"])
+	}
+}
+
 // TestNewMiddlewareRouter tests middleware router creation
 func TestNewMiddlewareRouter(t *testing.T) {
 	mux := http.NewServeMux()
@@ -0,0 +1,153 @@
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/shortontech/gotrack/internal/logging"
+	"github.com/shortontech/gotrack/internal/shortlink"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// ShortLinkClickEvent is the event type of /s/<code> redirects
+const ShortLinkClickEvent = "short_link_click"
+
+// adminShortLinksPath creates links; a code after it reads or deletes one
+const adminShortLinksPath = "/_gotrack/admin/links"
+
+// GET /s/<code> — records a click on a short link and redirects to its
+// destination with the link's UTM parameters. The visitor is redirected even
+// when the click isn't recorded.
+func (e Env) ShortLinkRedirect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	link, err := e.ShortLinks.Get(r.Context(), strings.TrimPrefix(r.URL.Path, shortlink.PathPrefix))
+	if errors.Is(err, shortlink.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logging.Debugf("Short link lookup failed: %v", err)
+		http.Error(w, "short link lookup failed", http.StatusServiceUnavailable)
+		return
+	}
+	dest := link.URL()
+	// HEAD requests come from link unfurlers and checkers, not visitors
+	if r.Method == http.MethodGet {
+		if link.WriteKey != "" {
+			r = r.Clone(r.Context())
+			r.Header.Set(writeKeyHeader, link.WriteKey)
+		}
+		e.recordLinkEvent(w, r, shortLinkEvent(link.Code, dest))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, dest, http.StatusFound)
+}
+
+// shortLinkEvent builds the event of a short link click: the page and
+// campaign are the destination's, and the code is the campaign when the link
+// has none
+func shortLinkEvent(code, dest string) event.Event {
+	ev := event.Event{
+		Type:  ShortLinkClickEvent,
+		Props: map[string]string{"short_link": code},
+	}
+	if u, err := url.Parse(dest); err == nil {
+		ev.Route.Domain = u.Hostname()
+		ev.Route.Protocol = u.Scheme
+		ev.Route.Path = u.Path
+		ev.Route.FullPath = u.RequestURI()
+		ev.Route.Hash = u.Fragment
+		event.ApplyCampaignParams(u.Query(), &ev)
+	}
+	if ev.URL.UTM.Campaign == "" {
+		ev.URL.UTM.Campaign = code
+	}
+	return ev
+}
+
+// shortLinkResponse is a link as the admin API returns it
+type shortLinkResponse struct {
+	shortlink.Link
+	Path string `json:"path"` // redirect path on the tracking host
+	URL  string `json:"url"`  // destination with the UTM parameters
+}
+
+func newShortLinkResponse(l shortlink.Link) shortLinkResponse {
+	return shortLinkResponse{Link: l, Path: shortlink.PathPrefix + l.Code, URL: l.URL()}
+}
+
+// AdminShortLinks creates a short link from a JSON body with a destination,
+// and optionally a code, utm parameters and a tenant write_key
+func (e Env) AdminShortLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var link shortlink.Link
+	dec := json.NewDecoder(io.LimitReader(r.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&link); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if link.WriteKey != "" && e.Tenants != nil {
+		if _, ok := e.Tenants.Lookup(link.WriteKey); !ok {
+			http.Error(w, "unknown write_key", http.StatusBadRequest)
+			return
+		}
+	}
+	link, err := e.ShortLinks.Create(r.Context(), link)
+	switch {
+	case errors.Is(err, shortlink.ErrExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, shortlink.ErrInvalidCode), errors.Is(err, shortlink.ErrInvalidDestination):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "failed to store short link", http.StatusInternalServerError)
+		return
+	}
+	e.auditLog(r, strings.TrimSpace(r.Header.Get(actorHeader)), "links.create", map[string]any{"code": link.Code, "destination": link.Destination})
+	writeJSON(w, http.StatusCreated, newShortLinkResponse(link))
+}
+
+// AdminShortLink returns (GET) or deletes (DELETE) the short link whose code
+// follows /_gotrack/admin/links/
+func (e Env) AdminShortLink(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimPrefix(r.URL.Path, adminShortLinksPath+"/")
+	switch r.Method {
+	case http.MethodGet:
+		link, err := e.ShortLinks.Get(r.Context(), code)
+		if errors.Is(err, shortlink.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "short link lookup failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, newShortLinkResponse(link))
+	case http.MethodDelete:
+		err := e.ShortLinks.Delete(r.Context(), code)
+		if errors.Is(err, shortlink.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to delete short link", http.StatusInternalServerError)
+			return
+		}
+		e.auditLog(r, strings.TrimSpace(r.Header.Get(actorHeader)), "links.delete", map[string]any{"code": code})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,112 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/internal/shortlink"
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestShortLinks(t *testing.T) {
+	var emitted []event.Event
+	env := Env{
+		Cfg:        cfg.Config{AdminToken: "admin-token", ShortLinks: true},
+		Emit:       func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) },
+		ShortLinks: shortlink.NewStore(kv.NewMemoryStore()),
+		Tenants:    newTestTenants(t),
+	}
+	handler := NewMux(env)
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		emitted = nil
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if strings.HasPrefix(target, adminShortLinksPath) {
+			req.Header.Set("Authorization", "Bearer admin-token")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodPost, adminShortLinksPath, `{"code":"spring","destination":"https://shop.example/sale","utm":{"source":"newsletter","medium":"email"},"write_key":"wk_shop"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d: %s", w.Code, w.Body)
+	}
+	var created shortLinkResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Path != "/s/spring" || created.URL != "https://shop.example/sale?utm_medium=email&utm_source=newsletter" {
+		t.Errorf("created = %+v", created)
+	}
+
+	t.Run("redirect", func(t *testing.T) {
+		w := serve(http.MethodGet, "/s/spring", "")
+		if w.Code != http.StatusFound || w.Header().Get("Location") != created.URL {
+			t.Fatalf("status = %d, location = %q", w.Code, w.Header().Get("Location"))
+		}
+		if len(emitted) != 1 {
+			t.Fatalf("emitted = %d, want 1", len(emitted))
+		}
+		ev := emitted[0]
+		if ev.Type != ShortLinkClickEvent || ev.Props["short_link"] != "spring" || ev.SiteID != "shop" {
+			t.Errorf("event = %+v", ev)
+		}
+		// The code is the campaign when the link has none
+		if ev.URL.UTM.Source != "newsletter" || ev.URL.UTM.Campaign != "spring" || ev.Route.Path != "/sale" {
+			t.Errorf("utm = %+v, route = %+v", ev.URL.UTM, ev.Route)
+		}
+	})
+
+	t.Run("HEAD redirects without recording", func(t *testing.T) {
+		if w := serve(http.MethodHead, "/s/spring", ""); w.Code != http.StatusFound || len(emitted) != 0 {
+			t.Errorf("status = %d, emitted = %d", w.Code, len(emitted))
+		}
+	})
+
+	t.Run("create errors", func(t *testing.T) {
+		for body, want := range map[string]int{
+			`{"code":"spring","destination":"https://shop.example/"}`:       http.StatusConflict,
+			`{"destination":"javascript:alert(1)"}`:                         http.StatusBadRequest,
+			`{"code":"a b","destination":"https://shop.example/"}`:          http.StatusBadRequest,
+			`{"destination":"https://shop.example/","write_key":"wk_nope"}`: http.StatusBadRequest,
+			`{"destination":"https://shop.example/","unknown":true}`:        http.StatusBadRequest,
+		} {
+			if w := serve(http.MethodPost, adminShortLinksPath, body); w.Code != want {
+				t.Errorf("create %s = %d, want %d", body, w.Code, want)
+			}
+		}
+	})
+
+	t.Run("get and delete", func(t *testing.T) {
+		if w := serve(http.MethodGet, adminShortLinksPath+"/spring", ""); w.Code != http.StatusOK {
+			t.Errorf("get = %d", w.Code)
+		}
+		if w := serve(http.MethodDelete, adminShortLinksPath+"/spring", ""); w.Code != http.StatusNoContent {
+			t.Errorf("delete = %d", w.Code)
+		}
+		if w := serve(http.MethodGet, "/s/spring", ""); w.Code != http.StatusNotFound || len(emitted) != 0 {
+			t.Errorf("deleted link = %d, emitted = %d", w.Code, len(emitted))
+		}
+		if w := serve(http.MethodDelete, adminShortLinksPath+"/spring", ""); w.Code != http.StatusNotFound {
+			t.Errorf("second delete = %d", w.Code)
+		}
+	})
+}
+
+func TestShortLinkEvent_Campaign(t *testing.T) {
+	ev := shortLinkEvent("fb1", "https://shop.example/?utm_campaign=spring&gclid=abc")
+	if ev.URL.UTM.Campaign != "spring" || ev.URL.Google.GCLID != "abc" {
+		t.Errorf("event url = %+v", ev.URL)
+	}
+	if _, err := url.Parse(ev.Route.FullPath); err != nil || ev.Route.Domain != "shop.example" {
+		t.Errorf("route = %+v", ev.Route)
+	}
+}
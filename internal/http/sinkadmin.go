@@ -0,0 +1,195 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/shortontech/gotrack/internal/sink"
+)
+
+// adminSinksPath lists and adds sinks; a sink name after it removes one, and
+// /pause, /resume or /flush after the name act on it
+const adminSinksPath = "/_gotrack/admin/sinks"
+
+// sinkNamePattern restricts the names of sinks added at runtime
+var sinkNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// sinkControlStatus is one sink as the sink admin API reports it
+type sinkControlStatus struct {
+	sinkStatus
+	Paused bool `json:"paused"`
+	Added  bool `json:"added,omitempty"` // added through the admin API; removed on restart
+	sink.MemberStats
+}
+
+// sinkFlushResponse is the answer to a flush
+type sinkFlushResponse struct {
+	Name    string `json:"name"`
+	Flushed int    `json:"flushed"` // events written out; 0 for sinks that don't buffer
+}
+
+// addSinkRequest is the body that adds a sink. Only NDJSON log sinks can be
+// added, for watching events while debugging.
+type addSinkRequest struct {
+	Type string `json:"type"` // log, the default
+	Name string `json:"name"` // debug when empty
+	Path string `json:"path"` // stdout (the default), or a file name in the temp directory
+}
+
+// describeSinks reports members' health, pause state and delivery counts
+func describeSinks(ctx context.Context, members []*sink.Member) []sinkControlStatus {
+	sinks := make([]sink.Sink, len(members))
+	for i, m := range members {
+		sinks[i] = m.Sink
+	}
+	statuses := sinkStatuses(ctx, sinks)
+	described := make([]sinkControlStatus, len(members))
+	for i, m := range members {
+		described[i] = sinkControlStatus{sinkStatus: statuses[i], Paused: m.Paused(), Added: m.Added, MemberStats: m.Stats()}
+	}
+	return described
+}
+
+// AdminSinks lists the sinks with their status and delivery counts (GET), or
+// adds a log sink for debugging (POST)
+func (e Env) AdminSinks(w http.ResponseWriter, r *http.Request) {
+	if e.SinkSet == nil {
+		http.Error(w, "sink control not enabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, describeSinks(r.Context(), e.SinkSet.Members()))
+	case http.MethodPost:
+		e.addSink(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (e Env) addSink(w http.ResponseWriter, r *http.Request) {
+	var req addSinkRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Type != "" && req.Type != "log" {
+		http.Error(w, "only log sinks can be added", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		req.Name = "debug"
+	}
+	if !sinkNamePattern.MatchString(req.Name) {
+		http.Error(w, "invalid sink name (lowercase letters, digits, - and _)", http.StatusBadRequest)
+		return
+	}
+	path := "stdout"
+	if req.Path != "" && req.Path != "stdout" {
+		// A bare file name, so the admin API can't write anywhere else
+		if filepath.Base(req.Path) != req.Path || strings.HasPrefix(req.Path, ".") {
+			http.Error(w, "path must be stdout or a file name", http.StatusBadRequest)
+			return
+		}
+		path = filepath.Join(os.TempDir(), req.Path)
+	}
+	if e.SinkSet.Get(req.Name) != nil {
+		http.Error(w, sink.ErrSinkExists.Error(), http.StatusConflict)
+		return
+	}
+
+	s := sink.NewLogSinkWithConfig(sink.LogConfig{Name: req.Name, Path: path})
+	if err := s.Start(context.Background()); err != nil {
+		http.Error(w, "failed to start sink: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m, err := e.SinkSet.Add(s)
+	if err != nil {
+		_ = s.Close()
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	e.auditLog(r, strings.TrimSpace(r.Header.Get(actorHeader)), "sinks.add", map[string]any{"name": req.Name, "path": path})
+	writeJSON(w, http.StatusCreated, describeSinks(r.Context(), []*sink.Member{m})[0])
+}
+
+// AdminSink acts on the sink named after /_gotrack/admin/sinks/: POST
+// .../pause stops sending it events, .../resume sends them again and
+// .../flush writes out what it buffers. DELETE removes a sink added through
+// the admin API; configured sinks can only be paused.
+func (e Env) AdminSink(w http.ResponseWriter, r *http.Request) {
+	if e.SinkSet == nil {
+		http.Error(w, "sink control not enabled", http.StatusNotFound)
+		return
+	}
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, adminSinksPath+"/"), "/")
+	if action == "" && r.Method == http.MethodDelete {
+		e.removeSink(w, r, name)
+		return
+	}
+	if action == "" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m := e.SinkSet.Get(name)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor := strings.TrimSpace(r.Header.Get(actorHeader))
+	switch action {
+	case "pause":
+		m.Pause()
+	case "resume":
+		m.Resume()
+	case "flush":
+		flushed := 0
+		if f, ok := m.Sink.(sink.Flusher); ok {
+			n, err := f.Flush(r.Context())
+			if err != nil {
+				http.Error(w, "flush failed: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+			flushed = n
+		}
+		e.auditLog(r, actor, "sinks.flush", map[string]any{"name": name, "flushed": flushed})
+		writeJSON(w, http.StatusOK, sinkFlushResponse{Name: name, Flushed: flushed})
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	e.auditLog(r, actor, "sinks."+action, map[string]any{"name": name})
+	writeJSON(w, http.StatusOK, describeSinks(r.Context(), []*sink.Member{m})[0])
+}
+
+func (e Env) removeSink(w http.ResponseWriter, r *http.Request, name string) {
+	m, err := e.SinkSet.Remove(name)
+	switch {
+	case errors.Is(err, sink.ErrSinkNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, sink.ErrSinkConfigured):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err := m.Sink.Close(); err != nil {
+		http.Error(w, "sink removed, but closing it failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.auditLog(r, strings.TrimSpace(r.Header.Get(actorHeader)), "sinks.remove", map[string]any{"name": name})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,90 @@
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/sink"
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestAdminSinks(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir()) // where added log sinks write
+	set := sink.NewSet([]sink.Sink{&fakeSink{name: "postgres", pingErr: errors.New("connection refused")}})
+	handler := NewMux(Env{Cfg: cfg.Config{AdminToken: "admin-token"}, SinkSet: set})
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-token")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(http.MethodPost, adminSinksPath+"/postgres/pause", ""); w.Code != http.StatusOK {
+		t.Fatalf("pause = %d %s", w.Code, w.Body)
+	}
+	set.Get("postgres").Skip()
+	w := serve(http.MethodGet, adminSinksPath, "")
+	var listed []sinkControlStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || !listed[0].Paused || listed[0].Status != "unavailable" || listed[0].Skipped != 1 {
+		t.Errorf("list = %s", w.Body)
+	}
+	if w := serve(http.MethodPost, adminSinksPath+"/postgres/resume", ""); w.Code != http.StatusOK || set.Get("postgres").Paused() {
+		t.Errorf("resume = %d %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodPost, adminSinksPath+"/postgres/flush", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"flushed":0`) {
+		t.Errorf("flush = %d %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodDelete, adminSinksPath+"/postgres", ""); w.Code != http.StatusConflict {
+		t.Errorf("removing a configured sink = %d, want 409", w.Code)
+	}
+	if w := serve(http.MethodPost, adminSinksPath+"/missing/pause", ""); w.Code != http.StatusNotFound {
+		t.Errorf("pausing an unknown sink = %d, want 404", w.Code)
+	}
+
+	t.Run("debug log sink", func(t *testing.T) {
+		for _, body := range []string{`{"path":"../escape.ndjson"}`, `{"path":"/etc/passwd"}`, `{"name":"Bad Name"}`, `{"type":"kafka"}`} {
+			if w := serve(http.MethodPost, adminSinksPath, body); w.Code != http.StatusBadRequest {
+				t.Errorf("add %s = %d, want 400", body, w.Code)
+			}
+		}
+		if w := serve(http.MethodPost, adminSinksPath, `{"name":"postgres"}`); w.Code != http.StatusConflict {
+			t.Errorf("add over a configured name = %d, want 409", w.Code)
+		}
+
+		w := serve(http.MethodPost, adminSinksPath, `{"name":"debug","path":"debug.ndjson"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("add = %d %s", w.Code, w.Body)
+		}
+		m := set.Get("debug")
+		if m == nil || !m.Added {
+			t.Fatalf("debug sink not in the set")
+		}
+		if err := m.Sink.Enqueue(event.Event{EventID: "debug-1"}); err != nil {
+			t.Fatal(err)
+		}
+		if w := serve(http.MethodPost, adminSinksPath+"/debug/flush", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"flushed":1`) {
+			t.Errorf("flush = %d %s", w.Code, w.Body)
+		}
+		if w := serve(http.MethodDelete, adminSinksPath+"/debug", ""); w.Code != http.StatusNoContent {
+			t.Errorf("remove = %d %s", w.Code, w.Body)
+		}
+		data, err := os.ReadFile(filepath.Join(os.TempDir(), "debug.ndjson"))
+		if err != nil || !strings.Contains(string(data), "debug-1") {
+			t.Errorf("debug file = %q, %v", data, err)
+		}
+		if set.Get("debug") != nil {
+			t.Error("debug sink still in the set")
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package httpx
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/analytics"
+)
+
+// realtimeStatsPath answers live traffic counts
+const realtimeStatsPath = "/stats/realtime"
+
+// realtimeResponse is the answer to /stats/realtime
+type realtimeResponse struct {
+	GeneratedAt time.Time               `json:"generated_at"`
+	Last5m      analytics.RealtimeStats `json:"last_5m"`
+	Last15m     analytics.RealtimeStats `json:"last_15m"`
+	Last60m     analytics.RealtimeStats `json:"last_60m"`
+}
+
+// RealtimeStats returns event counts by type, the top UTM sources and
+// referrers and the bot share over the last 5, 15 and 60 minutes, counted on
+// this instance as events are ingested.
+// Query params: limit (top sources and referrers, default 10, max 100).
+func (e Env) RealtimeStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.Realtime == nil {
+		http.Error(w, "realtime stats not enabled", http.StatusNotFound)
+		return
+	}
+
+	limit := queryInt(r, "limit", 10)
+	if limit <= 0 || limit > 100 {
+		limit = 10
+	}
+	writeJSON(w, http.StatusOK, realtimeResponse{
+		GeneratedAt: time.Now().UTC(),
+		Last5m:      e.Realtime.Stats(5, limit),
+		Last15m:     e.Realtime.Stats(15, limit),
+		Last60m:     e.Realtime.Stats(60, limit),
+	})
+}
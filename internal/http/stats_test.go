@@ -0,0 +1,54 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/analytics"
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestRealtimeStats(t *testing.T) {
+	realtime := analytics.NewRealtime(80)
+	for _, source := range []string{"google", "google", "newsletter"} {
+		ev := event.Event{Type: "pageview"}
+		ev.URL.UTM.Source = source
+		realtime.Observe(ev)
+	}
+	bot := event.Event{Type: "click"}
+	bot.Server.Detection.Honeypot = true
+	realtime.Observe(bot)
+
+	mux := NewMux(Env{Cfg: cfg.Config{AdminToken: "admin-token"}, Realtime: realtime})
+
+	t.Run("returns counts per window", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAdminRequest(realtimeStatsPath+"?limit=1"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+		}
+		var resp realtimeResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		for _, stats := range []analytics.RealtimeStats{resp.Last5m, resp.Last15m, resp.Last60m} {
+			if stats.Events != 4 || stats.BotEvents != 1 || stats.BotPercent != 25 || stats.Types["pageview"] != 3 {
+				t.Errorf("%d minutes = %+v", stats.Minutes, stats)
+			}
+			if len(stats.UTMSources) != 1 || stats.UTMSources[0].Value != "google" {
+				t.Errorf("%d minute sources = %v", stats.Minutes, stats.UTMSources)
+			}
+		}
+	})
+
+	t.Run("requires the admin token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, realtimeStatsPath, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", w.Code)
+		}
+	})
+}
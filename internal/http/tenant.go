@@ -0,0 +1,216 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/shortontech/gotrack/pkg/config"
+)
+
+// Write keys identify the tenant of an ingestion request. The header takes
+// precedence; the query parameter serves pixels and sendBeacon, which cannot
+// set headers.
+const (
+	writeKeyHeader = "X-GoTrack-Write-Key"
+	writeKeyParam  = "write_key"
+)
+
+// Tenant is a resolved site with its per-site settings
+type Tenant struct {
+	ID      string
+	origins map[string]bool // normalized scheme://host[:port]; empty allows any
+	hmac    *HMACAuth       // nil uses the instance-wide HMAC secret
+	outputs map[string]bool // sink names; empty means every sink
+}
+
+// Tenants maps write keys to sites. A nil *Tenants means single-tenant mode:
+// no write key is needed and events carry no site_id.
+type Tenants struct {
+	mu    sync.RWMutex
+	byKey map[string]*Tenant
+	byID  map[string]*Tenant
+}
+
+// NewTenants builds the registry from tenant definitions
+func NewTenants(defs []config.Tenant) (*Tenants, error) {
+	t := &Tenants{}
+	if err := t.Update(defs); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Update replaces the tenant definitions, e.g. on reload. Sites keep their
+// HMAC state, so a changed secret is rotated and the old one stays valid
+// until the next change, as with the instance-wide secret.
+func (t *Tenants) Update(defs []config.Tenant) error {
+	t.mu.RLock()
+	previous := t.byID
+	t.mu.RUnlock()
+
+	byKey := make(map[string]*Tenant)
+	byID := make(map[string]*Tenant, len(defs))
+	for _, def := range defs {
+		tenant := &Tenant{ID: def.SiteID}
+		for _, o := range def.AllowedOrigins {
+			origin, ok := normalizeOrigin(o)
+			if !ok {
+				return fmt.Errorf("tenant %s: invalid allowed origin %q", def.SiteID, o)
+			}
+			if tenant.origins == nil {
+				tenant.origins = make(map[string]bool)
+			}
+			tenant.origins[origin] = true
+		}
+		for _, name := range def.Outputs {
+			if tenant.outputs == nil {
+				tenant.outputs = make(map[string]bool)
+			}
+			tenant.outputs[strings.TrimSpace(name)] = true
+		}
+		byID[def.SiteID] = tenant
+		for _, key := range def.WriteKeys {
+			byKey[key] = tenant
+		}
+	}
+
+	// Only rotate secrets once every definition is known to be valid
+	for _, def := range defs {
+		if def.HMACSecret == "" {
+			continue
+		}
+		if old := previous[def.SiteID]; old != nil && old.hmac != nil {
+			old.hmac.Rotate(def.HMACSecret, def.HMACPublicKey)
+			byID[def.SiteID].hmac = old.hmac
+		} else {
+			byID[def.SiteID].hmac = NewHMACAuth(def.HMACSecret, def.HMACPublicKey)
+		}
+	}
+
+	t.mu.Lock()
+	t.byKey, t.byID = byKey, byID
+	t.mu.Unlock()
+	return nil
+}
+
+// Lookup returns the tenant owning a write key
+func (t *Tenants) Lookup(writeKey string) (*Tenant, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	tenant, ok := t.byKey[writeKey]
+	return tenant, ok
+}
+
+// Site returns the tenant with a site ID
+func (t *Tenants) Site(id string) (*Tenant, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	tenant, ok := t.byID[id]
+	return tenant, ok
+}
+
+// Routes reports whether events of a site go to the named sink. Unknown
+// sites, including events relayed from an edge whose tenants are not
+// configured here, go to every sink. It is safe on a nil *Tenants.
+func (t *Tenants) Routes(siteID, sink string) bool {
+	if t == nil || siteID == "" {
+		return true
+	}
+	t.mu.RLock()
+	tenant := t.byID[siteID]
+	t.mu.RUnlock()
+	return tenant == nil || len(tenant.outputs) == 0 || tenant.outputs[sink]
+}
+
+// allowsOrigin checks the request's Origin, or failing that its Referer,
+// against the site's allowed origins. Requests carrying neither are allowed:
+// both headers are set by browsers, and non-browser clients can forge them.
+func (t *Tenant) allowsOrigin(r *http.Request) bool {
+	if len(t.origins) == 0 {
+		return true
+	}
+	raw := r.Header.Get("Origin")
+	if raw == "" || raw == "null" {
+		raw = r.Referer()
+	}
+	if raw == "" {
+		return true
+	}
+	origin, ok := normalizeOrigin(raw)
+	return ok && t.origins[origin]
+}
+
+// normalizeOrigin reduces a URL to its lowercase scheme://host[:port]
+func normalizeOrigin(raw string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// writeKey returns the write key sent with r
+func writeKey(r *http.Request) string {
+	if key := strings.TrimSpace(r.Header.Get(writeKeyHeader)); key != "" {
+		return key
+	}
+	return strings.TrimSpace(r.URL.Query().Get(writeKeyParam))
+}
+
+type tenantKey struct{}
+
+// tenantFrom returns the tenant resolveTenant stored in ctx, or nil
+func tenantFrom(ctx context.Context) *Tenant {
+	tenant, _ := ctx.Value(tenantKey{}).(*Tenant)
+	return tenant
+}
+
+// siteID returns the tenant's site ID; empty for a nil tenant
+func (t *Tenant) siteID() string {
+	if t == nil {
+		return ""
+	}
+	return t.ID
+}
+
+// resolveTenant identifies the tenant of an ingestion request and checks its
+// origin, answering 401 or 403 on failure. The tenant is stored in the
+// returned request's context. In single-tenant mode r is returned as is.
+func (e Env) resolveTenant(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if e.Tenants == nil {
+		return r, true
+	}
+	key := writeKey(r)
+	if key == "" {
+		http.Error(w, "missing write key", http.StatusUnauthorized)
+		return nil, false
+	}
+	tenant, ok := e.Tenants.Lookup(key)
+	if !ok {
+		http.Error(w, "invalid write key", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !tenant.allowsOrigin(r) {
+		http.Error(w, "origin not allowed for this site", http.StatusForbidden)
+		return nil, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)), true
+}
+
+// hmacAuth returns the HMAC secret holder for the request's tenant, falling
+// back to the instance-wide one. Ingestion requests use the tenant stored by
+// resolveTenant; /hmac.js and /hmac/public-key look up an optional write key.
+func (e Env) hmacAuth(r *http.Request) *HMACAuth {
+	tenant := tenantFrom(r.Context())
+	if tenant == nil && e.Tenants != nil {
+		tenant, _ = e.Tenants.Lookup(writeKey(r))
+	}
+	if tenant != nil && tenant.hmac != nil {
+		return tenant.hmac
+	}
+	return e.HMACAuth
+}
@@ -0,0 +1,223 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func newTestTenants(t *testing.T) *Tenants {
+	t.Helper()
+	tenants, err := NewTenants([]config.Tenant{
+		{SiteID: "shop", WriteKeys: []string{"wk_shop", "wk_shop_old"}, AllowedOrigins: []string{"https://shop.example"}, Outputs: []string{"kafka"}},
+		{SiteID: "blog", WriteKeys: []string{"wk_blog"}, HMACSecret: "blog-secret"},
+	})
+	if err != nil {
+		t.Fatalf("NewTenants() error = %v", err)
+	}
+	return tenants
+}
+
+func TestTenantsLookup(t *testing.T) {
+	tenants := newTestTenants(t)
+	for key, want := range map[string]string{"wk_shop": "shop", "wk_shop_old": "shop", "wk_blog": "blog"} {
+		if tenant, ok := tenants.Lookup(key); !ok || tenant.ID != want {
+			t.Errorf("Lookup(%q) = %v, %v, want %s", key, tenant, ok, want)
+		}
+	}
+	if _, ok := tenants.Lookup("wk_unknown"); ok {
+		t.Error("Lookup() found an unknown key")
+	}
+}
+
+func TestTenantsRoutes(t *testing.T) {
+	tenants := newTestTenants(t)
+	tests := []struct {
+		siteID, sink string
+		want         bool
+	}{
+		{"shop", "kafka", true},
+		{"shop", "postgres", false},
+		{"blog", "postgres", true}, // no outputs: every sink
+		{"edge", "postgres", true}, // unknown site, e.g. relayed
+		{"", "postgres", true},
+	}
+	for _, tt := range tests {
+		if got := tenants.Routes(tt.siteID, tt.sink); got != tt.want {
+			t.Errorf("Routes(%q, %q) = %v, want %v", tt.siteID, tt.sink, got, tt.want)
+		}
+	}
+
+	var single *Tenants
+	if !single.Routes("shop", "postgres") {
+		t.Error("nil Tenants should route to every sink")
+	}
+}
+
+func TestTenantsUpdateRotatesHMAC(t *testing.T) {
+	tenants := newTestTenants(t)
+	blog, _ := tenants.Lookup("wk_blog")
+	oldAuth := blog.hmac
+
+	err := tenants.Update([]config.Tenant{
+		{SiteID: "blog", WriteKeys: []string{"wk_blog_new"}, HMACSecret: "blog-secret-2"},
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if _, ok := tenants.Lookup("wk_blog"); ok {
+		t.Error("removed write key still accepted")
+	}
+	blog, ok := tenants.Lookup("wk_blog_new")
+	if !ok || blog.hmac != oldAuth {
+		t.Fatal("tenant HMAC state should survive an update")
+	}
+	if current, previous := blog.hmac.currentSecrets(); string(current) != "blog-secret-2" || string(previous) != "blog-secret" {
+		t.Errorf("secrets = %q, %q", current, previous)
+	}
+}
+
+func TestTenantAllowsOrigin(t *testing.T) {
+	tenant, _ := newTestTenants(t).Lookup("wk_shop")
+	tests := []struct {
+		name, origin, referer string
+		want                  bool
+	}{
+		{"matching origin", "https://shop.example", "", true},
+		{"origin case and port", "HTTPS://Shop.Example", "", true},
+		{"other origin", "https://evil.example", "", false},
+		{"scheme mismatch", "http://shop.example", "", false},
+		{"referer fallback", "", "https://shop.example/cart?x=1", true},
+		{"foreign referer", "", "https://evil.example/", false},
+		{"no origin or referer", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/collect", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			if got := tenant.allowsOrigin(r); got != tt.want {
+				t.Errorf("allowsOrigin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectTenants(t *testing.T) {
+	collect := func(env Env, target string, header http.Header) (*httptest.ResponseRecorder, []event.Event) {
+		var emitted []event.Event
+		env.Cfg.MaxBodyBytes = 1 << 20
+		env.Emit = func(_ context.Context, e event.Event) { emitted = append(emitted, e) }
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"type":"click","site_id":"spoofed"}`))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		env.Collect(w, req)
+		return w, emitted
+	}
+
+	t.Run("single-tenant clears client site_id", func(t *testing.T) {
+		w, emitted := collect(Env{}, "/collect", nil)
+		if w.Code != http.StatusAccepted || len(emitted) != 1 || emitted[0].SiteID != "" {
+			t.Errorf("status = %d, emitted = %+v", w.Code, emitted)
+		}
+	})
+
+	t.Run("write key in header", func(t *testing.T) {
+		env := Env{Tenants: newTestTenants(t)}
+		w, emitted := collect(env, "/collect", http.Header{"X-Gotrack-Write-Key": {"wk_shop"}, "Origin": {"https://shop.example"}})
+		if w.Code != http.StatusAccepted || len(emitted) != 1 || emitted[0].SiteID != "shop" {
+			t.Errorf("status = %d, emitted = %+v", w.Code, emitted)
+		}
+	})
+
+	t.Run("write key in query", func(t *testing.T) {
+		env := Env{Tenants: newTestTenants(t)}
+		w, emitted := collect(env, "/collect?write_key=wk_shop_old", nil)
+		if w.Code != http.StatusAccepted || len(emitted) != 1 || emitted[0].SiteID != "shop" {
+			t.Errorf("status = %d, emitted = %+v", w.Code, emitted)
+		}
+	})
+
+	t.Run("missing or unknown write key", func(t *testing.T) {
+		env := Env{Tenants: newTestTenants(t)}
+		for _, target := range []string{"/collect", "/collect?write_key=wk_nope"} {
+			w, emitted := collect(env, target, nil)
+			if w.Code != http.StatusUnauthorized || len(emitted) != 0 {
+				t.Errorf("%s: status = %d, emitted = %d", target, w.Code, len(emitted))
+			}
+		}
+	})
+
+	t.Run("origin not allowed", func(t *testing.T) {
+		env := Env{Tenants: newTestTenants(t)}
+		w, emitted := collect(env, "/collect?write_key=wk_shop", http.Header{"Origin": {"https://evil.example"}})
+		if w.Code != http.StatusForbidden || len(emitted) != 0 {
+			t.Errorf("status = %d, emitted = %d", w.Code, len(emitted))
+		}
+	})
+
+	t.Run("tenant HMAC secret", func(t *testing.T) {
+		tenants := newTestTenants(t)
+		env := Env{Tenants: tenants, HMACAuth: NewHMACAuth("instance-secret", "")}
+		blog, _ := tenants.Lookup("wk_blog")
+		body := []byte(`{"type":"click","site_id":"spoofed"}`)
+
+		sig := generateHMACWithSecret([]byte("blog-secret"), body, "192.0.2.1")
+		w, emitted := collect(env, "/collect?write_key=wk_blog", http.Header{"X-Gotrack-Hmac": {sig}})
+		if w.Code != http.StatusAccepted || len(emitted) != 1 || emitted[0].SiteID != blog.ID {
+			t.Errorf("tenant signature: status = %d, emitted = %+v", w.Code, emitted)
+		}
+
+		sig = env.HMACAuth.generateHMAC(body, "192.0.2.1")
+		if w, _ := collect(env, "/collect?write_key=wk_blog", http.Header{"X-Gotrack-Hmac": {sig}}); w.Code != http.StatusUnauthorized {
+			t.Errorf("instance signature for a tenant with its own secret: status = %d, want 401", w.Code)
+		}
+	})
+}
+
+func TestPixelTenants(t *testing.T) {
+	var emitted []event.Event
+	env := Env{Tenants: newTestTenants(t), Emit: func(_ context.Context, e event.Event) { emitted = append(emitted, e) }}
+
+	w := httptest.NewRecorder()
+	env.Pixel(w, httptest.NewRequest(http.MethodGet, "/px.gif?write_key=wk_blog", nil))
+	if w.Code != http.StatusOK || len(emitted) != 1 || emitted[0].SiteID != "blog" {
+		t.Errorf("status = %d, emitted = %+v", w.Code, emitted)
+	}
+
+	w = httptest.NewRecorder()
+	env.Pixel(w, httptest.NewRequest(http.MethodGet, "/px.gif", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status without write key = %d, want 401", w.Code)
+	}
+}
+
+func TestHMACPublicKeyTenant(t *testing.T) {
+	tenants := newTestTenants(t)
+	env := Env{Tenants: tenants, HMACAuth: NewHMACAuth("instance-secret", "")}
+	blog, _ := tenants.Lookup("wk_blog")
+
+	w := httptest.NewRecorder()
+	env.HMACPublicKey(w, httptest.NewRequest(http.MethodGet, "/hmac/public-key?write_key=wk_blog", nil))
+	if !strings.Contains(w.Body.String(), blog.hmac.GetPublicKeyBase64()) {
+		t.Errorf("tenant public key not served: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	env.HMACPublicKey(w, httptest.NewRequest(http.MethodGet, "/hmac/public-key", nil))
+	if !strings.Contains(w.Body.String(), env.HMACAuth.GetPublicKeyBase64()) {
+		t.Errorf("instance public key not served: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,59 @@
+package httpx
+
+import (
+	"net/http"
+
+	"github.com/shortontech/gotrack/internal/tracing"
+	event "github.com/shortontech/gotrack/pkg/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traced runs an ingestion handler in a server span, continuing the trace
+// from the request's traceparent header when there is one
+func traced(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			))
+		defer span.End()
+
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		h(wrapped, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(wrapped.statusCode))
+		if wrapped.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(wrapped.statusCode))
+		}
+	}
+}
+
+// enrich adds server-side fields and the resolved site to events in an
+// event.enrich span. A client-supplied site_id is always overwritten.
+func (e Env) enrich(r *http.Request, events ...*event.Event) {
+	_, span := tracing.Tracer().Start(r.Context(), "event.enrich",
+		trace.WithAttributes(attribute.Int("gotrack.event.count", len(events))))
+	defer span.End()
+
+	siteID := tenantFrom(r.Context()).siteID()
+	if siteID != "" {
+		span.SetAttributes(attribute.String("gotrack.site_id", siteID))
+	}
+	for _, ev := range events {
+		event.EnrichServerFields(r, ev, e.Cfg)
+		ev.SiteID = siteID
+		if e.Metrics != nil {
+			e.Metrics.ObserveDetection(ev.Server.Detection)
+		}
+	}
+	e.evaluateConsent(r, events...)
+}
@@ -0,0 +1,92 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cfg "github.com/shortontech/gotrack/pkg/config"
+	event "github.com/shortontech/gotrack/pkg/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider that keeps finished spans in memory
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return recorder
+}
+
+func TestTracedCollect(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var emitCtx context.Context
+	env := Env{
+		Cfg:  cfg.Config{MaxBodyBytes: 1 << 20},
+		Emit: func(ctx context.Context, ev event.Event) { emitCtx = ctx },
+	}
+	req := httptest.NewRequest(http.MethodPost, "/collect", bytes.NewBufferString(`[{"type":"click"},{"type":"pageview"}]`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	NewMux(env).ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d", w.Code)
+	}
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want enrich and server spans", len(spans))
+	}
+	enrich, server := spans[0], spans[1]
+	if server.Name() != "POST /collect" || server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("server span = %q (%v)", server.Name(), server.SpanKind())
+	}
+	if server.Parent().SpanID().String() != "00f067aa0ba902b7" || server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("server span should continue the incoming trace, parent = %v", server.Parent())
+	}
+	if enrich.Name() != "event.enrich" || enrich.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("enrich span = %q with parent %v", enrich.Name(), enrich.Parent())
+	}
+
+	// Sinks see the request span
+	if got := trace.SpanContextFromContext(emitCtx); got.SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("emit context span = %v, want %v", got.SpanID(), server.SpanContext().SpanID())
+	}
+}
+
+func TestTracedPixel_StatusCode(t *testing.T) {
+	recorder := recordSpans(t)
+
+	w := httptest.NewRecorder()
+	NewMux(Env{}).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/px.gif", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d", w.Code)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "POST /px.gif" {
+		t.Fatalf("spans = %v", spans)
+	}
+	for _, attr := range spans[0].Attributes() {
+		if attr.Key == "http.response.status_code" && attr.Value.AsInt64() != http.StatusMethodNotAllowed {
+			t.Errorf("status attribute = %v", attr.Value.AsInt64())
+		}
+	}
+	if spans[0].Parent().IsValid() {
+		t.Error("a request without traceparent should start a new trace")
+	}
+}
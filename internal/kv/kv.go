@@ -0,0 +1,51 @@
+// Package kv provides the key/value store shared by stateful features such as
+// detection timing, sessionization, dedup, quotas and consent caching.
+//
+// Every feature goes through the Store interface so a deployment picks one
+// backend: in-memory for a single instance, Redis or Postgres when several
+// replicas must agree.
+package kv
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Store is a key/value store with per-key expiry. A ttl of zero means the key
+// never expires. Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value for key; ok is false when the key is missing or expired
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set stores value under key, replacing any previous value and expiry
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Incr atomically increments the integer counter at key and returns the new
+	// value. ttl is only applied when the counter is created, so a fixed window
+	// starts with the first increment.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Delete removes key; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// Close releases resources held by the store
+	Close() error
+}
+
+// Backend names accepted by KV_BACKEND
+const (
+	BackendMemory   = "memory"
+	BackendRedis    = "redis"
+	BackendPostgres = "postgres"
+)
+
+// ValidBackend reports whether name is a supported backend
+func ValidBackend(name string) error {
+	switch name {
+	case BackendMemory, BackendRedis, BackendPostgres:
+		return nil
+	default:
+		return fmt.Errorf("unsupported KV backend %q (want memory, redis or postgres)", name)
+	}
+}
+
+func errNotInteger(key string) error {
+	return fmt.Errorf("kv: value at %q is not an integer counter", key)
+}
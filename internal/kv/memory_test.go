@@ -0,0 +1,103 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestMemoryStore tests the in-memory store semantics shared by all backends
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+
+	t.Run("set and get", func(t *testing.T) {
+		if err := s.Set(ctx, "a", []byte("one"), 0); err != nil {
+			t.Fatal(err)
+		}
+		value, ok, err := s.Get(ctx, "a")
+		if err != nil || !ok || string(value) != "one" {
+			t.Errorf("Get() = %q, %v, %v", value, ok, err)
+		}
+		if _, ok, _ := s.Get(ctx, "missing"); ok {
+			t.Error("expected missing key")
+		}
+	})
+
+	t.Run("ttl expiry", func(t *testing.T) {
+		_ = s.Set(ctx, "short", []byte("x"), time.Minute)
+		now = now.Add(2 * time.Minute)
+		if _, ok, _ := s.Get(ctx, "short"); ok {
+			t.Error("expected key to expire")
+		}
+		if _, ok, _ := s.Get(ctx, "a"); !ok {
+			t.Error("key without ttl should not expire")
+		}
+	})
+
+	t.Run("incr creates counter with ttl", func(t *testing.T) {
+		for want := int64(1); want <= 3; want++ {
+			n, err := s.Incr(ctx, "counter", time.Minute)
+			if err != nil || n != want {
+				t.Fatalf("Incr() = %d, %v; want %d", n, err, want)
+			}
+		}
+		// The window is fixed from the first increment
+		now = now.Add(61 * time.Second)
+		if n, _ := s.Incr(ctx, "counter", time.Minute); n != 1 {
+			t.Errorf("Incr() after expiry = %d, want 1", n)
+		}
+	})
+
+	t.Run("incr on non-integer value", func(t *testing.T) {
+		_ = s.Set(ctx, "text", []byte("abc"), 0)
+		if _, err := s.Incr(ctx, "text", 0); err == nil {
+			t.Error("expected error incrementing a non-integer value")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		_ = s.Delete(ctx, "a")
+		if _, ok, _ := s.Get(ctx, "a"); ok {
+			t.Error("expected key to be deleted")
+		}
+		if err := s.Delete(ctx, "never-set"); err != nil {
+			t.Errorf("Delete() of missing key = %v", err)
+		}
+	})
+
+	t.Run("sweep evicts expired keys", func(t *testing.T) {
+		sweep := NewMemoryStore()
+		sweep.now = func() time.Time { return now }
+		_ = sweep.Set(ctx, "old", []byte("x"), time.Second)
+		now = now.Add(2 * memorySweepInterval)
+		_ = sweep.Set(ctx, "new", []byte("y"), 0)
+
+		if sweep.Len() != 1 {
+			t.Errorf("Len() = %d after sweep, want 1", sweep.Len())
+		}
+	})
+
+	t.Run("values are copied", func(t *testing.T) {
+		buf := []byte("abc")
+		_ = s.Set(ctx, "copy", buf, 0)
+		buf[0] = 'z'
+		value, _, _ := s.Get(ctx, "copy")
+		if string(value) != "abc" {
+			t.Errorf("stored value changed to %q", value)
+		}
+	})
+}
+
+func TestValidBackend(t *testing.T) {
+	for _, name := range []string{BackendMemory, BackendRedis, BackendPostgres} {
+		if err := ValidBackend(name); err != nil {
+			t.Errorf("ValidBackend(%q) = %v", name, err)
+		}
+	}
+	if err := ValidBackend("etcd"); err == nil {
+		t.Error("expected error for unsupported backend")
+	}
+}
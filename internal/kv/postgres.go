@@ -0,0 +1,144 @@
+package kv
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresTable holds all keys; expired rows are hidden from reads and
+// deleted by Run
+const postgresTable = "gotrack_kv"
+
+// PostgresStore keeps state in a Postgres table, for deployments that already
+// run Postgres and do not want to operate Redis
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore wraps an open database handle
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// OpenPostgresStore connects to dsn and creates the table if needed
+func OpenPostgresStore(ctx context.Context, dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	s := NewPostgresStore(db)
+	if err := s.EnsureSchema(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// EnsureSchema creates the key/value table and its expiry index
+func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS `+postgresTable+` (
+			key TEXT PRIMARY KEY,
+			value BYTEA NOT NULL,
+			expires_at TIMESTAMPTZ
+		)`); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		CREATE INDEX IF NOT EXISTS `+postgresTable+`_expires_at_idx
+		ON `+postgresTable+` (expires_at) WHERE expires_at IS NOT NULL`); err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+	return nil
+}
+
+// Get returns the value for key
+func (s *PostgresStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT value FROM `+postgresTable+`
+		WHERE key = $1 AND (expires_at IS NULL OR expires_at > now())`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Set stores value under key
+func (s *PostgresStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO `+postgresTable+` (key, value, expires_at)
+		VALUES ($1, $2, CASE WHEN $3::bigint > 0 THEN now() + $3::bigint * interval '1 millisecond' END)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at`,
+		key, value, ttl.Milliseconds())
+	return err
+}
+
+// Incr increments the counter at key. An expired counter restarts at 1 with a fresh TTL.
+func (s *PostgresStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO `+postgresTable+` AS kv (key, value, expires_at)
+		VALUES ($1, '1', CASE WHEN $2::bigint > 0 THEN now() + $2::bigint * interval '1 millisecond' END)
+		ON CONFLICT (key) DO UPDATE SET
+			value = CASE WHEN kv.expires_at <= now() THEN EXCLUDED.value
+				ELSE convert_to((convert_from(kv.value, 'UTF8')::bigint + 1)::text, 'UTF8') END,
+			expires_at = CASE WHEN kv.expires_at <= now() THEN EXCLUDED.expires_at ELSE kv.expires_at END
+		RETURNING convert_from(value, 'UTF8')::bigint`,
+		key, ttl.Milliseconds()).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Delete removes key
+func (s *PostgresStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+postgresTable+` WHERE key = $1`, key)
+	return err
+}
+
+// DeleteExpired removes expired rows and returns how many were deleted
+func (s *PostgresStore) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM `+postgresTable+` WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Run deletes expired rows on every interval until the context is cancelled
+func (s *PostgresStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.DeleteExpired(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("kv: failed to delete expired keys: %v", err)
+			}
+		}
+	}
+}
+
+// Close closes the database handle
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,78 @@
+package kv
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestPostgresStore tests the Postgres backend queries
+func TestPostgresStore(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewPostgresStore(db)
+
+	t.Run("ensure schema", func(t *testing.T) {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS gotrack_kv").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE INDEX IF NOT EXISTS gotrack_kv_expires_at_idx").WillReturnResult(sqlmock.NewResult(0, 0))
+		if err := s.EnsureSchema(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		mock.ExpectQuery("SELECT value FROM gotrack_kv").WithArgs("a").
+			WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow([]byte("one")))
+		value, ok, err := s.Get(ctx, "a")
+		if err != nil || !ok || string(value) != "one" {
+			t.Errorf("Get() = %q, %v, %v", value, ok, err)
+		}
+
+		mock.ExpectQuery("SELECT value FROM gotrack_kv").WithArgs("missing").WillReturnError(sql.ErrNoRows)
+		if _, ok, err := s.Get(ctx, "missing"); ok || err != nil {
+			t.Errorf("Get() missing: ok = %v, err = %v", ok, err)
+		}
+	})
+
+	t.Run("set passes ttl in milliseconds", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO gotrack_kv").WithArgs("a", []byte("one"), int64(1500)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := s.Set(ctx, "a", []byte("one"), 1500*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("incr", func(t *testing.T) {
+		mock.ExpectQuery("INSERT INTO gotrack_kv AS kv").WithArgs("counter", int64(60000)).
+			WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(int64(3)))
+		if n, err := s.Incr(ctx, "counter", time.Minute); err != nil || n != 3 {
+			t.Errorf("Incr() = %d, %v", n, err)
+		}
+	})
+
+	t.Run("delete and expire", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM gotrack_kv WHERE key").WithArgs("a").WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := s.Delete(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+
+		mock.ExpectExec("DELETE FROM gotrack_kv WHERE expires_at").WillReturnResult(sqlmock.NewResult(0, 4))
+		if n, err := s.DeleteExpired(ctx); err != nil || n != 4 {
+			t.Errorf("DeleteExpired() = %d, %v", n, err)
+		}
+	})
+
+	mock.ExpectClose()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// TestRedisStore tests the Redis backend against miniredis
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	s := NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	defer s.Close()
+
+	t.Run("set and get with prefix", func(t *testing.T) {
+		if err := s.Set(ctx, "a", []byte("one"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		value, ok, err := s.Get(ctx, "a")
+		if err != nil || !ok || string(value) != "one" {
+			t.Errorf("Get() = %q, %v, %v", value, ok, err)
+		}
+		if !mr.Exists(DefaultRedisPrefix + "a") {
+			t.Error("expected key to be namespaced")
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		if _, ok, err := s.Get(ctx, "missing"); ok || err != nil {
+			t.Errorf("Get() ok = %v, err = %v", ok, err)
+		}
+	})
+
+	t.Run("ttl expiry", func(t *testing.T) {
+		_ = s.Set(ctx, "short", []byte("x"), time.Minute)
+		mr.FastForward(2 * time.Minute)
+		if _, ok, _ := s.Get(ctx, "short"); ok {
+			t.Error("expected key to expire")
+		}
+	})
+
+	t.Run("incr sets ttl only on create", func(t *testing.T) {
+		if n, err := s.Incr(ctx, "counter", time.Minute); err != nil || n != 1 {
+			t.Fatalf("Incr() = %d, %v", n, err)
+		}
+		mr.FastForward(30 * time.Second)
+		if n, _ := s.Incr(ctx, "counter", time.Minute); n != 2 {
+			t.Fatalf("Incr() = %d, want 2", n)
+		}
+		if ttl := mr.TTL(DefaultRedisPrefix + "counter"); ttl > 30*time.Second {
+			t.Errorf("TTL = %v, expected window to keep its original expiry", ttl)
+		}
+		mr.FastForward(31 * time.Second)
+		if n, _ := s.Incr(ctx, "counter", time.Minute); n != 1 {
+			t.Errorf("Incr() after expiry = %d, want 1", n)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		_ = s.Delete(ctx, "a")
+		if _, ok, _ := s.Get(ctx, "a"); ok {
+			t.Error("expected key to be deleted")
+		}
+	})
+}
@@ -0,0 +1,259 @@
+// Package loadgen generates realistic synthetic traffic at a controlled rate
+// so sinks can be load-tested before launch.
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// sessionWindow is how long a generated visitor's session lasts
+const sessionWindow = 30 * time.Minute
+
+// Generator builds events from a profile. A Generator is not safe for
+// concurrent use; Run gives each worker its own.
+type Generator struct {
+	profile Profile
+	seed    uint64
+	rng     *rand.Rand
+	now     func() time.Time
+
+	types   []string
+	typeW   picker
+	devices picker
+	geos    picker
+	sources picker
+}
+
+// NewGenerator returns a generator for p. Visitors keep the same device and
+// location across generators with the same seed.
+func NewGenerator(p Profile, seed uint64) *Generator {
+	types := make([]string, 0, len(p.Types))
+	for name := range p.Types {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	typeWeights := make([]float64, len(types))
+	for i, name := range types {
+		typeWeights[i] = p.Types[name]
+	}
+
+	return &Generator{
+		profile: p,
+		seed:    seed,
+		rng:     rand.New(rand.NewPCG(seed, rand.Uint64())),
+		now:     time.Now,
+		types:   types,
+		typeW:   newPicker(typeWeights),
+		devices: newPicker(weightsOf(p.Devices, func(d Device) float64 { return d.Weight })),
+		geos:    newPicker(weightsOf(p.Geos, func(g Geo) float64 { return g.Weight })),
+		sources: newPicker(weightsOf(p.Sources, func(s Source) float64 { return s.Weight })),
+	}
+}
+
+// Next returns a new event. The visitor is drawn from the profile's pool and
+// keeps its device, location and IP; the session and its attribution change
+// every half hour; the type and page are drawn per event.
+func (g *Generator) Next() event.Event {
+	p := g.profile
+	now := g.now()
+	visitor := g.rng.IntN(p.Visitors)
+	window := now.Truncate(sessionWindow)
+
+	// Per-visitor and per-session draws come from their own streams so
+	// they repeat for the same visitor
+	visitorRNG := rand.New(rand.NewPCG(g.seed, uint64(visitor)))
+	sessionRNG := rand.New(rand.NewPCG(uint64(visitor), uint64(window.Unix())))
+	device := p.Devices[g.devices.pick(visitorRNG)]
+	geo := p.Geos[g.geos.pick(visitorRNG)]
+	source := p.Sources[g.sources.pick(sessionRNG)]
+
+	domain := p.Domains[g.rng.IntN(len(p.Domains))]
+	path := p.Paths[g.rng.IntN(len(p.Paths))]
+
+	ev := event.Event{
+		EventID: uuid.New().String(),
+		TS:      now.UTC().Format(time.RFC3339Nano),
+		Type:    g.types[g.typeW.pick(g.rng)],
+		URL: event.URLInfo{
+			Referrer: source.Referrer,
+			UTM: event.UTMInfo{
+				Source:   source.Source,
+				Medium:   source.Medium,
+				Campaign: source.Campaign,
+			},
+		},
+		Route: event.RouteInfo{
+			Domain:   domain,
+			Path:     path,
+			FullPath: path,
+			Protocol: "https",
+		},
+		Device: event.DeviceInfo{
+			UA:        device.UA,
+			Browser:   device.Browser,
+			OS:        device.OS,
+			ViewportW: device.ViewportW,
+			ViewportH: device.ViewportH,
+		},
+		Session: event.SessionInfo{
+			VisitorID:    fmt.Sprintf("lg-visitor-%d", visitor),
+			SessionID:    fmt.Sprintf("lg-session-%d-%d", visitor, window.Unix()),
+			SessionStart: window.UTC().Format(time.RFC3339),
+		},
+		Server: event.ServerMeta{
+			IP:  visitorIP(visitor),
+			Geo: map[string]string{"country": geo.Country, "region": geo.Region, "city": geo.City},
+		},
+		Props: map[string]string{"loadgen": "true"},
+	}
+	if source.Referrer != "" {
+		ev.URL.ReferrerHostname = hostname(source.Referrer)
+	}
+	if device.Mobile {
+		mobile := true
+		ev.Device.UAMobile = &mobile
+	}
+	if len(p.Sites) > 0 {
+		ev.SiteID = p.Sites[visitor%len(p.Sites)]
+	}
+	return ev
+}
+
+// visitorIP maps a visitor to an address in 198.18.0.0/15, the range
+// reserved for benchmarking
+func visitorIP(visitor int) string {
+	return fmt.Sprintf("198.%d.%d.%d", 18+(visitor>>16)&1, (visitor>>8)&0xff, visitor&0xff)
+}
+
+func hostname(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// picker draws indexes in proportion to their weights
+type picker struct {
+	cumulative []float64
+}
+
+func newPicker(weights []float64) picker {
+	cumulative := make([]float64, len(weights))
+	var total float64
+	for i, w := range weights {
+		total += w
+		cumulative[i] = total
+	}
+	return picker{cumulative: cumulative}
+}
+
+func (p picker) pick(rng *rand.Rand) int {
+	total := p.cumulative[len(p.cumulative)-1]
+	return min(sort.SearchFloat64s(p.cumulative, rng.Float64()*total), len(p.cumulative)-1)
+}
+
+// Config controls how much traffic Run sends
+type Config struct {
+	Rate        float64       // events per second across all workers; 0 sends as fast as emit returns
+	Count       int           // events to send; 0 for no limit
+	Duration    time.Duration // how long to run; 0 for no limit
+	Concurrency int           // workers calling emit in parallel; defaults to 1
+	Seed        uint64        // seeds visitor attributes; runs with the same seed share visitors
+}
+
+// Stats summarizes a run
+type Stats struct {
+	Sent    int64
+	ByType  map[string]int64
+	Elapsed time.Duration
+}
+
+// Rate returns the achieved events per second
+func (s Stats) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Sent) / s.Elapsed.Seconds()
+}
+
+// Run sends events from p to emit until the count is reached, the duration
+// elapses or ctx is cancelled, whichever comes first
+func Run(ctx context.Context, p Profile, cfg Config, emit func(context.Context, event.Event)) Stats {
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+	workers := max(cfg.Concurrency, 1)
+	start := time.Now()
+
+	tokens := make(chan struct{}, workers)
+	go pace(ctx, cfg, start, tokens)
+
+	var mu sync.Mutex
+	stats := Stats{ByType: make(map[string]int64)}
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gen := NewGenerator(p, cfg.Seed)
+			counts := make(map[string]int64)
+			for range tokens {
+				ev := gen.Next()
+				// Events already drawn are delivered even if the run just ended
+				emit(context.WithoutCancel(ctx), ev)
+				counts[ev.Type]++
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for typ, n := range counts {
+				stats.ByType[typ] += n
+				stats.Sent += n
+			}
+		}()
+	}
+	wg.Wait()
+	stats.Elapsed = time.Since(start)
+	return stats
+}
+
+// pace issues one token per event on schedule and closes tokens when the
+// run is over. Falling behind is made up without waiting, so the average
+// rate holds even when emit stalls briefly.
+func pace(ctx context.Context, cfg Config, start time.Time, tokens chan<- struct{}) {
+	defer close(tokens)
+	var interval time.Duration
+	if cfg.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / cfg.Rate)
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	for i := 0; cfg.Count == 0 || i < cfg.Count; i++ {
+		if wait := time.Until(start.Add(time.Duration(i) * interval)); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case tokens <- struct{}{}:
+		}
+	}
+}
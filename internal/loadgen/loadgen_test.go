@@ -0,0 +1,161 @@
+package loadgen
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestBuiltinProfilesValidate(t *testing.T) {
+	for _, name := range ProfileNames() {
+		if err := Profiles[name].Validate(); err != nil {
+			t.Errorf("profile %s: %v", name, err)
+		}
+	}
+}
+
+func TestGeneratorNext(t *testing.T) {
+	p := Profiles["web"]
+	p.Sites = []string{"a", "b"}
+	gen := NewGenerator(p, 1)
+
+	devices := map[string]string{}
+	types := map[string]int{}
+	for range 5000 {
+		ev := gen.Next()
+		if ev.EventID == "" || ev.TS == "" || ev.Route.Domain == "" || ev.Session.SessionID == "" {
+			t.Fatalf("incomplete event %+v", ev)
+		}
+		if ev.SiteID != "a" && ev.SiteID != "b" {
+			t.Fatalf("site = %q", ev.SiteID)
+		}
+		if !strings.HasPrefix(ev.Server.IP, "198.1") {
+			t.Fatalf("ip = %q, want one in 198.18.0.0/15", ev.Server.IP)
+		}
+		if ua, ok := devices[ev.Session.VisitorID]; ok && ua != ev.Device.UA {
+			t.Fatalf("visitor %s changed user agent", ev.Session.VisitorID)
+		}
+		devices[ev.Session.VisitorID] = ev.Device.UA
+		types[ev.Type]++
+	}
+
+	// The web mix is 80% pageviews; allow for sampling noise
+	if share := float64(types["pageview"]) / 5000; share < 0.75 || share > 0.85 {
+		t.Errorf("pageview share = %.2f, want about 0.80", share)
+	}
+	for typ := range types {
+		if _, ok := p.Types[typ]; !ok {
+			t.Errorf("unexpected type %q", typ)
+		}
+	}
+}
+
+func TestGeneratorSameSeedSameVisitors(t *testing.T) {
+	a, b := NewGenerator(Profiles["web"], 7), NewGenerator(Profiles["web"], 7)
+	fixed := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return fixed }
+	b.now = a.now
+	seen := map[string]event.Event{}
+	for range 2000 {
+		ev := a.Next()
+		seen[ev.Session.VisitorID] = ev
+	}
+	matched := 0
+	for range 2000 {
+		ev := b.Next()
+		if prev, ok := seen[ev.Session.VisitorID]; ok {
+			matched++
+			if prev.Device.UA != ev.Device.UA || prev.Server.Geo["city"] != ev.Server.Geo["city"] || prev.URL.UTM != ev.URL.UTM {
+				t.Fatalf("visitor %s differs between generators", ev.Session.VisitorID)
+			}
+		}
+	}
+	if matched == 0 {
+		t.Error("expected some visitors in common")
+	}
+}
+
+func TestLookupProfile(t *testing.T) {
+	if _, err := LookupProfile("ecommerce"); err != nil {
+		t.Errorf("built-in profile: %v", err)
+	}
+	if _, err := LookupProfile("nope"); err == nil || !strings.Contains(err.Error(), "built-in") {
+		t.Errorf("unknown profile error = %v", err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "profile.json")
+	if err := os.WriteFile(path, []byte(`{"types": {"signup": 1}, "visitors": 3}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LookupProfile(path)
+	if err != nil {
+		t.Fatalf("LookupProfile: %v", err)
+	}
+	if len(p.Types) != 1 || p.Visitors != 3 || len(p.Devices) == 0 {
+		t.Errorf("profile = %+v, want signup only with default devices", p)
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"types": {"pageview": 0}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LookupProfile(bad); err == nil {
+		t.Error("expected an error for a zero weight")
+	}
+}
+
+func TestRunCount(t *testing.T) {
+	var sent atomic.Int64
+	stats := Run(context.Background(), Profiles["pageviews"], Config{Count: 250, Concurrency: 4}, func(ctx context.Context, ev event.Event) {
+		sent.Add(1)
+	})
+	if stats.Sent != 250 || sent.Load() != 250 || stats.ByType["pageview"] != 250 {
+		t.Errorf("stats = %+v, emitted %d; want 250 pageviews", stats, sent.Load())
+	}
+}
+
+func TestRunRateAndDuration(t *testing.T) {
+	var mu sync.Mutex
+	var sent int
+	stats := Run(context.Background(), Profiles["web"], Config{Rate: 100, Duration: 300 * time.Millisecond, Concurrency: 2}, func(ctx context.Context, ev event.Event) {
+		if ctx.Err() != nil {
+			t.Error("emit got a cancelled context")
+		}
+		mu.Lock()
+		sent++
+		mu.Unlock()
+	})
+	// 100/s for 300ms is about 30 events, the first sent immediately
+	if stats.Sent < 15 || stats.Sent > 45 || int(stats.Sent) != sent {
+		t.Errorf("sent %d (emitted %d), want about 30", stats.Sent, sent)
+	}
+	if stats.Elapsed < 250*time.Millisecond {
+		t.Errorf("elapsed = %v, want the full duration", stats.Elapsed)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan Stats)
+	go func() {
+		done <- Run(ctx, Profiles["web"], Config{Rate: 1000}, func(context.Context, event.Event) {})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case stats := <-done:
+		if stats.Sent == 0 {
+			t.Error("expected events before cancellation")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not stop after cancellation")
+	}
+}
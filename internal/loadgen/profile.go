@@ -0,0 +1,226 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Profile describes the traffic to generate. Weights are relative within
+// each list, so {"pageview": 8, "click": 2} sends four pageviews per click.
+type Profile struct {
+	Types    map[string]float64 `json:"types"`              // event type mix
+	Devices  []Device           `json:"devices"`            // user agent pool
+	Geos     []Geo              `json:"geos"`               // visitor locations
+	Sources  []Source           `json:"sources"`            // referrer and UTM attribution
+	Domains  []string           `json:"domains"`            // hosts pages are served from
+	Paths    []string           `json:"paths"`              // page paths
+	Sites    []string           `json:"sites,omitempty"`    // site IDs for multi-tenant deployments
+	Visitors int                `json:"visitors,omitempty"` // distinct visitors events are spread over
+}
+
+// Device is a browser in the user agent pool
+type Device struct {
+	Weight    float64 `json:"weight"`
+	UA        string  `json:"ua"`
+	Browser   string  `json:"browser"`
+	OS        string  `json:"os"`
+	Mobile    bool    `json:"mobile,omitempty"`
+	ViewportW int     `json:"viewport_w"`
+	ViewportH int     `json:"viewport_h"`
+}
+
+// Geo is a visitor location
+type Geo struct {
+	Weight  float64 `json:"weight"`
+	Country string  `json:"country"`
+	Region  string  `json:"region,omitempty"`
+	City    string  `json:"city,omitempty"`
+}
+
+// Source is where a visit came from. A source without a referrer or UTM
+// parameters is direct traffic.
+type Source struct {
+	Weight   float64 `json:"weight"`
+	Referrer string  `json:"referrer,omitempty"`
+	Source   string  `json:"utm_source,omitempty"`
+	Medium   string  `json:"utm_medium,omitempty"`
+	Campaign string  `json:"utm_campaign,omitempty"`
+}
+
+var defaultDevices = []Device{
+	{Weight: 45, UA: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36", Browser: "Chrome", OS: "Windows", ViewportW: 1920, ViewportH: 1080},
+	{Weight: 25, UA: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", Browser: "Safari", OS: "iOS", Mobile: true, ViewportW: 390, ViewportH: 844},
+	{Weight: 15, UA: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36", Browser: "Chrome", OS: "Android", Mobile: true, ViewportW: 412, ViewportH: 915},
+	{Weight: 10, UA: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15", Browser: "Safari", OS: "macOS", ViewportW: 1440, ViewportH: 900},
+	{Weight: 5, UA: "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0", Browser: "Firefox", OS: "Linux", ViewportW: 1366, ViewportH: 768},
+}
+
+var defaultGeos = []Geo{
+	{Weight: 40, Country: "US", Region: "CA", City: "San Francisco"},
+	{Weight: 20, Country: "US", Region: "NY", City: "New York"},
+	{Weight: 15, Country: "GB", Region: "ENG", City: "London"},
+	{Weight: 10, Country: "DE", Region: "BE", City: "Berlin"},
+	{Weight: 10, Country: "IN", Region: "KA", City: "Bengaluru"},
+	{Weight: 5, Country: "BR", Region: "SP", City: "São Paulo"},
+}
+
+var defaultSources = []Source{
+	{Weight: 35},
+	{Weight: 30, Referrer: "https://www.google.com/", Source: "google", Medium: "organic"},
+	{Weight: 15, Referrer: "https://www.google.com/", Source: "google", Medium: "cpc", Campaign: "spring_sale"},
+	{Weight: 10, Referrer: "https://www.facebook.com/", Source: "facebook", Medium: "social", Campaign: "launch"},
+	{Weight: 10, Source: "newsletter", Medium: "email", Campaign: "weekly"},
+}
+
+// Profiles are the built-in profiles by name
+var Profiles = map[string]Profile{
+	// web is a content site: mostly pageviews with some engagement
+	"web": {
+		Types:    map[string]float64{"pageview": 80, "click": 15, "conversion": 2, "custom_event": 3},
+		Devices:  defaultDevices,
+		Geos:     defaultGeos,
+		Sources:  defaultSources,
+		Domains:  []string{"example.com"},
+		Paths:    []string{"/", "/blog", "/blog/launch", "/pricing", "/about", "/docs", "/signup"},
+		Visitors: 10000,
+	},
+	// ecommerce has a deeper funnel with more conversions
+	"ecommerce": {
+		Types:    map[string]float64{"pageview": 60, "click": 15, "add_to_cart": 12, "begin_checkout": 6, "purchase": 4, "conversion": 3},
+		Devices:  defaultDevices,
+		Geos:     defaultGeos,
+		Sources:  defaultSources,
+		Domains:  []string{"shop.example.com"},
+		Paths:    []string{"/", "/collections/new", "/products/tee", "/products/hoodie", "/cart", "/checkout"},
+		Visitors: 50000,
+	},
+	// pageviews is the highest-volume, lowest-variety traffic for throughput tests
+	"pageviews": {
+		Types:    map[string]float64{"pageview": 1},
+		Devices:  defaultDevices,
+		Geos:     defaultGeos,
+		Sources:  defaultSources,
+		Domains:  []string{"example.com"},
+		Paths:    []string{"/"},
+		Visitors: 100000,
+	},
+}
+
+// ProfileNames returns the built-in profile names in order
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupProfile returns the built-in profile with the given name, or reads
+// a JSON profile when name is a path. Sections a file leaves out are taken
+// from the web profile.
+func LookupProfile(name string) (Profile, error) {
+	if p, ok := Profiles[name]; ok {
+		return p, nil
+	}
+	if !strings.ContainsAny(name, "/.") {
+		return Profile{}, fmt.Errorf("unknown profile %q (built-in: %s)", name, strings.Join(ProfileNames(), ", "))
+	}
+	return LoadProfile(name)
+}
+
+// LoadProfile reads a JSON profile from path
+func LoadProfile(path string) (Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Profile{}, fmt.Errorf("failed to read profile: %w", err)
+	}
+	var p Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Profile{}, fmt.Errorf("failed to parse profile: %w", err)
+	}
+	p = p.withDefaults(Profiles["web"])
+	if err := p.Validate(); err != nil {
+		return Profile{}, fmt.Errorf("profile %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// withDefaults fills empty sections from def
+func (p Profile) withDefaults(def Profile) Profile {
+	if len(p.Types) == 0 {
+		p.Types = def.Types
+	}
+	if len(p.Devices) == 0 {
+		p.Devices = def.Devices
+	}
+	if len(p.Geos) == 0 {
+		p.Geos = def.Geos
+	}
+	if len(p.Sources) == 0 {
+		p.Sources = def.Sources
+	}
+	if len(p.Domains) == 0 {
+		p.Domains = def.Domains
+	}
+	if len(p.Paths) == 0 {
+		p.Paths = def.Paths
+	}
+	if p.Visitors == 0 {
+		p.Visitors = def.Visitors
+	}
+	return p
+}
+
+// Validate checks that every section has something to pick from
+func (p Profile) Validate() error {
+	if err := positiveWeights("types", p.Types); err != nil {
+		return err
+	}
+	for name, list := range map[string][]float64{
+		"devices": weightsOf(p.Devices, func(d Device) float64 { return d.Weight }),
+		"geos":    weightsOf(p.Geos, func(g Geo) float64 { return g.Weight }),
+		"sources": weightsOf(p.Sources, func(s Source) float64 { return s.Weight }),
+	} {
+		if len(list) == 0 {
+			return fmt.Errorf("%s: at least one entry is required", name)
+		}
+		if slices.ContainsFunc(list, func(w float64) bool { return w <= 0 }) {
+			return fmt.Errorf("%s: weights must be positive", name)
+		}
+	}
+	if len(p.Domains) == 0 || len(p.Paths) == 0 {
+		return fmt.Errorf("domains and paths must not be empty")
+	}
+	if p.Visitors < 1 {
+		return fmt.Errorf("visitors must be positive")
+	}
+	return nil
+}
+
+func positiveWeights(section string, weights map[string]float64) error {
+	if len(weights) == 0 {
+		return fmt.Errorf("%s: at least one entry is required", section)
+	}
+	for name, w := range weights {
+		if name == "" {
+			return fmt.Errorf("%s: names must not be empty", section)
+		}
+		if w <= 0 {
+			return fmt.Errorf("%s: weight for %s must be positive", section, name)
+		}
+	}
+	return nil
+}
+
+func weightsOf[T any](items []T, weight func(T) float64) []float64 {
+	out := make([]float64, len(items))
+	for i, item := range items {
+		out[i] = weight(item)
+	}
+	return out
+}
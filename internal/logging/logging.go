@@ -0,0 +1,86 @@
+// Package logging adds a process-wide, runtime-adjustable log level on top of
+// the standard library logger so verbosity can change without a restart.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is a log severity
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var current atomic.Int32
+
+func init() {
+	current.Store(int32(LevelInfo))
+}
+
+// ParseLevel converts a level name (debug, info, warn, error) to a Level
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %q", s)
+}
+
+// String returns the level name
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "info"
+}
+
+// SetLevel changes the minimum level that is logged
+func SetLevel(l Level) {
+	current.Store(int32(l))
+}
+
+// SetLevelString parses and applies a level name
+func SetLevelString(s string) error {
+	l, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	SetLevel(l)
+	return nil
+}
+
+// GetLevel returns the current minimum level
+func GetLevel() Level {
+	return Level(current.Load())
+}
+
+// Enabled reports whether messages at level l are logged
+func Enabled(l Level) bool {
+	return l >= GetLevel()
+}
+
+// Debugf logs a message when debug logging is enabled
+func Debugf(format string, args ...any) {
+	if Enabled(LevelDebug) {
+		log.Printf("DEBUG: "+format, args...)
+	}
+}
@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Level
+		wantErr bool
+	}{
+		{input: "debug", want: LevelDebug},
+		{input: "INFO", want: LevelInfo},
+		{input: "", want: LevelInfo},
+		{input: "warning", want: LevelWarn},
+		{input: " error ", want: LevelError},
+		{input: "verbose", want: LevelInfo, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseLevel(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugf(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetLevel(LevelInfo)
+
+	SetLevel(LevelInfo)
+	Debugf("hidden %d", 1)
+	if buf.Len() != 0 {
+		t.Errorf("debug message logged at info level: %q", buf.String())
+	}
+
+	if err := SetLevelString("debug"); err != nil {
+		t.Fatal(err)
+	}
+	Debugf("shown %d", 2)
+	if !strings.Contains(buf.String(), "DEBUG: shown 2") {
+		t.Errorf("expected debug message, got %q", buf.String())
+	}
+	if GetLevel().String() != "debug" {
+		t.Errorf("GetLevel() = %v, want debug", GetLevel())
+	}
+}
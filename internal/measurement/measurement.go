@@ -0,0 +1,242 @@
+// Package measurement maps Google Analytics 4 Measurement Protocol payloads
+// to GoTrack events, so existing GA server-side integrations can dual-write
+// by pointing a second copy of their requests at GoTrack.
+package measurement
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Limits from the Measurement Protocol reference
+const (
+	MaxEvents         = 25 // events per request
+	MaxParams         = 25 // params per event
+	MaxUserProperties = 25 // user properties per request
+	MaxNameLength     = 40 // event, param and user property names
+)
+
+// Payload is a Measurement Protocol request body
+type Payload struct {
+	ClientID           string                  `json:"client_id"`       // web streams
+	AppInstanceID      string                  `json:"app_instance_id"` // app streams
+	UserID             string                  `json:"user_id"`
+	TimestampMicros    Micros                  `json:"timestamp_micros"`
+	UserProperties     map[string]UserProperty `json:"user_properties"`
+	NonPersonalizedAds bool                    `json:"non_personalized_ads"`
+	Events             []Event                 `json:"events"`
+}
+
+// Event is one entry of Payload.Events
+type Event struct {
+	Name            string         `json:"name"`
+	Params          map[string]any `json:"params"`
+	TimestampMicros Micros         `json:"timestamp_micros"` // overrides the request timestamp
+}
+
+// UserProperty is a user property value
+type UserProperty struct {
+	Value any `json:"value"`
+}
+
+// Micros is a Unix time in microseconds. Client libraries send it as a
+// number or as a string, and both are accepted.
+type Micros int64
+
+func (m *Micros) UnmarshalJSON(b []byte) error {
+	b = bytes.Trim(b, `"`)
+	if len(b) == 0 || string(b) == "null" {
+		*m = 0
+		return nil
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return fmt.Errorf("timestamp_micros: %w", err)
+	}
+	*m = Micros(n)
+	return nil
+}
+
+// Parse decodes a request body
+func Parse(body []byte) (Payload, error) {
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return Payload{}, err
+	}
+	return p, nil
+}
+
+// Validation codes, as reported by GA's /debug/mp/collect
+const (
+	CodeValueRequired       = "VALUE_REQUIRED"
+	CodeNameInvalid         = "NAME_INVALID"
+	CodeNameReserved        = "NAME_RESERVED"
+	CodeExceededMaxEntities = "EXCEEDED_MAX_ENTITIES"
+)
+
+// Message is one validation problem, in the shape GA's debug endpoint uses
+type Message struct {
+	FieldPath      string `json:"fieldPath"`
+	Description    string `json:"description"`
+	ValidationCode string `json:"validationCode"`
+}
+
+var nameRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// reservedEventNames may only be sent by GA's own SDKs
+var reservedEventNames = map[string]bool{
+	"ad_activeview": true, "ad_click": true, "ad_exposure": true, "ad_impression": true,
+	"ad_query": true, "ad_reward": true, "adunit_exposure": true, "app_background": true,
+	"app_clear_data": true, "app_exception": true, "app_remove": true, "app_store_refund": true,
+	"app_store_subscription_cancel": true, "app_store_subscription_convert": true,
+	"app_store_subscription_renew": true, "app_update": true, "app_upgrade": true,
+	"dynamic_link_app_open": true, "dynamic_link_app_update": true, "dynamic_link_first_open": true,
+	"error": true, "first_open": true, "first_visit": true, "in_app_purchase": true,
+	"notification_dismiss": true, "notification_foreground": true, "notification_open": true,
+	"notification_receive": true, "os_update": true, "session_start": true,
+	"session_start_with_rollout": true, "user_engagement": true,
+}
+
+// reservedPrefixes may not start event, param or user property names
+var reservedPrefixes = []string{"_", "firebase_", "ga_", "google_", "gtag."}
+
+// Validate checks the payload against the Measurement Protocol rules. GA
+// drops a request with any problem, and so does GoTrack.
+func (p Payload) Validate() []Message {
+	var msgs []Message
+	add := func(path, code, format string, args ...any) {
+		msgs = append(msgs, Message{FieldPath: path, Description: fmt.Sprintf(format, args...), ValidationCode: code})
+	}
+
+	if p.ClientID == "" && p.AppInstanceID == "" {
+		add("client_id", CodeValueRequired, "client_id or app_instance_id is required")
+	}
+	switch {
+	case len(p.Events) == 0:
+		add("events", CodeValueRequired, "at least one event is required")
+	case len(p.Events) > MaxEvents:
+		add("events", CodeExceededMaxEntities, "a request may have at most %d events", MaxEvents)
+	}
+	if len(p.UserProperties) > MaxUserProperties {
+		add("user_properties", CodeExceededMaxEntities, "a request may have at most %d user properties", MaxUserProperties)
+	}
+	for name := range p.UserProperties {
+		if code, desc := checkName(name, false); code != "" {
+			add("user_properties."+name, code, "user property %s", desc)
+		}
+	}
+
+	for i, ev := range p.Events {
+		path := "events[" + strconv.Itoa(i) + "]"
+		if ev.Name == "" {
+			add(path+".name", CodeValueRequired, "event name is required")
+		} else if code, desc := checkName(ev.Name, true); code != "" {
+			add(path+".name", code, "event name %s", desc)
+		}
+		if len(ev.Params) > MaxParams {
+			add(path+".params", CodeExceededMaxEntities, "an event may have at most %d params", MaxParams)
+		}
+		for name := range ev.Params {
+			if code, desc := checkName(name, false); code != "" {
+				add(path+".params."+name, code, "param %s", desc)
+			}
+		}
+	}
+	return msgs
+}
+
+// checkName returns the code and description of what is wrong with a name
+func checkName(name string, isEvent bool) (code, desc string) {
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return CodeNameReserved, fmt.Sprintf("%q uses the reserved prefix %q", name, prefix)
+		}
+	}
+	if isEvent && reservedEventNames[name] {
+		return CodeNameReserved, fmt.Sprintf("%q is reserved", name)
+	}
+	if len(name) > MaxNameLength || !nameRegex.MatchString(name) {
+		return CodeNameInvalid, fmt.Sprintf("%q must start with a letter, use only letters, digits and underscores and be at most %d characters", name, MaxNameLength)
+	}
+	return "", ""
+}
+
+// ToEvents maps the payload to GoTrack events: the event name becomes the
+// type, client_id (or app_instance_id) the visitor ID and user_id the user
+// ID. Params and user properties (as user_properties.<name>) are kept in
+// props, and the well-known params are also mapped to event fields: session_id, page_location, page_referrer, page_title,
+// language and the campaign params.
+func (p Payload) ToEvents() []event.Event {
+	events := make([]event.Event, len(p.Events))
+	for i, in := range p.Events {
+		ev := &events[i]
+		ev.Type = in.Name
+		ev.TS = p.timestamp(in)
+		ev.Session.VisitorID = p.ClientID
+		if ev.Session.VisitorID == "" {
+			ev.Session.VisitorID = p.AppInstanceID
+		}
+		ev.Session.UserID = p.UserID
+		mapParams(ev, in.Params)
+
+		if len(in.Params) > 0 || len(p.UserProperties) > 0 {
+			ev.Props = make(map[string]string, len(in.Params)+len(p.UserProperties))
+			for k, v := range in.Params {
+				ev.Props[k] = event.PropValue(v)
+			}
+			for k, v := range p.UserProperties {
+				ev.Props["user_properties."+k] = event.PropValue(v.Value)
+			}
+		}
+	}
+	return events
+}
+
+// timestamp returns the event's time as RFC 3339; empty lets the server
+// fill in the receive time
+func (p Payload) timestamp(ev Event) string {
+	micros := ev.TimestampMicros
+	if micros == 0 {
+		micros = p.TimestampMicros
+	}
+	if micros == 0 {
+		return ""
+	}
+	return time.UnixMicro(int64(micros)).UTC().Format(time.RFC3339Nano)
+}
+
+func mapParams(ev *event.Event, params map[string]any) {
+	ev.Session.SessionID = stringParam(params, "session_id")
+	ev.Route.Title = stringParam(params, "page_title")
+	ev.Device.Language = stringParam(params, "language")
+	if ref := stringParam(params, "page_referrer"); ref != "" {
+		ev.URL.Referrer = ref
+		if u, err := url.Parse(ref); err == nil {
+			ev.URL.ReferrerHostname = u.Hostname()
+		}
+	}
+
+	// Explicit campaign params win over the UTM tags in page_location
+	ev.URL.UTM.Source = stringParam(params, "source")
+	ev.URL.UTM.Medium = stringParam(params, "medium")
+	ev.URL.UTM.Campaign = stringParam(params, "campaign")
+	ev.URL.UTM.Term = stringParam(params, "term")
+	ev.URL.UTM.Content = stringParam(params, "content")
+	ev.URL.UTM.CampaignID = stringParam(params, "campaign_id")
+	if loc := stringParam(params, "page_location"); loc != "" {
+		event.ApplyPageURL(ev, loc)
+	}
+}
+
+// stringParam returns a param as a string
+func stringParam(params map[string]any, key string) string {
+	return event.PropValue(params[key])
+}
@@ -0,0 +1,117 @@
+package measurement
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTimestampMicros(t *testing.T) {
+	for _, body := range []string{
+		`{"timestamp_micros":1700000000123456}`,
+		`{"timestamp_micros":"1700000000123456"}`,
+	} {
+		p, err := Parse([]byte(body))
+		if err != nil || p.TimestampMicros != 1700000000123456 {
+			t.Errorf("Parse(%s) = %d, %v", body, p.TimestampMicros, err)
+		}
+	}
+	if _, err := Parse([]byte(`{"timestamp_micros":"yesterday"}`)); err == nil {
+		t.Error("expected error for a non-numeric timestamp")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	codes := func(body string) []string {
+		t.Helper()
+		p, err := Parse([]byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, m := range p.Validate() {
+			out = append(out, m.FieldPath+":"+m.ValidationCode)
+		}
+		return out
+	}
+
+	if got := codes(`{"client_id":"1.2","events":[{"name":"purchase","params":{"value":9.99}}]}`); len(got) != 0 {
+		t.Errorf("valid payload: %v", got)
+	}
+
+	tooMany := `{"client_id":"1.2","events":[` + strings.TrimSuffix(strings.Repeat(`{"name":"click"},`, MaxEvents+1), ",") + `]}`
+	for body, want := range map[string]string{
+		`{"events":[{"name":"click"}]}`:   "client_id:VALUE_REQUIRED",
+		`{"app_instance_id":"a"}`:         "events:VALUE_REQUIRED",
+		tooMany:                           "events:EXCEEDED_MAX_ENTITIES",
+		`{"client_id":"1","events":[{}]}`: "events[0].name:VALUE_REQUIRED",
+		`{"client_id":"1","events":[{"name":"session_start"}]}`:                                  "events[0].name:NAME_RESERVED",
+		`{"client_id":"1","events":[{"name":"ga_click"}]}`:                                       "events[0].name:NAME_RESERVED",
+		`{"client_id":"1","events":[{"name":"add-to-cart"}]}`:                                    "events[0].name:NAME_INVALID",
+		`{"client_id":"1","events":[{"name":"click","params":{"_x":1}}]}`:                        "events[0].params._x:NAME_RESERVED",
+		`{"client_id":"1","events":[{"name":"click"}],"user_properties":{"9lives":{"value":1}}}`: "user_properties.9lives:NAME_INVALID",
+	} {
+		if got := codes(body); len(got) != 1 || got[0] != want {
+			t.Errorf("Validate(%s) = %v, want [%s]", body, got, want)
+		}
+	}
+}
+
+func TestToEvents(t *testing.T) {
+	p, err := Parse([]byte(`{
+		"client_id": "123.456",
+		"user_id": "u-1",
+		"timestamp_micros": 1700000000000000,
+		"user_properties": {"plan": {"value": "pro"}},
+		"events": [
+			{"name": "page_view", "params": {
+				"session_id": 1700000000,
+				"page_location": "https://example.com/pricing?utm_source=google&utm_campaign=spring",
+				"page_referrer": "https://www.google.com/",
+				"page_title": "Pricing",
+				"campaign": "override"
+			}},
+			{"name": "purchase", "timestamp_micros": "1700000001000000", "params": {"value": 42.5, "currency": "EUR", "items": [{"item_id": "sku-1"}]}}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := p.ToEvents()
+	if len(events) != 2 {
+		t.Fatalf("got %d events", len(events))
+	}
+
+	pv := events[0]
+	if pv.Type != "page_view" || pv.TS != "2023-11-14T22:13:20Z" {
+		t.Errorf("type = %q, ts = %q", pv.Type, pv.TS)
+	}
+	if pv.Session.VisitorID != "123.456" || pv.Session.UserID != "u-1" || pv.Session.SessionID != "1700000000" {
+		t.Errorf("session = %+v", pv.Session)
+	}
+	if pv.Route.Domain != "example.com" || pv.Route.Path != "/pricing" || pv.Route.Title != "Pricing" {
+		t.Errorf("route = %+v", pv.Route)
+	}
+	if pv.URL.Referrer != "https://www.google.com/" || pv.URL.ReferrerHostname != "www.google.com" {
+		t.Errorf("referrer = %q (%q)", pv.URL.Referrer, pv.URL.ReferrerHostname)
+	}
+	if pv.URL.UTM.Source != "google" || pv.URL.UTM.Campaign != "override" {
+		t.Errorf("utm = %+v, want the campaign param to win over page_location", pv.URL.UTM)
+	}
+	if pv.Props["user_properties.plan"] != "pro" || pv.Props["session_id"] != "1700000000" {
+		t.Errorf("props = %v", pv.Props)
+	}
+
+	purchase := events[1]
+	if purchase.TS != "2023-11-14T22:13:21Z" || purchase.Props["value"] != "42.5" || purchase.Props["currency"] != "EUR" ||
+		purchase.Props["items"] != `[{"item_id":"sku-1"}]` {
+		t.Errorf("purchase = ts %q, props %v", purchase.TS, purchase.Props)
+	}
+}
+
+func TestToEventsAppInstance(t *testing.T) {
+	p := Payload{AppInstanceID: "app-1", Events: []Event{{Name: "level_up"}}}
+	ev := p.ToEvents()[0]
+	if ev.Session.VisitorID != "app-1" || ev.TS != "" || ev.Props != nil {
+		t.Errorf("event = %+v", ev)
+	}
+}
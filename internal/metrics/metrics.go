@@ -2,31 +2,73 @@ package metrics
 
 import (
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/shortontech/gotrack/pkg/event/detection"
 )
 
 // Metrics holds all the Prometheus metrics for GoTrack
 type Metrics struct {
 	// Counters
-	EventsIngested *prometheus.CounterVec
-	SinkErrors     *prometheus.CounterVec
-	HTTPRequests   *prometheus.CounterVec
+	EventsIngested      *prometheus.CounterVec
+	SinkErrors          *prometheus.CounterVec
+	HTTPRequests        *prometheus.CounterVec
+	EventsSuppressed    *prometheus.CounterVec
+	EventsInvalid       *prometheus.CounterVec
+	EventsDuplicate     *prometheus.CounterVec
+	KafkaDeliveryErrors *prometheus.CounterVec
+
+	// Detection counters
+	DetectionAutomationHeaders   prometheus.Counter
+	DetectionUAAutomation        *prometheus.CounterVec
+	DetectionMissingHeaders      *prometheus.CounterVec
+	DetectionInconsistentHeaders *prometheus.CounterVec
+	DetectionHoneypotHits        *prometheus.CounterVec
+	DetectionHoneypotEvents      prometheus.Counter
+	DetectionTimingAnomalies     *prometheus.CounterVec
+	BotResponses                 *prometheus.CounterVec
+
+	// Proxy cache
+	ProxyCacheRequests *prometheus.CounterVec
+	ProxyCacheRemovals *prometheus.CounterVec
+	ProxyCacheEntries  prometheus.Gauge
+	ProxyCacheBytes    prometheus.Gauge
+
+	// Write-ahead log
+	WALPendingBytes prometheus.Gauge
+	WALLag          *prometheus.GaugeVec
+	WALBypassed     *prometheus.CounterVec
+
+	// Experiments
+	ExperimentAssignments *prometheus.CounterVec
 
 	// Gauges
-	QueueDepth *prometheus.GaugeVec
+	QueueDepth    *prometheus.GaugeVec
+	QueueAge      *prometheus.GaugeVec
+	SampleRate    *prometheus.GaugeVec
+	KafkaInFlight prometheus.Gauge
 
 	// Histograms
 	BatchFlushLatency *prometheus.HistogramVec
 	HTTPDuration      *prometheus.HistogramVec
+	DetectionBotScore prometheus.Histogram
+	RequestBodyBytes  *prometheus.HistogramVec
+	CollectBatchSize  prometheus.Histogram
+	SinkBatchSize     *prometheus.HistogramVec
 }
 
 // Config holds configuration for the metrics server
@@ -37,7 +79,8 @@ type Config struct {
 	TLSKey      string
 	ClientCA    string
 	RequireTLS  bool
-	RequireAuth bool
+	RequireAuth bool   // refuse to start unless AuthToken or ClientCA is set
+	AuthToken   string // bearer token required on /metrics; empty disables token auth
 }
 
 // LoadConfig loads metrics configuration from environment variables
@@ -50,18 +93,45 @@ func LoadConfig() Config {
 		ClientCA:    getOr("METRICS_CLIENT_CA", ""),
 		RequireTLS:  getBool("METRICS_REQUIRE_TLS", false),
 		RequireAuth: getBool("METRICS_REQUIRE_AUTH", false),
+		AuthToken:   getOr("METRICS_AUTH_TOKEN", ""),
+	}
+}
+
+// Validate rejects settings that would silently serve metrics with less
+// protection than configured. A disabled server is always valid.
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.RequireTLS && (c.TLSCert == "" || c.TLSKey == "") {
+		return errors.New("METRICS_REQUIRE_TLS needs METRICS_TLS_CERT and METRICS_TLS_KEY")
 	}
+	if c.ClientCA != "" {
+		if !c.RequireTLS {
+			return errors.New("METRICS_CLIENT_CA needs METRICS_REQUIRE_TLS")
+		}
+		if _, err := loadCertPool(c.ClientCA); err != nil {
+			return err
+		}
+	}
+	if c.RequireAuth && c.AuthToken == "" && c.ClientCA == "" {
+		return errors.New("METRICS_REQUIRE_AUTH needs METRICS_AUTH_TOKEN or METRICS_CLIENT_CA")
+	}
+	return nil
 }
 
+// batchBuckets spans single events up to the largest batches sinks flush
+var batchBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000}
+
 // NewMetrics creates and registers all GoTrack metrics
 func NewMetrics() *Metrics {
 	m := &Metrics{
 		EventsIngested: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "gotrack_events_ingested_total",
-				Help: "Total events ingested by sink type",
+				Help: "Total events ingested by sink type and tenant",
 			},
-			[]string{"sink"},
+			[]string{"sink", "tenant"},
 		),
 
 		SinkErrors: prometheus.NewCounterVec(
@@ -80,6 +150,161 @@ func NewMetrics() *Metrics {
 			[]string{"endpoint", "method", "status"},
 		),
 
+		EventsSuppressed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_events_suppressed_total",
+				Help: "Total events stripped, dropped or flagged because the client sent DNT or GPC or lacked TCF consent",
+			},
+			[]string{"signal", "action"},
+		),
+
+		EventsInvalid: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_events_invalid_total",
+				Help: "Total validation issues found on /collect events by issue code and policy action",
+			},
+			[]string{"code", "action"},
+		),
+
+		EventsDuplicate: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_events_duplicate_total",
+				Help: "Total events whose event_id was already seen within the dedup window, by action",
+			},
+			[]string{"action"},
+		),
+
+		KafkaDeliveryErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_kafka_delivery_errors_total",
+				Help: "Total failed Kafka deliveries by outcome (retried or dropped)",
+			},
+			[]string{"outcome"},
+		),
+
+		DetectionAutomationHeaders: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "gotrack_detection_automation_headers_total",
+				Help: "Events whose request carried automation tool headers",
+			},
+		),
+
+		DetectionUAAutomation: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_detection_ua_automation_total",
+				Help: "Events whose user agent contains an automation keyword, by keyword",
+			},
+			[]string{"keyword"},
+		),
+
+		DetectionMissingHeaders: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_detection_missing_headers_total",
+				Help: "Events whose request lacked a header browsers always send, by header",
+			},
+			[]string{"header"},
+		),
+
+		DetectionInconsistentHeaders: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_detection_inconsistent_headers_total",
+				Help: "Events whose request headers contradict each other, by check",
+			},
+			[]string{"check"},
+		),
+
+		DetectionHoneypotHits: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_detection_honeypot_hits_total",
+				Help: "Requests for a honeypot path, by path",
+			},
+			[]string{"path"},
+		),
+
+		DetectionHoneypotEvents: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "gotrack_detection_honeypot_events_total",
+				Help: "Events from clients that requested a honeypot path",
+			},
+		),
+
+		DetectionTimingAnomalies: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_detection_timing_anomalies_total",
+				Help: "Events from clients with bursty or periodic request timing, by pattern",
+			},
+			[]string{"pattern"},
+		),
+
+		BotResponses: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_bot_responses_total",
+				Help: "Events from likely bots given a BOT_RESPONSE, by endpoint and response",
+			},
+			[]string{"endpoint", "response"},
+		),
+
+		ProxyCacheRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_proxy_cache_requests_total",
+				Help: "Proxied requests by cache result (hit, miss, bypass)",
+			},
+			[]string{"result"},
+		),
+
+		ProxyCacheRemovals: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_proxy_cache_removals_total",
+				Help: "Entries removed from the proxy cache by reason (evicted, expired, purged)",
+			},
+			[]string{"reason"},
+		),
+
+		ProxyCacheEntries: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "gotrack_proxy_cache_entries",
+				Help: "Responses held by the proxy cache",
+			},
+		),
+
+		ProxyCacheBytes: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "gotrack_proxy_cache_bytes",
+				Help: "Approximate size of the responses held by the proxy cache",
+			},
+		),
+
+		WALPendingBytes: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "gotrack_wal_pending_bytes",
+				Help: "Bytes in the write-ahead log not yet delivered to every sink and checkpointed",
+			},
+		),
+
+		WALLag: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gotrack_wal_lag_bytes",
+				Help: "Bytes in the write-ahead log after a sink's checkpoint",
+			},
+			[]string{"sink"},
+		),
+
+		ExperimentAssignments: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_experiment_assignments_total",
+				Help: "Visitors assigned to an experiment variant on their first touch",
+			},
+			[]string{"experiment", "variant"},
+		),
+
+		WALBypassed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotrack_wal_bypassed_total",
+				Help: "Events sent straight to the sinks because the write-ahead log was full or failed (full, error)",
+			},
+			[]string{"reason"},
+		),
+
 		QueueDepth: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "gotrack_queue_depth",
@@ -88,6 +313,29 @@ func NewMetrics() *Metrics {
 			[]string{"sink"},
 		),
 
+		QueueAge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gotrack_queue_oldest_age_seconds",
+				Help: "Age of the oldest event a sink has not yet written, 0 when none is waiting",
+			},
+			[]string{"sink"},
+		),
+
+		SampleRate: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gotrack_sample_rate",
+				Help: "Current sample rate applied to events by type (1 = all events kept)",
+			},
+			[]string{"event_type"},
+		),
+
+		KafkaInFlight: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "gotrack_kafka_inflight_messages",
+				Help: "Events produced to Kafka and not yet acknowledged",
+			},
+		),
+
 		BatchFlushLatency: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "gotrack_batch_flush_latency_seconds",
@@ -105,15 +353,76 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"endpoint", "method"},
 		),
+
+		DetectionBotScore: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "gotrack_detection_bot_score",
+				Help:    "Bot score of collected events (0 = no automation signals, 100 = certain)",
+				Buckets: prometheus.LinearBuckets(10, 10, 10),
+			},
+		),
+
+		RequestBodyBytes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "gotrack_request_body_bytes",
+				Help:    "Size of request bodies as received and after decompression",
+				Buckets: prometheus.ExponentialBuckets(256, 4, 8), // 256 B to 4 MiB
+			},
+			[]string{"endpoint", "stage"},
+		),
+
+		CollectBatchSize: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "gotrack_collect_batch_events",
+				Help:    "Events per /collect request",
+				Buckets: batchBuckets,
+			},
+		),
+
+		SinkBatchSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "gotrack_sink_batch_events",
+				Help:    "Events per batch flushed to sinks",
+				Buckets: batchBuckets,
+			},
+			[]string{"sink"},
+		),
 	}
 
 	// Register all metrics
 	prometheus.MustRegister(m.EventsIngested)
 	prometheus.MustRegister(m.SinkErrors)
 	prometheus.MustRegister(m.HTTPRequests)
+	prometheus.MustRegister(m.EventsSuppressed)
+	prometheus.MustRegister(m.EventsInvalid)
+	prometheus.MustRegister(m.EventsDuplicate)
+	prometheus.MustRegister(m.KafkaDeliveryErrors)
+	prometheus.MustRegister(m.DetectionAutomationHeaders)
+	prometheus.MustRegister(m.DetectionUAAutomation)
+	prometheus.MustRegister(m.DetectionMissingHeaders)
+	prometheus.MustRegister(m.DetectionInconsistentHeaders)
+	prometheus.MustRegister(m.DetectionHoneypotHits)
+	prometheus.MustRegister(m.DetectionHoneypotEvents)
+	prometheus.MustRegister(m.DetectionTimingAnomalies)
+	prometheus.MustRegister(m.BotResponses)
+	prometheus.MustRegister(m.ProxyCacheRequests)
+	prometheus.MustRegister(m.ProxyCacheRemovals)
+	prometheus.MustRegister(m.ProxyCacheEntries)
+	prometheus.MustRegister(m.ProxyCacheBytes)
+	prometheus.MustRegister(m.WALPendingBytes)
+	prometheus.MustRegister(m.WALLag)
+	prometheus.MustRegister(m.ExperimentAssignments)
+	prometheus.MustRegister(m.WALBypassed)
 	prometheus.MustRegister(m.QueueDepth)
+	prometheus.MustRegister(m.QueueAge)
+	prometheus.MustRegister(m.SampleRate)
+	prometheus.MustRegister(m.KafkaInFlight)
 	prometheus.MustRegister(m.BatchFlushLatency)
 	prometheus.MustRegister(m.HTTPDuration)
+	prometheus.MustRegister(m.DetectionBotScore)
+	prometheus.MustRegister(m.RequestBodyBytes)
+	prometheus.MustRegister(m.CollectBatchSize)
+	prometheus.MustRegister(m.SinkBatchSize)
 
 	return m
 }
@@ -127,7 +436,13 @@ type Server struct {
 // NewServer creates a new metrics server
 func NewServer(config Config) *Server {
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
+	// OpenMetrics negotiation exposes exemplars to scrapers that ask for them
+	var handler http.Handler = promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	if config.AuthToken != "" {
+		handler = requireBearerToken(config.AuthToken, handler)
+	}
+	mux.Handle("/metrics", handler)
 
 	// Add a simple health check endpoint for the metrics server
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -154,12 +469,14 @@ func NewServer(config Config) *Server {
 		if config.ClientCA != "" {
 			clientCAs, err := loadCertPool(config.ClientCA)
 			if err != nil {
+				// Fail closed: an empty pool rejects every client certificate
 				log.Printf("metrics: failed to load client CA: %v", err)
+				clientCAs = x509.NewCertPool()
 			} else {
-				tlsConfig.ClientCAs = clientCAs
-				tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 				log.Printf("metrics: mTLS enabled with client CA: %s", config.ClientCA)
 			}
+			tlsConfig.ClientCAs = clientCAs
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 		}
 
 		srv.TLSConfig = tlsConfig
@@ -171,30 +488,42 @@ func NewServer(config Config) *Server {
 	}
 }
 
-// Start starts the metrics server in a separate goroutine
+// Start binds the metrics listener and serves it in a separate goroutine.
+// It returns an error when the address can't be bound or the TLS
+// certificate can't be loaded.
 func (s *Server) Start(ctx context.Context) error {
 	if !s.config.Enabled {
 		log.Printf("metrics: disabled (METRICS_ENABLED=false)")
 		return nil
 	}
 
+	useTLS := s.config.RequireTLS && s.config.TLSCert != "" && s.config.TLSKey != ""
+	if useTLS {
+		cert, err := tls.LoadX509KeyPair(s.config.TLSCert, s.config.TLSKey)
+		if err != nil {
+			return fmt.Errorf("metrics: failed to load TLS certificate: %w", err)
+		}
+		s.server.TLSConfig.Certificates = []tls.Certificate{cert}
+	}
+	ln, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("metrics: %w", err)
+	}
+
 	go func() {
 		var err error
-		if s.config.RequireTLS && s.config.TLSCert != "" && s.config.TLSKey != "" {
+		if useTLS {
 			log.Printf("metrics: HTTPS server listening on %s", s.config.Addr)
-			err = s.server.ListenAndServeTLS(s.config.TLSCert, s.config.TLSKey)
+			err = s.server.ServeTLS(ln, "", "")
 		} else {
 			log.Printf("metrics: HTTP server listening on %s", s.config.Addr)
-			err = s.server.ListenAndServe()
+			err = s.server.Serve(ln)
 		}
 
 		if err != nil && err != http.ErrServerClosed {
 			log.Printf("metrics: server error: %v", err)
 		}
 	}()
-
-	// Wait for server to start (give it a moment)
-	time.Sleep(100 * time.Millisecond)
 	return nil
 }
 
@@ -229,11 +558,30 @@ func getBool(key string, defaultValue bool) bool {
 	return parsed
 }
 
+// loadCertPool reads PEM-encoded CA certificates from certFile
 func loadCertPool(certFile string) (*x509.CertPool, error) {
-	// This would load a certificate pool from a file
-	// For now, return nil to indicate no client CA
-	// In production, you'd implement proper certificate loading
-	return nil, nil
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("client CA %s contains no PEM certificates", certFile)
+	}
+	return pool, nil
+}
+
+// requireBearerToken rejects requests without Authorization: Bearer token
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // Global metrics instance
@@ -256,8 +604,10 @@ func GetMetrics() *Metrics {
 }
 
 // Convenience methods for common operations
-func (m *Metrics) IncrementEventsIngested(sink string) {
-	m.EventsIngested.WithLabelValues(sink).Inc()
+// IncrementEventsIngested counts an event written to sink. tenant is the
+// event's site ID, empty in single-tenant deployments.
+func (m *Metrics) IncrementEventsIngested(sink, tenant string) {
+	m.EventsIngested.WithLabelValues(sink, tenant).Inc()
 }
 
 func (m *Metrics) IncrementSinkErrors(sink, errorType string) {
@@ -268,14 +618,136 @@ func (m *Metrics) IncrementHTTPRequests(endpoint, method, status string) {
 	m.HTTPRequests.WithLabelValues(endpoint, method, status).Inc()
 }
 
+func (m *Metrics) IncrementEventsSuppressed(signal, action string) {
+	m.EventsSuppressed.WithLabelValues(signal, action).Inc()
+}
+
+func (m *Metrics) IncrementEventsInvalid(code, action string) {
+	m.EventsInvalid.WithLabelValues(code, action).Inc()
+}
+
+// IncrementHoneypotHits counts a request for a honeypot path. Paths come
+// from HONEYPOT_PATHS, so the label stays bounded.
+func (m *Metrics) IncrementHoneypotHits(path string) {
+	m.DetectionHoneypotHits.WithLabelValues(path).Inc()
+}
+
+func (m *Metrics) IncrementBotResponses(endpoint, response string) {
+	m.BotResponses.WithLabelValues(endpoint, response).Inc()
+}
+
+func (m *Metrics) IncrementEventsDuplicate(action string) {
+	m.EventsDuplicate.WithLabelValues(action).Inc()
+}
+
+func (m *Metrics) AddKafkaDeliveryErrors(outcome string, n int) {
+	m.KafkaDeliveryErrors.WithLabelValues(outcome).Add(float64(n))
+}
+
+func (m *Metrics) SetKafkaInFlight(n float64) {
+	m.KafkaInFlight.Set(n)
+}
+
+func (m *Metrics) IncrementProxyCacheRequests(result string) {
+	m.ProxyCacheRequests.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) AddProxyCacheRemovals(reason string, n int) {
+	m.ProxyCacheRemovals.WithLabelValues(reason).Add(float64(n))
+}
+
+func (m *Metrics) SetProxyCacheSize(entries int, bytes int64) {
+	m.ProxyCacheEntries.Set(float64(entries))
+	m.ProxyCacheBytes.Set(float64(bytes))
+}
+
+func (m *Metrics) SetWALPendingBytes(n int64) {
+	m.WALPendingBytes.Set(float64(n))
+}
+
+func (m *Metrics) SetWALLag(sink string, n int64) {
+	m.WALLag.WithLabelValues(sink).Set(float64(n))
+}
+
+func (m *Metrics) IncrementExperimentAssignment(experiment, variant string) {
+	m.ExperimentAssignments.WithLabelValues(experiment, variant).Inc()
+}
+
+func (m *Metrics) IncrementWALBypassed(reason string) {
+	m.WALBypassed.WithLabelValues(reason).Inc()
+}
+
 func (m *Metrics) SetQueueDepth(sink string, depth float64) {
 	m.QueueDepth.WithLabelValues(sink).Set(depth)
 }
 
+func (m *Metrics) SetQueueAge(sink string, age time.Duration) {
+	m.QueueAge.WithLabelValues(sink).Set(age.Seconds())
+}
+
+func (m *Metrics) SetSampleRate(eventType string, rate float64) {
+	m.SampleRate.WithLabelValues(eventType).Set(rate)
+}
+
 func (m *Metrics) ObserveBatchFlushLatency(sink string, duration time.Duration) {
 	m.BatchFlushLatency.WithLabelValues(sink).Observe(duration.Seconds())
 }
 
+// ObserveSinkFlush records the size and latency of a batch flushed to a sink
+func (m *Metrics) ObserveSinkFlush(sink string, events int, duration time.Duration) {
+	m.SinkBatchSize.WithLabelValues(sink).Observe(float64(events))
+	m.BatchFlushLatency.WithLabelValues(sink).Observe(duration.Seconds())
+}
+
+// ObserveRequestBody records the size of a request body on the wire and
+// after decompression; the two are equal for uncompressed bodies
+func (m *Metrics) ObserveRequestBody(endpoint string, received, decompressed int) {
+	m.RequestBodyBytes.WithLabelValues(endpoint, "received").Observe(float64(received))
+	m.RequestBodyBytes.WithLabelValues(endpoint, "decompressed").Observe(float64(decompressed))
+}
+
+func (m *Metrics) ObserveCollectBatch(events int) {
+	m.CollectBatchSize.Observe(float64(events))
+}
+
 func (m *Metrics) ObserveHTTPDuration(endpoint, method string, duration time.Duration) {
 	m.HTTPDuration.WithLabelValues(endpoint, method).Observe(duration.Seconds())
 }
+
+// ObserveDetection records the bot-detection signals of a collected event.
+// Header values are not used as labels since clients control them.
+func (m *Metrics) ObserveDetection(d detection.ServerDetectionSignals) {
+	if len(d.HeaderAnalysis.AutomationHeaders) > 0 {
+		m.DetectionAutomationHeaders.Inc()
+	}
+	for _, keyword := range d.RequestAnalysis.UserAgentAnalysis.AutomationKeywords {
+		m.DetectionUAAutomation.WithLabelValues(keyword).Inc()
+	}
+	for _, header := range d.HeaderAnalysis.MissingExpected {
+		m.DetectionMissingHeaders.WithLabelValues(header).Inc()
+	}
+	for _, check := range d.HeaderAnalysis.InconsistentValues {
+		m.DetectionInconsistentHeaders.WithLabelValues(check).Inc()
+	}
+	if d.Honeypot {
+		m.DetectionHoneypotEvents.Inc()
+	}
+	if d.TimingAnalysis.Bursty {
+		m.DetectionTimingAnomalies.WithLabelValues("bursty").Inc()
+	}
+	if d.TimingAnalysis.Periodic {
+		m.DetectionTimingAnomalies.WithLabelValues("periodic").Inc()
+	}
+	m.DetectionBotScore.Observe(float64(d.BotScore()))
+}
+
+// ObserveHTTPDurationWithExemplar records the duration with the request ID
+// as an exemplar, so a slow bucket leads to the matching log line
+func (m *Metrics) ObserveHTTPDurationWithExemplar(endpoint, method string, duration time.Duration, requestID string) {
+	observer := m.HTTPDuration.WithLabelValues(endpoint, method)
+	if eo, ok := observer.(prometheus.ExemplarObserver); ok && requestID != "" {
+		eo.ObserveWithExemplar(duration.Seconds(), prometheus.Labels{"request_id": requestID})
+		return
+	}
+	observer.Observe(duration.Seconds())
+}
@@ -2,14 +2,41 @@ package metrics
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/shortontech/gotrack/pkg/event/detection"
 )
 
+// sampleCount returns how many values a histogram has observed
+func sampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
 func assertMetricsConfig(t *testing.T, cfg Config, expected map[string]interface{}) {
 	t.Helper()
 	if val, ok := expected["Enabled"].(bool); ok && cfg.Enabled != val {
@@ -33,6 +60,9 @@ func assertMetricsConfig(t *testing.T, cfg Config, expected map[string]interface
 	if val, ok := expected["RequireAuth"].(bool); ok && cfg.RequireAuth != val {
 		t.Errorf("RequireAuth = %v, want %v", cfg.RequireAuth, val)
 	}
+	if val, ok := expected["AuthToken"].(string); ok && cfg.AuthToken != val {
+		t.Errorf("AuthToken = %q, want %q", cfg.AuthToken, val)
+	}
 }
 
 func TestLoadConfig(t *testing.T) {
@@ -40,7 +70,7 @@ func TestLoadConfig(t *testing.T) {
 		envVars := []string{
 			"METRICS_ENABLED", "METRICS_ADDR", "METRICS_TLS_CERT",
 			"METRICS_TLS_KEY", "METRICS_CLIENT_CA", "METRICS_REQUIRE_TLS",
-			"METRICS_REQUIRE_AUTH",
+			"METRICS_REQUIRE_AUTH", "METRICS_AUTH_TOKEN",
 		}
 		oldValues := make(map[string]string)
 		for _, key := range envVars {
@@ -57,7 +87,7 @@ func TestLoadConfig(t *testing.T) {
 		cfg := LoadConfig()
 		assertMetricsConfig(t, cfg, map[string]interface{}{
 			"Enabled": false, "Addr": "127.0.0.1:9090", "TLSCert": "", "TLSKey": "",
-			"ClientCA": "", "RequireTLS": false, "RequireAuth": false,
+			"ClientCA": "", "RequireTLS": false, "RequireAuth": false, "AuthToken": "",
 		})
 	})
 
@@ -66,7 +96,7 @@ func TestLoadConfig(t *testing.T) {
 			"METRICS_ENABLED": "true", "METRICS_ADDR": "0.0.0.0:8080",
 			"METRICS_TLS_CERT": "/path/to/cert.pem", "METRICS_TLS_KEY": "/path/to/key.pem",
 			"METRICS_CLIENT_CA": "/path/to/ca.pem", "METRICS_REQUIRE_TLS": "true",
-			"METRICS_REQUIRE_AUTH": "true",
+			"METRICS_REQUIRE_AUTH": "true", "METRICS_AUTH_TOKEN": "scrape-token",
 		}
 		oldValues := make(map[string]string)
 		for key, val := range envVars {
@@ -86,7 +116,7 @@ func TestLoadConfig(t *testing.T) {
 		assertMetricsConfig(t, cfg, map[string]interface{}{
 			"Enabled": true, "Addr": "0.0.0.0:8080", "TLSCert": "/path/to/cert.pem",
 			"TLSKey": "/path/to/key.pem", "ClientCA": "/path/to/ca.pem",
-			"RequireTLS": true, "RequireAuth": true,
+			"RequireTLS": true, "RequireAuth": true, "AuthToken": "scrape-token",
 		})
 	})
 }
@@ -241,15 +271,43 @@ func TestNewMetrics(t *testing.T) {
 		if m.HTTPRequests == nil {
 			t.Error("HTTPRequests should not be nil")
 		}
+		if m.EventsSuppressed == nil {
+			t.Error("EventsSuppressed should not be nil")
+		}
+		if m.EventsInvalid == nil {
+			t.Error("EventsInvalid should not be nil")
+		}
+		if m.EventsDuplicate == nil {
+			t.Error("EventsDuplicate should not be nil")
+		}
+		if m.KafkaDeliveryErrors == nil {
+			t.Error("KafkaDeliveryErrors should not be nil")
+		}
 		if m.QueueDepth == nil {
 			t.Error("QueueDepth should not be nil")
 		}
+		if m.SampleRate == nil {
+			t.Error("SampleRate should not be nil")
+		}
+		if m.KafkaInFlight == nil {
+			t.Error("KafkaInFlight should not be nil")
+		}
 		if m.BatchFlushLatency == nil {
 			t.Error("BatchFlushLatency should not be nil")
 		}
 		if m.HTTPDuration == nil {
 			t.Error("HTTPDuration should not be nil")
 		}
+		if m.ProxyCacheRequests == nil || m.ProxyCacheRemovals == nil || m.ProxyCacheEntries == nil || m.ProxyCacheBytes == nil {
+			t.Error("proxy cache metrics should not be nil")
+		}
+		if m.RequestBodyBytes == nil || m.CollectBatchSize == nil || m.SinkBatchSize == nil {
+			t.Error("size metrics should not be nil")
+		}
+		if m.DetectionAutomationHeaders == nil || m.DetectionUAAutomation == nil || m.DetectionMissingHeaders == nil ||
+			m.DetectionInconsistentHeaders == nil || m.DetectionBotScore == nil {
+			t.Error("detection metrics should not be nil")
+		}
 	})
 }
 
@@ -259,9 +317,9 @@ func TestMetricsConvenienceMethods(t *testing.T) {
 
 	t.Run("IncrementEventsIngested", func(t *testing.T) {
 		// Should not panic
-		m.IncrementEventsIngested("log")
-		m.IncrementEventsIngested("kafka")
-		m.IncrementEventsIngested("postgres")
+		m.IncrementEventsIngested("log", "")
+		m.IncrementEventsIngested("kafka", "shop")
+		m.IncrementEventsIngested("postgres", "blog")
 	})
 
 	t.Run("IncrementSinkErrors", func(t *testing.T) {
@@ -278,6 +336,53 @@ func TestMetricsConvenienceMethods(t *testing.T) {
 		m.IncrementHTTPRequests("/api/test", "GET", "404")
 	})
 
+	t.Run("IncrementEventsSuppressed", func(t *testing.T) {
+		// Should not panic
+		m.IncrementEventsSuppressed("dnt", "strip")
+		m.IncrementEventsSuppressed("gpc", "drop")
+	})
+
+	t.Run("IncrementEventsInvalid", func(t *testing.T) {
+		m.IncrementEventsInvalid("too_long", "sanitized")
+		if got := testutil.ToFloat64(m.EventsInvalid.WithLabelValues("too_long", "sanitized")); got < 1 {
+			t.Errorf("invalid events = %v, want >= 1", got)
+		}
+	})
+
+	t.Run("IncrementEventsDuplicate", func(t *testing.T) {
+		m.IncrementEventsDuplicate("drop")
+		if got := testutil.ToFloat64(m.EventsDuplicate.WithLabelValues("drop")); got < 1 {
+			t.Errorf("duplicate events = %v, want >= 1", got)
+		}
+	})
+
+	t.Run("Kafka delivery tracking", func(t *testing.T) {
+		m.AddKafkaDeliveryErrors("retried", 2)
+		m.AddKafkaDeliveryErrors("dropped", 1)
+		m.SetKafkaInFlight(42)
+		if got := testutil.ToFloat64(m.KafkaDeliveryErrors.WithLabelValues("retried")); got < 2 {
+			t.Errorf("retried deliveries = %v, want >= 2", got)
+		}
+		if got := testutil.ToFloat64(m.KafkaInFlight); got != 42 {
+			t.Errorf("in-flight gauge = %v, want 42", got)
+		}
+	})
+
+	t.Run("Proxy cache tracking", func(t *testing.T) {
+		m.IncrementProxyCacheRequests("hit")
+		m.AddProxyCacheRemovals("evicted", 3)
+		m.SetProxyCacheSize(7, 4096)
+		if got := testutil.ToFloat64(m.ProxyCacheRemovals.WithLabelValues("evicted")); got < 3 {
+			t.Errorf("evictions = %v, want >= 3", got)
+		}
+		if got := testutil.ToFloat64(m.ProxyCacheEntries); got != 7 {
+			t.Errorf("entries gauge = %v, want 7", got)
+		}
+		if got := testutil.ToFloat64(m.ProxyCacheBytes); got != 4096 {
+			t.Errorf("bytes gauge = %v, want 4096", got)
+		}
+	})
+
 	t.Run("SetQueueDepth", func(t *testing.T) {
 		// Should not panic
 		m.SetQueueDepth("kafka", 100.0)
@@ -285,6 +390,19 @@ func TestMetricsConvenienceMethods(t *testing.T) {
 		m.SetQueueDepth("log", 0.0)
 	})
 
+	t.Run("SetQueueAge", func(t *testing.T) {
+		m.SetQueueAge("postgres", 1500*time.Millisecond)
+		if got := testutil.ToFloat64(m.QueueAge.WithLabelValues("postgres")); got != 1.5 {
+			t.Errorf("queue age = %v, want 1.5", got)
+		}
+	})
+
+	t.Run("SetSampleRate", func(t *testing.T) {
+		// Should not panic
+		m.SetSampleRate("pageview", 0.5)
+		m.SetSampleRate("pageview", 1)
+	})
+
 	t.Run("ObserveBatchFlushLatency", func(t *testing.T) {
 		// Should not panic
 		m.ObserveBatchFlushLatency("kafka", 50*time.Millisecond)
@@ -292,12 +410,87 @@ func TestMetricsConvenienceMethods(t *testing.T) {
 		m.ObserveBatchFlushLatency("log", 1*time.Millisecond)
 	})
 
+	t.Run("ObserveSinkFlush", func(t *testing.T) {
+		batches := sampleCount(t, m.SinkBatchSize.WithLabelValues("relay"))
+		flushes := sampleCount(t, m.BatchFlushLatency.WithLabelValues("relay"))
+		m.ObserveSinkFlush("relay", 250, 20*time.Millisecond)
+		if got := sampleCount(t, m.SinkBatchSize.WithLabelValues("relay")); got != batches+1 {
+			t.Errorf("batch sizes observed = %d, want %d", got, batches+1)
+		}
+		if got := sampleCount(t, m.BatchFlushLatency.WithLabelValues("relay")); got != flushes+1 {
+			t.Errorf("flush latencies observed = %d, want %d", got, flushes+1)
+		}
+	})
+
+	t.Run("ObserveRequestBody and ObserveCollectBatch", func(t *testing.T) {
+		m.ObserveRequestBody("/collect", 300, 1200)
+		m.ObserveCollectBatch(20)
+
+		w := httptest.NewRecorder()
+		NewServer(Config{}).server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		body := w.Body.String()
+		for _, want := range []string{
+			`gotrack_request_body_bytes_bucket{endpoint="/collect",stage="received",le="1024"}`,
+			`gotrack_request_body_bytes_bucket{endpoint="/collect",stage="decompressed",le="4096"}`,
+			`gotrack_collect_batch_events_bucket{le="20"}`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected %s in metrics output", want)
+			}
+		}
+	})
+
 	t.Run("ObserveHTTPDuration", func(t *testing.T) {
 		// Should not panic
 		m.ObserveHTTPDuration("/collect", "POST", 10*time.Millisecond)
 		m.ObserveHTTPDuration("/px.gif", "GET", 1*time.Millisecond)
 		m.ObserveHTTPDuration("/api/test", "GET", 50*time.Millisecond)
 	})
+
+	t.Run("ObserveDetection", func(t *testing.T) {
+		var d detection.ServerDetectionSignals
+		d.HeaderAnalysis.AutomationHeaders = []string{"X-Selenium: 1"}
+		d.HeaderAnalysis.MissingExpected = []string{"Accept-Language"}
+		d.HeaderAnalysis.InconsistentValues = []string{"language-ua-mismatch"}
+		d.RequestAnalysis.UserAgentAnalysis.AutomationKeywords = []string{"headless"}
+
+		headers := testutil.ToFloat64(m.DetectionAutomationHeaders)
+		m.ObserveDetection(d)
+		m.ObserveDetection(detection.ServerDetectionSignals{})
+
+		if got := testutil.ToFloat64(m.DetectionAutomationHeaders); got != headers+1 {
+			t.Errorf("automation headers = %v, want %v", got, headers+1)
+		}
+		if got := testutil.ToFloat64(m.DetectionUAAutomation.WithLabelValues("headless")); got < 1 {
+			t.Errorf("headless user agents = %v, want >= 1", got)
+		}
+		if got := testutil.ToFloat64(m.DetectionMissingHeaders.WithLabelValues("Accept-Language")); got < 1 {
+			t.Errorf("missing Accept-Language = %v, want >= 1", got)
+		}
+		if got := testutil.ToFloat64(m.DetectionInconsistentHeaders.WithLabelValues("language-ua-mismatch")); got < 1 {
+			t.Errorf("inconsistent headers = %v, want >= 1", got)
+		}
+
+		w := httptest.NewRecorder()
+		NewServer(Config{}).server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if !strings.Contains(w.Body.String(), `gotrack_detection_bot_score_bucket{le="100"}`) {
+			t.Error("expected bot score histogram in metrics output")
+		}
+	})
+
+	t.Run("ObserveHTTPDurationWithExemplar", func(t *testing.T) {
+		m.ObserveHTTPDurationWithExemplar("/collect", "POST", 10*time.Millisecond, "req-exemplar-1")
+		m.ObserveHTTPDurationWithExemplar("/collect", "POST", 10*time.Millisecond, "")
+
+		// Exemplars are only exposed in the OpenMetrics format
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", "application/openmetrics-text")
+		w := httptest.NewRecorder()
+		NewServer(Config{}).server.Handler.ServeHTTP(w, req)
+		if body := w.Body.String(); !strings.Contains(body, `request_id="req-exemplar-1"`) {
+			t.Errorf("expected request ID exemplar in OpenMetrics output")
+		}
+	})
 }
 
 // TestInitMetrics tests global metrics initialization
@@ -478,6 +671,28 @@ func TestServerStart(t *testing.T) {
 		srv.Shutdown(context.Background())
 	})
 
+	t.Run("reports an address in use", func(t *testing.T) {
+		taken, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		defer taken.Close()
+
+		srv := NewServer(Config{Enabled: true, Addr: taken.Addr().String()})
+		if err := srv.Start(context.Background()); err == nil {
+			srv.Shutdown(context.Background())
+			t.Error("Start() should fail when the address is taken")
+		}
+	})
+
+	t.Run("reports a missing TLS certificate", func(t *testing.T) {
+		srv := NewServer(Config{Enabled: true, Addr: "localhost:0", RequireTLS: true, TLSCert: "missing.crt", TLSKey: "missing.key"})
+		if err := srv.Start(context.Background()); err == nil {
+			srv.Shutdown(context.Background())
+			t.Error("Start() should fail without the certificate")
+		}
+	})
+
 	t.Run("handles context cancellation", func(t *testing.T) {
 		cfg := Config{
 			Enabled: true,
@@ -595,17 +810,194 @@ func TestServerHealthEndpoint(t *testing.T) {
 	})
 }
 
+// testCA is a throwaway certificate authority for mTLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// writeFile stores the CA certificate in a temporary file and returns its path
+func (ca *testCA) writeFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, ca.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clientCert issues a client certificate signed by the CA
+func (ca *testCA) clientCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "prometheus"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
 // TestLoadCertPool tests certificate pool loading
 func TestLoadCertPool(t *testing.T) {
-	t.Run("returns nil for stub implementation", func(t *testing.T) {
-		pool, err := loadCertPool("/path/to/cert.pem")
+	t.Run("loads PEM certificates", func(t *testing.T) {
+		pool, err := loadCertPool(newTestCA(t).writeFile(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pool == nil {
+			t.Error("loadCertPool should return a pool")
+		}
+	})
 
-		// Current implementation is a stub
-		if pool != nil {
-			t.Error("loadCertPool should return nil in stub implementation")
+	t.Run("fails for a missing file", func(t *testing.T) {
+		if _, err := loadCertPool(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+			t.Error("expected error for a missing file")
 		}
-		if err != nil {
-			t.Error("loadCertPool should not return error in stub implementation")
+	})
+
+	t.Run("fails without certificates", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "empty.pem")
+		if err := os.WriteFile(path, []byte("not a certificate"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadCertPool(path); err == nil {
+			t.Error("expected error for a file without certificates")
+		}
+	})
+}
+
+func TestConfigValidate(t *testing.T) {
+	caFile := newTestCA(t).writeFile(t)
+	tlsOn := Config{Enabled: true, RequireTLS: true, TLSCert: "/cert.pem", TLSKey: "/key.pem"}
+	withCA := tlsOn
+	withCA.ClientCA = caFile
+
+	tests := []struct {
+		name    string
+		edit    func(c *Config)
+		base    Config
+		wantErr bool
+	}{
+		{"disabled", func(c *Config) { c.RequireAuth, c.RequireTLS = true, true }, Config{}, false},
+		{"plain HTTP", func(c *Config) {}, Config{Enabled: true}, false},
+		{"TLS without key", func(c *Config) { c.TLSKey = "" }, tlsOn, true},
+		{"client CA without TLS", func(c *Config) { c.RequireTLS = false }, withCA, true},
+		{"unreadable client CA", func(c *Config) { c.ClientCA = "/missing/ca.pem" }, tlsOn, true},
+		{"mTLS", func(c *Config) { c.RequireAuth = true }, withCA, false},
+		{"auth without credentials", func(c *Config) { c.RequireAuth = true }, tlsOn, true},
+		{"auth with token", func(c *Config) { c.RequireAuth, c.AuthToken = true, "secret" }, Config{Enabled: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.base
+			tt.edit(&cfg)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerBearerAuth(t *testing.T) {
+	handler := NewServer(Config{AuthToken: "scrape-token"}).server.Handler
+
+	for name, tc := range map[string]struct {
+		path, auth string
+		want       int
+	}{
+		"missing token":     {"/metrics", "", http.StatusUnauthorized},
+		"wrong token":       {"/metrics", "Bearer nope", http.StatusUnauthorized},
+		"wrong scheme":      {"/metrics", "Basic scrape-token", http.StatusUnauthorized},
+		"valid token":       {"/metrics", "Bearer scrape-token", http.StatusOK},
+		"health stays open": {"/healthz", "", http.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tc.want {
+				t.Errorf("status = %d, want %d", w.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	srv := NewServer(Config{Enabled: true, RequireTLS: true, TLSCert: "/cert.pem", TLSKey: "/key.pem", ClientCA: ca.writeFile(t)})
+	if srv.server.TLSConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("ClientAuth = %v, want RequireAndVerifyClientCert", srv.server.TLSConfig.ClientAuth)
+	}
+
+	ts := httptest.NewUnstartedServer(srv.server.Handler)
+	ts.TLS = srv.server.TLSConfig.Clone()
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(certs ...tls.Certificate) (*http.Response, error) {
+		transport := ts.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certs
+		return (&http.Client{Transport: transport}).Get(ts.URL + "/metrics")
+	}
+
+	if resp, err := get(); err == nil {
+		resp.Body.Close()
+		t.Error("request without a client certificate should fail")
+	}
+	resp, err := get(ca.clientCert(t))
+	if err != nil {
+		t.Fatalf("request with a client certificate: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+
+	t.Run("fails closed when the CA cannot be loaded", func(t *testing.T) {
+		srv := NewServer(Config{Enabled: true, RequireTLS: true, TLSCert: "/cert.pem", TLSKey: "/key.pem", ClientCA: "/missing/ca.pem"})
+		if srv.server.TLSConfig.ClientAuth != tls.RequireAndVerifyClientCert || srv.server.TLSConfig.ClientCAs == nil {
+			t.Error("a missing client CA should still require client certificates")
 		}
 	})
 }
@@ -658,7 +1050,11 @@ func TestMetricsStruct(t *testing.T) {
 		_ = m.EventsIngested
 		_ = m.SinkErrors
 		_ = m.HTTPRequests
+		_ = m.EventsSuppressed
+		_ = m.EventsInvalid
+		_ = m.EventsDuplicate
 		_ = m.QueueDepth
+		_ = m.SampleRate
 		_ = m.BatchFlushLatency
 		_ = m.HTTPDuration
 	})
@@ -0,0 +1,113 @@
+// Package outbound checks the destinations of /r outbound link redirects. A
+// destination is followed when its host is on the allowlist or the link was
+// signed with the secret, so the endpoint can't be used as an open redirect.
+package outbound
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Path is the redirect endpoint, relative to the tracking host
+const Path = "/r"
+
+// Query parameters of a redirect link
+const (
+	URLParam       = "u"
+	SignatureParam = "s"
+)
+
+// signatureBytes is how much of the HMAC-SHA256 a link carries
+const signatureBytes = 16
+
+var (
+	// ErrNotAllowed is returned for a destination that is neither signed nor on the allowlist
+	ErrNotAllowed = errors.New("destination not allowed")
+	// ErrInvalidDestination is returned for a destination that isn't an absolute http or https URL
+	ErrInvalidDestination = errors.New("destination must be an absolute http or https URL")
+)
+
+// Policy decides which destinations /r redirects to
+type Policy struct {
+	secret []byte
+	hosts  []string // lowercased; "*.example.com" matches subdomains
+}
+
+// NewPolicy returns the policy for a signing secret and allowed hosts, such
+// as "partner.example" or "*.partner.example". Either may be empty.
+func NewPolicy(secret string, hosts []string) (Policy, error) {
+	p := Policy{secret: []byte(secret)}
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		name := strings.TrimPrefix(host, "*.")
+		if name == "" || strings.ContainsAny(name, "/:*@ ") {
+			return Policy{}, fmt.Errorf("invalid host %q: want a host name, optionally starting with *.", host)
+		}
+		p.hosts = append(p.hosts, host)
+	}
+	return p, nil
+}
+
+// Enabled reports whether any destination can be followed
+func (p Policy) Enabled() bool {
+	return len(p.secret) > 0 || len(p.hosts) > 0
+}
+
+// Destination returns the checked destination of a redirect link's query
+func (p Policy) Destination(q url.Values) (*url.URL, error) {
+	raw := q.Get(URLParam)
+	dest, err := url.Parse(raw)
+	if err != nil || (dest.Scheme != "http" && dest.Scheme != "https") || dest.Host == "" {
+		return nil, ErrInvalidDestination
+	}
+	if p.allowsHost(dest.Hostname()) {
+		return dest, nil
+	}
+	if len(p.secret) > 0 {
+		got, err := base64.RawURLEncoding.DecodeString(q.Get(SignatureParam))
+		if err == nil && hmac.Equal(got, p.signature(raw)) {
+			return dest, nil
+		}
+	}
+	return nil, ErrNotAllowed
+}
+
+// URL returns the redirect link to dest on the tracking host base, signed
+// when the policy has a secret
+func (p Policy) URL(base, dest string) (string, error) {
+	q := url.Values{URLParam: {dest}}
+	if len(p.secret) > 0 {
+		q.Set(SignatureParam, base64.RawURLEncoding.EncodeToString(p.signature(dest)))
+	}
+	if _, err := p.Destination(q); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(base, "/") + Path + "?" + q.Encode(), nil
+}
+
+func (p Policy) allowsHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range p.hosts {
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) signature(dest string) []byte {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(Path))
+	mac.Write([]byte{0})
+	mac.Write([]byte(dest))
+	return mac.Sum(nil)[:signatureBytes]
+}
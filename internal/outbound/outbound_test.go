@@ -0,0 +1,76 @@
+package outbound
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestPolicy_Allowlist(t *testing.T) {
+	p, err := NewPolicy("", []string{"partner.example", "*.Shop.Example"})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	tests := []struct {
+		dest string
+		want error
+	}{
+		{"https://partner.example/offer", nil},
+		{"http://PARTNER.example:8080/", nil},
+		{"https://eu.shop.example/cart", nil},
+		{"https://shop.example/", ErrNotAllowed},
+		{"https://evilshop.example/", ErrNotAllowed},
+		{"https://partner.example.evil.example/", ErrNotAllowed},
+		{"https://other.example/", ErrNotAllowed},
+		{"javascript:alert(1)", ErrInvalidDestination},
+		{"//partner.example/", ErrInvalidDestination},
+		{"", ErrInvalidDestination},
+	}
+	for _, tt := range tests {
+		if _, err := p.Destination(url.Values{URLParam: {tt.dest}}); !errors.Is(err, tt.want) {
+			t.Errorf("Destination(%q) error = %v, want %v", tt.dest, err, tt.want)
+		}
+	}
+}
+
+func TestPolicy_Signed(t *testing.T) {
+	p, _ := NewPolicy("secret", nil)
+	link, err := p.URL("https://track.example.com/", "https://anywhere.example/page?a=1")
+	if err != nil {
+		t.Fatalf("URL() error = %v", err)
+	}
+	u, _ := url.Parse(link)
+	if u.Path != Path {
+		t.Fatalf("URL() = %q", link)
+	}
+	q := u.Query()
+	if dest, err := p.Destination(q); err != nil || dest.String() != "https://anywhere.example/page?a=1" {
+		t.Errorf("Destination() = %v, %v", dest, err)
+	}
+
+	q.Set(URLParam, "https://evil.example/")
+	if _, err := p.Destination(q); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("edited destination error = %v, want ErrNotAllowed", err)
+	}
+	other, _ := NewPolicy("other", nil)
+	if _, err := other.Destination(u.Query()); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("other secret error = %v, want ErrNotAllowed", err)
+	}
+
+	// Without a secret, only allowed hosts get links
+	unsigned, _ := NewPolicy("", []string{"partner.example"})
+	if _, err := unsigned.URL("https://track.example.com", "https://anywhere.example/"); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("URL() for a host off the allowlist error = %v", err)
+	}
+}
+
+func TestNewPolicy_InvalidHosts(t *testing.T) {
+	for _, host := range []string{"https://partner.example", "partner.example/path", "*.", "a.*.example", "user@host"} {
+		if _, err := NewPolicy("", []string{host}); err == nil {
+			t.Errorf("NewPolicy(%q) succeeded, want an error", host)
+		}
+	}
+	if p, _ := NewPolicy("", nil); p.Enabled() {
+		t.Error("empty policy is enabled")
+	}
+}
@@ -0,0 +1,193 @@
+// Package privacy anonymizes client IP addresses before events reach sinks.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Mode selects how Server.IP is treated
+type Mode string
+
+const (
+	ModeNone     Mode = "none"     // keep the raw IP
+	ModeHash     Mode = "hash"     // HMAC-SHA256 with a daily salt derived from the secret
+	ModeTruncate Mode = "truncate" // zero the host part: /24 for IPv4, /48 for IPv6
+	ModeDrop     Mode = "drop"     // remove the IP entirely
+)
+
+// ParseMode validates a mode name; empty means ModeNone
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "":
+		return ModeNone, nil
+	case ModeNone, ModeHash, ModeTruncate, ModeDrop:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown IP privacy mode %q (want none, hash, truncate or drop)", s)
+	}
+}
+
+// Policy holds the default mode plus per-sink overrides
+type Policy struct {
+	Default   Mode
+	Overrides map[string]Mode // sink name -> mode
+	secret    string
+
+	mu      sync.Mutex
+	saltDay string
+	salt    []byte
+	now     func() time.Time
+}
+
+// NewPolicy builds a policy from the configured default mode and "sink=mode"
+// overrides. An empty default hashes when a secret is set and keeps the raw
+// IP otherwise. Hashing requires a secret.
+func NewPolicy(defaultMode string, overrides []string, secret string) (*Policy, error) {
+	if strings.TrimSpace(defaultMode) == "" && secret != "" {
+		defaultMode = string(ModeHash)
+	}
+	def, err := ParseMode(defaultMode)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{
+		Default:   def,
+		Overrides: make(map[string]Mode),
+		secret:    secret,
+		now:       time.Now,
+	}
+	for _, o := range overrides {
+		name, value, ok := strings.Cut(o, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid IP privacy override %q (want sink=mode)", o)
+		}
+		mode, err := ParseMode(value)
+		if err != nil {
+			return nil, fmt.Errorf("override for %s: %w", name, err)
+		}
+		p.Overrides[name] = mode
+	}
+
+	if secret == "" {
+		if p.Default == ModeHash {
+			return nil, fmt.Errorf("IP hashing requires IP_HASH_SECRET")
+		}
+		for name, mode := range p.Overrides {
+			if mode == ModeHash {
+				return nil, fmt.Errorf("IP hashing for %s sink requires IP_HASH_SECRET", name)
+			}
+		}
+	}
+	return p, nil
+}
+
+// ModeFor returns the mode that applies to the named sink
+func (p *Policy) ModeFor(sinkName string) Mode {
+	if p == nil {
+		return ModeNone
+	}
+	if m, ok := p.Overrides[sinkName]; ok {
+		return m
+	}
+	return p.Default
+}
+
+// Shared returns the mode every sink gets, or false when an override
+// differs from the default
+func (p *Policy) Shared() (Mode, bool) {
+	if p == nil {
+		return ModeNone, true
+	}
+	for _, m := range p.Overrides {
+		if m != p.Default {
+			return "", false
+		}
+	}
+	return p.Default, true
+}
+
+// Apply returns the event with Server.IP anonymized for the named sink.
+// The event is passed by value so each sink can receive a different form.
+func (p *Policy) Apply(sinkName string, ev event.Event) event.Event {
+	ev.Server.IP = p.IP(p.ModeFor(sinkName), ev.Server.IP)
+	return ev
+}
+
+// IP anonymizes a single address with the given mode. A hash is kept as is,
+// so an IP anonymized before the write-ahead log isn't hashed twice.
+func (p *Policy) IP(mode Mode, ip string) string {
+	if ip == "" {
+		return ""
+	}
+	switch mode {
+	case ModeDrop:
+		return ""
+	case ModeTruncate:
+		return truncateIP(ip)
+	case ModeHash:
+		if isHash(ip) {
+			return ip
+		}
+		return p.hashIP(ip)
+	default:
+		return ip
+	}
+}
+
+// hashIP keys the hash with a salt that rotates at midnight UTC, so the same
+// visitor is linkable within a day but not across days
+func (p *Policy) hashIP(ip string) string {
+	mac := hmac.New(sha256.New, p.dailySalt())
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// isHash reports whether ip has the form hashIP produces, which no address has
+func isHash(ip string) bool {
+	if len(ip) != 32 {
+		return false
+	}
+	for _, c := range ip {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Policy) dailySalt() []byte {
+	day := p.now().UTC().Format("2006-01-02")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if day != p.saltDay {
+		mac := hmac.New(sha256.New, []byte(p.secret))
+		mac.Write([]byte(day))
+		p.salt = mac.Sum(nil)
+		p.saltDay = day
+	}
+	return p.salt
+}
+
+// truncateIP zeroes the host part of an address; unparseable input is dropped
+func truncateIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,174 @@
+package privacy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Mode
+		wantErr bool
+	}{
+		{input: "", want: ModeNone},
+		{input: "HASH", want: ModeHash},
+		{input: " truncate ", want: ModeTruncate},
+		{input: "drop", want: ModeDrop},
+		{input: "mask", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseMode(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMode(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMode(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	t.Run("defaults to hash when secret is set", func(t *testing.T) {
+		p, err := NewPolicy("", nil, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Default != ModeHash {
+			t.Errorf("Default = %q, want hash", p.Default)
+		}
+	})
+
+	t.Run("defaults to none without secret", func(t *testing.T) {
+		p, err := NewPolicy("", nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Default != ModeNone {
+			t.Errorf("Default = %q, want none", p.Default)
+		}
+	})
+
+	t.Run("hash requires secret", func(t *testing.T) {
+		if _, err := NewPolicy("hash", nil, ""); err == nil {
+			t.Error("expected error for hash without secret")
+		}
+		if _, err := NewPolicy("drop", []string{"kafka=hash"}, ""); err == nil {
+			t.Error("expected error for hash override without secret")
+		}
+	})
+
+	t.Run("rejects malformed overrides", func(t *testing.T) {
+		for _, o := range []string{"kafka", "=drop", "kafka=blur"} {
+			if _, err := NewPolicy("none", []string{o}, ""); err == nil {
+				t.Errorf("expected error for override %q", o)
+			}
+		}
+	})
+
+	t.Run("overrides apply per sink", func(t *testing.T) {
+		p, err := NewPolicy("truncate", []string{"kafka=drop", " log = none "}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.ModeFor("kafka") != ModeDrop || p.ModeFor("log") != ModeNone || p.ModeFor("postgres") != ModeTruncate {
+			t.Errorf("unexpected modes: %+v", p.Overrides)
+		}
+	})
+}
+
+func TestPolicyIP(t *testing.T) {
+	p, err := NewPolicy("hash", nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return day }
+
+	t.Run("truncate", func(t *testing.T) {
+		tests := map[string]string{
+			"203.0.113.77":        "203.0.113.0",
+			"2001:db8:abcd:12::1": "2001:db8:abcd::",
+			"not-an-ip":           "",
+		}
+		for in, want := range tests {
+			if got := p.IP(ModeTruncate, in); got != want {
+				t.Errorf("truncate(%q) = %q, want %q", in, got, want)
+			}
+		}
+	})
+
+	t.Run("drop and none", func(t *testing.T) {
+		if got := p.IP(ModeDrop, "203.0.113.77"); got != "" {
+			t.Errorf("drop = %q, want empty", got)
+		}
+		if got := p.IP(ModeNone, "203.0.113.77"); got != "203.0.113.77" {
+			t.Errorf("none = %q, want raw IP", got)
+		}
+	})
+
+	t.Run("hash is stable within a day and rotates daily", func(t *testing.T) {
+		first := p.IP(ModeHash, "203.0.113.77")
+		if len(first) != 32 || first == "203.0.113.77" {
+			t.Fatalf("unexpected hash %q", first)
+		}
+		if again := p.IP(ModeHash, "203.0.113.77"); again != first {
+			t.Error("hash should be stable within a day")
+		}
+		if twice := p.IP(ModeHash, first); twice != first {
+			t.Errorf("hashing a hash = %q, want it kept", twice)
+		}
+		if other := p.IP(ModeHash, "203.0.113.78"); other == first {
+			t.Error("different IPs should hash differently")
+		}
+
+		day = day.Add(24 * time.Hour)
+		if next := p.IP(ModeHash, "203.0.113.77"); next == first {
+			t.Error("hash should change when the day rolls over")
+		}
+	})
+
+	t.Run("different secrets produce different hashes", func(t *testing.T) {
+		other, _ := NewPolicy("hash", nil, "other-secret")
+		other.now = p.now
+		if other.IP(ModeHash, "203.0.113.77") == p.IP(ModeHash, "203.0.113.77") {
+			t.Error("expected secret to affect the hash")
+		}
+	})
+}
+
+func TestPolicyShared(t *testing.T) {
+	p, _ := NewPolicy("truncate", []string{"kafka=truncate"}, "")
+	if mode, ok := p.Shared(); !ok || mode != ModeTruncate {
+		t.Errorf("Shared() = %q, %v, want truncate", mode, ok)
+	}
+	p, _ = NewPolicy("truncate", []string{"kafka=none"}, "")
+	if _, ok := p.Shared(); ok {
+		t.Error("Shared() with a differing override should be false")
+	}
+	if mode, ok := (*Policy)(nil).Shared(); !ok || mode != ModeNone {
+		t.Errorf("nil Shared() = %q, %v, want none", mode, ok)
+	}
+}
+
+func TestPolicyApply(t *testing.T) {
+	var p *Policy
+	ev := event.Event{Server: event.ServerMeta{IP: "203.0.113.77"}}
+	if got := p.Apply("log", ev).Server.IP; got != "203.0.113.77" {
+		t.Errorf("nil policy should keep IP, got %q", got)
+	}
+
+	p, _ = NewPolicy("drop", nil, "")
+	out := p.Apply("log", ev)
+	if out.Server.IP != "" {
+		t.Errorf("Apply() IP = %q, want dropped", out.Server.IP)
+	}
+	if ev.Server.IP != "203.0.113.77" {
+		t.Error("Apply() must not modify the caller's event")
+	}
+}
@@ -0,0 +1,288 @@
+// Package proxycache keeps proxied GET responses so repeated requests for
+// static assets are answered without a round trip to FORWARD_DESTINATION.
+//
+// Only responses the origin marks as shareable are kept: they need an
+// explicit lifetime (s-maxage, max-age or Expires) and must not be private,
+// no-store, no-cache or set cookies. Entries live in memory or on disk and
+// the least recently used ones are evicted once the size budget is reached.
+package proxycache
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/metrics"
+)
+
+// Backend names accepted by PROXY_CACHE
+const (
+	BackendMemory = "memory"
+	BackendDisk   = "disk"
+)
+
+// ValidBackend reports whether name is a supported backend
+func ValidBackend(name string) error {
+	switch name {
+	case BackendMemory, BackendDisk:
+		return nil
+	default:
+		return fmt.Errorf("unsupported proxy cache backend %q (want memory or disk)", name)
+	}
+}
+
+// Entry is a stored response
+type Entry struct {
+	Key     string
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Stored  time.Time
+	Expires time.Time
+}
+
+// size approximates the memory an entry holds
+func (e *Entry) size() int64 {
+	n := len(e.Key) + len(e.Body)
+	for k, vs := range e.Header {
+		for _, v := range vs {
+			n += len(k) + len(v)
+		}
+	}
+	return int64(n)
+}
+
+// Store holds entries by key. Implementations must be safe for concurrent
+// use; the Cache tracks sizes and decides what to evict.
+type Store interface {
+	// Get returns the entry for key, or nil when it is missing
+	Get(key string) (*Entry, error)
+	// Put stores e, replacing any entry with the same key
+	Put(e *Entry) error
+	// Delete removes key; deleting a missing key is not an error
+	Delete(key string) error
+	// Load returns entries kept from a previous run
+	Load() ([]*Entry, error)
+}
+
+// Lookup results reported to gotrack_proxy_cache_requests_total
+const (
+	ResultHit    = "hit"
+	ResultMiss   = "miss"
+	ResultBypass = "bypass"
+)
+
+// indexItem is the bookkeeping kept in memory for every stored entry
+type indexItem struct {
+	key     string
+	size    int64
+	expires time.Time
+}
+
+// Cache decides which responses may be stored and serves them back
+type Cache struct {
+	metrics       *metrics.Metrics // optional
+	store         Store
+	maxBytes      int64
+	maxEntryBytes int64
+	now           func() time.Time
+
+	mu    sync.Mutex
+	order *list.List // most recently used at the front
+	items map[string]*list.Element
+	bytes int64
+}
+
+// New creates a cache holding up to maxBytes of responses. A single response
+// may use at most an eighth of the budget. Entries already in store are
+// indexed, and dropped when expired or over budget. m may be nil.
+func New(store Store, maxBytes int64, m *metrics.Metrics) (*Cache, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("proxy cache size must be positive, got %d", maxBytes)
+	}
+	c := &Cache{
+		metrics:       m,
+		store:         store,
+		maxBytes:      maxBytes,
+		maxEntryBytes: maxBytes / 8,
+		now:           time.Now,
+		order:         list.New(),
+		items:         make(map[string]*list.Element),
+	}
+
+	entries, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	// Oldest first, so the most recently stored end up most recently used
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Stored.Before(entries[j].Stored) })
+	now := c.now()
+	for _, e := range entries {
+		if !now.Before(e.Expires) || e.size() > c.maxEntryBytes {
+			_ = store.Delete(e.Key)
+			continue
+		}
+		c.mu.Lock()
+		c.index(e)
+		evicted := c.evictLocked()
+		c.mu.Unlock()
+		c.deleteKeys(evicted)
+	}
+	return c, nil
+}
+
+// Lookup returns the stored response for r. Requests that must not be served
+// from a shared cache are reported as bypassed.
+func (c *Cache) Lookup(r *http.Request) (*Entry, bool) {
+	if !cacheableRequest(r) {
+		c.record(ResultBypass)
+		return nil, false
+	}
+	// no-cache asks for a fresh copy, which then replaces the stored one
+	if _, ok := parseCacheControl(r.Header.Get("Cache-Control"))["no-cache"]; ok {
+		c.record(ResultMiss)
+		return nil, false
+	}
+
+	key := Key(r)
+	c.mu.Lock()
+	el, ok := c.items[key]
+	if ok {
+		if item := el.Value.(*indexItem); !c.now().Before(item.expires) {
+			c.removeLocked(el)
+			c.mu.Unlock()
+			_ = c.store.Delete(key)
+			c.removed("expired", 1)
+			c.record(ResultMiss)
+			return nil, false
+		}
+		c.order.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	if !ok {
+		c.record(ResultMiss)
+		return nil, false
+	}
+
+	e, err := c.store.Get(key)
+	if err != nil {
+		log.Printf("proxy cache: read %q: %v", key, err)
+	}
+	if e == nil {
+		// Gone from the store, e.g. a disk entry removed by hand
+		c.mu.Lock()
+		if el, ok := c.items[key]; ok {
+			c.removeLocked(el)
+		}
+		c.mu.Unlock()
+		c.record(ResultMiss)
+		return nil, false
+	}
+	c.record(ResultHit)
+	return e, true
+}
+
+// Purge removes entries whose path starts with prefix; an empty prefix
+// empties the cache. It returns the number of entries removed.
+func (c *Cache) Purge(prefix string) int {
+	c.mu.Lock()
+	var keys []string
+	for key, el := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+			c.removeLocked(el)
+		}
+	}
+	c.mu.Unlock()
+
+	c.deleteKeys(keys)
+	c.removed("purged", len(keys))
+	return len(keys)
+}
+
+// Len returns the number of stored entries
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+// put stores e, evicting the least recently used entries to stay in budget
+func (c *Cache) put(e *Entry) {
+	if e.size() > c.maxEntryBytes {
+		return
+	}
+	if err := c.store.Put(e); err != nil {
+		log.Printf("proxy cache: store %q: %v", e.Key, err)
+		return
+	}
+	c.mu.Lock()
+	c.index(e)
+	evicted := c.evictLocked()
+	c.mu.Unlock()
+
+	c.deleteKeys(evicted)
+	c.removed("evicted", len(evicted))
+}
+
+// index records e as the most recently used entry; callers hold mu
+func (c *Cache) index(e *Entry) {
+	if el, ok := c.items[e.Key]; ok {
+		c.removeLocked(el)
+	}
+	item := &indexItem{key: e.Key, size: e.size(), expires: e.Expires}
+	c.items[e.Key] = c.order.PushFront(item)
+	c.bytes += item.size
+	c.reportSize()
+}
+
+// evictLocked drops the least recently used entries until the cache fits its
+// budget and returns their keys for deletion from the store
+func (c *Cache) evictLocked() []string {
+	var keys []string
+	for c.bytes > c.maxBytes {
+		el := c.order.Back()
+		keys = append(keys, el.Value.(*indexItem).key)
+		c.removeLocked(el)
+	}
+	return keys
+}
+
+func (c *Cache) removeLocked(el *list.Element) {
+	item := c.order.Remove(el).(*indexItem)
+	delete(c.items, item.key)
+	c.bytes -= item.size
+	c.reportSize()
+}
+
+func (c *Cache) deleteKeys(keys []string) {
+	for _, key := range keys {
+		if err := c.store.Delete(key); err != nil {
+			log.Printf("proxy cache: delete %q: %v", key, err)
+		}
+	}
+}
+
+func (c *Cache) record(result string) {
+	if c.metrics != nil {
+		c.metrics.IncrementProxyCacheRequests(result)
+	}
+}
+
+func (c *Cache) removed(reason string, n int) {
+	if c.metrics != nil && n > 0 {
+		c.metrics.AddProxyCacheRemovals(reason, n)
+	}
+}
+
+// reportSize publishes the entry count and size; callers hold mu
+func (c *Cache) reportSize() {
+	if c.metrics != nil {
+		c.metrics.SetProxyCacheSize(len(c.items), c.bytes)
+	}
+}
@@ -0,0 +1,273 @@
+package proxycache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCache returns a memory-backed cache with a controllable clock
+func newTestCache(t *testing.T, maxBytes int64) (*Cache, *time.Time) {
+	t.Helper()
+	c, err := New(NewMemoryStore(), maxBytes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+// store runs a response through Capture and Save as the proxy does
+func store(t *testing.T, c *Cache, r *http.Request, status int, header http.Header, body string) bool {
+	t.Helper()
+	capture := c.Capture(r, &http.Response{StatusCode: status, ContentLength: -1}, header)
+	if capture == nil {
+		return false
+	}
+	_, _ = capture.Write([]byte(body))
+	capture.Save()
+	return true
+}
+
+func cacheHeader(cacheControl string) http.Header {
+	h := http.Header{}
+	h.Set("Content-Type", "text/css")
+	if cacheControl != "" {
+		h.Set("Cache-Control", cacheControl)
+	}
+	return h
+}
+
+// TestLifetime tests freshness from Cache-Control and Expires
+func TestLifetime(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   time.Duration
+		ok     bool
+	}{
+		{"max-age", map[string]string{"Cache-Control": "public, max-age=60"}, time.Minute, true},
+		{"s-maxage wins", map[string]string{"Cache-Control": "max-age=60, s-maxage=600"}, 10 * time.Minute, true},
+		{"age is subtracted", map[string]string{"Cache-Control": "max-age=60", "Age": "20"}, 40 * time.Second, true},
+		{"stale on arrival", map[string]string{"Cache-Control": "max-age=60", "Age": "90"}, -30 * time.Second, false},
+		{"expires", map[string]string{
+			"Date":    now.Format(http.TimeFormat),
+			"Expires": now.Add(time.Hour).Format(http.TimeFormat),
+		}, time.Hour, true},
+		{"invalid expires", map[string]string{"Expires": "0"}, 0, false},
+		{"private", map[string]string{"Cache-Control": "private, max-age=60"}, 0, false},
+		{"no-store", map[string]string{"Cache-Control": "no-store"}, 0, false},
+		{"no-cache", map[string]string{"Cache-Control": `no-cache="Set-Cookie", max-age=60`}, 0, false},
+		{"no lifetime", map[string]string{"Last-Modified": now.Format(http.TimeFormat)}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.header {
+				h.Set(k, v)
+			}
+			got, ok := lifetime(h, now)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("lifetime() = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// TestCache tests storing, serving and removing responses
+func TestCache(t *testing.T) {
+	t.Run("stores and serves a shareable response", func(t *testing.T) {
+		c, now := newTestCache(t, 1<<20)
+		r := httptest.NewRequest(http.MethodGet, "/app.css?v=2", nil)
+		if !store(t, c, r, http.StatusOK, cacheHeader("max-age=60"), "body{}") {
+			t.Fatal("expected response to be storable")
+		}
+		*now = now.Add(10 * time.Second)
+
+		e, ok := c.Lookup(httptest.NewRequest(http.MethodGet, "/app.css?v=2", nil))
+		if !ok || string(e.Body) != "body{}" {
+			t.Fatalf("Lookup() = %v, %v", e, ok)
+		}
+		w := httptest.NewRecorder()
+		e.Serve(w, r, *now)
+		if w.Code != http.StatusOK || w.Body.String() != "body{}" || w.Header().Get("Age") != "10" {
+			t.Errorf("served %d %q, Age %q", w.Code, w.Body.String(), w.Header().Get("Age"))
+		}
+
+		if _, ok := c.Lookup(httptest.NewRequest(http.MethodGet, "/app.css?v=3", nil)); ok {
+			t.Error("a different query must miss")
+		}
+	})
+
+	t.Run("expires entries", func(t *testing.T) {
+		c, now := newTestCache(t, 1<<20)
+		r := httptest.NewRequest(http.MethodGet, "/a.js", nil)
+		store(t, c, r, http.StatusOK, cacheHeader("max-age=60"), "x")
+		*now = now.Add(time.Minute)
+		if _, ok := c.Lookup(r); ok || c.Len() != 0 {
+			t.Errorf("expired entry served, %d left", c.Len())
+		}
+	})
+
+	t.Run("refuses responses it may not share", func(t *testing.T) {
+		c, _ := newTestCache(t, 1<<20)
+		get := httptest.NewRequest(http.MethodGet, "/a.js", nil)
+		withCookie := cacheHeader("max-age=60")
+		withCookie.Set("Set-Cookie", "sid=1")
+		varyCookie := cacheHeader("max-age=60")
+		varyCookie.Set("Vary", "Accept-Encoding, Cookie")
+		authorized := httptest.NewRequest(http.MethodGet, "/a.js", nil)
+		authorized.Header.Set("Authorization", "Bearer x")
+		ranged := httptest.NewRequest(http.MethodGet, "/a.js", nil)
+		ranged.Header.Set("Range", "bytes=0-1")
+
+		cases := map[string]bool{
+			"set-cookie":     store(t, c, get, http.StatusOK, withCookie, "x"),
+			"vary on cookie": store(t, c, get, http.StatusOK, varyCookie, "x"),
+			"authorization":  store(t, c, authorized, http.StatusOK, cacheHeader("max-age=60"), "x"),
+			"range":          store(t, c, ranged, http.StatusOK, cacheHeader("max-age=60"), "x"),
+			"post":           store(t, c, httptest.NewRequest(http.MethodPost, "/a.js", nil), http.StatusOK, cacheHeader("max-age=60"), "x"),
+			"server error":   store(t, c, get, http.StatusInternalServerError, cacheHeader("max-age=60"), "x"),
+			"no lifetime":    store(t, c, get, http.StatusOK, cacheHeader(""), "x"),
+		}
+		for name, stored := range cases {
+			if stored {
+				t.Errorf("%s: response should not be stored", name)
+			}
+		}
+	})
+
+	t.Run("keys on Accept-Encoding", func(t *testing.T) {
+		c, _ := newTestCache(t, 1<<20)
+		gz := httptest.NewRequest(http.MethodGet, "/a.js", nil)
+		gz.Header.Set("Accept-Encoding", "gzip")
+		h := cacheHeader("max-age=60")
+		h.Set("Vary", "Accept-Encoding")
+		store(t, c, gz, http.StatusOK, h, "compressed")
+		if _, ok := c.Lookup(httptest.NewRequest(http.MethodGet, "/a.js", nil)); ok {
+			t.Error("client without gzip must not get the compressed copy")
+		}
+		if _, ok := c.Lookup(gz); !ok {
+			t.Error("expected hit for the same encoding")
+		}
+	})
+
+	t.Run("bypasses on request directives", func(t *testing.T) {
+		c, _ := newTestCache(t, 1<<20)
+		r := httptest.NewRequest(http.MethodGet, "/a.js", nil)
+		store(t, c, r, http.StatusOK, cacheHeader("max-age=60"), "x")
+
+		for _, directive := range []string{"no-cache", "no-store"} {
+			req := httptest.NewRequest(http.MethodGet, "/a.js", nil)
+			req.Header.Set("Cache-Control", directive)
+			if _, ok := c.Lookup(req); ok {
+				t.Errorf("%s request served from cache", directive)
+			}
+		}
+	})
+
+	t.Run("evicts least recently used", func(t *testing.T) {
+		// Entries here are about 66 bytes, so ten do not fit
+		c, _ := newTestCache(t, 560)
+		body := strings.Repeat("x", 20)
+		for _, path := range []string{"/1", "/2", "/3", "/4", "/5", "/6", "/7", "/8", "/9", "/10"} {
+			store(t, c, httptest.NewRequest(http.MethodGet, path, nil), http.StatusOK, cacheHeader("max-age=60"), body)
+			// Keep /1 in use so /2 is the oldest
+			c.Lookup(httptest.NewRequest(http.MethodGet, "/1", nil))
+		}
+		if _, ok := c.Lookup(httptest.NewRequest(http.MethodGet, "/1", nil)); !ok {
+			t.Error("recently used entry was evicted")
+		}
+		if _, ok := c.Lookup(httptest.NewRequest(http.MethodGet, "/2", nil)); ok {
+			t.Error("least recently used entry was kept")
+		}
+		if c.bytes > c.maxBytes {
+			t.Errorf("cache holds %d bytes, budget %d", c.bytes, c.maxBytes)
+		}
+	})
+
+	t.Run("skips bodies over the entry limit", func(t *testing.T) {
+		c, _ := newTestCache(t, 800)
+		r := httptest.NewRequest(http.MethodGet, "/big.js", nil)
+		store(t, c, r, http.StatusOK, cacheHeader("max-age=60"), strings.Repeat("x", 200))
+		if c.Len() != 0 {
+			t.Error("oversized body was stored")
+		}
+	})
+
+	t.Run("purges by path prefix", func(t *testing.T) {
+		c, _ := newTestCache(t, 1<<20)
+		for _, path := range []string{"/static/a.css", "/static/b.js", "/img/logo.png"} {
+			store(t, c, httptest.NewRequest(http.MethodGet, path, nil), http.StatusOK, cacheHeader("max-age=60"), "x")
+		}
+		if n := c.Purge("/static/"); n != 2 {
+			t.Errorf("Purge(/static/) = %d, want 2", n)
+		}
+		if _, ok := c.Lookup(httptest.NewRequest(http.MethodGet, "/img/logo.png", nil)); !ok {
+			t.Error("entry outside the prefix was purged")
+		}
+		if n := c.Purge(""); n != 1 || c.Len() != 0 {
+			t.Errorf("Purge(\"\") = %d, %d left", n, c.Len())
+		}
+	})
+}
+
+// TestEntryServe tests answers built from stored entries
+func TestEntryServe(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := &Entry{
+		Status:  http.StatusOK,
+		Header:  http.Header{"Etag": {`"v1"`}, "Content-Type": {"text/css"}, "Content-Length": {"6"}},
+		Body:    []byte("body{}"),
+		Stored:  now,
+		Expires: now.Add(time.Minute),
+	}
+
+	t.Run("revalidates with ETag", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/a.css", nil)
+		r.Header.Set("If-None-Match", `"v1"`)
+		w := httptest.NewRecorder()
+		e.Serve(w, r, now)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("status = %d, body = %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("serves ranges", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/a.css", nil)
+		r.Header.Set("Range", "bytes=0-3")
+		w := httptest.NewRecorder()
+		e.Serve(w, r, now)
+		if w.Code != http.StatusPartialContent || w.Body.String() != "body" {
+			t.Errorf("status = %d, body = %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("replays redirects", func(t *testing.T) {
+		redirect := &Entry{Status: http.StatusMovedPermanently, Header: http.Header{"Location": {"/new"}}, Stored: now}
+		w := httptest.NewRecorder()
+		redirect.Serve(w, httptest.NewRequest(http.MethodGet, "/old", nil), now)
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/new" {
+			t.Errorf("status = %d, location = %q", w.Code, w.Header().Get("Location"))
+		}
+	})
+}
+
+// TestValidBackend tests PROXY_CACHE values
+func TestValidBackend(t *testing.T) {
+	for _, name := range []string{BackendMemory, BackendDisk} {
+		if err := ValidBackend(name); err != nil {
+			t.Errorf("ValidBackend(%q) = %v", name, err)
+		}
+	}
+	if err := ValidBackend("redis"); err == nil {
+		t.Error("expected error for unsupported backend")
+	}
+	if _, err := New(NewMemoryStore(), 0, nil); err == nil {
+		t.Error("expected error for a zero size budget")
+	}
+}
@@ -0,0 +1,123 @@
+package proxycache
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// diskSuffix marks cache files so Load skips anything else in the directory
+const diskSuffix = ".entry"
+
+// DiskStore keeps one gob-encoded file per entry in a directory, so the cache
+// survives restarts and can exceed available memory
+type DiskStore struct {
+	dir string
+}
+
+// OpenDiskStore uses dir for entries, creating it when needed
+func OpenDiskStore(dir string) (*DiskStore, error) {
+	if dir == "" {
+		return nil, errors.New("proxy cache directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create proxy cache directory: %w", err)
+	}
+	return &DiskStore{dir: dir}, nil
+}
+
+// path maps key to a file name that is safe whatever the URL contains
+func (s *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+diskSuffix)
+}
+
+// Get reads the entry for key
+func (s *DiskStore) Get(key string) (*Entry, error) {
+	e, err := readEntry(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Guard against a hash collision or a file copied in by hand
+	if e.Key != key {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// Put writes e to a temporary file and renames it into place, so readers
+// never see a partial entry
+func (s *DiskStore) Put(e *Entry) error {
+	f, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(e); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.path(e.Key))
+}
+
+// Delete removes the file for key
+func (s *DiskStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Load reads every entry in the directory. Unreadable files, such as ones
+// left by an older version, are removed.
+func (s *DiskStore) Load() ([]*Entry, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	for _, file := range files {
+		name := filepath.Join(s.dir, file.Name())
+		if strings.HasPrefix(file.Name(), "tmp-") {
+			_ = os.Remove(name)
+			continue
+		}
+		if file.IsDir() || !strings.HasSuffix(file.Name(), diskSuffix) {
+			continue
+		}
+		e, err := readEntry(name)
+		if err != nil || s.path(e.Key) != name {
+			_ = os.Remove(name)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func readEntry(name string) (*Entry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var e Entry
+	if err := gob.NewDecoder(f).Decode(&e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
@@ -0,0 +1,77 @@
+package proxycache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestDiskStore tests entries written to and restored from a directory
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("put, get and delete", func(t *testing.T) {
+		e := &Entry{Key: "/a.css\x00gzip", Status: http.StatusOK, Header: http.Header{"Content-Type": {"text/css"}}, Body: []byte("body{}")}
+		if err := s.Put(e); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.Get(e.Key)
+		if err != nil || got == nil || string(got.Body) != "body{}" || got.Header.Get("Content-Type") != "text/css" {
+			t.Fatalf("Get() = %+v, %v", got, err)
+		}
+		if err := s.Delete(e.Key); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := s.Get(e.Key); got != nil || err != nil {
+			t.Errorf("Get() after Delete = %v, %v", got, err)
+		}
+		if err := s.Delete(e.Key); err != nil {
+			t.Errorf("deleting a missing key: %v", err)
+		}
+	})
+
+	t.Run("cache is restored after a restart", func(t *testing.T) {
+		c, err := New(s, 1<<20, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/logo.svg", nil)
+		if !store(t, c, r, http.StatusOK, cacheHeader("max-age=3600"), "<svg/>") {
+			t.Fatal("expected response to be storable")
+		}
+		expired := httptest.NewRequest(http.MethodGet, "/old.js", nil)
+		_ = s.Put(&Entry{Key: Key(expired), Status: http.StatusOK, Expires: time.Now().Add(-time.Minute)})
+		_ = os.WriteFile(filepath.Join(dir, "garbage"+diskSuffix), []byte("not gob"), 0o600)
+
+		reopened, err := OpenDiskStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err = New(reopened, 1<<20, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Len() != 1 {
+			t.Errorf("restored %d entries, want 1", c.Len())
+		}
+		if e, ok := c.Lookup(r); !ok || string(e.Body) != "<svg/>" {
+			t.Errorf("Lookup() after restart = %v, %v", e, ok)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "garbage"+diskSuffix)); !os.IsNotExist(err) {
+			t.Error("unreadable file should be removed")
+		}
+	})
+
+	t.Run("requires a directory", func(t *testing.T) {
+		if _, err := OpenDiskStore(""); err == nil {
+			t.Error("expected error for empty directory")
+		}
+	})
+}
@@ -0,0 +1,187 @@
+package proxycache
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// storableStatus lists the response codes kept when the origin gives them a
+// lifetime
+var storableStatus = map[int]bool{
+	http.StatusOK:                true,
+	http.StatusMovedPermanently:  true,
+	http.StatusPermanentRedirect: true,
+	http.StatusNotFound:          true,
+	http.StatusGone:              true,
+}
+
+// Key identifies the response to r. The path comes first so Purge can match
+// on it. Accept-Encoding is part of the key because the proxy forwards it and
+// the origin may compress differently per client.
+func Key(r *http.Request) string {
+	return r.URL.RequestURI() + "\x00" + r.Header.Get("Accept-Encoding")
+}
+
+// cacheableRequest reports whether r may be answered from, or stored in, a
+// shared cache
+func cacheableRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet || r.Header.Get("Authorization") != "" {
+		return false
+	}
+	_, noStore := parseCacheControl(r.Header.Get("Cache-Control"))["no-store"]
+	return !noStore
+}
+
+// parseCacheControl splits a Cache-Control header into lower-cased
+// directives and their unquoted values
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+	return directives
+}
+
+// lifetime returns how long a response with header h stays fresh, measured
+// from now. Responses without an explicit lifetime are not stored.
+func lifetime(h http.Header, now time.Time) (time.Duration, bool) {
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[directive]; ok {
+			return 0, false
+		}
+	}
+
+	var ttl time.Duration
+	if v, ok := cc["s-maxage"]; ok {
+		ttl = parseSeconds(v)
+	} else if v, ok := cc["max-age"]; ok {
+		ttl = parseSeconds(v)
+	} else if expires := h.Get("Expires"); expires != "" {
+		at, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, false
+		}
+		date := now
+		if d, err := http.ParseTime(h.Get("Date")); err == nil {
+			date = d
+		}
+		ttl = at.Sub(date)
+	}
+	// Time already spent in caches upstream counts against the lifetime
+	if age := h.Get("Age"); age != "" {
+		ttl -= parseSeconds(age)
+	}
+	return ttl, ttl > 0
+}
+
+func parseSeconds(v string) time.Duration {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// storableVary reports whether the response varies only on headers in Key
+func storableVary(h http.Header) bool {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" && !strings.EqualFold(name, "Accept-Encoding") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Capture buffers a response body while it is streamed to the client, and
+// stores it once complete
+type Capture struct {
+	cache *Cache
+	entry *Entry
+	buf   bytes.Buffer
+	full  bool
+}
+
+// Capture returns a recorder for resp, the origin's answer to r, or nil when
+// the response may not be stored. header is the response header as sent to
+// the client, without hop-by-hop fields.
+func (c *Cache) Capture(r *http.Request, resp *http.Response, header http.Header) *Capture {
+	if !cacheableRequest(r) || r.Header.Get("Range") != "" || !storableStatus[resp.StatusCode] {
+		return nil
+	}
+	if header.Get("Set-Cookie") != "" || !storableVary(header) {
+		return nil
+	}
+	if resp.ContentLength > c.maxEntryBytes {
+		return nil
+	}
+	now := c.now()
+	ttl, ok := lifetime(header, now)
+	if !ok {
+		return nil
+	}
+	return &Capture{
+		cache: c,
+		entry: &Entry{
+			Key:     Key(r),
+			Status:  resp.StatusCode,
+			Header:  header.Clone(),
+			Stored:  now,
+			Expires: now.Add(ttl),
+		},
+	}
+}
+
+// Write buffers p, giving up once the body outgrows the entry size limit
+func (c *Capture) Write(p []byte) (int, error) {
+	if !c.full {
+		if int64(c.buf.Len()+len(p)) > c.cache.maxEntryBytes {
+			c.full = true
+			c.buf = bytes.Buffer{}
+		} else {
+			c.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// Save stores the captured response. Call it only after the whole body was
+// copied.
+func (c *Capture) Save() {
+	if c.full {
+		return
+	}
+	c.entry.Body = c.buf.Bytes()
+	c.cache.put(c.entry)
+}
+
+// Serve writes e as the answer to r. Conditional and range requests for
+// 200 responses are handled against the stored body.
+func (e *Entry) Serve(w http.ResponseWriter, r *http.Request, now time.Time) {
+	h := w.Header()
+	for k, vs := range e.Header {
+		h[k] = append([]string(nil), vs...)
+	}
+	h.Set("Age", strconv.FormatInt(int64(now.Sub(e.Stored)/time.Second), 10))
+
+	if e.Status != http.StatusOK {
+		h.Set("Content-Length", strconv.Itoa(len(e.Body)))
+		w.WriteHeader(e.Status)
+		_, _ = w.Write(e.Body)
+		return
+	}
+	// ServeContent sets the length itself, and a 304 must not carry one
+	h.Del("Content-Length")
+	modified, _ := http.ParseTime(e.Header.Get("Last-Modified"))
+	http.ServeContent(w, r, "", modified, bytes.NewReader(e.Body))
+}
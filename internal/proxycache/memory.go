@@ -0,0 +1,44 @@
+package proxycache
+
+import "sync"
+
+// MemoryStore keeps entries in process memory. They are lost on restart and
+// not shared between replicas.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries map[string]*Entry
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*Entry)}
+}
+
+// Get returns the entry for key. Entries are never modified once stored, so
+// callers share them.
+func (s *MemoryStore) Get(key string) (*Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entries[key], nil
+}
+
+// Put stores e
+func (s *MemoryStore) Put(e *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[e.Key] = e
+	return nil
+}
+
+// Delete removes key
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// Load returns nothing; memory does not outlive the process
+func (s *MemoryStore) Load() ([]*Entry, error) {
+	return nil, nil
+}
@@ -0,0 +1,187 @@
+// Package region applies data-handling policies by the visitor's resolved
+// country, such as truncating IPs, dropping click IDs and skipping ad
+// platforms for EEA traffic. Policies are declared in a JSON file.
+package region
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/shortontech/gotrack/internal/consent"
+	"github.com/shortontech/gotrack/internal/privacy"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Click ID handling of a rule
+const (
+	ClickIDsKeep               = "keep"
+	ClickIDsDrop               = "drop"
+	ClickIDsDropWithoutConsent = "drop_without_consent"
+)
+
+// Unknown matches events without a resolved country
+const Unknown = "unknown"
+
+// groups are country groups a rule may list by name
+var groups = map[string][]string{
+	"EU": {"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU", "IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK"},
+	"EEA": {"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU", "IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK",
+		"IS", "LI", "NO"},
+}
+
+// Rule is one policy as declared in the file
+type Rule struct {
+	Name      string   `json:"name"`
+	Countries []string `json:"countries"`            // ISO 3166-1 alpha-2 codes, EU, EEA or unknown
+	IP        string   `json:"ip,omitempty"`         // privacy mode replacing IP_PRIVACY_MODE and the sink overrides
+	ClickIDs  string   `json:"click_ids,omitempty"`  // keep, drop or drop_without_consent
+	SkipSinks []string `json:"skip_sinks,omitempty"` // sinks that don't receive the events
+}
+
+// Load reads a JSON array of rules from path and checks them
+func Load(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read region policy file: %w", err)
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse region policy file: %w", err)
+	}
+	if _, err := New(rules, true, false); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Policy is a compiled rule
+type Policy struct {
+	Name      string
+	countries map[string]bool
+	ip        privacy.Mode // empty keeps the sinks' modes
+	clickIDs  string
+	skip      map[string]bool
+}
+
+// Policies holds the rules in file order; the first one covering an
+// event's country applies
+type Policies struct {
+	list            []*Policy
+	consentEnforced bool
+}
+
+// New compiles rules. canHash says whether IP_HASH_SECRET is set. Without
+// consentEnforced, TCF_ACTION being off, clients' consent flags are not
+// trusted and drop_without_consent drops every click ID.
+func New(rules []Rule, canHash, consentEnforced bool) (*Policies, error) {
+	ps := &Policies{consentEnforced: consentEnforced}
+	for i, r := range rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i)
+		}
+		if len(r.Countries) == 0 {
+			return nil, fmt.Errorf("region policy %s: countries is empty", name)
+		}
+		p := &Policy{Name: name, countries: make(map[string]bool), clickIDs: r.ClickIDs}
+		for _, c := range r.Countries {
+			c = strings.ToUpper(strings.TrimSpace(c))
+			switch {
+			case groups[c] != nil:
+				for _, member := range groups[c] {
+					p.countries[member] = true
+				}
+			case c == strings.ToUpper(Unknown):
+				p.countries[""] = true
+			case len(c) == 2 && c[0] >= 'A' && c[0] <= 'Z' && c[1] >= 'A' && c[1] <= 'Z':
+				p.countries[c] = true
+			default:
+				return nil, fmt.Errorf("region policy %s: invalid country %q (want a two-letter code, EU, EEA or unknown)", name, c)
+			}
+		}
+		if r.IP != "" {
+			mode, err := privacy.ParseMode(r.IP)
+			if err != nil {
+				return nil, fmt.Errorf("region policy %s: %w", name, err)
+			}
+			if mode == privacy.ModeHash && !canHash {
+				return nil, fmt.Errorf("region policy %s: IP hashing requires IP_HASH_SECRET", name)
+			}
+			p.ip = mode
+		}
+		switch r.ClickIDs {
+		case "":
+			p.clickIDs = ClickIDsKeep
+		case ClickIDsKeep, ClickIDsDrop, ClickIDsDropWithoutConsent:
+		default:
+			return nil, fmt.Errorf("region policy %s: unknown click_ids %q (want keep, drop or drop_without_consent)", name, r.ClickIDs)
+		}
+		if len(r.SkipSinks) > 0 {
+			p.skip = make(map[string]bool, len(r.SkipSinks))
+			for _, s := range r.SkipSinks {
+				p.skip[s] = true
+			}
+		}
+		ps.list = append(ps.list, p)
+	}
+	return ps, nil
+}
+
+// Apply returns the event with its region's click ID handling applied and
+// the policy that covers it, or nil
+func (ps *Policies) Apply(ev event.Event) (event.Event, *Policy) {
+	if ps == nil {
+		return ev, nil
+	}
+	country := strings.ToUpper(ev.Server.Geo["country"])
+	i := slices.IndexFunc(ps.list, func(p *Policy) bool { return p.countries[country] })
+	if i < 0 {
+		return ev, nil
+	}
+	p := ps.list[i]
+	switch p.clickIDs {
+	case ClickIDsDrop:
+		ev = withoutClickIDs(ev)
+	case ClickIDsDropWithoutConsent:
+		if !ps.consentEnforced || !consent.Granted(&ev) {
+			ev = withoutClickIDs(ev)
+		}
+	}
+	return ev, p
+}
+
+// withoutClickIDs clears the click IDs of a copy of ev, leaving the
+// caller's route query untouched
+func withoutClickIDs(ev event.Event) event.Event {
+	ev.Route.Query = maps.Clone(ev.Route.Query)
+	event.ClearClickIDs(&ev)
+	return ev
+}
+
+// Skips reports whether the policy keeps its events from the named sink
+func (p *Policy) Skips(sinkName string) bool {
+	return p != nil && p.skip[sinkName]
+}
+
+// IPMode returns the policy's IP privacy mode, or "" when the sinks' modes
+// apply
+func (p *Policy) IPMode() privacy.Mode {
+	if p == nil {
+		return ""
+	}
+	return p.ip
+}
+
+// ApplyIP anonymizes the event's IP for the named sink: with the policy's
+// mode when it has one, else with the IP privacy policy's
+func (p *Policy) ApplyIP(ip *privacy.Policy, sinkName string, ev event.Event) event.Event {
+	if p == nil || p.ip == "" {
+		return ip.Apply(sinkName, ev)
+	}
+	ev.Server.IP = ip.IP(p.ip, ev.Server.IP)
+	return ev
+}
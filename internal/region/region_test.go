@@ -0,0 +1,174 @@
+package region
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/privacy"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func geoEvent(country string) event.Event {
+	ev := event.Event{}
+	ev.Server.IP = "203.0.113.7"
+	if country != "" {
+		ev.Server.Geo = map[string]string{"country": country}
+	}
+	ev.URL.Google.GCLID = "gclid-1"
+	ev.Route.Query = map[string]string{"gclid": "gclid-1", "plan": "pro"}
+	return ev
+}
+
+func TestApply(t *testing.T) {
+	ps, err := New([]Rule{
+		{Name: "eea", Countries: []string{"eea", "CH"}, ClickIDs: ClickIDsDrop, SkipSinks: []string{"google_ads"}},
+		{Name: "rest-of-eu", Countries: []string{"EU"}},
+		{Name: "unresolved", Countries: []string{"unknown"}, IP: "drop"},
+	}, false, false)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		country string
+		want    string
+	}{
+		{"DE", "eea"},
+		{"no", "eea"}, // EEA outside the EU
+		{"CH", "eea"},
+		{"US", ""},
+		{"", "unresolved"},
+	}
+	for _, tt := range tests {
+		_, p := ps.Apply(geoEvent(tt.country))
+		got := ""
+		if p != nil {
+			got = p.Name
+		}
+		if got != tt.want {
+			t.Errorf("Apply(%q) policy = %q, want %q", tt.country, got, tt.want)
+		}
+	}
+
+	in := geoEvent("FR")
+	out, p := ps.Apply(in)
+	if out.URL.Google.GCLID != "" || out.Route.Query["gclid"] != "" || out.Route.Query["plan"] != "pro" {
+		t.Errorf("click IDs not dropped: %+v", out.URL.Google)
+	}
+	if in.Route.Query["gclid"] == "" {
+		t.Error("Apply() modified the caller's route query")
+	}
+	if !p.Skips("google_ads") || p.Skips("kafka") {
+		t.Errorf("Skips(google_ads) = %v, Skips(kafka) = %v", p.Skips("google_ads"), p.Skips("kafka"))
+	}
+
+	var none *Policies
+	if out, p := none.Apply(in); p != nil || out.URL.Google.GCLID == "" {
+		t.Errorf("nil Policies changed the event: %+v, %v", out.URL.Google, p)
+	}
+}
+
+func TestApply_DropWithoutConsent(t *testing.T) {
+	rules := []Rule{{Countries: []string{"EEA"}, ClickIDs: ClickIDsDropWithoutConsent}}
+	granted, denied := true, false
+
+	enforced, err := New(rules, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unenforced, err := New(rules, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		ps       *Policies
+		consent  *event.ConsentInfo
+		wantKept bool
+	}{
+		{"granted", enforced, &event.ConsentInfo{Measurement: &granted}, true},
+		{"denied", enforced, &event.ConsentInfo{Measurement: &denied}, false},
+		{"no consent", enforced, nil, false},
+		{"client claim without TCF_ACTION", unenforced, &event.ConsentInfo{Measurement: &granted}, false},
+	}
+	for _, tt := range tests {
+		ev := geoEvent("IT")
+		ev.Consent = tt.consent
+		out, _ := tt.ps.Apply(ev)
+		if kept := out.URL.Google.GCLID != ""; kept != tt.wantKept {
+			t.Errorf("%s: click ID kept = %v, want %v", tt.name, kept, tt.wantKept)
+		}
+	}
+}
+
+func TestApplyIP(t *testing.T) {
+	ip, err := privacy.NewPolicy("none", []string{"kafka=drop"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, err := New([]Rule{{Countries: []string{"DE"}, IP: "truncate"}}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev, p := ps.Apply(geoEvent("DE"))
+	for _, sinkName := range []string{"log", "kafka"} {
+		if got := p.ApplyIP(ip, sinkName, ev).Server.IP; got != "203.0.113.0" {
+			t.Errorf("%s sink IP = %q, want the region's truncation", sinkName, got)
+		}
+	}
+
+	ev, p = ps.Apply(geoEvent("US"))
+	if got := p.ApplyIP(ip, "kafka", ev).Server.IP; got != "" {
+		t.Errorf("kafka sink IP outside the region = %q, want the sink's drop", got)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		canHash bool
+	}{
+		{"no countries", Rule{}, false},
+		{"bad country", Rule{Countries: []string{"Germany"}}, false},
+		{"bad ip mode", Rule{Countries: []string{"DE"}, IP: "mask"}, false},
+		{"hash without secret", Rule{Countries: []string{"DE"}, IP: "hash"}, false},
+		{"bad click_ids", Rule{Countries: []string{"DE"}, ClickIDs: "strip"}, false},
+	}
+	for _, tt := range tests {
+		if _, err := New([]Rule{tt.rule}, tt.canHash, false); err == nil {
+			t.Errorf("%s: New() succeeded, want error", tt.name)
+		}
+	}
+	if _, err := New([]Rule{{Countries: []string{"DE"}, IP: "hash"}}, true, false); err != nil {
+		t.Errorf("hash with secret: %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "regions.json")
+	if err := os.WriteFile(path, []byte(`[{"name":"eea","countries":["EEA"],"ip":"truncate","click_ids":"drop_without_consent","skip_sinks":["meta_capi","google_ads"]}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(rules) != 1 || rules[0].Name != "eea" || len(rules[0].SkipSinks) != 2 {
+		t.Errorf("Load() = %+v", rules)
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"countries":["EEA"],"click_ids":"never"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load() accepted an invalid rule")
+	}
+	if _, err := Load(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Load() accepted a missing file")
+	}
+}
@@ -0,0 +1,172 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Errors returned when a chunk cannot be accepted
+var (
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrInvalidChunk     = errors.New("invalid chunk")
+	ErrTooManyBatches   = errors.New("too many in-flight batches")
+)
+
+// Chunk is a single piece of a relayed batch
+type Chunk struct {
+	BatchID     string
+	BatchSHA256 string
+	Compression string
+	Index       int
+	Count       int
+	SHA256      string
+	Data        []byte
+}
+
+// Status describes how much of a batch the receiver holds
+type Status struct {
+	BatchID  string `json:"batch_id"`
+	Received []int  `json:"received"`
+	Count    int    `json:"count"`
+	Complete bool   `json:"complete"`
+}
+
+type partialBatch struct {
+	sum         string
+	compression string
+	chunks      [][]byte
+	received    int
+	updated     time.Time
+}
+
+// Assembler reassembles chunked batches on the receiving side. Partial
+// batches survive sender retries so transfers can resume where they stopped,
+// and completed batch IDs are remembered so retransmits are not re-emitted.
+type Assembler struct {
+	mu         sync.Mutex
+	partial    map[string]*partialBatch
+	completed  map[string]time.Time
+	ttl        time.Duration
+	maxPartial int
+}
+
+// NewAssembler creates an assembler that forgets batches idle for longer than ttl
+func NewAssembler(ttl time.Duration, maxPartial int) *Assembler {
+	return &Assembler{
+		partial:    make(map[string]*partialBatch),
+		completed:  make(map[string]time.Time),
+		ttl:        ttl,
+		maxPartial: maxPartial,
+	}
+}
+
+// Add stores a chunk. When the final chunk arrives and the batch checksum
+// verifies, the full compressed payload is returned.
+func (a *Assembler) Add(c Chunk) ([]byte, Status, error) {
+	if c.BatchID == "" || c.Count <= 0 || c.Index < 0 || c.Index >= c.Count {
+		return nil, Status{}, ErrInvalidChunk
+	}
+	if Checksum(c.Data) != c.SHA256 {
+		return nil, Status{}, fmt.Errorf("chunk %d: %w", c.Index, ErrChecksumMismatch)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	a.evictExpired(now)
+
+	if _, done := a.completed[c.BatchID]; done {
+		return nil, completeStatus(c.BatchID, c.Count), nil
+	}
+
+	pb, ok := a.partial[c.BatchID]
+	if !ok {
+		if a.maxPartial > 0 && len(a.partial) >= a.maxPartial {
+			return nil, Status{}, ErrTooManyBatches
+		}
+		pb = &partialBatch{
+			sum:         c.BatchSHA256,
+			compression: c.Compression,
+			chunks:      make([][]byte, c.Count),
+		}
+		a.partial[c.BatchID] = pb
+	}
+	if len(pb.chunks) != c.Count || pb.sum != c.BatchSHA256 {
+		return nil, Status{}, fmt.Errorf("chunk %d does not match batch %s: %w", c.Index, c.BatchID, ErrInvalidChunk)
+	}
+
+	pb.updated = now
+	if pb.chunks[c.Index] == nil {
+		pb.chunks[c.Index] = append([]byte(nil), c.Data...)
+		pb.received++
+	}
+
+	if pb.received < c.Count {
+		return nil, pb.status(c.BatchID), nil
+	}
+
+	// All chunks present: verify end-to-end integrity before releasing
+	var size int
+	for _, chunk := range pb.chunks {
+		size += len(chunk)
+	}
+	payload := make([]byte, 0, size)
+	for _, chunk := range pb.chunks {
+		payload = append(payload, chunk...)
+	}
+	delete(a.partial, c.BatchID)
+	if Checksum(payload) != pb.sum {
+		return nil, Status{}, fmt.Errorf("batch %s: %w", c.BatchID, ErrChecksumMismatch)
+	}
+	a.completed[c.BatchID] = now
+	return payload, completeStatus(c.BatchID, c.Count), nil
+}
+
+// Status reports which chunks of a batch have been received
+func (a *Assembler) Status(batchID string) Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, done := a.completed[batchID]; done {
+		return Status{BatchID: batchID, Received: []int{}, Complete: true}
+	}
+	if pb, ok := a.partial[batchID]; ok {
+		return pb.status(batchID)
+	}
+	return Status{BatchID: batchID, Received: []int{}}
+}
+
+// evictExpired drops idle partial batches and old completion markers (must be called with mutex held)
+func (a *Assembler) evictExpired(now time.Time) {
+	for id, pb := range a.partial {
+		if now.Sub(pb.updated) > a.ttl {
+			delete(a.partial, id)
+		}
+	}
+	for id, ts := range a.completed {
+		if now.Sub(ts) > a.ttl {
+			delete(a.completed, id)
+		}
+	}
+}
+
+func (pb *partialBatch) status(batchID string) Status {
+	received := make([]int, 0, pb.received)
+	for i, chunk := range pb.chunks {
+		if chunk != nil {
+			received = append(received, i)
+		}
+	}
+	return Status{BatchID: batchID, Received: received, Count: len(pb.chunks)}
+}
+
+func completeStatus(batchID string, count int) Status {
+	received := make([]int, count)
+	for i := range received {
+		received[i] = i
+	}
+	return Status{BatchID: batchID, Received: received, Count: count, Complete: true}
+}
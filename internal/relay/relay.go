@@ -0,0 +1,171 @@
+// Package relay implements the wire format used to forward events from an
+// edge GoTrack instance to a central one. Events are encoded as NDJSON,
+// compressed as a whole, and split into checksummed chunks so that a batch
+// can be resumed after a partial transfer over a constrained link.
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// HTTP headers describing a relayed chunk
+const (
+	HeaderBatchID     = "X-GoTrack-Batch-ID"
+	HeaderBatchSHA256 = "X-GoTrack-Batch-SHA256"
+	HeaderChunkIndex  = "X-GoTrack-Chunk-Index"
+	HeaderChunkCount  = "X-GoTrack-Chunk-Count"
+	HeaderChunkSHA256 = "X-GoTrack-Chunk-SHA256"
+)
+
+// Supported batch compression algorithms (sent as Content-Encoding)
+const (
+	CompressionNone = "identity"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// maxDecodedBytes bounds decompression to guard against compression bombs
+const maxDecodedBytes = 256 << 20
+
+// ValidCompression reports whether the algorithm is supported
+func ValidCompression(c string) bool {
+	switch c {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return true
+	}
+	return false
+}
+
+// Encode serializes events as NDJSON and compresses the result
+func Encode(events []event.Event, compression string) ([]byte, error) {
+	var raw bytes.Buffer
+	enc := json.NewEncoder(&raw)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return nil, fmt.Errorf("failed to encode event: %w", err)
+		}
+	}
+	return compress(raw.Bytes(), compression)
+}
+
+// Decode decompresses a batch payload and parses its NDJSON events
+func Decode(payload []byte, compression string) ([]event.Event, error) {
+	raw, err := decompress(payload, compression)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []event.Event
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e event.Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("invalid event in batch: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read batch: %w", err)
+	}
+	return events, nil
+}
+
+// Split divides a payload into chunks of at most size bytes
+func Split(payload []byte, size int) [][]byte {
+	if size <= 0 || len(payload) <= size {
+		return [][]byte{payload}
+	}
+	chunks := make([][]byte, 0, (len(payload)+size-1)/size)
+	for start := 0; start < len(payload); start += size {
+		end := start + size
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunks = append(chunks, payload[start:end])
+	}
+	return chunks
+}
+
+// Checksum returns the hex-encoded SHA-256 of data
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func compress(data []byte, compression string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch compression {
+	case CompressionNone, "":
+		return data, nil
+	case CompressionGzip:
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to gzip batch: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("failed to gzip batch: %w", err)
+		}
+	case CompressionZstd:
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to zstd batch: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("failed to zstd batch: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", compression)
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(data []byte, compression string) ([]byte, error) {
+	var r io.Reader
+	switch compression {
+	case CompressionNone, "":
+		return data, nil
+	case CompressionGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip batch: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	case CompressionZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read zstd batch: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", compression)
+	}
+
+	out, err := io.ReadAll(io.LimitReader(r, maxDecodedBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress batch: %w", err)
+	}
+	if len(out) > maxDecodedBytes {
+		return nil, fmt.Errorf("decompressed batch exceeds %d bytes", maxDecodedBytes)
+	}
+	return out, nil
+}
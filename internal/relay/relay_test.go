@@ -0,0 +1,164 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func sampleEvents(n int) []event.Event {
+	events := make([]event.Event, n)
+	for i := range events {
+		events[i] = event.Event{
+			EventID: "evt-" + string(rune('a'+i%26)),
+			Type:    "pageview",
+			Route:   event.RouteInfo{Path: "/products/some/long/path/for/compression"},
+		}
+	}
+	return events
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, compression := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			events := sampleEvents(50)
+
+			payload, err := Encode(events, compression)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			decoded, err := Decode(payload, compression)
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if len(decoded) != len(events) {
+				t.Fatalf("expected %d events, got %d", len(events), len(decoded))
+			}
+			if decoded[3].EventID != events[3].EventID || decoded[3].Route.Path != events[3].Route.Path {
+				t.Errorf("event mismatch after roundtrip: %+v", decoded[3])
+			}
+		})
+	}
+
+	t.Run("compression shrinks repetitive batches", func(t *testing.T) {
+		raw, _ := Encode(sampleEvents(100), CompressionNone)
+		zst, _ := Encode(sampleEvents(100), CompressionZstd)
+		if len(zst) >= len(raw)/2 {
+			t.Errorf("expected zstd payload (%d) to be much smaller than raw (%d)", len(zst), len(raw))
+		}
+	})
+
+	t.Run("unsupported compression", func(t *testing.T) {
+		if _, err := Encode(sampleEvents(1), "lz4"); err == nil {
+			t.Error("expected error for unsupported compression")
+		}
+	})
+}
+
+func TestSplit(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 10)
+
+	chunks := Split(payload, 4)
+	if len(chunks) != 3 || len(chunks[2]) != 2 {
+		t.Fatalf("unexpected chunking: %d chunks", len(chunks))
+	}
+	if !bytes.Equal(bytes.Join(chunks, nil), payload) {
+		t.Error("chunks should reassemble to original payload")
+	}
+	if len(Split(payload, 0)) != 1 {
+		t.Error("non-positive size should produce a single chunk")
+	}
+}
+
+func chunksFor(t *testing.T, batchID string, size int) ([]byte, []Chunk) {
+	t.Helper()
+	payload, err := Encode(sampleEvents(20), CompressionGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := Split(payload, size)
+	chunks := make([]Chunk, len(parts))
+	for i, part := range parts {
+		chunks[i] = Chunk{
+			BatchID:     batchID,
+			BatchSHA256: Checksum(payload),
+			Compression: CompressionGzip,
+			Index:       i,
+			Count:       len(parts),
+			SHA256:      Checksum(part),
+			Data:        part,
+		}
+	}
+	return payload, chunks
+}
+
+func TestAssembler(t *testing.T) {
+	t.Run("reassembles out of order chunks", func(t *testing.T) {
+		a := NewAssembler(time.Minute, 10)
+		payload, chunks := chunksFor(t, "b1", 64)
+
+		var got []byte
+		for i := len(chunks) - 1; i >= 0; i-- {
+			out, status, err := a.Add(chunks[i])
+			if err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+			if i > 0 && (out != nil || status.Complete) {
+				t.Fatal("batch should not complete before all chunks arrive")
+			}
+			got = out
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("reassembled payload differs from original")
+		}
+	})
+
+	t.Run("reports received chunks for resume", func(t *testing.T) {
+		a := NewAssembler(time.Minute, 10)
+		_, chunks := chunksFor(t, "b2", 64)
+		_, _, _ = a.Add(chunks[0])
+		_, _, _ = a.Add(chunks[2])
+
+		status := a.Status("b2")
+		if status.Complete || len(status.Received) != 2 || status.Received[1] != 2 {
+			t.Errorf("unexpected status: %+v", status)
+		}
+	})
+
+	t.Run("rejects corrupted chunk", func(t *testing.T) {
+		a := NewAssembler(time.Minute, 10)
+		_, chunks := chunksFor(t, "b3", 64)
+		bad := chunks[0]
+		bad.Data = append([]byte("corrupt"), bad.Data...)
+
+		if _, _, err := a.Add(bad); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("expected ErrChecksumMismatch, got %v", err)
+		}
+	})
+
+	t.Run("duplicate batch is not released twice", func(t *testing.T) {
+		a := NewAssembler(time.Minute, 10)
+		_, chunks := chunksFor(t, "b4", 1<<20)
+		if out, _, _ := a.Add(chunks[0]); out == nil {
+			t.Fatal("expected single-chunk batch to complete")
+		}
+		out, status, err := a.Add(chunks[0])
+		if err != nil || out != nil || !status.Complete {
+			t.Errorf("retransmit should be acknowledged without payload: out=%v status=%+v err=%v", out != nil, status, err)
+		}
+	})
+
+	t.Run("limits in-flight batches", func(t *testing.T) {
+		a := NewAssembler(time.Minute, 1)
+		_, first := chunksFor(t, "b5", 64)
+		_, second := chunksFor(t, "b6", 64)
+		_, _, _ = a.Add(first[0])
+
+		if _, _, err := a.Add(second[0]); !errors.Is(err, ErrTooManyBatches) {
+			t.Errorf("expected ErrTooManyBatches, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,201 @@
+// Package routing decides which sinks receive an event. Rules are declared
+// per sink in OUTPUT_RULES; sinks without rules receive every event.
+package routing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Fields a condition can test
+const (
+	FieldType      = "type"
+	FieldUTMSource = "utm_source"
+	FieldSiteID    = "site_id"
+	FieldBotScore  = "bot_score"
+	FieldIPClass   = "ip_class"
+)
+
+// Condition tests one event field. String fields compare case-insensitively
+// against any of Values with = or !=; bot_score compares numerically.
+type Condition struct {
+	Field  string
+	Op     string // =, !=, <, <=, >, >=
+	Values []string
+	number int // parsed bot_score operand
+}
+
+// Rule sends a sink the events matching all of its conditions
+type Rule struct {
+	Sink       string
+	Conditions []Condition
+}
+
+// Parse reads rules separated by semicolons. Each rule is a sink name, a
+// colon and space-separated conditions, for example
+//
+//	kafka: type=click,pageview; postgres: type=purchase bot_score<50
+//
+// A sink listed in several rules receives events matching any of them.
+func Parse(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		sinkName, conds, ok := strings.Cut(part, ":")
+		sinkName = strings.TrimSpace(sinkName)
+		if !ok || sinkName == "" {
+			return nil, fmt.Errorf("invalid output rule %q (want sink: conditions)", part)
+		}
+		rule := Rule{Sink: sinkName}
+		for _, c := range strings.Fields(conds) {
+			cond, err := parseCondition(c)
+			if err != nil {
+				return nil, fmt.Errorf("output rule for %s: %w", sinkName, err)
+			}
+			rule.Conditions = append(rule.Conditions, cond)
+		}
+		if len(rule.Conditions) == 0 {
+			return nil, fmt.Errorf("output rule for %s has no conditions", sinkName)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseCondition(s string) (Condition, error) {
+	i := strings.IndexAny(s, "!=<>")
+	if i <= 0 {
+		return Condition{}, fmt.Errorf("invalid condition %q (want field=value)", s)
+	}
+	c := Condition{Field: s[:i]}
+	rest := s[i:]
+	switch {
+	case strings.HasPrefix(rest, "!="), strings.HasPrefix(rest, "<="), strings.HasPrefix(rest, ">="):
+		c.Op = rest[:2]
+	case rest[0] == '!':
+		return Condition{}, fmt.Errorf("invalid condition %q (want field=value)", s)
+	default:
+		c.Op = rest[:1]
+	}
+	value := rest[len(c.Op):]
+
+	switch c.Field {
+	case FieldType, FieldUTMSource, FieldSiteID, FieldIPClass:
+		if c.Op != "=" && c.Op != "!=" {
+			return Condition{}, fmt.Errorf("%s only supports = and !=", c.Field)
+		}
+		c.Values = strings.Split(value, ",")
+	case FieldBotScore:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > 100 {
+			return Condition{}, fmt.Errorf("bot_score %q must be a number from 0 to 100", value)
+		}
+		c.Values, c.number = []string{value}, n
+	default:
+		return Condition{}, fmt.Errorf("unknown field %q (want type, utm_source, site_id, ip_class or bot_score)", c.Field)
+	}
+	return c, nil
+}
+
+// Match reports whether e satisfies the condition
+func (c Condition) Match(e event.Event) bool {
+	if c.Field == FieldBotScore {
+		score := e.Server.Detection.BotScore()
+		switch c.Op {
+		case "=":
+			return score == c.number
+		case "!=":
+			return score != c.number
+		case "<":
+			return score < c.number
+		case "<=":
+			return score <= c.number
+		case ">":
+			return score > c.number
+		default:
+			return score >= c.number
+		}
+	}
+
+	var got string
+	switch c.Field {
+	case FieldType:
+		got = e.Type
+	case FieldUTMSource:
+		got = e.URL.UTM.Source
+	case FieldSiteID:
+		got = e.SiteID
+	case FieldIPClass:
+		got = e.Server.Detection.IPClass
+	}
+	in := false
+	for _, v := range c.Values {
+		if strings.EqualFold(got, v) {
+			in = true
+			break
+		}
+	}
+	return in == (c.Op == "=")
+}
+
+// Match reports whether e satisfies every condition of the rule
+func (r Rule) Match(e event.Event) bool {
+	for _, c := range r.Conditions {
+		if !c.Match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// Router holds the rules of each sink. It is safe for concurrent use and
+// its rules can be replaced while serving traffic.
+type Router struct {
+	mu     sync.RWMutex
+	bySink map[string][]Rule
+}
+
+// NewRouter creates a router from parsed rules
+func NewRouter(rules []Rule) *Router {
+	r := &Router{}
+	r.Update(rules)
+	return r
+}
+
+// Update replaces all rules
+func (r *Router) Update(rules []Rule) {
+	bySink := make(map[string][]Rule)
+	for _, rule := range rules {
+		bySink[rule.Sink] = append(bySink[rule.Sink], rule)
+	}
+	r.mu.Lock()
+	r.bySink = bySink
+	r.mu.Unlock()
+}
+
+// Allows reports whether sink should receive e. A nil router and sinks
+// without rules allow every event.
+func (r *Router) Allows(sink string, e event.Event) bool {
+	if r == nil {
+		return true
+	}
+	r.mu.RLock()
+	rules := r.bySink[sink]
+	r.mu.RUnlock()
+	if len(rules) == 0 {
+		return true
+	}
+	for _, rule := range rules {
+		if rule.Match(e) {
+			return true
+		}
+	}
+	return false
+}
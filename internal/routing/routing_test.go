@@ -0,0 +1,91 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestParse(t *testing.T) {
+	rules, err := Parse(" kafka: type=click,pageview ; postgres: type=purchase bot_score<50;postgres: site_id=shop ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 || rules[0].Sink != "kafka" || rules[1].Sink != "postgres" || len(rules[1].Conditions) != 2 {
+		t.Fatalf("rules = %+v", rules)
+	}
+	if c := rules[1].Conditions[1]; c.Field != FieldBotScore || c.Op != "<" || c.number != 50 {
+		t.Errorf("condition = %+v", c)
+	}
+	if rules, err := Parse(""); err != nil || rules != nil {
+		t.Errorf("Parse(\"\") = %v, %v", rules, err)
+	}
+
+	for _, bad := range []string{
+		"kafka",                  // no colon
+		": type=click",           // no sink
+		"kafka:",                 // no conditions
+		"kafka: type",            // no operator
+		"kafka: country=DE",      // unknown field
+		"kafka: type<click",      // ordering on a string field
+		"kafka: bot_score>high",  // not a number
+		"kafka: bot_score<=101",  // out of range
+		"kafka: utm_source!news", // incomplete operator
+	} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) should fail", bad)
+		}
+	}
+}
+
+func TestRouterAllows(t *testing.T) {
+	rules, err := Parse("kafka: type=click,pageview; postgres: type=purchase bot_score<50; postgres: utm_source=Newsletter; log: site_id!=blog; meta_capi: ip_class!=datacenter,tor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRouter(rules)
+
+	click := event.Event{Type: "click", SiteID: "shop"}
+	purchase := event.Event{Type: "purchase", SiteID: "blog"}
+	botPurchase := purchase
+	botPurchase.Server.Detection.RequestAnalysis.UserAgentAnalysis.ContainsAutomation = true
+	newsletter := event.Event{Type: "pageview"}
+	newsletter.URL.UTM.Source = "newsletter"
+	cloudClick := click
+	cloudClick.Server.Detection.IPClass = "datacenter"
+
+	tests := []struct {
+		name  string
+		sink  string
+		event event.Event
+		want  bool
+	}{
+		{"click to kafka", "kafka", click, true},
+		{"purchase not to kafka", "kafka", purchase, false},
+		{"purchase to postgres", "postgres", purchase, true},
+		{"bot purchase not to postgres", "postgres", botPurchase, false},
+		{"second rule matches", "postgres", newsletter, true},
+		{"click not to postgres", "postgres", click, false},
+		{"site excluded", "log", purchase, false},
+		{"other site", "log", click, true},
+		{"sink without rules", "relay", botPurchase, true},
+		{"datacenter excluded", "meta_capi", cloudClick, false},
+		{"unclassified allowed", "meta_capi", click, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Allows(tt.sink, tt.event); got != tt.want {
+				t.Errorf("Allows(%s) = %v, want %v", tt.sink, got, tt.want)
+			}
+		})
+	}
+
+	r.Update(nil)
+	if !r.Allows("kafka", purchase) {
+		t.Error("rules still applied after Update(nil)")
+	}
+	var none *Router
+	if !none.Allows("kafka", purchase) {
+		t.Error("nil router should allow every event")
+	}
+}
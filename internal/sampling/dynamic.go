@@ -0,0 +1,120 @@
+// Package sampling drops a fraction of high-volume events, at fixed per-type
+// rates or when the pipeline is overloaded, so conversions and other rare
+// events keep flowing.
+package sampling
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// DynamicConfig sets the load-shedding watermarks
+type DynamicConfig struct {
+	QueueHigh   int           // shed when any sink buffers at least this many events
+	QueueLow    int           // recover once every sink buffers at most this many
+	LatencyHigh time.Duration // shed when any sink's last flush took at least this long
+	MinRate     float64       // lowest pageview sample rate, e.g. 0.1
+}
+
+// Dynamic samples pageviews at a rate that halves each time a watermark is
+// crossed and doubles back toward 1 once load subsides. Only pageviews are
+// sampled; conversions, clicks and custom events are always kept.
+type Dynamic struct {
+	cfg  DynamicConfig
+	rate atomic.Uint64 // math.Float64bits of the current pageview rate
+
+	// OnChange is called with the new rate whenever it changes
+	OnChange func(rate float64)
+
+	random func() float64
+}
+
+// NewDynamic creates a sampler at full fidelity
+func NewDynamic(cfg DynamicConfig) *Dynamic {
+	if cfg.MinRate <= 0 || cfg.MinRate > 1 {
+		cfg.MinRate = 0.01
+	}
+	d := &Dynamic{cfg: cfg, random: rand.Float64}
+	d.rate.Store(math.Float64bits(1))
+	return d
+}
+
+// Rate returns the current pageview sample rate
+func (d *Dynamic) Rate() float64 {
+	return math.Float64frombits(d.rate.Load())
+}
+
+// Adjust moves the rate based on the heaviest sink load and returns the new rate.
+// Between the watermarks the rate is left alone so it does not flap.
+func (d *Dynamic) Adjust(queueDepth int, flushLatency time.Duration) float64 {
+	current := d.Rate()
+	next := current
+
+	overloaded := (d.cfg.QueueHigh > 0 && queueDepth >= d.cfg.QueueHigh) ||
+		(d.cfg.LatencyHigh > 0 && flushLatency >= d.cfg.LatencyHigh)
+	recovered := queueDepth <= d.cfg.QueueLow &&
+		(d.cfg.LatencyHigh <= 0 || flushLatency < d.cfg.LatencyHigh/2)
+
+	switch {
+	case overloaded:
+		next = math.Max(current/2, d.cfg.MinRate)
+	case recovered:
+		next = math.Min(current*2, 1)
+	}
+
+	if next != current {
+		d.rate.Store(math.Float64bits(next))
+		log.Printf("sampling: pageview sample rate %.3f -> %.3f (queue=%d flush=%s)", current, next, queueDepth, flushLatency)
+		if d.OnChange != nil {
+			d.OnChange(next)
+		}
+	}
+	return next
+}
+
+// Run polls probe on every interval and adjusts the rate until the context is cancelled
+func (d *Dynamic) Run(ctx context.Context, interval time.Duration, probe func() (int, time.Duration)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.Adjust(probe())
+		}
+	}
+}
+
+// Keep decides whether ev is kept and records the effective rate on it.
+// Events already sampled upstream (e.g. by a relaying edge) compound the rates.
+func (d *Dynamic) Keep(ev *event.Event) bool {
+	rate := 1.0
+	if ev.Type == "pageview" {
+		rate = d.Rate()
+	}
+	if rate < 1 && d.random() >= rate {
+		return false
+	}
+	if ev.SampleRate > 0 {
+		rate *= ev.SampleRate
+	}
+	ev.SampleRate = rate
+	return true
+}
+
+// Wrap returns an emit function that samples events before passing them on
+func (d *Dynamic) Wrap(emit func(context.Context, event.Event)) func(context.Context, event.Event) {
+	return func(ctx context.Context, ev event.Event) {
+		if d.Keep(&ev) {
+			emit(ctx, ev)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package sampling
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func newTestDynamic() *Dynamic {
+	return NewDynamic(DynamicConfig{
+		QueueHigh:   100,
+		QueueLow:    10,
+		LatencyHigh: time.Second,
+		MinRate:     0.125,
+	})
+}
+
+func TestDynamicAdjust(t *testing.T) {
+	t.Run("halves under queue pressure down to the floor", func(t *testing.T) {
+		d := newTestDynamic()
+		want := []float64{0.5, 0.25, 0.125, 0.125}
+		for i, w := range want {
+			if got := d.Adjust(150, 0); got != w {
+				t.Errorf("step %d: rate = %v, want %v", i, got, w)
+			}
+		}
+	})
+
+	t.Run("sheds on slow flushes", func(t *testing.T) {
+		d := newTestDynamic()
+		if got := d.Adjust(0, 2*time.Second); got != 0.5 {
+			t.Errorf("rate = %v, want 0.5", got)
+		}
+	})
+
+	t.Run("holds between watermarks and recovers below", func(t *testing.T) {
+		d := newTestDynamic()
+		d.Adjust(150, 0)
+		d.Adjust(150, 0)
+
+		if got := d.Adjust(50, 0); got != 0.25 {
+			t.Errorf("rate between watermarks = %v, want unchanged 0.25", got)
+		}
+		if got := d.Adjust(5, 0); got != 0.5 {
+			t.Errorf("rate after recovery step = %v, want 0.5", got)
+		}
+		d.Adjust(5, 0)
+		if got := d.Adjust(5, 0); got != 1 {
+			t.Errorf("rate = %v, want full fidelity", got)
+		}
+	})
+
+	t.Run("reports changes", func(t *testing.T) {
+		d := newTestDynamic()
+		var changes []float64
+		d.OnChange = func(rate float64) { changes = append(changes, rate) }
+		d.Adjust(150, 0)
+		d.Adjust(50, 0)
+		if len(changes) != 1 || changes[0] != 0.5 {
+			t.Errorf("OnChange calls = %v, want [0.5]", changes)
+		}
+	})
+}
+
+func TestDynamicKeep(t *testing.T) {
+	d := newTestDynamic()
+	d.Adjust(150, 0) // rate 0.5
+
+	t.Run("samples pageviews and records the rate", func(t *testing.T) {
+		d.random = func() float64 { return 0.4 }
+		ev := event.Event{Type: "pageview"}
+		if !d.Keep(&ev) {
+			t.Fatal("expected pageview below the rate to be kept")
+		}
+		if ev.SampleRate != 0.5 {
+			t.Errorf("SampleRate = %v, want 0.5", ev.SampleRate)
+		}
+
+		d.random = func() float64 { return 0.6 }
+		if d.Keep(&event.Event{Type: "pageview"}) {
+			t.Error("expected pageview above the rate to be dropped")
+		}
+	})
+
+	t.Run("never samples other event types", func(t *testing.T) {
+		d.random = func() float64 { return 0.99 }
+		for _, typ := range []string{"conversion", "purchase", "click"} {
+			ev := event.Event{Type: typ}
+			if !d.Keep(&ev) {
+				t.Errorf("%s event was dropped", typ)
+			}
+			if ev.SampleRate != 1 {
+				t.Errorf("%s SampleRate = %v, want 1", typ, ev.SampleRate)
+			}
+		}
+	})
+
+	t.Run("compounds upstream sample rate", func(t *testing.T) {
+		d.random = func() float64 { return 0 }
+		ev := event.Event{Type: "pageview", SampleRate: 0.5}
+		d.Keep(&ev)
+		if ev.SampleRate != 0.25 {
+			t.Errorf("SampleRate = %v, want 0.25", ev.SampleRate)
+		}
+	})
+
+	t.Run("wrap drops sampled events", func(t *testing.T) {
+		d.random = func() float64 { return 0.9 }
+		var emitted []event.Event
+		emit := d.Wrap(func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) })
+		emit(context.Background(), event.Event{Type: "pageview"})
+		emit(context.Background(), event.Event{Type: "conversion"})
+		if len(emitted) != 1 || emitted[0].Type != "conversion" {
+			t.Errorf("emitted = %+v, want only the conversion", emitted)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package sampling
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// DefaultType is the SAMPLING_RATES key for event types without a rate of their own
+const DefaultType = "*"
+
+// Fixed samples events at configured per-type rates. The decision is keyed
+// on the visitor, so a visitor's journey is kept or dropped as a whole: a
+// visitor kept at a 10% pageview rate is also kept for every type sampled at
+// 10% or more.
+type Fixed struct {
+	rates map[string]float64
+	def   float64
+}
+
+// ParseRates reads type=rate pairs such as pageview=10% or click=0.5. The
+// type * sets the rate of unlisted types, which otherwise are all kept.
+func ParseRates(pairs []string) (map[string]float64, error) {
+	rates := make(map[string]float64, len(pairs))
+	for _, pair := range pairs {
+		typ, value, ok := strings.Cut(pair, "=")
+		typ, value = strings.TrimSpace(typ), strings.TrimSpace(value)
+		if !ok || typ == "" {
+			return nil, fmt.Errorf("invalid sample rate %q (want type=rate)", pair)
+		}
+		percent := strings.HasSuffix(value, "%")
+		rate, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample rate for %s: %q", typ, value)
+		}
+		if percent {
+			rate /= 100
+		}
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("sample rate for %s must be between 0 and 100%%, got %s", typ, value)
+		}
+		rates[typ] = rate
+	}
+	return rates, nil
+}
+
+// NewFixed creates a sampler from parsed rates
+func NewFixed(rates map[string]float64) *Fixed {
+	f := &Fixed{rates: make(map[string]float64, len(rates)), def: 1}
+	for typ, rate := range rates {
+		if typ == DefaultType {
+			f.def = rate
+			continue
+		}
+		f.rates[typ] = rate
+	}
+	return f
+}
+
+// Rate returns the sample rate of an event type
+func (f *Fixed) Rate(eventType string) float64 {
+	if rate, ok := f.rates[eventType]; ok {
+		return rate
+	}
+	return f.def
+}
+
+// Rates returns the configured rate of every listed type, for reporting
+func (f *Fixed) Rates() map[string]float64 {
+	rates := make(map[string]float64, len(f.rates))
+	for typ, rate := range f.rates {
+		rates[typ] = rate
+	}
+	return rates
+}
+
+// Keep decides whether ev is kept and records the effective rate on it.
+// Events already sampled upstream compound the rates.
+func (f *Fixed) Keep(ev *event.Event) bool {
+	rate := f.Rate(ev.Type)
+	if rate < 1 && bucket(ev) >= rate {
+		return false
+	}
+	if ev.SampleRate > 0 {
+		rate *= ev.SampleRate
+	}
+	ev.SampleRate = rate
+	return true
+}
+
+// Wrap returns an emit function that samples events before passing them on
+func (f *Fixed) Wrap(emit func(context.Context, event.Event)) func(context.Context, event.Event) {
+	return func(ctx context.Context, ev event.Event) {
+		if f.Keep(&ev) {
+			emit(ctx, ev)
+		}
+	}
+}
+
+// bucket places an event's visitor in [0, 1). Events without a visitor fall
+// back to their session, and failing that to the event itself.
+func bucket(ev *event.Event) float64 {
+	key := ev.Session.VisitorID
+	if key == "" {
+		key = ev.Session.SessionID
+	}
+	if key == "" {
+		key = ev.EventID
+	}
+	sum := sha256.Sum256([]byte(key))
+	// The top 53 bits fill a float64 mantissa exactly
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / math.Exp2(53)
+}
@@ -0,0 +1,88 @@
+package sampling
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestParseRates(t *testing.T) {
+	rates, err := ParseRates([]string{"pageview=10%", " click = 0.5 ", "*=25%"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rates["pageview"] != 0.1 || rates["click"] != 0.5 || rates[DefaultType] != 0.25 {
+		t.Errorf("rates = %v", rates)
+	}
+	for _, bad := range []string{"pageview", "=10%", "pageview=ten", "pageview=150%", "pageview=-0.1"} {
+		if _, err := ParseRates([]string{bad}); err == nil {
+			t.Errorf("ParseRates(%q) should fail", bad)
+		}
+	}
+}
+
+func TestFixedKeep(t *testing.T) {
+	f := NewFixed(map[string]float64{"pageview": 0.1, "click": 0.5, "conversion": 1})
+
+	t.Run("keeps about the configured share of visitors", func(t *testing.T) {
+		kept := 0
+		for i := 0; i < 10000; i++ {
+			ev := event.Event{Type: "pageview", Session: event.SessionInfo{VisitorID: fmt.Sprintf("visitor-%d", i)}}
+			if f.Keep(&ev) {
+				kept++
+				if ev.SampleRate != 0.1 {
+					t.Fatalf("SampleRate = %v, want 0.1", ev.SampleRate)
+				}
+			}
+		}
+		if kept < 900 || kept > 1100 {
+			t.Errorf("kept %d of 10000 pageviews, want about 1000", kept)
+		}
+	})
+
+	t.Run("decisions follow the visitor", func(t *testing.T) {
+		for i := 0; i < 1000; i++ {
+			visitor := event.SessionInfo{VisitorID: fmt.Sprintf("v%d", i)}
+			pageview := event.Event{Type: "pageview", Session: visitor}
+			if !f.Keep(&pageview) {
+				continue
+			}
+			again := event.Event{Type: "pageview", Session: visitor}
+			click := event.Event{Type: "click", Session: visitor}
+			if !f.Keep(&again) || !f.Keep(&click) {
+				t.Fatalf("visitor %s kept for one pageview but not the rest of the journey", visitor.VisitorID)
+			}
+		}
+	})
+
+	t.Run("unlisted types are kept", func(t *testing.T) {
+		ev := event.Event{Type: "sign_up", EventID: "e"}
+		if !f.Keep(&ev) || ev.SampleRate != 1 {
+			t.Errorf("kept = false or SampleRate = %v", ev.SampleRate)
+		}
+		withDefault := NewFixed(map[string]float64{DefaultType: 0})
+		if withDefault.Keep(&event.Event{Type: "sign_up", EventID: "e"}) {
+			t.Error("default rate 0 should drop unlisted types")
+		}
+	})
+
+	t.Run("compounds upstream sample rate", func(t *testing.T) {
+		ev := event.Event{Type: "conversion", SampleRate: 0.5}
+		if !f.Keep(&ev) || ev.SampleRate != 0.5 {
+			t.Errorf("SampleRate = %v, want 0.5", ev.SampleRate)
+		}
+	})
+
+	t.Run("wrap drops sampled events", func(t *testing.T) {
+		drop := NewFixed(map[string]float64{"pageview": 0})
+		var emitted []event.Event
+		emit := drop.Wrap(func(_ context.Context, ev event.Event) { emitted = append(emitted, ev) })
+		emit(context.Background(), event.Event{Type: "pageview", EventID: "a"})
+		emit(context.Background(), event.Event{Type: "conversion", EventID: "b"})
+		if len(emitted) != 1 || emitted[0].Type != "conversion" {
+			t.Errorf("emitted = %+v, want only the conversion", emitted)
+		}
+	})
+}
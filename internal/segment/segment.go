@@ -0,0 +1,253 @@
+// Package segment maps Segment HTTP Tracking API calls to GoTrack events, so
+// teams moving off Segment can point analytics.js and the server-side
+// libraries at GoTrack without changing their instrumentation.
+package segment
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Call types GoTrack maps; other types (screen, group, alias) are ignored
+const (
+	TypeTrack    = "track"
+	TypePage     = "page"
+	TypeIdentify = "identify"
+)
+
+// Message is one Segment call
+type Message struct {
+	Type              string         `json:"type"`
+	MessageID         string         `json:"messageId"`
+	AnonymousID       string         `json:"anonymousId"`
+	UserID            string         `json:"userId"`
+	Event             string         `json:"event"`    // track
+	Name              string         `json:"name"`     // page
+	Category          string         `json:"category"` // page
+	Properties        map[string]any `json:"properties"`
+	Traits            map[string]any `json:"traits"` // identify
+	Context           *Context       `json:"context"`
+	Timestamp         time.Time      `json:"timestamp"`
+	OriginalTimestamp time.Time      `json:"originalTimestamp"`
+	SentAt            time.Time      `json:"sentAt"`
+	WriteKey          string         `json:"writeKey"`
+}
+
+// Context is the subset of Segment's context object GoTrack maps
+type Context struct {
+	IP        string   `json:"ip"`
+	UserAgent string   `json:"userAgent"`
+	Locale    string   `json:"locale"`
+	Timezone  string   `json:"timezone"`
+	Page      Page     `json:"page"`
+	Campaign  Campaign `json:"campaign"`
+}
+
+// Page is context.page, which analytics.js sends with every call
+type Page struct {
+	URL      string `json:"url"`
+	Path     string `json:"path"`
+	Referrer string `json:"referrer"`
+	Title    string `json:"title"`
+}
+
+// Campaign is context.campaign, parsed from the UTM parameters
+type Campaign struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	Medium  string `json:"medium"`
+	Term    string `json:"term"`
+	Content string `json:"content"`
+}
+
+// Batch is a /v1/batch request body. Its context and sentAt apply to
+// messages that do not set their own.
+type Batch struct {
+	Batch    []Message `json:"batch"`
+	Context  *Context  `json:"context"`
+	SentAt   time.Time `json:"sentAt"`
+	WriteKey string    `json:"writeKey"`
+}
+
+// ParseMessage decodes a single call. callType is implied by the endpoint
+// (/v1/t, /v1/p, /v1/i) and overrides the body's type.
+func ParseMessage(body []byte, callType string) (Message, error) {
+	var m Message
+	if err := json.Unmarshal(body, &m); err != nil {
+		return Message{}, err
+	}
+	m.Type = callType
+	return m, nil
+}
+
+// ParseBatch decodes a /v1/batch body
+func ParseBatch(body []byte) (Batch, error) {
+	var b Batch
+	if err := json.Unmarshal(body, &b); err != nil {
+		return Batch{}, err
+	}
+	for i := range b.Batch {
+		m := &b.Batch[i]
+		if m.Context == nil {
+			m.Context = b.Context
+		}
+		if m.SentAt.IsZero() {
+			m.SentAt = b.SentAt
+		}
+	}
+	return b, nil
+}
+
+// Validate reports the first problem that makes Segment reject a call
+func (m Message) Validate() error {
+	switch m.Type {
+	case TypeTrack:
+		if m.Event == "" {
+			return fmt.Errorf("track call requires event")
+		}
+	case TypePage, TypeIdentify:
+	default:
+		return nil // ignored types are not checked
+	}
+	if m.AnonymousID == "" && m.UserID == "" {
+		return fmt.Errorf("%s call requires anonymousId or userId", m.Type)
+	}
+	return nil
+}
+
+// Supported reports whether the call type is mapped to an event
+func (m Message) Supported() bool {
+	return m.Type == TypeTrack || m.Type == TypePage || m.Type == TypeIdentify
+}
+
+// messageNamespace derives event IDs from messageIds that are not UUIDs, such
+// as analytics.js's "ajs-next-…" IDs, so retried calls keep their event_id
+var messageNamespace = uuid.MustParse("6f1c2b0e-5d8a-4c47-9e39-1b7c1f4a2d90")
+
+// ToEvent maps a call to a GoTrack event. track calls take the event name as
+// type, page calls become pageviews and identify calls have type identify.
+// anonymousId is the visitor ID and userId the user ID. Properties are kept
+// in props, traits as traits.<name>, and the page name and category as name
+// and category. now is the receive time, used to correct client clock skew.
+func (m Message) ToEvent(now time.Time) event.Event {
+	var ev event.Event
+	switch m.Type {
+	case TypeTrack:
+		ev.Type = m.Event
+	case TypePage:
+		ev.Type = "pageview"
+	default:
+		ev.Type = m.Type
+	}
+	ev.EventID = m.eventID()
+	ev.TS = m.timestamp(now)
+	ev.Session.VisitorID = m.AnonymousID
+	ev.Session.UserID = m.UserID
+
+	if c := m.Context; c != nil {
+		ev.Server.IP = c.IP
+		ev.Device.UA = c.UserAgent
+		ev.Device.Language = c.Locale
+		ev.Device.TZ = c.Timezone
+		applyPage(&ev, c.Page)
+		// Explicit campaign context wins over the UTM tags in the page URL
+		setIf(&ev.URL.UTM.Campaign, c.Campaign.Name)
+		setIf(&ev.URL.UTM.Source, c.Campaign.Source)
+		setIf(&ev.URL.UTM.Medium, c.Campaign.Medium)
+		setIf(&ev.URL.UTM.Term, c.Campaign.Term)
+		setIf(&ev.URL.UTM.Content, c.Campaign.Content)
+	}
+	if m.Type == TypePage {
+		// page properties describe the page and override context.page
+		applyPage(&ev, Page{
+			URL:      stringProp(m.Properties, "url"),
+			Path:     stringProp(m.Properties, "path"),
+			Referrer: stringProp(m.Properties, "referrer"),
+			Title:    stringProp(m.Properties, "title"),
+		})
+	}
+
+	props := make(map[string]string, len(m.Properties)+len(m.Traits)+2)
+	for k, v := range m.Properties {
+		props[k] = event.PropValue(v)
+	}
+	for k, v := range m.Traits {
+		props["traits."+k] = event.PropValue(v)
+	}
+	if m.Type == TypePage {
+		props["name"] = m.Name
+		props["category"] = m.Category
+	}
+	for k, v := range props {
+		if v == "" {
+			delete(props, k)
+		}
+	}
+	if len(props) > 0 {
+		ev.Props = props
+	}
+	return ev
+}
+
+// eventID returns messageId in canonical UUID form, a UUID derived from it,
+// or empty to let the server generate one
+func (m Message) eventID() string {
+	if m.MessageID == "" {
+		return ""
+	}
+	if id, err := uuid.Parse(m.MessageID); err == nil {
+		return id.String()
+	}
+	return uuid.NewSHA1(messageNamespace, []byte(m.MessageID)).String()
+}
+
+// timestamp returns the event time as RFC 3339, as Segment computes it: an
+// explicit timestamp wins; otherwise originalTimestamp is shifted by the
+// difference between the receive time and sentAt, which corrects a client
+// clock that is off. Empty lets the server fill in the receive time.
+func (m Message) timestamp(now time.Time) string {
+	t := m.Timestamp
+	if t.IsZero() && !m.OriginalTimestamp.IsZero() {
+		t = m.OriginalTimestamp
+		if !m.SentAt.IsZero() {
+			t = now.Add(-m.SentAt.Sub(m.OriginalTimestamp))
+		}
+	}
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// applyPage sets the route and referrer from the non-empty page fields
+func applyPage(ev *event.Event, p Page) {
+	if p.URL != "" {
+		event.ApplyPageURL(ev, p.URL)
+	}
+	if p.Path != "" && ev.Route.Path == "" {
+		ev.Route.Path = p.Path
+	}
+	setIf(&ev.Route.Title, p.Title)
+	if p.Referrer != "" {
+		ev.URL.Referrer = p.Referrer
+		if u, err := url.Parse(p.Referrer); err == nil {
+			ev.URL.ReferrerHostname = u.Hostname()
+		}
+	}
+}
+
+func stringProp(props map[string]any, key string) string {
+	s, _ := props[key].(string)
+	return s
+}
+
+func setIf(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
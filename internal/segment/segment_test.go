@@ -0,0 +1,137 @@
+package segment
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBatch(t *testing.T) {
+	b, err := ParseBatch([]byte(`{
+		"writeKey": "wk",
+		"sentAt": "2024-01-02T03:04:05Z",
+		"context": {"ip": "203.0.113.7"},
+		"batch": [
+			{"type": "track", "event": "Signed Up", "userId": "u-1"},
+			{"type": "identify", "userId": "u-1", "context": {"ip": "198.51.100.1"}, "sentAt": "2024-01-02T03:04:06Z"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.WriteKey != "wk" || len(b.Batch) != 2 {
+		t.Fatalf("batch = %+v", b)
+	}
+	if b.Batch[0].Context.IP != "203.0.113.7" || !b.Batch[0].SentAt.Equal(b.SentAt) {
+		t.Errorf("batch context and sentAt not applied: %+v", b.Batch[0])
+	}
+	if b.Batch[1].Context.IP != "198.51.100.1" || b.Batch[1].SentAt.Equal(b.SentAt) {
+		t.Errorf("message context and sentAt overridden: %+v", b.Batch[1])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		msg   Message
+		valid bool
+	}{
+		{Message{Type: TypeTrack, Event: "Clicked", AnonymousID: "a"}, true},
+		{Message{Type: TypeTrack, AnonymousID: "a"}, false},
+		{Message{Type: TypePage, UserID: "u"}, true},
+		{Message{Type: TypeIdentify}, false},
+		{Message{Type: "screen"}, true}, // ignored, not checked
+	} {
+		if err := tc.msg.Validate(); (err == nil) != tc.valid {
+			t.Errorf("Validate(%+v) = %v", tc.msg, err)
+		}
+	}
+}
+
+func TestToEventTrack(t *testing.T) {
+	msg, err := ParseMessage([]byte(`{
+		"type": "page",
+		"event": "Order Completed",
+		"messageId": "ajs-next-1704164645000-abc",
+		"anonymousId": "anon-1",
+		"userId": "u-1",
+		"properties": {"revenue": 19.9, "currency": "USD", "products": [{"sku": "a"}], "coupon": null},
+		"context": {
+			"ip": "203.0.113.7",
+			"userAgent": "Mozilla/5.0",
+			"locale": "en-US",
+			"timezone": "Europe/Berlin",
+			"page": {"url": "https://shop.example/checkout?utm_source=news&gclid=g1", "referrer": "https://www.google.com/", "title": "Checkout"},
+			"campaign": {"name": "spring", "source": "newsletter"}
+		},
+		"timestamp": "2024-01-02T03:04:05Z"
+	}`), TypeTrack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := msg.ToEvent(time.Now())
+
+	if ev.Type != "Order Completed" || ev.TS != "2024-01-02T03:04:05Z" {
+		t.Errorf("type = %q, ts = %q", ev.Type, ev.TS)
+	}
+	again, _ := ParseMessage([]byte(`{"messageId": "ajs-next-1704164645000-abc"}`), TypeTrack)
+	if ev.EventID == "" || ev.EventID != again.ToEvent(time.Now()).EventID {
+		t.Errorf("event_id = %q, want one derived from the messageId", ev.EventID)
+	}
+	if ev.Session.VisitorID != "anon-1" || ev.Session.UserID != "u-1" {
+		t.Errorf("session = %+v", ev.Session)
+	}
+	if ev.Server.IP != "203.0.113.7" || ev.Device.UA != "Mozilla/5.0" || ev.Device.Language != "en-US" || ev.Device.TZ != "Europe/Berlin" {
+		t.Errorf("server = %+v, device = %+v", ev.Server, ev.Device)
+	}
+	if ev.Route.Domain != "shop.example" || ev.Route.Path != "/checkout" || ev.Route.Title != "Checkout" || ev.URL.ReferrerHostname != "www.google.com" {
+		t.Errorf("route = %+v, referrer = %q", ev.Route, ev.URL.Referrer)
+	}
+	if ev.URL.UTM.Campaign != "spring" || ev.URL.UTM.Source != "newsletter" || ev.URL.Google.GCLID != "g1" {
+		t.Errorf("utm = %+v, gclid = %q", ev.URL.UTM, ev.URL.Google.GCLID)
+	}
+	if ev.Props["revenue"] != "19.9" || ev.Props["products"] != `[{"sku":"a"}]` {
+		t.Errorf("props = %v", ev.Props)
+	}
+	if _, ok := ev.Props["coupon"]; ok {
+		t.Errorf("null property kept: %v", ev.Props)
+	}
+}
+
+func TestToEventPageAndIdentify(t *testing.T) {
+	page := Message{
+		Type:        TypePage,
+		Name:        "Pricing",
+		AnonymousID: "a",
+		Properties:  map[string]any{"url": "https://example.com/pricing", "title": "Pricing | Example"},
+		Context:     &Context{Page: Page{URL: "https://example.com/", Title: "Home"}},
+	}
+	ev := page.ToEvent(time.Now())
+	if ev.Type != "pageview" || ev.Route.Path != "/pricing" || ev.Route.Title != "Pricing | Example" || ev.Props["name"] != "Pricing" {
+		t.Errorf("page = type %q, route %+v, props %v", ev.Type, ev.Route, ev.Props)
+	}
+	if _, ok := ev.Props["category"]; ok {
+		t.Errorf("empty category kept: %v", ev.Props)
+	}
+
+	identify := Message{Type: TypeIdentify, UserID: "u-1", Traits: map[string]any{"email": "jane@example.com", "plan": "pro"}}
+	ev = identify.ToEvent(time.Now())
+	if ev.Type != "identify" || ev.Session.UserID != "u-1" || ev.Props["traits.email"] != "jane@example.com" || ev.Props["traits.plan"] != "pro" {
+		t.Errorf("identify = %+v", ev)
+	}
+}
+
+func TestTimestampSkew(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	original := time.Date(2024, 1, 2, 13, 59, 50, 0, time.UTC) // client clock two hours fast
+	msg := Message{OriginalTimestamp: original, SentAt: original.Add(10 * time.Second)}
+	if got := msg.timestamp(now); got != "2024-01-02T11:59:50Z" {
+		t.Errorf("timestamp = %q, want the client offset removed", got)
+	}
+
+	msg.SentAt = time.Time{}
+	if got := msg.timestamp(now); got != "2024-01-02T13:59:50Z" {
+		t.Errorf("timestamp without sentAt = %q", got)
+	}
+	if got := (Message{}).timestamp(now); got != "" {
+		t.Errorf("timestamp = %q, want empty", got)
+	}
+}
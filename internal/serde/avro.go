@@ -0,0 +1,144 @@
+package serde
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// avroNamespace is the namespace of the generated Avro records
+const avroNamespace = "com.shortontech.gotrack"
+
+// AvroSchema returns the Avro schema for event.Event as JSON. Every field has
+// a default so that appending fields stays backward and forward compatible.
+func AvroSchema() string {
+	schema := avroType(reflect.TypeOf(event.Event{}), map[string]bool{})
+	out, err := json.Marshal(schema)
+	if err != nil {
+		// The schema is built from plain maps and slices
+		panic(fmt.Sprintf("serde: failed to marshal avro schema: %v", err))
+	}
+	return string(out)
+}
+
+// avroType maps a Go type to its Avro schema. Records are defined on first
+// use and referenced by name afterwards, as Avro requires.
+func avroType(t reflect.Type, defined map[string]bool) any {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return "long"
+	case reflect.Float64, reflect.Float32:
+		return "double"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Ptr:
+		return []any{"null", avroType(t.Elem(), defined)}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": avroType(t.Elem(), defined)}
+	case reflect.Map:
+		return map[string]any{"type": "map", "values": avroType(t.Elem(), defined)}
+	case reflect.Struct:
+		if defined[t.Name()] {
+			return t.Name()
+		}
+		defined[t.Name()] = true
+		var fields []any
+		for _, f := range fieldsOf(t) {
+			fields = append(fields, map[string]any{
+				"name":    f.name,
+				"type":    avroType(f.typ, defined),
+				"default": avroDefault(f.typ),
+			})
+		}
+		return map[string]any{
+			"type":      "record",
+			"name":      t.Name(),
+			"namespace": avroNamespace,
+			"fields":    fields,
+		}
+	}
+	panic(fmt.Sprintf("serde: unsupported avro type %s", t))
+}
+
+// avroDefault returns the JSON default value for a field of type t
+func avroDefault(t reflect.Type) any {
+	switch t.Kind() {
+	case reflect.String:
+		return ""
+	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Float64, reflect.Float32:
+		return 0
+	case reflect.Bool:
+		return false
+	case reflect.Ptr:
+		return nil
+	case reflect.Slice:
+		return []any{}
+	default: // maps and records; missing record fields take their own defaults
+		return map[string]any{}
+	}
+}
+
+// appendAvro appends the Avro binary encoding of v
+func appendAvro(buf []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.String:
+		return appendAvroString(buf, v.String())
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return binary.AppendVarint(buf, v.Int())
+	case reflect.Float64, reflect.Float32:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Float()))
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1)
+		}
+		return append(buf, 0)
+	case reflect.Ptr:
+		// Union ["null", T]: branch index, then the value
+		if v.IsNil() {
+			return binary.AppendVarint(buf, 0)
+		}
+		return appendAvro(binary.AppendVarint(buf, 1), v.Elem())
+	case reflect.Slice:
+		if v.Len() > 0 {
+			buf = binary.AppendVarint(buf, int64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				buf = appendAvro(buf, v.Index(i))
+			}
+		}
+		return binary.AppendVarint(buf, 0)
+	case reflect.Map:
+		if v.Len() > 0 {
+			buf = binary.AppendVarint(buf, int64(v.Len()))
+			for _, k := range sortedKeys(v) {
+				buf = appendAvroString(buf, k.String())
+				buf = appendAvro(buf, v.MapIndex(k))
+			}
+		}
+		return binary.AppendVarint(buf, 0)
+	case reflect.Struct:
+		for _, f := range fieldsOf(v.Type()) {
+			buf = appendAvro(buf, v.Field(f.index))
+		}
+		return buf
+	}
+	panic(fmt.Sprintf("serde: unsupported avro value %s", v.Type()))
+}
+
+func appendAvroString(buf []byte, s string) []byte {
+	buf = binary.AppendVarint(buf, int64(len(s)))
+	return append(buf, s...)
+}
+
+// sortedKeys returns map keys in order so encodings are deterministic
+func sortedKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
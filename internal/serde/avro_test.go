@@ -0,0 +1,103 @@
+package serde
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type avroSample struct {
+	Name   string            `json:"name"`
+	Count  int               `json:"count,omitempty"`
+	Ratio  float64           `json:"ratio"`
+	OK     bool              `json:"ok"`
+	Flag   *bool             `json:"flag,omitempty"`
+	Tags   []string          `json:"tags"`
+	Labels map[string]string `json:"labels"`
+	Skip   string            `json:"-"`
+	hidden string
+}
+
+func TestAvroSchema(t *testing.T) {
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(AvroSchema()), &schema); err != nil {
+		t.Fatalf("AvroSchema() is not JSON: %v", err)
+	}
+	if schema["type"] != "record" || schema["name"] != "Event" || schema["namespace"] != avroNamespace {
+		t.Errorf("unexpected top-level schema: %v", schema)
+	}
+
+	fields := schema["fields"].([]any)
+	first := fields[0].(map[string]any)
+	if first["name"] != "event_id" || first["type"] != "string" || first["default"] != "" {
+		t.Errorf("first field = %v, want event_id string", first)
+	}
+	for _, f := range fields {
+		if _, ok := f.(map[string]any)["default"]; !ok {
+			t.Errorf("field %v has no default", f)
+		}
+	}
+}
+
+func TestAvroType(t *testing.T) {
+	got := avroType(reflect.TypeOf(avroSample{}), map[string]bool{})
+	out, _ := json.Marshal(got)
+	want := `{"fields":[` +
+		`{"default":"","name":"name","type":"string"},` +
+		`{"default":0,"name":"count","type":"long"},` +
+		`{"default":0,"name":"ratio","type":"double"},` +
+		`{"default":false,"name":"ok","type":"boolean"},` +
+		`{"default":null,"name":"flag","type":["null","boolean"]},` +
+		`{"default":[],"name":"tags","type":{"items":"string","type":"array"}},` +
+		`{"default":{},"name":"labels","type":{"type":"map","values":"string"}}` +
+		`],"name":"avroSample","namespace":"com.shortontech.gotrack","type":"record"}`
+	if string(out) != want {
+		t.Errorf("avroType() =\n%s\nwant\n%s", out, want)
+	}
+
+	t.Run("repeated records are referenced by name", func(t *testing.T) {
+		type pair struct {
+			A avroSample `json:"a"`
+			B avroSample `json:"b"`
+		}
+		rec := avroType(reflect.TypeOf(pair{}), map[string]bool{}).(map[string]any)
+		second := rec["fields"].([]any)[1].(map[string]any)
+		if second["type"] != "avroSample" {
+			t.Errorf("second use = %v, want name reference", second["type"])
+		}
+	})
+}
+
+func TestAppendAvro(t *testing.T) {
+	flag := true
+	v := avroSample{
+		Name:   "ab",
+		Count:  -2,
+		Ratio:  1,
+		OK:     true,
+		Flag:   &flag,
+		Tags:   []string{"x"},
+		Labels: map[string]string{"k": "v"},
+		Skip:   "ignored",
+	}
+	got := appendAvro(nil, reflect.ValueOf(v))
+	want := []byte{
+		4, 'a', 'b', // name
+		3,                            // count -2 zigzag
+		0, 0, 0, 0, 0, 0, 0xf0, 0x3f, // ratio 1.0 little-endian
+		1,    // ok
+		2, 1, // flag: union branch 1, true
+		2, 2, 'x', 0, // tags: block of 1, "x", end
+		2, 2, 'k', 2, 'v', 0, // labels: block of 1, k=v, end
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("appendAvro() = %v, want %v", got, want)
+	}
+
+	empty := appendAvro(nil, reflect.ValueOf(avroSample{}))
+	wantEmpty := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(empty, wantEmpty) {
+		t.Errorf("appendAvro(zero) = %v, want %v", empty, wantEmpty)
+	}
+}
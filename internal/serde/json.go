@@ -0,0 +1,243 @@
+package serde
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// maxPooledBuffer is the largest buffer PutBuffer keeps, so one oversized
+// event doesn't pin its memory in the pool
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{New: func() any {
+	b := make([]byte, 0, 4096)
+	return &b
+}}
+
+// GetBuffer returns an empty buffer from a shared pool. Return it with
+// PutBuffer once nothing refers to its contents.
+func GetBuffer() *[]byte {
+	b := bufferPool.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+// PutBuffer returns a buffer to the pool
+func PutBuffer(b *[]byte) {
+	if cap(*b) <= maxPooledBuffer {
+		bufferPool.Put(b)
+	}
+}
+
+// MarshalEvent returns the JSON encoding of e in a new slice sized to fit,
+// for callers that keep the bytes. It encodes into a pooled buffer, so the
+// copy is its only allocation.
+func MarshalEvent(e *event.Event) ([]byte, error) {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	out, err := AppendJSON(*buf, e)
+	if err != nil {
+		return nil, err
+	}
+	*buf = out
+	return append(make([]byte, 0, len(out)), out...), nil
+}
+
+// AppendJSON appends the JSON encoding of e to buf. The output is the same,
+// byte for byte, as json.Marshal's, but nothing is allocated beyond growing
+// buf, so sinks can encode every event into a reused buffer.
+func AppendJSON(buf []byte, e *event.Event) ([]byte, error) {
+	return appendJSON(buf, reflect.ValueOf(e).Elem())
+}
+
+// appendJSON appends v the way encoding/json does for the kinds events use
+func appendJSON(buf []byte, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return appendJSONString(buf, v.String()), nil
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return strconv.AppendInt(buf, v.Int(), 10), nil
+	case reflect.Float64, reflect.Float32:
+		return appendJSONFloat(buf, v.Float(), v.Type().Bits())
+	case reflect.Bool:
+		return strconv.AppendBool(buf, v.Bool()), nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return append(buf, "null"...), nil
+		}
+		return appendJSON(buf, v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(buf, "null"...), nil
+		}
+		buf = append(buf, '[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			var err error
+			if buf, err = appendJSON(buf, v.Index(i)); err != nil {
+				return buf, err
+			}
+		}
+		return append(buf, ']'), nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(buf, "null"...), nil
+		}
+		if m, ok := v.Interface().(map[string]string); ok {
+			return appendJSONStringMap(buf, m), nil
+		}
+	case reflect.Struct:
+		buf = append(buf, '{')
+		first := true
+		for _, f := range fieldsOf(v.Type()) {
+			fv := v.Field(f.index)
+			if f.omitEmpty && isEmptyJSON(fv) {
+				continue
+			}
+			if !first {
+				buf = append(buf, ',')
+			}
+			first = false
+			buf = appendJSONString(buf, f.name)
+			buf = append(buf, ':')
+			var err error
+			if buf, err = appendJSON(buf, fv); err != nil {
+				return buf, err
+			}
+		}
+		return append(buf, '}'), nil
+	}
+	return buf, fmt.Errorf("serde: unsupported json value %s", v.Type())
+}
+
+// appendJSONStringMap appends m with its keys sorted, as encoding/json does
+func appendJSONStringMap(buf []byte, m map[string]string) []byte {
+	// Event maps hold a handful of keys, which fit on the stack
+	var scratch [16]string
+	keys := scratch[:0]
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	buf = append(buf, '{')
+	for i, k := range keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONString(buf, k)
+		buf = append(buf, ':')
+		buf = appendJSONString(buf, m[k])
+	}
+	return append(buf, '}')
+}
+
+// isEmptyJSON reports whether omitempty leaves v out. Structs are never
+// empty, as in encoding/json.
+func isEmptyJSON(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return v.Int() == 0
+	case reflect.Float64, reflect.Float32:
+		return v.Float() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// appendJSONFloat formats f like encoding/json: plain notation except for
+// very small or large magnitudes, and an error for NaN and infinities
+func appendJSONFloat(buf []byte, f float64, bits int) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return buf, fmt.Errorf("json: unsupported value: %s", strconv.FormatFloat(f, 'g', -1, bits))
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	buf = strconv.AppendFloat(buf, f, format, -1, bits)
+	if format == 'e' {
+		// Shorten e-09 to e-9
+		if n := len(buf); n >= 4 && buf[n-4] == 'e' && buf[n-3] == '-' && buf[n-2] == '0' {
+			buf[n-2] = buf[n-1]
+			buf = buf[:n-1]
+		}
+	}
+	return buf, nil
+}
+
+// The escapes encoding/json uses differ between Go releases, for example
+// for invalid UTF-8, so they are taken from the linked encoding/json
+var (
+	asciiEscapes [utf8.RuneSelf]string // quoted form of each ASCII byte that needs one
+	invalidUTF8  string                // replacement for a byte that isn't valid UTF-8
+	lineSep      [2]string             // U+2028 and U+2029
+)
+
+func init() {
+	unquoted := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b[1 : len(b)-1])
+	}
+	for b := range utf8.RuneSelf {
+		if q := unquoted(string(rune(b))); q != string(rune(b)) {
+			asciiEscapes[b] = q
+		}
+	}
+	invalidUTF8 = unquoted("\xff")
+	lineSep = [2]string{unquoted("\u2028"), unquoted("\u2029")}
+}
+
+// appendJSONString quotes s like encoding/json, including its HTML-safe
+// escaping of <, > and &
+func appendJSONString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if asciiEscapes[b] == "" {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, asciiEscapes[b]...)
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		var replacement string
+		switch {
+		case c == utf8.RuneError && size == 1:
+			replacement = invalidUTF8
+		case c == '\u2028' || c == '\u2029':
+			replacement = lineSep[c-'\u2028']
+		default:
+			i += size
+			continue
+		}
+		buf = append(buf, s[start:i]...)
+		buf = append(buf, replacement...)
+		i += size
+		start = i
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
@@ -0,0 +1,207 @@
+package serde
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// fullEvent sets every field of an event, walking the struct so fields
+// added later are covered too
+func fullEvent(s string) event.Event {
+	var e event.Event
+	fill(reflect.ValueOf(&e).Elem(), s, 1)
+	return e
+}
+
+func fill(v reflect.Value, s string, n int) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		v.SetInt(int64(-n * 7))
+	case reflect.Float64, reflect.Float32:
+		v.SetFloat(float64(n) * 0.125)
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem(), s, n)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+		fill(v.Index(0), s, n)
+		fill(v.Index(1), s+"2", n+1)
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		for _, k := range []string{"zeta", "alpha", s} {
+			val := reflect.New(v.Type().Elem()).Elem()
+			fill(val, k+s, n)
+			v.SetMapIndex(reflect.ValueOf(k), val)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fill(v.Field(i), s, n+i)
+			}
+		}
+	}
+}
+
+func assertMatchesMarshal(t *testing.T, e event.Event) {
+	t.Helper()
+	want, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	got, err := AppendJSON(nil, &e)
+	if err != nil {
+		t.Fatalf("AppendJSON: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("AppendJSON differs from json.Marshal\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestAppendJSON(t *testing.T) {
+	t.Run("zero event", func(t *testing.T) {
+		assertMatchesMarshal(t, event.Event{})
+	})
+
+	t.Run("every field set", func(t *testing.T) {
+		assertMatchesMarshal(t, fullEvent("value"))
+	})
+
+	t.Run("strings that need escaping", func(t *testing.T) {
+		for _, s := range []string{
+			`quote " backslash \ slash /`,
+			"<script>alert('x')</script> & more",
+			"control \x00\x01\x1f \b\f\n\r\t",
+			"line separator ",
+			"invalid \xff\xfe utf-8 \xc3",
+			"emoji 🎉 and accents éü",
+			"",
+		} {
+			assertMatchesMarshal(t, fullEvent(s))
+		}
+	})
+
+	t.Run("floats", func(t *testing.T) {
+		for _, f := range []float64{0, 1, -0.5, 123.456, 1e-7, 1e-6, 1e20, 1e21, 5e-324, math.MaxFloat64} {
+			e := event.Event{SampleRate: f}
+			e.Server.Detection.RequestAnalysis.PayloadEntropy = f
+			assertMatchesMarshal(t, e)
+		}
+	})
+
+	t.Run("empty but not nil", func(t *testing.T) {
+		e := event.Event{Props: map[string]string{}}
+		e.Device.Languages = []string{}
+		e.Server.Detection.HeaderAnalysis.HeaderOrder = []string{}
+		assertMatchesMarshal(t, e)
+	})
+
+	t.Run("unsupported floats", func(t *testing.T) {
+		e := event.Event{SampleRate: math.NaN()}
+		if _, err := AppendJSON(nil, &e); err == nil || !strings.Contains(err.Error(), "NaN") {
+			t.Errorf("error = %v, want unsupported NaN", err)
+		}
+	})
+
+	t.Run("appends to buf", func(t *testing.T) {
+		e := event.Event{Type: "click"}
+		got, _ := AppendJSON([]byte("prefix:"), &e)
+		if !strings.HasPrefix(string(got), `prefix:{"type":"click"`) {
+			t.Errorf("got %s", got)
+		}
+	})
+}
+
+func TestAppendJSONAllocs(t *testing.T) {
+	e := fullEvent("value")
+	buf := make([]byte, 0, 64<<10)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = AppendJSON(buf[:0], &e)
+	})
+	if allocs != 0 {
+		t.Errorf("AppendJSON allocated %.1f times per event, want 0", allocs)
+	}
+}
+
+func TestMarshalEvent(t *testing.T) {
+	e := fullEvent("value")
+	want, _ := json.Marshal(e)
+	got, err := MarshalEvent(&e)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("MarshalEvent = %s, %v", got, err)
+	}
+	if cap(got) != len(got) {
+		t.Errorf("cap = %d, want it sized to len %d", cap(got), len(got))
+	}
+
+	// The result must not share the pooled buffer
+	again, _ := MarshalEvent(&event.Event{Type: "other"})
+	if !bytes.Equal(got, want) || bytes.Equal(got, again) {
+		t.Error("MarshalEvent results share memory")
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	b := GetBuffer()
+	*b = append(*b, "data"...)
+	PutBuffer(b)
+	if got := GetBuffer(); len(*got) != 0 {
+		t.Errorf("GetBuffer returned %d bytes, want an empty buffer", len(*got))
+	}
+
+	big := make([]byte, 0, maxPooledBuffer+1)
+	PutBuffer(&big) // dropped rather than pooled
+}
+
+func FuzzAppendJSONString(f *testing.F) {
+	for _, s := range []string{"plain", "<&>", " ", "\xff", "\x00\"\\"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		want, _ := json.Marshal(s)
+		if got := appendJSONString(nil, s); !bytes.Equal(got, want) {
+			t.Errorf("appendJSONString(%q) = %s, want %s", s, got, want)
+		}
+	})
+}
+
+func BenchmarkJSON(b *testing.B) {
+	e := fullEvent("value")
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := json.Marshal(e); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("AppendJSON", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			buf := GetBuffer()
+			out, err := AppendJSON(*buf, &e)
+			if err != nil {
+				b.Fatal(err)
+			}
+			*buf = out
+			PutBuffer(buf)
+		}
+	})
+	b.Run("MarshalEvent", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := MarshalEvent(&e); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,591 @@
+package serde
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Parquet compression codecs (PARQUET_COMPRESSION)
+const (
+	ParquetUncompressed = "none"
+	ParquetSnappy       = "snappy"
+	ParquetGzip         = "gzip"
+	ParquetZstd         = "zstd"
+)
+
+// parquetCodecs maps codec names to their CompressionCodec in parquet.thrift
+var parquetCodecs = map[string]int32{
+	ParquetUncompressed: 0,
+	ParquetSnappy:       1,
+	ParquetGzip:         2,
+	ParquetZstd:         6,
+}
+
+// ValidParquetCompression reports an error for an unknown codec. Empty means snappy.
+func ValidParquetCompression(codec string) error {
+	if _, ok := parquetCodecs[codec]; ok || codec == "" {
+		return nil
+	}
+	return fmt.Errorf("unknown parquet compression %q (want none, snappy, gzip or zstd)", codec)
+}
+
+// Values from parquet.thrift
+const (
+	pqBoolean   int32 = 0
+	pqInt64     int32 = 2
+	pqDouble    int32 = 5
+	pqByteArray int32 = 6
+
+	pqRequired int32 = 0
+	pqOptional int32 = 1
+	pqRepeated int32 = 2
+
+	pqNoAnnotation int32 = -1
+	pqUTF8         int32 = 0
+	pqMap          int32 = 1
+	pqList         int32 = 3
+
+	pqEncodingPlain int32 = 0
+	pqEncodingRLE   int32 = 3
+	pqDataPage      int32 = 0
+)
+
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
+
+var errParquetClosed = errors.New("parquet writer is closed")
+
+type pqKind int
+
+const (
+	pqLeaf pqKind = iota
+	pqGroup
+	pqListOf
+	pqMapOf
+)
+
+// pqNode is one element of the Parquet schema tree derived from event.Event.
+// Structs become groups, pointers optional fields, slices standard 3-level
+// LISTs and maps MAPs, each with its elements in one repeated group.
+type pqNode struct {
+	name       string
+	repetition int32
+	annotation int32 // ConvertedType, or pqNoAnnotation
+	kind       pqKind
+	ptr        bool // the Go value is a pointer
+	field      int  // index in the parent Go struct
+	repLevel   int  // repetition level of a list's or map's entries
+	children   []*pqNode
+	col        *pqColumn // leaves only
+}
+
+// pqColumn buffers one leaf column of the current row group: PLAIN encoded
+// values plus a repetition and definition level per entry
+type pqColumn struct {
+	path     []string
+	physical int32
+	maxDef   int
+	maxRep   int
+
+	values []byte
+	bools  int // booleans packed into values so far
+	defs   []byte
+	reps   []byte
+	count  int // entries, including nulls
+}
+
+// parquetSchema builds the schema tree for event.Event and its leaf columns
+// in file order
+func parquetSchema() (*pqNode, []*pqColumn) {
+	var cols []*pqColumn
+	root := &pqNode{name: "event", annotation: pqNoAnnotation, kind: pqGroup}
+	for _, f := range fieldsOf(reflect.TypeOf(event.Event{})) {
+		child := parquetNode(f.name, f.typ, pqRequired, 0, 0, nil, &cols)
+		child.field = f.index
+		root.children = append(root.children, child)
+	}
+	return root, cols
+}
+
+func parquetNode(name string, t reflect.Type, repetition int32, def, rep int, parent []string, cols *[]*pqColumn) *pqNode {
+	n := &pqNode{name: name, repetition: repetition, annotation: pqNoAnnotation}
+	if t.Kind() == reflect.Ptr {
+		n.ptr = true
+		n.repetition = pqOptional
+		def++
+		t = t.Elem()
+	}
+	path := append(slices.Clip(parent), name)
+
+	leaf := func(physical int32) *pqNode {
+		n.kind = pqLeaf
+		n.col = &pqColumn{path: path, physical: physical, maxDef: def, maxRep: rep}
+		*cols = append(*cols, n.col)
+		return n
+	}
+	switch t.Kind() {
+	case reflect.String:
+		n.annotation = pqUTF8
+		return leaf(pqByteArray)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return leaf(pqInt64)
+	case reflect.Float64, reflect.Float32:
+		return leaf(pqDouble)
+	case reflect.Bool:
+		return leaf(pqBoolean)
+	case reflect.Struct:
+		n.kind = pqGroup
+		for _, f := range fieldsOf(t) {
+			child := parquetNode(f.name, f.typ, pqRequired, def, rep, path, cols)
+			child.field = f.index
+			n.children = append(n.children, child)
+		}
+		return n
+	case reflect.Slice:
+		n.kind, n.annotation, n.repLevel = pqListOf, pqList, rep+1
+		list := &pqNode{name: "list", repetition: pqRepeated, annotation: pqNoAnnotation, kind: pqGroup}
+		list.children = []*pqNode{parquetNode("element", t.Elem(), pqRequired, def+1, rep+1, append(slices.Clip(path), "list"), cols)}
+		n.children = []*pqNode{list}
+		return n
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			break
+		}
+		n.kind, n.annotation, n.repLevel = pqMapOf, pqMap, rep+1
+		kvPath := append(slices.Clip(path), "key_value")
+		kv := &pqNode{name: "key_value", repetition: pqRepeated, annotation: pqNoAnnotation, kind: pqGroup}
+		kv.children = []*pqNode{
+			parquetNode("key", t.Key(), pqRequired, def+1, rep+1, kvPath, cols),
+			parquetNode("value", t.Elem(), pqRequired, def+1, rep+1, kvPath, cols),
+		}
+		n.children = []*pqNode{kv}
+		return n
+	}
+	panic(fmt.Sprintf("serde: unsupported parquet type %s", t))
+}
+
+// shred appends v to the columns under n, following the Dremel record
+// shredding algorithm with r and d as the current levels
+func (n *pqNode) shred(v reflect.Value, r, d int) {
+	if n.ptr {
+		if v.IsNil() {
+			n.null(r, d)
+			return
+		}
+		v = v.Elem()
+		d++
+	}
+	switch n.kind {
+	case pqLeaf:
+		n.col.add(v, r, d)
+	case pqGroup:
+		for _, c := range n.children {
+			c.shred(v.Field(c.field), r, d)
+		}
+	case pqListOf:
+		if v.Len() == 0 {
+			n.null(r, d)
+			return
+		}
+		elem := n.children[0].children[0]
+		for i := 0; i < v.Len(); i++ {
+			elem.shred(v.Index(i), r, d+1)
+			r = n.repLevel
+		}
+	case pqMapOf:
+		if v.Len() == 0 {
+			n.null(r, d)
+			return
+		}
+		key, value := n.children[0].children[0], n.children[0].children[1]
+		for _, k := range sortedKeys(v) {
+			key.shred(k, r, d+1)
+			value.shred(v.MapIndex(k), r, d+1)
+			r = n.repLevel
+		}
+	}
+}
+
+// null records a missing value at level d for every column under n
+func (n *pqNode) null(r, d int) {
+	if n.col != nil {
+		n.col.level(r, d)
+		return
+	}
+	for _, c := range n.children {
+		c.null(r, d)
+	}
+}
+
+func (c *pqColumn) level(r, d int) {
+	if c.maxRep > 0 {
+		c.reps = append(c.reps, byte(r))
+	}
+	if c.maxDef > 0 {
+		c.defs = append(c.defs, byte(d))
+	}
+	c.count++
+}
+
+func (c *pqColumn) add(v reflect.Value, r, d int) {
+	c.level(r, d)
+	switch c.physical {
+	case pqByteArray:
+		s := v.String()
+		c.values = binary.LittleEndian.AppendUint32(c.values, uint32(len(s)))
+		c.values = append(c.values, s...)
+	case pqInt64:
+		c.values = binary.LittleEndian.AppendUint64(c.values, uint64(v.Int()))
+	case pqDouble:
+		c.values = binary.LittleEndian.AppendUint64(c.values, math.Float64bits(v.Float()))
+	case pqBoolean:
+		// Bit-packed, least significant bit first
+		if c.bools%8 == 0 {
+			c.values = append(c.values, 0)
+		}
+		if v.Bool() {
+			c.values[len(c.values)-1] |= 1 << (c.bools % 8)
+		}
+		c.bools++
+	}
+}
+
+func (c *pqColumn) reset() {
+	c.values, c.defs, c.reps = c.values[:0], c.defs[:0], c.reps[:0]
+	c.bools, c.count = 0, 0
+}
+
+// appendLevels appends levels in the RLE/bit-packed hybrid encoding, as RLE
+// runs only, behind the 4-byte length a v1 data page expects. Levels fit in
+// one byte, so each run is its length and one value byte.
+func appendLevels(buf, levels []byte) []byte {
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0)
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		buf = binary.AppendUvarint(buf, uint64(j-i)<<1)
+		buf = append(buf, levels[i])
+		i = j
+	}
+	binary.LittleEndian.PutUint32(buf[start:], uint32(len(buf)-start-4))
+	return buf
+}
+
+// ParquetSchema returns the Parquet schema of event.Event in the message
+// syntax parquet tools print, for documentation and debugging
+func ParquetSchema() string {
+	root, _ := parquetSchema()
+	var b strings.Builder
+	b.WriteString("message event {\n")
+	for _, c := range root.children {
+		writeParquetSchema(&b, c, "  ")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func writeParquetSchema(b *strings.Builder, n *pqNode, indent string) {
+	b.WriteString(indent)
+	b.WriteString([...]string{"required", "optional", "repeated"}[n.repetition])
+	if n.kind == pqLeaf {
+		b.WriteString(map[int32]string{pqBoolean: " boolean ", pqInt64: " int64 ", pqDouble: " double ", pqByteArray: " binary "}[n.col.physical])
+	} else {
+		b.WriteString(" group ")
+	}
+	b.WriteString(n.name)
+	if n.annotation != pqNoAnnotation {
+		b.WriteString(map[int32]string{pqUTF8: " (UTF8)", pqMap: " (MAP)", pqList: " (LIST)"}[n.annotation])
+	}
+	if n.kind == pqLeaf {
+		b.WriteString(";\n")
+		return
+	}
+	b.WriteString(" {\n")
+	for _, c := range n.children {
+		writeParquetSchema(b, c, indent+"  ")
+	}
+	b.WriteString(indent + "}\n")
+}
+
+// ParquetOptions configures a ParquetWriter
+type ParquetOptions struct {
+	RowGroupRows int    // rows buffered per row group; 10000 when zero
+	Compression  string // none, snappy, gzip or zstd; snappy when empty
+}
+
+// ParquetWriter writes events to a Parquet file. Rows are buffered in memory
+// per column until a row group fills, then written as one data page per
+// column, so memory stays bounded by the row group size. The footer is
+// written by Close.
+type ParquetWriter struct {
+	w      io.Writer
+	opts   ParquetOptions
+	codec  int32
+	zstd   *zstd.Encoder
+	root   *pqNode
+	cols   []*pqColumn
+	offset int64
+	rows   int64
+	groups []pqRowGroup
+	buffer int // rows in the current row group
+	page   []byte
+	err    error
+}
+
+type pqRowGroup struct {
+	rows   int64
+	chunks []pqChunk
+}
+
+type pqChunk struct {
+	offset       int64
+	values       int
+	compressed   int64
+	uncompressed int64
+}
+
+// NewParquetWriter starts a Parquet file on w
+func NewParquetWriter(w io.Writer, opts ParquetOptions) (*ParquetWriter, error) {
+	if err := ValidParquetCompression(opts.Compression); err != nil {
+		return nil, err
+	}
+	if opts.Compression == "" {
+		opts.Compression = ParquetSnappy
+	}
+	if opts.RowGroupRows <= 0 {
+		opts.RowGroupRows = 10000
+	}
+	pw := &ParquetWriter{w: w, opts: opts, codec: parquetCodecs[opts.Compression]}
+	if opts.Compression == ParquetZstd {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		pw.zstd = enc
+	}
+	pw.root, pw.cols = parquetSchema()
+	if err := pw.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// Write buffers e as the next row, writing a row group once it is full
+func (w *ParquetWriter) Write(e *event.Event) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.root.shred(reflect.ValueOf(e).Elem(), 0, 0)
+	w.buffer++
+	w.rows++
+	if w.buffer >= w.opts.RowGroupRows {
+		return w.flushRowGroup()
+	}
+	return nil
+}
+
+// Rows returns how many events have been written, including buffered ones
+func (w *ParquetWriter) Rows() int64 { return w.rows }
+
+// Size returns the bytes written to the underlying writer so far, which
+// excludes the current row group
+func (w *ParquetWriter) Size() int64 { return w.offset }
+
+// Close writes the buffered row group and the footer. It does not close the
+// underlying writer.
+func (w *ParquetWriter) Close() error {
+	if w.zstd != nil {
+		defer w.zstd.Close()
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if w.buffer > 0 {
+		if err := w.flushRowGroup(); err != nil {
+			return err
+		}
+	}
+	footer := w.footer()
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, parquetMagic...)
+	if err := w.write(footer); err != nil {
+		return err
+	}
+	w.err = errParquetClosed
+	return nil
+}
+
+func (w *ParquetWriter) write(b []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
+
+// flushRowGroup writes each buffered column as a single v1 data page
+func (w *ParquetWriter) flushRowGroup() error {
+	group := pqRowGroup{rows: int64(w.buffer), chunks: make([]pqChunk, len(w.cols))}
+	for i, c := range w.cols {
+		page := w.page[:0]
+		if c.maxRep > 0 {
+			page = appendLevels(page, c.reps)
+		}
+		if c.maxDef > 0 {
+			page = appendLevels(page, c.defs)
+		}
+		page = append(page, c.values...)
+		w.page = page
+
+		body, err := w.compress(page)
+		if err != nil {
+			w.err = err
+			return err
+		}
+		var h thriftWriter
+		h.beginStruct(0)
+		h.i32(1, pqDataPage)
+		h.i32(2, int32(len(page)))
+		h.i32(3, int32(len(body)))
+		h.beginStruct(5)
+		h.i32(1, int32(c.count))
+		h.i32(2, pqEncodingPlain)
+		h.i32(3, pqEncodingRLE)
+		h.i32(4, pqEncodingRLE)
+		h.endStruct()
+		h.endStruct()
+
+		group.chunks[i] = pqChunk{
+			offset:       w.offset,
+			values:       c.count,
+			compressed:   int64(len(h.buf) + len(body)),
+			uncompressed: int64(len(h.buf) + len(page)),
+		}
+		if err := w.write(h.buf); err != nil {
+			return err
+		}
+		if err := w.write(body); err != nil {
+			return err
+		}
+		c.reset()
+	}
+	w.groups = append(w.groups, group)
+	w.buffer = 0
+	return nil
+}
+
+func (w *ParquetWriter) compress(page []byte) ([]byte, error) {
+	switch w.opts.Compression {
+	case ParquetSnappy:
+		return snappy.Encode(nil, page), nil
+	case ParquetZstd:
+		return w.zstd.EncodeAll(page, nil), nil
+	case ParquetGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(page); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return page, nil
+}
+
+// footer encodes the FileMetaData struct
+func (w *ParquetWriter) footer() []byte {
+	var t thriftWriter
+	t.beginStruct(0)
+	t.i32(1, 1) // format version
+
+	var elements []*pqNode
+	var walk func(n *pqNode)
+	walk = func(n *pqNode) {
+		elements = append(elements, n)
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(w.root)
+	t.list(2, thriftStruct, len(elements))
+	for _, n := range elements {
+		t.beginStruct(0)
+		if n.kind == pqLeaf {
+			t.i32(1, n.col.physical)
+		}
+		if n != w.root {
+			t.i32(3, n.repetition)
+		}
+		t.str(4, n.name)
+		if n.kind != pqLeaf {
+			t.i32(5, int32(len(n.children)))
+		}
+		if n.annotation != pqNoAnnotation {
+			t.i32(6, n.annotation)
+		}
+		t.endStruct()
+	}
+
+	t.i64(3, w.rows)
+	t.list(4, thriftStruct, len(w.groups))
+	for _, g := range w.groups {
+		var compressed, uncompressed int64
+		t.beginStruct(0)
+		t.list(1, thriftStruct, len(g.chunks))
+		for i, ch := range g.chunks {
+			c := w.cols[i]
+			t.beginStruct(0)
+			t.i64(2, ch.offset)
+			t.beginStruct(3)
+			t.i32(1, c.physical)
+			t.list(2, thriftI32, 2)
+			t.listI32(pqEncodingPlain)
+			t.listI32(pqEncodingRLE)
+			t.list(3, thriftBinary, len(c.path))
+			for _, p := range c.path {
+				t.listStr(p)
+			}
+			t.i32(4, w.codec)
+			t.i64(5, int64(ch.values))
+			t.i64(6, ch.uncompressed)
+			t.i64(7, ch.compressed)
+			t.i64(9, ch.offset)
+			t.endStruct()
+			t.endStruct()
+			compressed += ch.compressed
+			uncompressed += ch.uncompressed
+		}
+		t.i64(2, uncompressed)
+		t.i64(3, g.rows)
+		if len(g.chunks) > 0 {
+			t.i64(5, g.chunks[0].offset)
+		}
+		t.i64(6, compressed)
+		t.endStruct()
+	}
+	t.str(6, "gotrack")
+	t.endStruct()
+	return t.buf
+}
@@ -0,0 +1,447 @@
+package serde
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// The reader below is just enough of the Parquet format to check what the
+// writer produced: the thrift footer, the schema and v1 data pages with
+// RLE levels and PLAIN values.
+
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) byte() byte {
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v, n := binary.Varint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case 1, 2:
+		return typ == 1
+	case 5, 6:
+		return r.varint()
+	case 8:
+		n := int(r.uvarint())
+		s := string(r.buf[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case 9:
+		h := r.byte()
+		size, elem := int(h>>4), h&0x0f
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case 12:
+		return r.structure()
+	}
+	panic(fmt.Sprintf("unexpected thrift type %d", typ))
+}
+
+func (r *thriftReader) structure() map[int16]any {
+	fields := map[int16]any{}
+	var last int16
+	for {
+		h := r.byte()
+		if h == 0 {
+			return fields
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.varint())
+		}
+		fields[id] = r.value(h & 0x0f)
+		last = id
+	}
+}
+
+type parquetFile struct {
+	data   []byte
+	footer map[int16]any
+	levels map[string][2]int // max repetition and definition level by column path
+}
+
+func readParquet(t *testing.T, data []byte) *parquetFile {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte(parquetMagic)) || !bytes.HasSuffix(data, []byte(parquetMagic)) {
+		t.Fatal("missing PAR1 magic")
+	}
+	n := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	r := &thriftReader{buf: data[len(data)-8-n : len(data)-8]}
+	f := &parquetFile{data: data, footer: r.structure(), levels: map[string][2]int{}}
+	if r.pos != n {
+		t.Fatalf("footer is %d bytes, parsed %d", n, r.pos)
+	}
+
+	// Walk the flattened schema to find each leaf's maximum levels
+	schema := f.footer[2].([]any)
+	pos := 1
+	var walk func(path []string, rep, def int)
+	walk = func(path []string, rep, def int) {
+		el := schema[pos].(map[int16]any)
+		pos++
+		path = append(slices.Clip(path), el[4].(string))
+		switch el[3].(int64) {
+		case int64(pqOptional):
+			def++
+		case int64(pqRepeated):
+			rep++
+			def++
+		}
+		children, ok := el[5].(int64)
+		if !ok {
+			f.levels[strings.Join(path, ".")] = [2]int{rep, def}
+			return
+		}
+		for range children {
+			walk(path, rep, def)
+		}
+	}
+	for range schema[0].(map[int16]any)[5].(int64) {
+		walk(nil, 0, 0)
+	}
+	if pos != len(schema) {
+		t.Fatalf("schema has %d elements, walked %d", len(schema), pos)
+	}
+	return f
+}
+
+func (f *parquetFile) rowGroups() []map[int16]any {
+	var groups []map[int16]any
+	for _, g := range f.footer[4].([]any) {
+		groups = append(groups, g.(map[int16]any))
+	}
+	return groups
+}
+
+// column decodes one column chunk of row group g
+func (f *parquetFile) column(t *testing.T, g int, path string) (reps, defs []int, values []any) {
+	t.Helper()
+	var meta map[int16]any
+	for _, c := range f.rowGroups()[g][1].([]any) {
+		m := c.(map[int16]any)[3].(map[int16]any)
+		var parts []string
+		for _, p := range m[3].([]any) {
+			parts = append(parts, p.(string))
+		}
+		if strings.Join(parts, ".") == path {
+			meta = m
+		}
+	}
+	if meta == nil {
+		t.Fatalf("no column %s", path)
+	}
+
+	offset := int(meta[9].(int64))
+	r := &thriftReader{buf: f.data[offset:]}
+	header := r.structure()
+	page := header[5].(map[int16]any)
+	body := f.data[offset+r.pos : offset+r.pos+int(header[3].(int64))]
+	if got := int64(r.pos) + header[3].(int64); got != meta[7].(int64) {
+		t.Errorf("%s: total_compressed_size = %d, header and page are %d", path, meta[7], got)
+	}
+	var err error
+	switch meta[4].(int64) {
+	case 1:
+		body, err = snappy.Decode(nil, body)
+	case 2:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(body)); err == nil {
+			body, err = io.ReadAll(zr)
+		}
+	case 6:
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(nil); err == nil {
+			body, err = zr.DecodeAll(body, nil)
+		}
+	}
+	if err != nil {
+		t.Fatalf("%s: decompress: %v", path, err)
+	}
+	if int64(len(body)) != header[2].(int64) {
+		t.Fatalf("%s: page is %d bytes, header says %d", path, len(body), header[2])
+	}
+
+	count := int(page[1].(int64))
+	levels := f.levels[path]
+	readLevels := func(max int) []int {
+		out := make([]int, count)
+		if max == 0 {
+			return out
+		}
+		n := int(binary.LittleEndian.Uint32(body))
+		lr := &thriftReader{buf: body[4 : 4+n]}
+		body = body[4+n:]
+		out = out[:0]
+		for lr.pos < n {
+			h := lr.uvarint()
+			if h&1 != 0 {
+				t.Fatalf("%s: unexpected bit-packed run", path)
+			}
+			v := int(lr.byte())
+			for range h >> 1 {
+				out = append(out, v)
+			}
+		}
+		if len(out) != count {
+			t.Fatalf("%s: %d levels for %d values", path, len(out), count)
+		}
+		return out
+	}
+	reps = readLevels(levels[0])
+	defs = readLevels(levels[1])
+	if levels[1] == 0 {
+		for i := range defs {
+			defs[i] = 0
+		}
+	}
+
+	bit := 0
+	for _, d := range defs {
+		if d < levels[1] {
+			continue
+		}
+		switch meta[1].(int64) {
+		case int64(pqByteArray):
+			n := int(binary.LittleEndian.Uint32(body))
+			values = append(values, string(body[4:4+n]))
+			body = body[4+n:]
+		case int64(pqInt64):
+			values = append(values, int64(binary.LittleEndian.Uint64(body)))
+			body = body[8:]
+		case int64(pqDouble):
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(body)))
+			body = body[8:]
+		case int64(pqBoolean):
+			values = append(values, body[bit/8]&(1<<(bit%8)) != 0)
+			bit++
+		}
+	}
+	if bit > 0 {
+		body = body[(bit+7)/8:]
+	}
+	if len(body) != 0 {
+		t.Errorf("%s: %d bytes left after the values", path, len(body))
+	}
+	return reps, defs, values
+}
+
+func writeParquet(t *testing.T, opts ParquetOptions, events ...event.Event) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewParquetWriter(&buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range events {
+		if err := w.Write(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.Rows() != int64(len(events)) {
+		t.Errorf("Rows() = %d", w.Rows())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParquetWriter(t *testing.T) {
+	mobile := false
+	sparse := event.Event{EventID: "e2", Type: "click", Props: map[string]string{"b": "2", "a": "1"}}
+	sparse.Device.UAMobile = &mobile
+	sparse.Device.Languages = []string{"en-US"}
+	sparse.Device.Screens = []event.ScreenInfo{{Width: 1920}, {Width: 1280}}
+	events := []event.Event{fullEvent("full"), {EventID: "e1"}, sparse}
+
+	f := readParquet(t, writeParquet(t, ParquetOptions{}, events...))
+	if f.footer[3].(int64) != 3 || len(f.rowGroups()) != 1 {
+		t.Fatalf("num_rows = %v, row groups = %d", f.footer[3], len(f.rowGroups()))
+	}
+	_, cols := parquetSchema()
+	if len(f.levels) != len(cols) {
+		t.Errorf("schema has %d leaves, want %d", len(f.levels), len(cols))
+	}
+
+	check := func(path string, wantReps, wantDefs []int, wantValues []any) {
+		t.Helper()
+		reps, defs, values := f.column(t, 0, path)
+		if !slices.Equal(reps, wantReps) || !slices.Equal(defs, wantDefs) || fmt.Sprint(values) != fmt.Sprint(wantValues) {
+			t.Errorf("%s:\n got reps %v defs %v values %v\nwant reps %v defs %v values %v", path, reps, defs, values, wantReps, wantDefs, wantValues)
+		}
+	}
+	check("event_id", []int{0, 0, 0}, []int{0, 0, 0}, []any{"full", "e1", "e2"})
+	check("device.ua_mobile", []int{0, 0, 0}, []int{1, 0, 1}, []any{true, false})
+	check("device.languages.list.element", []int{0, 1, 0, 0}, []int{1, 1, 0, 1}, []any{"full", "full2", "en-US"})
+	check("device.screens.list.element.width", []int{0, 1, 0, 0, 1}, []int{1, 1, 0, 1, 1}, []any{int64(events[0].Device.Screens[0].Width), int64(events[0].Device.Screens[1].Width), int64(1920), int64(1280)})
+	check("props.key_value.key", []int{0, 1, 1, 0, 0, 1}, []int{1, 1, 1, 0, 1, 1}, []any{"alpha", "full", "zeta", "a", "b"})
+	check("props.key_value.value", []int{0, 1, 1, 0, 0, 1}, []int{1, 1, 1, 0, 1, 1}, []any{"alphafull", "fullfull", "zetafull", "1", "2"})
+	check("server.duplicate", []int{0, 0, 0}, []int{0, 0, 0}, []any{true, false, false})
+
+	// Every column holds one record per row, and a value for each entry
+	// defined at its maximum level
+	for path := range f.levels {
+		reps, _, _ := f.column(t, 0, path)
+		rows := 0
+		for _, r := range reps {
+			if r == 0 {
+				rows++
+			}
+		}
+		if rows != 3 {
+			t.Errorf("%s has %d records, want 3", path, rows)
+		}
+	}
+}
+
+func TestParquetWriterFloats(t *testing.T) {
+	events := []event.Event{{SampleRate: 0.25}, {SampleRate: math.MaxFloat64}, {SampleRate: -1}}
+	f := readParquet(t, writeParquet(t, ParquetOptions{Compression: ParquetUncompressed}, events...))
+	_, _, values := f.column(t, 0, "sample_rate")
+	if fmt.Sprint(values) != fmt.Sprint([]any{0.25, math.MaxFloat64, -1.0}) {
+		t.Errorf("sample_rate = %v", values)
+	}
+}
+
+func TestParquetWriterRowGroups(t *testing.T) {
+	var events []event.Event
+	for i := range 5 {
+		events = append(events, event.Event{EventID: fmt.Sprint("e", i)})
+	}
+	f := readParquet(t, writeParquet(t, ParquetOptions{RowGroupRows: 2}, events...))
+
+	groups := f.rowGroups()
+	if len(groups) != 3 {
+		t.Fatalf("row groups = %d, want 3", len(groups))
+	}
+	var ids []any
+	for i, g := range groups {
+		if want := []int64{2, 2, 1}[i]; g[3].(int64) != want {
+			t.Errorf("row group %d has %d rows, want %d", i, g[3], want)
+		}
+		_, _, values := f.column(t, i, "event_id")
+		ids = append(ids, values...)
+	}
+	if fmt.Sprint(ids) != "[e0 e1 e2 e3 e4]" {
+		t.Errorf("event_id across row groups = %v", ids)
+	}
+}
+
+func TestParquetWriterCompression(t *testing.T) {
+	for codec, id := range parquetCodecs {
+		t.Run(codec, func(t *testing.T) {
+			f := readParquet(t, writeParquet(t, ParquetOptions{Compression: codec}, fullEvent("compressed"), fullEvent("again")))
+			meta := f.rowGroups()[0][1].([]any)[0].(map[int16]any)[3].(map[int16]any)
+			if meta[4].(int64) != int64(id) {
+				t.Errorf("codec = %v, want %d", meta[4], id)
+			}
+			if _, _, values := f.column(t, 0, "route.path"); fmt.Sprint(values) != "[compressed again]" {
+				t.Errorf("route.path = %v", values)
+			}
+		})
+	}
+
+	if _, err := NewParquetWriter(io.Discard, ParquetOptions{Compression: "lz4"}); err == nil {
+		t.Error("unknown codec should fail")
+	}
+}
+
+func TestParquetWriterEmpty(t *testing.T) {
+	f := readParquet(t, writeParquet(t, ParquetOptions{}))
+	if f.footer[3].(int64) != 0 || len(f.rowGroups()) != 0 {
+		t.Errorf("num_rows = %v, row groups = %d", f.footer[3], len(f.rowGroups()))
+	}
+}
+
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n -= len(p); w.n < 0 {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func TestParquetWriterError(t *testing.T) {
+	w, err := NewParquetWriter(&failingWriter{n: 100}, ParquetOptions{RowGroupRows: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := fullEvent("x")
+	if err := w.Write(&e); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("Write() = %v, want disk full", err)
+	}
+	if err := w.Close(); err == nil {
+		t.Error("Close() after a failed write should fail")
+	}
+}
+
+func TestParquetSchema(t *testing.T) {
+	schema := ParquetSchema()
+	for _, want := range []string{
+		"message event {",
+		"  required binary event_id (UTF8);",
+		"    optional boolean ua_mobile;",
+		"    required group languages (LIST) {\n      repeated group list {\n        required binary element (UTF8);",
+		"  required group props (MAP) {\n    repeated group key_value {\n      required binary key (UTF8);\n      required binary value (UTF8);",
+		"  required double sample_rate;",
+		"      required int64 width;",
+	} {
+		if !strings.Contains(schema, want) {
+			t.Errorf("schema missing %q:\n%s", want, schema)
+		}
+	}
+}
+
+func BenchmarkParquetWrite(b *testing.B) {
+	e := fullEvent("value")
+	w, err := NewParquetWriter(io.Discard, ParquetOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for range b.N {
+		if err := w.Write(&e); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+}
@@ -0,0 +1,135 @@
+package serde
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/shortontech/gotrack/pkg/event"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoPackage is the package of the generated Protobuf schema
+const protoPackage = "gotrack.v1"
+
+// ProtoSchema returns a proto3 schema for event.Event. Field numbers follow
+// declaration order, starting at 1, so new fields must be appended.
+func ProtoSchema() string {
+	var b strings.Builder
+	b.WriteString("syntax = \"proto3\";\n\npackage " + protoPackage + ";\n")
+
+	// Event must be the first message: the wire format refers to it by index
+	queue := []reflect.Type{reflect.TypeOf(event.Event{})}
+	seen := map[string]bool{queue[0].Name(): true}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+
+		fmt.Fprintf(&b, "\nmessage %s {\n", t.Name())
+		for i, f := range fieldsOf(t) {
+			fmt.Fprintf(&b, "  %s %s = %d;\n", protoType(f.typ), f.name, i+1)
+
+			if nested := protoMessage(f.typ); nested != nil && !seen[nested.Name()] {
+				seen[nested.Name()] = true
+				queue = append(queue, nested)
+			}
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// protoType returns the field type declaration for a Go type
+func protoType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return "int64"
+	case reflect.Float64, reflect.Float32:
+		return "double"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Ptr:
+		return "optional " + protoType(t.Elem())
+	case reflect.Slice:
+		return "repeated " + protoType(t.Elem())
+	case reflect.Map:
+		return fmt.Sprintf("map<%s, %s>", protoType(t.Key()), protoType(t.Elem()))
+	case reflect.Struct:
+		return t.Name()
+	}
+	panic(fmt.Sprintf("serde: unsupported protobuf type %s", t))
+}
+
+// protoMessage returns the struct type a field refers to, if any
+func protoMessage(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct {
+		return t
+	}
+	return nil
+}
+
+// appendProto appends the proto3 encoding of struct v. Zero scalars are
+// omitted, matching proto3 default semantics; pointers keep explicit presence.
+func appendProto(buf []byte, v reflect.Value) []byte {
+	for i, f := range fieldsOf(v.Type()) {
+		buf = appendProtoField(buf, protowire.Number(i+1), v.Field(f.index))
+	}
+	return buf
+}
+
+func appendProtoField(buf []byte, num protowire.Number, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return buf
+		}
+		return appendProtoValue(buf, num, v.Elem())
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			buf = appendProtoValue(buf, num, v.Index(i))
+		}
+		return buf
+	case reflect.Map:
+		// Map entries are messages with the key as field 1 and value as field 2
+		for _, k := range sortedKeys(v) {
+			var entry []byte
+			entry = appendProtoValue(entry, 1, k)
+			entry = appendProtoValue(entry, 2, v.MapIndex(k))
+			buf = protowire.AppendTag(buf, num, protowire.BytesType)
+			buf = protowire.AppendBytes(buf, entry)
+		}
+		return buf
+	}
+	if v.IsZero() {
+		return buf
+	}
+	return appendProtoValue(buf, num, v)
+}
+
+// appendProtoValue appends one tagged value, including zero values
+func appendProtoValue(buf []byte, num protowire.Number, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.String:
+		buf = protowire.AppendTag(buf, num, protowire.BytesType)
+		return protowire.AppendString(buf, v.String())
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		buf = protowire.AppendTag(buf, num, protowire.VarintType)
+		return protowire.AppendVarint(buf, uint64(v.Int()))
+	case reflect.Float64, reflect.Float32:
+		buf = protowire.AppendTag(buf, num, protowire.Fixed64Type)
+		return protowire.AppendFixed64(buf, math.Float64bits(v.Float()))
+	case reflect.Bool:
+		buf = protowire.AppendTag(buf, num, protowire.VarintType)
+		return protowire.AppendVarint(buf, protowire.EncodeBool(v.Bool()))
+	case reflect.Struct:
+		buf = protowire.AppendTag(buf, num, protowire.BytesType)
+		return protowire.AppendBytes(buf, appendProto(nil, v))
+	}
+	panic(fmt.Sprintf("serde: unsupported protobuf value %s", v.Type()))
+}
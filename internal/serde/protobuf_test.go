@@ -0,0 +1,90 @@
+package serde
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestProtoSchema(t *testing.T) {
+	schema := ProtoSchema()
+	if !strings.HasPrefix(schema, "syntax = \"proto3\";\n\npackage gotrack.v1;\n\nmessage Event {\n") {
+		t.Errorf("schema should open with Event:\n%s", schema)
+	}
+	for _, want := range []string{
+		"  string event_id = 1;\n",
+		"  URLInfo url = 5;\n",
+		"  map<string, string> other_click_ids = 5;\n",
+		"  repeated ScreenInfo screens = ",
+		"  optional bool ua_mobile = 3;\n",
+		"message ServerDetectionSignals {\n",
+	} {
+		if !strings.Contains(schema, want) {
+			t.Errorf("schema missing %q", want)
+		}
+	}
+	if strings.Count(schema, "message ScreenInfo {") != 1 {
+		t.Error("each message should be declared once")
+	}
+}
+
+func TestAppendProto(t *testing.T) {
+	flag := false
+	v := avroSample{
+		Name:   "ab",
+		Count:  -2,
+		Flag:   &flag,
+		Tags:   []string{"x", "y"},
+		Labels: map[string]string{"k": "v"},
+	}
+	buf := appendProto(nil, reflect.ValueOf(v))
+
+	type wireField struct {
+		num protowire.Number
+		typ protowire.Type
+		val []byte
+		n   uint64
+	}
+	var fields []wireField
+	for len(buf) > 0 {
+		num, typ, n := protowire.ConsumeTag(buf)
+		buf = buf[n:]
+		f := wireField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.n, n = protowire.ConsumeVarint(buf)
+		case protowire.BytesType:
+			f.val, n = protowire.ConsumeBytes(buf)
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+		if n < 0 {
+			t.Fatalf("malformed field %d", num)
+		}
+		buf = buf[n:]
+		fields = append(fields, f)
+	}
+
+	// Zero ratio and ok are omitted; the explicit false flag is kept
+	if len(fields) != 6 {
+		t.Fatalf("got %d fields, want 6: %+v", len(fields), fields)
+	}
+	if fields[0].num != 1 || string(fields[0].val) != "ab" {
+		t.Errorf("name = %+v", fields[0])
+	}
+	if fields[1].num != 2 || int64(fields[1].n) != -2 {
+		t.Errorf("count = %+v", fields[1])
+	}
+	if fields[2].num != 5 || fields[2].n != 0 {
+		t.Errorf("flag = %+v, want explicit false", fields[2])
+	}
+	if fields[3].num != 6 || string(fields[3].val) != "x" || string(fields[4].val) != "y" {
+		t.Errorf("tags = %+v %+v", fields[3], fields[4])
+	}
+	wantEntry := []byte{0x0a, 1, 'k', 0x12, 1, 'v'}
+	if fields[5].num != 7 || string(fields[5].val) != string(wantEntry) {
+		t.Errorf("labels entry = %v, want %v", fields[5].val, wantEntry)
+	}
+}
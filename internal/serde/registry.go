@@ -0,0 +1,86 @@
+package serde
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// registryContentType is the Schema Registry API media type
+const registryContentType = "application/vnd.schemaregistry.v1+json"
+
+// Registry is a minimal Confluent Schema Registry client: it registers
+// schemas and returns their IDs. The registry assigns a new version when the
+// schema changes and rejects changes that break the subject's compatibility
+// setting.
+type Registry struct {
+	URL      string
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+// NewRegistry creates a client for the registry at baseURL
+func NewRegistry(baseURL, username, password string) *Registry {
+	return &Registry{
+		URL:      strings.TrimRight(baseURL, "/"),
+		Username: username,
+		Password: password,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Register registers schema under subject and returns its global ID.
+// Registering an existing schema returns the existing ID.
+func (r *Registry) Register(ctx context.Context, subject, schemaType, schema string) (int, error) {
+	body, err := json.Marshal(map[string]string{"schema": schema, "schemaType": schemaType})
+	if err != nil {
+		return 0, err
+	}
+
+	endpoint := r.URL + "/subjects/" + url.PathEscape(subject) + "/versions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", registryContentType)
+	req.Header.Set("Accept", registryContentType)
+	if r.Username != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("schema registry request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema registry response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			ErrorCode int    `json:"error_code"`
+			Message   string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return 0, fmt.Errorf("schema registry rejected %s (status %d, code %d): %s", subject, resp.StatusCode, apiErr.ErrorCode, apiErr.Message)
+		}
+		return 0, fmt.Errorf("schema registry rejected %s: status %d", subject, resp.StatusCode)
+	}
+
+	var out struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return 0, fmt.Errorf("invalid schema registry response: %w", err)
+	}
+	return out.ID, nil
+}
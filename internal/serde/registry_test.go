@@ -0,0 +1,64 @@
+package serde
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryRegister(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/subjects/gotrack.events-value/versions" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != registryContentType {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "sr-user" || pass != "sr-pass" {
+			t.Errorf("basic auth = %q/%q/%v", user, pass, ok)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid body: %v", err)
+		}
+		if body["schemaType"] != "AVRO" || body["schema"] != `{"type":"string"}` {
+			t.Errorf("body = %v", body)
+		}
+		_, _ = w.Write([]byte(`{"id":7}`))
+	}))
+	defer srv.Close()
+
+	r := NewRegistry(srv.URL+"/", "sr-user", "sr-pass")
+	id, err := r.Register(context.Background(), "gotrack.events-value", "AVRO", `{"type":"string"}`)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if id != 7 {
+		t.Errorf("id = %d, want 7", id)
+	}
+}
+
+func TestRegistryRegister_Incompatible(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error_code":409,"message":"Schema being registered is incompatible with an earlier schema"}`))
+	}))
+	defer srv.Close()
+
+	_, err := NewRegistry(srv.URL, "", "").Register(context.Background(), "s", "AVRO", "{}")
+	if err == nil || !strings.Contains(err.Error(), "incompatible") {
+		t.Errorf("Register() error = %v, want incompatibility error", err)
+	}
+}
+
+func TestRegistryRegister_Unreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	if _, err := NewRegistry(srv.URL, "", "").Register(context.Background(), "s", "AVRO", "{}"); err == nil {
+		t.Error("Register() should fail when the registry is down")
+	}
+}
@@ -0,0 +1,142 @@
+// Package serde serializes events for the sinks. Besides plain JSON it
+// supports Avro and Protobuf in the Confluent wire format for Kafka, with
+// schemas generated from the event.Event struct and registered with a
+// Confluent Schema Registry, and Parquet files for the parquet sink.
+//
+// Generated schemas follow the struct declaration order. Adding a field to
+// the end of a struct yields a compatible new schema version; reordering or
+// removing fields does not.
+package serde
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Supported serialization formats (KAFKA_SERIALIZATION)
+const (
+	FormatJSON     = "json"
+	FormatAvro     = "avro"
+	FormatProtobuf = "protobuf"
+)
+
+// ValidFormat reports an error for an unknown format. Empty means JSON.
+func ValidFormat(format string) error {
+	switch format {
+	case "", FormatJSON, FormatAvro, FormatProtobuf:
+		return nil
+	}
+	return fmt.Errorf("unknown serialization format %q (want json, avro or protobuf)", format)
+}
+
+// Serializer encodes events into Kafka message values
+type Serializer interface {
+	Serialize(e event.Event) ([]byte, error)
+	// Format returns the format name, sent as a message header
+	Format() string
+}
+
+// New returns a serializer for format. Avro and Protobuf register the event
+// schema under subject with the registry and fail without one.
+func New(ctx context.Context, format string, registry *Registry, subject string) (Serializer, error) {
+	if err := ValidFormat(format); err != nil {
+		return nil, err
+	}
+	if format == "" || format == FormatJSON {
+		return jsonSerializer{}, nil
+	}
+	if registry == nil {
+		return nil, fmt.Errorf("%s serialization requires KAFKA_SCHEMA_REGISTRY_URL", format)
+	}
+
+	schemaType, schema := "AVRO", AvroSchema()
+	if format == FormatProtobuf {
+		schemaType, schema = "PROTOBUF", ProtoSchema()
+	}
+	id, err := registry.Register(ctx, subject, schemaType, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register %s schema: %w", format, err)
+	}
+	return &wireSerializer{format: format, id: id}, nil
+}
+
+// NewWithSchemaID returns a serializer that frames payloads with a schema ID
+// registered out of band, without contacting a registry
+func NewWithSchemaID(format string, id int) (Serializer, error) {
+	if err := ValidFormat(format); err != nil {
+		return nil, err
+	}
+	if format == "" || format == FormatJSON {
+		return jsonSerializer{}, nil
+	}
+	return &wireSerializer{format: format, id: id}, nil
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Serialize(e event.Event) ([]byte, error) { return MarshalEvent(&e) }
+func (jsonSerializer) Format() string                          { return FormatJSON }
+
+// wireSerializer frames payloads in the Confluent wire format: a zero magic
+// byte, the big-endian schema ID, then (for Protobuf) the message index path
+type wireSerializer struct {
+	format string
+	id     int
+}
+
+func (s *wireSerializer) Serialize(e event.Event) ([]byte, error) {
+	buf := make([]byte, 5, 256)
+	binary.BigEndian.PutUint32(buf[1:], uint32(s.id))
+	if s.format == FormatProtobuf {
+		// Event is the first message in the schema; its index path [0]
+		// is encoded as a single zero byte
+		buf = append(buf, 0)
+		return appendProto(buf, reflect.ValueOf(e)), nil
+	}
+	return appendAvro(buf, reflect.ValueOf(e)), nil
+}
+
+func (s *wireSerializer) Format() string { return s.format }
+
+// field is an exported struct field as it appears in the JSON encoding
+type field struct {
+	name      string
+	index     int
+	typ       reflect.Type
+	omitEmpty bool
+}
+
+// fieldCache holds fieldsOf results, since every event walks the same types
+var fieldCache sync.Map // reflect.Type -> []field
+
+// fieldsOf lists the serialized fields of struct type t in declaration order
+func fieldsOf(t reflect.Type) []field {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]field)
+	}
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		omitEmpty := slices.Contains(strings.Split(opts, ","), "omitempty")
+		fields = append(fields, field{name: name, index: i, typ: f.Type, omitEmpty: omitEmpty})
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
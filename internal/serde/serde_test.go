@@ -0,0 +1,151 @@
+package serde
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestValidFormat(t *testing.T) {
+	for _, f := range []string{"", FormatJSON, FormatAvro, FormatProtobuf} {
+		if err := ValidFormat(f); err != nil {
+			t.Errorf("ValidFormat(%q) = %v", f, err)
+		}
+	}
+	if err := ValidFormat("thrift"); err == nil {
+		t.Error("ValidFormat(thrift) should fail")
+	}
+}
+
+func TestNew_JSON(t *testing.T) {
+	s, err := New(context.Background(), "", nil, "events-value")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	out, err := s.Serialize(event.Event{EventID: "evt-1"})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(out) != `{"event_id":"evt-1","url":{"utm":{},"google":{},"meta":{},"microsoft":{}},"route":{},"device":{},"session":{},"server":{"detection":{"header_fingerprint":"","header_analysis":{"missing_expected":null,"automation_headers":null,"inconsistent_values":null,"header_order":null,"header_count":0},"request_analysis":{"payload_entropy":0,"request_size":0,"user_agent_analysis":{"length":0,"contains_automation":false,"automation_keywords":null,"platform":"","browser":""}},"timing_analysis":{"request_interval_ms":0,"interval_precision":0,"requests_per_second":0,"has_previous_request":false}}}}` {
+		t.Errorf("Serialize() = %s", out)
+	}
+	if s.Format() != FormatJSON {
+		t.Errorf("Format() = %q, want json", s.Format())
+	}
+}
+
+func TestNew_RequiresRegistry(t *testing.T) {
+	for _, f := range []string{FormatAvro, FormatProtobuf} {
+		_, err := New(context.Background(), f, nil, "events-value")
+		if err == nil || !strings.Contains(err.Error(), "KAFKA_SCHEMA_REGISTRY_URL") {
+			t.Errorf("New(%s) error = %v, want registry error", f, err)
+		}
+	}
+}
+
+func TestNew_WireFormat(t *testing.T) {
+	var gotType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		gotType = body["schemaType"]
+		_, _ = w.Write([]byte(`{"id":258}`))
+	}))
+	defer srv.Close()
+	registry := NewRegistry(srv.URL, "", "")
+
+	ev := event.Event{EventID: "evt-1", Type: "pageview"}
+
+	t.Run("avro", func(t *testing.T) {
+		s, err := New(context.Background(), FormatAvro, registry, "events-value")
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if gotType != "AVRO" {
+			t.Errorf("schemaType = %q, want AVRO", gotType)
+		}
+		out, _ := s.Serialize(ev)
+		if out[0] != 0 || binary.BigEndian.Uint32(out[1:5]) != 258 {
+			t.Errorf("header = %v, want magic 0 and schema id 258", out[:5])
+		}
+		// First field is event_id: zigzag length 5 -> 10, then the bytes
+		if out[5] != 10 || string(out[6:11]) != "evt-1" {
+			t.Errorf("payload starts %v, want event_id", out[5:11])
+		}
+	})
+
+	t.Run("protobuf", func(t *testing.T) {
+		s, err := New(context.Background(), FormatProtobuf, registry, "events-value")
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if gotType != "PROTOBUF" {
+			t.Errorf("schemaType = %q, want PROTOBUF", gotType)
+		}
+		out, _ := s.Serialize(ev)
+		if out[0] != 0 || binary.BigEndian.Uint32(out[1:5]) != 258 || out[5] != 0 {
+			t.Errorf("header = %v, want magic, schema id 258 and message index 0", out[:6])
+		}
+		// Field 1 (event_id), wire type 2
+		if out[6] != 0x0a || out[7] != 5 || string(out[8:13]) != "evt-1" {
+			t.Errorf("payload starts %v, want event_id", out[6:13])
+		}
+	})
+}
+
+func TestNewWithSchemaID(t *testing.T) {
+	s, err := NewWithSchemaID(FormatAvro, 7)
+	if err != nil {
+		t.Fatalf("NewWithSchemaID() error = %v", err)
+	}
+	out, _ := s.Serialize(event.Event{EventID: "evt-1"})
+	if out[0] != 0 || binary.BigEndian.Uint32(out[1:5]) != 7 {
+		t.Errorf("header = %v, want magic 0 and schema id 7", out[:5])
+	}
+	if s, _ := NewWithSchemaID("", 7); s.Format() != FormatJSON {
+		t.Errorf("Format() = %q, want json", s.Format())
+	}
+	if _, err := NewWithSchemaID("thrift", 7); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func BenchmarkSerialize(b *testing.B) {
+	mobile := true
+	ev := event.Event{
+		EventID: "0190b6a2-7c3e-7b1a-9f3e-2d4c5b6a7e8f",
+		TS:      "2025-01-01T12:00:00.123Z",
+		Type:    "pageview",
+		URL: event.URLInfo{
+			Referrer:         "https://www.google.com/",
+			ReferrerHostname: "www.google.com",
+			UTM:              event.UTMInfo{Source: "google", Medium: "cpc", Campaign: "spring_sale"},
+			Google:           event.GoogleAdsInfo{GCLID: "Cj0KCQiA"},
+		},
+		Route:   event.RouteInfo{Domain: "example.com", Path: "/pricing", FullPath: "/pricing?plan=pro", Title: "Pricing", Protocol: "https"},
+		Device:  event.DeviceInfo{UA: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X)", UAMobile: &mobile, Browser: "Safari", OS: "iOS", ViewportW: 390, ViewportH: 844},
+		Session: event.SessionInfo{VisitorID: "v-123", SessionID: "s-456", SessionSeq: 3},
+		Server:  event.ServerMeta{IP: "203.0.113.42", Geo: map[string]string{"country": "US", "city": "San Francisco"}},
+		Props:   map[string]string{"plan": "pro"},
+	}
+	for _, format := range []string{FormatJSON, FormatAvro, FormatProtobuf} {
+		s, err := NewWithSchemaID(format, 1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(format, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := s.Serialize(ev); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package session
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Click ID cookies, named and formatted like the ones Meta's and Google's
+// tags set, so values written by either side are read by the other
+const (
+	FBCCookie   = "_fbc"    // fb.1.<creation ms>.<fbclid>
+	FBPCookie   = "_fbp"    // fb.1.<creation ms>.<random>
+	GCLAWCookie = "_gcl_aw" // GCL.<click unix>.<gclid>
+)
+
+// ClickCookies keeps ad click IDs in first-party cookies set on /px.gif, so
+// conversions recorded later in the visit still carry the original click
+// when the ad platforms' own scripts are blocked
+type ClickCookies struct {
+	cfg Config
+	now func() time.Time
+}
+
+// NewClickCookies creates a click ID cookie writer. Only CookieDomain,
+// SameSite, Secure and ClickIDTTL are used.
+func NewClickCookies(cfg Config) *ClickCookies {
+	if cfg.ClickIDTTL <= 0 {
+		cfg.ClickIDTTL = 90 * 24 * time.Hour
+	}
+	if cfg.SameSite == http.SameSiteNoneMode {
+		cfg.Secure = true // browsers reject SameSite=None without Secure
+	}
+	return &ClickCookies{cfg: cfg, now: time.Now}
+}
+
+// Apply stores ev's click IDs in the cookies, issues _fbp to browsers
+// without one, and then fills the click IDs ev lacks from the cookies.
+// A cookie already holding the same click keeps its original timestamp.
+func (c *ClickCookies) Apply(w http.ResponseWriter, r *http.Request, ev *event.Event) {
+	now := c.now()
+	ms := strconv.FormatInt(now.UnixMilli(), 10)
+
+	fbc := cookieValue(r, FBCCookie, fbcClickID)
+	if id := ev.URL.Meta.FBCLID; id != "" && fbcClickID(fbc) != id {
+		fbc = "fb.1." + ms + "." + id
+		c.setCookie(w, FBCCookie, fbc)
+	}
+	fbp := cookieValue(r, FBPCookie, fbcClickID)
+	if fbp == "" {
+		fbp = "fb.1." + ms + "." + strconv.FormatInt(1e9+rand.Int64N(9e9), 10)
+		c.setCookie(w, FBPCookie, fbp)
+	}
+	gcl := cookieValue(r, GCLAWCookie, gclClickID)
+	if id := ev.URL.Google.GCLID; id != "" && gclClickID(gcl) != id {
+		gcl = "GCL." + strconv.FormatInt(now.Unix(), 10) + "." + id
+		c.setCookie(w, GCLAWCookie, gcl)
+	}
+	fill(ev, fbc, fbp, gcl)
+}
+
+// Fill sets the click IDs ev lacks from the cookies on r without writing
+// any, for endpoints that report conversions rather than landings
+func (c *ClickCookies) Fill(r *http.Request, ev *event.Event) {
+	fill(ev, cookieValue(r, FBCCookie, fbcClickID), cookieValue(r, FBPCookie, fbcClickID), cookieValue(r, GCLAWCookie, gclClickID))
+}
+
+func fill(ev *event.Event, fbc, fbp, gcl string) {
+	if ev.URL.Meta.FBC == "" {
+		ev.URL.Meta.FBC = fbc
+	}
+	if ev.URL.Meta.FBP == "" {
+		ev.URL.Meta.FBP = fbp
+	}
+	if ev.URL.Google.GCLID == "" {
+		ev.URL.Google.GCLID = gclClickID(gcl)
+	}
+}
+
+// cookieValue returns the named cookie if id finds a click ID in it
+func cookieValue(r *http.Request, name string, id func(string) string) string {
+	ck, err := r.Cookie(name)
+	if err != nil || id(ck.Value) == "" {
+		return ""
+	}
+	return ck.Value
+}
+
+// fbcClickID returns the last part of "fb.<subdomain index>.<ms>.<value>",
+// the fbclid of _fbc or the random part of _fbp
+func fbcClickID(v string) string {
+	parts := strings.SplitN(v, ".", 4)
+	if len(parts) != 4 || parts[0] != "fb" || !digits(parts[1]) || !digits(parts[2]) {
+		return ""
+	}
+	return parts[3]
+}
+
+// gclClickID returns the gclid of "GCL.<unix>.<gclid>"
+func gclClickID(v string) string {
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) != 3 || parts[0] != "GCL" || !digits(parts[1]) {
+		return ""
+	}
+	return parts[2]
+}
+
+func digits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// setCookie writes a cookie the ad platforms' scripts can read, so unlike
+// the session cookies it is not HttpOnly
+func (c *ClickCookies) setCookie(w http.ResponseWriter, name, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   c.cfg.CookieDomain,
+		MaxAge:   int(c.cfg.ClickIDTTL.Seconds()),
+		Secure:   c.cfg.Secure,
+		SameSite: c.cfg.SameSite,
+	})
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func newTestClickCookies(now time.Time) *ClickCookies {
+	c := NewClickCookies(Config{SameSite: http.SameSiteLaxMode})
+	c.now = func() time.Time { return now }
+	return c
+}
+
+func cookieMap(w *httptest.ResponseRecorder) map[string]*http.Cookie {
+	m := map[string]*http.Cookie{}
+	for _, c := range w.Result().Cookies() {
+		m[c.Name] = c
+	}
+	return m
+}
+
+func TestClickCookiesApply(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("stores click IDs from the landing", func(t *testing.T) {
+		c := newTestClickCookies(now)
+		var ev event.Event
+		ev.URL.Meta.FBCLID = "IwAR1abc"
+		ev.URL.Google.GCLID = "Cj0KCQ"
+		w := httptest.NewRecorder()
+		c.Apply(w, requestWithCookies(nil), &ev)
+
+		cookies := cookieMap(w)
+		if got := cookies[FBCCookie]; got == nil || got.Value != "fb.1.1714564800000.IwAR1abc" {
+			t.Errorf("_fbc = %+v", got)
+		}
+		if got := cookies[GCLAWCookie]; got == nil || got.Value != "GCL.1714564800.Cj0KCQ" {
+			t.Errorf("_gcl_aw = %+v", got)
+		}
+		fbp := cookies[FBPCookie]
+		if fbp == nil || !strings.HasPrefix(fbp.Value, "fb.1.1714564800000.") || fbcClickID(fbp.Value) == "" {
+			t.Fatalf("_fbp = %+v", fbp)
+		}
+		for _, ck := range cookies {
+			if ck.HttpOnly || ck.MaxAge != 90*24*3600 || ck.Path != "/" {
+				t.Errorf("cookie %s has unexpected attributes: %+v", ck.Name, ck)
+			}
+		}
+		if ev.URL.Meta.FBC != cookies[FBCCookie].Value || ev.URL.Meta.FBP != fbp.Value {
+			t.Errorf("event meta = %+v", ev.URL.Meta)
+		}
+	})
+
+	t.Run("later hits read the cookies", func(t *testing.T) {
+		c := newTestClickCookies(now)
+		first := event.Event{}
+		first.URL.Meta.FBCLID = "IwAR1abc"
+		first.URL.Google.GCLID = "Cj0KCQ"
+		w1 := httptest.NewRecorder()
+		c.Apply(w1, requestWithCookies(nil), &first)
+
+		c.now = func() time.Time { return now.Add(time.Hour) }
+		var later event.Event
+		w2 := httptest.NewRecorder()
+		c.Apply(w2, requestWithCookies(w1), &later)
+
+		if len(w2.Result().Cookies()) != 0 {
+			t.Errorf("expected no new cookies, got %v", w2.Result().Cookies())
+		}
+		if later.URL.Meta.FBC != first.URL.Meta.FBC || later.URL.Meta.FBP != first.URL.Meta.FBP || later.URL.Google.GCLID != "Cj0KCQ" {
+			t.Errorf("later event = %+v", later.URL)
+		}
+
+		var conversion event.Event
+		c.Fill(requestWithCookies(w1), &conversion)
+		if conversion.URL.Meta.FBC != first.URL.Meta.FBC || conversion.URL.Google.GCLID != "Cj0KCQ" {
+			t.Errorf("conversion = %+v", conversion.URL)
+		}
+	})
+
+	t.Run("a new click replaces the cookie", func(t *testing.T) {
+		c := newTestClickCookies(now)
+		req := requestWithCookies(nil)
+		req.AddCookie(&http.Cookie{Name: FBCCookie, Value: "fb.2.1700000000000.old"})
+		req.AddCookie(&http.Cookie{Name: GCLAWCookie, Value: "GCL.1700000000.old"})
+		var ev event.Event
+		ev.URL.Meta.FBCLID = "new"
+		ev.URL.Google.GCLID = "new"
+		w := httptest.NewRecorder()
+		c.Apply(w, req, &ev)
+
+		cookies := cookieMap(w)
+		if fbcClickID(cookies[FBCCookie].Value) != "new" || gclClickID(cookies[GCLAWCookie].Value) != "new" {
+			t.Errorf("cookies = %v", w.Result().Cookies())
+		}
+	})
+
+	t.Run("ignores malformed cookies", func(t *testing.T) {
+		c := newTestClickCookies(now)
+		req := requestWithCookies(nil)
+		req.AddCookie(&http.Cookie{Name: FBCCookie, Value: "IwAR1abc"})
+		req.AddCookie(&http.Cookie{Name: GCLAWCookie, Value: "GCL.x.Cj0KCQ"})
+		var ev event.Event
+		c.Fill(req, &ev)
+		if ev.URL.Meta.FBC != "" || ev.URL.Google.GCLID != "" {
+			t.Errorf("event = %+v", ev.URL)
+		}
+	})
+
+	t.Run("keeps client-supplied values", func(t *testing.T) {
+		c := newTestClickCookies(now)
+		req := requestWithCookies(nil)
+		req.AddCookie(&http.Cookie{Name: FBPCookie, Value: "fb.1.1700000000000.123"})
+		var ev event.Event
+		ev.URL.Meta.FBP = "fb.1.1710000000000.456"
+		c.Fill(req, &ev)
+		if ev.URL.Meta.FBP != "fb.1.1710000000000.456" {
+			t.Errorf("fbp = %q", ev.URL.Meta.FBP)
+		}
+	})
+}
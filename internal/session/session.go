@@ -0,0 +1,184 @@
+// Package session issues first-party visitor and session cookies and keeps
+// session state server-side, so events are stitched into sessions even when
+// the client does not manage its own identifiers.
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Cookie names issued by the server
+const (
+	VisitorCookie = "_gt_vid"
+	SessionCookie = "_gt_sid"
+)
+
+// Config controls cookie attributes and session lifetime
+type Config struct {
+	CookieDomain string        // empty scopes cookies to the request host
+	SameSite     http.SameSite // SameSite=None forces Secure
+	Secure       bool          // only send cookies over HTTPS
+	Timeout      time.Duration // idle time after which a new session starts
+	MaxDuration  time.Duration // sessions are rotated after this long regardless of activity
+	VisitorTTL   time.Duration // lifetime of the visitor cookie
+	ClickIDTTL   time.Duration // lifetime of the click ID cookies
+}
+
+// ParseSameSite maps lax, strict or none to http.SameSite
+func ParseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("invalid SameSite value %q (want lax, strict or none)", s)
+	}
+}
+
+// state is what the store keeps per session
+type state struct {
+	Start time.Time `json:"start"`
+	Seq   int       `json:"seq"`
+}
+
+// Manager resolves and rotates sessions. State lives in a kv.Store, so
+// sessions survive restarts and are shared between replicas when the store is.
+type Manager struct {
+	cfg   Config
+	store kv.Store
+	now   func() time.Time
+}
+
+// NewManager creates a session manager backed by store
+func NewManager(cfg Config, store kv.Store) *Manager {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Minute
+	}
+	if cfg.VisitorTTL <= 0 {
+		cfg.VisitorTTL = 395 * 24 * time.Hour
+	}
+	if cfg.SameSite == http.SameSiteNoneMode {
+		cfg.Secure = true // browsers reject SameSite=None without Secure
+	}
+	return &Manager{cfg: cfg, store: store, now: time.Now}
+}
+
+// Resolve identifies the visitor and session for a request that carries n
+// events, refreshes the cookies on w and returns the session info for the
+// first event. Subsequent events in the same request use SessionSeq+1, +2, ...
+func (m *Manager) Resolve(ctx context.Context, w http.ResponseWriter, r *http.Request, n int) event.SessionInfo {
+	now := m.now().UTC()
+	if n < 1 {
+		n = 1
+	}
+
+	visitorID, firstVisit := m.visitor(r, now)
+	sessionID, st := m.session(ctx, r, now)
+
+	info := event.SessionInfo{
+		VisitorID:    visitorID,
+		SessionID:    sessionID,
+		SessionStart: st.Start.Format(time.RFC3339),
+		SessionSeq:   st.Seq + 1,
+		FirstVisitTS: firstVisit.Format(time.RFC3339),
+	}
+
+	st.Seq += n
+	if data, err := json.Marshal(st); err == nil {
+		if err := m.store.Set(ctx, sessionKey(sessionID), data, m.cfg.Timeout); err != nil {
+			log.Printf("session: failed to save session state: %v", err)
+		}
+	}
+
+	m.setCookie(w, VisitorCookie, visitorID+"."+strconv.FormatInt(firstVisit.Unix(), 10), m.cfg.VisitorTTL)
+	m.setCookie(w, SessionCookie, sessionID, m.cfg.Timeout)
+	return info
+}
+
+// Visitor returns the visitor ID from the visitor cookie, issuing one to new
+// visitors, without starting or continuing a session
+func (m *Manager) Visitor(w http.ResponseWriter, r *http.Request) string {
+	visitorID, firstVisit := m.visitor(r, m.now().UTC())
+	m.setCookie(w, VisitorCookie, visitorID+"."+strconv.FormatInt(firstVisit.Unix(), 10), m.cfg.VisitorTTL)
+	return visitorID
+}
+
+// visitor reads the visitor cookie ("<uuid>.<first visit unix>") or mints a new visitor
+func (m *Manager) visitor(r *http.Request, now time.Time) (string, time.Time) {
+	if c, err := r.Cookie(VisitorCookie); err == nil {
+		id, ts, ok := strings.Cut(c.Value, ".")
+		if _, err := uuid.Parse(id); err == nil && ok {
+			if unix, err := strconv.ParseInt(ts, 10, 64); err == nil && unix > 0 && unix <= now.Unix() {
+				return id, time.Unix(unix, 0).UTC()
+			}
+		}
+	}
+	return uuid.NewString(), now
+}
+
+// session continues the cookie's session if it is still live in the store,
+// otherwise starts a new one
+func (m *Manager) session(ctx context.Context, r *http.Request, now time.Time) (string, state) {
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		if _, err := uuid.Parse(c.Value); err == nil {
+			data, ok, err := m.store.Get(ctx, sessionKey(c.Value))
+			if err != nil {
+				log.Printf("session: failed to load session state: %v", err)
+			}
+			var st state
+			if ok && json.Unmarshal(data, &st) == nil {
+				if m.cfg.MaxDuration <= 0 || now.Sub(st.Start) < m.cfg.MaxDuration {
+					return c.Value, st
+				}
+			}
+		}
+	}
+	return uuid.NewString(), state{Start: now}
+}
+
+func (m *Manager) setCookie(w http.ResponseWriter, name, value string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   m.cfg.CookieDomain,
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   m.cfg.Secure,
+		SameSite: m.cfg.SameSite,
+	})
+}
+
+// Apply fills session fields the client did not supply. seq is the sequence
+// number to use when the client sent no session of its own.
+func Apply(ev *event.Event, info event.SessionInfo, seq int) {
+	if ev.Session.VisitorID == "" {
+		ev.Session.VisitorID = info.VisitorID
+	}
+	if ev.Session.FirstVisitTS == "" {
+		ev.Session.FirstVisitTS = info.FirstVisitTS
+	}
+	if ev.Session.SessionID == "" {
+		ev.Session.SessionID = info.SessionID
+		ev.Session.SessionStart = info.SessionStart
+		ev.Session.SessionSeq = seq
+	}
+}
+
+func sessionKey(id string) string {
+	return "session:" + id
+}
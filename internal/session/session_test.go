@@ -0,0 +1,176 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func newTestManager(now *time.Time) *Manager {
+	m := NewManager(Config{
+		SameSite:    http.SameSiteLaxMode,
+		Timeout:     30 * time.Minute,
+		MaxDuration: 24 * time.Hour,
+	}, kv.NewMemoryStore())
+	m.now = func() time.Time { return *now }
+	return m
+}
+
+// requestWithCookies replays cookies set on a previous response
+func requestWithCookies(prev *httptest.ResponseRecorder) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/px.gif", nil)
+	if prev != nil {
+		for _, c := range prev.Result().Cookies() {
+			req.AddCookie(c)
+		}
+	}
+	return req
+}
+
+func TestManagerResolve(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("issues cookies for a new visitor", func(t *testing.T) {
+		m := newTestManager(&now)
+		w := httptest.NewRecorder()
+		info := m.Resolve(ctx, w, requestWithCookies(nil), 1)
+
+		if info.VisitorID == "" || info.SessionID == "" || info.SessionSeq != 1 {
+			t.Fatalf("unexpected session info: %+v", info)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 2 {
+			t.Fatalf("expected 2 cookies, got %d", len(cookies))
+		}
+		for _, c := range cookies {
+			if !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.Path != "/" {
+				t.Errorf("cookie %s has unexpected attributes: %+v", c.Name, c)
+			}
+		}
+	})
+
+	t.Run("continues the session and counts events", func(t *testing.T) {
+		m := newTestManager(&now)
+		w1 := httptest.NewRecorder()
+		first := m.Resolve(ctx, w1, requestWithCookies(nil), 3)
+
+		now = now.Add(10 * time.Minute)
+		second := m.Resolve(ctx, httptest.NewRecorder(), requestWithCookies(w1), 1)
+
+		if second.VisitorID != first.VisitorID || second.SessionID != first.SessionID {
+			t.Errorf("expected same visitor and session: %+v vs %+v", first, second)
+		}
+		if second.SessionSeq != 4 {
+			t.Errorf("SessionSeq = %d, want 4 after a 3-event request", second.SessionSeq)
+		}
+		if second.FirstVisitTS != first.FirstVisitTS || second.SessionStart != first.SessionStart {
+			t.Errorf("timestamps changed: %+v vs %+v", first, second)
+		}
+	})
+
+	t.Run("starts a new session after the idle timeout", func(t *testing.T) {
+		store := kv.NewMemoryStore()
+		m := newTestManager(&now)
+		m.store = store
+		w1 := httptest.NewRecorder()
+		first := m.Resolve(ctx, w1, requestWithCookies(nil), 1)
+
+		// The store enforces the idle TTL; simulate expiry by dropping the state
+		_ = store.Delete(ctx, sessionKey(first.SessionID))
+		second := m.Resolve(ctx, httptest.NewRecorder(), requestWithCookies(w1), 1)
+
+		if second.SessionID == first.SessionID {
+			t.Error("expected a new session after timeout")
+		}
+		if second.VisitorID != first.VisitorID {
+			t.Error("visitor should survive session rotation")
+		}
+		if second.SessionSeq != 1 {
+			t.Errorf("SessionSeq = %d, want 1", second.SessionSeq)
+		}
+	})
+
+	t.Run("rotates sessions past the max duration", func(t *testing.T) {
+		m := newTestManager(&now)
+		w := httptest.NewRecorder()
+		first := m.Resolve(ctx, w, requestWithCookies(nil), 1)
+
+		// Stay active every 20 minutes for 25 hours
+		var last event.SessionInfo
+		for i := 0; i < 75; i++ {
+			now = now.Add(20 * time.Minute)
+			next := httptest.NewRecorder()
+			last = m.Resolve(ctx, next, requestWithCookies(w), 1)
+			w = next
+		}
+		if last.SessionID == first.SessionID {
+			t.Error("expected session to rotate after max duration")
+		}
+	})
+
+	t.Run("ignores malformed cookies", func(t *testing.T) {
+		m := newTestManager(&now)
+		req := requestWithCookies(nil)
+		req.AddCookie(&http.Cookie{Name: VisitorCookie, Value: "not-a-visitor"})
+		req.AddCookie(&http.Cookie{Name: SessionCookie, Value: "../../etc"})
+		info := m.Resolve(ctx, httptest.NewRecorder(), req, 1)
+
+		if info.VisitorID == "not-a-visitor" || info.SessionID == "../../etc" {
+			t.Errorf("malformed cookies were trusted: %+v", info)
+		}
+	})
+
+	t.Run("SameSite=None forces Secure", func(t *testing.T) {
+		m := NewManager(Config{SameSite: http.SameSiteNoneMode}, kv.NewMemoryStore())
+		w := httptest.NewRecorder()
+		m.Resolve(ctx, w, requestWithCookies(nil), 1)
+		if !strings.Contains(w.Header().Get("Set-Cookie"), "Secure") {
+			t.Error("expected Secure attribute with SameSite=None")
+		}
+	})
+}
+
+func TestApply(t *testing.T) {
+	info := event.SessionInfo{VisitorID: "v", SessionID: "s", SessionStart: "start", SessionSeq: 1, FirstVisitTS: "first"}
+
+	t.Run("fills missing fields", func(t *testing.T) {
+		var ev event.Event
+		Apply(&ev, info, 2)
+		if ev.Session.VisitorID != "v" || ev.Session.SessionID != "s" || ev.Session.SessionSeq != 2 {
+			t.Errorf("unexpected session: %+v", ev.Session)
+		}
+	})
+
+	t.Run("keeps client-supplied session", func(t *testing.T) {
+		ev := event.Event{Session: event.SessionInfo{VisitorID: "client-v", SessionID: "client-s", SessionSeq: 7}}
+		Apply(&ev, info, 2)
+		if ev.Session.VisitorID != "client-v" || ev.Session.SessionID != "client-s" || ev.Session.SessionSeq != 7 {
+			t.Errorf("client session overwritten: %+v", ev.Session)
+		}
+	})
+}
+
+func TestParseSameSite(t *testing.T) {
+	tests := map[string]http.SameSite{
+		"":       http.SameSiteLaxMode,
+		"Lax":    http.SameSiteLaxMode,
+		"strict": http.SameSiteStrictMode,
+		"none":   http.SameSiteNoneMode,
+	}
+	for in, want := range tests {
+		got, err := ParseSameSite(in)
+		if err != nil || got != want {
+			t.Errorf("ParseSameSite(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseSameSite("sometimes"); err == nil {
+		t.Error("expected error for invalid value")
+	}
+}
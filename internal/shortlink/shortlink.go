@@ -0,0 +1,182 @@
+// Package shortlink stores short links: a code that /s/<code> redirects to a
+// destination, with UTM parameters added so marketing can hand out tracked
+// links without building them by hand. Links live in the shared key/value
+// store, so every replica serves the links created on any of them.
+package shortlink
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// PathPrefix is the redirect endpoint; the code follows it
+const PathPrefix = "/s/"
+
+// CodePlaceholder in a UTM value is replaced by the link's code
+const CodePlaceholder = "{code}"
+
+const (
+	keyPrefix     = "shortlink:"
+	maxCodeLength = 64
+	generatedLen  = 7
+	codeAlphabet  = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var (
+	// ErrNotFound is returned for a code no link was created with
+	ErrNotFound = errors.New("short link not found")
+	// ErrExists is returned when creating a link with a code already in use
+	ErrExists = errors.New("short link code already in use")
+	// ErrInvalidCode is returned for codes with characters other than letters, digits, - and _
+	ErrInvalidCode = fmt.Errorf("code must be 1-%d letters, digits, - or _", maxCodeLength)
+	// ErrInvalidDestination is returned for a destination that isn't an absolute http or https URL
+	ErrInvalidDestination = errors.New("destination must be an absolute http or https URL")
+)
+
+// Link is a stored short link
+type Link struct {
+	Code        string        `json:"code"`
+	Destination string        `json:"destination"`
+	UTM         event.UTMInfo `json:"utm"`                 // added to the destination; CodePlaceholder is replaced by the code
+	WriteKey    string        `json:"write_key,omitempty"` // tenant whose site records the clicks
+	Created     time.Time     `json:"created"`
+}
+
+// URL returns the destination with the link's UTM parameters set, replacing
+// any the destination already has
+func (l Link) URL() string {
+	u, err := url.Parse(l.Destination)
+	if err != nil {
+		return l.Destination
+	}
+	q := u.Query()
+	set := false
+	for param, value := range map[string]string{
+		"utm_source":      l.UTM.Source,
+		"utm_medium":      l.UTM.Medium,
+		"utm_campaign":    l.UTM.Campaign,
+		"utm_term":        l.UTM.Term,
+		"utm_content":     l.UTM.Content,
+		"utm_id":          l.UTM.ID,
+		"utm_campaign_id": l.UTM.CampaignID,
+	} {
+		if value != "" {
+			q.Set(param, strings.ReplaceAll(value, CodePlaceholder, l.Code))
+			set = true
+		}
+	}
+	if set {
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// Store keeps short links in a key/value store
+type Store struct {
+	kv kv.Store
+}
+
+// NewStore returns a Store backed by s
+func NewStore(s kv.Store) *Store {
+	return &Store{kv: s}
+}
+
+// Create stores l and returns it with its code and creation time filled in.
+// Without a code a random one is generated.
+func (s *Store) Create(ctx context.Context, l Link) (Link, error) {
+	u, err := url.Parse(strings.TrimSpace(l.Destination))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Link{}, ErrInvalidDestination
+	}
+	l.Destination = u.String()
+	l.Created = time.Now().UTC().Truncate(time.Second)
+
+	if l.Code != "" {
+		if !ValidCode(l.Code) {
+			return Link{}, ErrInvalidCode
+		}
+		return l, s.put(ctx, l)
+	}
+	// Generated codes only collide once millions of links exist
+	for range 3 {
+		l.Code = generateCode()
+		if err := s.put(ctx, l); !errors.Is(err, ErrExists) {
+			return l, err
+		}
+	}
+	return Link{}, ErrExists
+}
+
+// put stores l unless its code is taken. Two replicas creating the same code
+// at once can both succeed; the last write wins.
+func (s *Store) put(ctx context.Context, l Link) error {
+	if _, ok, err := s.kv.Get(ctx, keyPrefix+l.Code); err != nil {
+		return err
+	} else if ok {
+		return ErrExists
+	}
+	value, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return s.kv.Set(ctx, keyPrefix+l.Code, value, 0)
+}
+
+// Get returns the link with code
+func (s *Store) Get(ctx context.Context, code string) (Link, error) {
+	if !ValidCode(code) {
+		return Link{}, ErrNotFound
+	}
+	value, ok, err := s.kv.Get(ctx, keyPrefix+code)
+	if err != nil {
+		return Link{}, err
+	}
+	if !ok {
+		return Link{}, ErrNotFound
+	}
+	var l Link
+	if err := json.Unmarshal(value, &l); err != nil {
+		return Link{}, fmt.Errorf("short link %q: %w", code, err)
+	}
+	return l, nil
+}
+
+// Delete removes the link with code
+func (s *Store) Delete(ctx context.Context, code string) error {
+	if _, err := s.Get(ctx, code); err != nil {
+		return err
+	}
+	return s.kv.Delete(ctx, keyPrefix+code)
+}
+
+// ValidCode reports whether code can name a link
+func ValidCode(code string) bool {
+	if code == "" || len(code) > maxCodeLength {
+		return false
+	}
+	for _, c := range code {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// generateCode returns a random code without look-alike characters
+func generateCode() string {
+	b := make([]byte, generatedLen)
+	_, _ = rand.Read(b)
+	for i := range b {
+		b[i] = codeAlphabet[int(b[i])%len(codeAlphabet)]
+	}
+	return string(b)
+}
@@ -0,0 +1,87 @@
+package shortlink
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/shortontech/gotrack/internal/kv"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(kv.NewMemoryStore())
+
+	l, err := s.Create(ctx, Link{Code: "spring", Destination: "https://shop.example/sale"})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if l.Created.IsZero() {
+		t.Error("Create() didn't set the creation time")
+	}
+	if _, err := s.Create(ctx, Link{Code: "spring", Destination: "https://other.example/"}); !errors.Is(err, ErrExists) {
+		t.Errorf("duplicate Create() error = %v, want ErrExists", err)
+	}
+	got, err := s.Get(ctx, "spring")
+	if err != nil || got.Destination != "https://shop.example/sale" {
+		t.Errorf("Get() = %+v, %v", got, err)
+	}
+
+	generated, err := s.Create(ctx, Link{Destination: "https://shop.example/"})
+	if err != nil || !ValidCode(generated.Code) || len(generated.Code) != generatedLen {
+		t.Errorf("Create() without a code = %+v, %v", generated, err)
+	}
+
+	if err := s.Delete(ctx, "spring"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := s.Get(ctx, "spring"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, "spring"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStore_Invalid(t *testing.T) {
+	s := NewStore(kv.NewMemoryStore())
+	for _, tt := range []struct {
+		link Link
+		want error
+	}{
+		{Link{Destination: "javascript:alert(1)"}, ErrInvalidDestination},
+		{Link{Destination: "/relative"}, ErrInvalidDestination},
+		{Link{Code: "has space", Destination: "https://shop.example/"}, ErrInvalidCode},
+		{Link{Code: "a/b", Destination: "https://shop.example/"}, ErrInvalidCode},
+	} {
+		if _, err := s.Create(context.Background(), tt.link); !errors.Is(err, tt.want) {
+			t.Errorf("Create(%+v) error = %v, want %v", tt.link, err, tt.want)
+		}
+	}
+}
+
+func TestLink_URL(t *testing.T) {
+	l := Link{
+		Code:        "fb-spring",
+		Destination: "https://shop.example/sale?id=7&utm_source=old#top",
+		UTM:         event.UTMInfo{Source: "facebook", Medium: "social", Campaign: "spring-{code}"},
+	}
+	u, err := url.Parse(l.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("id") != "7" || q.Get("utm_source") != "facebook" || q.Get("utm_medium") != "social" || q.Get("utm_campaign") != "spring-fb-spring" {
+		t.Errorf("URL() = %q", l.URL())
+	}
+	if u.Fragment != "top" {
+		t.Errorf("URL() lost the fragment: %q", l.URL())
+	}
+
+	plain := Link{Code: "x", Destination: "https://shop.example/a?b=1"}
+	if plain.URL() != plain.Destination {
+		t.Errorf("URL() without UTM = %q, want the destination", plain.URL())
+	}
+}
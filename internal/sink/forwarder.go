@@ -0,0 +1,369 @@
+package sink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/metrics"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Destination is a remote API that takes events in batches, such as an ad
+// platform's conversion API or a managed stream. A Forwarder batches the
+// events a destination qualifies and hands them to Send.
+type Destination interface {
+	// Name is the sink name, as listed in OUTPUTS
+	Name() string
+	// Check verifies the credentials and settings before the first send
+	Check() error
+	// Qualifies reports whether an event is one the destination takes, such as
+	// a conversion the platform can attribute
+	Qualifies(e event.Event) bool
+	// Send delivers one batch. Errors wrapped in PermanentError are not retried.
+	Send(ctx context.Context, events []event.Event) error
+	// MaxBatch is the most events the platform accepts per request
+	MaxBatch() int
+}
+
+// PermanentError marks a rejection that retrying will not fix, such as a
+// malformed request. The batch is dropped rather than retried.
+type PermanentError struct{ Err error }
+
+func (e PermanentError) Error() string { return e.Err.Error() }
+func (e PermanentError) Unwrap() error { return e.Err }
+
+// ForwardConfig holds the batching settings shared by all forwarders
+type ForwardConfig struct {
+	BatchSize   int
+	FlushMS     int
+	MaxAttempts int // attempts per batch before its events are re-queued
+	MaxPending  int // upper bound on buffered events while the platform is unreachable
+}
+
+// forwardConfigFromEnv reads the FORWARD_* batching settings
+func forwardConfigFromEnv() ForwardConfig {
+	return ForwardConfig{
+		BatchSize:   getIntEnv("FORWARD_BATCH_SIZE", 100),
+		FlushMS:     getIntEnv("FORWARD_FLUSH_MS", 5000),
+		MaxAttempts: getIntEnv("FORWARD_MAX_ATTEMPTS", 5),
+		MaxPending:  getIntEnv("FORWARD_MAX_PENDING", 10000),
+	}
+}
+
+// Forwarder is a sink that sends qualified events to a Destination, such as
+// Meta's Conversions API, Google Ads conversion upload or Kinesis. Events the
+// destination does not qualify are ignored. Failed batches are retried with
+// backoff and then re-queued, up to MaxPending events.
+type Forwarder struct {
+	dest   Destination
+	config ForwardConfig
+
+	batch      []event.Event
+	batchMutex sync.Mutex
+	sendMutex  sync.Mutex
+	kick       chan struct{} // signals the flush routine that a batch is full
+	lastFlush  atomic.Int64  // duration of the last flush in nanoseconds
+	oldest     time.Time     // when batch[0] was enqueued
+	sending    time.Time     // when the oldest event being flushed was enqueued
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+
+	// Metrics receives the size and latency of each flush; optional
+	Metrics *metrics.Metrics
+}
+
+// NewForwarder creates a Forwarder for a destination
+func NewForwarder(dest Destination, config ForwardConfig) *Forwarder {
+	return &Forwarder{
+		dest:   dest,
+		config: config,
+		kick:   make(chan struct{}, 1),
+	}
+}
+
+func (f *Forwarder) Start(ctx context.Context) error {
+	if err := f.dest.Check(); err != nil {
+		return err
+	}
+	if f.config.BatchSize <= 0 {
+		f.config.BatchSize = 100
+	}
+	if limit := f.dest.MaxBatch(); limit > 0 && f.config.BatchSize > limit {
+		f.config.BatchSize = limit
+	}
+	if f.config.FlushMS <= 0 {
+		f.config.FlushMS = 5000
+	}
+	if f.config.MaxAttempts <= 0 {
+		f.config.MaxAttempts = 1
+	}
+
+	f.ctx, f.cancel = context.WithCancel(ctx)
+	f.done = make(chan struct{})
+	f.batch = make([]event.Event, 0, f.config.BatchSize)
+
+	go f.flushRoutine()
+	return nil
+}
+
+func (f *Forwarder) Enqueue(e event.Event) error {
+	if !f.dest.Qualifies(e) {
+		return nil
+	}
+	f.batchMutex.Lock()
+	if f.config.MaxPending > 0 && len(f.batch) >= f.config.MaxPending {
+		f.batchMutex.Unlock()
+		return fmt.Errorf("%s buffer full (%d events)", f.dest.Name(), len(f.batch))
+	}
+	if len(f.batch) == 0 {
+		f.oldest = time.Now()
+	}
+	f.batch = append(f.batch, e)
+	full := len(f.batch) >= f.config.BatchSize
+	f.batchMutex.Unlock()
+
+	if full {
+		select {
+		case f.kick <- struct{}{}:
+		default: // flush already pending
+		}
+	}
+	return nil
+}
+
+func (f *Forwarder) Close() error {
+	if f.cancel == nil {
+		return nil // never started
+	}
+	f.cancel()
+	<-f.done
+
+	// Final best-effort flush with a bounded deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := f.flush(ctx)
+	return err
+}
+
+func (f *Forwarder) Name() string {
+	return f.dest.Name()
+}
+
+// Flush sends the buffer now rather than at the next flush tick
+func (f *Forwarder) Flush(ctx context.Context) (int, error) {
+	if f.cancel == nil {
+		return 0, fmt.Errorf("%s sink not started", f.dest.Name())
+	}
+	return f.flush(ctx)
+}
+
+// Load reports buffered events and the last flush duration
+func (f *Forwarder) Load() (int, time.Duration) {
+	f.batchMutex.Lock()
+	defer f.batchMutex.Unlock()
+	return len(f.batch), time.Duration(f.lastFlush.Load())
+}
+
+// flushRoutine sends the buffer on a fixed interval
+func (f *Forwarder) flushRoutine() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(time.Duration(f.config.FlushMS) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-f.kick:
+		}
+		_, _ = f.flush(f.ctx) // Error logged within flush
+	}
+}
+
+// flush sends the current buffer in batches, returning how many events were
+// delivered. Batches the platform rejected outright are dropped; the rest of
+// the buffer is put back when the platform stays unreachable.
+func (f *Forwarder) flush(ctx context.Context) (int, error) {
+	f.sendMutex.Lock()
+	defer f.sendMutex.Unlock()
+
+	f.batchMutex.Lock()
+	if len(f.batch) == 0 {
+		f.batchMutex.Unlock()
+		return 0, nil
+	}
+	pending := f.batch
+	f.batch = make([]event.Event, 0, f.config.BatchSize)
+	f.sending, f.oldest = f.oldest, time.Time{}
+	f.batchMutex.Unlock()
+
+	start := time.Now()
+	defer func() {
+		f.batchMutex.Lock()
+		f.sending = time.Time{}
+		f.batchMutex.Unlock()
+
+		elapsed := time.Since(start)
+		f.lastFlush.Store(int64(elapsed))
+		if f.Metrics != nil {
+			f.Metrics.ObserveSinkFlush(f.Name(), len(pending), elapsed)
+		}
+	}()
+
+	sent := 0
+	for start := 0; start < len(pending); start += f.config.BatchSize {
+		end := min(start+f.config.BatchSize, len(pending))
+		err := f.send(ctx, pending[start:end])
+		var permanent PermanentError
+		switch {
+		case err == nil:
+			sent += end - start
+		case errors.As(err, &permanent):
+			fmt.Fprintf(os.Stderr, "%s rejected %d events, dropping them: %v\n", f.dest.Name(), end-start, err)
+		default:
+			fmt.Fprintf(os.Stderr, "%s flush error: %v\n", f.dest.Name(), err)
+			f.requeue(pending[start:])
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// send delivers one batch, retrying with exponential backoff
+func (f *Forwarder) send(ctx context.Context, events []event.Event) error {
+	var err error
+	for attempt := 0; attempt < f.config.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(250*(1<<uint(attempt-1))) * time.Millisecond
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+		err = f.dest.Send(ctx, events)
+		var permanent PermanentError
+		if err == nil || errors.As(err, &permanent) {
+			return err
+		}
+	}
+	return fmt.Errorf("batch failed after %d attempts: %w", f.config.MaxAttempts, err)
+}
+
+// OldestPending reports when the oldest buffered or in-flight event was enqueued
+func (f *Forwarder) OldestPending() time.Time {
+	f.batchMutex.Lock()
+	defer f.batchMutex.Unlock()
+	return earliest(f.oldest, f.sending)
+}
+
+// earliest returns the earlier of two times, ignoring zero ones
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// requeue returns undelivered events to the front of the buffer
+func (f *Forwarder) requeue(events []event.Event) {
+	f.batchMutex.Lock()
+	defer f.batchMutex.Unlock()
+
+	merged := make([]event.Event, 0, len(events)+len(f.batch))
+	merged = append(merged, events...)
+	merged = append(merged, f.batch...)
+	f.oldest = f.sending
+	if f.config.MaxPending > 0 && len(merged) > f.config.MaxPending {
+		dropped := len(merged) - f.config.MaxPending
+		fmt.Fprintf(os.Stderr, "%s buffer full, dropping %d oldest events\n", f.dest.Name(), dropped)
+		merged = merged[dropped:]
+	}
+	f.batch = merged
+}
+
+// checkResponse turns a platform's HTTP response into an error. Client
+// errors other than 408 and 429 are permanent.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	err := fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return PermanentError{err}
+	}
+	return err
+}
+
+// parseEventMap parses a comma list of type=name pairs, such as
+// "purchase=Purchase,sign_up=CompleteRegistration". A bare type maps to itself.
+func parseEventMap(s string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		typ, name, found := strings.Cut(pair, "=")
+		typ, name = strings.TrimSpace(typ), strings.TrimSpace(name)
+		if !found {
+			name = typ
+		}
+		if typ == "" || name == "" {
+			return nil, fmt.Errorf("invalid event mapping %q (want type=name)", pair)
+		}
+		m[typ] = name
+	}
+	return m, nil
+}
+
+// conversionValue returns the order value of a /conversion event, else the
+// value and currency props, as sent by GA-style purchase events
+func conversionValue(e event.Event) (value float64, currency string, ok bool) {
+	if ec := e.Ecommerce; ec != nil {
+		return ec.Value, ec.Currency, true
+	}
+	value, err := strconv.ParseFloat(e.Props["value"], 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return value, strings.ToUpper(e.Props["currency"]), true
+}
+
+// normalizedEmail lowercases and trims the email prop, as both platforms
+// require before hashing
+func normalizedEmail(e event.Event) string {
+	return strings.ToLower(strings.TrimSpace(e.Props["email"]))
+}
+
+// phoneDigits returns the digits of the phone prop, which should include the
+// country code
+func phoneDigits(e event.Event) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, e.Props["phone"])
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,242 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/shortontech/gotrack/internal/metrics"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// fakeDestination records batches and fails sends on demand
+type fakeDestination struct {
+	mu      sync.Mutex
+	batches [][]event.Event
+	calls   int
+	fail    func(n int) error // error for the nth Send, nil to accept
+}
+
+func (d *fakeDestination) Name() string                 { return "fake" }
+func (d *fakeDestination) Check() error                 { return nil }
+func (d *fakeDestination) MaxBatch() int                { return 2 }
+func (d *fakeDestination) Qualifies(e event.Event) bool { return e.Type == "purchase" }
+
+func (d *fakeDestination) Send(_ context.Context, events []event.Event) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls++
+	if d.fail != nil {
+		if err := d.fail(d.calls); err != nil {
+			return err
+		}
+	}
+	d.batches = append(d.batches, append([]event.Event(nil), events...))
+	return nil
+}
+
+func startTestForwarder(t *testing.T, dest Destination, config ForwardConfig) *Forwarder {
+	t.Helper()
+	if config.FlushMS == 0 {
+		config.FlushMS = 60000 // tests flush explicitly
+	}
+	f := NewForwarder(dest, config)
+	if err := f.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+func TestForwarderBatching(t *testing.T) {
+	dest := &fakeDestination{}
+	f := startTestForwarder(t, dest, ForwardConfig{BatchSize: 100, MaxAttempts: 1})
+
+	for _, typ := range []string{"purchase", "pageview", "purchase", "purchase"} {
+		if err := f.Enqueue(event.Event{Type: typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pending, _ := f.Load(); pending != 3 {
+		t.Errorf("pending = %d, want 3 (the pageview does not qualify)", pending)
+	}
+	sent, err := f.Flush(context.Background())
+	if err != nil || sent != 3 {
+		t.Fatalf("Flush = %d, %v", sent, err)
+	}
+	// BatchSize is capped at the destination's MaxBatch
+	if len(dest.batches) != 2 || len(dest.batches[0]) != 2 || len(dest.batches[1]) != 1 {
+		t.Errorf("batches = %v", dest.batches)
+	}
+}
+
+func TestForwarderRetry(t *testing.T) {
+	dest := &fakeDestination{fail: func(n int) error {
+		if n == 1 {
+			return errors.New("status 503")
+		}
+		return nil
+	}}
+	f := startTestForwarder(t, dest, ForwardConfig{BatchSize: 10, MaxAttempts: 3})
+	_ = f.Enqueue(event.Event{Type: "purchase"})
+
+	if sent, err := f.Flush(context.Background()); err != nil || sent != 1 || dest.calls != 2 {
+		t.Errorf("Flush = %d, %v after %d calls", sent, err, dest.calls)
+	}
+}
+
+func TestForwarderPermanentError(t *testing.T) {
+	dest := &fakeDestination{fail: func(n int) error {
+		return PermanentError{errors.New("status 400")}
+	}}
+	f := startTestForwarder(t, dest, ForwardConfig{BatchSize: 10, MaxAttempts: 3})
+	_ = f.Enqueue(event.Event{Type: "purchase"})
+
+	if sent, err := f.Flush(context.Background()); err != nil || sent != 0 {
+		t.Errorf("Flush = %d, %v", sent, err)
+	}
+	if dest.calls != 1 {
+		t.Errorf("calls = %d, permanent errors must not be retried", dest.calls)
+	}
+	if pending, _ := f.Load(); pending != 0 {
+		t.Errorf("pending = %d, rejected events must be dropped", pending)
+	}
+}
+
+func TestForwarderRequeue(t *testing.T) {
+	dest := &fakeDestination{fail: func(n int) error { return errors.New("status 503") }}
+	f := startTestForwarder(t, dest, ForwardConfig{BatchSize: 10, MaxAttempts: 1, MaxPending: 2})
+
+	for i := 0; i < 2; i++ {
+		_ = f.Enqueue(event.Event{Type: "purchase", EventID: string(rune('a' + i))})
+	}
+	if err := f.Enqueue(event.Event{Type: "purchase"}); err == nil {
+		t.Error("expected buffer full error")
+	}
+	if _, err := f.Flush(context.Background()); err == nil {
+		t.Fatal("expected flush error")
+	}
+	if pending, _ := f.Load(); pending != 2 {
+		t.Errorf("pending = %d, want failed events re-queued", pending)
+	}
+
+	dest.fail = nil
+	if sent, err := f.Flush(context.Background()); err != nil || sent != 2 {
+		t.Errorf("Flush after recovery = %d, %v", sent, err)
+	}
+}
+
+func TestForwarderOldestPending(t *testing.T) {
+	dest := &fakeDestination{fail: func(n int) error { return errors.New("status 503") }}
+	f := startTestForwarder(t, dest, ForwardConfig{BatchSize: 10, MaxAttempts: 1})
+
+	if !f.OldestPending().IsZero() {
+		t.Fatal("empty forwarder reported a pending event")
+	}
+	_ = f.Enqueue(event.Event{Type: "purchase"})
+	first := f.OldestPending()
+	if first.IsZero() {
+		t.Fatal("OldestPending() is zero with an event buffered")
+	}
+	_ = f.Enqueue(event.Event{Type: "purchase"})
+	if got := f.OldestPending(); !got.Equal(first) {
+		t.Errorf("OldestPending() = %v after a second event, want %v", got, first)
+	}
+
+	// Re-queued events keep their age
+	if _, err := f.Flush(context.Background()); err == nil {
+		t.Fatal("expected flush error")
+	}
+	if got := f.OldestPending(); !got.Equal(first) {
+		t.Errorf("OldestPending() = %v after a failed flush, want %v", got, first)
+	}
+
+	dest.fail = nil
+	if _, err := f.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.OldestPending(); !got.IsZero() {
+		t.Errorf("OldestPending() = %v after delivery, want zero", got)
+	}
+}
+
+func TestForwarderMetrics(t *testing.T) {
+	m := metrics.InitMetrics()
+	f := startTestForwarder(t, &fakeDestination{}, ForwardConfig{BatchSize: 10, MaxAttempts: 1})
+	f.Metrics = m
+
+	histogram := func() *dto.Histogram {
+		var out dto.Metric
+		if err := m.SinkBatchSize.WithLabelValues("fake").(prometheus.Metric).Write(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out.GetHistogram()
+	}
+	before := histogram()
+
+	for i := 0; i < 3; i++ {
+		_ = f.Enqueue(event.Event{Type: "purchase"})
+	}
+	if _, err := f.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Flush(context.Background()); err != nil { // empty, not observed
+		t.Fatal(err)
+	}
+
+	after := histogram()
+	if got := after.GetSampleCount() - before.GetSampleCount(); got != 1 {
+		t.Errorf("flushes observed = %d, want 1", got)
+	}
+	if got := after.GetSampleSum() - before.GetSampleSum(); got != 3 {
+		t.Errorf("events observed = %v, want 3", got)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	for code, permanent := range map[int]bool{400: true, 403: true, 408: false, 429: false, 500: false, 503: false} {
+		rec := httptest.NewRecorder()
+		rec.WriteHeader(code)
+		err := checkResponse(rec.Result())
+		var p PermanentError
+		if err == nil || errors.As(err, &p) != permanent {
+			t.Errorf("status %d: err = %v, want permanent = %v", code, err, permanent)
+		}
+	}
+	rec := httptest.NewRecorder()
+	rec.WriteHeader(http.StatusOK)
+	if err := checkResponse(rec.Result()); err != nil {
+		t.Errorf("status 200: %v", err)
+	}
+}
+
+func TestParseEventMap(t *testing.T) {
+	m, err := parseEventMap(" purchase=Purchase, sign_up ,")
+	if err != nil || len(m) != 2 || m["purchase"] != "Purchase" || m["sign_up"] != "sign_up" {
+		t.Errorf("parseEventMap = %v, %v", m, err)
+	}
+	if _, err := parseEventMap("=Purchase"); err == nil {
+		t.Error("expected error for a missing type")
+	}
+}
+
+func TestConversionValue(t *testing.T) {
+	props := event.Event{Props: map[string]string{"value": "12.5", "currency": "usd"}}
+	if v, c, ok := conversionValue(props); !ok || v != 12.5 || c != "USD" {
+		t.Errorf("props: %v %q %v", v, c, ok)
+	}
+	order := props
+	order.Ecommerce = &event.EcommerceInfo{OrderID: "A1", Value: 30, Currency: "EUR"}
+	if v, c, ok := conversionValue(order); !ok || v != 30 || c != "EUR" {
+		t.Errorf("ecommerce: %v %q %v", v, c, ok)
+	}
+	if _, _, ok := conversionValue(event.Event{}); ok {
+		t.Error("expected no value for a plain event")
+	}
+}
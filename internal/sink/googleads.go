@@ -0,0 +1,269 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// GoogleAdsConfig holds the Google Ads API credentials and conversion mapping
+type GoogleAdsConfig struct {
+	CustomerID      string // account the conversions belong to, digits only
+	LoginCustomerID string // manager account, when access goes through one
+	DeveloperToken  string
+
+	// OAuth: a refresh token is exchanged for access tokens as they expire.
+	// A static AccessToken is used when no refresh token is configured.
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+	AccessToken  string
+
+	APIVersion  string            // e.g. v18
+	Conversions map[string]string // GoTrack type -> conversion action ID or resource name
+	BaseURL     string            // Google Ads API origin; overridden in tests
+	TokenURL    string            // OAuth token endpoint; overridden in tests
+}
+
+// GoogleAds uploads click conversions to Google Ads. An event qualifies when
+// its type is mapped and it carries a gclid, gbraid or wbraid. Conversions
+// with a gclid are enhanced with the hashed email and phone props.
+type GoogleAds struct {
+	config GoogleAdsConfig
+	client *http.Client
+
+	tokenMu     sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewGoogleAdsSinkFromEnv creates a Google Ads conversion upload forwarder
+// from environment variables
+func NewGoogleAdsSinkFromEnv() (*Forwarder, error) {
+	conversions, err := parseEventMap(os.Getenv("GOOGLE_ADS_CONVERSIONS"))
+	if err != nil {
+		return nil, fmt.Errorf("GOOGLE_ADS_CONVERSIONS: %w", err)
+	}
+	return NewForwarder(NewGoogleAds(GoogleAdsConfig{
+		CustomerID:      os.Getenv("GOOGLE_ADS_CUSTOMER_ID"),
+		LoginCustomerID: os.Getenv("GOOGLE_ADS_LOGIN_CUSTOMER_ID"),
+		DeveloperToken:  os.Getenv("GOOGLE_ADS_DEVELOPER_TOKEN"),
+		ClientID:        os.Getenv("GOOGLE_ADS_CLIENT_ID"),
+		ClientSecret:    os.Getenv("GOOGLE_ADS_CLIENT_SECRET"),
+		RefreshToken:    os.Getenv("GOOGLE_ADS_REFRESH_TOKEN"),
+		AccessToken:     os.Getenv("GOOGLE_ADS_ACCESS_TOKEN"),
+		APIVersion:      getEnvOr("GOOGLE_ADS_API_VERSION", "v18"),
+		Conversions:     conversions,
+	}), forwardConfigFromEnv()), nil
+}
+
+// NewGoogleAds creates the Google Ads destination with explicit configuration
+func NewGoogleAds(config GoogleAdsConfig) *GoogleAds {
+	config.CustomerID = strings.ReplaceAll(config.CustomerID, "-", "")
+	config.LoginCustomerID = strings.ReplaceAll(config.LoginCustomerID, "-", "")
+	if config.BaseURL == "" {
+		config.BaseURL = "https://googleads.googleapis.com"
+	}
+	if config.TokenURL == "" {
+		config.TokenURL = "https://oauth2.googleapis.com/token"
+	}
+	// Conversion action IDs become resource names under the customer
+	conversions := make(map[string]string, len(config.Conversions))
+	for typ, action := range config.Conversions {
+		if !strings.HasPrefix(action, "customers/") {
+			action = "customers/" + config.CustomerID + "/conversionActions/" + action
+		}
+		conversions[typ] = action
+	}
+	config.Conversions = conversions
+	return &GoogleAds{config: config, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (g *GoogleAds) Name() string  { return "google_ads" }
+func (g *GoogleAds) MaxBatch() int { return 2000 }
+
+func (g *GoogleAds) Check() error {
+	if g.config.CustomerID == "" || g.config.DeveloperToken == "" {
+		return fmt.Errorf("GOOGLE_ADS_CUSTOMER_ID and GOOGLE_ADS_DEVELOPER_TOKEN are required for the google_ads sink")
+	}
+	oauth := g.config.RefreshToken != "" && g.config.ClientID != "" && g.config.ClientSecret != ""
+	if !oauth && g.config.AccessToken == "" {
+		return fmt.Errorf("google_ads sink needs GOOGLE_ADS_CLIENT_ID, GOOGLE_ADS_CLIENT_SECRET and GOOGLE_ADS_REFRESH_TOKEN, or GOOGLE_ADS_ACCESS_TOKEN")
+	}
+	if len(g.config.Conversions) == 0 {
+		return fmt.Errorf("GOOGLE_ADS_CONVERSIONS maps no event types")
+	}
+	return nil
+}
+
+func (g *GoogleAds) Qualifies(e event.Event) bool {
+	if _, ok := g.config.Conversions[e.Type]; !ok {
+		return false
+	}
+	google := e.URL.Google
+	return google.GCLID != "" || google.GBRAID != "" || google.WBRAID != ""
+}
+
+// clickConversion is one entry of an uploadClickConversions request
+type clickConversion struct {
+	ConversionAction   string           `json:"conversionAction"`
+	ConversionDateTime string           `json:"conversionDateTime"`
+	GCLID              string           `json:"gclid,omitempty"`
+	GBRAID             string           `json:"gbraid,omitempty"`
+	WBRAID             string           `json:"wbraid,omitempty"`
+	ConversionValue    *float64         `json:"conversionValue,omitempty"`
+	CurrencyCode       string           `json:"currencyCode,omitempty"`
+	OrderID            string           `json:"orderId,omitempty"` // makes re-uploads after a retry idempotent
+	UserIdentifiers    []map[string]any `json:"userIdentifiers,omitempty"`
+}
+
+func (g *GoogleAds) Send(ctx context.Context, events []event.Event) error {
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(map[string]any{
+		"conversions":    g.convert(events),
+		"partialFailure": true, // one bad conversion does not reject the batch
+	})
+	if err != nil {
+		return PermanentError{err}
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/customers/%s:uploadClickConversions", g.config.BaseURL, g.config.APIVersion, g.config.CustomerID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return PermanentError{fmt.Errorf("failed to create google ads request: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("developer-token", g.config.DeveloperToken)
+	if g.config.LoginCustomerID != "" {
+		req.Header.Set("login-customer-id", g.config.LoginCustomerID)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("google ads request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized && g.config.RefreshToken != "" {
+		g.resetToken() // retried with a fresh token
+		return fmt.Errorf("google ads access token rejected")
+	}
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	var result struct {
+		PartialFailureError *struct {
+			Message string `json:"message"`
+		} `json:"partialFailureError"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && result.PartialFailureError != nil {
+		fmt.Fprintf(os.Stderr, "google_ads rejected some conversions: %s\n", result.PartialFailureError.Message)
+	}
+	return nil
+}
+
+func (g *GoogleAds) convert(events []event.Event) []clickConversion {
+	out := make([]clickConversion, len(events))
+	for i, e := range events {
+		c := clickConversion{
+			ConversionAction:   g.config.Conversions[e.Type],
+			ConversionDateTime: eventTime(e).UTC().Format("2006-01-02 15:04:05-07:00"),
+			OrderID:            e.EventID,
+		}
+		if e.Ecommerce != nil {
+			c.OrderID = e.Ecommerce.OrderID
+		}
+		// The API takes exactly one click ID; gbraid and wbraid do not allow user identifiers
+		switch google := e.URL.Google; {
+		case google.GCLID != "":
+			c.GCLID = google.GCLID
+			c.UserIdentifiers = googleUserIdentifiers(e)
+		case google.GBRAID != "":
+			c.GBRAID = google.GBRAID
+		default:
+			c.WBRAID = google.WBRAID
+		}
+		if value, currency, ok := conversionValue(e); ok {
+			c.ConversionValue = &value
+			c.CurrencyCode = currency
+		}
+		out[i] = c
+	}
+	return out
+}
+
+// googleUserIdentifiers returns the enhanced conversion identifiers, hashed
+// with SHA-256. Phone numbers are hashed in E.164 form.
+func googleUserIdentifiers(e event.Event) []map[string]any {
+	var ids []map[string]any
+	if email := normalizedEmail(e); email != "" {
+		ids = append(ids, map[string]any{"hashedEmail": sha256Hex(email)})
+	}
+	if phone := phoneDigits(e); phone != "" {
+		ids = append(ids, map[string]any{"hashedPhoneNumber": sha256Hex("+" + phone)})
+	}
+	return ids
+}
+
+// accessToken returns a valid OAuth access token, refreshing it a minute
+// before it expires
+func (g *GoogleAds) accessToken(ctx context.Context) (string, error) {
+	if g.config.RefreshToken == "" {
+		return g.config.AccessToken, nil
+	}
+	g.tokenMu.Lock()
+	defer g.tokenMu.Unlock()
+	if g.token != "" && time.Until(g.tokenExpiry) > time.Minute {
+		return g.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {g.config.ClientID},
+		"client_secret": {g.config.ClientSecret},
+		"refresh_token": {g.config.RefreshToken},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", PermanentError{fmt.Errorf("failed to create token request: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("google oauth request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", fmt.Errorf("google oauth token refresh: %w", err)
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil || tok.AccessToken == "" {
+		return "", fmt.Errorf("invalid google oauth token response")
+	}
+	g.token = tok.AccessToken
+	g.tokenExpiry = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return g.token, nil
+}
+
+func (g *GoogleAds) resetToken() {
+	g.tokenMu.Lock()
+	g.token = ""
+	g.tokenMu.Unlock()
+}
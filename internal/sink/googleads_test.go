@@ -0,0 +1,166 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// fakeGoogleAds serves the OAuth token and uploadClickConversions endpoints
+type fakeGoogleAds struct {
+	mu          sync.Mutex
+	tokens      int
+	rejectToken string // access token answered with 401
+	headers     http.Header
+	path        string
+	body        struct {
+		Conversions    []clickConversion `json:"conversions"`
+		PartialFailure bool              `json:"partialFailure"`
+	}
+}
+
+func (f *fakeGoogleAds) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		if r.FormValue("refresh_token") != "refresh" || r.FormValue("grant_type") != "refresh_token" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		f.tokens++
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "access-" + string(rune('0'+f.tokens)), "expires_in": 3600})
+		return
+	}
+	if r.Header.Get("Authorization") == "Bearer "+f.rejectToken {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return
+	}
+	f.headers = r.Header.Clone()
+	f.path = r.URL.Path
+	_ = json.NewDecoder(r.Body).Decode(&f.body)
+	_, _ = w.Write([]byte(`{"results":[{}]}`))
+}
+
+func newTestGoogleAds(url string) *GoogleAds {
+	return NewGoogleAds(GoogleAdsConfig{
+		CustomerID:      "123-456-7890",
+		LoginCustomerID: "111-222-3333",
+		DeveloperToken:  "dev",
+		ClientID:        "client",
+		ClientSecret:    "secret",
+		RefreshToken:    "refresh",
+		APIVersion:      "v18",
+		Conversions:     map[string]string{"purchase": "42", "sign_up": "customers/1/conversionActions/7"},
+		BaseURL:         url,
+		TokenURL:        url + "/token",
+	})
+}
+
+func TestGoogleAdsSend(t *testing.T) {
+	fake := &fakeGoogleAds{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ads := newTestGoogleAds(server.URL)
+	if err := ads.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	click := event.Event{
+		EventID: "ev-1",
+		Type:    "purchase",
+		TS:      "2024-01-02T03:04:05Z",
+		Props:   map[string]string{"value": "25", "currency": "eur", "email": "Jane@Example.com", "phone": "+1 555 010 9999"},
+	}
+	click.URL.Google.GCLID = "gclid-1"
+	app := event.Event{EventID: "ev-2", Type: "sign_up", TS: "2024-01-02T03:04:05Z"}
+	app.URL.Google.GBRAID = "gbraid-1"
+
+	if err := ads.Send(context.Background(), []event.Event{click, app}); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.path != "/v18/customers/1234567890:uploadClickConversions" {
+		t.Errorf("path = %q", fake.path)
+	}
+	if fake.headers.Get("Authorization") != "Bearer access-1" || fake.headers.Get("developer-token") != "dev" ||
+		fake.headers.Get("login-customer-id") != "1112223333" {
+		t.Errorf("headers = %v", fake.headers)
+	}
+	if !fake.body.PartialFailure || len(fake.body.Conversions) != 2 {
+		t.Fatalf("body = %+v", fake.body)
+	}
+
+	c := fake.body.Conversions[0]
+	if c.ConversionAction != "customers/1234567890/conversionActions/42" || c.GCLID != "gclid-1" ||
+		c.ConversionDateTime != "2024-01-02 03:04:05+00:00" || c.OrderID != "ev-1" ||
+		c.ConversionValue == nil || *c.ConversionValue != 25 || c.CurrencyCode != "EUR" {
+		t.Errorf("conversion = %+v", c)
+	}
+	if len(c.UserIdentifiers) != 2 || c.UserIdentifiers[0]["hashedEmail"] != sha256Hex("jane@example.com") ||
+		c.UserIdentifiers[1]["hashedPhoneNumber"] != sha256Hex("+15550109999") {
+		t.Errorf("user identifiers = %v", c.UserIdentifiers)
+	}
+
+	c = fake.body.Conversions[1]
+	if c.ConversionAction != "customers/1/conversionActions/7" || c.GBRAID != "gbraid-1" || c.GCLID != "" || c.UserIdentifiers != nil {
+		t.Errorf("app conversion = %+v", c)
+	}
+}
+
+func TestGoogleAdsTokenRefresh(t *testing.T) {
+	fake := &fakeGoogleAds{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ads := newTestGoogleAds(server.URL)
+	e := event.Event{Type: "purchase"}
+	e.URL.Google.GCLID = "g"
+
+	for i := 0; i < 2; i++ {
+		if err := ads.Send(context.Background(), []event.Event{e}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.tokens != 1 {
+		t.Errorf("token requests = %d, want the token cached", fake.tokens)
+	}
+
+	// A revoked token is dropped and the retry fetches a new one
+	fake.rejectToken = "access-1"
+	err := ads.Send(context.Background(), []event.Event{e})
+	var permanent PermanentError
+	if err == nil || errors.As(err, &permanent) {
+		t.Fatalf("err = %v, want a retryable error", err)
+	}
+	if err := ads.Send(context.Background(), []event.Event{e}); err != nil || fake.tokens != 2 {
+		t.Errorf("err = %v after %d token requests", err, fake.tokens)
+	}
+}
+
+func TestGoogleAdsQualifies(t *testing.T) {
+	ads := newTestGoogleAds("")
+	e := event.Event{Type: "purchase"}
+	if ads.Qualifies(e) {
+		t.Error("purchase without a click ID should not qualify")
+	}
+	e.URL.Google.WBRAID = "w"
+	if !ads.Qualifies(e) {
+		t.Error("purchase with wbraid should qualify")
+	}
+	e.Type = "pageview"
+	if ads.Qualifies(e) {
+		t.Error("unmapped type should not qualify")
+	}
+
+	if err := NewGoogleAds(GoogleAdsConfig{CustomerID: "1", DeveloperToken: "d", Conversions: map[string]string{"a": "1"}}).Check(); err == nil {
+		t.Error("expected error without credentials")
+	}
+}
@@ -2,13 +2,27 @@ package sink
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
-	"github.com/shortontech/gotrack/internal/event"
+	"github.com/shortontech/gotrack/internal/metrics"
+	"github.com/shortontech/gotrack/internal/serde"
+	"github.com/shortontech/gotrack/internal/tracing"
+	"github.com/shortontech/gotrack/pkg/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // KafkaConfig holds configuration for Kafka producer
@@ -17,6 +31,8 @@ type KafkaConfig struct {
 	Topic       string
 	Acks        string
 	Compression string
+	KeyStrategy string // message key: event_id, visitor_id, session_id or ip_hash
+	Client      string // client library: confluent (librdkafka, needs cgo) or franz (pure Go)
 
 	// SASL config
 	SASLMechanism string
@@ -26,14 +42,125 @@ type KafkaConfig struct {
 	// TLS config
 	TLSCAPath     string
 	TLSSkipVerify bool
+
+	// Serialization: json (default), avro or protobuf. Avro and Protobuf
+	// register the event schema with the Schema Registry under <topic>-value.
+	Serialization          string
+	SchemaRegistryURL      string
+	SchemaRegistryUser     string
+	SchemaRegistryPassword string
+
+	// Delivery guarantees
+	Idempotent        bool          // enable.idempotence; requires Acks=all
+	TransactionalID   string        // enables transactional mode when set
+	TxnCommitInterval time.Duration // how often an open transaction is committed
+	MaxInFlight       int           // events produced but not yet acknowledged
+	DeliveryRetries   int           // re-produce attempts after a failed delivery report
 }
 
-// KafkaSink produces events to Kafka with key=event_id for idempotency
+// KafkaSink produces events to Kafka, keyed per KeyStrategy.
+// Every produced event holds an in-flight slot until its delivery report (or,
+// in transactional mode, its transaction commit) confirms it, which gives
+// at-least-once delivery for everything Enqueue accepted.
 type KafkaSink struct {
-	config   KafkaConfig
-	producer *kafka.Producer
+	config     KafkaConfig
+	producer   kafkaProducer
+	serializer serde.Serializer
+
+	// Metrics receives delivery error counts and the in-flight gauge; optional
+	Metrics *metrics.Metrics
+
+	inflight atomic.Int64
+
+	// Transactional mode: producers hold txnMu for reading while the commit
+	// loop takes it exclusively, so no message straddles a commit
+	txnMu      sync.RWMutex
+	pendingMu  sync.Mutex
+	txnPending []*kafkaRecord
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// Kafka client libraries (KAFKA_CLIENT). Builds without cgo only have franz,
+// which is also their default.
+const (
+	KafkaClientConfluent = "confluent"
+	KafkaClientFranz     = "franz"
+)
+
+// kafkaProducer is the client library behind KafkaSink. Implementations
+// report every produced record to the sink's handleDelivery once it is
+// acknowledged or has failed.
+type kafkaProducer interface {
+	produce(rec *kafkaRecord) error
+	flush(timeout time.Duration) (remaining int)
+	ping(timeout time.Duration) error
+	close() // discards anything still unacknowledged
+
+	initTxn(ctx context.Context) error
+	beginTxn() error
+	commitTxn(ctx context.Context) error
+	abortTxn(ctx context.Context) error
+	// abortable reports whether a failed commit can be recovered by aborting
+	abortable(err error) bool
+}
+
+// kafkaRecord is a message as KafkaSink hands it to the client library
+type kafkaRecord struct {
+	Key     []byte
+	Value   []byte
+	Headers []kafkaHeader
+	attempt int // re-produce attempts so far
+}
+
+type kafkaHeader struct {
+	Key   string
+	Value []byte
+}
+
+// Kafka message key strategies (KAFKA_KEY)
+const (
+	KafkaKeyEventID   = "event_id"
+	KafkaKeyVisitorID = "visitor_id"
+	KafkaKeySessionID = "session_id"
+	KafkaKeyIPHash    = "ip_hash"
+)
+
+// validKafkaKey reports an error for an unknown key strategy. Empty means event_id.
+func validKafkaKey(strategy string) error {
+	switch strategy {
+	case "", KafkaKeyEventID, KafkaKeyVisitorID, KafkaKeySessionID, KafkaKeyIPHash:
+		return nil
+	}
+	return fmt.Errorf("unknown KAFKA_KEY %q (want event_id, visitor_id, session_id or ip_hash)", strategy)
 }
 
+// kafkaKey returns the message key for e. Events missing the chosen field
+// fall back to event_id so they still spread across partitions. The ip_hash
+// key is a SHA-256 of the event's IP field, so a raw IP never appears in the
+// key even when the sink keeps it in the payload.
+func kafkaKey(strategy string, e event.Event) []byte {
+	var key string
+	switch strategy {
+	case KafkaKeyVisitorID:
+		key = e.Session.VisitorID
+	case KafkaKeySessionID:
+		key = e.Session.SessionID
+	case KafkaKeyIPHash:
+		if e.Server.IP != "" {
+			sum := sha256.Sum256([]byte(e.Server.IP))
+			key = hex.EncodeToString(sum[:])
+		}
+	}
+	if key == "" {
+		key = e.EventID
+	}
+	return []byte(key)
+}
+
+// errKafkaInFlightFull is returned by Enqueue when MaxInFlight events are unacknowledged
+var errKafkaInFlightFull = errors.New("kafka in-flight limit reached")
+
 // NewKafkaSinkFromEnv creates a KafkaSink from environment variables
 func NewKafkaSinkFromEnv() *KafkaSink {
 	brokersStr := os.Getenv("KAFKA_BROKERS")
@@ -50,11 +177,24 @@ func NewKafkaSinkFromEnv() *KafkaSink {
 		Topic:         getEnvOr("KAFKA_TOPIC", "gotrack.events"),
 		Acks:          getEnvOr("KAFKA_ACKS", "all"),
 		Compression:   getEnvOr("KAFKA_COMPRESSION", ""),
+		KeyStrategy:   getEnvOr("KAFKA_KEY", KafkaKeyEventID),
+		Client:        getEnvOr("KAFKA_CLIENT", defaultKafkaClient),
 		SASLMechanism: os.Getenv("KAFKA_SASL_MECHANISM"),
 		SASLUser:      os.Getenv("KAFKA_SASL_USER"),
 		SASLPassword:  os.Getenv("KAFKA_SASL_PASSWORD"),
 		TLSCAPath:     os.Getenv("KAFKA_TLS_CA"),
 		TLSSkipVerify: getBoolEnv("KAFKA_TLS_SKIP_VERIFY", false),
+
+		Serialization:          getEnvOr("KAFKA_SERIALIZATION", serde.FormatJSON),
+		SchemaRegistryURL:      os.Getenv("KAFKA_SCHEMA_REGISTRY_URL"),
+		SchemaRegistryUser:     os.Getenv("KAFKA_SCHEMA_REGISTRY_USER"),
+		SchemaRegistryPassword: os.Getenv("KAFKA_SCHEMA_REGISTRY_PASSWORD"),
+
+		Idempotent:        getBoolEnv("KAFKA_IDEMPOTENT", false),
+		TransactionalID:   os.Getenv("KAFKA_TRANSACTIONAL_ID"),
+		TxnCommitInterval: time.Duration(getIntEnv("KAFKA_TXN_COMMIT_MS", 1000)) * time.Millisecond,
+		MaxInFlight:       getIntEnv("KAFKA_MAX_INFLIGHT", 10000),
+		DeliveryRetries:   getIntEnv("KAFKA_DELIVERY_RETRIES", 3),
 	}
 
 	return &KafkaSink{config: config}
@@ -67,144 +207,421 @@ func NewKafkaSink(brokers []string, topic string) *KafkaSink {
 			Brokers: brokers,
 			Topic:   topic,
 			Acks:    "all",
+
+			KeyStrategy:       KafkaKeyEventID,
+			Client:            defaultKafkaClient,
+			TxnCommitInterval: time.Second,
+			MaxInFlight:       10000,
+			DeliveryRetries:   3,
 		},
 	}
 }
 
 func (s *KafkaSink) Start(ctx context.Context) error {
-	configMap := kafka.ConfigMap{
-		"bootstrap.servers": strings.Join(s.config.Brokers, ","),
-		"acks":              s.config.Acks,
-		"retries":           10,
-		"retry.backoff.ms":  100,
-		"batch.size":        16384,
-		"linger.ms":         10,
-	}
-
-	// Set compression if specified
-	if s.config.Compression != "" {
-		configMap["compression.type"] = s.config.Compression
-	}
-
-	// Configure SASL if specified
-	if s.config.SASLMechanism != "" {
-		configMap["security.protocol"] = "SASL_SSL"
-		configMap["sasl.mechanism"] = s.config.SASLMechanism
-		if s.config.SASLUser != "" {
-			configMap["sasl.username"] = s.config.SASLUser
-		}
-		if s.config.SASLPassword != "" {
-			configMap["sasl.password"] = s.config.SASLPassword
-		}
+	if err := validKafkaKey(s.config.KeyStrategy); err != nil {
+		return err
 	}
-
-	// Configure TLS if CA path is provided
-	if s.config.TLSCAPath != "" {
-		if s.config.SASLMechanism == "" {
-			configMap["security.protocol"] = "SSL"
-		}
-		configMap["ssl.ca.location"] = s.config.TLSCAPath
+	switch s.config.Client {
+	case "":
+		s.config.Client = defaultKafkaClient
+	case KafkaClientConfluent, KafkaClientFranz:
+	default:
+		return fmt.Errorf("unknown KAFKA_CLIENT %q (want confluent or franz)", s.config.Client)
+	}
+	if err := serde.ValidFormat(s.config.Serialization); err != nil {
+		return err
+	}
+	transactional := s.config.TransactionalID != ""
+	if (s.config.Idempotent || transactional) && s.config.Acks != "all" {
+		return fmt.Errorf("kafka idempotent and transactional modes require KAFKA_ACKS=all, got %q", s.config.Acks)
 	}
 
-	// Configure TLS verification
-	if s.config.TLSSkipVerify {
-		configMap["ssl.endpoint.identification.algorithm"] = "none"
+	var registry *serde.Registry
+	if s.config.SchemaRegistryURL != "" {
+		registry = serde.NewRegistry(s.config.SchemaRegistryURL, s.config.SchemaRegistryUser, s.config.SchemaRegistryPassword)
 	}
+	serializer, err := serde.New(ctx, s.config.Serialization, registry, s.config.Topic+"-value")
+	if err != nil {
+		return err
+	}
+	s.serializer = serializer
 
-	producer, err := kafka.NewProducer(&configMap)
+	var producer kafkaProducer
+	if s.config.Client == KafkaClientFranz {
+		producer, err = newFranzProducer(s)
+	} else {
+		producer, err = newConfluentProducer(s)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create Kafka producer: %w", err)
 	}
 
+	if transactional {
+		initCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := producer.initTxn(initCtx)
+		cancel()
+		if err == nil {
+			err = producer.beginTxn()
+		}
+		if err != nil {
+			producer.close()
+			return fmt.Errorf("failed to initialize Kafka transactions: %w", err)
+		}
+	}
+
 	s.producer = producer
+	s.done = make(chan struct{})
 
-	// Start delivery report handler in background
-	go s.handleDeliveryReports(ctx)
+	if transactional {
+		var loopCtx context.Context
+		loopCtx, s.cancel = context.WithCancel(ctx)
+		go s.commitLoop(loopCtx)
+	} else {
+		close(s.done)
+	}
 
 	return nil
 }
 
 func (s *KafkaSink) Enqueue(e event.Event) error {
+	return s.EnqueueContext(context.Background(), e)
+}
+
+// EnqueueContext produces e in a kafka.produce span and passes the trace
+// context on to consumers in the message headers
+func (s *KafkaSink) EnqueueContext(ctx context.Context, e event.Event) (err error) {
 	if s.producer == nil {
 		return fmt.Errorf("kafka producer not initialized")
 	}
 
-	// Serialize event to JSON
-	value, err := json.Marshal(e)
+	ctx, span := tracing.Tracer().Start(ctx, "kafka.produce",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(s.config.Topic),
+			semconv.MessagingOperationPublish,
+		))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	value, err := s.serializer.Serialize(e)
 	if err != nil {
 		return fmt.Errorf("failed to serialize event: %w", err)
 	}
 
-	// Create Kafka message with event_id as key for idempotency
-	msg := &kafka.Message{
-		TopicPartition: kafka.TopicPartition{
-			Topic:     &s.config.Topic,
-			Partition: kafka.PartitionAny,
-		},
-		Key:   []byte(e.EventID),
+	// The key picks the partition, so events sharing a key stay in order
+	rec := &kafkaRecord{
+		Key:   kafkaKey(s.config.KeyStrategy, e),
 		Value: value,
-		Headers: []kafka.Header{
+		Headers: []kafkaHeader{
 			{Key: "event_type", Value: []byte(e.Type)},
 			{Key: "schema", Value: []byte("v1")},
+			{Key: "format", Value: []byte(s.serializer.Format())},
 		},
 	}
+	if e.SiteID != "" {
+		rec.Headers = append(rec.Headers, kafkaHeader{Key: "site_id", Value: []byte(e.SiteID)})
+	}
+	rec.Headers = append(rec.Headers, traceHeaders(ctx)...)
 
-	// Send message asynchronously
-	err = s.producer.Produce(msg, nil)
-	if err != nil {
+	if !s.acquire() {
+		return fmt.Errorf("%w (%d)", errKafkaInFlightFull, s.config.MaxInFlight)
+	}
+
+	// Send message asynchronously; the slot is released by the delivery report
+	if err := s.produce(rec); err != nil {
+		s.release(1)
 		return fmt.Errorf("failed to produce message: %w", err)
 	}
 
 	return nil
 }
 
+// traceHeaders returns the W3C trace context of ctx as message headers
+func traceHeaders(ctx context.Context) []kafkaHeader {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	keys := carrier.Keys()
+	sort.Strings(keys)
+	headers := make([]kafkaHeader, 0, len(keys))
+	for _, k := range keys {
+		headers = append(headers, kafkaHeader{Key: k, Value: []byte(carrier.Get(k))})
+	}
+	return headers
+}
+
+// produce sends rec, recording it against the open transaction in transactional mode
+func (s *KafkaSink) produce(rec *kafkaRecord) error {
+	if s.config.TransactionalID == "" {
+		return s.producer.produce(rec)
+	}
+
+	s.txnMu.RLock()
+	defer s.txnMu.RUnlock()
+	if err := s.producer.produce(rec); err != nil {
+		return err
+	}
+	s.pendingMu.Lock()
+	s.txnPending = append(s.txnPending, rec)
+	s.pendingMu.Unlock()
+	return nil
+}
+
 func (s *KafkaSink) Close() error {
 	if s.producer == nil {
 		return nil
 	}
 
+	// Commit the open transaction before shutting the producer down
+	if s.cancel != nil {
+		s.cancel()
+	}
+	<-s.done
+
 	// Flush any remaining messages (wait up to 10 seconds)
-	remaining := s.producer.Flush(10 * 1000)
+	remaining := s.producer.flush(kafkaFlushTimeout)
+	s.producer.close()
 	if remaining > 0 {
 		return fmt.Errorf("failed to flush %d remaining messages", remaining)
 	}
-
-	s.producer.Close()
 	return nil
 }
 
+// kafkaFlushTimeout bounds Flush when ctx has no deadline
+const kafkaFlushTimeout = 10 * time.Second
+
+// Flush waits for produced messages to be acknowledged, committing the open
+// transaction first in transactional mode. It returns how many in-flight
+// events were acknowledged.
+func (s *KafkaSink) Flush(ctx context.Context) (int, error) {
+	if s.producer == nil {
+		return 0, fmt.Errorf("kafka producer not initialized")
+	}
+	timeout := kafkaFlushTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if timeout <= 0 {
+		return 0, ctx.Err()
+	}
+
+	before := s.InFlight()
+	if s.config.TransactionalID != "" {
+		s.commitTxn(true)
+	}
+	remaining := s.producer.flush(timeout)
+	flushed := max(before-s.InFlight(), 0)
+	if remaining > 0 {
+		return flushed, fmt.Errorf("%d messages still unacknowledged", remaining)
+	}
+	return flushed, nil
+}
+
+// kafkaPingTimeout bounds the broker request when ctx has no deadline
+const kafkaPingTimeout = 2 * time.Second
+
+// Ping checks that a broker is reachable
+func (s *KafkaSink) Ping(ctx context.Context) error {
+	if s.producer == nil {
+		return fmt.Errorf("kafka producer not initialized")
+	}
+	timeout := kafkaPingTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if timeout <= 0 {
+		return ctx.Err()
+	}
+	return s.producer.ping(timeout)
+}
+
 func (s *KafkaSink) Name() string {
 	return "kafka"
 }
 
-// handleDeliveryReports processes delivery reports in background
-func (s *KafkaSink) handleDeliveryReports(ctx context.Context) {
+// Load reports events not yet acknowledged by the brokers. The client
+// batches internally, so there is no flush latency to report.
+func (s *KafkaSink) Load() (int, time.Duration) {
+	return int(s.inflight.Load()), 0
+}
+
+// InFlight returns the number of events produced but not yet acknowledged
+func (s *KafkaSink) InFlight() int {
+	return int(s.inflight.Load())
+}
+
+// acquire takes an in-flight slot, reporting false when the limit is reached
+func (s *KafkaSink) acquire() bool {
+	n := s.inflight.Add(1)
+	if s.config.MaxInFlight > 0 && n > int64(s.config.MaxInFlight) {
+		s.inflight.Add(-1)
+		return false
+	}
+	s.setInFlightGauge(n)
+	return true
+}
+
+// release returns n in-flight slots
+func (s *KafkaSink) release(n int) {
+	s.setInFlightGauge(s.inflight.Add(int64(-n)))
+}
+
+func (s *KafkaSink) setInFlightGauge(n int64) {
+	if s.Metrics != nil {
+		s.Metrics.SetKafkaInFlight(float64(n))
+	}
+}
+
+func (s *KafkaSink) countDeliveryErrors(outcome string, n int) {
+	if s.Metrics != nil && n > 0 {
+		s.Metrics.AddKafkaDeliveryErrors(outcome, n)
+	}
+}
+
+// retryRecord copies rec for another produce attempt, or returns nil once
+// DeliveryRetries is exhausted
+func (s *KafkaSink) retryRecord(rec *kafkaRecord) *kafkaRecord {
+	if rec.attempt >= s.config.DeliveryRetries {
+		return nil
+	}
+	return &kafkaRecord{
+		Key:     rec.Key,
+		Value:   rec.Value,
+		Headers: rec.Headers,
+		attempt: rec.attempt + 1,
+	}
+}
+
+// handleDelivery settles one delivery report; fatal errors are never
+// retried. Failed messages are re-produced while retries remain, keeping
+// their in-flight slot. In transactional mode slots are settled by the
+// commit loop instead, since a failed message fails the whole transaction.
+func (s *KafkaSink) handleDelivery(rec *kafkaRecord, err error, fatal bool) {
+	if s.config.TransactionalID != "" {
+		if err != nil {
+			log.Printf("kafka: delivery failed in transaction: %v", err)
+		}
+		return
+	}
+
+	if err == nil {
+		s.release(1)
+		return
+	}
+
+	if retry := s.retryRecord(rec); retry != nil && !fatal {
+		if perr := s.producer.produce(retry); perr == nil {
+			s.countDeliveryErrors("retried", 1)
+			return
+		}
+	}
+
+	log.Printf("kafka: delivery failed for event %s: %v", rec.Key, err)
+	s.countDeliveryErrors("dropped", 1)
+	s.release(1)
+}
+
+// commitLoop periodically commits the open transaction and begins the next
+// one. The final commit happens when ctx is cancelled.
+func (s *KafkaSink) commitLoop(ctx context.Context) {
+	defer close(s.done)
+
+	interval := s.config.TxnCommitInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
+			s.commitTxn(false)
 			return
-		default:
-			e := s.producer.Events()
-			select {
-			case <-ctx.Done():
-				return
-			case ev := <-e:
-				switch event := ev.(type) {
-				case *kafka.Message:
-					if event.TopicPartition.Error != nil {
-						// In production, you might want to log this to a structured logger
-						// or send to an error monitoring system
-						fmt.Fprintf(os.Stderr, "Kafka delivery failed: %v\n", event.TopicPartition.Error)
-					}
-				case kafka.Error:
-					// Kafka client errors
-					fmt.Fprintf(os.Stderr, "Kafka error: %v\n", event)
-				}
-			}
+		case <-ticker.C:
+			s.commitTxn(true)
 		}
 	}
 }
 
+// commitTxn commits the messages produced since the last commit. On an
+// abortable error the transaction is aborted and its messages are produced
+// again into the next transaction while retries remain.
+func (s *KafkaSink) commitTxn(begin bool) {
+	s.txnMu.Lock()
+	defer s.txnMu.Unlock()
+
+	s.pendingMu.Lock()
+	pending := s.txnPending
+	s.txnPending = nil
+	s.pendingMu.Unlock()
+	if len(pending) == 0 && begin {
+		return
+	}
+
+	commitCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := s.producer.commitTxn(commitCtx)
+	if err == nil {
+		s.release(len(pending))
+		if begin {
+			s.beginTxn()
+		}
+		return
+	}
+
+	log.Printf("kafka: transaction commit failed: %v", err)
+	if s.producer.abortable(err) {
+		if aerr := s.producer.abortTxn(commitCtx); aerr != nil {
+			log.Printf("kafka: transaction abort failed: %v", aerr)
+		} else if begin && s.beginTxn() {
+			s.retryTxn(pending)
+			return
+		}
+	}
+
+	// Fatal error, or shutting down: the messages cannot be retried
+	s.countDeliveryErrors("dropped", len(pending))
+	s.release(len(pending))
+}
+
+// retryTxn produces the messages of an aborted transaction into the newly
+// begun one. Callers hold txnMu exclusively.
+func (s *KafkaSink) retryTxn(pending []*kafkaRecord) {
+	var retried []*kafkaRecord
+	for _, rec := range pending {
+		retry := s.retryRecord(rec)
+		if retry == nil || s.producer.produce(retry) != nil {
+			continue
+		}
+		retried = append(retried, retry)
+	}
+
+	s.pendingMu.Lock()
+	s.txnPending = append(s.txnPending, retried...)
+	s.pendingMu.Unlock()
+
+	dropped := len(pending) - len(retried)
+	s.countDeliveryErrors("retried", len(retried))
+	s.countDeliveryErrors("dropped", dropped)
+	s.release(dropped)
+}
+
+// beginTxn opens the next transaction, reporting whether it succeeded
+func (s *KafkaSink) beginTxn() bool {
+	if err := s.producer.beginTxn(); err != nil {
+		log.Printf("kafka: failed to begin transaction: %v", err)
+		return false
+	}
+	return true
+}
+
 // Helper functions
 func getEnvOr(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -0,0 +1,163 @@
+//go:build cgo
+
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// librdkafka needs cgo, so it is only the default when cgo is available
+const defaultKafkaClient = KafkaClientConfluent
+
+// confluentProducer produces through confluent-kafka-go and librdkafka
+type confluentProducer struct {
+	sink *KafkaSink
+	p    *kafka.Producer
+}
+
+func newConfluentProducer(s *KafkaSink) (kafkaProducer, error) {
+	configMap := kafka.ConfigMap{
+		"bootstrap.servers": strings.Join(s.config.Brokers, ","),
+		"acks":              s.config.Acks,
+		"retries":           10,
+		"retry.backoff.ms":  100,
+		"batch.size":        16384,
+		"linger.ms":         10,
+	}
+
+	// Set compression if specified
+	if s.config.Compression != "" {
+		configMap["compression.type"] = s.config.Compression
+	}
+
+	// Configure SASL if specified
+	if s.config.SASLMechanism != "" {
+		configMap["security.protocol"] = "SASL_SSL"
+		configMap["sasl.mechanism"] = s.config.SASLMechanism
+		if s.config.SASLUser != "" {
+			configMap["sasl.username"] = s.config.SASLUser
+		}
+		if s.config.SASLPassword != "" {
+			configMap["sasl.password"] = s.config.SASLPassword
+		}
+	}
+
+	// Configure TLS if CA path is provided
+	if s.config.TLSCAPath != "" {
+		if s.config.SASLMechanism == "" {
+			configMap["security.protocol"] = "SSL"
+		}
+		configMap["ssl.ca.location"] = s.config.TLSCAPath
+	}
+
+	// Configure TLS verification
+	if s.config.TLSSkipVerify {
+		configMap["ssl.endpoint.identification.algorithm"] = "none"
+	}
+
+	// Transactions imply idempotence; librdkafka enables it automatically
+	if s.config.Idempotent {
+		configMap["enable.idempotence"] = true
+	}
+	if s.config.TransactionalID != "" {
+		configMap["transactional.id"] = s.config.TransactionalID
+	}
+
+	p, err := kafka.NewProducer(&configMap)
+	if err != nil {
+		return nil, err
+	}
+	c := &confluentProducer{sink: s, p: p}
+
+	// Start delivery report handler in background; it exits when the
+	// producer is closed
+	go c.handleEvents()
+	return c, nil
+}
+
+func (c *confluentProducer) produce(rec *kafkaRecord) error {
+	headers := make([]kafka.Header, len(rec.Headers))
+	for i, h := range rec.Headers {
+		headers[i] = kafka.Header{Key: h.Key, Value: h.Value}
+	}
+	return c.p.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{
+			Topic:     &c.sink.config.Topic,
+			Partition: kafka.PartitionAny,
+		},
+		Key:     rec.Key,
+		Value:   rec.Value,
+		Headers: headers,
+		Opaque:  rec,
+	}, nil)
+}
+
+// handleEvents processes delivery reports until the producer is closed
+func (c *confluentProducer) handleEvents() {
+	for ev := range c.p.Events() {
+		switch e := ev.(type) {
+		case *kafka.Message:
+			rec, ok := e.Opaque.(*kafkaRecord)
+			if !ok {
+				continue
+			}
+			err := e.TopicPartition.Error
+			var kerr kafka.Error
+			fatal := errors.As(err, &kerr) && (kerr.IsFatal() ||
+				kerr.Code() == kafka.ErrPurgeQueue || kerr.Code() == kafka.ErrPurgeInflight)
+			c.sink.handleDelivery(rec, err, fatal)
+		case kafka.Error:
+			// Kafka client errors
+			log.Printf("kafka: client error: %v", e)
+		}
+	}
+}
+
+func (c *confluentProducer) flush(timeout time.Duration) int {
+	return c.p.Flush(int(timeout.Milliseconds()))
+}
+
+// ping requests cluster metadata
+func (c *confluentProducer) ping(timeout time.Duration) error {
+	md, err := c.p.GetMetadata(nil, false, int(timeout.Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("kafka metadata request failed: %w", err)
+	}
+	if len(md.Brokers) == 0 {
+		return errors.New("no kafka brokers available")
+	}
+	return nil
+}
+
+func (c *confluentProducer) close() {
+	_ = c.p.Purge(kafka.PurgeQueue | kafka.PurgeInFlight)
+	c.p.Close()
+}
+
+func (c *confluentProducer) initTxn(ctx context.Context) error {
+	return c.p.InitTransactions(ctx)
+}
+
+func (c *confluentProducer) beginTxn() error {
+	return c.p.BeginTransaction()
+}
+
+func (c *confluentProducer) commitTxn(ctx context.Context) error {
+	return c.p.CommitTransaction(ctx)
+}
+
+func (c *confluentProducer) abortTxn(ctx context.Context) error {
+	return c.p.AbortTransaction(ctx)
+}
+
+func (c *confluentProducer) abortable(err error) bool {
+	var kerr kafka.Error
+	return errors.As(err, &kerr) && kerr.TxnRequiresAbort()
+}
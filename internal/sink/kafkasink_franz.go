@@ -0,0 +1,233 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// franzProducer produces through franz-go, a pure Go client, so it works in
+// static CGO_ENABLED=0 builds
+type franzProducer struct {
+	sink *KafkaSink
+	cl   *kgo.Client
+}
+
+func newFranzProducer(s *KafkaSink) (kafkaProducer, error) {
+	opts, err := franzOptions(s.config)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &franzProducer{sink: s, cl: cl}, nil
+}
+
+// franzOptions translates KafkaConfig into the client options matching the
+// librdkafka settings used by the confluent client
+func franzOptions(config KafkaConfig) ([]kgo.Opt, error) {
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(config.Brokers...),
+		kgo.DefaultProduceTopic(config.Topic),
+		kgo.RecordRetries(10),
+		kgo.RetryBackoffFn(func(int) time.Duration { return 100 * time.Millisecond }),
+		kgo.ProducerLinger(10 * time.Millisecond),
+	}
+
+	switch config.Acks {
+	case "all", "-1":
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	case "1":
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()))
+	case "0":
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()))
+	default:
+		return nil, fmt.Errorf("unknown KAFKA_ACKS %q (want all, 1 or 0)", config.Acks)
+	}
+
+	// franz-go is idempotent by default; follow KAFKA_IDEMPOTENT like librdkafka
+	if config.TransactionalID != "" {
+		opts = append(opts, kgo.TransactionalID(config.TransactionalID))
+	} else if !config.Idempotent {
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+
+	// A retried record keeps its in-flight slot while it is produced again,
+	// so leave room above MaxInFlight for Produce never to block
+	if config.MaxInFlight > 0 {
+		opts = append(opts, kgo.MaxBufferedRecords(2*config.MaxInFlight))
+	}
+
+	switch strings.ToLower(config.Compression) {
+	case "", "none":
+	case "gzip":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.GzipCompression()))
+	case "snappy":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.SnappyCompression()))
+	case "lz4":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.Lz4Compression()))
+	case "zstd":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.ZstdCompression()))
+	default:
+		return nil, fmt.Errorf("unknown KAFKA_COMPRESSION %q (want gzip, snappy, lz4 or zstd)", config.Compression)
+	}
+
+	if config.SASLMechanism != "" {
+		mechanism, err := franzSASL(config)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.SASL(mechanism))
+	}
+
+	// SASL implies SASL_SSL and a CA implies SSL, as with librdkafka
+	if config.SASLMechanism != "" || config.TLSCAPath != "" {
+		tlsConfig, err := kafkaTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+	return opts, nil
+}
+
+// franzSASL returns the SASL mechanism for KAFKA_SASL_MECHANISM. franz-go
+// supports PLAIN and SCRAM; GSSAPI and OAUTHBEARER need the confluent client.
+func franzSASL(config KafkaConfig) (sasl.Mechanism, error) {
+	switch strings.ToUpper(config.SASLMechanism) {
+	case "PLAIN":
+		return plain.Auth{User: config.SASLUser, Pass: config.SASLPassword}.AsMechanism(), nil
+	case "SCRAM-SHA-256":
+		return scram.Auth{User: config.SASLUser, Pass: config.SASLPassword}.AsSha256Mechanism(), nil
+	case "SCRAM-SHA-512":
+		return scram.Auth{User: config.SASLUser, Pass: config.SASLPassword}.AsSha512Mechanism(), nil
+	}
+	return nil, fmt.Errorf("KAFKA_SASL_MECHANISM %q is not supported by KAFKA_CLIENT=franz (want PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512)", config.SASLMechanism)
+}
+
+// kafkaTLSConfig builds the TLS settings for KAFKA_TLS_CA and
+// KAFKA_TLS_SKIP_VERIFY
+func kafkaTLSConfig(config KafkaConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.TLSCAPath != "" {
+		pem, err := os.ReadFile(config.TLSCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read KAFKA_TLS_CA: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in KAFKA_TLS_CA %s", config.TLSCAPath)
+		}
+	}
+	if config.TLSSkipVerify {
+		// Like ssl.endpoint.identification.algorithm=none: the certificate
+		// chain is still verified, only the host name is not
+		roots := tlsConfig.RootCAs
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyChain(rawCerts, roots)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// verifyChain verifies the peer certificate chain against roots, or the
+// system pool when roots is nil, without checking the host name
+func verifyChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errors.New("kafka broker presented no certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	return err
+}
+
+func (f *franzProducer) produce(rec *kafkaRecord) error {
+	headers := make([]kgo.RecordHeader, len(rec.Headers))
+	for i, h := range rec.Headers {
+		headers[i] = kgo.RecordHeader{Key: h.Key, Value: h.Value}
+	}
+	r := &kgo.Record{Key: rec.Key, Value: rec.Value, Headers: headers}
+	f.cl.Produce(context.Background(), r, func(_ *kgo.Record, err error) {
+		fatal := errors.Is(err, kgo.ErrClientClosed) || errors.Is(err, kgo.ErrAborting)
+		f.sink.handleDelivery(rec, err, fatal)
+	})
+	return nil
+}
+
+func (f *franzProducer) flush(timeout time.Duration) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_ = f.cl.Flush(ctx)
+	return int(f.cl.BufferedProduceRecords())
+}
+
+// ping sends an ApiVersions request to the first broker that answers
+func (f *franzProducer) ping(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := f.cl.Ping(ctx); err != nil {
+		return fmt.Errorf("no kafka brokers available: %w", err)
+	}
+	return nil
+}
+
+func (f *franzProducer) close() {
+	f.cl.Close()
+}
+
+// initTxn loads the producer ID, which is where the transactional ID is
+// registered with the coordinator
+func (f *franzProducer) initTxn(ctx context.Context) error {
+	_, _, err := f.cl.ProducerID(ctx)
+	return err
+}
+
+func (f *franzProducer) beginTxn() error {
+	return f.cl.BeginTransaction()
+}
+
+func (f *franzProducer) commitTxn(ctx context.Context) error {
+	if err := f.cl.Flush(ctx); err != nil {
+		return err
+	}
+	return f.cl.EndTransaction(ctx, kgo.TryCommit)
+}
+
+func (f *franzProducer) abortTxn(ctx context.Context) error {
+	if err := f.cl.AbortBufferedRecords(ctx); err != nil {
+		return err
+	}
+	return f.cl.EndTransaction(ctx, kgo.TryAbort)
+}
+
+// abortable reports false for errors that leave the transactional ID unusable
+func (f *franzProducer) abortable(err error) bool {
+	return !errors.Is(err, kerr.ProducerFenced) &&
+		!errors.Is(err, kerr.InvalidProducerEpoch) &&
+		!errors.Is(err, kerr.TransactionalIDAuthorizationFailed)
+}
@@ -0,0 +1,77 @@
+package sink
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFranzOptions(t *testing.T) {
+	base := KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "test", Acks: "all"}
+
+	t.Run("accepts the confluent client's settings", func(t *testing.T) {
+		for _, mutate := range []func(*KafkaConfig){
+			func(c *KafkaConfig) {},
+			func(c *KafkaConfig) { c.Acks = "1" },
+			func(c *KafkaConfig) { c.Compression = "zstd" },
+			func(c *KafkaConfig) { c.Idempotent = true },
+			func(c *KafkaConfig) { c.TransactionalID = "gotrack-1" },
+			func(c *KafkaConfig) { c.SASLMechanism, c.SASLUser, c.SASLPassword = "SCRAM-SHA-512", "u", "p" },
+		} {
+			cfg := base
+			mutate(&cfg)
+			if _, err := franzOptions(cfg); err != nil {
+				t.Errorf("franzOptions(%+v) error = %v", cfg, err)
+			}
+		}
+	})
+
+	t.Run("rejects what it can't honour", func(t *testing.T) {
+		for want, mutate := range map[string]func(*KafkaConfig){
+			"KAFKA_ACKS":           func(c *KafkaConfig) { c.Acks = "2" },
+			"KAFKA_COMPRESSION":    func(c *KafkaConfig) { c.Compression = "brotli" },
+			"KAFKA_SASL_MECHANISM": func(c *KafkaConfig) { c.SASLMechanism = "GSSAPI" },
+			"KAFKA_TLS_CA":         func(c *KafkaConfig) { c.TLSCAPath = filepath.Join(t.TempDir(), "missing.pem") },
+		} {
+			cfg := base
+			mutate(&cfg)
+			if _, err := franzOptions(cfg); err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("franzOptions(%+v) error = %v, want %s error", cfg, err, want)
+			}
+		}
+	})
+}
+
+func TestKafkaTLSConfig(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	cert := srv.Certificate()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := kafkaTLSConfig(KafkaConfig{TLSCAPath: caPath, TLSSkipVerify: true})
+	if err != nil {
+		t.Fatalf("kafkaTLSConfig() error = %v", err)
+	}
+	if !tlsConfig.InsecureSkipVerify || tlsConfig.VerifyPeerCertificate == nil {
+		t.Fatal("skip verify should replace host name checks with a chain check")
+	}
+	if err := tlsConfig.VerifyPeerCertificate([][]byte{cert.Raw}, nil); err != nil {
+		t.Errorf("chain signed by KAFKA_TLS_CA rejected: %v", err)
+	}
+	if err := verifyChain([][]byte{cert.Raw}, x509.NewCertPool()); err == nil {
+		t.Error("chain from an unknown CA should be rejected")
+	}
+
+	if _, err := kafkaTLSConfig(KafkaConfig{TLSCAPath: os.DevNull}); err == nil {
+		t.Error("a CA file without certificates should be rejected")
+	}
+}
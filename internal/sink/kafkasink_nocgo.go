@@ -0,0 +1,14 @@
+//go:build !cgo
+
+package sink
+
+import "errors"
+
+// Static builds can't link librdkafka, so they produce with franz-go
+const defaultKafkaClient = KafkaClientFranz
+
+var errKafkaNoCgo = errors.New("KAFKA_CLIENT=confluent needs a cgo build; use KAFKA_CLIENT=franz")
+
+func newConfluentProducer(*KafkaSink) (kafkaProducer, error) {
+	return nil, errKafkaNoCgo
+}
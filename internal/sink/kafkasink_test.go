@@ -2,11 +2,14 @@ package sink
 
 import (
 	"context"
+	"errors"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
-	"github.com/shortontech/gotrack/internal/event"
+	"github.com/shortontech/gotrack/pkg/event"
+	"go.opentelemetry.io/otel"
 )
 
 func withEnvVars(t *testing.T, vars map[string]string, fn func()) {
@@ -57,6 +60,9 @@ func assertKafkaConfig(t *testing.T, cfg KafkaConfig, expected map[string]interf
 	if val, ok := expected["acks"].(string); ok {
 		assertStringField(t, cfg.Acks, val, "Acks")
 	}
+	if val, ok := expected["key"].(string); ok {
+		assertStringField(t, cfg.KeyStrategy, val, "KeyStrategy")
+	}
 	if val, ok := expected["compression"].(string); ok {
 		assertStringField(t, cfg.Compression, val, "Compression")
 	}
@@ -90,6 +96,7 @@ func TestNewKafkaSinkFromEnv(t *testing.T) {
 				"brokers": []string{"localhost:9092"},
 				"topic":   "gotrack.events",
 				"acks":    "all",
+				"key":     "event_id",
 			})
 		})
 	})
@@ -97,7 +104,7 @@ func TestNewKafkaSinkFromEnv(t *testing.T) {
 	t.Run("uses env variables when set", func(t *testing.T) {
 		envVars := map[string]string{
 			"KAFKA_BROKERS": "broker1:9092,broker2:9092,broker3:9092", "KAFKA_TOPIC": "custom.topic",
-			"KAFKA_ACKS": "1", "KAFKA_COMPRESSION": "gzip", "KAFKA_SASL_MECHANISM": "PLAIN",
+			"KAFKA_ACKS": "1", "KAFKA_COMPRESSION": "gzip", "KAFKA_KEY": "visitor_id", "KAFKA_SASL_MECHANISM": "PLAIN",
 			"KAFKA_SASL_USER": "test-user", "KAFKA_SASL_PASSWORD": "test-pass",
 			"KAFKA_TLS_CA": "/path/to/ca.pem", "KAFKA_TLS_SKIP_VERIFY": "true",
 		}
@@ -108,6 +115,7 @@ func TestNewKafkaSinkFromEnv(t *testing.T) {
 				"topic":           "custom.topic",
 				"acks":            "1",
 				"compression":     "gzip",
+				"key":             "visitor_id",
 				"sasl_mechanism":  "PLAIN",
 				"sasl_user":       "test-user",
 				"sasl_password":   "test-pass",
@@ -175,7 +183,7 @@ func TestKafkaSink_Start_ConfigurationPaths(t *testing.T) {
 	t.Run("basic configuration", func(t *testing.T) {
 		sink := NewKafkaSink([]string{"localhost:9092"}, "test-topic")
 		ctx := context.Background()
-		
+
 		// This will fail without Kafka, but it exercises the config map creation
 		err := sink.Start(ctx)
 		// We expect an error since Kafka isn't running
@@ -184,7 +192,7 @@ func TestKafkaSink_Start_ConfigurationPaths(t *testing.T) {
 				t.Logf("Got expected error: %v", err)
 			}
 		}
-		
+
 		// Cleanup if it somehow succeeded
 		if sink.producer != nil {
 			sink.Close()
@@ -201,12 +209,12 @@ func TestKafkaSink_Start_ConfigurationPaths(t *testing.T) {
 			},
 		}
 		ctx := context.Background()
-		
+
 		err := sink.Start(ctx)
 		if err != nil {
 			t.Logf("Got expected error (no Kafka): %v", err)
 		}
-		
+
 		if sink.producer != nil {
 			sink.Close()
 		}
@@ -223,12 +231,12 @@ func TestKafkaSink_Start_ConfigurationPaths(t *testing.T) {
 			},
 		}
 		ctx := context.Background()
-		
+
 		err := sink.Start(ctx)
 		if err != nil {
 			t.Logf("Got expected error (no Kafka): %v", err)
 		}
-		
+
 		if sink.producer != nil {
 			sink.Close()
 		}
@@ -243,12 +251,12 @@ func TestKafkaSink_Start_ConfigurationPaths(t *testing.T) {
 			},
 		}
 		ctx := context.Background()
-		
+
 		err := sink.Start(ctx)
 		if err != nil {
 			t.Logf("Got expected error (no Kafka): %v", err)
 		}
-		
+
 		if sink.producer != nil {
 			sink.Close()
 		}
@@ -263,12 +271,12 @@ func TestKafkaSink_Start_ConfigurationPaths(t *testing.T) {
 			},
 		}
 		ctx := context.Background()
-		
+
 		err := sink.Start(ctx)
 		if err != nil {
 			t.Logf("Got expected error (no Kafka): %v", err)
 		}
-		
+
 		if sink.producer != nil {
 			sink.Close()
 		}
@@ -286,12 +294,12 @@ func TestKafkaSink_Start_ConfigurationPaths(t *testing.T) {
 			},
 		}
 		ctx := context.Background()
-		
+
 		err := sink.Start(ctx)
 		if err != nil {
 			t.Logf("Got expected error (no Kafka): %v", err)
 		}
-		
+
 		if sink.producer != nil {
 			sink.Close()
 		}
@@ -301,12 +309,12 @@ func TestKafkaSink_Start_ConfigurationPaths(t *testing.T) {
 // Test Kafka Enqueue without producer
 func TestKafkaSink_Enqueue_NoProducer(t *testing.T) {
 	sink := NewKafkaSink([]string{"localhost:9092"}, "test")
-	
+
 	evt := event.Event{
 		EventID: "test-123",
 		Type:    "click",
 	}
-	
+
 	err := sink.Enqueue(evt)
 	if err == nil {
 		t.Error("Enqueue should fail when producer is not initialized")
@@ -316,6 +324,349 @@ func TestKafkaSink_Enqueue_NoProducer(t *testing.T) {
 	}
 }
 
+// TestNewKafkaSinkFromEnv_DeliveryOptions tests delivery guarantee settings
+func TestNewKafkaSinkFromEnv_DeliveryOptions(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		envVars := map[string]string{
+			"KAFKA_IDEMPOTENT": "", "KAFKA_TRANSACTIONAL_ID": "", "KAFKA_TXN_COMMIT_MS": "",
+			"KAFKA_MAX_INFLIGHT": "", "KAFKA_DELIVERY_RETRIES": "",
+		}
+		withEnvVars(t, envVars, func() {
+			cfg := NewKafkaSinkFromEnv().config
+			if cfg.Idempotent || cfg.TransactionalID != "" {
+				t.Errorf("idempotent/transactional should default off: %+v", cfg)
+			}
+			if cfg.TxnCommitInterval != time.Second || cfg.MaxInFlight != 10000 || cfg.DeliveryRetries != 3 {
+				t.Errorf("unexpected defaults: %+v", cfg)
+			}
+		})
+	})
+
+	t.Run("from env", func(t *testing.T) {
+		envVars := map[string]string{
+			"KAFKA_IDEMPOTENT": "true", "KAFKA_TRANSACTIONAL_ID": "gotrack-1", "KAFKA_TXN_COMMIT_MS": "250",
+			"KAFKA_MAX_INFLIGHT": "50", "KAFKA_DELIVERY_RETRIES": "5",
+		}
+		withEnvVars(t, envVars, func() {
+			cfg := NewKafkaSinkFromEnv().config
+			if !cfg.Idempotent || cfg.TransactionalID != "gotrack-1" {
+				t.Errorf("idempotent/transactional not read: %+v", cfg)
+			}
+			if cfg.TxnCommitInterval != 250*time.Millisecond || cfg.MaxInFlight != 50 || cfg.DeliveryRetries != 5 {
+				t.Errorf("unexpected values: %+v", cfg)
+			}
+		})
+	})
+}
+
+// TestKafkaSink_Start_RequiresAcksAll tests that exactly-once modes reject weaker acks
+func TestKafkaSink_Start_RequiresAcksAll(t *testing.T) {
+	for _, cfg := range []KafkaConfig{
+		{Brokers: []string{"localhost:9092"}, Topic: "test", Acks: "1", Idempotent: true},
+		{Brokers: []string{"localhost:9092"}, Topic: "test", Acks: "0", TransactionalID: "tx"},
+	} {
+		sink := &KafkaSink{config: cfg}
+		err := sink.Start(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "KAFKA_ACKS=all") {
+			t.Errorf("Start(%+v) error = %v, want acks error", cfg, err)
+		}
+		if sink.producer != nil {
+			t.Error("producer should not be created")
+		}
+	}
+}
+
+// kafkaTestClients lists the clients this build can run; builds without cgo
+// only have franz
+func kafkaTestClients() []string {
+	if defaultKafkaClient == KafkaClientFranz {
+		return []string{KafkaClientFranz}
+	}
+	return []string{KafkaClientConfluent, KafkaClientFranz}
+}
+
+// startTestKafkaSink starts a producer against an unreachable broker; messages
+// stay queued locally, which is enough to exercise in-flight accounting
+func startTestKafkaSink(t *testing.T, client string, maxInFlight, retries int) *KafkaSink {
+	t.Helper()
+	sink := &KafkaSink{config: KafkaConfig{
+		Brokers:         []string{"127.0.0.1:1"},
+		Topic:           "test",
+		Acks:            "all",
+		Client:          client,
+		MaxInFlight:     maxInFlight,
+		DeliveryRetries: retries,
+	}}
+	if err := sink.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(sink.producer.close)
+	return sink
+}
+
+// TestKafkaSink_InFlightLimit tests that Enqueue rejects events beyond MaxInFlight
+func TestKafkaSink_InFlightLimit(t *testing.T) {
+	for _, client := range kafkaTestClients() {
+		t.Run(client, func(t *testing.T) {
+			sink := startTestKafkaSink(t, client, 2, 0)
+
+			for i := 0; i < 2; i++ {
+				if err := sink.Enqueue(event.Event{EventID: "evt", Type: "pageview"}); err != nil {
+					t.Fatalf("Enqueue %d error = %v", i, err)
+				}
+			}
+			err := sink.Enqueue(event.Event{EventID: "evt", Type: "pageview"})
+			if !errors.Is(err, errKafkaInFlightFull) {
+				t.Errorf("Enqueue over limit error = %v, want errKafkaInFlightFull", err)
+			}
+			if depth, _ := sink.Load(); depth != 2 {
+				t.Errorf("Load() depth = %d, want 2", depth)
+			}
+		})
+	}
+}
+
+// TestKafkaSink_HandleDelivery tests settling of delivery reports
+func TestKafkaSink_HandleDelivery(t *testing.T) {
+	errTimedOut := errors.New("timed out")
+	failed := func(attempt int) *kafkaRecord {
+		return &kafkaRecord{Key: []byte("evt"), attempt: attempt}
+	}
+
+	for _, client := range kafkaTestClients() {
+		t.Run(client+"/success releases slot", func(t *testing.T) {
+			sink := startTestKafkaSink(t, client, 10, 1)
+			sink.acquire()
+			sink.handleDelivery(&kafkaRecord{Key: []byte("evt")}, nil, false)
+			if sink.InFlight() != 0 {
+				t.Errorf("InFlight() = %d, want 0", sink.InFlight())
+			}
+		})
+
+		t.Run(client+"/failure is retried while attempts remain", func(t *testing.T) {
+			sink := startTestKafkaSink(t, client, 10, 1)
+			sink.acquire()
+			sink.handleDelivery(failed(0), errTimedOut, false)
+			if sink.InFlight() != 1 {
+				t.Errorf("InFlight() = %d, want 1 while retrying", sink.InFlight())
+			}
+		})
+
+		t.Run(client+"/failure is dropped once retries are exhausted", func(t *testing.T) {
+			sink := startTestKafkaSink(t, client, 10, 1)
+			sink.acquire()
+			sink.handleDelivery(failed(1), errTimedOut, false)
+			if sink.InFlight() != 0 {
+				t.Errorf("InFlight() = %d, want 0 after drop", sink.InFlight())
+			}
+		})
+
+		t.Run(client+"/fatal failure is never retried", func(t *testing.T) {
+			sink := startTestKafkaSink(t, client, 10, 1)
+			sink.acquire()
+			sink.handleDelivery(failed(0), errTimedOut, true)
+			if sink.InFlight() != 0 {
+				t.Errorf("InFlight() = %d, want 0 after fatal error", sink.InFlight())
+			}
+		})
+	}
+}
+
+// TestKafkaSink_RetryRecord tests attempt tracking on retried records
+func TestKafkaSink_RetryRecord(t *testing.T) {
+	sink := NewKafkaSink([]string{"localhost:9092"}, "test")
+	sink.config.DeliveryRetries = 2
+	rec := &kafkaRecord{Key: []byte("k"), Value: []byte("v")}
+
+	retry := sink.retryRecord(rec)
+	if retry == nil || retry.attempt != 1 || string(retry.Key) != "k" || string(retry.Value) != "v" {
+		t.Fatalf("first retry = %+v", retry)
+	}
+	if again := sink.retryRecord(retry); again == nil || again.attempt != 2 {
+		t.Fatalf("second retry = %+v", again)
+	}
+	rec.attempt = 2
+	if sink.retryRecord(rec) != nil {
+		t.Error("retryRecord should give up after DeliveryRetries attempts")
+	}
+}
+
+func TestKafkaSink_Ping(t *testing.T) {
+	if err := NewKafkaSink([]string{"localhost:9092"}, "events").Ping(context.Background()); err == nil {
+		t.Error("Ping() without a producer should fail")
+	}
+
+	for _, client := range kafkaTestClients() {
+		t.Run(client, func(t *testing.T) {
+			// Nothing listens on this port, so the broker request fails
+			sink := NewKafkaSink([]string{"127.0.0.1:1"}, "events")
+			sink.config.Client = client
+			if err := sink.Start(context.Background()); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			defer sink.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			if err := sink.Ping(ctx); err == nil {
+				t.Error("Ping() should fail when no broker is reachable")
+			}
+		})
+	}
+}
+
+func TestKafkaSink_Flush(t *testing.T) {
+	if _, err := NewKafkaSink([]string{"localhost:9092"}, "events").Flush(context.Background()); err == nil {
+		t.Error("Flush() without a producer should fail")
+	}
+
+	for _, client := range kafkaTestClients() {
+		t.Run(client, func(t *testing.T) {
+			sink := NewKafkaSink([]string{"127.0.0.1:1"}, "events")
+			sink.config.Client = client
+			if err := sink.Start(context.Background()); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			defer sink.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			if n, err := sink.Flush(ctx); err != nil || n != 0 {
+				t.Errorf("Flush() with nothing produced = %d, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestTraceHeaders(t *testing.T) {
+	recordSpans(t)
+
+	if headers := traceHeaders(context.Background()); len(headers) != 0 {
+		t.Errorf("headers without a span = %v", headers)
+	}
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "kafka.produce")
+	defer span.End()
+	headers := traceHeaders(ctx)
+	if len(headers) != 1 || headers[0].Key != "traceparent" {
+		t.Fatalf("headers = %v", headers)
+	}
+	want := "00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"
+	if string(headers[0].Value) != want {
+		t.Errorf("traceparent = %s, want %s", headers[0].Value, want)
+	}
+}
+
+// TestKafkaKey tests message key selection per strategy
+func TestKafkaKey(t *testing.T) {
+	ev := event.Event{
+		EventID: "evt-1",
+		Session: event.SessionInfo{VisitorID: "vis-1", SessionID: "ses-1"},
+		Server:  event.ServerMeta{IP: "203.0.113.7"},
+	}
+
+	tests := []struct {
+		strategy string
+		want     string
+	}{
+		{"", "evt-1"},
+		{KafkaKeyEventID, "evt-1"},
+		{KafkaKeyVisitorID, "vis-1"},
+		{KafkaKeySessionID, "ses-1"},
+	}
+	for _, tt := range tests {
+		if got := string(kafkaKey(tt.strategy, ev)); got != tt.want {
+			t.Errorf("kafkaKey(%q) = %q, want %q", tt.strategy, got, tt.want)
+		}
+	}
+
+	ipKey := string(kafkaKey(KafkaKeyIPHash, ev))
+	if len(ipKey) != 64 || strings.Contains(ipKey, "203.0.113.7") {
+		t.Errorf("ip_hash key = %q, want a hex SHA-256 digest", ipKey)
+	}
+	if again := string(kafkaKey(KafkaKeyIPHash, ev)); again != ipKey {
+		t.Error("ip_hash key should be stable for the same IP")
+	}
+
+	t.Run("falls back to event_id when field is empty", func(t *testing.T) {
+		bare := event.Event{EventID: "evt-2"}
+		for _, strategy := range []string{KafkaKeyVisitorID, KafkaKeySessionID, KafkaKeyIPHash} {
+			if got := string(kafkaKey(strategy, bare)); got != "evt-2" {
+				t.Errorf("kafkaKey(%q) = %q, want evt-2", strategy, got)
+			}
+		}
+	})
+}
+
+// TestKafkaSink_Start_InvalidKey tests that an unknown KAFKA_KEY is rejected
+func TestKafkaSink_Start_InvalidKey(t *testing.T) {
+	withEnvVars(t, map[string]string{"KAFKA_KEY": "user_id"}, func() {
+		sink := NewKafkaSinkFromEnv()
+		err := sink.Start(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "KAFKA_KEY") {
+			t.Errorf("Start() error = %v, want KAFKA_KEY error", err)
+		}
+	})
+}
+
+// TestKafkaSink_Client tests KAFKA_CLIENT selection
+func TestKafkaSink_Client(t *testing.T) {
+	withEnvVars(t, map[string]string{"KAFKA_CLIENT": ""}, func() {
+		if got := NewKafkaSinkFromEnv().config.Client; got != defaultKafkaClient {
+			t.Errorf("Client = %q, want %q", got, defaultKafkaClient)
+		}
+	})
+	withEnvVars(t, map[string]string{"KAFKA_CLIENT": "franz"}, func() {
+		if got := NewKafkaSinkFromEnv().config.Client; got != KafkaClientFranz {
+			t.Errorf("Client = %q, want franz", got)
+		}
+	})
+
+	sink := &KafkaSink{config: KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "test", Acks: "all", Client: "sarama"}}
+	err := sink.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "KAFKA_CLIENT") {
+		t.Errorf("Start() error = %v, want KAFKA_CLIENT error", err)
+	}
+}
+
+// TestKafkaSink_Serialization tests serialization settings
+func TestKafkaSink_Serialization(t *testing.T) {
+	t.Run("reads env", func(t *testing.T) {
+		envVars := map[string]string{
+			"KAFKA_SERIALIZATION": "avro", "KAFKA_SCHEMA_REGISTRY_URL": "http://registry:8081",
+			"KAFKA_SCHEMA_REGISTRY_USER": "u", "KAFKA_SCHEMA_REGISTRY_PASSWORD": "p",
+		}
+		withEnvVars(t, envVars, func() {
+			cfg := NewKafkaSinkFromEnv().config
+			if cfg.Serialization != "avro" || cfg.SchemaRegistryURL != "http://registry:8081" ||
+				cfg.SchemaRegistryUser != "u" || cfg.SchemaRegistryPassword != "p" {
+				t.Errorf("unexpected serialization config: %+v", cfg)
+			}
+		})
+	})
+
+	t.Run("defaults to json", func(t *testing.T) {
+		withEnvVars(t, map[string]string{"KAFKA_SERIALIZATION": ""}, func() {
+			if got := NewKafkaSinkFromEnv().config.Serialization; got != "json" {
+				t.Errorf("Serialization = %q, want json", got)
+			}
+		})
+	})
+
+	t.Run("rejects unknown format and missing registry", func(t *testing.T) {
+		for format, want := range map[string]string{"xml": "unknown serialization", "protobuf": "KAFKA_SCHEMA_REGISTRY_URL"} {
+			sink := &KafkaSink{config: KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "test", Acks: "all", Serialization: format}}
+			err := sink.Start(context.Background())
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("Start(%s) error = %v, want %q", format, err, want)
+			}
+			if sink.producer != nil {
+				t.Error("producer should not be created")
+			}
+		}
+	})
+}
+
 // TestGetEnvOr tests the string environment variable helper
 func TestGetEnvOr(t *testing.T) {
 	tests := []struct {
@@ -516,7 +867,7 @@ func TestKafkaConfigMap(t *testing.T) {
 				t.Errorf("Single broker: got %d brokers, want 1", len(sink.config.Brokers))
 			}
 		})
-		
+
 		withEnvVars(t, map[string]string{"KAFKA_BROKERS": "broker1:9092,broker2:9092"}, func() {
 			sink := NewKafkaSinkFromEnv()
 			if len(sink.config.Brokers) != 2 {
@@ -0,0 +1,175 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/shortontech/gotrack/internal/serde"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// kinesisMaxPartitionKey is the longest partition key Kinesis accepts
+const kinesisMaxPartitionKey = 256
+
+// kinesisAPI is the part of the Kinesis client the sink uses; tests replace it
+type kinesisAPI interface {
+	PutRecords(ctx context.Context, in *kinesis.PutRecordsInput, optFns ...func(*kinesis.Options)) (*kinesis.PutRecordsOutput, error)
+}
+
+// KinesisConfig holds configuration for the Kinesis producer
+type KinesisConfig struct {
+	Stream       string // stream name, or its ARN
+	PartitionKey string // event_id, visitor_id, session_id or ip_hash, as for KAFKA_KEY
+	Region       string // empty uses AWS_REGION or the shared config
+	Endpoint     string // overrides the AWS endpoint, e.g. for LocalStack
+	RecordRetry  int    // PutRecords calls per batch for records Kinesis throttled
+}
+
+// Kinesis writes events as JSON records to an AWS Kinesis data stream with
+// PutRecords. Credentials come from the standard AWS chain: environment
+// variables, the shared config and credentials files, web identity (IRSA) or
+// the instance and task role.
+type Kinesis struct {
+	config KinesisConfig
+	client kinesisAPI
+}
+
+// NewKinesisSinkFromEnv creates a Kinesis forwarder from environment variables
+func NewKinesisSinkFromEnv() (*Forwarder, error) {
+	k := NewKinesis(KinesisConfig{
+		Stream:       os.Getenv("KINESIS_STREAM"),
+		PartitionKey: getEnvOr("KINESIS_PARTITION_KEY", KafkaKeyVisitorID),
+		Region:       os.Getenv("KINESIS_REGION"),
+		Endpoint:     os.Getenv("KINESIS_ENDPOINT"),
+		RecordRetry:  getIntEnv("KINESIS_RECORD_RETRY", 3),
+	})
+	if err := k.connect(context.Background()); err != nil {
+		return nil, err
+	}
+	return NewForwarder(k, ForwardConfig{
+		BatchSize:   getIntEnv("KINESIS_BATCH_SIZE", 500),
+		FlushMS:     getIntEnv("KINESIS_FLUSH_MS", 1000),
+		MaxAttempts: getIntEnv("KINESIS_MAX_ATTEMPTS", 5),
+		MaxPending:  getIntEnv("KINESIS_MAX_PENDING", 10000),
+	}), nil
+}
+
+// NewKinesis creates the Kinesis destination with explicit configuration. The
+// AWS client is created by NewKinesisSinkFromEnv; tests supply their own.
+func NewKinesis(config KinesisConfig) *Kinesis {
+	if config.RecordRetry <= 0 {
+		config.RecordRetry = 1
+	}
+	return &Kinesis{config: config}
+}
+
+// connect loads the AWS configuration and creates the client
+func (k *Kinesis) connect(ctx context.Context) error {
+	var opts []func(*awsconfig.LoadOptions) error
+	if k.config.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(k.config.Region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	k.client = kinesis.NewFromConfig(cfg, func(o *kinesis.Options) {
+		if k.config.Endpoint != "" {
+			o.BaseEndpoint = aws.String(k.config.Endpoint)
+		}
+	})
+	return nil
+}
+
+func (k *Kinesis) Name() string  { return "kinesis" }
+func (k *Kinesis) MaxBatch() int { return 500 }
+
+func (k *Kinesis) Check() error {
+	if k.config.Stream == "" {
+		return fmt.Errorf("KINESIS_STREAM is required for the kinesis sink")
+	}
+	switch k.config.PartitionKey {
+	case "", KafkaKeyEventID, KafkaKeyVisitorID, KafkaKeySessionID, KafkaKeyIPHash:
+	default:
+		return fmt.Errorf("unknown KINESIS_PARTITION_KEY %q (want event_id, visitor_id, session_id or ip_hash)", k.config.PartitionKey)
+	}
+	if k.client == nil {
+		return fmt.Errorf("kinesis client not configured")
+	}
+	return nil
+}
+
+// Qualifies accepts every event; the stream is a full copy of the event log
+func (k *Kinesis) Qualifies(e event.Event) bool { return true }
+
+// Send writes one batch. Records Kinesis rejected, usually for exceeding a
+// shard's throughput, are resent on their own up to RecordRetry times; if
+// some still fail the whole batch is retried by the Forwarder, so consumers
+// must tolerate duplicates by event_id.
+func (k *Kinesis) Send(ctx context.Context, events []event.Event) error {
+	entries := make([]types.PutRecordsRequestEntry, len(events))
+	for i, e := range events {
+		data, err := serde.MarshalEvent(&e)
+		if err != nil {
+			return PermanentError{err}
+		}
+		entries[i] = types.PutRecordsRequestEntry{Data: data, PartitionKey: aws.String(k.partitionKey(e))}
+	}
+
+	for attempt := 0; attempt < k.config.RecordRetry; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(100*(1<<uint(attempt-1))) * time.Millisecond):
+			}
+		}
+		in := &kinesis.PutRecordsInput{Records: entries}
+		if strings.HasPrefix(k.config.Stream, "arn:") {
+			in.StreamARN = aws.String(k.config.Stream)
+		} else {
+			in.StreamName = aws.String(k.config.Stream)
+		}
+		out, err := k.client.PutRecords(ctx, in)
+		if err != nil {
+			return fmt.Errorf("kinesis PutRecords failed: %w", err)
+		}
+		if aws.ToInt32(out.FailedRecordCount) == 0 {
+			return nil
+		}
+		entries = failedEntries(entries, out.Records)
+	}
+	return fmt.Errorf("kinesis rejected %d of %d records", len(entries), len(events))
+}
+
+// failedEntries returns the entries whose result carries an error code; the
+// results are in request order
+func failedEntries(entries []types.PutRecordsRequestEntry, results []types.PutRecordsResultEntry) []types.PutRecordsRequestEntry {
+	var failed []types.PutRecordsRequestEntry
+	for i, r := range results {
+		if i < len(entries) && r.ErrorCode != nil {
+			failed = append(failed, entries[i])
+		}
+	}
+	return failed
+}
+
+// partitionKey picks the record's shard key as KAFKA_KEY does for Kafka,
+// keeping it within the Kinesis length limit
+func (k *Kinesis) partitionKey(e event.Event) string {
+	key := string(kafkaKey(k.config.PartitionKey, e))
+	if key == "" {
+		key = "-"
+	}
+	if len(key) > kinesisMaxPartitionKey {
+		key = sha256Hex(key)
+	}
+	return key
+}
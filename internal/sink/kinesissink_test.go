@@ -0,0 +1,100 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// fakeKinesis records PutRecords calls and throttles the first record of
+// the first throttle calls
+type fakeKinesis struct {
+	calls    []*kinesis.PutRecordsInput
+	throttle int
+}
+
+func (f *fakeKinesis) PutRecords(ctx context.Context, in *kinesis.PutRecordsInput, _ ...func(*kinesis.Options)) (*kinesis.PutRecordsOutput, error) {
+	f.calls = append(f.calls, in)
+	out := &kinesis.PutRecordsOutput{Records: make([]types.PutRecordsResultEntry, len(in.Records)), FailedRecordCount: aws.Int32(0)}
+	if len(f.calls) <= f.throttle {
+		out.Records[0].ErrorCode = aws.String("ProvisionedThroughputExceededException")
+		out.FailedRecordCount = aws.Int32(1)
+	}
+	return out, nil
+}
+
+func TestKinesisSend(t *testing.T) {
+	fake := &fakeKinesis{throttle: 1}
+	k := NewKinesis(KinesisConfig{Stream: "events", PartitionKey: KafkaKeyVisitorID, RecordRetry: 3})
+	k.client = fake
+	if err := k.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	a := event.Event{EventID: "ev-1", Type: "pageview"}
+	a.Session.VisitorID = "v-1"
+	b := event.Event{EventID: "ev-2", Type: "click"}
+	if err := k.Send(context.Background(), []event.Event{a, b}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.calls) != 2 {
+		t.Fatalf("PutRecords called %d times, want a retry of the throttled record", len(fake.calls))
+	}
+	first := fake.calls[0]
+	if aws.ToString(first.StreamName) != "events" || first.StreamARN != nil || len(first.Records) != 2 {
+		t.Fatalf("first call = %+v", first)
+	}
+	if got := aws.ToString(first.Records[0].PartitionKey); got != "v-1" {
+		t.Errorf("partition key = %q, want the visitor", got)
+	}
+	if got := aws.ToString(first.Records[1].PartitionKey); got != "ev-2" {
+		t.Errorf("partition key = %q, want the event_id fallback", got)
+	}
+	var got event.Event
+	if err := json.Unmarshal(first.Records[0].Data, &got); err != nil || got.EventID != "ev-1" {
+		t.Errorf("data = %s (%v)", first.Records[0].Data, err)
+	}
+	if retry := fake.calls[1]; len(retry.Records) != 1 || aws.ToString(retry.Records[0].PartitionKey) != "v-1" {
+		t.Errorf("retry = %+v, want only the throttled record", retry.Records)
+	}
+}
+
+func TestKinesisSendGivesUp(t *testing.T) {
+	fake := &fakeKinesis{throttle: 5}
+	k := NewKinesis(KinesisConfig{Stream: "arn:aws:kinesis:us-east-1:123456789012:stream/events", RecordRetry: 2})
+	k.client = fake
+	err := k.Send(context.Background(), []event.Event{{EventID: "ev-1"}})
+	if err == nil || !strings.Contains(err.Error(), "1 of 1") {
+		t.Fatalf("Send = %v, want a retryable rejection", err)
+	}
+	if len(fake.calls) != 2 || aws.ToString(fake.calls[0].StreamARN) == "" {
+		t.Errorf("calls = %d, stream ARN = %q", len(fake.calls), aws.ToString(fake.calls[0].StreamARN))
+	}
+}
+
+func TestKinesisCheck(t *testing.T) {
+	for _, config := range []KinesisConfig{
+		{PartitionKey: KafkaKeyEventID},
+		{Stream: "events", PartitionKey: "user_id"},
+	} {
+		k := NewKinesis(config)
+		k.client = &fakeKinesis{}
+		if err := k.Check(); err == nil {
+			t.Errorf("Check(%+v) should fail", config)
+		}
+	}
+
+	k := NewKinesis(KinesisConfig{PartitionKey: KafkaKeyVisitorID})
+	long := event.Event{EventID: "ev-1"}
+	long.Session.VisitorID = strings.Repeat("v", 300)
+	if key := k.partitionKey(long); len(key) > kinesisMaxPartitionKey {
+		t.Errorf("partition key is %d bytes", len(key))
+	}
+}
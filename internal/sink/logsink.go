@@ -1,66 +1,413 @@
 package sink
 
 import (
+	"bufio"
+	"compress/gzip"
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/shortontech/gotrack/internal/event"
+	"github.com/shortontech/gotrack/internal/serde"
+	"github.com/shortontech/gotrack/pkg/event"
 )
 
+// backupTimeFormat stamps rotated files. It sorts chronologically and has no
+// characters that need quoting in a shell.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// LogConfig holds configuration for the NDJSON log sink
+type LogConfig struct {
+	Name          string        // sink name; "log" when empty
+	Path          string        // file to append to, or "stdout"
+	BufferBytes   int           // write buffer size; 0 writes every line straight to the file
+	FlushMS       int           // longest a line waits in the buffer
+	MaxBytes      int64         // rotate once the file would grow past this; 0 disables
+	MaxAge        time.Duration // rotate once the file has been written to this long; 0 disables
+	Compress      bool          // gzip rotated files
+	MaxBackups    int           // rotated files to keep; 0 keeps all
+	RetentionDays int           // delete rotated files older than this; 0 keeps all
+}
+
 type LogSink struct {
-	f   *os.File
-	mu  sync.Mutex
-	dst string
+	config LogConfig
+	now    func() time.Time
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	size    int64     // bytes in the current file, including buffered ones
+	opened  time.Time // when the current file started receiving writes
+	pending int       // lines written since the last flush
+
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	cleanMu sync.Mutex // serializes compression and retention cleanup
 }
 
+// NewLogSink creates a LogSink from environment variables
 func NewLogSink() *LogSink {
 	path := os.Getenv("LOG_PATH")
 	if path == "" {
 		path = "ndjson.log"
 	} // default picked up from Docker env
 
-	return &LogSink{dst: path}
+	maxAge, err := time.ParseDuration(getEnvOr("LOG_MAX_AGE", "0"))
+	if err != nil {
+		log.Printf("ignoring invalid LOG_MAX_AGE: %v", err)
+		maxAge = 0
+	}
+	return NewLogSinkWithConfig(LogConfig{
+		Path:          path,
+		BufferBytes:   getIntEnv("LOG_BUFFER_KB", 64) << 10,
+		FlushMS:       getIntEnv("LOG_FLUSH_MS", 1000),
+		MaxBytes:      int64(getIntEnv("LOG_MAX_MB", 0)) << 20,
+		MaxAge:        maxAge,
+		Compress:      getBoolEnv("LOG_COMPRESS", false),
+		MaxBackups:    getIntEnv("LOG_BACKUPS", 0),
+		RetentionDays: getIntEnv("LOG_RETENTION_DAYS", 0),
+	})
+}
+
+// NewLogSinkWithConfig creates a LogSink with explicit configuration
+func NewLogSinkWithConfig(config LogConfig) *LogSink {
+	if config.FlushMS <= 0 {
+		config.FlushMS = 1000
+	}
+	return &LogSink{config: config, now: time.Now}
 }
 
 func (s *LogSink) Start(ctx context.Context) error {
-	if s.dst == "stdout" {
+	if s.config.Path == "stdout" {
 		return nil
 	} // stdout only
-	f, err := os.OpenFile(s.dst, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err := s.open(); err != nil {
+		return err
+	}
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go s.flushLoop(s.stop)
+
+	// Apply retention to files left by earlier runs
+	if s.rotates() {
+		s.wg.Add(1)
+		go s.afterRotate("")
+	}
+	return nil
+}
+
+// open opens the log file for appending, picking up its current size
+func (s *LogSink) open() error {
+	f, err := os.OpenFile(s.config.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
 	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
 	s.f = f
+	s.size = info.Size()
+	s.opened = s.now()
+	if s.config.BufferBytes > 0 {
+		if s.w == nil {
+			s.w = bufio.NewWriterSize(f, s.config.BufferBytes)
+		} else {
+			s.w.Reset(f)
+		}
+	}
 	return nil
 }
 
 func (s *LogSink) Enqueue(e event.Event) error {
-	b, _ := json.Marshal(e)
+	buf := serde.GetBuffer()
+	defer serde.PutBuffer(buf)
+	b, err := serde.AppendJSON(*buf, &e)
+	if err != nil {
+		return err
+	}
 	line := append(b, '\n')
-	if s.f != nil {
-		s.mu.Lock()
-		_, err := s.f.Write(line)
-		// Force flush to ensure data is written immediately
-		if err == nil {
-			err = s.f.Sync()
+	*buf = line
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		log.Printf("event %s", string(b))
+		return nil
+	}
+	if s.due(int64(len(line))) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if s.w == nil {
+		_, err = s.f.Write(line)
+	} else {
+		_, err = s.w.Write(line)
+	}
+	if err == nil {
+		s.size += int64(len(line))
+		s.pending++
+	}
+	return err
+}
+
+// Flush writes buffered lines to the file and syncs it. It also rotates a
+// file that has reached LOG_MAX_AGE while no events arrived.
+func (s *LogSink) Flush(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return 0, nil
+	}
+	n := s.pending
+	if err := s.flushLocked(); err != nil {
+		return 0, err
+	}
+	if s.size > 0 && s.due(0) {
+		return n, s.rotate()
+	}
+	return n, nil
+}
+
+func (s *LogSink) flushLocked() error {
+	if s.pending == 0 {
+		return nil
+	}
+	if s.w != nil {
+		if err := s.w.Flush(); err != nil {
+			return err
+		}
+	}
+	s.pending = 0
+	return s.f.Sync()
+}
+
+func (s *LogSink) flushLoop(stop <-chan struct{}) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(time.Duration(s.config.FlushMS) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := s.Flush(context.Background()); err != nil {
+				log.Printf("log sink flush failed: %v", err)
+			}
+		}
+	}
+}
+
+func (s *LogSink) rotates() bool {
+	return s.config.MaxBytes > 0 || s.config.MaxAge > 0
+}
+
+// due reports whether the file must rotate before n more bytes are written.
+// A file is never rotated empty, so a single oversized line still lands.
+func (s *LogSink) due(n int64) bool {
+	if s.size == 0 {
+		return false
+	}
+	if s.config.MaxBytes > 0 && s.size+n > s.config.MaxBytes {
+		return true
+	}
+	return s.config.MaxAge > 0 && s.now().Sub(s.opened) >= s.config.MaxAge
+}
+
+// rotate renames the current file to a timestamped backup and opens a new
+// one. Compression and cleanup of the backup happen in the background.
+func (s *LogSink) rotate() error {
+	if err := s.flushLocked(); err != nil {
+		return err
+	}
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+	backup := s.backupName(s.now())
+	if err := os.Rename(s.config.Path, backup); err != nil {
+		// Keep writing to the same file rather than losing events
+		if openErr := s.open(); openErr != nil {
+			return openErr
 		}
-		s.mu.Unlock()
+		return fmt.Errorf("rotate log file: %w", err)
+	}
+	if err := s.open(); err != nil {
 		return err
 	}
-	log.Printf("event %s", string(b))
+	s.wg.Add(1)
+	go s.afterRotate(backup)
 	return nil
 }
 
+// backupName returns an unused name such as events-2026-01-02T15-04-05.000.ndjson
+// for a file rotated at t
+func (s *LogSink) backupName(t time.Time) string {
+	prefix, ext := s.backupParts()
+	base := prefix + t.UTC().Format(backupTimeFormat)
+	name := base + ext
+	for i := 1; ; i++ {
+		_, errPlain := os.Stat(name)
+		_, errGz := os.Stat(name + ".gz")
+		if os.IsNotExist(errPlain) && os.IsNotExist(errGz) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// backupParts splits the log path around the timestamp of its backups
+func (s *LogSink) backupParts() (prefix, ext string) {
+	ext = filepath.Ext(s.config.Path)
+	return strings.TrimSuffix(s.config.Path, ext) + "-", ext
+}
+
+// afterRotate compresses a new backup, if any, then enforces LOG_BACKUPS and
+// LOG_RETENTION_DAYS
+func (s *LogSink) afterRotate(backup string) {
+	defer s.wg.Done()
+	s.cleanMu.Lock()
+	defer s.cleanMu.Unlock()
+	if backup != "" && s.config.Compress {
+		if err := compressFile(backup); err != nil {
+			log.Printf("log sink: compress %s: %v", backup, err)
+		}
+	}
+	if err := s.removeExpired(); err != nil {
+		log.Printf("log sink: retention cleanup: %v", err)
+	}
+}
+
+// logBackup is a rotated log file and the time it was rotated
+type logBackup struct {
+	name string
+	at   time.Time
+}
+
+// backups lists rotated files, newest first
+func (s *LogSink) backups() ([]logBackup, error) {
+	prefix, ext := s.backupParts()
+	entries, err := os.ReadDir(filepath.Dir(prefix))
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(prefix)
+	var found []logBackup
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(strings.TrimSuffix(entry.Name(), ".gz"), base)
+		if !ok || !strings.HasSuffix(stamp, ext) || len(stamp) < len(backupTimeFormat) {
+			continue
+		}
+		at, err := time.Parse(backupTimeFormat, stamp[:len(backupTimeFormat)])
+		if err != nil {
+			continue
+		}
+		found = append(found, logBackup{filepath.Join(filepath.Dir(prefix), entry.Name()), at})
+	}
+	slices.SortFunc(found, func(a, b logBackup) int { return strings.Compare(b.name, a.name) })
+	return found, nil
+}
+
+func (s *LogSink) removeExpired() error {
+	if s.config.MaxBackups <= 0 && s.config.RetentionDays <= 0 {
+		return nil
+	}
+	backups, err := s.backups()
+	if err != nil {
+		return err
+	}
+	cutoff := s.now().AddDate(0, 0, -s.config.RetentionDays)
+	var errs []error
+	for i, b := range backups {
+		tooMany := s.config.MaxBackups > 0 && i >= s.config.MaxBackups
+		tooOld := s.config.RetentionDays > 0 && b.at.Before(cutoff)
+		if tooMany || tooOld {
+			if err := os.Remove(b.name); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// compressFile gzips name to name.gz and removes the original
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Close flushes buffered lines, closes the file and waits for background
+// compression and cleanup to finish
 func (s *LogSink) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.mu.Lock()
+	var err error
 	if s.f != nil {
-		return s.f.Close()
+		err = s.flushLocked()
+		if closeErr := s.f.Close(); err == nil {
+			err = closeErr
+		}
+		s.f = nil
 	}
-	return nil
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
 }
 
 func (s *LogSink) Name() string {
+	if s.config.Name != "" {
+		return s.config.Name
+	}
 	return "log"
 }
+
+// Ping checks that the log file can still be opened for writing, which
+// catches a deleted directory or changed permissions
+func (s *LogSink) Ping(ctx context.Context) error {
+	if s.config.Path == "stdout" {
+		return nil
+	}
+	s.mu.Lock()
+	started := s.f != nil
+	s.mu.Unlock()
+	if !started {
+		return fmt.Errorf("log sink not started")
+	}
+	f, err := os.OpenFile(s.config.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("log file not writable: %w", err)
+	}
+	return f.Close()
+}
@@ -1,14 +1,19 @@
 package sink
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/shortontech/gotrack/internal/event"
+	"github.com/shortontech/gotrack/pkg/event"
 )
 
 // TestNewLogSink tests LogSink creation
@@ -20,8 +25,8 @@ func TestNewLogSink(t *testing.T) {
 		os.Unsetenv("LOG_PATH")
 
 		sink := NewLogSink()
-		if sink.dst != "ndjson.log" {
-			t.Errorf("dst = %q, want ndjson.log", sink.dst)
+		if sink.config.Path != "ndjson.log" {
+			t.Errorf("dst = %q, want ndjson.log", sink.config.Path)
 		}
 	})
 
@@ -31,8 +36,8 @@ func TestNewLogSink(t *testing.T) {
 
 		os.Setenv("LOG_PATH", "/tmp/custom.log")
 		sink := NewLogSink()
-		if sink.dst != "/tmp/custom.log" {
-			t.Errorf("dst = %q, want /tmp/custom.log", sink.dst)
+		if sink.config.Path != "/tmp/custom.log" {
+			t.Errorf("dst = %q, want /tmp/custom.log", sink.config.Path)
 		}
 	})
 }
@@ -178,6 +183,26 @@ func TestLogSinkEnqueue(t *testing.T) {
 		}
 	})
 
+	t.Run("writes the same JSON as encoding/json", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "events.log")
+		sink, cleanup := setupLogSink(t, logPath)
+		defer cleanup()
+		evt := wideTestEvent()
+		evt.Props = map[string]string{"plan": "<pro>", "coupon": "SPRING"}
+		if err := sink.Enqueue(evt); err != nil {
+			t.Fatalf("Enqueue() failed: %v", err)
+		}
+		sink.Close()
+		want, _ := json.Marshal(evt)
+		content, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("failed to read log file: %v", err)
+		}
+		if string(content) != string(want)+"\n" {
+			t.Errorf("log line = %s, want %s", content, want)
+		}
+	})
+
 	t.Run("handles stdout mode without error", func(t *testing.T) {
 		sink, cleanup := setupLogSink(t, "stdout")
 		defer cleanup()
@@ -265,6 +290,37 @@ func TestLogSinkName(t *testing.T) {
 	}
 }
 
+// TestLogSinkPing tests the log file writability check
+func TestLogSinkPing(t *testing.T) {
+	if err := NewLogSinkWithConfig(LogConfig{Path: "stdout"}).Ping(context.Background()); err != nil {
+		t.Errorf("stdout Ping() = %v", err)
+	}
+	if err := NewLogSinkWithConfig(LogConfig{Path: "events.log"}).Ping(context.Background()); err == nil {
+		t.Error("Ping() before Start should fail")
+	}
+
+	logDir := filepath.Join(t.TempDir(), "logs")
+	if err := os.Mkdir(logDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	sink := NewLogSinkWithConfig(LogConfig{Path: filepath.Join(logDir, "events.log")})
+	if err := sink.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer sink.Close()
+	if err := sink.Ping(context.Background()); err != nil {
+		t.Errorf("Ping() = %v", err)
+	}
+
+	// The open descriptor survives, but new writes to the path would not
+	if err := os.RemoveAll(logDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Ping(context.Background()); err == nil {
+		t.Error("Ping() should fail once the log directory is gone")
+	}
+}
+
 // TestLogSinkAppendMode tests that log sink appends to existing files
 func TestLogSinkAppendMode(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -310,6 +366,241 @@ func TestLogSinkAppendMode(t *testing.T) {
 	}
 }
 
+// startLogSink starts a sink on a clock the test advances by hand
+func startLogSink(t *testing.T, config LogConfig) (*LogSink, *time.Time) {
+	t.Helper()
+	clock := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	sink := NewLogSinkWithConfig(config)
+	sink.now = func() time.Time { return clock }
+	if err := sink.Start(context.Background()); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	return sink, &clock
+}
+
+// logBackupNames lists rotated files next to the log, oldest first
+func logBackupNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Name() != "events.ndjson" {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func TestLogSinkBuffering(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "events.ndjson")
+	sink, _ := startLogSink(t, LogConfig{Path: logPath, BufferBytes: 64 << 10, FlushMS: 60000})
+
+	for i := 0; i < 3; i++ {
+		if err := sink.Enqueue(event.Event{EventID: "buffered", Type: "click"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if content, _ := os.ReadFile(logPath); len(content) != 0 {
+		t.Errorf("lines reached the file before a flush: %s", content)
+	}
+
+	n, err := sink.Flush(context.Background())
+	if err != nil || n != 3 {
+		t.Fatalf("Flush() = %d, %v; want 3 lines", n, err)
+	}
+	content, _ := os.ReadFile(logPath)
+	if got := strings.Count(string(content), "buffered"); got != 3 {
+		t.Errorf("file has %d events after Flush, want 3", got)
+	}
+	if n, _ := sink.Flush(context.Background()); n != 0 {
+		t.Errorf("second Flush() = %d, want 0", n)
+	}
+}
+
+func TestLogSinkPeriodicFlush(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "events.ndjson")
+	sink, _ := startLogSink(t, LogConfig{Path: logPath, BufferBytes: 64 << 10, FlushMS: 10})
+	if err := sink.Enqueue(event.Event{EventID: "ticked"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		content, _ := os.ReadFile(logPath)
+		if strings.Contains(string(content), "ticked") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("buffered line was never flushed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLogSinkRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "events.ndjson")
+	sink, clock := startLogSink(t, LogConfig{Path: logPath, BufferBytes: 4096, MaxBytes: 1500})
+
+	for i := 0; i < 10; i++ {
+		*clock = clock.Add(time.Second)
+		if err := sink.Enqueue(event.Event{EventID: fmt.Sprintf("evt-%02d", i), Type: "pageview"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+
+	backups := logBackupNames(t, dir)
+	if len(backups) < 2 {
+		t.Fatalf("backups = %v, want the log rotated more than once", backups)
+	}
+	if !strings.HasPrefix(backups[0], "events-2026-03-01T12-00-") || !strings.HasSuffix(backups[0], ".ndjson") {
+		t.Errorf("backup name = %q", backups[0])
+	}
+
+	// Every event lands exactly once, in order, and no file passes the limit
+	var all strings.Builder
+	for _, name := range append(backups, "events.ndjson") {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(content) > 1500 {
+			t.Errorf("%s is %d bytes, over LOG_MAX_MB", name, len(content))
+		}
+		all.Write(content)
+	}
+	for i := 0; i < 10; i++ {
+		if got := strings.Count(all.String(), fmt.Sprintf(`"evt-%02d"`, i)); got != 1 {
+			t.Errorf("evt-%02d written %d times", i, got)
+		}
+	}
+}
+
+func TestLogSinkRotateByAge(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "events.ndjson")
+	sink, clock := startLogSink(t, LogConfig{Path: logPath, MaxAge: time.Hour})
+
+	if err := sink.Enqueue(event.Event{EventID: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	*clock = clock.Add(30 * time.Minute)
+	if _, err := sink.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if backups := logBackupNames(t, dir); len(backups) != 0 {
+		t.Fatalf("rotated too early: %v", backups)
+	}
+
+	// An idle file rotates on the flush tick once it is old enough
+	*clock = clock.Add(31 * time.Minute)
+	if _, err := sink.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	backups := logBackupNames(t, dir)
+	if len(backups) != 1 || backups[0] != "events-2026-03-01T13-01-00.000.ndjson" {
+		t.Fatalf("backups = %v", backups)
+	}
+
+	// The new, empty file isn't rotated until something is written to it
+	*clock = clock.Add(2 * time.Hour)
+	sink.Flush(context.Background())
+	if err := sink.Enqueue(event.Event{EventID: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+	if backups := logBackupNames(t, dir); len(backups) != 1 {
+		t.Errorf("backups = %v, want an empty file left alone", backups)
+	}
+	content, _ := os.ReadFile(logPath)
+	if !strings.Contains(string(content), "second") || strings.Contains(string(content), "first") {
+		t.Errorf("current file = %s", content)
+	}
+}
+
+func TestLogSinkCompress(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "events.ndjson")
+	sink, clock := startLogSink(t, LogConfig{Path: logPath, MaxAge: time.Hour, Compress: true})
+
+	if err := sink.Enqueue(event.Event{EventID: "compressed"}); err != nil {
+		t.Fatal(err)
+	}
+	*clock = clock.Add(time.Hour)
+	if _, err := sink.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sink.Close() // waits for compression
+
+	backups := logBackupNames(t, dir)
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".ndjson.gz") {
+		t.Fatalf("backups = %v, want one gzipped file", backups)
+	}
+	f, err := os.Open(filepath.Join(dir, backups[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil || !strings.Contains(string(content), "compressed") {
+		t.Errorf("decompressed = %s, %v", content, err)
+	}
+}
+
+func TestLogSinkRetention(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "events.ndjson")
+	old := []string{
+		"events-2026-02-01T00-00-00.000.ndjson.gz", // past LOG_RETENTION_DAYS
+		"events-2026-02-27T00-00-00.000.ndjson",
+		"events-2026-02-28T00-00-00.000.ndjson",
+		"events-2026-02-28T06-00-00.000.ndjson.gz",
+		"other.ndjson", // not a backup of this log
+	}
+	for _, name := range old {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sink, _ := startLogSink(t, LogConfig{Path: logPath, MaxBytes: 1 << 20, MaxBackups: 2, RetentionDays: 7})
+	sink.Close()
+
+	want := []string{
+		"events-2026-02-28T00-00-00.000.ndjson",
+		"events-2026-02-28T06-00-00.000.ndjson.gz",
+		"other.ndjson",
+	}
+	if got := logBackupNames(t, dir); !slices.Equal(got, want) {
+		t.Errorf("files after cleanup = %v, want %v", got, want)
+	}
+}
+
+func BenchmarkLogSinkEnqueue(b *testing.B) {
+	b.Setenv("LOG_PATH", filepath.Join(b.TempDir(), "events.log"))
+	sink := NewLogSink()
+	if err := sink.Start(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	defer sink.Close()
+	evt := wideTestEvent()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if err := sink.Enqueue(evt); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 // Helper function
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && indexOf(s, substr) >= 0)
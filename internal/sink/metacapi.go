@@ -0,0 +1,192 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// MetaConfig holds the Meta Conversions API credentials and event mapping
+type MetaConfig struct {
+	PixelID       string
+	AccessToken   string
+	TestEventCode string            // routes events to the Test Events tool instead of production
+	APIVersion    string            // Graph API version, e.g. v21.0
+	Events        map[string]string // GoTrack type -> Meta standard or custom event name
+	BaseURL       string            // Graph API origin; overridden in tests
+}
+
+// MetaCAPI sends conversions to Meta's Conversions API. An event qualifies
+// when its type is mapped and it carries a Meta identifier: fbclid, fbc or
+// fbp, or an email or phone prop.
+type MetaCAPI struct {
+	config MetaConfig
+	client *http.Client
+}
+
+// NewMetaSinkFromEnv creates a Meta Conversions API forwarder from
+// environment variables
+func NewMetaSinkFromEnv() (*Forwarder, error) {
+	events, err := parseEventMap(getEnvOr("META_EVENTS", "purchase=Purchase,generate_lead=Lead,sign_up=CompleteRegistration"))
+	if err != nil {
+		return nil, fmt.Errorf("META_EVENTS: %w", err)
+	}
+	return NewForwarder(NewMetaCAPI(MetaConfig{
+		PixelID:       os.Getenv("META_PIXEL_ID"),
+		AccessToken:   os.Getenv("META_ACCESS_TOKEN"),
+		TestEventCode: os.Getenv("META_TEST_EVENT_CODE"),
+		APIVersion:    getEnvOr("META_API_VERSION", "v21.0"),
+		Events:        events,
+	}), forwardConfigFromEnv()), nil
+}
+
+// NewMetaCAPI creates the Meta destination with explicit configuration
+func NewMetaCAPI(config MetaConfig) *MetaCAPI {
+	if config.BaseURL == "" {
+		config.BaseURL = "https://graph.facebook.com"
+	}
+	return &MetaCAPI{config: config, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (m *MetaCAPI) Name() string  { return "meta_capi" }
+func (m *MetaCAPI) MaxBatch() int { return 1000 }
+
+func (m *MetaCAPI) Check() error {
+	if m.config.PixelID == "" || m.config.AccessToken == "" {
+		return fmt.Errorf("META_PIXEL_ID and META_ACCESS_TOKEN are required for the meta_capi sink")
+	}
+	if len(m.config.Events) == 0 {
+		return fmt.Errorf("META_EVENTS maps no event types")
+	}
+	return nil
+}
+
+func (m *MetaCAPI) Qualifies(e event.Event) bool {
+	if _, ok := m.config.Events[e.Type]; !ok {
+		return false
+	}
+	meta := e.URL.Meta
+	return meta.FBCLID != "" || meta.FBC != "" || meta.FBP != "" || normalizedEmail(e) != "" || phoneDigits(e) != ""
+}
+
+// metaEvent is one entry of a Conversions API request
+type metaEvent struct {
+	EventName      string         `json:"event_name"`
+	EventTime      int64          `json:"event_time"`
+	EventID        string         `json:"event_id,omitempty"` // deduplicates against the browser pixel
+	ActionSource   string         `json:"action_source"`
+	EventSourceURL string         `json:"event_source_url,omitempty"`
+	UserData       map[string]any `json:"user_data"`
+	CustomData     map[string]any `json:"custom_data,omitempty"`
+}
+
+func (m *MetaCAPI) Send(ctx context.Context, events []event.Event) error {
+	// The token goes in the body so it stays out of logged URLs
+	body := map[string]any{"data": m.convert(events), "access_token": m.config.AccessToken}
+	if m.config.TestEventCode != "" {
+		body["test_event_code"] = m.config.TestEventCode
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return PermanentError{err}
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/%s/events", m.config.BaseURL, m.config.APIVersion, url.PathEscape(m.config.PixelID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return PermanentError{fmt.Errorf("failed to create meta request: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("meta request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func (m *MetaCAPI) convert(events []event.Event) []metaEvent {
+	out := make([]metaEvent, len(events))
+	for i, e := range events {
+		t := eventTime(e)
+		out[i] = metaEvent{
+			EventName:      m.config.Events[e.Type],
+			EventTime:      t.Unix(),
+			EventID:        e.EventID,
+			ActionSource:   "website",
+			EventSourceURL: pageURL(e),
+			UserData:       metaUserData(e, t),
+		}
+		if value, currency, ok := conversionValue(e); ok {
+			out[i].CustomData = map[string]any{"value": value, "currency": currency}
+		}
+		if e.Ecommerce != nil {
+			out[i].CustomData["order_id"] = e.Ecommerce.OrderID
+		}
+	}
+	return out
+}
+
+// metaUserData builds the customer information Meta matches on. Identifiers
+// are hashed with SHA-256 as Meta requires; click IDs, the IP and the user
+// agent are sent as is.
+func metaUserData(e event.Event, t time.Time) map[string]any {
+	ud := map[string]any{}
+	if fbc := e.URL.Meta.FBC; fbc != "" {
+		ud["fbc"] = fbc
+	} else if e.URL.Meta.FBCLID != "" {
+		// fbc format: fb.<subdomain index>.<creation time in ms>.<fbclid>
+		ud["fbc"] = "fb.1." + strconv.FormatInt(t.UnixMilli(), 10) + "." + e.URL.Meta.FBCLID
+	}
+	if e.URL.Meta.FBP != "" {
+		ud["fbp"] = e.URL.Meta.FBP
+	}
+	// server.ip_hash holds the raw address when IP privacy is off for this sink
+	if net.ParseIP(e.Server.IP) != nil {
+		ud["client_ip_address"] = e.Server.IP
+	}
+	if e.Device.UA != "" {
+		ud["client_user_agent"] = e.Device.UA
+	}
+	if email := normalizedEmail(e); email != "" {
+		ud["em"] = []string{sha256Hex(email)}
+	}
+	if phone := phoneDigits(e); phone != "" {
+		ud["ph"] = []string{sha256Hex(phone)}
+	}
+	if id := e.Session.UserID; id != "" {
+		ud["external_id"] = []string{sha256Hex(id)}
+	} else if id := e.Session.VisitorID; id != "" {
+		ud["external_id"] = []string{sha256Hex(id)}
+	}
+	return ud
+}
+
+// pageURL rebuilds the page an event happened on
+func pageURL(e event.Event) string {
+	if e.Route.CanonicalURL != "" {
+		return e.Route.CanonicalURL
+	}
+	if e.Route.Domain == "" {
+		return ""
+	}
+	scheme := e.Route.Protocol
+	if scheme == "" {
+		scheme = "https"
+	}
+	path := e.Route.FullPath
+	if path == "" {
+		path = e.Route.Path
+	}
+	return scheme + "://" + e.Route.Domain + path
+}
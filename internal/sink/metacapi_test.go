@@ -0,0 +1,101 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestMetaCAPISend(t *testing.T) {
+	var got struct {
+		Data          []metaEvent `json:"data"`
+		AccessToken   string      `json:"access_token"`
+		TestEventCode string      `json:"test_event_code"`
+	}
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"events_received":1}`))
+	}))
+	defer server.Close()
+
+	meta := NewMetaCAPI(MetaConfig{
+		PixelID:       "123",
+		AccessToken:   "token",
+		TestEventCode: "TEST1",
+		APIVersion:    "v21.0",
+		Events:        map[string]string{"purchase": "Purchase"},
+		BaseURL:       server.URL,
+	})
+	if err := meta.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := event.Event{
+		EventID: "ev-1",
+		Type:    "purchase",
+		TS:      "2024-01-02T03:04:05Z",
+		Route:   event.RouteInfo{Domain: "shop.example", Path: "/checkout", Protocol: "https"},
+		Props:   map[string]string{"value": "19.90", "currency": "usd", "email": " Jane@Example.com "},
+	}
+	e.URL.Meta.FBCLID = "abc"
+	e.Server.IP = "203.0.113.7"
+	e.Device.UA = "Mozilla/5.0"
+	e.Session.VisitorID = "v-1"
+
+	if !meta.Qualifies(e) {
+		t.Fatal("purchase with fbclid should qualify")
+	}
+	if err := meta.Send(context.Background(), []event.Event{e}); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/v21.0/123/events" || got.AccessToken != "token" || got.TestEventCode != "TEST1" || len(got.Data) != 1 {
+		t.Fatalf("path = %q, body = %+v", path, got)
+	}
+	sent := got.Data[0]
+	if sent.EventName != "Purchase" || sent.EventTime != 1704164645 || sent.EventID != "ev-1" ||
+		sent.ActionSource != "website" || sent.EventSourceURL != "https://shop.example/checkout" {
+		t.Errorf("event = %+v", sent)
+	}
+	ud := sent.UserData
+	if ud["fbc"] != "fb.1.1704164645000.abc" || ud["client_ip_address"] != "203.0.113.7" || ud["client_user_agent"] != "Mozilla/5.0" {
+		t.Errorf("user_data = %v", ud)
+	}
+	if em, _ := ud["em"].([]any); len(em) != 1 || em[0] != sha256Hex("jane@example.com") {
+		t.Errorf("em = %v", ud["em"])
+	}
+	if sent.CustomData["value"] != 19.9 || sent.CustomData["currency"] != "USD" {
+		t.Errorf("custom_data = %v", sent.CustomData)
+	}
+}
+
+func TestMetaCAPIQualifies(t *testing.T) {
+	meta := NewMetaCAPI(MetaConfig{Events: map[string]string{"purchase": "Purchase"}})
+
+	unmapped := event.Event{Type: "pageview"}
+	unmapped.URL.Meta.FBCLID = "abc"
+	anonymous := event.Event{Type: "purchase"}
+	// A hashed IP alone is not enough to match a conversion
+	anonymous.Server.IP = "d1f0e2"
+
+	for name, e := range map[string]event.Event{"unmapped type": unmapped, "no identifier": anonymous} {
+		if meta.Qualifies(e) {
+			t.Errorf("%s should not qualify", name)
+		}
+	}
+	if !meta.Qualifies(event.Event{Type: "purchase", Props: map[string]string{"phone": "+1 (555) 010-9999"}}) {
+		t.Error("purchase with a phone prop should qualify")
+	}
+	if err := meta.Check(); err == nil {
+		t.Error("expected error without pixel ID and token")
+	}
+}
@@ -0,0 +1,30 @@
+package sink
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// NullSink discards events. It gives load tests and benchmarks a sink with
+// no I/O, so they measure GoTrack rather than a destination.
+type NullSink struct {
+	count atomic.Int64
+}
+
+func NewNullSink() *NullSink { return &NullSink{} }
+
+func (s *NullSink) Start(ctx context.Context) error { return nil }
+
+func (s *NullSink) Enqueue(e event.Event) error {
+	s.count.Add(1)
+	return nil
+}
+
+func (s *NullSink) Close() error { return nil }
+
+func (s *NullSink) Name() string { return "null" }
+
+// Count returns how many events the sink has discarded
+func (s *NullSink) Count() int64 { return s.count.Load() }
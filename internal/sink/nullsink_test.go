@@ -0,0 +1,26 @@
+package sink
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestNullSink(t *testing.T) {
+	s := NewNullSink()
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if err := s.Enqueue(event.Event{Type: "pageview"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Count() != 3 || s.Name() != "null" {
+		t.Errorf("count = %d, name = %q", s.Count(), s.Name())
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,271 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shortontech/gotrack/internal/serde"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Partition layouts for PARQUET_PARTITION
+const (
+	ParquetPartitionHour = "hour" // date=YYYY-MM-DD/hour=HH
+	ParquetPartitionDay  = "day"  // date=YYYY-MM-DD
+)
+
+// ParquetConfig holds configuration for the Parquet file sink
+type ParquetConfig struct {
+	Dir          string // root of the dataset
+	Partition    string // hour or day, from the event timestamp in UTC
+	RowGroupRows int    // rows buffered in memory per row group
+	FileRows     int    // rows per file before it is closed
+	RollSeconds  int    // longest a file stays open before it is closed
+	Compression  string // none, snappy, gzip or zstd
+}
+
+// ParquetSink writes events to Parquet files under Hive-style date and hour
+// partitions, so Spark, DuckDB or Athena can query them directly. Files are
+// written under a hidden temporary name and renamed into place once their
+// footer is written, so readers never see a partial file.
+type ParquetSink struct {
+	config ParquetConfig
+	now    func() time.Time
+
+	mu    sync.Mutex
+	files map[string]*parquetPart // open file per partition directory
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// parquetPart is one file being written
+type parquetPart struct {
+	f      *os.File
+	buf    *bufio.Writer
+	w      *serde.ParquetWriter
+	tmp    string
+	final  string
+	opened time.Time
+}
+
+// NewParquetSinkFromEnv creates a ParquetSink from environment variables
+func NewParquetSinkFromEnv() *ParquetSink {
+	return NewParquetSink(ParquetConfig{
+		Dir:          getEnvOr("PARQUET_DIR", "parquet"),
+		Partition:    getEnvOr("PARQUET_PARTITION", ParquetPartitionHour),
+		RowGroupRows: getIntEnv("PARQUET_ROW_GROUP_ROWS", 10000),
+		FileRows:     getIntEnv("PARQUET_FILE_ROWS", 1000000),
+		RollSeconds:  getIntEnv("PARQUET_ROLL_SECONDS", 300),
+		Compression:  getEnvOr("PARQUET_COMPRESSION", serde.ParquetSnappy),
+	})
+}
+
+// NewParquetSink creates a ParquetSink with explicit configuration
+func NewParquetSink(config ParquetConfig) *ParquetSink {
+	return &ParquetSink{config: config, now: time.Now, files: map[string]*parquetPart{}}
+}
+
+func (s *ParquetSink) Start(ctx context.Context) error {
+	if err := serde.ValidParquetCompression(s.config.Compression); err != nil {
+		return err
+	}
+	switch s.config.Partition {
+	case ParquetPartitionHour, ParquetPartitionDay:
+	default:
+		return fmt.Errorf("invalid PARQUET_PARTITION %q (want hour or day)", s.config.Partition)
+	}
+	if s.config.RowGroupRows <= 0 || s.config.FileRows <= 0 || s.config.RollSeconds <= 0 {
+		return fmt.Errorf("PARQUET_ROW_GROUP_ROWS, PARQUET_FILE_ROWS and PARQUET_ROLL_SECONDS must be positive")
+	}
+	if err := os.MkdirAll(s.config.Dir, 0750); err != nil {
+		return fmt.Errorf("failed to create parquet directory: %w", err)
+	}
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go s.rollLoop(s.stop)
+	return nil
+}
+
+func (s *ParquetSink) Enqueue(e event.Event) error {
+	dir := filepath.Join(s.config.Dir, s.partition(e))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	part, ok := s.files[dir]
+	if !ok {
+		var err error
+		if part, err = s.open(dir); err != nil {
+			return err
+		}
+		s.files[dir] = part
+	}
+	if err := part.w.Write(&e); err != nil {
+		// A file that failed mid-write has no usable footer
+		delete(s.files, dir)
+		part.abort()
+		return fmt.Errorf("parquet write failed, events buffered for %s are lost: %w", part.final, err)
+	}
+	if part.w.Rows() >= int64(s.config.FileRows) {
+		delete(s.files, dir)
+		return part.commit()
+	}
+	return nil
+}
+
+// partition returns the directory for e under the dataset root, from its
+// timestamp, falling back to when it was received and then to now
+func (s *ParquetSink) partition(e event.Event) string {
+	ts, err := time.Parse(time.RFC3339Nano, e.TS)
+	if err != nil {
+		if ts, err = time.Parse(time.RFC3339Nano, e.ReceivedAt); err != nil {
+			ts = s.now()
+		}
+	}
+	ts = ts.UTC()
+	if s.config.Partition == ParquetPartitionDay {
+		return "date=" + ts.Format(time.DateOnly)
+	}
+	return filepath.Join("date="+ts.Format(time.DateOnly), "hour="+ts.Format("15"))
+}
+
+func (s *ParquetSink) open(dir string) (*parquetPart, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create parquet partition: %w", err)
+	}
+	now := s.now()
+	name := fmt.Sprintf("part-%s-%s.parquet", now.UTC().Format("20060102T150405"), uuid.NewString()[:8])
+	part := &parquetPart{
+		tmp:    filepath.Join(dir, "."+name+".tmp"),
+		final:  filepath.Join(dir, name),
+		opened: now,
+	}
+	f, err := os.OpenFile(part.tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, err
+	}
+	part.f = f
+	part.buf = bufio.NewWriterSize(f, 256<<10)
+	part.w, err = serde.NewParquetWriter(part.buf, serde.ParquetOptions{
+		RowGroupRows: s.config.RowGroupRows,
+		Compression:  s.config.Compression,
+	})
+	if err != nil {
+		part.abort()
+		return nil, err
+	}
+	return part, nil
+}
+
+// commit writes the footer and renames the file into place
+func (p *parquetPart) commit() error {
+	err := p.w.Close()
+	if err == nil {
+		err = p.buf.Flush()
+	}
+	if err == nil {
+		err = p.f.Sync()
+	}
+	if closeErr := p.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(p.tmp, p.final)
+	}
+	if err != nil {
+		os.Remove(p.tmp)
+		return fmt.Errorf("failed to write %s: %w", p.final, err)
+	}
+	return nil
+}
+
+// abort discards a file that can't be completed
+func (p *parquetPart) abort() {
+	if p.w != nil {
+		p.w.Close()
+	}
+	p.f.Close()
+	os.Remove(p.tmp)
+}
+
+// Flush closes every open file, making its events visible to readers, and
+// returns how many events they held
+func (s *ParquetSink) Flush(ctx context.Context) (int, error) {
+	return s.commitOpen(func(*parquetPart) bool { return true })
+}
+
+// commitOpen commits the open files that match
+func (s *ParquetSink) commitOpen(match func(*parquetPart) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	var errs []error
+	for dir, part := range s.files {
+		if !match(part) {
+			continue
+		}
+		delete(s.files, dir)
+		if err := part.commit(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n += int(part.w.Rows())
+	}
+	return n, errors.Join(errs...)
+}
+
+// roll closes files that have been open for PARQUET_ROLL_SECONDS
+func (s *ParquetSink) roll() (int, error) {
+	maxAge := time.Duration(s.config.RollSeconds) * time.Second
+	now := s.now()
+	return s.commitOpen(func(p *parquetPart) bool { return now.Sub(p.opened) >= maxAge })
+}
+
+func (s *ParquetSink) rollLoop(stop <-chan struct{}) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(min(time.Duration(s.config.RollSeconds)*time.Second, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := s.roll(); err != nil {
+				log.Printf("parquet sink: %v", err)
+			}
+		}
+	}
+}
+
+func (s *ParquetSink) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.wg.Wait()
+	_, err := s.Flush(context.Background())
+	return err
+}
+
+func (s *ParquetSink) Name() string {
+	return "parquet"
+}
+
+// Ping checks that new files can be created in the dataset directory, which
+// catches a full disk, a lost mount or changed permissions
+func (s *ParquetSink) Ping(ctx context.Context) error {
+	f, err := os.CreateTemp(s.config.Dir, ".ping-*")
+	if err != nil {
+		return fmt.Errorf("parquet directory not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
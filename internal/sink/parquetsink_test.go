@@ -0,0 +1,196 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/serde"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func startParquetSink(t *testing.T, config ParquetConfig) (*ParquetSink, *time.Time) {
+	t.Helper()
+	if config.Dir == "" {
+		config.Dir = t.TempDir()
+	}
+	if config.Partition == "" {
+		config.Partition = ParquetPartitionHour
+	}
+	if config.RowGroupRows == 0 {
+		config.RowGroupRows = 100
+	}
+	if config.FileRows == 0 {
+		config.FileRows = 1000
+	}
+	if config.RollSeconds == 0 {
+		config.RollSeconds = 3600 // rolled by hand in tests
+	}
+	clock := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := NewParquetSink(config)
+	s.now = func() time.Time { return clock }
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, &clock
+}
+
+// parquetFiles lists files under dir relative to it, hidden ones included
+func parquetFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	return files
+}
+
+func TestParquetSinkPartitions(t *testing.T) {
+	s, _ := startParquetSink(t, ParquetConfig{})
+	for _, e := range []event.Event{
+		{EventID: "a", TS: "2026-02-28T23:59:59Z"},
+		{EventID: "b", TS: "2026-03-01T01:30:00+02:00"}, // 23:30 UTC the day before
+		{EventID: "c", TS: "not a time", ReceivedAt: "2026-03-01T08:00:00.123Z"},
+		{EventID: "d"}, // the sink's clock
+	} {
+		if err := s.Enqueue(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing is visible to readers until the files are closed
+	for _, f := range parquetFiles(t, s.config.Dir) {
+		if !strings.HasPrefix(filepath.Base(f), ".") || !strings.HasSuffix(f, ".parquet.tmp") {
+			t.Errorf("unexpected file %s before Flush", f)
+		}
+	}
+
+	n, err := s.Flush(context.Background())
+	if err != nil || n != 4 {
+		t.Fatalf("Flush() = %d, %v; want 4 events", n, err)
+	}
+	var dirs []string
+	for _, f := range parquetFiles(t, s.config.Dir) {
+		if !strings.HasPrefix(filepath.Base(f), "part-20260301T120000-") || !strings.HasSuffix(f, ".parquet") {
+			t.Errorf("unexpected file %s after Flush", f)
+		}
+		dirs = append(dirs, filepath.Dir(f))
+		data, err := os.ReadFile(filepath.Join(s.config.Dir, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+			t.Errorf("%s is not a complete parquet file", f)
+		}
+	}
+	want := []string{"date=2026-02-28/hour=23", "date=2026-03-01/hour=08", "date=2026-03-01/hour=12"}
+	if !slices.Equal(dirs, want) {
+		t.Errorf("partitions = %v, want %v", dirs, want)
+	}
+}
+
+func TestParquetSinkDayPartition(t *testing.T) {
+	s, _ := startParquetSink(t, ParquetConfig{Partition: ParquetPartitionDay})
+	if got := s.partition(event.Event{TS: "2026-03-01T08:00:00Z"}); got != "date=2026-03-01" {
+		t.Errorf("partition = %q", got)
+	}
+}
+
+func TestParquetSinkFileRows(t *testing.T) {
+	s, _ := startParquetSink(t, ParquetConfig{RowGroupRows: 2, FileRows: 3})
+	for range 7 {
+		if err := s.Enqueue(event.Event{TS: "2026-03-01T12:00:00Z"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var closed, open int
+	for _, f := range parquetFiles(t, s.config.Dir) {
+		if strings.HasSuffix(f, ".tmp") {
+			open++
+		} else {
+			closed++
+		}
+	}
+	if closed != 2 || open != 1 {
+		t.Errorf("closed files = %d, open = %d; want 2 full files and 1 open", closed, open)
+	}
+}
+
+func TestParquetSinkRoll(t *testing.T) {
+	s, clock := startParquetSink(t, ParquetConfig{RollSeconds: 60})
+	if err := s.Enqueue(event.Event{TS: "2026-03-01T12:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	*clock = clock.Add(30 * time.Second)
+	if n, _ := s.roll(); n != 0 {
+		t.Fatalf("roll() closed %d events before PARQUET_ROLL_SECONDS", n)
+	}
+	*clock = clock.Add(30 * time.Second)
+	if n, err := s.roll(); n != 1 || err != nil {
+		t.Fatalf("roll() = %d, %v; want the file closed", n, err)
+	}
+
+	// The next event starts a new file in the same partition
+	if err := s.Enqueue(event.Event{TS: "2026-03-01T12:00:01Z"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files := parquetFiles(t, s.config.Dir); len(files) != 2 || strings.HasSuffix(files[1], ".tmp") {
+		t.Errorf("files = %v, want two closed files", files)
+	}
+}
+
+func TestParquetSinkStartValidation(t *testing.T) {
+	dir := t.TempDir()
+	for name, config := range map[string]ParquetConfig{
+		"compression": {Dir: dir, Partition: ParquetPartitionHour, RowGroupRows: 1, FileRows: 1, RollSeconds: 1, Compression: "lz4"},
+		"partition":   {Dir: dir, Partition: "minute", RowGroupRows: 1, FileRows: 1, RollSeconds: 1},
+		"row group":   {Dir: dir, Partition: ParquetPartitionHour, FileRows: 1, RollSeconds: 1},
+	} {
+		if err := NewParquetSink(config).Start(context.Background()); err == nil {
+			t.Errorf("%s: Start() should fail", name)
+		}
+	}
+}
+
+func TestParquetSinkPing(t *testing.T) {
+	s, _ := startParquetSink(t, ParquetConfig{})
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping() = %v", err)
+	}
+	if files := parquetFiles(t, s.config.Dir); len(files) != 0 {
+		t.Errorf("Ping left %v behind", files)
+	}
+	os.RemoveAll(s.config.Dir)
+	if err := s.Ping(context.Background()); err == nil {
+		t.Error("Ping() should fail once the directory is gone")
+	}
+}
+
+func TestParquetSinkEnv(t *testing.T) {
+	t.Setenv("PARQUET_DIR", "/data/events")
+	t.Setenv("PARQUET_COMPRESSION", serde.ParquetZstd)
+	t.Setenv("PARQUET_ROW_GROUP_ROWS", "5000")
+	s := NewParquetSinkFromEnv()
+	want := ParquetConfig{Dir: "/data/events", Partition: ParquetPartitionHour, RowGroupRows: 5000, FileRows: 1000000, RollSeconds: 300, Compression: serde.ParquetZstd}
+	if s.config != want {
+		t.Errorf("config = %+v, want %+v", s.config, want)
+	}
+}
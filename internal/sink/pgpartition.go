@@ -0,0 +1,190 @@
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Partition intervals for PG_PARTITION
+const (
+	PartitionNone    = ""
+	PartitionDaily   = "daily"
+	PartitionMonthly = "monthly"
+)
+
+// partitionMaintenanceInterval is how often upcoming partitions are created,
+// expired ones dropped and expired rows purged
+const partitionMaintenanceInterval = time.Hour
+
+// validatePartitioning checks the partition settings and that partition
+// names derived from the table stay within PostgreSQL's identifier limit
+func validatePartitioning(cfg PGConfig) error {
+	switch cfg.Partition {
+	case PartitionNone:
+		return nil
+	case PartitionDaily, PartitionMonthly:
+	default:
+		return fmt.Errorf("unknown PG_PARTITION %q (want daily or monthly)", cfg.Partition)
+	}
+	if len(cfg.Table)+len("_default") > 63 || len(partitionName(cfg.Table, cfg.Partition, time.Now())) > 63 {
+		return fmt.Errorf("table name too long for partition names (max 63 characters)")
+	}
+	if cfg.RetentionDays < 0 || cfg.PartitionsAhead < 0 {
+		return fmt.Errorf("PG_RETENTION_DAYS and PG_PARTITION_PREMAKE must not be negative")
+	}
+	return nil
+}
+
+// partitionStart truncates t (UTC) to the start of its partition
+func partitionStart(interval string, t time.Time) time.Time {
+	t = t.UTC()
+	if interval == PartitionMonthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// nextPartition returns the start of the partition after the one starting at start
+func nextPartition(interval string, start time.Time) time.Time {
+	if interval == PartitionMonthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// partitionName returns the child table holding t, e.g. events_json_p20261017
+// for daily or events_json_p202610 for monthly partitions
+func partitionName(table, interval string, t time.Time) string {
+	layout := "20060102"
+	if interval == PartitionMonthly {
+		layout = "200601"
+	}
+	return table + "_p" + partitionStart(interval, t).Format(layout)
+}
+
+// ensurePartitionedTable creates the partitioned parent and its default
+// partition, refusing to proceed if a plain table of the same name exists
+func (s *PGSink) ensurePartitionedTable() error {
+	var kind sql.NullString
+	err := s.db.QueryRowContext(s.ctx,
+		"SELECT c.relkind::text FROM pg_class c WHERE c.oid = to_regclass($1)", s.config.Table).Scan(&kind)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to inspect table: %w", err)
+	}
+	if kind.Valid && kind.String != "p" {
+		return fmt.Errorf("table %s exists and is not partitioned; migrate it or set PG_TABLE to a new table", s.config.Table)
+	}
+
+	// The partition key must be part of every unique constraint, so event_id
+	// dedupe is per (event_id, ts)
+	createTable := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			id BIGSERIAL,
+			%s,
+			PRIMARY KEY (id, ts),
+			UNIQUE (event_id, ts)
+		) PARTITION BY RANGE (ts)`, s.config.Table, s.columnDefinitions())
+	if _, err := s.db.ExecContext(s.ctx, createTable); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+
+	// Events with timestamps outside the managed partitions land here
+	createDefault := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s_default PARTITION OF %s DEFAULT", s.config.Table, s.config.Table)
+	if _, err := s.db.ExecContext(s.ctx, createDefault); err != nil {
+		return fmt.Errorf("failed to create default partition: %w", err)
+	}
+	return nil
+}
+
+// createPartitions creates the current partition and PartitionsAhead
+// upcoming ones. A failure is reported but later partitions are still tried.
+func (s *PGSink) createPartitions(now time.Time) error {
+	var firstErr error
+	start := partitionStart(s.config.Partition, now)
+	for i := 0; i <= s.config.PartitionsAhead; i++ {
+		end := nextPartition(s.config.Partition, start)
+		query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+			partitionName(s.config.Table, s.config.Partition, start), s.config.Table,
+			start.Format(time.RFC3339), end.Format(time.RFC3339))
+		if _, err := s.db.ExecContext(s.ctx, query); err != nil && firstErr == nil {
+			// Usually rows for this range already sit in the default partition
+			firstErr = fmt.Errorf("failed to create partition for %s: %w", start.Format("2006-01-02"), err)
+		}
+		start = end
+	}
+	return firstErr
+}
+
+// dropExpiredPartitions drops partitions whose whole range is older than
+// RetentionDays. The default partition is never dropped.
+func (s *PGSink) dropExpiredPartitions(now time.Time) error {
+	if s.config.RetentionDays <= 0 {
+		return nil
+	}
+
+	rows, err := s.db.QueryContext(s.ctx, `
+		SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = to_regclass($1)`, s.config.Table)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions: %w", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read partition: %w", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	cutoff := now.UTC().AddDate(0, 0, -s.config.RetentionDays)
+	for _, name := range names {
+		start, ok := parsePartitionName(s.config.Table, s.config.Partition, name)
+		if !ok || nextPartition(s.config.Partition, start).After(cutoff) {
+			continue
+		}
+		// Names come from the catalog and match our own pattern
+		if _, err := s.db.ExecContext(s.ctx, "DROP TABLE IF EXISTS "+name); err != nil {
+			return fmt.Errorf("failed to drop partition %s: %w", name, err)
+		}
+		log.Printf("postgres: dropped expired partition %s", name)
+	}
+	return nil
+}
+
+// parsePartitionName returns the start of the partition named name, or false
+// if it is not one of this table's managed partitions
+func parsePartitionName(table, interval, name string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(name, table+"_p")
+	if !ok {
+		return time.Time{}, false
+	}
+	layout := "20060102"
+	if interval == PartitionMonthly {
+		layout = "200601"
+	}
+	if len(suffix) != len(layout) {
+		return time.Time{}, false
+	}
+	start, err := time.Parse(layout, suffix)
+	return start, err == nil
+}
+
+// maintainPartitions creates upcoming partitions and drops expired ones
+func (s *PGSink) maintainPartitions(now time.Time) {
+	if err := s.createPartitions(now); err != nil {
+		log.Printf("postgres: %v", err)
+	}
+	if err := s.dropExpiredPartitions(now); err != nil {
+		log.Printf("postgres: %v", err)
+	}
+}
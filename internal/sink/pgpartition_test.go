@@ -0,0 +1,199 @@
+package sink
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestValidatePartitioning(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     PGConfig
+		wantErr string
+	}{
+		{"disabled", PGConfig{Table: "events_json"}, ""},
+		{"daily", PGConfig{Table: "events_json", Partition: PartitionDaily, PartitionsAhead: 3, RetentionDays: 30}, ""},
+		{"monthly", PGConfig{Table: "events_json", Partition: PartitionMonthly}, ""},
+		{"unknown interval", PGConfig{Table: "events_json", Partition: "weekly"}, "unknown PG_PARTITION"},
+		{"name too long", PGConfig{Table: strings.Repeat("e", 60), Partition: PartitionDaily}, "too long"},
+		{"negative retention", PGConfig{Table: "events_json", Partition: PartitionDaily, RetentionDays: -1}, "negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePartitioning(tt.cfg)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPartitionNaming(t *testing.T) {
+	ts := time.Date(2026, 12, 31, 23, 30, 0, 0, time.FixedZone("X", -2*3600)) // 2027-01-01 01:30 UTC
+
+	if got := partitionName("events_json", PartitionDaily, ts); got != "events_json_p20270101" {
+		t.Errorf("daily name = %q", got)
+	}
+	if got := partitionName("events_json", PartitionMonthly, ts); got != "events_json_p202701" {
+		t.Errorf("monthly name = %q", got)
+	}
+	if got := nextPartition(PartitionMonthly, partitionStart(PartitionMonthly, ts)); !got.Equal(time.Date(2027, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("next monthly partition = %v", got)
+	}
+
+	start, ok := parsePartitionName("events_json", PartitionDaily, "events_json_p20270101")
+	if !ok || !start.Equal(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("parsePartitionName = %v, %v", start, ok)
+	}
+	for _, name := range []string{"events_json_default", "events_json_p202701", "other_p20270101", "events_json_pxxxxxxxx"} {
+		if _, ok := parsePartitionName("events_json", PartitionDaily, name); ok {
+			t.Errorf("parsePartitionName(%q) should not match", name)
+		}
+	}
+}
+
+func TestPGSink_EnsureSchema_Partitioned(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{
+		config: PGConfig{Table: "test_events", Partition: PartitionDaily, PartitionsAhead: 2},
+		db:     db,
+		ctx:    context.Background(),
+	}
+
+	mock.ExpectQuery("SELECT c.relkind").WithArgs("test_events").
+		WillReturnRows(sqlmock.NewRows([]string{"relkind"}))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS test_events (")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS test_events_default PARTITION OF test_events DEFAULT").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	day := partitionStart(PartitionDaily, time.Now())
+	for i := 0; i < 3; i++ {
+		name := partitionName("test_events", PartitionDaily, day.AddDate(0, 0, i))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS " + name + " PARTITION OF test_events FOR VALUES FROM").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS idx_test_events_ts").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS idx_test_events_gin").WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, field := range []string{"gclid", "fbclid", "msclkid"} {
+		mock.ExpectExec("CREATE INDEX IF NOT EXISTS idx_test_events_" + field).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	if err := sink.ensureSchema(); err != nil {
+		t.Fatalf("ensureSchema failed: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestPGSink_EnsureSchema_RefusesPlainTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{
+		config: PGConfig{Table: "test_events", Partition: PartitionMonthly},
+		db:     db,
+		ctx:    context.Background(),
+	}
+	mock.ExpectQuery("SELECT c.relkind").WithArgs("test_events").
+		WillReturnRows(sqlmock.NewRows([]string{"relkind"}).AddRow("r"))
+
+	err = sink.ensureSchema()
+	if err == nil || !strings.Contains(err.Error(), "not partitioned") {
+		t.Errorf("ensureSchema error = %v, want not partitioned", err)
+	}
+}
+
+func TestPGSink_DropExpiredPartitions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{
+		config: PGConfig{Table: "test_events", Partition: PartitionDaily, RetentionDays: 30},
+		db:     db,
+		ctx:    context.Background(),
+	}
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT c.relname FROM pg_inherits").WithArgs("test_events").
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).
+			AddRow("test_events_p20260901").
+			AddRow("test_events_p20260917").
+			AddRow("test_events_p20261016").
+			AddRow("test_events_default"))
+	// Only the partition ending on or before the 2026-09-17 cutoff is dropped
+	mock.ExpectExec("DROP TABLE IF EXISTS test_events_p20260901").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := sink.dropExpiredPartitions(now); err != nil {
+		t.Fatalf("dropExpiredPartitions failed: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+
+	t.Run("disabled without retention", func(t *testing.T) {
+		sink.config.RetentionDays = 0
+		if err := sink.dropExpiredPartitions(now); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestPGSink_FlushWithInsert_PartitionedConflictTarget(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{
+		config: PGConfig{Table: "events_json", Partition: PartitionDaily},
+		db:     db,
+		ctx:    context.Background(),
+		batch:  []event.Event{{EventID: "123e4567-e89b-12d3-a456-426614174000", TS: "2026-10-17T12:00:00Z"}},
+	}
+	mock.ExpectExec(regexp.QuoteMeta("ON CONFLICT (event_id, ts) DO NOTHING")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := sink.flushWithInsert(); err != nil {
+		t.Fatalf("flushWithInsert failed: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestNewPGSinkFromEnv_Partitioning(t *testing.T) {
+	withEnvVars(t, map[string]string{"PG_PARTITION": "monthly", "PG_PARTITION_PREMAKE": "2", "PG_RETENTION_DAYS": "400"}, func() {
+		cfg := NewPGSinkFromEnv().config
+		if cfg.Partition != PartitionMonthly || cfg.PartitionsAhead != 2 || cfg.RetentionDays != 400 {
+			t.Errorf("unexpected partition config: %+v", cfg)
+		}
+	})
+	withEnvVars(t, map[string]string{"PG_PARTITION": "", "PG_PARTITION_PREMAKE": "", "PG_RETENTION_DAYS": ""}, func() {
+		cfg := NewPGSinkFromEnv().config
+		if cfg.Partition != PartitionNone || cfg.PartitionsAhead != 3 || cfg.RetentionDays != 0 || cfg.PurgeBatchSize != defaultPurgeBatchSize {
+			t.Errorf("unexpected partition defaults: %+v", cfg)
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package sink
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+// maxQueryLimit caps the page size of QueryEvents
+const maxQueryLimit = 500
+
+// QueryEvents returns stored events matching q, newest first. Pages are
+// keyed on (ts, id), so results stay stable while new events arrive.
+func (s *PGSink) QueryEvents(ctx context.Context, q sink.Query) (sink.Page, error) {
+	if s.db == nil {
+		return sink.Page{}, fmt.Errorf("postgres sink not started")
+	}
+	limit := q.Limit
+	if limit <= 0 || limit > maxQueryLimit {
+		limit = maxQueryLimit
+	}
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if s.wide() {
+		for _, f := range [][2]string{{"type", q.Type}, {"visitor_id", q.VisitorID}, {"session_id", q.SessionID}, {"ip", q.IP}} {
+			if f[1] != "" {
+				where = append(where, f[0]+" = "+arg(f[1]))
+			}
+		}
+	} else if filter := containmentFilter(q); filter != "" {
+		// Containment is served by the GIN index on payload
+		where = append(where, "payload @> "+arg(filter)+"::jsonb")
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "ts >= "+arg(q.Since))
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "ts < "+arg(q.Until))
+	}
+	if q.Cursor != "" {
+		ts, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return sink.Page{}, err
+		}
+		where = append(where, fmt.Sprintf("(ts, id) < (%s, %s)", arg(ts), arg(id)))
+	}
+
+	query := fmt.Sprintf("SELECT id, ts, %s FROM %s", s.selectList(), s.config.Table)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Fetch one extra row to learn whether another page exists
+	query += " ORDER BY ts DESC, id DESC LIMIT " + arg(limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return sink.Page{}, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	var page sink.Page
+	var lastTS time.Time
+	var lastID int64
+	for rows.Next() {
+		var id int64
+		var ts time.Time
+		ev, err := s.scanEvent(rows, &id, &ts)
+		if err != nil {
+			return sink.Page{}, err
+		}
+		if len(page.Events) == limit {
+			page.NextCursor = encodeCursor(lastTS, lastID)
+			break
+		}
+		page.Events = append(page.Events, ev)
+		lastTS, lastID = ts, id
+	}
+	return page, rows.Err()
+}
+
+// containmentFilter builds the JSONB object matched by the payload filters
+func containmentFilter(q sink.Query) string {
+	filter := map[string]any{}
+	if q.Type != "" {
+		filter["type"] = q.Type
+	}
+	session := map[string]string{}
+	if q.VisitorID != "" {
+		session["visitor_id"] = q.VisitorID
+	}
+	if q.SessionID != "" {
+		session["session_id"] = q.SessionID
+	}
+	if len(session) > 0 {
+		filter["session"] = session
+	}
+	if q.IP != "" {
+		filter["server"] = map[string]string{"ip_hash": q.IP}
+	}
+	if len(filter) == 0 {
+		return ""
+	}
+	out, _ := json.Marshal(filter)
+	return string(out)
+}
+
+// encodeCursor returns an opaque cursor for the row after (ts, id)
+func encodeCursor(ts time.Time, id int64) string {
+	raw := ts.UTC().Format(time.RFC3339Nano) + "," + strconv.FormatInt(id, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor parses a cursor from encodeCursor
+func decodeCursor(cursor string) (time.Time, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, sink.ErrInvalidCursor
+	}
+	tsPart, idPart, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return time.Time{}, 0, sink.ErrInvalidCursor
+	}
+	ts, err := time.Parse(time.RFC3339Nano, tsPart)
+	if err != nil {
+		return time.Time{}, 0, sink.ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, sink.ErrInvalidCursor
+	}
+	return ts, id, nil
+}
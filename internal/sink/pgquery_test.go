@@ -0,0 +1,141 @@
+package sink
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	ts := time.Date(2026, 10, 17, 12, 30, 0, 123456000, time.UTC)
+	gotTS, gotID, err := decodeCursor(encodeCursor(ts, 42))
+	if err != nil || !gotTS.Equal(ts) || gotID != 42 {
+		t.Errorf("decodeCursor = %v, %d, %v", gotTS, gotID, err)
+	}
+	for _, bad := range []string{"!!", "bm9jb21tYQ", "eA,MQ"} {
+		if _, _, err := decodeCursor(bad); !errors.Is(err, sink.ErrInvalidCursor) {
+			t.Errorf("decodeCursor(%q) = %v, want ErrInvalidCursor", bad, err)
+		}
+	}
+}
+
+func TestContainmentFilter(t *testing.T) {
+	if f := containmentFilter(sink.Query{}); f != "" {
+		t.Errorf("empty query filter = %q", f)
+	}
+	f := containmentFilter(sink.Query{Type: "click", VisitorID: "v1"})
+	if f != `{"session":{"visitor_id":"v1"},"type":"click"}` {
+		t.Errorf("filter = %s", f)
+	}
+	if f := containmentFilter(sink.Query{IP: "5f0c"}); f != `{"server":{"ip_hash":"5f0c"}}` {
+		t.Errorf("IP filter = %s", f)
+	}
+}
+
+func TestPGSinkQueryEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	pg := &PGSink{config: PGConfig{Table: "test_events"}, db: db}
+	since := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	t1 := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(-time.Minute)
+
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"SELECT id, ts, payload FROM test_events WHERE payload @> $1::jsonb AND ts >= $2 ORDER BY ts DESC, id DESC LIMIT $3")).
+		WithArgs(`{"type":"click"}`, since, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "payload"}).
+			AddRow(9, t1, []byte(`{"event_id":"c"}`)).
+			AddRow(7, t2, []byte(`{"event_id":"b"}`)).
+			AddRow(5, t2, []byte(`{"event_id":"a"}`)))
+
+	page, err := pg.QueryEvents(context.Background(), sink.Query{Type: "click", Since: since, Limit: 2})
+	if err != nil {
+		t.Fatalf("QueryEvents failed: %v", err)
+	}
+	if len(page.Events) != 2 || string(page.Events[1]) != `{"event_id":"b"}` {
+		t.Fatalf("events = %s", page.Events)
+	}
+	ts, id, err := decodeCursor(page.NextCursor)
+	if err != nil || !ts.Equal(t2) || id != 7 {
+		t.Errorf("next cursor = %v, %d, %v", ts, id, err)
+	}
+
+	// The last page has no cursor
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, ts, payload FROM test_events WHERE (ts, id) < ($1, $2) ORDER BY ts DESC, id DESC LIMIT $3")).
+		WithArgs(t2, int64(7), 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "payload"}).AddRow(5, t2, []byte(`{"event_id":"a"}`)))
+
+	page, err = pg.QueryEvents(context.Background(), sink.Query{Cursor: page.NextCursor, Limit: 2})
+	if err != nil {
+		t.Fatalf("QueryEvents failed: %v", err)
+	}
+	if len(page.Events) != 1 || page.NextCursor != "" {
+		t.Errorf("last page = %s, cursor %q", page.Events, page.NextCursor)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestPGSinkQueryEvents_Wide(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	pg := &PGSink{config: PGConfig{Table: "test_events", Schema: SchemaWide}, db: db}
+	until := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+
+	columns := append([]string{"id", "ts"}, pg.insertColumns()[2:]...)
+	row := make([]driver.Value, len(columns))
+	for i, c := range columns {
+		switch c {
+		case "id":
+			row[i] = 1
+		case "ts":
+			row[i] = until.Add(-time.Hour)
+		case "visitor_id":
+			row[i] = "v1"
+		case "payload":
+			row[i] = []byte(`{"event_id":"a"}`)
+		}
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("FROM test_events WHERE visitor_id = $1 AND session_id = $2 AND ts < $3 ORDER BY")).
+		WithArgs("v1", "s1", until, maxQueryLimit+1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(row...))
+
+	page, err := pg.QueryEvents(context.Background(), sink.Query{VisitorID: "v1", SessionID: "s1", Until: until})
+	if err != nil {
+		t.Fatalf("QueryEvents failed: %v", err)
+	}
+	if len(page.Events) != 1 || !regexp.MustCompile(`"visitor_id":"v1"`).Match(page.Events[0]) {
+		t.Errorf("events = %s", page.Events)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestPGSinkQueryEvents_InvalidCursor(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	pg := &PGSink{config: PGConfig{Table: "test_events"}, db: db}
+	if _, err := pg.QueryEvents(context.Background(), sink.Query{Cursor: "not-a-cursor"}); !errors.Is(err, sink.ErrInvalidCursor) {
+		t.Errorf("err = %v, want ErrInvalidCursor", err)
+	}
+}
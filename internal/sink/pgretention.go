@@ -0,0 +1,122 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+// defaultPurgeBatchSize bounds the rows one DELETE removes, so retention and
+// visitor purges don't hold locks on a large share of the table at once
+const defaultPurgeBatchSize = 5000
+
+// validateRetention checks the retention settings that apply to plain and
+// partitioned tables alike
+func validateRetention(cfg PGConfig) error {
+	if cfg.RetentionDays < 0 {
+		return fmt.Errorf("PG_RETENTION_DAYS must not be negative")
+	}
+	if cfg.PurgeBatchSize < 0 {
+		return fmt.Errorf("PG_PURGE_BATCH_SIZE must not be negative")
+	}
+	return nil
+}
+
+// purgeExpiredRows deletes rows older than RetentionDays. Partitioned tables
+// drop whole partitions instead, so only their default partition, which
+// holds rows outside the managed ranges, is purged row by row.
+func (s *PGSink) purgeExpiredRows(now time.Time) (int64, error) {
+	if s.config.RetentionDays <= 0 {
+		return 0, nil
+	}
+	table := s.config.Table
+	if s.config.Partition != PartitionNone {
+		table += "_default"
+	}
+	cutoff := now.UTC().AddDate(0, 0, -s.config.RetentionDays)
+	n, err := s.deleteInBatches(s.ctx, table, "ts < $1", cutoff)
+	if err != nil {
+		return n, fmt.Errorf("failed to purge expired events: %w", err)
+	}
+	return n, nil
+}
+
+// maintainRetention purges expired rows, logging the outcome
+func (s *PGSink) maintainRetention(now time.Time) {
+	n, err := s.purgeExpiredRows(now)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("postgres: %v", err)
+	}
+	if n > 0 {
+		log.Printf("postgres: purged %d events older than %d days", n, s.config.RetentionDays)
+	}
+	n, err = s.purgeExpiredSessions(now)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("postgres: %v", err)
+	}
+	if n > 0 {
+		log.Printf("postgres: purged %d sessions older than %d days", n, s.config.RetentionDays)
+	}
+}
+
+// PurgeVisitor deletes every stored event of a visitor, and their rolled up
+// sessions, to service a data subject deletion request. Events still
+// buffered are not affected, so callers should flush first.
+func (s *PGSink) PurgeVisitor(ctx context.Context, visitorID string) (int64, error) {
+	if s.db == nil {
+		return 0, fmt.Errorf("postgres sink not started")
+	}
+	if visitorID == "" {
+		return 0, errors.New("visitor ID is required")
+	}
+	cond, arg := "payload @> $1::jsonb", containmentFilter(sink.Query{VisitorID: visitorID})
+	if s.wide() {
+		cond, arg = "visitor_id = $1", visitorID
+	}
+	n, err := s.deleteInBatches(ctx, s.config.Table, cond, arg)
+	if err != nil {
+		return n, fmt.Errorf("failed to purge visitor events: %w", err)
+	}
+	if s.sessionsEnabled() {
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE visitor_id = $1", s.config.SessionsTable), visitorID); err != nil {
+			return n, fmt.Errorf("failed to purge visitor sessions: %w", err)
+		}
+	}
+	return n, nil
+}
+
+// deleteInBatches deletes the rows of table matching cond, PurgeBatchSize at
+// a time, until a batch comes back short. Each batch commits on its own, so
+// an interrupted purge keeps the rows it already deleted and can be rerun.
+func (s *PGSink) deleteInBatches(ctx context.Context, table, cond string, arg any) (int64, error) {
+	size := s.config.PurgeBatchSize
+	if size <= 0 {
+		size = defaultPurgeBatchSize
+	}
+	// Table names are validated at Start; (id, ts) is unique in both layouts
+	query := fmt.Sprintf("DELETE FROM %s WHERE (id, ts) IN (SELECT id, ts FROM %s WHERE %s LIMIT %d)",
+		table, table, cond, size)
+
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		res, err := s.db.ExecContext(ctx, query, arg)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(size) {
+			return total, nil
+		}
+	}
+}
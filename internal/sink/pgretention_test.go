@@ -0,0 +1,108 @@
+package sink
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestValidateRetention(t *testing.T) {
+	for _, cfg := range []PGConfig{{RetentionDays: -1}, {PurgeBatchSize: -1}} {
+		if err := validateRetention(cfg); err == nil {
+			t.Errorf("validateRetention(%+v) succeeded, want error", cfg)
+		}
+	}
+	if err := validateRetention(PGConfig{RetentionDays: 30}); err != nil {
+		t.Errorf("validateRetention error = %v", err)
+	}
+}
+
+func TestPGSink_PurgeExpiredRows(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{
+		config: PGConfig{Table: "test_events", RetentionDays: 30, PurgeBatchSize: 2},
+		db:     db,
+		ctx:    context.Background(),
+	}
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	cutoff := time.Date(2026, 9, 17, 12, 0, 0, 0, time.UTC)
+
+	// Batches repeat until one deletes fewer rows than the batch size
+	query := regexp.QuoteMeta("DELETE FROM test_events WHERE (id, ts) IN (SELECT id, ts FROM test_events WHERE ts < $1 LIMIT 2)")
+	mock.ExpectExec(query).WithArgs(cutoff).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(query).WithArgs(cutoff).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := sink.purgeExpiredRows(now)
+	if err != nil || n != 3 {
+		t.Fatalf("purgeExpiredRows = %d, %v, want 3 rows", n, err)
+	}
+
+	t.Run("partitioned tables purge the default partition", func(t *testing.T) {
+		sink.config.Partition = PartitionDaily
+		defer func() { sink.config.Partition = PartitionNone }()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM test_events_default WHERE")).WithArgs(cutoff).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		if _, err := sink.purgeExpiredRows(now); err != nil {
+			t.Errorf("purgeExpiredRows error = %v", err)
+		}
+	})
+
+	t.Run("disabled without retention", func(t *testing.T) {
+		sink.config.RetentionDays = 0
+		if n, err := sink.purgeExpiredRows(now); n != 0 || err != nil {
+			t.Errorf("purgeExpiredRows = %d, %v", n, err)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestPGSink_PurgeVisitor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{config: PGConfig{Table: "test_events"}, db: db, ctx: context.Background()}
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM test_events WHERE (id, ts) IN (SELECT id, ts FROM test_events WHERE payload @> $1::jsonb LIMIT 5000)")).
+		WithArgs(`{"session":{"visitor_id":"v-1"}}`).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+
+	if n, err := sink.PurgeVisitor(context.Background(), "v-1"); err != nil || n != 4 {
+		t.Fatalf("PurgeVisitor = %d, %v, want 4 rows", n, err)
+	}
+
+	t.Run("wide schema filters the column", func(t *testing.T) {
+		sink.config.Schema = SchemaWide
+		mock.ExpectExec(regexp.QuoteMeta("FROM test_events WHERE visitor_id = $1 LIMIT 5000")).
+			WithArgs("v-1").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		if _, err := sink.PurgeVisitor(context.Background(), "v-1"); err != nil {
+			t.Errorf("PurgeVisitor error = %v", err)
+		}
+	})
+
+	if _, err := sink.PurgeVisitor(context.Background(), ""); err == nil {
+		t.Error("PurgeVisitor succeeded without a visitor ID")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := sink.PurgeVisitor(ctx, "v-1"); err == nil {
+		t.Error("PurgeVisitor succeeded with a cancelled context")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// sessionsChunkSize bounds the events one roll-up statement scans for
+// sessions, so a first run over a large table proceeds in steps
+const sessionsChunkSize = 10000
+
+// sessionsLateCommit is the longest an insert is assumed to take to commit.
+// IDs are taken when rows are inserted, so a slow transaction may commit
+// IDs below ones a roll-up has already seen; runs re-scan the events that
+// were this recent, plus one interval, to pick them up.
+const sessionsLateCommit = 5 * time.Minute
+
+// sessionsMark records the newest event ID a roll-up run saw. Every ID up
+// to it was taken before the run, so one that commits later belongs to a
+// transaction that was still open.
+type sessionsMark struct {
+	at time.Time
+	id int64
+}
+
+// sessionFields maps the event fields the roll-up reads to SQL expressions
+// on the JSON payload. Wide tables hold some of them in columns.
+var sessionFields = map[string]string{
+	"type":              "payload->>'type'",
+	"visitor_id":        "payload->'session'->>'visitor_id'",
+	"session_id":        "payload->'session'->>'session_id'",
+	"site_id":           "payload->>'site_id'",
+	"path":              "payload->'route'->>'path'",
+	"utm_source":        "payload->'url'->'utm'->>'source'",
+	"utm_medium":        "payload->'url'->'utm'->>'medium'",
+	"utm_campaign":      "payload->'url'->'utm'->>'campaign'",
+	"referrer_hostname": "payload->'url'->>'referrer_hostname'",
+}
+
+// validateSessions checks the session roll-up settings
+func validateSessions(cfg PGConfig) error {
+	if cfg.SessionsInterval < 0 {
+		return fmt.Errorf("PG_SESSIONS_INTERVAL must not be negative")
+	}
+	if cfg.SessionsInterval == 0 {
+		return nil
+	}
+	if err := validateTableName(cfg.SessionsTable); err != nil {
+		return fmt.Errorf("invalid PG_SESSIONS_TABLE: %w", err)
+	}
+	if cfg.SessionsTable == cfg.Table {
+		return fmt.Errorf("PG_SESSIONS_TABLE must differ from PG_TABLE")
+	}
+	return nil
+}
+
+// sessionsEnabled reports whether events are rolled up into sessions
+func (s *PGSink) sessionsEnabled() bool {
+	return s.config.SessionsInterval > 0
+}
+
+// sessionField returns the SQL expression for an event field of the roll-up
+func (s *PGSink) sessionField(name string) string {
+	if s.wide() {
+		for _, c := range wideColumns {
+			if c.name == name {
+				return name
+			}
+		}
+	}
+	return sessionFields[name]
+}
+
+// ensureSessionsTable creates the sessions table and, for JSON tables, the
+// session ID index the roll-up finds a session's events by
+func (s *PGSink) ensureSessionsTable() error {
+	table := s.config.SessionsTable
+	createTable := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			session_id TEXT PRIMARY KEY,
+			visitor_id TEXT,
+			site_id TEXT,
+			started_at TIMESTAMPTZ NOT NULL,
+			ended_at TIMESTAMPTZ NOT NULL,
+			duration_seconds DOUBLE PRECISION NOT NULL,
+			events INTEGER NOT NULL,
+			pageviews INTEGER NOT NULL,
+			entry_page TEXT,
+			exit_page TEXT,
+			pages TEXT[],
+			utm_source TEXT,
+			utm_medium TEXT,
+			utm_campaign TEXT,
+			referrer_hostname TEXT,
+			last_event_id BIGINT NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`, table)
+	if _, err := s.db.ExecContext(s.ctx, createTable); err != nil {
+		return fmt.Errorf("failed to create sessions table: %w", err)
+	}
+
+	indexes := []string{
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_started_at ON %s (started_at)", table, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_visitor_id ON %s (visitor_id)", table, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_last_event_id ON %s (last_event_id)", table, table),
+	}
+	if !s.wide() {
+		expr := s.sessionField("session_id")
+		indexes = append(indexes, fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_session_id ON %s ((%s)) WHERE %s IS NOT NULL",
+			s.config.Table, s.config.Table, expr, expr))
+	}
+	for _, idx := range indexes {
+		if _, err := s.db.ExecContext(s.ctx, idx); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+	return nil
+}
+
+// rollUpQuery recomputes every session with an event whose id is in ($1, $2]
+// from all of the session's events, so a session's row is complete however
+// its events were split between runs
+func (s *PGSink) rollUpQuery() string {
+	f := s.sessionField
+	pageview := fmt.Sprintf("%s = 'pageview' AND %s <> ''", f("type"), f("path"))
+	first := func(field string) string {
+		return fmt.Sprintf("(array_agg(%s ORDER BY ts, id) FILTER (WHERE %s <> ''))[1]", f(field), f(field))
+	}
+	columns := []string{
+		"session_id", "visitor_id", "site_id", "started_at", "ended_at", "duration_seconds", "events", "pageviews",
+		"entry_page", "exit_page", "pages", "utm_source", "utm_medium", "utm_campaign", "referrer_hostname", "last_event_id",
+	}
+	updates := make([]string, 0, len(columns))
+	for _, c := range columns[1:] {
+		updates = append(updates, c+" = EXCLUDED."+c)
+	}
+	return fmt.Sprintf(`
+		WITH touched AS (
+			SELECT DISTINCT %[3]s AS session_id FROM %[1]s WHERE id > $1 AND id <= $2 AND %[3]s <> ''
+		)
+		INSERT INTO %[2]s (%[4]s)
+		SELECT %[3]s, max(%[5]s), max(%[6]s), min(ts), max(ts), EXTRACT(EPOCH FROM max(ts) - min(ts)), count(*),
+			count(*) FILTER (WHERE %[7]s),
+			(array_agg(%[8]s ORDER BY ts, id) FILTER (WHERE %[7]s))[1],
+			(array_agg(%[8]s ORDER BY ts DESC, id DESC) FILTER (WHERE %[7]s))[1],
+			array_agg(%[8]s ORDER BY ts, id) FILTER (WHERE %[7]s),
+			%[9]s, %[10]s, %[11]s, %[12]s, max(id)
+		FROM %[1]s
+		WHERE %[3]s IN (SELECT session_id FROM touched)
+		GROUP BY %[3]s
+		ON CONFLICT (session_id) DO UPDATE SET %[13]s, updated_at = now()`,
+		s.config.Table, s.config.SessionsTable, f("session_id"), strings.Join(columns, ", "),
+		f("visitor_id"), f("site_id"), pageview, f("path"),
+		first("utm_source"), first("utm_medium"), first("utm_campaign"), first("referrer_hostname"),
+		strings.Join(updates, ", "))
+}
+
+// sessionsStart returns the event ID a run at now resumes after: the newest
+// one seen by a run old enough that any transaction open then has committed.
+// A fresh sink resumes after the newest event already rolled up, by this
+// instance or any other sharing the table.
+func (s *PGSink) sessionsStart(ctx context.Context, now time.Time) (int64, error) {
+	if len(s.sessionsMarks) == 0 {
+		var stored int64
+		err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT COALESCE(max(last_event_id), 0) FROM %s", s.config.SessionsTable)).Scan(&stored)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read the last rolled up event: %w", err)
+		}
+		s.sessionsMarks = []sessionsMark{{at: now, id: stored}}
+	}
+	cutoff := now.Add(-sessionsLateCommit - time.Duration(s.config.SessionsInterval)*time.Second)
+	// Marks older than the newest one before the cutoff are no longer needed
+	i := 0
+	for i+1 < len(s.sessionsMarks) && !s.sessionsMarks[i+1].at.After(cutoff) {
+		i++
+	}
+	s.sessionsMarks = s.sessionsMarks[i:]
+	return s.sessionsMarks[0].id, nil
+}
+
+// rollUpSessions writes the sessions with events stored since an earlier
+// run, a chunk of events at a time, and returns the number of sessions
+// written. Recent events are rolled up again in case a transaction with
+// lower IDs committed after them (see sessionsLateCommit).
+func (s *PGSink) rollUpSessions(ctx context.Context, now time.Time) (int64, error) {
+	from, err := s.sessionsStart(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	var newest int64
+	if err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT COALESCE(max(id), 0) FROM %s", s.config.Table)).Scan(&newest); err != nil {
+		return 0, fmt.Errorf("failed to read the newest event: %w", err)
+	}
+
+	chunk := fmt.Sprintf("SELECT max(id) FROM (SELECT id FROM %s WHERE id > $1 ORDER BY id LIMIT %d) chunk", s.config.Table, sessionsChunkSize)
+	rollUp := s.rollUpQuery()
+	var total int64
+	for {
+		var to sql.NullInt64
+		if err := s.db.QueryRowContext(ctx, chunk, from).Scan(&to); err != nil {
+			return total, fmt.Errorf("failed to find new events: %w", err)
+		}
+		if !to.Valid {
+			s.sessionsMarks = append(s.sessionsMarks, sessionsMark{at: now, id: newest})
+			return total, nil
+		}
+		res, err := s.db.ExecContext(ctx, rollUp, from, to.Int64)
+		if err != nil {
+			return total, fmt.Errorf("failed to roll up sessions: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		from = to.Int64
+	}
+}
+
+// maintainSessions rolls up sessions, logging failures
+func (s *PGSink) maintainSessions(now time.Time) {
+	if _, err := s.rollUpSessions(s.ctx, now); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("postgres: %v", err)
+	}
+}
+
+// purgeExpiredSessions deletes sessions that ended more than RetentionDays ago
+func (s *PGSink) purgeExpiredSessions(now time.Time) (int64, error) {
+	if !s.sessionsEnabled() || s.config.RetentionDays <= 0 {
+		return 0, nil
+	}
+	cutoff := now.UTC().AddDate(0, 0, -s.config.RetentionDays)
+	res, err := s.db.ExecContext(s.ctx, fmt.Sprintf("DELETE FROM %s WHERE ended_at < $1", s.config.SessionsTable), cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired sessions: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,170 @@
+package sink
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestValidateSessions(t *testing.T) {
+	for _, cfg := range []PGConfig{
+		{SessionsInterval: -1},
+		{SessionsInterval: 60, Table: "events_json", SessionsTable: "bad-name"},
+		{SessionsInterval: 60, Table: "events_json", SessionsTable: "events_json"},
+	} {
+		if err := validateSessions(cfg); err == nil {
+			t.Errorf("validateSessions(%+v) succeeded, want error", cfg)
+		}
+	}
+	for _, cfg := range []PGConfig{{}, {SessionsInterval: 60, Table: "events_json", SessionsTable: "sessions"}} {
+		if err := validateSessions(cfg); err != nil {
+			t.Errorf("validateSessions(%+v) error = %v", cfg, err)
+		}
+	}
+}
+
+func TestPGSink_RollUpQuery(t *testing.T) {
+	sink := &PGSink{config: PGConfig{Table: "test_events", SessionsTable: "test_sessions"}}
+	query := sink.rollUpQuery()
+	for _, want := range []string{
+		"FROM test_events WHERE id > $1 AND id <= $2 AND payload->'session'->>'session_id' <> ''",
+		"INSERT INTO test_sessions (session_id, visitor_id,",
+		"(array_agg(payload->'route'->>'path' ORDER BY ts DESC, id DESC) FILTER (WHERE payload->>'type' = 'pageview'",
+		"ON CONFLICT (session_id) DO UPDATE SET visitor_id = EXCLUDED.visitor_id,",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("roll-up query lacks %q:\n%s", want, query)
+		}
+	}
+
+	sink.config.Schema = SchemaWide
+	query = sink.rollUpQuery()
+	for _, want := range []string{
+		"AND session_id <> ''",
+		"max(visitor_id), max(payload->>'site_id')",
+		"FILTER (WHERE type = 'pageview' AND payload->'route'->>'path' <> '')",
+		"(array_agg(utm_source ORDER BY ts, id) FILTER (WHERE utm_source <> ''))[1]",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("wide roll-up query lacks %q:\n%s", want, query)
+		}
+	}
+}
+
+func TestPGSink_RollUpSessions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{
+		config: PGConfig{Table: "test_events", SessionsTable: "test_sessions", SessionsInterval: 60},
+		db:     db,
+		ctx:    context.Background(),
+	}
+	last := regexp.QuoteMeta("SELECT COALESCE(max(last_event_id), 0) FROM test_sessions")
+	newest := regexp.QuoteMeta("SELECT COALESCE(max(id), 0) FROM test_events")
+	chunk := regexp.QuoteMeta("SELECT max(id) FROM (SELECT id FROM test_events WHERE id > $1 ORDER BY id LIMIT 10000) chunk")
+	rollUp := regexp.QuoteMeta("INSERT INTO test_sessions")
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	// Resumes after the newest event in the table and rolls up chunk by chunk
+	mock.ExpectQuery(last).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(100))
+	mock.ExpectQuery(newest).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(10150))
+	mock.ExpectQuery(chunk).WithArgs(100).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(10100))
+	mock.ExpectExec(rollUp).WithArgs(100, 10100).WillReturnResult(sqlmock.NewResult(0, 40))
+	mock.ExpectQuery(chunk).WithArgs(10100).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(10150))
+	mock.ExpectExec(rollUp).WithArgs(10100, 10150).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(chunk).WithArgs(10150).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+
+	if n, err := sink.rollUpSessions(context.Background(), start); err != nil || n != 42 {
+		t.Fatalf("rollUpSessions = %d, %v, want 42 sessions", n, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestPGSink_RollUpSessions_LateCommit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{
+		config: PGConfig{Table: "test_events", SessionsTable: "test_sessions", SessionsInterval: 60},
+		db:     db,
+		ctx:    context.Background(),
+	}
+	newest := regexp.QuoteMeta("SELECT COALESCE(max(id), 0) FROM test_events")
+	chunk := regexp.QuoteMeta("SELECT max(id) FROM (SELECT id FROM test_events WHERE id > $1 ORDER BY id LIMIT 10000) chunk")
+	rollUp := regexp.QuoteMeta("INSERT INTO test_sessions")
+	run := func(at time.Time, from, to int64) {
+		t.Helper()
+		mock.ExpectQuery(newest).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(to))
+		mock.ExpectQuery(chunk).WithArgs(from).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(to))
+		mock.ExpectExec(rollUp).WithArgs(from, to).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(chunk).WithArgs(to).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+		if _, err := sink.rollUpSessions(context.Background(), at); err != nil {
+			t.Fatalf("rollUpSessions at %s: %v", at, err)
+		}
+	}
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(max(last_event_id), 0) FROM test_sessions")).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(100))
+
+	// The first run sees events up to 200 while a batch holding 150 is still
+	// uncommitted. Later runs scan from 100 again until that run is older
+	// than the longest commit plus an interval, so they pick up 150 once it
+	// commits.
+	run(start, 100, 200)
+	run(start.Add(time.Minute), 100, 300)
+	run(start.Add(sessionsLateCommit), 100, 400)
+	run(start.Add(sessionsLateCommit+time.Minute), 200, 500)
+	run(start.Add(sessionsLateCommit+2*time.Minute), 300, 500)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestPGSink_PurgeSessions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{
+		config: PGConfig{Table: "test_events", SessionsTable: "test_sessions", SessionsInterval: 60, RetentionDays: 30},
+		db:     db,
+		ctx:    context.Background(),
+	}
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM test_sessions WHERE ended_at < $1")).
+		WithArgs(time.Date(2026, 9, 17, 12, 0, 0, 0, time.UTC)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	if n, err := sink.purgeExpiredSessions(now); err != nil || n != 3 {
+		t.Errorf("purgeExpiredSessions = %d, %v, want 3", n, err)
+	}
+
+	// Erasure removes the visitor's sessions with their events
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM test_events")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM test_sessions WHERE visitor_id = $1")).
+		WithArgs("v-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if n, err := sink.PurgeVisitor(context.Background(), "v-1"); err != nil || n != 1 {
+		t.Errorf("PurgeVisitor = %d, %v", n, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
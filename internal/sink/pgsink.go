@@ -11,12 +11,22 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lib/pq"
-	"github.com/shortontech/gotrack/internal/event"
+	"github.com/shortontech/gotrack/internal/metrics"
+	"github.com/shortontech/gotrack/internal/tracing"
+	"github.com/shortontech/gotrack/pkg/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// maxFlushLinks caps the request spans linked from one flush span
+const maxFlushLinks = 128
+
 // validSQLIdentifier matches valid SQL identifiers (table/column names)
 // Allows alphanumeric characters, underscores, and must start with letter or underscore
 var validSQLIdentifier = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
@@ -43,6 +53,23 @@ type PGConfig struct {
 	BatchSize int
 	FlushMS   int
 	UseCopy   bool
+	Schema    string // json (default) or wide
+
+	// Partitioning: daily or monthly range partitions on ts. Empty keeps a
+	// plain table.
+	Partition       string
+	PartitionsAhead int // upcoming partitions kept created
+
+	// Retention: events older than RetentionDays are deleted, by dropping
+	// partitions where the table has them and in batches of PurgeBatchSize
+	// rows otherwise. Zero keeps everything.
+	RetentionDays  int
+	PurgeBatchSize int
+
+	// Sessions: every SessionsInterval seconds the sessions with new events
+	// are rolled up into SessionsTable. Zero disables the job.
+	SessionsInterval int
+	SessionsTable    string
 }
 
 // PGSink implements high-throughput PostgreSQL ingestion with COPY support
@@ -52,11 +79,22 @@ type PGSink struct {
 
 	// Batching
 	batch      []event.Event
+	links      []trace.Link // spans that enqueued events in batch, for the flush span
 	batchMutex sync.Mutex
 	flushTimer *time.Timer
+	queued     atomic.Int64 // len(batch), readable without the batch lock
+	lastFlush  atomic.Int64 // duration of the last flush in nanoseconds
+	oldest     atomic.Int64 // when batch[0] was enqueued in Unix nanoseconds, 0 when empty
 	ctx        context.Context
 	cancel     context.CancelFunc
 	done       chan struct{}
+
+	// Event IDs rolled up into sessions by recent runs; only used by
+	// flushRoutine
+	sessionsMarks []sessionsMark
+
+	// Metrics receives the size and latency of each flush; optional
+	Metrics *metrics.Metrics
 }
 
 // NewPGSinkFromEnv creates a PGSink from environment variables
@@ -67,6 +105,15 @@ func NewPGSinkFromEnv() *PGSink {
 		BatchSize: getIntEnv("PG_BATCH_SIZE", 500),
 		FlushMS:   getIntEnv("PG_FLUSH_MS", 500),
 		UseCopy:   getBoolEnv("PG_COPY", true),
+		Schema:    getEnvOr("PG_SCHEMA", SchemaJSON),
+
+		Partition:       os.Getenv("PG_PARTITION"),
+		PartitionsAhead: getIntEnv("PG_PARTITION_PREMAKE", 3),
+		RetentionDays:   getIntEnv("PG_RETENTION_DAYS", 0),
+		PurgeBatchSize:  getIntEnv("PG_PURGE_BATCH_SIZE", defaultPurgeBatchSize),
+
+		SessionsInterval: getIntEnv("PG_SESSIONS_INTERVAL", 0),
+		SessionsTable:    getEnvOr("PG_SESSIONS_TABLE", "sessions"),
 	}
 
 	return &PGSink{config: config}
@@ -81,6 +128,10 @@ func NewPGSink(dsn string) *PGSink {
 			BatchSize: 500,
 			FlushMS:   500,
 			UseCopy:   true,
+
+			PartitionsAhead: 3,
+			PurgeBatchSize:  defaultPurgeBatchSize,
+			SessionsTable:   "sessions",
 		},
 	}
 }
@@ -94,6 +145,18 @@ func (s *PGSink) Start(ctx context.Context) error {
 	if err := validateTableName(s.config.Table); err != nil {
 		return fmt.Errorf("invalid table name: %w", err)
 	}
+	if err := validateSchemaMode(s.config.Schema); err != nil {
+		return err
+	}
+	if err := validatePartitioning(s.config); err != nil {
+		return fmt.Errorf("invalid partitioning: %w", err)
+	}
+	if err := validateRetention(s.config); err != nil {
+		return fmt.Errorf("invalid retention: %w", err)
+	}
+	if err := validateSessions(s.config); err != nil {
+		return fmt.Errorf("invalid session roll-up: %w", err)
+	}
 
 	// Connect to PostgreSQL
 	db, err := sql.Open("postgres", s.config.DSN)
@@ -121,10 +184,23 @@ func (s *PGSink) Start(ctx context.Context) error {
 }
 
 func (s *PGSink) Enqueue(e event.Event) error {
+	return s.EnqueueContext(context.Background(), e)
+}
+
+// EnqueueContext buffers e and remembers the span in ctx, so the flush span
+// that writes the batch links back to the requests it came from
+func (s *PGSink) EnqueueContext(ctx context.Context, e event.Event) error {
 	s.batchMutex.Lock()
 	defer s.batchMutex.Unlock()
 
+	if len(s.batch) == 0 {
+		s.oldest.Store(time.Now().UnixNano())
+	}
 	s.batch = append(s.batch, e)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() && len(s.links) < maxFlushLinks {
+		s.links = append(s.links, trace.Link{SpanContext: sc})
+	}
+	s.queued.Store(int64(len(s.batch)))
 
 	// If batch is full, flush immediately
 	if len(s.batch) >= s.config.BatchSize {
@@ -170,20 +246,93 @@ func (s *PGSink) Name() string {
 	return "postgres"
 }
 
-// ensureSchema creates the table and indexes if they don't exist
+// Ping checks the database connection
+func (s *PGSink) Ping(ctx context.Context) error {
+	if s.db == nil {
+		return fmt.Errorf("postgres sink not started")
+	}
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("postgres unreachable: %w", err)
+	}
+	return nil
+}
+
+// Flush writes the current batch without waiting for the flush interval.
+// A failed batch stays buffered for the next flush or Close.
+func (s *PGSink) Flush(ctx context.Context) (int, error) {
+	s.batchMutex.Lock()
+	defer s.batchMutex.Unlock()
+
+	if s.db == nil {
+		return 0, fmt.Errorf("postgres sink not started")
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	n := len(s.batch)
+	if err := s.flushBatch(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Load reports buffered events and the last flush duration. It never takes
+// the batch lock, which is held for the whole of a slow flush.
+func (s *PGSink) Load() (int, time.Duration) {
+	return int(s.queued.Load()), time.Duration(s.lastFlush.Load())
+}
+
+// OldestPending reports when the oldest buffered event was enqueued. Like
+// Load, it never takes the batch lock.
+func (s *PGSink) OldestPending() time.Time {
+	if ns := s.oldest.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// Reload re-reads PG_BATCH_SIZE and PG_FLUSH_MS. Buffered events are kept;
+// the new sizes apply from the next enqueue or flush tick.
+func (s *PGSink) Reload() error {
+	s.batchMutex.Lock()
+	defer s.batchMutex.Unlock()
+
+	if n := getIntEnv("PG_BATCH_SIZE", s.config.BatchSize); n > 0 {
+		s.config.BatchSize = n
+	}
+	if n := getIntEnv("PG_FLUSH_MS", s.config.FlushMS); n > 0 {
+		s.config.FlushMS = n
+	}
+	return nil
+}
+
+// flushInterval returns the current flush interval
+func (s *PGSink) flushInterval() time.Duration {
+	s.batchMutex.Lock()
+	defer s.batchMutex.Unlock()
+	return time.Duration(s.config.FlushMS) * time.Millisecond
+}
+
+// ensureSchema creates the table and indexes if they don't exist. With
+// partitioning it also creates the upcoming partitions and drops expired ones.
 func (s *PGSink) ensureSchema() error {
 	// Note: Table name is validated in Start() method to prevent SQL injection
-	// Create table
-	createTable := fmt.Sprintf(`
+	if s.config.Partition != PartitionNone {
+		if err := s.ensurePartitionedTable(); err != nil {
+			return err
+		}
+		s.maintainPartitions(time.Now())
+	} else {
+		createTable := fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS %s (
 			id BIGSERIAL PRIMARY KEY,
-			event_id UUID UNIQUE NOT NULL,
-			ts TIMESTAMPTZ NOT NULL DEFAULT now(),
-			payload JSONB NOT NULL
-		)`, s.config.Table)
+			%s,
+			UNIQUE (event_id)
+		)`, s.config.Table, s.columnDefinitions())
 
-	if _, err := s.db.ExecContext(s.ctx, createTable); err != nil {
-		return fmt.Errorf("failed to create table: %w", err)
+		if _, err := s.db.ExecContext(s.ctx, createTable); err != nil {
+			return fmt.Errorf("failed to create table: %w", err)
+		}
 	}
 
 	// Create indexes
@@ -192,30 +341,171 @@ func (s *PGSink) ensureSchema() error {
 		fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_gin ON %s USING GIN (payload)", s.config.Table, s.config.Table),
 	}
 
+	// Wide columns are indexed directly; click IDs left in the payload get
+	// expression indexes for support lookups (see FindEvents)
+	if s.wide() {
+		indexes = append(indexes, s.wideIndexes()...)
+	}
+	for _, field := range []string{"gclid", "fbclid", "msclkid"} {
+		expr := s.searchExpression(field)
+		if expr == field {
+			continue
+		}
+		indexes = append(indexes, fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_%s ON %s ((%s)) WHERE %s IS NOT NULL",
+			s.config.Table, field, s.config.Table, expr, expr))
+	}
+
 	for _, idx := range indexes {
 		if _, err := s.db.ExecContext(s.ctx, idx); err != nil {
 			return fmt.Errorf("failed to create index: %w", err)
 		}
 	}
 
+	if s.sessionsEnabled() {
+		return s.ensureSessionsTable()
+	}
 	return nil
 }
 
+// searchExpressions maps click ID lookup fields to indexed SQL expressions
+var searchExpressions = map[string]string{
+	"gclid":   "payload->'url'->'google'->>'gclid'",
+	"fbclid":  "payload->'url'->'meta'->>'fbclid'",
+	"msclkid": "payload->'url'->'microsoft'->>'msclkid'",
+}
+
+// searchExpression returns the SQL expression for a click ID lookup field,
+// or "" if the field is unsupported. Wide tables hold some click IDs in columns.
+func (s *PGSink) searchExpression(field string) string {
+	if s.wide() {
+		for _, c := range wideColumns {
+			if c.name == field {
+				return field
+			}
+		}
+	}
+	return searchExpressions[field]
+}
+
+// selectList returns the columns scanEvent reads
+func (s *PGSink) selectList() string {
+	if s.wide() {
+		return strings.Join(s.insertColumns()[2:], ", ")
+	}
+	return "payload"
+}
+
+// scanEvent reads one event selected with selectList, after any leading
+// columns scanned into lead. Wide rows are reassembled into the full event.
+func (s *PGSink) scanEvent(rows *sql.Rows, lead ...interface{}) (json.RawMessage, error) {
+	var payload []byte
+	if !s.wide() {
+		if err := rows.Scan(append(lead, &payload)...); err != nil {
+			return nil, fmt.Errorf("failed to read event: %w", err)
+		}
+		return json.RawMessage(payload), nil
+	}
+
+	values := make([]*string, len(wideColumns))
+	dest := lead
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	if err := rows.Scan(append(dest, &payload)...); err != nil {
+		return nil, fmt.Errorf("failed to read event: %w", err)
+	}
+	restored, err := restoreWide(values, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode event: %w", err)
+	}
+	return restored, nil
+}
+
+// FindEvents returns up to limit stored event payloads (newest first) whose
+// field (event_id, gclid, fbclid or msclkid) equals value. Payloads include server enrichment and detection data.
+func (s *PGSink) FindEvents(ctx context.Context, field, value string, limit int) ([]json.RawMessage, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("postgres sink not started")
+	}
+
+	selected := s.selectList()
+
+	var query string
+	if field == "event_id" {
+		// Compare as UUID so the unique index is used
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE event_id = $1::uuid ORDER BY ts DESC LIMIT $2", selected, s.config.Table)
+	} else {
+		expr := s.searchExpression(field)
+		if expr == "" {
+			return nil, fmt.Errorf("unsupported search field %q", field)
+		}
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1 ORDER BY ts DESC LIMIT $2", selected, s.config.Table, expr)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, value, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search events: %w", err)
+	}
+	defer rows.Close()
+
+	var results []json.RawMessage
+	for rows.Next() {
+		ev, err := s.scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, ev)
+	}
+	return results, rows.Err()
+}
+
 // flushRoutine handles periodic flushing and cleanup
 func (s *PGSink) flushRoutine() {
 	defer close(s.done)
 
-	ticker := time.NewTicker(time.Duration(s.config.FlushMS) * time.Millisecond)
+	interval := s.flushInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	// Partition maintenance and the retention purge share this loop; a nil
+	// channel never fires
+	var maintenance <-chan time.Time
+	if s.config.Partition != PartitionNone || s.config.RetentionDays > 0 {
+		maintenanceTicker := time.NewTicker(partitionMaintenanceInterval)
+		defer maintenanceTicker.Stop()
+		maintenance = maintenanceTicker.C
+	}
+	var sessions <-chan time.Time
+	if s.sessionsEnabled() {
+		sessionsTicker := time.NewTicker(time.Duration(s.config.SessionsInterval) * time.Second)
+		defer sessionsTicker.Stop()
+		sessions = sessionsTicker.C
+	}
+	// Partitions were maintained at startup; rows are purged here so a large
+	// first purge doesn't hold up Start
+	s.maintainRetention(time.Now())
+
 	for {
 		select {
 		case <-s.ctx.Done():
 			return
+		case now := <-maintenance:
+			if s.config.Partition != PartitionNone {
+				s.maintainPartitions(now)
+			}
+			s.maintainRetention(now)
+		case now := <-sessions:
+			s.maintainSessions(now)
 		case <-ticker.C:
 			s.batchMutex.Lock()
 			_ = s.flushBatch() // Error logged within flushBatch
 			s.batchMutex.Unlock()
+
+			// Pick up a reloaded flush interval
+			if current := s.flushInterval(); current != interval {
+				interval = current
+				ticker.Reset(interval)
+			}
 		}
 	}
 }
@@ -231,20 +521,45 @@ func (s *PGSink) flushBatch() error {
 		return nil
 	}
 
+	spanName := "postgres.insert"
+	if s.config.UseCopy {
+		spanName = "postgres.copy"
+	}
+	_, span := tracing.Tracer().Start(s.ctx, spanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithLinks(s.links...),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBSQLTable(s.config.Table),
+			attribute.Int("gotrack.batch.size", len(s.batch)),
+		))
+	defer span.End()
+
+	start := time.Now()
 	var err error
 	if s.config.UseCopy {
 		err = s.flushWithCopy()
 	} else {
 		err = s.flushWithInsert()
 	}
+	elapsed := time.Since(start)
+	s.lastFlush.Store(int64(elapsed))
+	if s.Metrics != nil {
+		s.Metrics.ObserveSinkFlush(s.Name(), len(s.batch), elapsed)
+	}
 
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		// In production, you might want to handle this more gracefully
 		// (e.g., retry, dead letter queue, etc.)
 		fmt.Fprintf(os.Stderr, "PostgreSQL flush error: %v\n", err)
 	} else {
 		// Clear the batch on successful flush
 		s.batch = s.batch[:0]
+		s.links = s.links[:0]
+		s.queued.Store(0)
+		s.oldest.Store(0)
 	}
 
 	return err
@@ -259,7 +574,7 @@ func (s *PGSink) flushWithCopy() error {
 	defer txn.Rollback()
 
 	// Prepare COPY statement
-	stmt, err := txn.PrepareContext(s.ctx, pq.CopyIn(s.config.Table, "event_id", "ts", "payload"))
+	stmt, err := txn.PrepareContext(s.ctx, pq.CopyIn(s.config.Table, s.insertColumns()...))
 	if err != nil {
 		return fmt.Errorf("failed to prepare copy: %w", err)
 	}
@@ -267,23 +582,12 @@ func (s *PGSink) flushWithCopy() error {
 
 	// Add events to COPY
 	for _, e := range s.batch {
-		payload, err := json.Marshal(e)
+		values, err := s.rowValues(e)
 		if err != nil {
 			continue // Skip invalid events
 		}
 
-		var ts time.Time
-		if e.TS != "" {
-			if parsed, err := time.Parse(time.RFC3339, e.TS); err == nil {
-				ts = parsed
-			} else {
-				ts = time.Now()
-			}
-		} else {
-			ts = time.Now()
-		}
-
-		_, err = stmt.ExecContext(s.ctx, e.EventID, ts, string(payload))
+		_, err = stmt.ExecContext(s.ctx, values...)
 		if err != nil {
 			// Skip events with constraint violations (duplicate event_id)
 			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
@@ -308,43 +612,35 @@ func (s *PGSink) flushWithInsert() error {
 	}
 
 	// Build multi-value INSERT
-	placeholders := make([]string, len(s.batch))
-	args := make([]interface{}, len(s.batch)*3)
-
-	for i, e := range s.batch {
-		placeholders[i] = fmt.Sprintf("($%d, $%d, $%d)", i*3+1, i*3+2, i*3+3)
-
-		// event_id
-		args[i*3] = e.EventID
-
-		// timestamp
-		var ts time.Time
-		if e.TS != "" {
-			if parsed, err := time.Parse(time.RFC3339, e.TS); err == nil {
-				ts = parsed
-			} else {
-				ts = time.Now()
-			}
-		} else {
-			ts = time.Now()
-		}
-		args[i*3+1] = ts
+	columns := s.insertColumns()
+	placeholders := make([]string, 0, len(s.batch))
+	args := make([]interface{}, 0, len(s.batch)*len(columns))
 
-		// payload as JSONB
-		payload, err := json.Marshal(e)
+	for _, e := range s.batch {
+		values, err := s.rowValues(e)
 		if err != nil {
-			payload = []byte("{}") // Fallback to empty object
+			continue // Skip invalid events
 		}
-		args[i*3+2] = string(payload)
+		row := make([]string, len(values))
+		for k := range values {
+			row[k] = fmt.Sprintf("$%d", len(args)+k+1)
+		}
+		placeholders = append(placeholders, "("+strings.Join(row, ", ")+")")
+		args = append(args, values...)
+	}
+	if len(placeholders) == 0 {
+		return nil
 	}
 
 	// Note: Table name is validated in Start() method to prevent SQL injection
 	query := fmt.Sprintf(`
-		INSERT INTO %s (event_id, ts, payload) 
-		VALUES %s 
-		ON CONFLICT (event_id) DO NOTHING`,
+		INSERT INTO %s (%s)
+		VALUES %s
+		ON CONFLICT %s DO NOTHING`,
 		s.config.Table,
-		strings.Join(placeholders, ", "))
+		strings.Join(columns, ", "),
+		strings.Join(placeholders, ", "),
+		s.conflictTarget())
 
 	_, err := s.db.ExecContext(s.ctx, query, args...)
 	if err != nil {
@@ -354,6 +650,25 @@ func (s *PGSink) flushWithInsert() error {
 	return nil
 }
 
+// eventTime returns the event timestamp, or now if it is missing or invalid
+func eventTime(e event.Event) time.Time {
+	if e.TS != "" {
+		if parsed, err := time.Parse(time.RFC3339, e.TS); err == nil {
+			return parsed
+		}
+	}
+	return time.Now()
+}
+
+// conflictTarget returns the unique key used for idempotent inserts.
+// Partitioned tables must include ts in it.
+func (s *PGSink) conflictTarget() string {
+	if s.config.Partition != PartitionNone {
+		return "(event_id, ts)"
+	}
+	return "(event_id)"
+}
+
 // Helper functions
 func getIntEnv(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
@@ -8,7 +8,11 @@ import (
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/shortontech/gotrack/internal/event"
+	"github.com/shortontech/gotrack/pkg/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // TestValidateTableName tests SQL injection prevention
@@ -258,6 +262,12 @@ func TestPGSinkEnqueueBatching(t *testing.T) {
 		if len(sink.batch) != 5 {
 			t.Errorf("batch length = %d, want 5", len(sink.batch))
 		}
+		if depth, _ := sink.Load(); depth != 5 {
+			t.Errorf("Load() depth = %d, want 5", depth)
+		}
+		if oldest := sink.OldestPending(); oldest.IsZero() || time.Since(oldest) > time.Minute {
+			t.Errorf("OldestPending() = %v, want the first enqueue", oldest)
+		}
 	})
 }
 
@@ -407,6 +417,12 @@ func TestPGSink_EnsureSchema_Success(t *testing.T) {
 	mock.ExpectExec("CREATE INDEX IF NOT EXISTS idx_test_events_gin").
 		WillReturnResult(sqlmock.NewResult(0, 0))
 
+	// Expect click ID lookup indexes
+	for _, field := range []string{"gclid", "fbclid", "msclkid"} {
+		mock.ExpectExec("CREATE INDEX IF NOT EXISTS idx_test_events_" + field).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
 	err = sink.ensureSchema()
 	if err != nil {
 		t.Errorf("ensureSchema failed: %v", err)
@@ -690,6 +706,122 @@ func TestPGSink_FlushBatch_ErrorKeepsBatch(t *testing.T) {
 	}
 }
 
+// recordSpans installs a tracer provider that keeps finished spans in memory
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return recorder
+}
+
+// Test that a flush span links to the requests that enqueued the batch
+func TestPGSink_FlushBatch_Span(t *testing.T) {
+	recorder := recordSpans(t)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{
+		config: PGConfig{Table: "events_json", BatchSize: 10, FlushMS: 60000},
+		db:     db,
+		ctx:    context.Background(),
+	}
+
+	reqCtx, reqSpan := otel.Tracer("test").Start(context.Background(), "POST /collect")
+	if err := sink.EnqueueContext(reqCtx, event.Event{EventID: "evt-001", Type: "click"}); err != nil {
+		t.Fatalf("EnqueueContext failed: %v", err)
+	}
+	reqSpan.End()
+	if err := sink.Enqueue(event.Event{EventID: "evt-002", Type: "click"}); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	sink.flushTimer.Stop()
+
+	mock.ExpectExec("INSERT INTO events_json").WillReturnResult(sqlmock.NewResult(0, 2))
+	if err := sink.flushBatch(); err != nil {
+		t.Fatalf("flushBatch failed: %v", err)
+	}
+
+	spans := recorder.Ended()
+	flush := spans[len(spans)-1]
+	if flush.Name() != "postgres.insert" {
+		t.Fatalf("flush span = %q", flush.Name())
+	}
+	links := flush.Links()
+	if len(links) != 1 || links[0].SpanContext.SpanID() != reqSpan.SpanContext().SpanID() {
+		t.Errorf("flush span links = %v, want the request span", links)
+	}
+	if len(sink.links) != 0 {
+		t.Errorf("links should be cleared with the batch, got %d", len(sink.links))
+	}
+}
+
+// Test the database health check
+func TestPGSink_Ping(t *testing.T) {
+	if err := (&PGSink{}).Ping(context.Background()); err == nil {
+		t.Error("Ping() before Start should fail")
+	}
+
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{db: db}
+	mock.ExpectPing()
+	if err := sink.Ping(context.Background()); err != nil {
+		t.Errorf("Ping() = %v", err)
+	}
+	mock.ExpectPing().WillReturnError(fmt.Errorf("connection refused"))
+	if err := sink.Ping(context.Background()); err == nil {
+		t.Error("Ping() should fail when the database is unreachable")
+	}
+}
+
+func TestPGSink_Flush(t *testing.T) {
+	if _, err := (&PGSink{}).Flush(context.Background()); err == nil {
+		t.Error("Flush() before Start should fail")
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{
+		config: PGConfig{Table: "events_json"},
+		db:     db,
+		ctx:    context.Background(),
+		batch:  []event.Event{{EventID: "evt-001"}, {EventID: "evt-002"}},
+	}
+	mock.ExpectExec("INSERT INTO events_json").WillReturnError(fmt.Errorf("connection reset"))
+	if n, err := sink.Flush(context.Background()); err == nil || n != 0 {
+		t.Errorf("Flush() = %d, %v, want 0 and an error", n, err)
+	}
+
+	mock.ExpectExec("INSERT INTO events_json").WillReturnResult(sqlmock.NewResult(0, 2))
+	if n, err := sink.Flush(context.Background()); err != nil || n != 2 {
+		t.Errorf("Flush() = %d, %v, want 2, nil", n, err)
+	}
+	if len(sink.batch) != 0 {
+		t.Errorf("batch not cleared, %d events left", len(sink.batch))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
 // Test flushRoutine periodic flushing
 func TestPGSink_FlushRoutine(t *testing.T) {
 	db, mock, err := sqlmock.New()
@@ -867,7 +999,7 @@ func TestPGSink_FlushWithCopy_PrepareError(t *testing.T) {
 
 // Test PGSink Enqueue with timer
 func TestPGSink_Enqueue_Timer(t *testing.T) {
-	db, _ , err := sqlmock.New()
+	db, _, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("failed to create mock: %v", err)
 	}
@@ -896,3 +1028,76 @@ func TestPGSink_Enqueue_Timer(t *testing.T) {
 		t.Errorf("batch should have 1 event, got %d", len(sink.batch))
 	}
 }
+
+// TestPGSinkReload tests that batching settings can change without a restart
+func TestPGSinkReload(t *testing.T) {
+	s := NewPGSink("postgres://localhost/test")
+	t.Setenv("PG_BATCH_SIZE", "50")
+	t.Setenv("PG_FLUSH_MS", "2000")
+
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if s.config.BatchSize != 50 {
+		t.Errorf("BatchSize = %d, want 50", s.config.BatchSize)
+	}
+	if got := s.flushInterval(); got != 2*time.Second {
+		t.Errorf("flushInterval() = %v, want 2s", got)
+	}
+
+	// Invalid values keep the current setting
+	t.Setenv("PG_BATCH_SIZE", "0")
+	_ = s.Reload()
+	if s.config.BatchSize != 50 {
+		t.Errorf("BatchSize = %d after invalid reload, want 50", s.config.BatchSize)
+	}
+}
+
+// TestPGSinkFindEvents tests support lookups by event ID and click ID
+func TestPGSinkFindEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{config: PGConfig{Table: "test_events"}, db: db}
+	ctx := context.Background()
+
+	t.Run("by gclid", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT payload FROM test_events WHERE payload->'url'->'google'->>'gclid' = \$1`).
+			WithArgs("XYZ", 20).
+			WillReturnRows(sqlmock.NewRows([]string{"payload"}).
+				AddRow([]byte(`{"event_id":"a"}`)).
+				AddRow([]byte(`{"event_id":"b"}`)))
+
+		events, err := sink.FindEvents(ctx, "gclid", "XYZ", 20)
+		if err != nil {
+			t.Fatalf("FindEvents failed: %v", err)
+		}
+		if len(events) != 2 || string(events[0]) != `{"event_id":"a"}` {
+			t.Errorf("unexpected events: %s", events)
+		}
+	})
+
+	t.Run("by event_id", func(t *testing.T) {
+		mock.ExpectQuery(`WHERE event_id = \$1::uuid`).
+			WithArgs("6f1c0c64-1d8f-4a1e-9d55-1f5d2b8a7c01", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"payload"}))
+
+		events, err := sink.FindEvents(ctx, "event_id", "6f1c0c64-1d8f-4a1e-9d55-1f5d2b8a7c01", 1)
+		if err != nil || len(events) != 0 {
+			t.Errorf("FindEvents = %v, %v", events, err)
+		}
+	})
+
+	t.Run("rejects unknown field", func(t *testing.T) {
+		if _, err := sink.FindEvents(ctx, "payload", "x", 1); err == nil {
+			t.Error("expected error for unsupported field")
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/shortontech/gotrack/internal/serde"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Table layouts for PG_SCHEMA
+const (
+	SchemaJSON = "json" // event_id, ts and the whole event as JSONB
+	SchemaWide = "wide" // common fields in typed columns, the rest as JSONB
+)
+
+// validateSchemaMode checks PG_SCHEMA. Empty means json.
+func validateSchemaMode(mode string) error {
+	switch mode {
+	case "", SchemaJSON, SchemaWide:
+		return nil
+	}
+	return fmt.Errorf("unknown PG_SCHEMA %q (want json or wide)", mode)
+}
+
+// wideColumn maps an event field to a text column in the wide schema
+type wideColumn struct {
+	name    string
+	indexed bool
+	get     func(e *event.Event) string
+	set     func(e *event.Event, v string)
+}
+
+// wideColumns lists the typed columns, in table order, after event_id and ts.
+// Their values are removed from the JSONB payload and restored on read.
+var wideColumns = []wideColumn{
+	{"type", true, func(e *event.Event) string { return e.Type }, func(e *event.Event, v string) { e.Type = v }},
+	{"visitor_id", true, func(e *event.Event) string { return e.Session.VisitorID }, func(e *event.Event, v string) { e.Session.VisitorID = v }},
+	{"session_id", true, func(e *event.Event) string { return e.Session.SessionID }, func(e *event.Event, v string) { e.Session.SessionID = v }},
+	{"utm_source", false, func(e *event.Event) string { return e.URL.UTM.Source }, func(e *event.Event, v string) { e.URL.UTM.Source = v }},
+	{"utm_medium", false, func(e *event.Event) string { return e.URL.UTM.Medium }, func(e *event.Event, v string) { e.URL.UTM.Medium = v }},
+	{"utm_campaign", true, func(e *event.Event) string { return e.URL.UTM.Campaign }, func(e *event.Event, v string) { e.URL.UTM.Campaign = v }},
+	{"utm_term", false, func(e *event.Event) string { return e.URL.UTM.Term }, func(e *event.Event, v string) { e.URL.UTM.Term = v }},
+	{"utm_content", false, func(e *event.Event) string { return e.URL.UTM.Content }, func(e *event.Event, v string) { e.URL.UTM.Content = v }},
+	{"gclid", true, func(e *event.Event) string { return e.URL.Google.GCLID }, func(e *event.Event, v string) { e.URL.Google.GCLID = v }},
+	{"fbclid", true, func(e *event.Event) string { return e.URL.Meta.FBCLID }, func(e *event.Event, v string) { e.URL.Meta.FBCLID = v }},
+	{"ip", false, func(e *event.Event) string { return e.Server.IP }, func(e *event.Event, v string) { e.Server.IP = v }},
+	{"ua", false, func(e *event.Event) string { return e.Device.UA }, func(e *event.Event, v string) { e.Device.UA = v }},
+}
+
+// wide reports whether the sink uses the wide schema
+func (s *PGSink) wide() bool {
+	return s.config.Schema == SchemaWide
+}
+
+// columnDefinitions returns the data columns of the table, without keys
+func (s *PGSink) columnDefinitions() string {
+	defs := []string{"event_id UUID NOT NULL", "ts TIMESTAMPTZ NOT NULL DEFAULT now()"}
+	if s.wide() {
+		for _, c := range wideColumns {
+			defs = append(defs, c.name+" TEXT")
+		}
+	}
+	defs = append(defs, "payload JSONB NOT NULL")
+	return strings.Join(defs, ",\n\t\t\t")
+}
+
+// insertColumns returns the columns written for each event
+func (s *PGSink) insertColumns() []string {
+	cols := []string{"event_id", "ts"}
+	if s.wide() {
+		for _, c := range wideColumns {
+			cols = append(cols, c.name)
+		}
+	}
+	return append(cols, "payload")
+}
+
+// rowValues returns the values for insertColumns. In wide mode mapped fields
+// go to their columns (NULL when empty) and are left out of the payload.
+func (s *PGSink) rowValues(e event.Event) ([]interface{}, error) {
+	values := []interface{}{e.EventID, eventTime(e)}
+	if s.wide() {
+		for _, c := range wideColumns {
+			if v := c.get(&e); v != "" {
+				values = append(values, v)
+			} else {
+				values = append(values, nil)
+			}
+			c.set(&e, "")
+		}
+	}
+	buf := serde.GetBuffer()
+	defer serde.PutBuffer(buf)
+	payload, err := serde.AppendJSON(*buf, &e)
+	if err != nil {
+		return nil, err
+	}
+	*buf = payload
+	return append(values, string(payload)), nil
+}
+
+// wideIndexes returns the column indexes for the wide schema
+func (s *PGSink) wideIndexes() []string {
+	var indexes []string
+	for _, c := range wideColumns {
+		if c.indexed {
+			indexes = append(indexes, fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_%s ON %s (%s) WHERE %s IS NOT NULL",
+				s.config.Table, c.name, s.config.Table, c.name, c.name))
+		}
+	}
+	return indexes
+}
+
+// restoreWide rebuilds the full event JSON from the mapped columns and the
+// remainder payload
+func restoreWide(values []*string, payload []byte) (json.RawMessage, error) {
+	var e event.Event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, err
+	}
+	for i, c := range wideColumns {
+		if values[i] != nil {
+			c.set(&e, *values[i])
+		}
+	}
+	return json.Marshal(e)
+}
@@ -0,0 +1,208 @@
+package sink
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func wideTestEvent() event.Event {
+	e := event.Event{
+		EventID: "123e4567-e89b-12d3-a456-426614174000",
+		TS:      "2026-10-17T12:00:00Z",
+		Type:    "pageview",
+		Session: event.SessionInfo{VisitorID: "vis-1", SessionID: "ses-1"},
+		Server:  event.ServerMeta{IP: "203.0.113.7"},
+	}
+	e.URL.UTM.Campaign = "spring"
+	e.URL.Google.GCLID = "G-1"
+	e.URL.Microsoft.MSCLKID = "M-1"
+	e.Device.UA = "Mozilla/5.0"
+	e.Route.Path = "/pricing"
+	return e
+}
+
+func TestValidateSchemaMode(t *testing.T) {
+	for _, mode := range []string{"", SchemaJSON, SchemaWide} {
+		if err := validateSchemaMode(mode); err != nil {
+			t.Errorf("validateSchemaMode(%q) = %v", mode, err)
+		}
+	}
+	if err := validateSchemaMode("columnar"); err == nil {
+		t.Error("validateSchemaMode(columnar) should fail")
+	}
+}
+
+func TestPGSink_RowValues_Wide(t *testing.T) {
+	sink := &PGSink{config: PGConfig{Schema: SchemaWide}}
+	columns := sink.insertColumns()
+	values, err := sink.rowValues(wideTestEvent())
+	if err != nil {
+		t.Fatalf("rowValues failed: %v", err)
+	}
+	if len(values) != len(columns) {
+		t.Fatalf("got %d values for %d columns", len(values), len(columns))
+	}
+
+	byColumn := map[string]interface{}{}
+	for i, c := range columns {
+		byColumn[c] = values[i]
+	}
+	if byColumn["gclid"] != "G-1" || byColumn["visitor_id"] != "vis-1" || byColumn["ua"] != "Mozilla/5.0" {
+		t.Errorf("mapped columns = %v", byColumn)
+	}
+	if byColumn["fbclid"] != nil || byColumn["utm_source"] != nil {
+		t.Error("empty fields should be NULL")
+	}
+
+	payload := byColumn["payload"].(string)
+	for _, moved := range []string{"G-1", "vis-1", "203.0.113.7", "spring", "Mozilla"} {
+		if strings.Contains(payload, moved) {
+			t.Errorf("payload should not repeat mapped value %q: %s", moved, payload)
+		}
+	}
+	if !strings.Contains(payload, "M-1") || !strings.Contains(payload, "/pricing") {
+		t.Errorf("payload should keep unmapped fields: %s", payload)
+	}
+}
+
+func BenchmarkPGSinkRowValues(b *testing.B) {
+	for _, schema := range []string{SchemaJSON, SchemaWide} {
+		b.Run(schema, func(b *testing.B) {
+			sink := &PGSink{config: PGConfig{Schema: schema}}
+			e := wideTestEvent()
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := sink.rowValues(e); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreWide(t *testing.T) {
+	sink := &PGSink{config: PGConfig{Schema: SchemaWide}}
+	original := wideTestEvent()
+	values, _ := sink.rowValues(original)
+
+	// Columns come back from the database as nullable text
+	mapped := make([]*string, len(wideColumns))
+	for i := range wideColumns {
+		if v, ok := values[2+i].(string); ok {
+			mapped[i] = &v
+		}
+	}
+	restored, err := restoreWide(mapped, []byte(values[len(values)-1].(string)))
+	if err != nil {
+		t.Fatalf("restoreWide failed: %v", err)
+	}
+	want, _ := json.Marshal(original)
+	if string(restored) != string(want) {
+		t.Errorf("restored event =\n%s\nwant\n%s", restored, want)
+	}
+}
+
+func TestPGSink_EnsureSchema_Wide(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{config: PGConfig{Table: "test_events", Schema: SchemaWide}, db: db, ctx: context.Background()}
+
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS test_events \(.*visitor_id TEXT.*gclid TEXT.*payload JSONB NOT NULL`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS idx_test_events_ts").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS idx_test_events_gin").WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, c := range []string{"type", "visitor_id", "session_id", "utm_campaign", "gclid", "fbclid"} {
+		mock.ExpectExec(regexp.QuoteMeta("CREATE INDEX IF NOT EXISTS idx_test_events_" + c + " ON test_events (" + c + ")")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	// msclkid stays in the payload and keeps its expression index
+	mock.ExpectExec(regexp.QuoteMeta("CREATE INDEX IF NOT EXISTS idx_test_events_msclkid ON test_events ((payload->")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := sink.ensureSchema(); err != nil {
+		t.Fatalf("ensureSchema failed: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestPGSink_FlushWithInsert_Wide(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{
+		config: PGConfig{Table: "events_json", Schema: SchemaWide},
+		db:     db,
+		ctx:    context.Background(),
+		batch:  []event.Event{wideTestEvent(), wideTestEvent()},
+	}
+	n := len(sink.insertColumns())
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO events_json (event_id, ts, type, visitor_id")).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	if err := sink.flushWithInsert(); err != nil {
+		t.Fatalf("flushWithInsert failed: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+	if n != 2+len(wideColumns)+1 {
+		t.Errorf("insertColumns has %d columns", n)
+	}
+}
+
+func TestPGSinkFindEvents_Wide(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	sink := &PGSink{config: PGConfig{Table: "test_events", Schema: SchemaWide}, db: db}
+
+	columns := sink.insertColumns()[2:]
+	row := make([]driver.Value, len(columns))
+	for i, c := range columns {
+		switch c {
+		case "gclid":
+			row[i] = "G-1"
+		case "type":
+			row[i] = "pageview"
+		case "payload":
+			row[i] = []byte(`{"event_id":"a","route":{"path":"/pricing"}}`)
+		}
+	}
+	mock.ExpectQuery(`SELECT type, visitor_id, .*, payload FROM test_events WHERE gclid = \$1`).
+		WithArgs("G-1", 5).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(row...))
+
+	events, err := sink.FindEvents(context.Background(), "gclid", "G-1", 5)
+	if err != nil {
+		t.Fatalf("FindEvents failed: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	var e event.Event
+	if err := json.Unmarshal(events[0], &e); err != nil {
+		t.Fatalf("invalid event JSON: %v", err)
+	}
+	if e.EventID != "a" || e.Type != "pageview" || e.URL.Google.GCLID != "G-1" || e.Route.Path != "/pricing" {
+		t.Errorf("restored event = %s", events[0])
+	}
+}
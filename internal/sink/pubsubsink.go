@@ -0,0 +1,187 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/pubsub/v2"
+	"github.com/shortontech/gotrack/internal/serde"
+	"github.com/shortontech/gotrack/pkg/event"
+	"google.golang.org/api/option"
+)
+
+// Pub/Sub ordering key strategies (PUBSUB_ORDERING_KEY)
+const (
+	PubSubOrderNone      = "none"
+	PubSubOrderVisitorID = "visitor_id"
+	PubSubOrderSessionID = "session_id"
+)
+
+// PubSubConfig holds configuration for the Pub/Sub publisher
+type PubSubConfig struct {
+	ProjectID      string
+	Topic          string // topic ID, or a full projects/<p>/topics/<t> name
+	OrderingKey    string // none, visitor_id or session_id
+	BatchSize      int    // messages per publish request
+	FlushMS        int    // longest a message waits for its batch to fill
+	MaxOutstanding int    // unacknowledged messages before Enqueue fails
+
+	// ClientOptions are passed to the Pub/Sub client; tests point it at a fake server
+	ClientOptions []option.ClientOption
+}
+
+// PubSubSink publishes events as JSON to a Google Cloud Pub/Sub topic. The
+// client library batches messages and enforces MaxOutstanding; credentials
+// come from Application Default Credentials (GOOGLE_APPLICATION_CREDENTIALS,
+// workload identity or the metadata server), and PUBSUB_EMULATOR_HOST is
+// honored. With an ordering key, events of one visitor or session are
+// delivered in order.
+type PubSubSink struct {
+	config    PubSubConfig
+	client    *pubsub.Client
+	publisher *pubsub.Publisher
+
+	inflight  atomic.Int64
+	lastFlush atomic.Int64 // publish latency of the last acknowledged message in nanoseconds
+	wg        sync.WaitGroup
+}
+
+// NewPubSubSinkFromEnv creates a PubSubSink from environment variables
+func NewPubSubSinkFromEnv() *PubSubSink {
+	project := os.Getenv("PUBSUB_PROJECT_ID")
+	if project == "" {
+		project = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	return NewPubSubSink(PubSubConfig{
+		ProjectID:      project,
+		Topic:          getEnvOr("PUBSUB_TOPIC", "gotrack-events"),
+		OrderingKey:    getEnvOr("PUBSUB_ORDERING_KEY", PubSubOrderVisitorID),
+		BatchSize:      getIntEnv("PUBSUB_BATCH_SIZE", 100),
+		FlushMS:        getIntEnv("PUBSUB_FLUSH_MS", 10),
+		MaxOutstanding: getIntEnv("PUBSUB_MAX_OUTSTANDING", 10000),
+	})
+}
+
+// NewPubSubSink creates a PubSubSink with explicit configuration
+func NewPubSubSink(config PubSubConfig) *PubSubSink {
+	return &PubSubSink{config: config}
+}
+
+func (s *PubSubSink) Start(ctx context.Context) error {
+	if s.config.ProjectID == "" {
+		return fmt.Errorf("PUBSUB_PROJECT_ID or GOOGLE_CLOUD_PROJECT is required for the pubsub sink")
+	}
+	switch s.config.OrderingKey {
+	case PubSubOrderNone, PubSubOrderVisitorID, PubSubOrderSessionID:
+	default:
+		return fmt.Errorf("unknown PUBSUB_ORDERING_KEY %q (want none, visitor_id or session_id)", s.config.OrderingKey)
+	}
+
+	client, err := pubsub.NewClient(ctx, s.config.ProjectID, s.config.ClientOptions...)
+	if err != nil {
+		return fmt.Errorf("failed to create pubsub client: %w", err)
+	}
+	publisher := client.Publisher(s.config.Topic)
+	publisher.EnableMessageOrdering = s.config.OrderingKey != PubSubOrderNone
+
+	settings := pubsub.DefaultPublishSettings
+	if s.config.BatchSize > 0 {
+		settings.CountThreshold = min(s.config.BatchSize, pubsub.MaxPublishRequestCount)
+	}
+	if s.config.FlushMS > 0 {
+		settings.DelayThreshold = time.Duration(s.config.FlushMS) * time.Millisecond
+	}
+	if s.config.MaxOutstanding > 0 {
+		// Enqueue fails before this limit; the library's flow control is a backstop
+		// that also fails rather than block the HTTP path
+		settings.FlowControlSettings.MaxOutstandingMessages = s.config.MaxOutstanding
+		settings.FlowControlSettings.LimitExceededBehavior = pubsub.FlowControlSignalError
+	}
+	publisher.PublishSettings = settings
+
+	s.client, s.publisher = client, publisher
+	return nil
+}
+
+func (s *PubSubSink) Enqueue(e event.Event) error {
+	data, err := serde.MarshalEvent(&e)
+	if err != nil {
+		return err
+	}
+	key := s.orderingKey(e)
+	msg := &pubsub.Message{
+		Data:        data,
+		OrderingKey: key,
+		Attributes:  map[string]string{"event_id": e.EventID, "type": e.Type},
+	}
+	if e.SiteID != "" {
+		msg.Attributes["site_id"] = e.SiteID
+	}
+
+	if s.config.MaxOutstanding > 0 && s.inflight.Load() >= int64(s.config.MaxOutstanding) {
+		return fmt.Errorf("pubsub outstanding limit reached (%d messages)", s.config.MaxOutstanding)
+	}
+	start := time.Now()
+	s.inflight.Add(1)
+	s.wg.Add(1)
+	result := s.publisher.Publish(context.Background(), msg)
+	go func() {
+		defer s.wg.Done()
+		defer s.inflight.Add(-1)
+		if _, err := result.Get(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "pubsub publish error for event %s: %v\n", e.EventID, err)
+			if key != "" {
+				// A failed ordered publish pauses its key until resumed
+				s.publisher.ResumePublish(key)
+			}
+			return
+		}
+		s.lastFlush.Store(int64(time.Since(start)))
+	}()
+	return nil
+}
+
+// orderingKey returns the key that orders e among its visitor's or session's
+// events; empty publishes it unordered
+func (s *PubSubSink) orderingKey(e event.Event) string {
+	switch s.config.OrderingKey {
+	case PubSubOrderVisitorID:
+		return e.Session.VisitorID
+	case PubSubOrderSessionID:
+		return e.Session.SessionID
+	}
+	return ""
+}
+
+func (s *PubSubSink) Close() error {
+	if s.publisher == nil {
+		return nil // never started
+	}
+	s.publisher.Stop() // publishes what is buffered
+	s.wg.Wait()
+	return s.client.Close()
+}
+
+func (s *PubSubSink) Name() string {
+	return "pubsub"
+}
+
+// Flush publishes buffered messages now, blocking until Pub/Sub has answered
+// for all of them
+func (s *PubSubSink) Flush(ctx context.Context) (int, error) {
+	if s.publisher == nil {
+		return 0, fmt.Errorf("pubsub sink not started")
+	}
+	pending := int(s.inflight.Load())
+	s.publisher.Flush()
+	return pending, nil
+}
+
+// Load reports unacknowledged messages and the latest publish latency
+func (s *PubSubSink) Load() (int, time.Duration) {
+	return int(s.inflight.Load()), time.Duration(s.lastFlush.Load())
+}
@@ -0,0 +1,110 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"cloud.google.com/go/pubsub/v2/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/v2/pstest"
+	"github.com/shortontech/gotrack/pkg/event"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// newFakePubSub starts an in-process Pub/Sub server with topic projects/p/topics/t
+func newFakePubSub(t *testing.T) (*pstest.Server, []option.ClientOption) {
+	t.Helper()
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	if _, err := srv.GServer.CreateTopic(context.Background(), &pubsubpb.Topic{Name: "projects/p/topics/t"}); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, []option.ClientOption{option.WithGRPCConn(conn)}
+}
+
+func TestPubSubSinkDelivery(t *testing.T) {
+	srv, opts := newFakePubSub(t)
+	s := NewPubSubSink(PubSubConfig{ProjectID: "p", Topic: "t", OrderingKey: PubSubOrderVisitorID, BatchSize: 10, FlushMS: 5, ClientOptions: opts})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	e := event.Event{EventID: "ev-1", Type: "pageview", SiteID: "shop"}
+	e.Session.VisitorID = "v-1"
+	if err := s.Enqueue(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Enqueue(event.Event{EventID: "ev-2", Type: "click"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := srv.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("published %d messages, want 2", len(msgs))
+	}
+	byID := map[string]*pstest.Message{}
+	for _, m := range msgs {
+		byID[m.Attributes["event_id"]] = m
+	}
+	first := byID["ev-1"]
+	if first == nil || first.OrderingKey != "v-1" || first.Attributes["type"] != "pageview" || first.Attributes["site_id"] != "shop" {
+		t.Fatalf("message = %+v", first)
+	}
+	var got event.Event
+	if err := json.Unmarshal(first.Data, &got); err != nil || got.EventID != "ev-1" {
+		t.Errorf("data = %s (%v)", first.Data, err)
+	}
+	if second := byID["ev-2"]; second == nil || second.OrderingKey != "" {
+		t.Errorf("event without a visitor should be unordered: %+v", second)
+	}
+	if pending, _ := s.Load(); pending != 0 {
+		t.Errorf("Load = %d pending after Close", pending)
+	}
+}
+
+func TestPubSubSinkConfig(t *testing.T) {
+	for _, config := range []PubSubConfig{
+		{Topic: "t", OrderingKey: PubSubOrderNone},
+		{ProjectID: "p", Topic: "t", OrderingKey: "user_id"},
+	} {
+		if err := NewPubSubSink(config).Start(context.Background()); err == nil {
+			t.Errorf("Start(%+v) should fail", config)
+		}
+	}
+
+	t.Setenv("PUBSUB_PROJECT_ID", "")
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "from-gcloud")
+	if s := NewPubSubSinkFromEnv(); s.config.ProjectID != "from-gcloud" || s.config.OrderingKey != PubSubOrderVisitorID {
+		t.Errorf("config = %+v", s.config)
+	}
+}
+
+func TestPubSubSinkOutstandingLimit(t *testing.T) {
+	_, opts := newFakePubSub(t)
+	s := NewPubSubSink(PubSubConfig{ProjectID: "p", Topic: "t", OrderingKey: PubSubOrderNone, MaxOutstanding: 1, ClientOptions: opts})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.inflight.Store(1) // one message awaiting its ack
+	if err := s.Enqueue(event.Event{EventID: "ev-1"}); err == nil {
+		t.Error("expected error at the outstanding limit")
+	}
+	s.inflight.Store(0)
+	if err := s.Enqueue(event.Event{EventID: "ev-2"}); err != nil {
+		t.Errorf("Enqueue under the limit = %v", err)
+	}
+}
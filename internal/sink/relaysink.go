@@ -0,0 +1,417 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shortontech/gotrack/internal/metrics"
+	"github.com/shortontech/gotrack/internal/relay"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// RelayConfig holds configuration for forwarding events to a central GoTrack
+type RelayConfig struct {
+	URL         string // central endpoint, e.g. https://central.example.com/relay/batch
+	Token       string // shared bearer token expected by the central instance
+	Compression string // identity, gzip or zstd
+	BatchSize   int
+	FlushMS     int
+	ChunkBytes  int // maximum compressed bytes per HTTP request
+	MaxAttempts int // attempts per batch before events are re-queued
+	MaxPending  int // upper bound on buffered events while central is unreachable
+}
+
+// RelaySink batches events, compresses them and ships them to another
+// GoTrack instance in resumable, checksummed chunks
+type RelaySink struct {
+	config RelayConfig
+	client *http.Client
+
+	batch      []event.Event
+	batchMutex sync.Mutex
+	sendMutex  sync.Mutex
+	kick       chan struct{} // signals the flush routine that a batch is full
+	lastFlush  atomic.Int64  // duration of the last flush in nanoseconds
+	oldest     time.Time     // when batch[0] was enqueued
+	sending    time.Time     // when the oldest event being flushed was enqueued
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+
+	// Metrics receives the size and latency of each flush; optional
+	Metrics *metrics.Metrics
+}
+
+// NewRelaySinkFromEnv creates a RelaySink from environment variables
+func NewRelaySinkFromEnv() *RelaySink {
+	config := RelayConfig{
+		URL:         os.Getenv("RELAY_URL"),
+		Token:       os.Getenv("RELAY_TOKEN"),
+		Compression: getEnvOr("RELAY_COMPRESSION", relay.CompressionGzip),
+		BatchSize:   getIntEnv("RELAY_BATCH_SIZE", 500),
+		FlushMS:     getIntEnv("RELAY_FLUSH_MS", 1000),
+		ChunkBytes:  getIntEnv("RELAY_CHUNK_BYTES", 256*1024),
+		MaxAttempts: getIntEnv("RELAY_MAX_ATTEMPTS", 5),
+		MaxPending:  getIntEnv("RELAY_MAX_PENDING", 50000),
+	}
+	return NewRelaySink(config)
+}
+
+// NewRelaySink creates a RelaySink with explicit configuration
+func NewRelaySink(config RelayConfig) *RelaySink {
+	return &RelaySink{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+		kick:   make(chan struct{}, 1),
+	}
+}
+
+func (s *RelaySink) Start(ctx context.Context) error {
+	if s.config.URL == "" {
+		return fmt.Errorf("RELAY_URL is required for the relay sink")
+	}
+	if _, err := url.ParseRequestURI(s.config.URL); err != nil {
+		return fmt.Errorf("invalid RELAY_URL: %w", err)
+	}
+	if !relay.ValidCompression(s.config.Compression) {
+		return fmt.Errorf("unsupported RELAY_COMPRESSION: %s", s.config.Compression)
+	}
+	if s.config.BatchSize <= 0 {
+		s.config.BatchSize = 500
+	}
+	if s.config.FlushMS <= 0 {
+		s.config.FlushMS = 1000
+	}
+	if s.config.MaxAttempts <= 0 {
+		s.config.MaxAttempts = 1
+	}
+
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	s.batch = make([]event.Event, 0, s.config.BatchSize)
+
+	go s.flushRoutine()
+	return nil
+}
+
+func (s *RelaySink) Enqueue(e event.Event) error {
+	s.batchMutex.Lock()
+	if s.config.MaxPending > 0 && len(s.batch) >= s.config.MaxPending {
+		s.batchMutex.Unlock()
+		return fmt.Errorf("relay buffer full (%d events)", len(s.batch))
+	}
+	if len(s.batch) == 0 {
+		s.oldest = time.Now()
+	}
+	s.batch = append(s.batch, e)
+	full := len(s.batch) >= s.config.BatchSize
+	s.batchMutex.Unlock()
+
+	if full {
+		select {
+		case s.kick <- struct{}{}:
+		default: // flush already pending
+		}
+	}
+	return nil
+}
+
+func (s *RelaySink) Close() error {
+	if s.cancel == nil {
+		return nil // never started
+	}
+	s.cancel()
+	<-s.done
+
+	// Final best-effort flush with a bounded deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := s.flush(ctx)
+	return err
+}
+
+func (s *RelaySink) Name() string {
+	return "relay"
+}
+
+// Flush ships the buffer now rather than at the next flush tick
+func (s *RelaySink) Flush(ctx context.Context) (int, error) {
+	if s.cancel == nil {
+		return 0, fmt.Errorf("relay sink not started")
+	}
+	return s.flush(ctx)
+}
+
+// Ping reports the sink unhealthy while its buffer is full, which means the
+// central instance has been unreachable long enough to start dropping events
+func (s *RelaySink) Ping(ctx context.Context) error {
+	if s.cancel == nil {
+		return fmt.Errorf("relay sink not started")
+	}
+	s.batchMutex.Lock()
+	defer s.batchMutex.Unlock()
+	if s.config.MaxPending > 0 && len(s.batch) >= s.config.MaxPending {
+		return fmt.Errorf("relay buffer full (%d events)", len(s.batch))
+	}
+	return nil
+}
+
+// Reload re-reads the RELAY_* batching settings. The destination, token and
+// compression are fixed for the lifetime of the sink.
+func (s *RelaySink) Reload() error {
+	s.batchMutex.Lock()
+	defer s.batchMutex.Unlock()
+
+	if n := getIntEnv("RELAY_BATCH_SIZE", s.config.BatchSize); n > 0 {
+		s.config.BatchSize = n
+	}
+	if n := getIntEnv("RELAY_FLUSH_MS", s.config.FlushMS); n > 0 {
+		s.config.FlushMS = n
+	}
+	if n := getIntEnv("RELAY_CHUNK_BYTES", s.config.ChunkBytes); n > 0 {
+		s.config.ChunkBytes = n
+	}
+	if n := getIntEnv("RELAY_MAX_ATTEMPTS", s.config.MaxAttempts); n > 0 {
+		s.config.MaxAttempts = n
+	}
+	if n := getIntEnv("RELAY_MAX_PENDING", s.config.MaxPending); n > 0 {
+		s.config.MaxPending = n
+	}
+	return nil
+}
+
+// Load reports buffered events and the last flush duration
+func (s *RelaySink) Load() (int, time.Duration) {
+	s.batchMutex.Lock()
+	defer s.batchMutex.Unlock()
+	return len(s.batch), time.Duration(s.lastFlush.Load())
+}
+
+// currentConfig returns a snapshot of the (reloadable) configuration
+func (s *RelaySink) currentConfig() RelayConfig {
+	s.batchMutex.Lock()
+	defer s.batchMutex.Unlock()
+	return s.config
+}
+
+// flushRoutine ships the buffer on a fixed interval
+func (s *RelaySink) flushRoutine() {
+	defer close(s.done)
+
+	interval := time.Duration(s.currentConfig().FlushMS) * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = s.flush(s.ctx) // Error logged within flush
+		case <-s.kick:
+			_, _ = s.flush(s.ctx)
+		}
+
+		// Pick up a reloaded flush interval
+		if current := time.Duration(s.currentConfig().FlushMS) * time.Millisecond; current != interval {
+			interval = current
+			ticker.Reset(interval)
+		}
+	}
+}
+
+// flush takes the current buffer and ships it as a single batch, returning
+// how many events were delivered. Events that could not be delivered are put
+// back at the front of the buffer.
+func (s *RelaySink) flush(ctx context.Context) (int, error) {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	s.batchMutex.Lock()
+	if len(s.batch) == 0 {
+		s.batchMutex.Unlock()
+		return 0, nil
+	}
+	pending := s.batch
+	cfg := s.config
+	s.batch = make([]event.Event, 0, cfg.BatchSize)
+	s.sending, s.oldest = s.oldest, time.Time{}
+	s.batchMutex.Unlock()
+
+	start := time.Now()
+	defer func() {
+		s.batchMutex.Lock()
+		s.sending = time.Time{}
+		s.batchMutex.Unlock()
+
+		elapsed := time.Since(start)
+		s.lastFlush.Store(int64(elapsed))
+		if s.Metrics != nil {
+			s.Metrics.ObserveSinkFlush(s.Name(), len(pending), elapsed)
+		}
+	}()
+
+	for start := 0; start < len(pending); start += cfg.BatchSize {
+		end := start + cfg.BatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		if err := s.sendBatch(ctx, cfg, pending[start:end]); err != nil {
+			fmt.Fprintf(os.Stderr, "Relay flush error: %v\n", err)
+			s.requeue(pending[start:])
+			return start, err
+		}
+	}
+	return len(pending), nil
+}
+
+// OldestPending reports when the oldest buffered or in-flight event was enqueued
+func (s *RelaySink) OldestPending() time.Time {
+	s.batchMutex.Lock()
+	defer s.batchMutex.Unlock()
+	return earliest(s.oldest, s.sending)
+}
+
+// requeue returns undelivered events to the front of the buffer
+func (s *RelaySink) requeue(events []event.Event) {
+	s.batchMutex.Lock()
+	defer s.batchMutex.Unlock()
+
+	merged := make([]event.Event, 0, len(events)+len(s.batch))
+	merged = append(merged, events...)
+	merged = append(merged, s.batch...)
+	s.oldest = s.sending
+	if s.config.MaxPending > 0 && len(merged) > s.config.MaxPending {
+		dropped := len(merged) - s.config.MaxPending
+		fmt.Fprintf(os.Stderr, "Relay buffer full, dropping %d oldest events\n", dropped)
+		merged = merged[dropped:]
+	}
+	s.batch = merged
+}
+
+// sendBatch encodes, chunks and transmits one batch, resuming from the
+// receiver's acknowledged chunks on each retry
+func (s *RelaySink) sendBatch(ctx context.Context, cfg RelayConfig, events []event.Event) error {
+	payload, err := relay.Encode(events, cfg.Compression)
+	if err != nil {
+		return err
+	}
+
+	batchID := uuid.New().String()
+	batchSum := relay.Checksum(payload)
+	chunks := relay.Split(payload, cfg.ChunkBytes)
+
+	var lastErr error
+	for attempt := 0; attempt < cfg.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(100*(1<<uint(attempt-1))) * time.Millisecond
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+
+		received := map[int]bool{}
+		if attempt > 0 {
+			status, err := s.fetchStatus(ctx, batchID)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if status.Complete {
+				return nil
+			}
+			for _, idx := range status.Received {
+				received[idx] = true
+			}
+		}
+
+		lastErr = s.sendChunks(ctx, batchID, batchSum, chunks, received)
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("batch %s failed after %d attempts: %w", batchID, cfg.MaxAttempts, lastErr)
+}
+
+func (s *RelaySink) sendChunks(ctx context.Context, batchID, batchSum string, chunks [][]byte, skip map[int]bool) error {
+	for i, chunk := range chunks {
+		if skip[i] {
+			continue
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.URL, bytes.NewReader(chunk))
+		if err != nil {
+			return fmt.Errorf("failed to create relay request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Encoding", s.config.Compression)
+		req.Header.Set(relay.HeaderBatchID, batchID)
+		req.Header.Set(relay.HeaderBatchSHA256, batchSum)
+		req.Header.Set(relay.HeaderChunkIndex, strconv.Itoa(i))
+		req.Header.Set(relay.HeaderChunkCount, strconv.Itoa(len(chunks)))
+		req.Header.Set(relay.HeaderChunkSHA256, relay.Checksum(chunk))
+		s.authorize(req)
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("chunk %d/%d: %w", i+1, len(chunks), err)
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+			return fmt.Errorf("chunk %d/%d rejected with status %d: %s", i+1, len(chunks), resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+	}
+	return nil
+}
+
+// fetchStatus asks the receiver which chunks of a batch it already holds
+func (s *RelaySink) fetchStatus(ctx context.Context, batchID string) (relay.Status, error) {
+	var status relay.Status
+
+	u, err := url.Parse(s.config.URL)
+	if err != nil {
+		return status, err
+	}
+	q := u.Query()
+	q.Set("batch_id", batchID)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return status, fmt.Errorf("failed to create status request: %w", err)
+	}
+	s.authorize(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return status, fmt.Errorf("status request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("status request returned %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, fmt.Errorf("invalid status response: %w", err)
+	}
+	return status, nil
+}
+
+func (s *RelaySink) authorize(req *http.Request) {
+	if s.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.Token)
+	}
+}
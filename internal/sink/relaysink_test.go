@@ -0,0 +1,290 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/relay"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// fakeRelayReceiver mimics the /relay/batch endpoint of a central instance
+type fakeRelayReceiver struct {
+	mu        sync.Mutex
+	assembler *relay.Assembler
+	events    []event.Event
+	posts     int
+	failPost  func(n int) bool // return true to reject the nth POST
+}
+
+func (f *fakeRelayReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == http.MethodGet {
+		_ = json.NewEncoder(w).Encode(f.assembler.Status(r.URL.Query().Get("batch_id")))
+		return
+	}
+
+	f.posts++
+	if f.failPost != nil && f.failPost(f.posts) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	data, _ := io.ReadAll(r.Body)
+	index, _ := strconv.Atoi(r.Header.Get(relay.HeaderChunkIndex))
+	count, _ := strconv.Atoi(r.Header.Get(relay.HeaderChunkCount))
+	payload, status, err := f.assembler.Add(relay.Chunk{
+		BatchID:     r.Header.Get(relay.HeaderBatchID),
+		BatchSHA256: r.Header.Get(relay.HeaderBatchSHA256),
+		Compression: r.Header.Get("Content-Encoding"),
+		Index:       index,
+		Count:       count,
+		SHA256:      r.Header.Get(relay.HeaderChunkSHA256),
+		Data:        data,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if payload != nil {
+		events, err := relay.Decode(payload, r.Header.Get("Content-Encoding"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.events = append(f.events, events...)
+	}
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+func (f *fakeRelayReceiver) received() ([]event.Event, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]event.Event(nil), f.events...), f.posts
+}
+
+func newTestRelaySink(url, compression string) *RelaySink {
+	return NewRelaySink(RelayConfig{
+		URL:         url,
+		Token:       "secret",
+		Compression: compression,
+		BatchSize:   100,
+		FlushMS:     60000, // flush manually in tests
+		ChunkBytes:  128,
+		MaxAttempts: 3,
+		MaxPending:  1000,
+	})
+}
+
+// TestRelaySinkDelivery tests compressed, chunked delivery to a central receiver
+func TestRelaySinkDelivery(t *testing.T) {
+	for _, compression := range []string{relay.CompressionGzip, relay.CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			receiver := &fakeRelayReceiver{assembler: relay.NewAssembler(time.Minute, 10)}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			s := newTestRelaySink(server.URL, compression)
+			if err := s.Start(context.Background()); err != nil {
+				t.Fatalf("Start() failed: %v", err)
+			}
+			for i := 0; i < 30; i++ {
+				_ = s.Enqueue(event.Event{EventID: "evt-" + strconv.Itoa(i), Type: "pageview"})
+			}
+			if err := s.Close(); err != nil {
+				t.Fatalf("Close() failed: %v", err)
+			}
+
+			events, posts := receiver.received()
+			if len(events) != 30 {
+				t.Errorf("expected 30 events at receiver, got %d", len(events))
+			}
+			if posts < 2 {
+				t.Errorf("expected batch to be split into multiple chunks, got %d posts", posts)
+			}
+		})
+	}
+}
+
+// TestRelaySinkResume tests that retries only resend chunks the receiver is missing
+func TestRelaySinkResume(t *testing.T) {
+	receiver := &fakeRelayReceiver{
+		assembler: relay.NewAssembler(time.Minute, 10),
+		failPost:  func(n int) bool { return n == 2 }, // drop the second chunk once
+	}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	s := newTestRelaySink(server.URL, relay.CompressionNone)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		_ = s.Enqueue(event.Event{EventID: "evt-" + strconv.Itoa(i)})
+	}
+	if _, err := s.flush(context.Background()); err != nil {
+		t.Fatalf("flush() failed: %v", err)
+	}
+	defer s.Close()
+
+	events, posts := receiver.received()
+	if len(events) != 10 {
+		t.Fatalf("expected 10 events, got %d", len(events))
+	}
+
+	// Chunks = first attempt up to failure (2 posts) + remaining chunks on resume
+	batch, _ := relay.Encode(events, relay.CompressionNone)
+	chunkCount := len(relay.Split(batch, 128))
+	if posts != chunkCount+1 {
+		t.Errorf("expected %d posts (one retried chunk), got %d", chunkCount+1, posts)
+	}
+}
+
+// TestRelaySinkRequeue tests that undeliverable events stay buffered
+func TestRelaySinkRequeue(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	s := newTestRelaySink(server.URL, relay.CompressionGzip)
+	s.config.MaxAttempts = 1
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	defer func() {
+		s.cancel()
+		<-s.done
+	}()
+
+	_ = s.Enqueue(event.Event{EventID: "evt-1"})
+	if _, err := s.flush(context.Background()); err == nil {
+		t.Fatal("expected flush to fail")
+	}
+
+	s.batchMutex.Lock()
+	pending := len(s.batch)
+	s.batchMutex.Unlock()
+	if pending != 1 {
+		t.Errorf("expected failed event to be re-queued, got %d pending", pending)
+	}
+}
+
+// TestRelaySinkStartValidation tests configuration validation
+func TestRelaySinkStartValidation(t *testing.T) {
+	if err := NewRelaySink(RelayConfig{}).Start(context.Background()); err == nil {
+		t.Error("expected error when URL is missing")
+	}
+	if err := NewRelaySink(RelayConfig{URL: "http://central/relay/batch", Compression: "lz4"}).Start(context.Background()); err == nil {
+		t.Error("expected error for unsupported compression")
+	}
+	if name := NewRelaySink(RelayConfig{}).Name(); name != "relay" {
+		t.Errorf("Name() = %q, want relay", name)
+	}
+}
+
+// TestRelaySinkPing tests that a full buffer marks the sink unhealthy
+func TestRelaySinkPing(t *testing.T) {
+	s := newTestRelaySink("http://central/relay/batch", relay.CompressionGzip)
+	if err := s.Ping(context.Background()); err == nil {
+		t.Error("Ping() before Start should fail")
+	}
+
+	s.config.MaxPending = 2
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.cancel()
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping() = %v", err)
+	}
+	_ = s.Enqueue(event.Event{EventID: "a"})
+	_ = s.Enqueue(event.Event{EventID: "b"})
+	if err := s.Ping(context.Background()); err == nil {
+		t.Error("Ping() should fail with a full buffer")
+	}
+}
+
+// TestRelaySinkFlush tests that Flush reports delivered and requeued events
+func TestRelaySinkFlush(t *testing.T) {
+	var down atomic.Bool
+	receiver := &fakeRelayReceiver{assembler: relay.NewAssembler(time.Minute, 10)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		receiver.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	s := newTestRelaySink(server.URL, relay.CompressionGzip)
+	if _, err := s.Flush(context.Background()); err == nil {
+		t.Error("Flush() before Start should fail")
+	}
+	s.config.MaxAttempts = 1
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	defer func() {
+		s.cancel()
+		<-s.done
+	}()
+
+	for i := 0; i < 3; i++ {
+		_ = s.Enqueue(event.Event{EventID: "evt-" + strconv.Itoa(i)})
+	}
+	if n, err := s.Flush(context.Background()); err != nil || n != 3 {
+		t.Errorf("Flush() = %d, %v, want 3, nil", n, err)
+	}
+
+	down.Store(true)
+	_ = s.Enqueue(event.Event{EventID: "evt-3"})
+	if n, err := s.Flush(context.Background()); err == nil || n != 0 {
+		t.Errorf("Flush() = %d, %v, want 0 and an error", n, err)
+	}
+	if depth, _ := s.Load(); depth != 1 {
+		t.Errorf("expected 1 event left buffered, got %d", depth)
+	}
+}
+
+// TestRelaySinkReload tests that batching settings can change without a restart
+func TestRelaySinkReload(t *testing.T) {
+	s := newTestRelaySink("http://central/relay/batch", relay.CompressionGzip)
+	t.Setenv("RELAY_BATCH_SIZE", "10")
+	t.Setenv("RELAY_CHUNK_BYTES", "4096")
+
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	cfg := s.currentConfig()
+	if cfg.BatchSize != 10 || cfg.ChunkBytes != 4096 {
+		t.Errorf("unexpected config after reload: %+v", cfg)
+	}
+	if cfg.URL != "http://central/relay/batch" || cfg.Token != "secret" {
+		t.Error("reload should not change the destination")
+	}
+}
+
+// TestRelaySinkLoad tests queue depth reporting
+func TestRelaySinkLoad(t *testing.T) {
+	s := newTestRelaySink("http://central/relay/batch", relay.CompressionGzip)
+	s.batch = append(s.batch, event.Event{EventID: "evt-1"}, event.Event{EventID: "evt-2"})
+
+	if depth, _ := s.Load(); depth != 2 {
+		t.Errorf("Load() depth = %d, want 2", depth)
+	}
+}
@@ -0,0 +1,137 @@
+package sink
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Errors returned by Set
+var (
+	ErrSinkExists     = errors.New("a sink with that name already exists")
+	ErrSinkNotFound   = errors.New("no sink with that name")
+	ErrSinkConfigured = errors.New("configured sinks can be paused but not removed")
+)
+
+// Set holds the sinks events are fanned out to, with a pause switch and
+// delivery counts per sink, so that an operator can stop writing to a sink
+// or attach one for debugging without restarting the server
+type Set struct {
+	mu      sync.RWMutex
+	members []*Member
+}
+
+// Member is a sink in a Set
+type Member struct {
+	Sink  Sink
+	Added bool // added at runtime rather than configured
+
+	paused    atomic.Bool
+	delivered atomic.Int64
+	failed    atomic.Int64
+	skipped   atomic.Int64
+}
+
+// MemberStats counts the events a member was handed since the server started
+type MemberStats struct {
+	Delivered int64 `json:"delivered"`
+	Failed    int64 `json:"failed"`
+	Skipped   int64 `json:"skipped"` // not sent because the sink was paused
+}
+
+// NewSet creates a set of the configured sinks
+func NewSet(sinks []Sink) *Set {
+	s := &Set{members: make([]*Member, len(sinks))}
+	for i, sink := range sinks {
+		s.members[i] = &Member{Sink: sink}
+	}
+	return s
+}
+
+// Members returns the current members in the order they were added
+func (s *Set) Members() []*Member {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.members
+}
+
+// Get returns the member named name, or nil
+func (s *Set) Get(name string) *Member {
+	for _, m := range s.Members() {
+		if m.Sink.Name() == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// Add appends a started sink to the set; it receives events from then on
+func (s *Set) Add(sink Sink) (*Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.members {
+		if m.Sink.Name() == sink.Name() {
+			return nil, ErrSinkExists
+		}
+	}
+	m := &Member{Sink: sink, Added: true}
+	// Copy, so that snapshots handed out by Members stay unchanged
+	s.members = append(s.members[:len(s.members):len(s.members)], m)
+	return m, nil
+}
+
+// Remove takes a sink added at runtime out of the set. The caller closes it.
+func (s *Set) Remove(name string) (*Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, m := range s.members {
+		if m.Sink.Name() != name {
+			continue
+		}
+		if !m.Added {
+			return nil, ErrSinkConfigured
+		}
+		m.Pause() // turns away events from fan-outs still holding a snapshot
+		members := make([]*Member, 0, len(s.members)-1)
+		s.members = append(append(members, s.members[:i]...), s.members[i+1:]...)
+		return m, nil
+	}
+	return nil, ErrSinkNotFound
+}
+
+// Added returns the sinks added at runtime
+func (s *Set) Added() []Sink {
+	var added []Sink
+	for _, m := range s.Members() {
+		if m.Added {
+			added = append(added, m.Sink)
+		}
+	}
+	return added
+}
+
+// Pause stops events from being sent to the sink
+func (m *Member) Pause() { m.paused.Store(true) }
+
+// Resume sends events to the sink again
+func (m *Member) Resume() { m.paused.Store(false) }
+
+// Paused reports whether the sink is paused
+func (m *Member) Paused() bool { return m.paused.Load() }
+
+// Record counts the outcome of handing the sink an event
+func (m *Member) Record(err error) {
+	if err != nil {
+		m.failed.Add(1)
+		return
+	}
+	m.delivered.Add(1)
+}
+
+// Skip counts an event not sent because the sink is paused
+func (m *Member) Skip() { m.skipped.Add(1) }
+
+// Stats returns the member's counts
+func (m *Member) Stats() MemberStats {
+	return MemberStats{Delivered: m.delivered.Load(), Failed: m.failed.Load(), Skipped: m.skipped.Load()}
+}
@@ -0,0 +1,47 @@
+package sink
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	set := NewSet([]Sink{NewNullSink()})
+	configured := set.Members()
+
+	debug := NewLogSinkWithConfig(LogConfig{Name: "debug", Path: "stdout"})
+	if _, err := set.Add(debug); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.Add(NewLogSinkWithConfig(LogConfig{Name: "debug", Path: "stdout"})); !errors.Is(err, ErrSinkExists) {
+		t.Errorf("second debug sink: err = %v, want ErrSinkExists", err)
+	}
+	if len(configured) != 1 || len(set.Members()) != 2 {
+		t.Errorf("snapshot has %d members, set %d", len(configured), len(set.Members()))
+	}
+	if added := set.Added(); len(added) != 1 || added[0] != debug {
+		t.Errorf("Added() = %v", added)
+	}
+
+	if _, err := set.Remove("null"); !errors.Is(err, ErrSinkConfigured) {
+		t.Errorf("removing a configured sink: err = %v", err)
+	}
+	m, err := set.Remove("debug")
+	if err != nil || m.Sink != debug || !m.Paused() {
+		t.Errorf("Remove = %+v, %v", m, err)
+	}
+	if _, err := set.Remove("debug"); !errors.Is(err, ErrSinkNotFound) {
+		t.Errorf("removing twice: err = %v", err)
+	}
+	if set.Get("debug") != nil || set.Get("null") == nil {
+		t.Error("Get does not reflect the removal")
+	}
+
+	null := set.Get("null")
+	null.Record(nil)
+	null.Record(errors.New("refused"))
+	null.Skip()
+	if stats := null.Stats(); stats != (MemberStats{Delivered: 1, Failed: 1, Skipped: 1}) {
+		t.Errorf("stats = %+v", stats)
+	}
+}
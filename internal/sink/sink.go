@@ -1,14 +1,61 @@
 package sink
 
 import (
-	"context"
+	"github.com/shortontech/gotrack/pkg/sink"
+)
 
-	"github.com/shortontech/gotrack/internal/event"
+// The sink contracts are public in pkg/sink; these aliases let the built-in
+// implementations and the server refer to them without a second import.
+type (
+	Sink            = sink.Sink
+	Reloadable      = sink.Reloadable
+	LoadReporter    = sink.LoadReporter
+	BacklogReporter = sink.BacklogReporter
+	HealthChecker   = sink.HealthChecker
+	ContextEnqueuer = sink.ContextEnqueuer
+	Flusher         = sink.Flusher
+	Querier         = sink.Querier
 )
 
-type Sink interface {
-	Start(ctx context.Context) error
-	Enqueue(e event.Event) error
-	Close() error
-	Name() string // Returns the sink name for metrics and logging
-}
+// Compile-time checks that the built-in sinks satisfy the public contracts
+var (
+	_ Sink         = (*LogSink)(nil)
+	_ Sink         = (*KafkaSink)(nil)
+	_ Sink         = (*PGSink)(nil)
+	_ Sink         = (*RelaySink)(nil)
+	_ Sink         = (*Forwarder)(nil)
+	_ Sink         = (*DatagramSink)(nil)
+	_ Sink         = (*PubSubSink)(nil)
+	_ Sink         = (*NullSink)(nil)
+	_ Sink         = (*ParquetSink)(nil)
+	_ Reloadable   = (*PGSink)(nil)
+	_ Reloadable   = (*RelaySink)(nil)
+	_ LoadReporter = (*PGSink)(nil)
+	_ LoadReporter = (*KafkaSink)(nil)
+	_ LoadReporter = (*RelaySink)(nil)
+	_ LoadReporter = (*Forwarder)(nil)
+	_ LoadReporter = (*PubSubSink)(nil)
+	_ Querier      = (*PGSink)(nil)
+
+	_ BacklogReporter = (*PGSink)(nil)
+	_ BacklogReporter = (*RelaySink)(nil)
+	_ BacklogReporter = (*Forwarder)(nil)
+
+	_ HealthChecker = (*LogSink)(nil)
+	_ HealthChecker = (*KafkaSink)(nil)
+	_ HealthChecker = (*PGSink)(nil)
+	_ HealthChecker = (*RelaySink)(nil)
+	_ HealthChecker = (*DatagramSink)(nil)
+	_ HealthChecker = (*ParquetSink)(nil)
+
+	_ ContextEnqueuer = (*KafkaSink)(nil)
+	_ ContextEnqueuer = (*PGSink)(nil)
+
+	_ Flusher = (*LogSink)(nil)
+	_ Flusher = (*KafkaSink)(nil)
+	_ Flusher = (*PGSink)(nil)
+	_ Flusher = (*RelaySink)(nil)
+	_ Flusher = (*Forwarder)(nil)
+	_ Flusher = (*PubSubSink)(nil)
+	_ Flusher = (*ParquetSink)(nil)
+)
@@ -0,0 +1,187 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shortontech/gotrack/internal/serde"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// syslogSeverityInfo is the severity of every event message
+const syslogSeverityInfo = 6
+
+// syslogFacilities maps facility names to their RFC 5424 codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// DatagramConfig holds the settings of the udp and syslog sinks
+type DatagramConfig struct {
+	Network      string        // udp or unixgram
+	Addr         string        // host:port, or a socket path for unixgram
+	Syslog       bool          // wrap each event in an RFC 5424 syslog message
+	Facility     int           // syslog facility code
+	Tag          string        // syslog APP-NAME
+	MaxBytes     int           // larger messages are dropped, not truncated
+	WriteTimeout time.Duration // bound on a write to a full local socket
+}
+
+// DatagramSink sends each event as one NDJSON datagram over UDP, or as one
+// syslog message, to a local agent such as Vector or Fluent Bit. Datagrams
+// are fire-and-forget: a slow or absent agent loses events instead of
+// holding up the HTTP path.
+type DatagramSink struct {
+	config   DatagramConfig
+	hostname string
+	pid      string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewUDPSinkFromEnv creates the udp sink from environment variables
+func NewUDPSinkFromEnv() *DatagramSink {
+	return NewDatagramSink(DatagramConfig{
+		Network:      "udp",
+		Addr:         getEnvOr("UDP_ADDR", "127.0.0.1:9000"),
+		MaxBytes:     getIntEnv("UDP_MAX_BYTES", 65000),
+		WriteTimeout: time.Duration(getIntEnv("UDP_WRITE_TIMEOUT_MS", 10)) * time.Millisecond,
+	})
+}
+
+// NewSyslogSinkFromEnv creates the syslog sink from environment variables.
+// An address starting with / is a local socket such as /dev/log.
+func NewSyslogSinkFromEnv() (*DatagramSink, error) {
+	facility, err := parseSyslogFacility(getEnvOr("SYSLOG_FACILITY", "local0"))
+	if err != nil {
+		return nil, err
+	}
+	addr := getEnvOr("SYSLOG_ADDR", "127.0.0.1:514")
+	network := "udp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unixgram"
+	}
+	return NewDatagramSink(DatagramConfig{
+		Network:      network,
+		Addr:         addr,
+		Syslog:       true,
+		Facility:     facility,
+		Tag:          getEnvOr("SYSLOG_TAG", "gotrack"),
+		MaxBytes:     getIntEnv("UDP_MAX_BYTES", 65000),
+		WriteTimeout: time.Duration(getIntEnv("UDP_WRITE_TIMEOUT_MS", 10)) * time.Millisecond,
+	}), nil
+}
+
+// NewDatagramSink creates a udp or syslog sink with explicit configuration
+func NewDatagramSink(config DatagramConfig) *DatagramSink {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &DatagramSink{config: config, hostname: hostname, pid: strconv.Itoa(os.Getpid())}
+}
+
+// parseSyslogFacility accepts a facility name such as local0 or its code
+func parseSyslogFacility(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if code, ok := syslogFacilities[s]; ok {
+		return code, nil
+	}
+	if code, err := strconv.Atoi(s); err == nil && code >= 0 && code <= 23 {
+		return code, nil
+	}
+	return 0, fmt.Errorf("invalid SYSLOG_FACILITY %q", s)
+}
+
+func (s *DatagramSink) Start(ctx context.Context) error {
+	// Datagram sockets do not handshake, so this only fails on a bad address
+	// or a missing local socket
+	conn, err := net.Dial(s.config.Network, s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("%s sink: %w", s.Name(), err)
+	}
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *DatagramSink) Enqueue(e event.Event) error {
+	buf := serde.GetBuffer()
+	defer serde.PutBuffer(buf)
+	msg, err := s.format(*buf, &e, time.Now())
+	if err != nil {
+		return err
+	}
+	*buf = msg
+	if s.config.MaxBytes > 0 && len(msg) > s.config.MaxBytes {
+		return fmt.Errorf("%s sink: event %s is %d bytes, over the %d byte limit", s.Name(), e.EventID, len(msg), s.config.MaxBytes)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return fmt.Errorf("%s sink not started", s.Name())
+	}
+	if s.config.WriteTimeout > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+	}
+	_, err = s.conn.Write(msg)
+	return err
+}
+
+// format appends an event to buf framed as an NDJSON line for udp, or as an
+// RFC 5424 message stamped with the send time and the JSON as MSG for syslog
+func (s *DatagramSink) format(buf []byte, e *event.Event, ts time.Time) ([]byte, error) {
+	if !s.config.Syslog {
+		b, err := serde.AppendJSON(buf, e)
+		return append(b, '\n'), err
+	}
+	pri := s.config.Facility*8 + syslogSeverityInfo
+	buf = fmt.Appendf(buf, "<%d>1 %s %s %s %s - - ", pri, ts.UTC().Format(time.RFC3339Nano), s.hostname, s.config.Tag, s.pid)
+	return serde.AppendJSON(buf, e)
+}
+
+func (s *DatagramSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *DatagramSink) Name() string {
+	if s.config.Syslog {
+		return "syslog"
+	}
+	return "udp"
+}
+
+// Ping reports whether the socket is open. Delivery over UDP cannot be
+// confirmed; a local unixgram socket that disappeared fails the check.
+func (s *DatagramSink) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return fmt.Errorf("%s sink not started", s.Name())
+	}
+	if s.config.Network == "unixgram" {
+		if _, err := os.Stat(s.config.Addr); err != nil {
+			return fmt.Errorf("syslog socket: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// readDatagram returns the next datagram received on conn
+func readDatagram(t *testing.T, conn net.PacketConn) string {
+	t.Helper()
+	buf := make([]byte, 65536)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no datagram received: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestUDPSinkDelivery(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	s := NewDatagramSink(DatagramConfig{Network: "udp", Addr: listener.LocalAddr().String(), MaxBytes: 1024})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Enqueue(event.Event{EventID: "ev-1", Type: "pageview"}); err != nil {
+		t.Fatal(err)
+	}
+	line := readDatagram(t, listener)
+	if !strings.HasSuffix(line, "\n") {
+		t.Errorf("datagram %q is not newline terminated", line)
+	}
+	var got event.Event
+	if err := json.Unmarshal([]byte(line), &got); err != nil || got.EventID != "ev-1" {
+		t.Errorf("datagram = %q (%v)", line, err)
+	}
+
+	big := event.Event{EventID: "ev-2", Props: map[string]string{"blob": strings.Repeat("x", 2048)}}
+	if err := s.Enqueue(big); err == nil {
+		t.Error("expected error for an event over MaxBytes")
+	}
+	if err := s.Ping(context.Background()); err != nil || s.Name() != "udp" {
+		t.Errorf("Ping = %v, Name = %q", err, s.Name())
+	}
+}
+
+func TestSyslogSinkFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	listener, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	defer listener.Close()
+
+	t.Setenv("SYSLOG_ADDR", path)
+	t.Setenv("SYSLOG_FACILITY", "local3")
+	t.Setenv("SYSLOG_TAG", "edge")
+	s, err := NewSyslogSinkFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Enqueue(event.Event{EventID: "ev-1"}); err != nil {
+		t.Fatal(err)
+	}
+	msg := readDatagram(t, listener)
+	// local3 (19) * 8 + info (6) = 158
+	header := regexp.MustCompile(`^<158>1 \S+Z \S+ edge \d+ - - `)
+	if loc := header.FindStringIndex(msg); loc == nil {
+		t.Fatalf("message %q has no RFC 5424 header", msg)
+	} else if !strings.HasPrefix(msg[loc[1]:], `{"event_id":"ev-1"`) {
+		t.Errorf("MSG = %q", msg[loc[1]:])
+	}
+	if s.Name() != "syslog" {
+		t.Errorf("Name = %q", s.Name())
+	}
+
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping = %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Ping(context.Background()); err == nil {
+		t.Error("expected Ping error once the socket is gone")
+	}
+}
+
+func TestParseSyslogFacility(t *testing.T) {
+	for in, want := range map[string]int{"local0": 16, "USER": 1, " daemon ": 3, "23": 23} {
+		if got, err := parseSyslogFacility(in); err != nil || got != want {
+			t.Errorf("parseSyslogFacility(%q) = %d, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"local8", "24", ""} {
+		if _, err := parseSyslogFacility(in); err == nil {
+			t.Errorf("parseSyslogFacility(%q) should fail", in)
+		}
+	}
+}
+
+func TestDatagramSinkNotStarted(t *testing.T) {
+	s := NewDatagramSink(DatagramConfig{Network: "udp", Addr: "127.0.0.1:9"})
+	if err := s.Enqueue(event.Event{}); err == nil {
+		t.Error("expected error before Start")
+	}
+	if err := s.Ping(context.Background()); err == nil {
+		t.Error("expected Ping error before Start")
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close before Start = %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package transform
+
+import (
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// functions compute derived fields; an empty result leaves the field alone
+var functions = map[string]func(event.Event) string{
+	"channel":      Channel,
+	"landing_path": LandingPath,
+}
+
+// Channel returns the marketing channel stored during enrichment, deriving
+// it for events that predate url.channel
+func Channel(e event.Event) string {
+	if e.URL.Channel != "" {
+		return e.URL.Channel
+	}
+	return event.Channel(e)
+}
+
+// LandingPath returns the route path of the first event in a session
+// (session_seq 1), so the landing page can be grouped on without a join.
+// Later events of the session are left unchanged.
+func LandingPath(e event.Event) string {
+	if e.Session.SessionSeq != 1 {
+		return ""
+	}
+	return e.Route.Path
+}
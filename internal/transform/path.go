@@ -0,0 +1,119 @@
+package transform
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// target is a resolved JSON path: a struct field, or a key of a string map
+// field such as props.plan or route.query.ref
+type target struct {
+	index []int  // struct field index path from the event
+	key   string // map key when the field is a map[string]string
+	isKey bool
+}
+
+// resolveAll resolves several paths
+func resolveAll(paths []string, stringOnly bool) ([]target, error) {
+	targets := make([]target, len(paths))
+	for i, path := range paths {
+		t, err := resolve(path, stringOnly)
+		if err != nil {
+			return nil, err
+		}
+		targets[i] = t
+	}
+	return targets, nil
+}
+
+// resolve maps a JSON path onto the event. With stringOnly, the path must
+// name a string field or a map key.
+func resolve(path string, stringOnly bool) (target, error) {
+	parts := strings.Split(path, ".")
+	t := reflect.TypeOf(event.Event{})
+	var index []int
+	for i, name := range parts {
+		if t.Kind() == reflect.Map && t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.String {
+			// The rest of the path is the key, which may itself contain dots
+			return target{index: index, key: strings.Join(parts[i:], "."), isKey: true}, nil
+		}
+		if t.Kind() != reflect.Struct {
+			return target{}, fmt.Errorf("unknown field %q", path)
+		}
+		f, ok := fieldByJSONName(t, name)
+		if !ok {
+			return target{}, fmt.Errorf("unknown field %q", path)
+		}
+		index = append(index, f.Index[0])
+		t = f.Type
+	}
+	if stringOnly && t.Kind() != reflect.String {
+		return target{}, fmt.Errorf("field %q is not a string", path)
+	}
+	return target{index: index}, nil
+}
+
+// fieldByJSONName finds the struct field serialized as name
+func fieldByJSONName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if tag == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// get returns a string target's value; false when it is empty or unset
+func (t target) get(root reflect.Value) (string, bool) {
+	v := root.FieldByIndex(t.index)
+	if t.isKey {
+		if v.IsNil() {
+			return "", false
+		}
+		mv := v.MapIndex(reflect.ValueOf(t.key))
+		if !mv.IsValid() || mv.String() == "" {
+			return "", false
+		}
+		return mv.String(), true
+	}
+	return v.String(), v.String() != ""
+}
+
+// set stores s in a string target, creating its map if needed
+func (t target) set(root reflect.Value, s string) {
+	v := root.FieldByIndex(t.index)
+	if !t.isKey {
+		v.SetString(s)
+		return
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	v.SetMapIndex(reflect.ValueOf(t.key), reflect.ValueOf(s).Convert(v.Type().Elem()))
+}
+
+// clear removes the target: map keys are deleted, fields set to their zero value
+func (t target) clear(root reflect.Value) {
+	v := root.FieldByIndex(t.index)
+	if !t.isKey {
+		v.SetZero()
+		return
+	}
+	if !v.IsNil() {
+		v.SetMapIndex(reflect.ValueOf(t.key), reflect.Value{})
+	}
+}
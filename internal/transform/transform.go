@@ -0,0 +1,249 @@
+// Package transform rewrites events on their way to the sinks: dropping
+// fields, renaming keys, lowercasing values and computing derived fields.
+// Steps are declared in a JSON file and may be limited to some sinks.
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Step is one transform. Exactly one of Drop, Rename, Lowercase and Compute
+// is set. Fields are JSON paths such as url.raw_query or props.plan.
+type Step struct {
+	Drop      []string          `json:"drop,omitempty"`      // fields to remove
+	Rename    map[string]string `json:"rename,omitempty"`    // from -> to; string fields and map keys only
+	Lowercase []string          `json:"lowercase,omitempty"` // string fields to lowercase
+	Compute   map[string]string `json:"compute,omitempty"`   // field -> function, e.g. props.channel: channel
+	Sinks     []string          `json:"sinks,omitempty"`     // sinks the step applies to; empty means all
+}
+
+// Load reads a JSON array of steps from path and compiles them
+func Load(path string) ([]Step, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transforms file: %w", err)
+	}
+	var steps []Step
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return nil, fmt.Errorf("failed to parse transforms file: %w", err)
+	}
+	if _, err := compile(steps); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// op is a compiled step
+type op struct {
+	sinks map[string]bool
+	apply func(root reflect.Value)
+}
+
+func compile(steps []Step) ([]op, error) {
+	ops := make([]op, 0, len(steps))
+	for i, s := range steps {
+		n := 0
+		for _, set := range []bool{len(s.Drop) > 0, len(s.Rename) > 0, len(s.Lowercase) > 0, len(s.Compute) > 0} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("transform %d: set exactly one of drop, rename, lowercase or compute", i)
+		}
+
+		var apply func(reflect.Value)
+		var err error
+		switch {
+		case len(s.Drop) > 0:
+			apply, err = compileDrop(s.Drop)
+		case len(s.Rename) > 0:
+			apply, err = compileRename(s.Rename)
+		case len(s.Lowercase) > 0:
+			apply, err = compileLowercase(s.Lowercase)
+		default:
+			apply, err = compileCompute(s.Compute)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("transform %d: %w", i, err)
+		}
+
+		o := op{apply: apply}
+		if len(s.Sinks) > 0 {
+			o.sinks = make(map[string]bool, len(s.Sinks))
+			for _, name := range s.Sinks {
+				o.sinks[name] = true
+			}
+		}
+		ops = append(ops, o)
+	}
+	return ops, nil
+}
+
+func compileDrop(paths []string) (func(reflect.Value), error) {
+	targets, err := resolveAll(paths, false)
+	if err != nil {
+		return nil, err
+	}
+	return func(root reflect.Value) {
+		for _, t := range targets {
+			t.clear(root)
+		}
+	}, nil
+}
+
+func compileRename(pairs map[string]string) (func(reflect.Value), error) {
+	type move struct{ from, to target }
+	var moves []move
+	for _, from := range sortedKeys(pairs) {
+		src, err := resolve(from, true)
+		if err != nil {
+			return nil, err
+		}
+		dst, err := resolve(pairs[from], true)
+		if err != nil {
+			return nil, err
+		}
+		moves = append(moves, move{src, dst})
+	}
+	return func(root reflect.Value) {
+		for _, m := range moves {
+			if v, ok := m.from.get(root); ok {
+				m.from.clear(root)
+				m.to.set(root, v)
+			}
+		}
+	}, nil
+}
+
+func compileLowercase(paths []string) (func(reflect.Value), error) {
+	targets, err := resolveAll(paths, true)
+	if err != nil {
+		return nil, err
+	}
+	return func(root reflect.Value) {
+		for _, t := range targets {
+			if v, ok := t.get(root); ok {
+				t.set(root, strings.ToLower(v))
+			}
+		}
+	}, nil
+}
+
+func compileCompute(fields map[string]string) (func(reflect.Value), error) {
+	type computed struct {
+		to target
+		fn func(event.Event) string
+	}
+	var cs []computed
+	for _, path := range sortedKeys(fields) {
+		fn, ok := functions[fields[path]]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %q for %s (want %s)", fields[path], path, strings.Join(sortedKeys(functions), ", "))
+		}
+		t, err := resolve(path, true)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, computed{t, fn})
+	}
+	return func(root reflect.Value) {
+		for _, c := range cs {
+			// Each function sees the event as the previous steps left it
+			if v := c.fn(root.Interface().(event.Event)); v != "" {
+				c.to.set(root, v)
+			}
+		}
+	}, nil
+}
+
+// Pipeline applies the configured steps. It is safe for concurrent use and
+// its steps can be replaced while serving traffic.
+type Pipeline struct {
+	mu  sync.RWMutex
+	ops []op
+}
+
+// New compiles steps into a pipeline
+func New(steps []Step) (*Pipeline, error) {
+	p := &Pipeline{}
+	if err := p.Update(steps); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Update replaces all steps. Invalid steps leave the pipeline unchanged.
+func (p *Pipeline) Update(steps []Step) error {
+	ops, err := compile(steps)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.ops = ops
+	p.mu.Unlock()
+	return nil
+}
+
+// Apply returns the event sink should receive. The maps of ev are copied
+// before they are changed, so other sinks' copies are unaffected. A nil
+// pipeline returns ev as is.
+func (p *Pipeline) Apply(sink string, ev event.Event) event.Event {
+	if p == nil {
+		return ev
+	}
+	p.mu.RLock()
+	ops := p.ops
+	p.mu.RUnlock()
+
+	var root reflect.Value
+	for _, o := range ops {
+		if o.sinks != nil && !o.sinks[sink] {
+			continue
+		}
+		if !root.IsValid() {
+			root = reflect.ValueOf(&ev).Elem()
+			cloneMaps(root)
+		}
+		o.apply(root)
+	}
+	return ev
+}
+
+// cloneMaps replaces every map in v with a copy
+func cloneMaps(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				cloneMaps(v.Field(i))
+			}
+		}
+	case reflect.Map:
+		if !v.IsNil() {
+			c := reflect.MakeMapWithSize(v.Type(), v.Len())
+			iter := v.MapRange()
+			for iter.Next() {
+				c.SetMapIndex(iter.Key(), iter.Value())
+			}
+			v.Set(c)
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,125 @@
+package transform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestPipelineApply(t *testing.T) {
+	p, err := New([]Step{
+		{Drop: []string{"url.raw_query", "server.detection", "props.secret"}},
+		{Rename: map[string]string{"props.plan": "props.tier", "device.ua": "props.user_agent"}},
+		{Lowercase: []string{"route.domain", "url.referrer_hostname"}},
+		{Compute: map[string]string{"props.channel": "channel", "props.landing_path": "landing_path"}},
+		{Drop: []string{"session.visitor_id"}, Sinks: []string{"kafka"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev := event.Event{
+		Route:   event.RouteInfo{Domain: "Shop.Example", Path: "/sale"},
+		Session: event.SessionInfo{VisitorID: "v-1", SessionSeq: 1},
+		Device:  event.DeviceInfo{UA: "Mozilla/5.0"},
+		Props:   map[string]string{"plan": "pro", "secret": "s"},
+	}
+	ev.URL.RawQuery = "utm_source=google&email=a@b.c"
+	ev.URL.ReferrerHostname = "WWW.GOOGLE.DE"
+	ev.Server.Detection.HeaderFingerprint = "fp"
+
+	got := p.Apply("postgres", ev)
+	if got.URL.RawQuery != "" || got.Server.Detection.HeaderFingerprint != "" {
+		t.Errorf("dropped fields kept: raw_query = %q, detection = %+v", got.URL.RawQuery, got.Server.Detection)
+	}
+	want := map[string]string{"tier": "pro", "user_agent": "Mozilla/5.0", "channel": event.ChannelOrganicSearch, "landing_path": "/sale"}
+	if len(got.Props) != len(want) {
+		t.Errorf("props = %v, want %v", got.Props, want)
+	}
+	for k, v := range want {
+		if got.Props[k] != v {
+			t.Errorf("props[%s] = %q, want %q", k, got.Props[k], v)
+		}
+	}
+	if got.Device.UA != "" || got.Route.Domain != "shop.example" || got.URL.ReferrerHostname != "www.google.de" {
+		t.Errorf("ua = %q, domain = %q, referrer = %q", got.Device.UA, got.Route.Domain, got.URL.ReferrerHostname)
+	}
+	if got.Session.VisitorID != "v-1" {
+		t.Error("kafka-only step applied to postgres")
+	}
+
+	if kafka := p.Apply("kafka", ev); kafka.Session.VisitorID != "" {
+		t.Error("kafka-only step not applied to kafka")
+	}
+	if ev.Props["plan"] != "pro" || ev.Props["secret"] != "s" || len(ev.Props) != 2 {
+		t.Errorf("original props changed: %v", ev.Props)
+	}
+
+	var none *Pipeline
+	if same := none.Apply("kafka", ev); same.URL.RawQuery != ev.URL.RawQuery {
+		t.Error("nil pipeline changed the event")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for name, steps := range map[string][]Step{
+		"no operation":       {{Sinks: []string{"kafka"}}},
+		"two operations":     {{Drop: []string{"url.raw_query"}, Lowercase: []string{"type"}}},
+		"unknown field":      {{Drop: []string{"url.nope"}}},
+		"rename non-string":  {{Rename: map[string]string{"device.viewport_w": "props.w"}}},
+		"lowercase a struct": {{Lowercase: []string{"url.utm"}}},
+		"unknown function":   {{Compute: map[string]string{"props.x": "country"}}},
+	} {
+		if _, err := New(steps); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	p, err := New([]Step{{Drop: []string{"url.raw_query"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Update([]Step{{Drop: []string{"nope"}}}); err == nil {
+		t.Fatal("expected error")
+	}
+	ev := event.Event{}
+	ev.URL.RawQuery = "a=b"
+	if got := p.Apply("log", ev); got.URL.RawQuery != "" {
+		t.Error("failed Update replaced the steps")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transforms.json")
+	if err := os.WriteFile(path, []byte(`[{"drop": ["url.raw_query"], "sinks": ["kafka"]}, {"compute": {"props.channel": "channel"}}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	steps, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].Sinks[0] != "kafka" || steps[1].Compute["props.channel"] != "channel" {
+		t.Errorf("steps = %+v", steps)
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"drop": ["url.nope"]}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("expected error for an unknown field")
+	}
+}
+
+func TestChannel(t *testing.T) {
+	e := event.Event{}
+	e.URL.ReferrerHostname = "www.google.com"
+	if got := Channel(e); got != event.ChannelOrganicSearch {
+		t.Errorf("Channel() = %q, want %q", got, event.ChannelOrganicSearch)
+	}
+	e.URL.Channel = event.ChannelEmail
+	if got := Channel(e); got != event.ChannelEmail {
+		t.Errorf("Channel() = %q, want the stored %q", got, event.ChannelEmail)
+	}
+}
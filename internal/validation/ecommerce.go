@@ -0,0 +1,77 @@
+package validation
+
+import (
+	"strconv"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Limits on an ecommerce section
+const (
+	MaxItems    = 200       // lines per cart or order
+	MaxQuantity = 1_000_000 // units per line
+	MaxAmount   = 1e9       // any amount, in the currency
+)
+
+// CheckEcommerce lists the issues with an ecommerce section: unknown
+// currencies, amounts without a currency, negative or implausible amounts,
+// quantities and checkout steps, and items with neither an ID nor a name.
+// A nil section has none.
+func CheckEcommerce(ec *event.EcommerceInfo) []Issue {
+	if ec == nil {
+		return nil
+	}
+	var issues []Issue
+	add := func(field, code string) {
+		issues = append(issues, Issue{Field: "ecommerce." + field, Code: code})
+	}
+	amount := func(field string, v float64) {
+		if v < 0 || v > MaxAmount {
+			add(field, CodeOutOfRange)
+		}
+	}
+
+	switch {
+	case ec.Currency != "" && !ValidCurrency(ec.Currency):
+		add("currency", CodeInvalidCurrency)
+	case ec.Currency == "" && hasAmount(ec):
+		add("currency", CodeRequired)
+	}
+	amount("value", ec.Value)
+	amount("subtotal", ec.Subtotal)
+	amount("discount", ec.Discount)
+	amount("tax", ec.Tax)
+	amount("shipping", ec.Shipping)
+	if ec.CheckoutStep < 0 {
+		add("checkout_step", CodeOutOfRange)
+	}
+
+	if len(ec.Items) > MaxItems {
+		add("items", CodeOutOfRange)
+	}
+	for i, it := range ec.Items {
+		field := "items[" + strconv.Itoa(i) + "]"
+		if it.ItemID == "" && it.ItemName == "" {
+			add(field+".item_id", CodeRequired)
+		}
+		amount(field+".price", it.Price)
+		amount(field+".discount", it.Discount)
+		if it.Quantity < 0 || it.Quantity > MaxQuantity {
+			add(field+".quantity", CodeOutOfRange)
+		}
+	}
+	return issues
+}
+
+// hasAmount reports whether any amount of ec is set, which needs a currency
+func hasAmount(ec *event.EcommerceInfo) bool {
+	if ec.Value != 0 || ec.Subtotal != 0 || ec.Discount != 0 || ec.Tax != 0 || ec.Shipping != 0 {
+		return true
+	}
+	for _, it := range ec.Items {
+		if it.Price != 0 || it.Discount != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+func TestCheckEcommerce(t *testing.T) {
+	tests := []struct {
+		name string
+		ec   *event.EcommerceInfo
+		want []Issue
+	}{
+		{"no section", nil, nil},
+		{"cart", &event.EcommerceInfo{Currency: "eur", Subtotal: 20, CheckoutStep: 2, Items: []event.EcommerceItem{{ItemID: "sku-1", Price: 10, Quantity: 2}}}, nil},
+		{"empty checkout step", &event.EcommerceInfo{CheckoutStep: 1, CheckoutOption: "express"}, nil},
+		{"unknown currency", &event.EcommerceInfo{Value: 5, Currency: "EURO"}, []Issue{{"ecommerce.currency", CodeInvalidCurrency}}},
+		{"amount without currency", &event.EcommerceInfo{Items: []event.EcommerceItem{{ItemName: "Mug", Price: 3}}}, []Issue{{"ecommerce.currency", CodeRequired}}},
+		{
+			"out of range",
+			&event.EcommerceInfo{Value: -1, Discount: 2e9, Currency: "USD", CheckoutStep: -1},
+			[]Issue{{"ecommerce.value", CodeOutOfRange}, {"ecommerce.discount", CodeOutOfRange}, {"ecommerce.checkout_step", CodeOutOfRange}},
+		},
+		{
+			"bad item",
+			&event.EcommerceInfo{Currency: "USD", Items: []event.EcommerceItem{{Price: -3, Quantity: MaxQuantity + 1}}},
+			[]Issue{{"ecommerce.items[0].item_id", CodeRequired}, {"ecommerce.items[0].price", CodeOutOfRange}, {"ecommerce.items[0].quantity", CodeOutOfRange}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckEcommerce(tt.ec); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	items := make([]event.EcommerceItem, MaxItems+1)
+	for i := range items {
+		items[i].ItemID = "sku"
+	}
+	if got := CheckEcommerce(&event.EcommerceInfo{Items: items}); !reflect.DeepEqual(got, []Issue{{"ecommerce.items", CodeOutOfRange}}) {
+		t.Errorf("too many items: got %v", got)
+	}
+}
+
+func TestCheckEcommerceInEvents(t *testing.T) {
+	v := newValidator(t, PolicySanitize, Rules{Required: []string{"ecommerce.order_id"}})
+
+	var cart event.Event
+	res := v.Check(0, &cart)
+	if !res.Rejected() || res.Issues[0] != (Issue{"ecommerce.order_id", CodeRequired}) {
+		t.Errorf("event without ecommerce: %+v", res)
+	}
+
+	order := event.Event{Ecommerce: &event.EcommerceInfo{OrderID: "A1", Value: -5, Currency: "USD"}}
+	if res := v.Check(0, &order); !res.Rejected() || res.Issues[0] != (Issue{"ecommerce.value", CodeOutOfRange}) {
+		t.Errorf("sanitize must reject broken ecommerce data: %+v", res)
+	}
+}
@@ -0,0 +1,307 @@
+// Package validation checks events received on /collect against configurable
+// rules (required fields, field lengths, allowed types, timestamp window and
+// event ID format) and the ecommerce section's structure, and applies a
+// policy to events that break them.
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Policy decides what happens to an event that breaks a rule
+type Policy string
+
+const (
+	PolicyReject   Policy = "reject"   // drop the event
+	PolicySanitize Policy = "sanitize" // repair what can be repaired, drop the rest
+	PolicyFlag     Policy = "flag"     // keep the event as sent and list its issues in server.validation_issues
+)
+
+// ParsePolicy validates a policy name; empty means PolicyFlag
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return PolicyFlag, nil
+	case PolicyReject, PolicySanitize, PolicyFlag:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown validation policy %q (want reject, sanitize or flag)", s)
+	}
+}
+
+// Issue codes
+const (
+	CodeRequired        = "required"         // a required field is empty
+	CodeTooLong         = "too_long"         // a string exceeds MaxFieldLength
+	CodeTypeNotAllowed  = "type_not_allowed" // type is not in EventTypes
+	CodeInvalidTS       = "invalid_ts"       // ts is not an RFC 3339 timestamp
+	CodeTSOutOfRange    = "ts_out_of_range"  // ts is older than MaxAge or further ahead than MaxSkew
+	CodeInvalidEventID  = "invalid_event_id" // event_id is not a UUID
+	CodeInvalidCurrency = "invalid_currency" // ecommerce.currency is not an ISO 4217 code
+	CodeOutOfRange      = "out_of_range"     // an ecommerce amount, quantity, step or item count is out of range
+)
+
+// Issue is one broken rule. Field is the JSON path, e.g. url.referrer.
+type Issue struct {
+	Field string `json:"field"`
+	Code  string `json:"code"`
+}
+
+func (i Issue) String() string { return i.Field + ":" + i.Code }
+
+// Result statuses
+const (
+	StatusAccepted  = "accepted"
+	StatusSanitized = "sanitized"
+	StatusFlagged   = "flagged"
+	StatusRejected  = "rejected"
+)
+
+// Result reports the outcome for one event of a /collect request
+type Result struct {
+	Index  int     `json:"index"`
+	Status string  `json:"status"`
+	Issues []Issue `json:"issues,omitempty"`
+}
+
+// Rejected reports whether the event must be dropped
+func (r Result) Rejected() bool { return r.Status == StatusRejected }
+
+// Rules configures the checks. Zero values disable a check.
+type Rules struct {
+	Required       []string      // JSON paths that must be non-empty, e.g. type or session.visitor_id
+	MaxFieldLength int           // longest accepted string value, in characters
+	EventTypes     []string      // accepted event types; empty accepts any
+	MaxAge         time.Duration // oldest accepted client timestamp
+	MaxSkew        time.Duration // furthest accepted client timestamp in the future
+}
+
+// Validator applies Rules under a Policy. It is safe for concurrent use.
+type Validator struct {
+	policy   Policy
+	rules    Rules
+	required [][]int // field index paths of rules.Required
+	types    map[string]bool
+	now      func() time.Time
+}
+
+// New creates a validator, rejecting required paths that name no event field
+func New(policy Policy, rules Rules) (*Validator, error) {
+	v := &Validator{policy: policy, rules: rules, now: time.Now}
+	for _, path := range rules.Required {
+		index, ok := fieldIndex(reflect.TypeOf(event.Event{}), strings.Split(path, "."))
+		if !ok {
+			return nil, fmt.Errorf("unknown required field %q", path)
+		}
+		v.required = append(v.required, index)
+	}
+	if len(rules.EventTypes) > 0 {
+		v.types = make(map[string]bool, len(rules.EventTypes))
+		for _, t := range rules.EventTypes {
+			v.types[t] = true
+		}
+	}
+	return v, nil
+}
+
+// Policy returns the configured policy
+func (v *Validator) Policy() Policy { return v.policy }
+
+// Check validates e as received from the client, before enrichment, and
+// applies the policy: sanitize repairs e in place, flag records the issues
+// in e.Server.ValidationIssues. Issues listed by the client are discarded.
+func (v *Validator) Check(index int, e *event.Event) Result {
+	e.Server.ValidationIssues = nil
+	issues, fixes := v.inspect(e)
+	if len(issues) == 0 {
+		return Result{Index: index, Status: StatusAccepted}
+	}
+
+	result := Result{Index: index, Issues: issues}
+	switch v.policy {
+	case PolicyReject:
+		result.Status = StatusRejected
+	case PolicySanitize:
+		if len(fixes) < len(issues) {
+			result.Status = StatusRejected
+			break
+		}
+		for _, fix := range fixes {
+			fix()
+		}
+		result.Status = StatusSanitized
+	default:
+		for _, issue := range issues {
+			e.Server.ValidationIssues = append(e.Server.ValidationIssues, issue.String())
+		}
+		result.Status = StatusFlagged
+	}
+	return result
+}
+
+// inspect lists the issues with e and the repairs for those that have one
+func (v *Validator) inspect(e *event.Event) ([]Issue, []func()) {
+	var issues []Issue
+	var fixes []func()
+
+	root := reflect.ValueOf(e).Elem()
+	for i, index := range v.required {
+		// A path through a nil pointer, such as an event without ecommerce, is empty
+		if f, err := root.FieldByIndexErr(index); err != nil || f.IsZero() {
+			issues = append(issues, Issue{Field: v.rules.Required[i], Code: CodeRequired})
+		}
+	}
+
+	// An empty type becomes pageview during enrichment
+	if v.types != nil && e.Type != "" && !v.types[e.Type] {
+		issues = append(issues, Issue{Field: "type", Code: CodeTypeNotAllowed})
+	}
+
+	// Enrichment would replace a malformed event ID anyway; report it here
+	if e.EventID != "" {
+		if _, err := uuid.Parse(e.EventID); err != nil {
+			issues = append(issues, Issue{Field: "event_id", Code: CodeInvalidEventID})
+			fixes = append(fixes, func() { e.EventID = event.NewEventID() })
+		}
+	}
+
+	// A bad timestamp is dropped, so enrichment stamps the receive time
+	if e.TS != "" {
+		if code := v.checkTS(e.TS); code != "" {
+			issues = append(issues, Issue{Field: "ts", Code: code})
+			fixes = append(fixes, func() { e.TS = "" })
+		}
+	}
+
+	// Broken ecommerce data can't be repaired, so sanitize drops the event
+	issues = append(issues, CheckEcommerce(e.Ecommerce)...)
+
+	if v.rules.MaxFieldLength > 0 {
+		walkStrings(root, "", func(path, s string, set func(string)) {
+			// event_id and ts have checks of their own
+			if path == "event_id" || path == "ts" {
+				return
+			}
+			if utf8.RuneCountInString(s) > v.rules.MaxFieldLength {
+				issues = append(issues, Issue{Field: path, Code: CodeTooLong})
+				fixes = append(fixes, func() { set(truncate(s, v.rules.MaxFieldLength)) })
+			}
+		})
+	}
+	return issues, fixes
+}
+
+// checkTS returns the issue code for a client timestamp, or "" if it is fine
+func (v *Validator) checkTS(ts string) string {
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return CodeInvalidTS
+	}
+	now := v.now()
+	if (v.rules.MaxAge > 0 && t.Before(now.Add(-v.rules.MaxAge))) ||
+		(v.rules.MaxSkew > 0 && t.After(now.Add(v.rules.MaxSkew))) {
+		return CodeTSOutOfRange
+	}
+	return ""
+}
+
+// truncate cuts s to at most n characters
+func truncate(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// jsonName returns the JSON name of a struct field, or "" if it is not serialized
+func jsonName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name
+}
+
+// fieldIndex resolves a JSON path to a struct field index path
+func fieldIndex(t reflect.Type, path []string) ([]int, bool) {
+	var index []int
+	for _, name := range path {
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return nil, false
+		}
+		found := false
+		for i := 0; i < t.NumField(); i++ {
+			if jsonName(t.Field(i)) == name {
+				index = append(index, i)
+				t = t.Field(i).Type
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return index, true
+}
+
+// walkStrings calls fn with every string in v, its JSON path and a setter
+// for it. Setters may be called once the walk is over.
+func walkStrings(v reflect.Value, path string, fn func(path, s string, set func(string))) {
+	switch v.Kind() {
+	case reflect.String:
+		fn(path, v.String(), v.SetString)
+	case reflect.Pointer:
+		if !v.IsNil() {
+			walkStrings(v.Elem(), path, fn)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if name := jsonName(t.Field(i)); name != "" {
+				walkStrings(v.Field(i), join(path, name), fn)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkStrings(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key()
+			fn(join(path, key.String()), iter.Value().String(), func(s string) {
+				v.SetMapIndex(key, reflect.ValueOf(s).Convert(v.Type().Elem()))
+			})
+		}
+	}
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,171 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+var fixedNow = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newValidator(t *testing.T, policy Policy, rules Rules) *Validator {
+	t.Helper()
+	v, err := New(policy, rules)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	v.now = func() time.Time { return fixedNow }
+	return v
+}
+
+func TestParsePolicy(t *testing.T) {
+	for in, want := range map[string]Policy{"": PolicyFlag, "reject": PolicyReject, " Sanitize ": PolicySanitize, "flag": PolicyFlag} {
+		if got, err := ParsePolicy(in); err != nil || got != want {
+			t.Errorf("ParsePolicy(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParsePolicy("drop"); err == nil {
+		t.Error("expected error for an unknown policy")
+	}
+}
+
+func TestNewUnknownRequiredField(t *testing.T) {
+	if _, err := New(PolicyReject, Rules{Required: []string{"session.nope"}}); err == nil || !strings.Contains(err.Error(), "session.nope") {
+		t.Errorf("New() error = %v, want unknown field", err)
+	}
+	if _, err := New(PolicyReject, Rules{Required: []string{"type.x"}}); err == nil {
+		t.Error("expected error for a path below a string field")
+	}
+}
+
+func TestCheckIssues(t *testing.T) {
+	rules := Rules{
+		Required:       []string{"type", "session.visitor_id"},
+		MaxFieldLength: 10,
+		EventTypes:     []string{"pageview", "click"},
+		MaxAge:         72 * time.Hour,
+		MaxSkew:        10 * time.Minute,
+	}
+	valid := func() event.Event {
+		var e event.Event
+		e.Type = "click"
+		e.EventID = uuid.NewString()
+		e.TS = fixedNow.Add(-time.Hour).Format(time.RFC3339Nano)
+		e.Session.VisitorID = "v1"
+		return e
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*event.Event)
+		want   []string
+	}{
+		{"valid", func(*event.Event) {}, nil},
+		{"missing required", func(e *event.Event) { e.Type, e.Session.VisitorID = "", "" }, []string{"type:required", "session.visitor_id:required"}},
+		{"type not allowed", func(e *event.Event) { e.Type = "purchase" }, []string{"type:type_not_allowed"}},
+		{"malformed event id", func(e *event.Event) { e.EventID = "not-a-uuid-at-all" }, []string{"event_id:invalid_event_id"}},
+		{"unparseable ts", func(e *event.Event) { e.TS = "yesterday" }, []string{"ts:invalid_ts"}},
+		{"ts too old", func(e *event.Event) { e.TS = fixedNow.Add(-73 * time.Hour).Format(time.RFC3339) }, []string{"ts:ts_out_of_range"}},
+		{"ts in the future", func(e *event.Event) { e.TS = fixedNow.Add(11 * time.Minute).Format(time.RFC3339) }, []string{"ts:ts_out_of_range"}},
+		{"long nested string", func(e *event.Event) { e.URL.Referrer = "https://example.com/" }, []string{"url.referrer:too_long"}},
+		{"long slice element", func(e *event.Event) { e.Device.Languages = []string{"en", "en-US-x-private"} }, []string{"device.languages[1]:too_long"}},
+		{"long map value", func(e *event.Event) { e.URL.OtherIDs = map[string]string{"twclid": "abcdefghijk"} }, []string{"url.other_click_ids.twclid:too_long"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := valid()
+			tt.modify(&e)
+			res := newValidator(t, PolicyFlag, rules).Check(3, &e)
+			var got []string
+			for _, issue := range res.Issues {
+				got = append(got, issue.String())
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("issues = %v, want %v", got, tt.want)
+			}
+			if res.Index != 3 {
+				t.Errorf("Index = %d, want 3", res.Index)
+			}
+		})
+	}
+}
+
+func TestCheckPolicies(t *testing.T) {
+	rules := Rules{Required: []string{"type"}, MaxFieldLength: 5}
+
+	t.Run("flag keeps the event and records issues", func(t *testing.T) {
+		e := event.Event{Type: "clicked"}
+		e.Server.ValidationIssues = []string{"forged:by_client"}
+		res := newValidator(t, PolicyFlag, rules).Check(0, &e)
+		if res.Status != StatusFlagged || res.Rejected() {
+			t.Errorf("Status = %s, want flagged", res.Status)
+		}
+		if e.Type != "clicked" || strings.Join(e.Server.ValidationIssues, ",") != "type:too_long" {
+			t.Errorf("event = %q, issues = %v", e.Type, e.Server.ValidationIssues)
+		}
+	})
+
+	t.Run("clean event clears client issues", func(t *testing.T) {
+		e := event.Event{Type: "click"}
+		e.Server.ValidationIssues = []string{"forged:by_client"}
+		if res := newValidator(t, PolicyFlag, rules).Check(0, &e); res.Status != StatusAccepted || e.Server.ValidationIssues != nil {
+			t.Errorf("Status = %s, issues = %v", res.Status, e.Server.ValidationIssues)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		e := event.Event{Type: "clicked"}
+		if res := newValidator(t, PolicyReject, rules).Check(0, &e); !res.Rejected() {
+			t.Errorf("Status = %s, want rejected", res.Status)
+		}
+	})
+
+	t.Run("sanitize repairs what it can", func(t *testing.T) {
+		e := event.Event{Type: "clicked", EventID: "42", TS: "soon"}
+		e.Device.Languages = []string{"zh-Hant-TW"}
+		e.URL.OtherIDs = map[string]string{"twclid": "abcdefgh"}
+		e.Server.ValidationIssues = []string{"forged:by_client"}
+		res := newValidator(t, PolicySanitize, rules).Check(0, &e)
+		if res.Status != StatusSanitized || len(res.Issues) != 5 {
+			t.Fatalf("Status = %s, issues = %v", res.Status, res.Issues)
+		}
+		if e.Type != "click" || e.TS != "" || e.Device.Languages[0] != "zh-Ha" || e.URL.OtherIDs["twclid"] != "abcde" {
+			t.Errorf("event not repaired: %+v", e)
+		}
+		if _, err := uuid.Parse(e.EventID); err != nil {
+			t.Errorf("EventID = %q, want a fresh UUID", e.EventID)
+		}
+		if e.Server.ValidationIssues != nil {
+			t.Errorf("sanitized event keeps issues %v", e.Server.ValidationIssues)
+		}
+	})
+
+	t.Run("sanitize rejects what it cannot repair", func(t *testing.T) {
+		e := event.Event{URL: event.URLInfo{Referrer: "https://example.com/"}} // type is required
+		res := newValidator(t, PolicySanitize, rules).Check(0, &e)
+		if !res.Rejected() || e.URL.Referrer != "https://example.com/" {
+			t.Errorf("Status = %s, referrer = %q; want rejected and untouched", res.Status, e.URL.Referrer)
+		}
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 3, "hel"},
+		{"héllo", 2, "hé"},
+		{"日本語", 2, "日本"},
+		{"abc", 0, ""},
+	} {
+		if got := truncate(tt.in, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package wal
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCheckpointInterval is how often each target is flushed and its
+	// position checkpointed. A crash replays at most this much into a target
+	// again.
+	DefaultCheckpointInterval = 5 * time.Second
+
+	retryMin = 100 * time.Millisecond
+	retryMax = 30 * time.Second
+)
+
+// DeliverFunc hands a record to one target and fails if the target refused it
+type DeliverFunc func(ctx context.Context, record []byte, target string) error
+
+// FlushFunc writes out what a target buffers
+type FlushFunc func(ctx context.Context, target string) error
+
+// Dispatcher delivers the log's records to each target in order, from the
+// target's own checkpoint. Targets advance independently: one that refuses
+// records gets them again with backoff while the others carry on, and a
+// restart resumes each from where it was. Delivery is at least once: records
+// a target took after its last checkpoint are delivered to it again after a
+// crash.
+type Dispatcher struct {
+	log      *Log
+	targets  []string
+	deliver  DeliverFunc
+	flush    FlushFunc
+	interval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher delivering the records of l to targets.
+// Before a target's checkpoint, flush writes out what it buffers; records
+// only count as delivered to it once that succeeds.
+func NewDispatcher(l *Log, targets []string, deliver DeliverFunc, flush FlushFunc, interval time.Duration) *Dispatcher {
+	if interval <= 0 {
+		interval = DefaultCheckpointInterval
+	}
+	return &Dispatcher{log: l, targets: targets, deliver: deliver, flush: flush, interval: interval}
+}
+
+// Start replays each target's records after its checkpoint, then keeps
+// delivering new ones until Close
+func (d *Dispatcher) Start() {
+	d.log.Track(d.targets)
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	for _, target := range d.targets {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.run(ctx, target)
+		}()
+	}
+}
+
+// Close stops delivering and checkpoints what each target was delivered.
+// Records left are delivered after the next start.
+func (d *Dispatcher) Close() {
+	if d.cancel == nil {
+		return
+	}
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) run(ctx context.Context, target string) {
+	delivered := d.log.Checkpointed(target)
+	reader := d.log.NewReader(delivered)
+	defer reader.Close()
+	defer func() { d.checkpoint(target, delivered) }()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		appended := d.log.Appended()
+		record, next, ok, err := reader.Next()
+		if err != nil {
+			log.Printf("wal: read failed: %v", err)
+		}
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-appended:
+			case <-ticker.C: // also retries after a read error
+				d.checkpoint(target, delivered)
+			}
+			continue
+		}
+
+		if !d.deliverRecord(ctx, record, target) {
+			return
+		}
+		delivered = next
+		select {
+		case <-ticker.C:
+			d.checkpoint(target, delivered)
+		default:
+		}
+	}
+}
+
+// deliverRecord delivers a record until the target takes it, and reports
+// false if ctx was done first
+func (d *Dispatcher) deliverRecord(ctx context.Context, record []byte, target string) bool {
+	err := d.deliver(ctx, record, target)
+	for wait := retryMin; err != nil; wait = min(wait*2, retryMax) {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+		err = d.deliver(ctx, record, target)
+	}
+	return true
+}
+
+// checkpoint flushes a target and records that everything before pos was
+// delivered to it
+func (d *Dispatcher) checkpoint(target string, pos Position) {
+	if pos == d.log.Checkpointed(target) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.interval)
+	defer cancel()
+	if d.flush != nil {
+		if err := d.flush(ctx, target); err != nil {
+			log.Printf("wal: not checkpointing %s, flush failed: %v", target, err)
+			return
+		}
+	}
+	if err := d.log.Checkpoint(target, pos); err != nil {
+		log.Printf("wal: checkpoint of %s failed: %v", target, err)
+	}
+}
@@ -0,0 +1,553 @@
+// Package wal is a write-ahead log of events on local disk. Records are
+// appended to numbered segment files before a request is acknowledged, read
+// back in order by a Dispatcher that hands them to each sink from that
+// sink's own position, and whole segments are deleted once every sink has
+// everything in them.
+package wal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentExt     = ".wal"
+	checkpointFile = "checkpoint"
+	headerSize     = 8        // payload length and CRC-32C, both uint32 big-endian
+	maxRecordBytes = 16 << 20 // anything longer is a corrupt header
+
+	// DefaultSegmentBytes is the size past which a new segment is started
+	DefaultSegmentBytes = 64 << 20
+)
+
+// Errors returned by Append
+var (
+	ErrFull   = errors.New("wal: size limit reached")
+	ErrClosed = errors.New("wal: closed")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options tune a Log
+type Options struct {
+	SegmentBytes int64         // start a new segment past this; 0 selects DefaultSegmentBytes
+	MaxBytes     int64         // refuse appends once the segments hold this much; 0 is unbounded
+	SyncInterval time.Duration // fsync this often; 0 syncs before every Append returns
+}
+
+// Position is a place in the log: a segment and a byte offset into it
+type Position struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// Log is a segmented append-only log in a directory. It is safe for
+// concurrent use by appenders and readers.
+type Log struct {
+	dir  string
+	opts Options
+
+	mu          sync.Mutex
+	segments    []uint64         // on disk, ascending; the last one is appended to
+	sizes       map[uint64]int64 // bytes in each segment before the active one
+	f           *os.File
+	size        int64 // bytes in the active segment
+	total       int64 // bytes across all segments
+	checkpoints map[string]Position
+	dirty       bool          // appended since the last fsync
+	appended    chan struct{} // closed on the next append
+	closed      bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens the log in dir, creating it if needed. A record cut short by a
+// crash at the end of the last segment is discarded.
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = DefaultSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	l := &Log{dir: dir, opts: opts, sizes: make(map[uint64]int64), appended: make(chan struct{}), stop: make(chan struct{})}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		l.segments = append(l.segments, id)
+		l.sizes[id] = info.Size()
+		l.total += info.Size()
+	}
+	slices.Sort(l.segments)
+	if len(l.segments) == 0 {
+		l.segments = []uint64{1}
+	}
+
+	if err := l.readCheckpoints(); err != nil {
+		return nil, err
+	}
+	if err := l.openActive(); err != nil {
+		return nil, err
+	}
+	if opts.SyncInterval > 0 {
+		l.wg.Add(1)
+		go l.syncLoop()
+	}
+	return l, nil
+}
+
+func (l *Log) path(id uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%016d%s", id, segmentExt))
+}
+
+// readCheckpoints loads each consumer's position. A position in a deleted
+// segment moves to the start of the log.
+func (l *Log) readCheckpoints() error {
+	l.checkpoints = make(map[string]Position)
+	data, err := os.ReadFile(filepath.Join(l.dir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &l.checkpoints); err != nil {
+		log.Printf("wal: ignoring unreadable checkpoint: %v", err)
+		l.checkpoints = make(map[string]Position)
+		return nil
+	}
+	for name, pos := range l.checkpoints {
+		if !slices.Contains(l.segments, pos.Segment) {
+			l.checkpoints[name] = l.start()
+		}
+	}
+	return nil
+}
+
+// start is the position of the first record on disk
+func (l *Log) start() Position {
+	return Position{Segment: l.segments[0]}
+}
+
+// oldest returns the earliest consumer position, which records after must
+// be kept for
+func (l *Log) oldest() Position {
+	if len(l.checkpoints) == 0 {
+		return l.start()
+	}
+	var oldest Position
+	first := true
+	for _, pos := range l.checkpoints {
+		if first || pos.before(oldest) {
+			oldest, first = pos, false
+		}
+	}
+	return oldest
+}
+
+func (p Position) before(q Position) bool {
+	return p.Segment < q.Segment || p.Segment == q.Segment && p.Offset < q.Offset
+}
+
+// openActive opens the last segment for appending, cutting off a partial
+// record left at its end
+func (l *Log) openActive() error {
+	id := l.segments[len(l.segments)-1]
+	f, err := os.OpenFile(l.path(id), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	valid := validLength(f, info.Size())
+	if valid < info.Size() {
+		log.Printf("wal: discarding %d bytes of an incomplete record in segment %d", info.Size()-valid, id)
+		if err := f.Truncate(valid); err != nil {
+			f.Close()
+			return err
+		}
+		l.total -= info.Size() - valid
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, valid
+	return nil
+}
+
+// validLength returns the length of the run of intact records at the start
+// of a segment of size bytes
+func validLength(r io.ReaderAt, size int64) int64 {
+	var offset int64
+	for offset < size {
+		_, n, err := readRecord(r, offset, size)
+		if err != nil {
+			break
+		}
+		offset += n
+	}
+	return offset
+}
+
+// readRecord reads the record at offset of a segment whose first end bytes
+// can be read, returning its payload and its length including the header
+func readRecord(r io.ReaderAt, offset, end int64) ([]byte, int64, error) {
+	if end-offset < headerSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	var header [headerSize]byte
+	if _, err := r.ReadAt(header[:], offset); err != nil {
+		return nil, 0, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[:4]))
+	if length > maxRecordBytes || end-offset-headerSize < length {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, length)
+	if _, err := r.ReadAt(payload, offset+headerSize); err != nil {
+		return nil, 0, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("wal: checksum mismatch")
+	}
+	return payload, headerSize + length, nil
+}
+
+// Append writes a record. Once it returns, the record survives the process
+// crashing; with a SyncInterval it may be lost if the machine does.
+func (l *Log) Append(payload []byte) error {
+	if len(payload) > maxRecordBytes {
+		return fmt.Errorf("wal: record of %d bytes exceeds %d", len(payload), maxRecordBytes)
+	}
+	record := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[headerSize:], payload)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if l.opts.MaxBytes > 0 && l.total+int64(len(record)) > l.opts.MaxBytes {
+		return ErrFull
+	}
+	if l.size > 0 && l.size+int64(len(record)) > l.opts.SegmentBytes {
+		if err := l.roll(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(record)
+	l.size += int64(n)
+	l.total += int64(n)
+	if err != nil {
+		// Drop the partial record so the segment stays readable
+		if terr := l.f.Truncate(l.size - int64(n)); terr == nil {
+			_, _ = l.f.Seek(l.size-int64(n), io.SeekStart)
+			l.size -= int64(n)
+			l.total -= int64(n)
+		}
+		return err
+	}
+	if l.opts.SyncInterval == 0 {
+		if err := l.f.Sync(); err != nil {
+			return err
+		}
+	} else {
+		l.dirty = true
+	}
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return nil
+}
+
+// roll closes the active segment and starts the next one
+func (l *Log) roll() error {
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	l.sizes[l.segments[len(l.segments)-1]] = l.size
+	id := l.segments[len(l.segments)-1] + 1
+	f, err := os.OpenFile(l.path(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, id)
+	l.f, l.size, l.dirty = f, 0, false
+	return nil
+}
+
+func (l *Log) syncLoop() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			if l.dirty && !l.closed {
+				if err := l.f.Sync(); err != nil {
+					log.Printf("wal: fsync failed: %v", err)
+				} else {
+					l.dirty = false
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// Appended returns a channel closed by the next Append, for a reader that
+// has caught up to wait on. Get it before reading, so no append is missed.
+func (l *Log) Appended() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.appended
+}
+
+// Track sets the consumers the log keeps records for, each reading from its
+// own position. A consumer without a checkpoint starts at the oldest
+// checkpoint on record, so it misses nothing the others haven't got;
+// checkpoints of consumers no longer tracked are dropped.
+func (l *Log) Track(names []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	oldest := l.oldest()
+	checkpoints := make(map[string]Position, len(names))
+	for _, name := range names {
+		pos, ok := l.checkpoints[name]
+		if !ok {
+			pos = oldest
+		}
+		checkpoints[name] = pos
+	}
+	l.checkpoints = checkpoints
+}
+
+// Checkpointed returns the position everything before which was delivered
+// to a consumer
+func (l *Log) Checkpointed(name string) Position {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if pos, ok := l.checkpoints[name]; ok {
+		return pos
+	}
+	return l.oldest()
+}
+
+// Checkpoint records that everything before pos was delivered to a
+// consumer and deletes the segments every consumer is past
+func (l *Log) Checkpoint(name string, pos Position) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.checkpoints[name] = pos
+	data, err := json.Marshal(l.checkpoints)
+	if err != nil {
+		return err
+	}
+	// The checkpoint must be on disk before the segments behind it go, or a
+	// crash could leave it pointing into a deleted segment
+	if err := l.writeCheckpoints(data); err != nil {
+		return err
+	}
+
+	oldest := l.oldest()
+	for len(l.segments) > 1 && l.segments[0] < oldest.Segment {
+		id := l.segments[0]
+		if err := os.Remove(l.path(id)); err != nil {
+			return err
+		}
+		l.total -= l.sizes[id]
+		delete(l.sizes, id)
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+// writeCheckpoints replaces the checkpoint file with data durably: the
+// temporary file is synced before the rename and the directory after it.
+func (l *Log) writeCheckpoints(data []byte) error {
+	tmp := filepath.Join(l.dir, checkpointFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(l.dir, checkpointFile)); err != nil {
+		return err
+	}
+	dir, err := os.Open(l.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Lag returns the bytes appended after a consumer's checkpoint
+func (l *Log) Lag(name string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pos, ok := l.checkpoints[name]
+	if !ok {
+		pos = l.oldest()
+	}
+	return l.lag(pos)
+}
+
+// Pending returns the bytes appended after the oldest checkpoint, which
+// some consumer has yet to be delivered
+func (l *Log) Pending() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lag(l.oldest())
+}
+
+func (l *Log) lag(pos Position) int64 {
+	lag := -pos.Offset
+	for _, id := range l.segments {
+		switch {
+		case id < pos.Segment:
+		case id == l.segments[len(l.segments)-1]:
+			lag += l.size
+		default:
+			lag += l.sizes[id]
+		}
+	}
+	return lag
+}
+
+// Close syncs and closes the active segment
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	err := l.f.Sync()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.mu.Unlock()
+	close(l.stop)
+	l.wg.Wait()
+	return err
+}
+
+// Reader reads records in order from a position
+type Reader struct {
+	log *Log
+	pos Position
+	f   *os.File // segment pos is in, opened lazily
+}
+
+// NewReader creates a reader starting at pos, normally a consumer's
+// Checkpointed position
+func (l *Log) NewReader(pos Position) *Reader {
+	return &Reader{log: l, pos: pos}
+}
+
+// Next returns the next record and the position after it. ok is false when
+// the reader has caught up with the appenders. A damaged record ends its
+// segment: the rest of it is skipped and logged.
+func (r *Reader) Next() (payload []byte, next Position, ok bool, err error) {
+	for {
+		r.log.mu.Lock()
+		active := r.log.segments[len(r.log.segments)-1]
+		end := r.log.size
+		following := uint64(0)
+		if i := slices.IndexFunc(r.log.segments, func(id uint64) bool { return id > r.pos.Segment }); i >= 0 {
+			following = r.log.segments[i]
+		}
+		r.log.mu.Unlock()
+
+		if r.pos.Segment == active && r.pos.Offset >= end {
+			return nil, r.pos, false, nil
+		}
+		if r.f == nil {
+			if r.f, err = os.Open(r.log.path(r.pos.Segment)); err != nil {
+				return nil, r.pos, false, err
+			}
+		}
+		if r.pos.Segment != active {
+			info, err := r.f.Stat()
+			if err != nil {
+				return nil, r.pos, false, err
+			}
+			end = info.Size()
+		}
+		if r.pos.Offset < end {
+			payload, n, err := readRecord(r.f, r.pos.Offset, end)
+			if err == nil {
+				r.pos.Offset += n
+				return payload, r.pos, true, nil
+			}
+			if r.pos.Segment == active {
+				return nil, r.pos, false, err
+			}
+			log.Printf("wal: skipping %d bytes after a damaged record in segment %d: %v", end-r.pos.Offset, r.pos.Segment, err)
+		}
+		// Done with a finished segment; move on to the next
+		r.f.Close()
+		r.f = nil
+		r.pos = Position{Segment: following}
+	}
+}
+
+// Close releases the reader's open segment
+func (r *Reader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -0,0 +1,270 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readAll reads every record from pos
+func readAll(t *testing.T, l *Log, pos Position) ([]string, Position) {
+	t.Helper()
+	r := l.NewReader(pos)
+	defer r.Close()
+	var records []string
+	for {
+		payload, next, ok, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return records, next
+		}
+		records = append(records, string(payload))
+	}
+}
+
+func TestLog(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{SegmentBytes: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i := range 10 {
+		record := fmt.Sprintf("record-%02d-%s", i, "0123456789") // 20 bytes, so 2 per segment
+		want = append(want, record)
+		if err := l.Append([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, end := readAll(t, l, l.Checkpointed("sink"))
+	if !slices.Equal(got, want) {
+		t.Fatalf("read %q", got)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt)); len(segments) != 5 {
+		t.Errorf("%d segments, want 5", len(segments))
+	}
+
+	if err := l.Checkpoint("sink", end); err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt)); len(segments) != 1 {
+		t.Errorf("%d segments after the checkpoint, want the active one", len(segments))
+	}
+	if l.Pending() != 0 {
+		t.Errorf("Pending() = %d after checkpointing everything", l.Pending())
+	}
+	if err := l.Append([]byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append([]byte("closed")); !errors.Is(err, ErrClosed) {
+		t.Errorf("append after Close: err = %v", err)
+	}
+
+	// Reopened, only what came after the checkpoint is read
+	l, err = Open(dir, Options{SegmentBytes: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if got, _ := readAll(t, l, l.Checkpointed("sink")); !slices.Equal(got, []string{"after"}) {
+		t.Errorf("after reopening read %q", got)
+	}
+}
+
+func TestLog_Recovery(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []string{"one", "two"} {
+		if err := l.Append([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	// A crash in the middle of writing a third record
+	f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%016d%s", 1, segmentExt)), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 5, 1, 2})
+	f.Close()
+
+	l, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.Append([]byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readAll(t, l, l.Checkpointed("sink")); !slices.Equal(got, []string{"one", "two", "three"}) {
+		t.Errorf("read %q", got)
+	}
+}
+
+func TestLog_Full(t *testing.T) {
+	l, err := Open(t.TempDir(), Options{SegmentBytes: 32, MaxBytes: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.Append([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append([]byte("0123456789")); !errors.Is(err, ErrFull) {
+		t.Errorf("third append: err = %v, want ErrFull", err)
+	}
+
+	// Delivered segments make room again
+	_, end := readAll(t, l, l.Checkpointed("sink"))
+	if err := l.Append([]byte("x")); !errors.Is(err, ErrFull) {
+		t.Fatalf("err = %v", err)
+	}
+	if err := l.Checkpoint("sink", end); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append([]byte("0123456789")); err != nil {
+		t.Errorf("append after the checkpoint: %v", err)
+	}
+}
+
+func TestLog_Consumers(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{SegmentBytes: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []string{"0123456789", "0123456789", "0123456789"} { // one segment each
+		if err := l.Append([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Track([]string{"fast", "slow"})
+	_, end := readAll(t, l, l.Checkpointed("fast"))
+	if err := l.Checkpoint("fast", end); err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt)); len(segments) != 3 {
+		t.Errorf("%d segments, want all 3 kept for the slow consumer", len(segments))
+	}
+	if l.Lag("fast") != 0 || l.Lag("slow") != 54 || l.Pending() != 54 {
+		t.Errorf("lag fast = %d, slow = %d, pending = %d", l.Lag("fast"), l.Lag("slow"), l.Pending())
+	}
+	l.Close()
+
+	// Reopened, each consumer resumes from its own position; a consumer
+	// that is no longer tracked stops holding on to segments
+	l, err = Open(dir, Options{SegmentBytes: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if got, _ := readAll(t, l, l.Checkpointed("slow")); len(got) != 3 {
+		t.Errorf("slow consumer read %d records after reopening, want 3", len(got))
+	}
+	if got, _ := readAll(t, l, l.Checkpointed("fast")); len(got) != 0 {
+		t.Errorf("fast consumer read %q again", got)
+	}
+	l.Track([]string{"fast", "new"})
+	if pos := l.Checkpointed("new"); pos != (Position{Segment: 1}) {
+		t.Errorf("new consumer starts at %+v, want the oldest checkpoint on record", pos)
+	}
+	if err := l.Checkpoint("new", end); err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt)); len(segments) != 1 {
+		t.Errorf("%d segments once the slow consumer was dropped, want 1", len(segments))
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{SyncInterval: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	delivered := map[string][]string{}
+	down := true // kafka refuses records until it comes back
+	deliver := func(_ context.Context, record []byte, target string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if target == "kafka" && down {
+			return errors.New("broker unavailable")
+		}
+		delivered[target] = append(delivered[target], string(record))
+		return nil
+	}
+	flush := func(context.Context, string) error { return nil }
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			ok := cond()
+			mu.Unlock()
+			if ok {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s; delivered %v", what, delivered)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	targets := []string{"kafka", "postgres"}
+
+	if err := l.Append([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDispatcher(l, targets, deliver, flush, 20*time.Millisecond)
+	d.Start()
+	if err := l.Append([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("postgres to carry on without kafka", func() bool { return len(delivered["postgres"]) == 2 })
+	d.Close()
+	l.Close()
+	if l.Lag("postgres") != 0 || l.Lag("kafka") == 0 {
+		t.Errorf("lag postgres = %d, kafka = %d", l.Lag("postgres"), l.Lag("kafka"))
+	}
+
+	// After a restart, kafka catches up and postgres gets nothing twice
+	l, err = Open(dir, Options{SyncInterval: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	d = NewDispatcher(l, targets, deliver, flush, 20*time.Millisecond)
+	d.Start()
+	waitFor("kafka to catch up", func() bool { return len(delivered["kafka"]) == 2 })
+	d.Close()
+
+	if !slices.Equal(delivered["postgres"], []string{"a", "b"}) || !slices.Equal(delivered["kafka"], []string{"a", "b"}) {
+		t.Errorf("delivered %v, want each record once per sink", delivered)
+	}
+	if l.Pending() != 0 {
+		t.Errorf("Pending() = %d after every sink caught up", l.Pending())
+	}
+}
@@ -0,0 +1,392 @@
+// Package client sends events to a GoTrack server from Go programs, such as
+// backend services reporting server-side conversions. It signs requests
+// the way /collect verifies them, batches events in the background and
+// retries failed deliveries.
+//
+//	c := client.NewClient("https://track.example.com/collect", os.Getenv("GOTRACK_HMAC_SECRET"))
+//	defer c.Close()
+//	c.Enqueue(client.Purchase("order-1001", 49.90, "EUR"))
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Defaults for the options
+const (
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = time.Second
+	DefaultMaxAttempts   = 5
+	DefaultMaxPending    = 10000
+	DefaultClientIP      = "127.0.0.1"
+	DefaultUserAgent     = "gotrack-go-client/1"
+)
+
+var (
+	// ErrClosed is returned by Enqueue after Close
+	ErrClosed = errors.New("client: closed")
+	// ErrQueueFull is returned by Enqueue when MaxPending events are waiting
+	ErrQueueFull = errors.New("client: queue full")
+)
+
+// StatusError is a response GoTrack answered with an error status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("gotrack responded %d: %s", e.StatusCode, e.Body)
+}
+
+// retryable reports whether the request may succeed when sent again
+func (e *StatusError) retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client requests are sent with
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithClientIP sets the address sent in X-Forwarded-For. When the client
+// connects from a trusted proxy (TRUST_PROXY or TRUSTED_PROXY_CIDRS) GoTrack
+// derives the HMAC key from it and records it as the event's IP, so pass the
+// end user's address for conversions made on their behalf. From other peers
+// GoTrack uses the connection's address, which must then be set here.
+func WithClientIP(ip string) Option {
+	return func(c *Client) { c.clientIP = ip }
+}
+
+// WithWriteKey sends a tenant's write key, for multi-tenant servers. The
+// HMAC secret is then the tenant's.
+func WithWriteKey(key string) Option {
+	return func(c *Client) { c.writeKey = key }
+}
+
+// WithAPIKey authenticates with an API_KEYS_FILE bearer key, which GoTrack
+// accepts in place of an HMAC signature
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithUserAgent sets the User-Agent GoTrack records in the device fields
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// WithBatchSize sets how many events are sent per request
+func WithBatchSize(n int) Option {
+	return func(c *Client) { c.batchSize = n }
+}
+
+// WithFlushInterval sets how often queued events are sent when a batch
+// hasn't filled up
+func WithFlushInterval(d time.Duration) Option {
+	return func(c *Client) { c.flushInterval = d }
+}
+
+// WithMaxAttempts sets how often a batch is sent before it is given up
+func WithMaxAttempts(n int) Option {
+	return func(c *Client) { c.maxAttempts = n }
+}
+
+// WithMaxPending bounds the events waiting to be sent
+func WithMaxPending(n int) Option {
+	return func(c *Client) { c.maxPending = n }
+}
+
+// WithErrorHandler receives queued events that could not be delivered. By
+// default they are logged and dropped.
+func WithErrorHandler(f func(err error, events []event.Event)) Option {
+	return func(c *Client) { c.onError = f }
+}
+
+// Client sends events to one GoTrack /collect endpoint. It is safe for
+// concurrent use.
+type Client struct {
+	endpoint      string
+	secret        []byte
+	http          *http.Client
+	clientIP      string
+	writeKey      string
+	apiKey        string
+	userAgent     string
+	batchSize     int
+	flushInterval time.Duration
+	maxAttempts   int
+	maxPending    int
+	onError       func(error, []event.Event)
+
+	mu      sync.Mutex
+	pending []event.Event
+	closed  bool
+	sendMu  sync.Mutex // one flush at a time, so batches keep their order
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewClient returns a client for the GoTrack server at endpoint, signing
+// with hmacSecret. An endpoint without a path posts to /collect. The
+// client flushes queued events in the background until Close.
+func NewClient(endpoint, hmacSecret string, opts ...Option) *Client {
+	if u, err := url.Parse(endpoint); err == nil && (u.Path == "" || u.Path == "/") {
+		u.Path = "/collect"
+		endpoint = u.String()
+	}
+	c := &Client{
+		endpoint:      endpoint,
+		secret:        []byte(hmacSecret),
+		http:          &http.Client{Timeout: 30 * time.Second},
+		clientIP:      DefaultClientIP,
+		userAgent:     DefaultUserAgent,
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		maxAttempts:   DefaultMaxAttempts,
+		maxPending:    DefaultMaxPending,
+		kick:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.batchSize = max(c.batchSize, 1)
+	c.maxAttempts = max(c.maxAttempts, 1)
+	if c.flushInterval <= 0 {
+		c.flushInterval = DefaultFlushInterval
+	}
+	if c.onError == nil {
+		c.onError = func(err error, events []event.Event) {
+			log.Printf("gotrack client: dropped %d events: %v", len(events), err)
+		}
+	}
+	go c.run()
+	return c
+}
+
+// NewEvent returns an event of type typ with a new event ID and the current
+// time, which GoTrack uses to deduplicate retries
+func NewEvent(typ string) event.Event {
+	return event.Event{
+		EventID: event.NewEventID(),
+		TS:      time.Now().UTC().Format(time.RFC3339Nano),
+		Type:    typ,
+	}
+}
+
+// Purchase returns a purchase event for an order, as the conversion
+// forwarders and revenue reports expect it. Deduplication goes by event ID,
+// so resend the same event rather than a new one for the order.
+func Purchase(orderID string, value float64, currency string) event.Event {
+	ev := NewEvent("purchase")
+	ev.Ecommerce = &event.EcommerceInfo{OrderID: orderID, Value: value, Currency: currency}
+	return ev
+}
+
+// Enqueue queues events to be sent in the background. Events without an ID
+// or timestamp get one, as from NewEvent.
+func (c *Client) Enqueue(events ...event.Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if len(c.pending)+len(events) > c.maxPending {
+		return ErrQueueFull
+	}
+	for _, ev := range events {
+		c.pending = append(c.pending, fill(ev))
+	}
+	if len(c.pending) >= c.batchSize {
+		select {
+		case c.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Send delivers events right away, retrying as for queued events, and
+// returns once GoTrack accepted them or the attempts ran out
+func (c *Client) Send(ctx context.Context, events ...event.Event) error {
+	batch := make([]event.Event, len(events))
+	for i, ev := range events {
+		batch[i] = fill(ev)
+	}
+	var errs []error
+	for len(batch) > 0 {
+		n := min(len(batch), c.batchSize)
+		if err := c.send(ctx, batch[:n]); err != nil {
+			errs = append(errs, err)
+		}
+		batch = batch[n:]
+	}
+	return errors.Join(errs...)
+}
+
+// Flush sends the queued events and waits for the result. Batches that
+// fail are also passed to the error handler.
+func (c *Client) Flush(ctx context.Context) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	var errs []error
+	for len(pending) > 0 {
+		n := min(len(pending), c.batchSize)
+		if err := c.send(ctx, pending[:n]); err != nil {
+			c.onError(err, pending[:n])
+			errs = append(errs, err)
+		}
+		pending = pending[n:]
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the background flushes and sends the events still queued
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.stop)
+	<-c.done
+	return c.Flush(context.Background())
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		case <-c.kick:
+		}
+		_ = c.Flush(context.Background())
+	}
+}
+
+// fill gives an event the ID and timestamp NewEvent would have
+func fill(ev event.Event) event.Event {
+	if ev.EventID == "" {
+		ev.EventID = event.NewEventID()
+	}
+	if ev.TS == "" {
+		ev.TS = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	return ev
+}
+
+// send posts one batch, retrying with backoff while the failure is
+// temporary
+func (c *Client) send(ctx context.Context, batch []event.Event) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("encoding events: %w", err)
+	}
+	var lastErr error
+	for attempt := 0; attempt < c.maxAttempts; attempt++ {
+		if attempt > 0 {
+			wait := min(time.Duration(100<<(attempt-1))*time.Millisecond, 5*time.Second)
+			var status *StatusError
+			if errors.As(lastErr, &status) && status.StatusCode == http.StatusTooManyRequests {
+				wait = max(wait, time.Second)
+			}
+			select {
+			case <-ctx.Done():
+				return errors.Join(ctx.Err(), lastErr)
+			case <-time.After(wait):
+			}
+		}
+		lastErr = c.post(ctx, body)
+		var status *StatusError
+		if lastErr == nil || errors.As(lastErr, &status) && !status.retryable() {
+			return lastErr
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", c.maxAttempts, lastErr)
+}
+
+func (c *Client) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("X-Forwarded-For", c.clientIP)
+	if c.writeKey != "" {
+		req.Header.Set("X-GoTrack-Write-Key", c.writeKey)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	} else if len(c.secret) > 0 {
+		c.sign(req, body)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
+
+// sign sets the X-GoTrack-HMAC, -TS and -Nonce headers. The key is
+// HMAC-SHA256(secret, "client-key:" + client IP) and signs
+// "<ts>\n<nonce>\n<body>", so the signature holds with HMAC_REPLAY_WINDOW on.
+func (c *Client) sign(req *http.Request, body []byte) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := make([]byte, 18)
+	_, _ = rand.Read(nonce)
+	nonceStr := base64.RawURLEncoding.EncodeToString(nonce)
+
+	keyMAC := hmac.New(sha256.New, c.secret)
+	keyMAC.Write([]byte("client-key:" + c.clientIP))
+	mac := hmac.New(sha256.New, keyMAC.Sum(nil))
+	mac.Write([]byte(ts + "\n" + nonceStr + "\n"))
+	mac.Write(body)
+
+	req.Header.Set("X-GoTrack-HMAC", hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("X-GoTrack-TS", ts)
+	req.Header.Set("X-GoTrack-Nonce", nonceStr)
+}
@@ -0,0 +1,201 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	httpx "github.com/shortontech/gotrack/internal/http"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// collector is a /collect stand-in that checks signatures as GoTrack does
+// behind TRUST_PROXY
+type collector struct {
+	mu       sync.Mutex
+	auth     *httpx.HMACAuth
+	statuses []int // answered in turn before accepting
+	requests int
+	batches  [][]event.Event
+	headers  []http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	body, _ := io.ReadAll(r.Body)
+	if !c.auth.VerifyHMAC(r, event.ClientIP(r, config.Config{TrustProxy: true}), body) {
+		http.Error(w, "invalid or missing HMAC signature", http.StatusUnauthorized)
+		return
+	}
+	if len(c.statuses) > 0 {
+		code := c.statuses[0]
+		c.statuses = c.statuses[1:]
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	var batch []event.Event
+	if err := json.Unmarshal(body, &batch); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	c.batches = append(c.batches, batch)
+	c.headers = append(c.headers, r.Header.Clone())
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (c *collector) events() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, b := range c.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func newCollector(t *testing.T, statuses ...int) (*collector, *httptest.Server) {
+	t.Helper()
+	c := &collector{auth: httpx.NewHMACAuth("secret", ""), statuses: statuses}
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+	return c, srv
+}
+
+func TestSend(t *testing.T) {
+	c, srv := newCollector(t)
+	cl := NewClient(srv.URL, "secret", WithWriteKey("wk_shop"), WithClientIP("203.0.113.7"))
+	defer cl.Close()
+
+	purchase := Purchase("order-1", 49.9, "EUR")
+	if err := cl.Send(context.Background(), purchase, event.Event{Type: "sign_up"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if len(c.batches) != 1 || len(c.batches[0]) != 2 {
+		t.Fatalf("batches = %v, want one of two events", c.batches)
+	}
+	got := c.batches[0]
+	if got[0].EventID != purchase.EventID || got[0].Ecommerce == nil || got[0].Ecommerce.OrderID != "order-1" {
+		t.Errorf("purchase = %+v", got[0])
+	}
+	if got[1].EventID == "" || got[1].TS == "" {
+		t.Errorf("event ID and timestamp not filled: %+v", got[1])
+	}
+	h := c.headers[0]
+	if h.Get("X-GoTrack-Write-Key") != "wk_shop" || h.Get("X-Forwarded-For") != "203.0.113.7" || h.Get("X-GoTrack-Nonce") == "" {
+		t.Errorf("headers = %v", h)
+	}
+}
+
+func TestSend_DefaultPath(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	cl := NewClient(srv.URL, "")
+	defer cl.Close()
+	if err := cl.Send(context.Background(), NewEvent("pageview")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if path != "/collect" {
+		t.Errorf("path = %q, want /collect", path)
+	}
+}
+
+func TestSend_Retries(t *testing.T) {
+	c, srv := newCollector(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+	cl := NewClient(srv.URL, "secret", WithMaxAttempts(3))
+	defer cl.Close()
+
+	if err := cl.Send(context.Background(), NewEvent("purchase")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if c.requests != 3 || c.events() != 1 {
+		t.Errorf("requests = %d, events = %d, want 3 and 1", c.requests, c.events())
+	}
+}
+
+func TestSend_Rejected(t *testing.T) {
+	c, srv := newCollector(t, http.StatusBadRequest)
+	cl := NewClient(srv.URL, "secret")
+	defer cl.Close()
+
+	err := cl.Send(context.Background(), NewEvent("purchase"))
+	var status *StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Send() error = %v, want status 400", err)
+	}
+	if c.requests != 1 {
+		t.Errorf("requests = %d, want no retry", c.requests)
+	}
+
+	cl = NewClient(srv.URL, "wrong-secret", WithMaxAttempts(1))
+	defer cl.Close()
+	if err := cl.Send(context.Background(), NewEvent("purchase")); !errors.As(err, &status) || status.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Send() with the wrong secret error = %v, want status 401", err)
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	c, srv := newCollector(t)
+	cl := NewClient(srv.URL, "secret", WithBatchSize(2), WithFlushInterval(time.Hour), WithMaxPending(3))
+
+	if err := cl.Enqueue(NewEvent("a"), NewEvent("b")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	// A full batch is sent without waiting for the interval
+	deadline := time.Now().Add(5 * time.Second)
+	for c.events() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if c.events() != 2 {
+		t.Fatalf("events = %d after a full batch, want 2", c.events())
+	}
+
+	if err := cl.Enqueue(NewEvent("c"), NewEvent("d"), NewEvent("e"), NewEvent("f")); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Enqueue() past MaxPending error = %v, want ErrQueueFull", err)
+	}
+	if err := cl.Enqueue(NewEvent("c")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if err := cl.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if c.events() != 3 {
+		t.Errorf("events = %d after Close, want 3", c.events())
+	}
+	if err := cl.Enqueue(NewEvent("d")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Enqueue() after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestFlush_ErrorHandler(t *testing.T) {
+	_, srv := newCollector(t, http.StatusUnprocessableEntity)
+	var dropped []event.Event
+	cl := NewClient(srv.URL, "secret", WithFlushInterval(time.Hour), WithErrorHandler(func(err error, events []event.Event) {
+		dropped = append(dropped, events...)
+	}))
+	defer cl.Close()
+
+	ev := NewEvent("purchase")
+	if err := cl.Enqueue(ev); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if err := cl.Flush(context.Background()); err == nil {
+		t.Error("Flush() succeeded, want the rejection")
+	}
+	if len(dropped) != 1 || dropped[0].EventID != ev.EventID {
+		t.Errorf("error handler got %v", dropped)
+	}
+}
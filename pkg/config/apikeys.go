@@ -0,0 +1,79 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// APIKey is a credential for server-to-server ingestion. Backends send it as
+// Authorization: Bearer <key> instead of signing payloads with HMAC.
+type APIKey struct {
+	Name       string   `json:"name"`                  // identifies the key in logs and errors
+	Key        string   `json:"key,omitempty"`         // the secret itself
+	KeySHA256  string   `json:"key_sha256,omitempty"`  // hex SHA-256 of the secret, to keep it out of the file
+	SiteID     string   `json:"site_id,omitempty"`     // tenant the key sends for; required in multi-tenant mode
+	EventTypes []string `json:"event_types,omitempty"` // allowed event types; empty allows any
+}
+
+// Digest returns the SHA-256 of the key, from key_sha256 or the plain key
+func (k APIKey) Digest() [32]byte {
+	var sum [32]byte
+	if k.KeySHA256 != "" {
+		_, _ = hex.Decode(sum[:], []byte(k.KeySHA256))
+		return sum
+	}
+	return sha256.Sum256([]byte(k.Key))
+}
+
+// LoadAPIKeys reads a JSON array of API keys from path. Names and secrets
+// must be unique and each key needs exactly one of key or key_sha256.
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys file: %w", err)
+	}
+
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys file: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("API keys file %s defines no keys", path)
+	}
+
+	names := map[string]bool{}
+	secrets := map[[32]byte]string{}
+	for i, k := range keys {
+		if strings.TrimSpace(k.Name) == "" {
+			return nil, fmt.Errorf("API key %d: name is required", i)
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("API key %s: duplicate name", k.Name)
+		}
+		names[k.Name] = true
+
+		switch {
+		case (k.Key == "") == (k.KeySHA256 == ""):
+			return nil, fmt.Errorf("API key %s: set exactly one of key and key_sha256", k.Name)
+		case k.KeySHA256 != "":
+			if sum, err := hex.DecodeString(k.KeySHA256); err != nil || len(sum) != 32 {
+				return nil, fmt.Errorf("API key %s: key_sha256 must be 64 hex characters", k.Name)
+			}
+		}
+		if owner, ok := secrets[k.Digest()]; ok {
+			return nil, fmt.Errorf("API key %s: secret already used by %s", k.Name, owner)
+		}
+		secrets[k.Digest()] = k.Name
+
+		for _, typ := range k.EventTypes {
+			if strings.TrimSpace(typ) == "" {
+				return nil, fmt.Errorf("API key %s: empty event type", k.Name)
+			}
+		}
+	}
+	return keys, nil
+}
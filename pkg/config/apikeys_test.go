@@ -0,0 +1,75 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeAPIKeys(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	t.Run("parses keys", func(t *testing.T) {
+		path := writeAPIKeys(t, `[
+			{"name": "billing", "key": "sk_billing", "site_id": "shop", "event_types": ["purchase", "refund"]},
+			{"name": "crm", "key_sha256": "`+strings.Repeat("ab", 32)+`"}
+		]`)
+		keys, err := LoadAPIKeys(path)
+		if err != nil {
+			t.Fatalf("LoadAPIKeys() error = %v", err)
+		}
+		if len(keys) != 2 {
+			t.Fatalf("got %d keys, want 2", len(keys))
+		}
+		if billing := keys[0]; billing.SiteID != "shop" || len(billing.EventTypes) != 2 {
+			t.Errorf("billing = %+v", billing)
+		}
+		if sum := keys[0].Digest(); sum != sha256.Sum256([]byte("sk_billing")) {
+			t.Errorf("Digest() of a plain key = %x", sum)
+		}
+		if sum := keys[1].Digest(); hex.EncodeToString(sum[:]) != strings.Repeat("ab", 32) {
+			t.Errorf("Digest() of a hashed key = %x", sum)
+		}
+	})
+
+	hashed := sha256.Sum256([]byte("sk_a"))
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"invalid json", `{"name": "a"`, "failed to parse"},
+		{"empty list", `[]`, "no keys"},
+		{"missing name", `[{"key": "sk"}]`, "name is required"},
+		{"duplicate name", `[{"name": "a", "key": "k1"}, {"name": "a", "key": "k2"}]`, "duplicate name"},
+		{"no secret", `[{"name": "a"}]`, "exactly one of key and key_sha256"},
+		{"both secrets", `[{"name": "a", "key": "k", "key_sha256": "` + strings.Repeat("0", 64) + `"}]`, "exactly one of key and key_sha256"},
+		{"bad hash", `[{"name": "a", "key_sha256": "abc"}]`, "64 hex characters"},
+		{"shared secret", `[{"name": "a", "key": "sk_a"}, {"name": "b", "key_sha256": "` + hex.EncodeToString(hashed[:]) + `"}]`, "already used by a"},
+		{"empty event type", `[{"name": "a", "key": "k", "event_types": [" "]}]`, "empty event type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadAPIKeys(writeAPIKeys(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadAPIKeys() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadAPIKeys(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+			t.Error("expected error for a missing file")
+		}
+	})
+}
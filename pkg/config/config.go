@@ -1,39 +1,233 @@
 package config
 
 import (
+	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
 )
 
 type Config struct {
-	ServerAddr   string
-	TrustProxy   bool
-	MaxBodyBytes int64    // bytes for /collect payload
-	IPHashSecret string   // daily salt secret seed; if empty, we won’t hash
-	Outputs      []string // enabled sinks: log, kafka, postgres
-	TestMode     bool     // if true, generate test events on startup
+	ServerAddr           string
+	TrustProxy           bool
+	TrustedProxyCIDRs    []string // peers allowed to set X-Forwarded-For; when set, TrustProxy is ignored
+	MaxBodyBytes         int64    // bytes for /collect payload
+	MaxDecompressedBytes int64    // bytes a gzip or br /collect payload may expand to
+	IPHashSecret         string   // daily salt secret seed; if empty, we won’t hash
+	Outputs              []string // enabled sinks: log, kafka, postgres
+	TestMode             bool     // if true, generate test events on startup
+	ConfigFile           string   // optional KEY=VALUE file overlaid on the environment; re-read on reload
+	LogLevel             string   // debug, info, warn, error (reloadable)
+
+	RecordReceivedAt bool // store the server receive time in received_at next to the client ts
+	ParseUserAgent   bool // fill device browser, OS and model from the User-Agent
+	ClientHints      bool // ask for high-entropy Client Hints on the pixel and proxied pages
+
+	// Graceful Drain
+	DrainTimeoutSeconds int64 // how long a drain waits for sinks to flush their buffers
+
+	// Multi-tenancy
+	TenantsFile string // JSON file of tenants with write keys; empty serves a single site (reloadable)
+	APIKeysFile string // JSON file of bearer keys for server-to-server /collect; empty disables them (reloadable)
+
+	// Output Routing
+	OutputRules string // per-sink filters such as "postgres: type=purchase"; sinks without rules get every event (reloadable)
+
+	// Event Transforms
+	TransformsFile string // JSON file of drop/rename/lowercase/compute steps applied before the sinks (reloadable)
+
+	RegionPolicyFile string // JSON file of data-handling rules by visitor country, e.g. for EEA traffic
+
+	// A/B Experiments
+	ExperimentsFile string // JSON file of experiments and variants visitors are assigned to; empty disables them (reloadable)
+
+	// Event Validation (/collect)
+	ValidationPolicy         string   // reject, sanitize or flag events that break a rule
+	ValidationRequired       []string // JSON paths that must be non-empty (e.g. type, session.visitor_id)
+	ValidationEventTypes     []string // accepted event types; empty accepts any
+	ValidationMaxFieldLength int64    // longest accepted string value in characters; 0 disables
+	ValidationMaxAgeHours    int64    // oldest accepted client timestamp; 0 disables
+	ValidationMaxSkewMinutes int64    // furthest accepted client timestamp in the future; 0 disables
+
+	// IP Privacy
+	IPPrivacyMode  string   // none, hash, truncate or drop; empty hashes when IPHashSecret is set
+	IPPrivacySinks []string // per-sink overrides as sink=mode (e.g. kafka=drop)
+
+	// Field encryption
+	FieldEncryptionFields    []string // ip, ua, click_ids and referrer encrypted before the sinks
+	FieldEncryptionKeys      string   // id:base64key entries; the first encrypts
+	FieldEncryptionKeysFile  string   // file holding the keys, e.g. a mounted KMS secret
+	FieldEncryptionSkipSinks []string // sinks that receive plaintext, such as ad platform uploads
+
+	// Do Not Track / Global Privacy Control
+	DNTRespect bool   // honor DNT: 1 and Sec-GPC: 1 request headers
+	DNTAction  string // strip identifying fields or drop the event
+
+	// IAB TCF v2 Consent
+	TCFAction   string   // off, flag, anonymize or drop events whose TC string doesn't permit measurement
+	TCFPurposes []string // TCF purposes measurement needs, by number
+	TCFVendorID int64    // Global Vendor List ID that must have consent; 0 skips the vendor check
+	TCFRequired bool     // treat events without a TC string as lacking consent, unless gdpr=0
+
+	// Event Deduplication
+	DedupEnabled       bool   // suppress events whose event_id was already seen
+	DedupAction        string // drop duplicates or flag them with server.duplicate
+	DedupWindowSeconds int64  // how long an event_id is remembered
+	DedupMaxEntries    int64  // event IDs held by the in-memory detector
+
+	// Conversions
+	ConversionDedupDays int64 // how long /conversion remembers an order_id; 0 disables order dedup
+
+	// Fixed Sampling
+	SamplingRates []string // per-type rates as type=rate (e.g. pageview=10%), decided per visitor; * sets the default
+
+	// Dynamic Sampling (load shedding)
+	SamplingDynamic       bool  // reduce pageview sampling automatically when sinks fall behind
+	SamplingQueueHigh     int64 // buffered events in any sink that trigger shedding
+	SamplingQueueLow      int64 // buffered events at or below which full fidelity is restored
+	SamplingLatencyHighMS int64 // sink flush latency that triggers shedding
+	SamplingMinPercent    int64 // lowest pageview sample rate, in percent
+
+	// Server-issued Sessions
+	SessionCookies        bool   // issue visitor/session cookies on /px.gif and /collect
+	SessionCookieDomain   string // cookie Domain attribute; empty scopes to the request host
+	SessionSameSite       string // lax, strict or none
+	SessionCookieSecure   bool   // set the Secure attribute
+	SessionTimeoutMinutes int64  // idle timeout before a new session starts
+	SessionMaxHours       int64  // rotate sessions older than this; 0 disables
+	VisitorCookieDays     int64  // visitor cookie lifetime
+	ClickIDCookies        bool   // keep ad click IDs in _fbc/_fbp/_gcl_aw cookies set on /px.gif
+	ClickIDCookieDays     int64  // click ID cookie lifetime
+
+	// Rate Limiting (reloadable)
+	RateLimitRPS   int64 // per-client requests per second on ingestion endpoints; 0 disables
+	RateLimitBurst int64 // per-client burst size
 
 	// HTTPS Configuration
 	EnableHTTPS bool   // enable HTTPS server
 	CertFile    string // path to SSL certificate file (server.crt)
 	KeyFile     string // path to SSL private key file (server.key)
 
+	// Listeners replace SERVER_ADDR with several addresses, each serving a
+	// set of routes, as [http://|https://]addr=routes
+	Listeners []string
+
+	// ACME Configuration (automatic certificates, e.g. Let's Encrypt)
+	ACMEDomains      []string // hostnames to obtain certificates for; non-empty enables ACME mode
+	ACMECacheDir     string   // directory keeping certificates and the account key across restarts
+	ACMEEmail        string   // contact address registered with the CA
+	ACMEHTTPAddr     string   // listener for HTTP-01 challenges and plain HTTP; empty disables it
+	ACMEDirectoryURL string   // ACME directory; empty uses Let's Encrypt production
+
 	// Middleware/Proxy Configuration
-	ForwardDestination string // destination hostname to forward non-tracking requests to
+	ForwardDestination string   // destination hostname to forward non-tracking requests to
+	TrackingPathPrefix string   // also serve the pixel, /collect and scripts under this path
+	ProxyMaxHTMLBytes  int64    // largest HTML response buffered for pixel injection; larger ones stream unchanged
+	ProxyCache         string   // cache backend for proxied GET responses: memory or disk; empty disables caching
+	ProxyCacheDir      string   // directory of the disk cache
+	ProxyCacheMaxBytes int64    // size budget of the cache; least recently used responses are evicted beyond it
+	ProxyOriginSecret  string   // signs an X-GoTrack-Proxy header on requests to the origin; empty sends none
+	InjectTemplateFile string   // html/template rendering the code injected into proxied pages; empty uses the built-in snippet
+	InjectPaths        []string // path patterns of pages to instrument; empty instruments all
+	InjectExcludePaths []string // path patterns of pages never instrumented, e.g. /admin/*
+	InjectCSP          string   // off, nonce or external: how injected scripts pass a strict Content-Security-Policy
 
 	// HMAC Authentication Configuration
-	HMACSecret    string // secret key for HMAC generation/verification
-	RequireHMAC   bool   // require HMAC verification for /collect endpoint
-	HMACPublicKey string // public key for client-side HMAC generation (base64 encoded)
+	HMACSecret              string // secret key for HMAC generation/verification
+	RequireHMAC             bool   // require HMAC verification for /collect endpoint
+	HMACPublicKey           string // public key for client-side HMAC generation (base64 encoded)
+	HMACReplayWindowSeconds int64  // allowed clock skew of X-GoTrack-TS; 0 accepts unsigned timestamps and skips nonce checks
+	HMACNonceMaxEntries     int64  // nonces held in memory when the shared store is in-memory
 
 	// Metrics Configuration
-	MetricsEnabled    bool   // enable Prometheus metrics server
-	MetricsAddr       string // metrics server bind address
-	MetricsTLSCert    string // TLS certificate for metrics server
-	MetricsTLSKey     string // TLS private key for metrics server
-	MetricsClientCA   string // client CA for mTLS authentication
-	MetricsRequireTLS bool   // require TLS for metrics server
+	MetricsEnabled     bool   // enable Prometheus metrics server
+	MetricsAddr        string // metrics server bind address
+	MetricsTLSCert     string // TLS certificate for metrics server
+	MetricsTLSKey      string // TLS private key for metrics server
+	MetricsClientCA    string // client CA for mTLS authentication
+	MetricsRequireTLS  bool   // require TLS for metrics server
+	MetricsRequireAuth bool   // refuse to start without token or mTLS auth
+	MetricsAuthToken   string // bearer token required on /metrics
+
+	MetricsDetailedPaths bool // label HTTP metrics with raw request paths instead of route names
+
+	// Admin API Configuration
+	AdminToken           string // bearer token for /admin/* endpoints; empty disables the admin API
+	ClusterWindowSeconds int64  // how long idle device clusters are kept in the report
+
+	// Relay Configuration (receiving events from edge GoTrack instances)
+	RelayAcceptToken string // bearer token required on /relay/batch; empty disables the endpoint
+
+	// Bulk Import Configuration
+	ImportToken string // bearer token required on /collect/ndjson; empty disables the endpoint
+
+	// Measurement Protocol Configuration (GA4-compatible ingestion)
+	MPAPISecret string // api_secret required on /mp/collect; empty disables the endpoint
+
+	// Segment Configuration (Segment HTTP Tracking API-compatible ingestion)
+	SegmentEnabled  bool   // serve /v1/t, /v1/p, /v1/i and /v1/batch
+	SegmentWriteKey string // write key required on those endpoints in single-tenant mode; empty accepts any
+
+	// Email Tracking Configuration
+	EmailLinkSecret string // signs the /e/o.gif open pixel and /e/c click links; empty disables email tracking
+
+	// Outbound Link Configuration (/r redirects)
+	OutboundLinkSecret   string   // signs /r links to any destination
+	OutboundAllowedHosts []string // hosts /r redirects to without a signature; *.example.com matches subdomains
+
+	// Short Link Configuration
+	ShortLinks bool // serve /s/<code> links kept in the shared store, created through the admin API
+
+	// Honeypot Configuration (bot detection traps)
+	HoneypotPaths      []string // decoy paths whose requests flag the client as automated; a trailing / covers the paths below
+	HoneypotLinkPath   string   // trap path linked invisibly from proxied pages; empty adds no link
+	HoneypotTTLSeconds int64    // how long a trapped client stays flagged
+
+	// Bot Response Configuration (what likely bots' events get)
+	BotResponses      []string // per-endpoint responses as endpoint=response (tag, drop or tarpit); * sets the default
+	BotScoreThreshold int64    // bot score from which an event gets its endpoint's response
+	BotTarpitMS       int64    // how long tarpitted requests are held
+
+	// Alert Configuration (operational webhooks)
+	AlertWebhookURL      string // Slack-compatible webhook notified when a condition starts and clears; empty disables alerts
+	AlertIntervalSeconds int64  // how often conditions are checked
+	AlertSinkDownMinutes int64  // how long a sink's health check must fail before alerting
+	AlertBacklogEvents   int64  // undelivered events in one sink that raise an alert; 0 disables
+	AlertBotPercent      int64  // share of events from likely bots in an interval that raises an alert; 0 disables
+	AlertCertDays        int64  // days before certificate expiry to alert; 0 disables
+
+	// Write-Ahead Log Configuration (on-disk queue between ingestion and sinks)
+	WALDir                   string // directory events are queued in before delivery; empty sends them straight to the sinks
+	WALSegmentMB             int64  // size of each log file
+	WALMaxMB                 int64  // disk the log may use before events bypass it; 0 is unbounded
+	WALSyncMS                int64  // how often the log is synced to disk; 0 syncs before each request is answered
+	WALCheckpointIntervalSec int64  // how often sinks are flushed and delivered events released from the log
+
+	// Cluster Configuration (several replicas behind one load balancer)
+	ClusterMode bool   // stamp events with the instance, name it in responses and serve /cluster/info
+	InstanceID  string // this replica's name in events, responses and alerts; the hostname when empty
+
+	// Shared State Configuration (session/visitor state, dedup, quotas, detection timing)
+	KVBackend     string // memory, redis or postgres; empty picks redis when RedisAddr is set
+	KVPostgresDSN string // Postgres DSN for the postgres backend
+
+	// Redis Configuration (shared state for multi-instance deployments)
+	RedisAddr        string // Redis address (host:port); empty keeps state in memory
+	RedisPassword    string // Redis password
+	RedisDB          int64  // Redis logical database
+	TimingTTLSeconds int64  // how long per-IP request timing is remembered for detection
+	RateMaxKeys      int64  // IPs and fingerprints whose request rates are counted in memory
+	IPReputationFile string // "<range> <class>" lines added to the built-in datacenter ranges
+	ReferrerListFile string // "<name or domain> <kind>" lines added to the built-in referrer list
+	ClickIDFile      string // "<param> [pattern]" lines added to the built-in click IDs
+
+	FingerprintTTLSeconds int64 // how long an idle header fingerprint's history is kept
+	FingerprintMaxKeys    int64 // header fingerprints whose history is kept in memory
+
+	// TrustedProxies is TrustedProxyCIDRs parsed once by Load, so requests
+	// don't parse it again; nil when an entry is invalid
+	TrustedProxies []netip.Prefix
 }
 
 func getOr(k, def string) string {
@@ -79,33 +273,245 @@ func getStringSlice(k, def string) []string {
 	return result
 }
 
+// ParseTrustedProxies parses TRUSTED_PROXY_CIDRS entries. A bare address
+// is a single-host range.
+func ParseTrustedProxies(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			addr, err := netip.ParseAddr(c)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", c, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range %q: %w", c, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
 func Load() Config {
-	return Config{
-		ServerAddr:   getOr("SERVER_ADDR", ":19890"),
-		TrustProxy:   getBool("TRUST_PROXY", false),
-		MaxBodyBytes: getInt64("MAX_BODY_BYTES", 1<<20), // 1 MiB default
-		IPHashSecret: getOr("IP_HASH_SECRET", ""),       // set to enable hashing
-		Outputs:      getStringSlice("OUTPUTS", "log"),  // default to log only
-		TestMode:     getBool("TEST_MODE", false),       // enable test event generation
+	cfg := Config{
+		ServerAddr:           getOr("SERVER_ADDR", ":19890"),
+		TrustProxy:           getBool("TRUST_PROXY", false),
+		TrustedProxyCIDRs:    getStringSlice("TRUSTED_PROXY_CIDRS", ""), // no ranges; TRUST_PROXY decides
+		MaxBodyBytes:         getInt64("MAX_BODY_BYTES", 1<<20),         // 1 MiB default
+		MaxDecompressedBytes: getInt64("MAX_DECOMPRESSED_BYTES", 4<<20), // 4 MiB; JSON compresses ~5-10x
+		IPHashSecret:         getOr("IP_HASH_SECRET", ""),               // set to enable hashing
+		Outputs:              getStringSlice("OUTPUTS", "log"),          // default to log only
+		TestMode:             getBool("TEST_MODE", false),               // enable test event generation
+		ConfigFile:           getOr("CONFIG_FILE", ""),                  // no config file by default
+		LogLevel:             getOr("LOG_LEVEL", "info"),                // info by default
+
+		RecordReceivedAt: getBool("RECORD_RECEIVED_AT", false), // client ts only by default
+		ParseUserAgent:   getBool("PARSE_USER_AGENT", true),    // parsed by default
+		ClientHints:      getBool("CLIENT_HINTS", true),        // requested by default
+
+		// Graceful Drain
+		DrainTimeoutSeconds: getInt64("DRAIN_TIMEOUT", 25), // fits within Kubernetes' default 30s grace period
+
+		// Multi-tenancy
+		TenantsFile: getOr("TENANTS_FILE", ""),  // single-tenant by default
+		APIKeysFile: getOr("API_KEYS_FILE", ""), // API keys disabled by default
+
+		// Output Routing
+		OutputRules: getOr("OUTPUT_RULES", ""), // every sink receives every event by default
+
+		// Event Transforms
+		TransformsFile: getOr("TRANSFORMS_FILE", ""), // events reach sinks as enriched by default
+
+		RegionPolicyFile: getOr("REGION_POLICY_FILE", ""), // every region handled alike by default
+
+		// A/B Experiments
+		ExperimentsFile: getOr("EXPERIMENTS_FILE", ""), // no experiments by default
+
+		// Event Validation
+		ValidationPolicy:         getOr("VALIDATION_POLICY", "flag"),               // keep events, record issues
+		ValidationRequired:       getStringSlice("VALIDATION_REQUIRED_FIELDS", ""), // nothing required by default
+		ValidationEventTypes:     getStringSlice("VALIDATION_EVENT_TYPES", ""),     // any type by default
+		ValidationMaxFieldLength: getInt64("VALIDATION_MAX_FIELD_LENGTH", 2048),    // fits long URLs
+		ValidationMaxAgeHours:    getInt64("VALIDATION_MAX_AGE_HOURS", 72),         // covers offline queues
+		ValidationMaxSkewMinutes: getInt64("VALIDATION_MAX_SKEW_MINUTES", 10),      // tolerates client clock drift
+
+		// IP Privacy
+		IPPrivacyMode:  getOr("IP_PRIVACY_MODE", ""),                // derived from IP_HASH_SECRET by default
+		IPPrivacySinks: getStringSlice("IP_PRIVACY_SINK_MODES", ""), // no per-sink overrides by default
+
+		// Field encryption
+		FieldEncryptionFields:    getStringSlice("FIELD_ENCRYPTION_FIELDS", ""),                         // fields stored as received by default
+		FieldEncryptionKeys:      getOr("FIELD_ENCRYPTION_KEYS", ""),                                    // no keys by default
+		FieldEncryptionKeysFile:  getOr("FIELD_ENCRYPTION_KEYS_FILE", ""),                               // no key file by default
+		FieldEncryptionSkipSinks: getStringSlice("FIELD_ENCRYPTION_SKIP_SINKS", "meta_capi,google_ads"), // ad platforms need raw click IDs
+
+		// Do Not Track / Global Privacy Control
+		DNTRespect: getBool("DNT_RESPECT", false), // opt-out headers ignored by default
+		DNTAction:  getOr("DNT_ACTION", "strip"),  // keep anonymous events by default
+
+		// IAB TCF v2 Consent
+		TCFAction:   getOr("TCF_ACTION", "off"),            // TC strings passed through undecoded by default
+		TCFPurposes: getStringSlice("TCF_PURPOSES", "1,8"), // store on device, measure content performance
+		TCFVendorID: getInt64("TCF_VENDOR_ID", 0),          // no vendor check by default
+		TCFRequired: getBool("TCF_REQUIRED", false),        // events without a TC string are allowed
+
+		// Event Deduplication
+		DedupEnabled:       getBool("DEDUP_ENABLED", false),       // disabled by default
+		DedupAction:        getOr("DEDUP_ACTION", "drop"),         // discard browser retries
+		DedupWindowSeconds: getInt64("DEDUP_WINDOW", 3600),        // covers client retry backoff
+		DedupMaxEntries:    getInt64("DEDUP_MAX_ENTRIES", 100000), // ~10 MB of IDs
+
+		// Conversions
+		ConversionDedupDays: getInt64("CONVERSION_DEDUP_DAYS", 30), // covers late re-sends from order systems
+
+		// Fixed Sampling
+		SamplingRates: getStringSlice("SAMPLING_RATES", ""), // every event kept by default
+
+		// Dynamic Sampling
+		SamplingDynamic:       getBool("SAMPLING_DYNAMIC", false),         // disabled by default
+		SamplingQueueHigh:     getInt64("SAMPLING_QUEUE_HIGH", 10000),     // high watermark
+		SamplingQueueLow:      getInt64("SAMPLING_QUEUE_LOW", 1000),       // low watermark
+		SamplingLatencyHighMS: getInt64("SAMPLING_LATENCY_HIGH_MS", 2000), // 2s flushes mean the sink is struggling
+		SamplingMinPercent:    getInt64("SAMPLING_MIN_PERCENT", 10),       // keep at least 10% of pageviews
+
+		// Server-issued Sessions
+		SessionCookies:        getBool("SESSION_COOKIES", false),       // disabled by default
+		SessionCookieDomain:   getOr("SESSION_COOKIE_DOMAIN", ""),      // request host by default
+		SessionSameSite:       getOr("SESSION_SAMESITE", "lax"),        // first-party default
+		SessionCookieSecure:   getBool("SESSION_COOKIE_SECURE", false), // forced on for SameSite=None
+		SessionTimeoutMinutes: getInt64("SESSION_TIMEOUT_MINUTES", 30), // industry-standard idle timeout
+		SessionMaxHours:       getInt64("SESSION_MAX_HOURS", 24),       // rotate at least daily
+		VisitorCookieDays:     getInt64("VISITOR_COOKIE_DAYS", 395),    // ~13 months
+		ClickIDCookies:        getBool("CLICK_ID_COOKIES", false),      // disabled by default
+		ClickIDCookieDays:     getInt64("CLICK_ID_COOKIE_DAYS", 90),    // the ad platforms' own default
+
+		// Rate Limiting
+		RateLimitRPS:   getInt64("RATE_LIMIT_RPS", 0),    // disabled by default
+		RateLimitBurst: getInt64("RATE_LIMIT_BURST", 20), // allow short bursts
 
 		// HTTPS Configuration
 		EnableHTTPS: getBool("ENABLE_HTTPS", false),       // disabled by default
 		CertFile:    getOr("SSL_CERT_FILE", "server.crt"), // default cert file path
 		KeyFile:     getOr("SSL_KEY_FILE", "server.key"),  // default key file path
 
+		Listeners: getStringSlice("LISTENERS", ""), // SERVER_ADDR serves everything by default
+
+		// ACME Configuration
+		ACMEDomains:      getStringSlice("ACME_DOMAINS", ""),    // ACME disabled by default
+		ACMECacheDir:     getOr("ACME_CACHE_DIR", "acme-cache"), // relative to the working directory
+		ACMEEmail:        getOr("ACME_EMAIL", ""),               // no contact address
+		ACMEHTTPAddr:     getOr("ACME_HTTP_ADDR", ":80"),        // HTTP-01 needs port 80
+		ACMEDirectoryURL: getOr("ACME_DIRECTORY_URL", ""),       // Let's Encrypt production
+
 		// Middleware/Proxy Configuration
-		ForwardDestination: getOr("FORWARD_DESTINATION", ""), // no default destination
+		ForwardDestination: getOr("FORWARD_DESTINATION", ""),           // no default destination
+		TrackingPathPrefix: getOr("TRACKING_PATH_PREFIX", ""),          // default paths only
+		ProxyMaxHTMLBytes:  getInt64("PROXY_MAX_HTML_BYTES", 4<<20),    // 4 MiB
+		ProxyCache:         getOr("PROXY_CACHE", ""),                   // caching disabled
+		ProxyCacheDir:      getOr("PROXY_CACHE_DIR", "proxy-cache"),    // relative to the working directory
+		ProxyCacheMaxBytes: getInt64("PROXY_CACHE_MAX_BYTES", 64<<20),  // 64 MiB
+		ProxyOriginSecret:  getOr("PROXY_ORIGIN_SECRET", ""),           // no signature header
+		InjectTemplateFile: getOr("INJECT_TEMPLATE_FILE", ""),          // built-in snippet
+		InjectPaths:        getStringSlice("INJECT_PATHS", ""),         // every page
+		InjectExcludePaths: getStringSlice("INJECT_EXCLUDE_PATHS", ""), // no exclusions
+		InjectCSP:          getOr("INJECT_CSP", ""),                    // inline as always
 
 		// HMAC Authentication Configuration
-		HMACSecret:    getOr("HMAC_SECRET", ""),     // no default - must be set explicitly
-		HMACPublicKey: getOr("HMAC_PUBLIC_KEY", ""), // derived from secret if not set
+		HMACSecret:              getOr("HMAC_SECRET", ""),                   // no default - must be set explicitly
+		HMACPublicKey:           getOr("HMAC_PUBLIC_KEY", ""),               // derived from secret if not set
+		HMACReplayWindowSeconds: getInt64("HMAC_REPLAY_WINDOW", 0),          // opt-in until all clients sign timestamps
+		HMACNonceMaxEntries:     getInt64("HMAC_NONCE_MAX_ENTRIES", 100000), // ~10 MB of nonces
 
 		// Metrics Configuration
-		MetricsEnabled:    getBool("METRICS_ENABLED", false),       // disabled by default
-		MetricsAddr:       getOr("METRICS_ADDR", "127.0.0.1:9090"), // bind to localhost by default
-		MetricsTLSCert:    getOr("METRICS_TLS_CERT", ""),           // no default TLS cert
-		MetricsTLSKey:     getOr("METRICS_TLS_KEY", ""),            // no default TLS key
-		MetricsClientCA:   getOr("METRICS_CLIENT_CA", ""),          // no default client CA
-		MetricsRequireTLS: getBool("METRICS_REQUIRE_TLS", false),   // TLS disabled by default
+		MetricsEnabled:     getBool("METRICS_ENABLED", false),       // disabled by default
+		MetricsAddr:        getOr("METRICS_ADDR", "127.0.0.1:9090"), // bind to localhost by default
+		MetricsTLSCert:     getOr("METRICS_TLS_CERT", ""),           // no default TLS cert
+		MetricsTLSKey:      getOr("METRICS_TLS_KEY", ""),            // no default TLS key
+		MetricsClientCA:    getOr("METRICS_CLIENT_CA", ""),          // no default client CA
+		MetricsRequireTLS:  getBool("METRICS_REQUIRE_TLS", false),   // TLS disabled by default
+		MetricsRequireAuth: getBool("METRICS_REQUIRE_AUTH", false),  // auth optional by default
+		MetricsAuthToken:   getOr("METRICS_AUTH_TOKEN", ""),         // no default token
+
+		MetricsDetailedPaths: getBool("METRICS_DETAILED_PATHS", false), // route names by default
+
+		// Admin API Configuration
+		AdminToken:           getOr("ADMIN_TOKEN", ""),         // admin API disabled by default
+		ClusterWindowSeconds: getInt64("CLUSTER_WINDOW", 3600), // 1 hour
+
+		// Relay Configuration
+		RelayAcceptToken: getOr("RELAY_ACCEPT_TOKEN", ""), // relay receiver disabled by default
+
+		// Bulk Import Configuration
+		ImportToken: getOr("IMPORT_TOKEN", ""), // bulk import disabled by default
+
+		// Measurement Protocol Configuration
+		MPAPISecret: getOr("MP_API_SECRET", ""), // Measurement Protocol disabled by default
+
+		// Segment Configuration
+		SegmentEnabled:  getBool("SEGMENT_ENABLED", false), // Segment endpoints disabled by default
+		SegmentWriteKey: getOr("SEGMENT_WRITE_KEY", ""),    // any write key accepted by default
+
+		// Email Tracking Configuration
+		EmailLinkSecret: getOr("EMAIL_LINK_SECRET", ""), // email tracking disabled by default
+
+		// Outbound Link Configuration; /r is disabled when both are empty
+		OutboundLinkSecret:   getOr("OUTBOUND_LINK_SECRET", ""),
+		OutboundAllowedHosts: getStringSlice("OUTBOUND_ALLOWED_HOSTS", ""),
+
+		// Short Link Configuration
+		ShortLinks: getBool("SHORT_LINKS", false), // disabled by default
+
+		// Honeypot Configuration
+		HoneypotPaths:      getStringSlice("HONEYPOT_PATHS", ""), // no decoy paths by default
+		HoneypotLinkPath:   getOr("HONEYPOT_LINK_PATH", ""),      // no hidden link by default
+		HoneypotTTLSeconds: getInt64("HONEYPOT_TTL", 86400),      // 24 hours
+
+		// Bot Response Configuration
+		BotResponses:      getStringSlice("BOT_RESPONSE", ""),  // likely bots are only scored by default
+		BotScoreThreshold: getInt64("BOT_SCORE_THRESHOLD", 80), // automation UA plus automation headers
+		BotTarpitMS:       getInt64("BOT_TARPIT_MS", 10000),    // 10 seconds
+
+		// Alert Configuration
+		AlertWebhookURL:      getOr("ALERT_WEBHOOK_URL", ""),          // alerts disabled by default
+		AlertIntervalSeconds: getInt64("ALERT_INTERVAL", 60),          // 1 minute
+		AlertSinkDownMinutes: getInt64("ALERT_SINK_DOWN_MINUTES", 5),  // 5 minutes
+		AlertBacklogEvents:   getInt64("ALERT_BACKLOG_EVENTS", 10000), // events
+		AlertBotPercent:      getInt64("ALERT_BOT_PERCENT", 50),       // half of the traffic
+		AlertCertDays:        getInt64("ALERT_CERT_DAYS", 14),         // two weeks
+
+		// Write-Ahead Log Configuration
+		WALDir:                   getOr("WAL_DIR", ""),                   // disabled by default
+		WALSegmentMB:             getInt64("WAL_SEGMENT_MB", 64),         // 64 MiB files
+		WALMaxMB:                 getInt64("WAL_MAX_MB", 1024),           // 1 GiB
+		WALSyncMS:                getInt64("WAL_SYNC_MS", 1000),          // survives process crashes, not power loss, within 1s
+		WALCheckpointIntervalSec: getInt64("WAL_CHECKPOINT_INTERVAL", 5), // 5 seconds
+
+		// Cluster Configuration
+		ClusterMode: getBool("CLUSTER_MODE", false), // single instance by default
+		InstanceID:  getOr("INSTANCE_ID", ""),       // hostname by default
+
+		// Shared State Configuration
+		KVBackend:     getOr("KV_BACKEND", ""), // derived from REDIS_ADDR by default
+		KVPostgresDSN: getOr("KV_PG_DSN", ""),  // no default DSN
+
+		// Redis Configuration
+		RedisAddr:        getOr("REDIS_ADDR", ""),                     // in-memory state by default
+		RedisPassword:    getOr("REDIS_PASSWORD", ""),                 // no password by default
+		RedisDB:          getInt64("REDIS_DB", 0),                     // default database
+		TimingTTLSeconds: getInt64("DETECTION_TIMING_TTL", 600),       // 10 minutes
+		RateMaxKeys:      getInt64("DETECTION_RATE_MAX_KEYS", 100000), // ~20 MB of counts
+		IPReputationFile: getOr("IP_REPUTATION_FILE", ""),             // built-in ranges only
+		ReferrerListFile: getOr("REFERRER_LIST_FILE", ""),             // built-in referrer list only
+		ClickIDFile:      getOr("CLICK_ID_FILE", ""),                  // built-in click IDs only
+
+		FingerprintTTLSeconds: getInt64("DETECTION_FINGERPRINT_TTL", 86400),       // 24 hours
+		FingerprintMaxKeys:    getInt64("DETECTION_FINGERPRINT_MAX_KEYS", 100000), // ~20 MB, ~100 MB if all rotate IPs
 	}
+	// An invalid entry is reported by the server's settings validation
+	cfg.TrustedProxies, _ = ParseTrustedProxies(cfg.TrustedProxyCIDRs)
+	return cfg
 }
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"net/netip"
 	"os"
+	"slices"
 	"testing"
 )
 
@@ -366,3 +368,16 @@ func TestLoad(t *testing.T) {
 		})
 	})
 }
+
+func TestLoadParsesTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXY_CIDRS", "10.0.0.0/8, 192.0.2.7")
+	want := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.7/32")}
+	if got := Load().TrustedProxies; !slices.Equal(got, want) {
+		t.Errorf("TrustedProxies = %v, want %v", got, want)
+	}
+
+	t.Setenv("TRUSTED_PROXY_CIDRS", "10.0.0.0/8, not-an-ip")
+	if got := Load().TrustedProxies; got != nil {
+		t.Errorf("TrustedProxies = %v, want nil for an invalid entry", got)
+	}
+}
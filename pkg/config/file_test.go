@@ -0,0 +1,74 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyFile(t *testing.T) {
+	t.Run("sets environment from file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "gotrack.env")
+		content := "# comment\n\nLOG_LEVEL=debug\nexport RATE_LIMIT_RPS=5\nHMAC_SECRET=\"quoted secret\"\n"
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("LOG_LEVEL", "info")
+		t.Setenv("RATE_LIMIT_RPS", "")
+		t.Setenv("HMAC_SECRET", "")
+
+		if err := ApplyFile(path); err != nil {
+			t.Fatalf("ApplyFile() error = %v", err)
+		}
+		if got := os.Getenv("LOG_LEVEL"); got != "debug" {
+			t.Errorf("LOG_LEVEL = %q, want debug", got)
+		}
+		if got := os.Getenv("RATE_LIMIT_RPS"); got != "5" {
+			t.Errorf("RATE_LIMIT_RPS = %q, want 5", got)
+		}
+		if got := os.Getenv("HMAC_SECRET"); got != "quoted secret" {
+			t.Errorf("HMAC_SECRET = %q, want quoted secret", got)
+		}
+	})
+
+	t.Run("rejects malformed lines without partial apply", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bad.env")
+		if err := os.WriteFile(path, []byte("LOG_LEVEL=warn\nnot a pair\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("LOG_LEVEL", "info")
+
+		if err := ApplyFile(path); err == nil {
+			t.Fatal("expected error for malformed line")
+		}
+		if got := os.Getenv("LOG_LEVEL"); got != "info" {
+			t.Errorf("LOG_LEVEL = %q, want unchanged info", got)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if err := ApplyFile(filepath.Join(t.TempDir(), "missing.env")); err == nil {
+			t.Error("expected error for missing file")
+		}
+	})
+}
+
+func TestLoadWithFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gotrack.env")
+	if err := os.WriteFile(path, []byte("RATE_LIMIT_RPS=42\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("RATE_LIMIT_RPS", "1")
+
+	cfg, err := LoadWithFile()
+	if err != nil {
+		t.Fatalf("LoadWithFile() error = %v", err)
+	}
+	if cfg.RateLimitRPS != 42 {
+		t.Errorf("RateLimitRPS = %d, want 42", cfg.RateLimitRPS)
+	}
+	if cfg.ConfigFile != path {
+		t.Errorf("ConfigFile = %q, want %q", cfg.ConfigFile, path)
+	}
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Tenant is one site sharing a GoTrack instance. Clients identify the site
+// with one of its write keys; the other settings apply to its events only.
+type Tenant struct {
+	SiteID         string   `json:"site_id"`                   // recorded on every event as site_id
+	WriteKeys      []string `json:"write_keys"`                // accepted keys; list two to rotate
+	AllowedOrigins []string `json:"allowed_origins,omitempty"` // e.g. https://shop.example; empty allows any
+	HMACSecret     string   `json:"hmac_secret,omitempty"`     // overrides HMAC_SECRET for this site
+	HMACPublicKey  string   `json:"hmac_public_key,omitempty"` // overrides HMAC_PUBLIC_KEY for this site
+	Outputs        []string `json:"outputs,omitempty"`         // subset of OUTPUTS to write to; empty means all
+}
+
+// LoadTenants reads a JSON array of tenants from path. Site IDs and write
+// keys must be non-empty and unique across all tenants, and allowed origins
+// must be absolute, such as https://shop.example.
+func LoadTenants(path string) ([]Tenant, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants file: %w", err)
+	}
+
+	var tenants []Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants file: %w", err)
+	}
+	if len(tenants) == 0 {
+		return nil, fmt.Errorf("tenants file %s defines no tenants", path)
+	}
+
+	sites := map[string]bool{}
+	keys := map[string]string{}
+	for i, t := range tenants {
+		if strings.TrimSpace(t.SiteID) == "" {
+			return nil, fmt.Errorf("tenant %d: site_id is required", i)
+		}
+		if sites[t.SiteID] {
+			return nil, fmt.Errorf("tenant %s: duplicate site_id", t.SiteID)
+		}
+		sites[t.SiteID] = true
+
+		if len(t.WriteKeys) == 0 {
+			return nil, fmt.Errorf("tenant %s: at least one write key is required", t.SiteID)
+		}
+		for _, key := range t.WriteKeys {
+			if strings.TrimSpace(key) == "" {
+				return nil, fmt.Errorf("tenant %s: empty write key", t.SiteID)
+			}
+			if owner, ok := keys[key]; ok {
+				return nil, fmt.Errorf("tenant %s: write key already used by %s", t.SiteID, owner)
+			}
+			keys[key] = t.SiteID
+		}
+
+		for _, origin := range t.AllowedOrigins {
+			if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, fmt.Errorf("tenant %s: allowed origin %q must be scheme://host", t.SiteID, origin)
+			}
+		}
+	}
+	return tenants, nil
+}
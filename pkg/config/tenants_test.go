@@ -0,0 +1,68 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTenants(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTenants(t *testing.T) {
+	t.Run("parses tenants", func(t *testing.T) {
+		path := writeTenants(t, `[
+			{"site_id": "shop", "write_keys": ["wk_shop"], "allowed_origins": ["https://shop.example"], "outputs": ["kafka"]},
+			{"site_id": "blog", "write_keys": ["wk_blog", "wk_blog_old"], "hmac_secret": "blog-secret"}
+		]`)
+		tenants, err := LoadTenants(path)
+		if err != nil {
+			t.Fatalf("LoadTenants() error = %v", err)
+		}
+		if len(tenants) != 2 {
+			t.Fatalf("got %d tenants, want 2", len(tenants))
+		}
+		if shop := tenants[0]; shop.SiteID != "shop" || shop.AllowedOrigins[0] != "https://shop.example" || shop.Outputs[0] != "kafka" {
+			t.Errorf("shop = %+v", shop)
+		}
+		if blog := tenants[1]; len(blog.WriteKeys) != 2 || blog.HMACSecret != "blog-secret" {
+			t.Errorf("blog = %+v", blog)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"invalid json", `{"site_id": "shop"`, "failed to parse"},
+		{"empty list", `[]`, "no tenants"},
+		{"missing site id", `[{"write_keys": ["wk"]}]`, "site_id is required"},
+		{"duplicate site id", `[{"site_id": "a", "write_keys": ["k1"]}, {"site_id": "a", "write_keys": ["k2"]}]`, "duplicate site_id"},
+		{"no write keys", `[{"site_id": "a"}]`, "write key is required"},
+		{"empty write key", `[{"site_id": "a", "write_keys": [""]}]`, "empty write key"},
+		{"relative origin", `[{"site_id": "a", "write_keys": ["k"], "allowed_origins": ["shop.example"]}]`, "must be scheme://host"},
+		{"shared write key", `[{"site_id": "a", "write_keys": ["k"]}, {"site_id": "b", "write_keys": ["k"]}]`, "already used by a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadTenants(writeTenants(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadTenants() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadTenants(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+			t.Error("expected error for a missing file")
+		}
+	})
+}
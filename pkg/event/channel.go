@@ -0,0 +1,196 @@
+package event
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Marketing channels stored in URLInfo.Channel
+const (
+	ChannelPaidSearch    = "paid_search"
+	ChannelPaidSocial    = "paid_social"
+	ChannelDisplay       = "display"
+	ChannelEmail         = "email"
+	ChannelAffiliate     = "affiliate"
+	ChannelOrganicSearch = "organic_search"
+	ChannelOrganicSocial = "organic_social"
+	ChannelReferral      = "referral"
+	ChannelDirect        = "direct"
+	ChannelOther         = "other"
+)
+
+// Referrer kinds in a referrer list
+const (
+	ReferrerSearch = "search"
+	ReferrerSocial = "social"
+	ReferrerEmail  = "email"
+)
+
+//go:embed referrers.txt
+var builtinReferrers []byte
+
+// DefaultChannelClassifier derives URLInfo.Channel during enrichment. It
+// holds the embedded referrer list; main replaces it when REFERRER_LIST_FILE
+// is set.
+var DefaultChannelClassifier = mustBuiltinChannelClassifier()
+
+// paidMediums are utm_medium values for paid clicks
+var paidMediums = map[string]bool{"cpc": true, "ppc": true, "paid": true, "paidsearch": true, "paid_search": true, "paid-search": true, "paidsocial": true, "paid_social": true, "paid-social": true, "cpv": true, "cpa": true, "cpp": true}
+
+// ChannelClassifier classifies how a visitor arrived from its click IDs,
+// UTM parameters and referrer, looking hostnames and utm_source up in a
+// referrer list
+type ChannelClassifier struct {
+	names   map[string]string // registrable names such as google, any TLD
+	domains map[string]string // hostnames, also matching their subdomains
+}
+
+// NewChannelClassifier reads "<name or domain> <kind>" lines from each
+// source, skipping blank lines and # comments. Later sources win for an
+// identical entry, so a file can reclassify a built-in one.
+func NewChannelClassifier(sources ...io.Reader) (*ChannelClassifier, error) {
+	c := &ChannelClassifier{names: make(map[string]string), domains: make(map[string]string)}
+	for _, src := range sources {
+		scanner := bufio.NewScanner(src)
+		for line := 1; scanner.Scan(); line++ {
+			text, _, _ := strings.Cut(scanner.Text(), "#")
+			fields := strings.Fields(text)
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: want \"<name or domain> <kind>\", got %q", line, strings.TrimSpace(text))
+			}
+			kind := strings.ToLower(fields[1])
+			switch kind {
+			case ReferrerSearch, ReferrerSocial, ReferrerEmail:
+			default:
+				return nil, fmt.Errorf("line %d: unknown referrer kind %q (want search, social or email)", line, fields[1])
+			}
+			entry := strings.TrimPrefix(strings.ToLower(fields[0]), "www.")
+			if strings.Contains(entry, ".") {
+				c.domains[entry] = kind
+			} else {
+				c.names[entry] = kind
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// LoadChannelClassifier returns a classifier with the built-in referrer list
+// plus the entries in path
+func LoadChannelClassifier(path string) (*ChannelClassifier, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := NewChannelClassifier(bytes.NewReader(builtinReferrers), f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func mustBuiltinChannelClassifier() *ChannelClassifier {
+	c, err := NewChannelClassifier(bytes.NewReader(builtinReferrers))
+	if err != nil {
+		panic("event: built-in referrer list: " + err.Error())
+	}
+	return c
+}
+
+// Channel classifies e with DefaultChannelClassifier
+func Channel(e Event) string {
+	return DefaultChannelClassifier.Classify(e)
+}
+
+// Classify returns the marketing channel of e, in the spirit of GA4's
+// default channel grouping: ad click IDs first, then UTM parameters, then
+// the referrer. Referrals from the site's own domain count as direct.
+func (c *ChannelClassifier) Classify(e Event) string {
+	utm := e.URL.UTM
+	medium := strings.ToLower(utm.Medium)
+	source := strings.ToLower(utm.Source)
+
+	switch {
+	case e.URL.Google.GCLID != "" || e.URL.Google.GBRAID != "" || e.URL.Google.WBRAID != "" || e.URL.Microsoft.MSCLKID != "":
+		return ChannelPaidSearch
+	case e.URL.Meta.FBCLID != "" || e.URL.OtherIDs["ttclid"] != "" || e.URL.OtherIDs["li_fat_id"] != "" || e.URL.OtherIDs["twclid"] != "":
+		return ChannelPaidSocial
+	}
+
+	if medium != "" || source != "" {
+		kind := c.kind(source)
+		switch {
+		case paidMediums[medium] && kind == ReferrerSocial:
+			return ChannelPaidSocial
+		case paidMediums[medium]:
+			return ChannelPaidSearch
+		case medium == "display" || medium == "banner" || medium == "cpm" || medium == "interstitial":
+			return ChannelDisplay
+		case medium == "email" || medium == "e-mail" || medium == "e_mail" || source == "email" || source == "newsletter" || kind == ReferrerEmail:
+			return ChannelEmail
+		case medium == "affiliate" || medium == "affiliates":
+			return ChannelAffiliate
+		case medium == "organic" || kind == ReferrerSearch:
+			return ChannelOrganicSearch
+		case medium == "social" || medium == "social-network" || medium == "social_network" || kind == ReferrerSocial:
+			return ChannelOrganicSocial
+		case medium == "referral":
+			return ChannelReferral
+		}
+		return ChannelOther
+	}
+
+	host := strings.ToLower(e.URL.ReferrerHostname)
+	if host == "" && e.URL.Referrer != "" {
+		if u, err := url.Parse(e.URL.Referrer); err == nil {
+			host = strings.ToLower(u.Hostname())
+		}
+	}
+	domain := strings.ToLower(e.Route.Domain)
+	if host == "" || strings.TrimPrefix(host, "www.") == strings.TrimPrefix(domain, "www.") {
+		return ChannelDirect
+	}
+	switch c.kind(host) {
+	case ReferrerSearch:
+		return ChannelOrganicSearch
+	case ReferrerSocial:
+		return ChannelOrganicSocial
+	case ReferrerEmail:
+		return ChannelEmail
+	}
+	return ChannelReferral
+}
+
+// kind looks a hostname or bare utm_source such as google, t.co or
+// l.facebook.com up in the list. The longest matching domain wins, then any
+// label that is a listed name.
+func (c *ChannelClassifier) kind(host string) string {
+	if c == nil || host == "" {
+		return ""
+	}
+	for d := host; d != ""; {
+		if kind, ok := c.domains[d]; ok {
+			return kind
+		}
+		_, d, _ = strings.Cut(d, ".")
+	}
+	for _, label := range strings.Split(host, ".") {
+		if kind, ok := c.names[label]; ok {
+			return kind
+		}
+	}
+	return ""
+}
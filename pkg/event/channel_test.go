@@ -0,0 +1,71 @@
+package event
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChannel(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(e *Event)
+		want string
+	}{
+		{"direct", func(e *Event) {}, ChannelDirect},
+		{"own domain", func(e *Event) { e.URL.ReferrerHostname = "www.shop.example" }, ChannelDirect},
+		{"gclid", func(e *Event) { e.URL.Google.GCLID = "g"; e.URL.UTM.Medium = "email" }, ChannelPaidSearch},
+		{"fbclid", func(e *Event) { e.URL.Meta.FBCLID = "f" }, ChannelPaidSocial},
+		{"cpc on social", func(e *Event) { e.URL.UTM.Source, e.URL.UTM.Medium = "facebook", "cpc" }, ChannelPaidSocial},
+		{"cpc", func(e *Event) { e.URL.UTM.Source, e.URL.UTM.Medium = "bing", "CPC" }, ChannelPaidSearch},
+		{"display", func(e *Event) { e.URL.UTM.Medium = "banner" }, ChannelDisplay},
+		{"email", func(e *Event) { e.URL.UTM.Source = "newsletter" }, ChannelEmail},
+		{"affiliate", func(e *Event) { e.URL.UTM.Medium = "affiliate" }, ChannelAffiliate},
+		{"utm other", func(e *Event) { e.URL.UTM.Source = "podcast" }, ChannelOther},
+		{"search referrer", func(e *Event) { e.URL.ReferrerHostname = "www.google.co.uk" }, ChannelOrganicSearch},
+		{"social referrer", func(e *Event) { e.URL.ReferrerHostname = "l.facebook.com" }, ChannelOrganicSocial},
+		{"t.co", func(e *Event) { e.URL.ReferrerHostname = "t.co" }, ChannelOrganicSocial},
+		{"single letter elsewhere", func(e *Event) { e.URL.ReferrerHostname = "t.example.com" }, ChannelReferral},
+		{"referral", func(e *Event) { e.URL.ReferrerHostname = "news.example.org" }, ChannelReferral},
+		{"webmail referrer", func(e *Event) { e.URL.ReferrerHostname = "mail.yahoo.com" }, ChannelEmail},
+		{"webmail source", func(e *Event) { e.URL.UTM.Source = "mail.google.com" }, ChannelEmail},
+		{"referrer without hostname", func(e *Event) { e.URL.Referrer = "https://duckduckgo.com/" }, ChannelOrganicSearch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := Event{Route: RouteInfo{Domain: "shop.example"}}
+			tt.edit(&e)
+			if got := Channel(e); got != tt.want {
+				t.Errorf("Channel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewChannelClassifier(t *testing.T) {
+	c, err := NewChannelClassifier(strings.NewReader("# partners\nexample.org email\nwww.Forum.Example social\nkagi search\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"news.example.org", ChannelEmail},
+		{"forum.example", ChannelOrganicSocial},
+		{"kagi.com", ChannelOrganicSearch},
+		{"www.google.com", ChannelReferral}, // not in this list
+	}
+	for _, tt := range tests {
+		e := Event{}
+		e.URL.ReferrerHostname = tt.host
+		if got := c.Classify(e); got != tt.want {
+			t.Errorf("Classify(%s) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"google\n", "google video\n"} {
+		if _, err := NewChannelClassifier(strings.NewReader(bad)); err == nil {
+			t.Errorf("NewChannelClassifier(%q): expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package event
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// builtinClickIDs are the click IDs recorded in URLInfo.OtherIDs without a
+// CLICK_ID_FILE, in the file's "<param> [pattern]" format
+const builtinClickIDs = `
+ttclid    # TikTok
+li_fat_id # LinkedIn
+epik      # Pinterest
+twclid    # X (Twitter)
+dclid     # Google Display & Video 360
+`
+
+// typedClickIDs have their own URLInfo fields and can't be registered
+var typedClickIDs = []string{"gclid", "gclsrc", "gbraid", "wbraid", "fbclid", "fbc", "fbp", "msclkid"}
+
+// clickIDParams are query parameters that identify an ad click, besides the
+// typed and registered ones
+var clickIDParams = map[string]bool{
+	"gclid": true, "gclsrc": true, "gbraid": true, "wbraid": true,
+	"fbclid": true, "msclkid": true, "ttclid": true, "li_fat_id": true,
+	"epik": true, "twclid": true, "dclid": true, "yclid": true,
+}
+
+// DefaultClickIDs decides which query parameters are recorded as click IDs
+// in URLInfo.OtherIDs. main replaces it when CLICK_ID_FILE is set.
+var DefaultClickIDs = mustBuiltinClickIDs()
+
+// ClickIDRegistry lists the query parameters recorded in URLInfo.OtherIDs,
+// each with an optional pattern its values must match
+type ClickIDRegistry struct {
+	params   []string
+	patterns map[string]*regexp.Regexp // nil accepts any value
+}
+
+// NewClickIDRegistry reads "<param> [pattern]" lines from each source,
+// skipping blank lines and # comments. A pattern is a regular expression
+// the whole value must match; values that don't are not recorded. Later
+// sources win for a repeated param, so a file can add a pattern to a
+// built-in click ID.
+func NewClickIDRegistry(sources ...io.Reader) (*ClickIDRegistry, error) {
+	c := &ClickIDRegistry{patterns: make(map[string]*regexp.Regexp)}
+	for _, src := range sources {
+		scanner := bufio.NewScanner(src)
+		for line := 1; scanner.Scan(); line++ {
+			text, _, _ := strings.Cut(scanner.Text(), "#")
+			fields := strings.Fields(text)
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) > 2 {
+				return nil, fmt.Errorf("line %d: want \"<param> [pattern]\", got %q", line, strings.TrimSpace(text))
+			}
+			param := fields[0]
+			if slices.Contains(typedClickIDs, strings.ToLower(param)) || strings.HasPrefix(strings.ToLower(param), "utm_") {
+				return nil, fmt.Errorf("line %d: %s has its own event field and can't be registered", line, param)
+			}
+			var pattern *regexp.Regexp
+			if len(fields) == 2 {
+				re, err := regexp.Compile(`^(?:` + fields[1] + `)$`)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+				pattern = re
+			}
+			if _, ok := c.patterns[param]; !ok {
+				c.params = append(c.params, param)
+			}
+			c.patterns[param] = pattern
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// LoadClickIDRegistry returns a registry with the built-in click IDs plus
+// those in path
+func LoadClickIDRegistry(path string) (*ClickIDRegistry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := NewClickIDRegistry(strings.NewReader(builtinClickIDs), f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func mustBuiltinClickIDs() *ClickIDRegistry {
+	c, err := NewClickIDRegistry(strings.NewReader(builtinClickIDs))
+	if err != nil {
+		panic("event: built-in click IDs: " + err.Error())
+	}
+	return c
+}
+
+// Params returns the registered query parameters in registration order
+func (c *ClickIDRegistry) Params() []string {
+	return slices.Clone(c.params)
+}
+
+// Has reports whether param is a click ID, typed or registered. Names are
+// compared case-insensitively, as an identifier in any case is still one.
+func (c *ClickIDRegistry) Has(param string) bool {
+	if slices.Contains(typedClickIDs, strings.ToLower(param)) {
+		return true
+	}
+	return slices.ContainsFunc(c.params, func(p string) bool { return strings.EqualFold(p, param) })
+}
+
+// Valid reports whether value is acceptable for the registered param
+func (c *ClickIDRegistry) Valid(param, value string) bool {
+	re := c.patterns[param]
+	return re == nil || re.MatchString(value)
+}
+
+// copyTo records the registered click IDs in q whose values are valid
+func (c *ClickIDRegistry) copyTo(q url.Values, dst map[string]string) {
+	for _, param := range c.params {
+		if v := strings.TrimSpace(q.Get(param)); v != "" && c.Valid(param, v) {
+			dst[param] = v
+		}
+	}
+}
+
+// ClearClickIDs removes the ad click IDs from e, including the raw query and
+// route query parameters that carry them
+func ClearClickIDs(e *Event) {
+	e.URL.Google.GCLID = ""
+	e.URL.Google.GCLSRC = ""
+	e.URL.Google.GBRAID = ""
+	e.URL.Google.WBRAID = ""
+	e.URL.Meta.FBCLID = ""
+	e.URL.Meta.FBC = ""
+	e.URL.Meta.FBP = ""
+	e.URL.Microsoft.MSCLKID = ""
+	e.URL.OtherIDs = nil
+	e.URL.RawQuery = "" // carries the same click IDs
+
+	for key := range e.Route.Query {
+		if IsClickIDParam(key) {
+			delete(e.Route.Query, key)
+		}
+	}
+}
+
+// IsClickIDParam reports whether the query parameter key carries an ad
+// click ID, typed or registered
+func IsClickIDParam(key string) bool {
+	return clickIDParams[strings.ToLower(key)] || DefaultClickIDs.Has(key)
+}
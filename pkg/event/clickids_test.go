@@ -0,0 +1,98 @@
+package event
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/config"
+)
+
+func TestNewClickIDRegistry(t *testing.T) {
+	c, err := NewClickIDRegistry(strings.NewReader(builtinClickIDs), strings.NewReader(`
+# Snapchat, Reddit, Impact
+sc_cid
+rdt_cid  [A-Za-z0-9_-]{8,}
+irclickid [A-Za-z0-9]+
+ttclid   [0-9a-f]+ # reclassified built-in
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Params(), []string{"ttclid", "li_fat_id", "epik", "twclid", "dclid", "sc_cid", "rdt_cid", "irclickid"}; !slices.Equal(got, want) {
+		t.Errorf("Params() = %v, want %v", got, want)
+	}
+
+	q := url.Values{
+		"sc_cid":    {" snap-1 "},
+		"rdt_cid":   {"short"},
+		"irclickid": {"Abc123"},
+		"ttclid":    {"not-hex"},
+		"epik":      {""},
+		"other":     {"x"},
+	}
+	dst := map[string]string{}
+	c.copyTo(q, dst)
+	if want := map[string]string{"sc_cid": "snap-1", "irclickid": "Abc123"}; len(dst) != len(want) || dst["sc_cid"] != want["sc_cid"] || dst["irclickid"] != want["irclickid"] {
+		t.Errorf("copied %v, want %v", dst, want)
+	}
+
+	if !c.Has("SC_CID") || !c.Has("gclid") || c.Has("other") {
+		t.Error("Has() misreports registered, typed or unknown params")
+	}
+
+	for _, bad := range []string{"gclid\n", "utm_source\n", "x [a-\n", "x a b\n"} {
+		if _, err := NewClickIDRegistry(strings.NewReader(bad)); err == nil {
+			t.Errorf("NewClickIDRegistry(%q): expected error", bad)
+		}
+	}
+}
+
+func TestLoadClickIDRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "click_ids.txt")
+	if err := os.WriteFile(path, []byte("sc_cid\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadClickIDRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(prev *ClickIDRegistry) { DefaultClickIDs = prev }(DefaultClickIDs)
+	DefaultClickIDs = c
+
+	req := httptest.NewRequest(http.MethodGet, "/px.gif?sc_cid=snap-1&ttclid=tt-1", nil)
+	e := &Event{}
+	EnrichServerFields(req, e, config.Config{})
+	if e.URL.OtherIDs["sc_cid"] != "snap-1" || e.URL.OtherIDs["ttclid"] != "tt-1" {
+		t.Errorf("OtherIDs = %v, want sc_cid and the built-in ttclid", e.URL.OtherIDs)
+	}
+
+	if _, err := LoadClickIDRegistry(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("expected error for a missing file")
+	}
+}
+
+func TestClearClickIDs(t *testing.T) {
+	e := &Event{}
+	ApplyPageURL(e, "https://example.com/?gclid=g1&fbclid=f1&msclkid=m1&ttclid=t1&yclid=y1&utm_source=news&plan=pro")
+	e.URL.Meta.FBP = "fb.1.1700000000000.42"
+
+	ClearClickIDs(e)
+	if e.URL.Google != (GoogleAdsInfo{}) || e.URL.Meta != (MetaAdsInfo{}) || e.URL.Microsoft != (MicrosoftAdsInfo{}) || e.URL.OtherIDs != nil {
+		t.Errorf("click IDs kept: %+v", e.URL)
+	}
+	if e.URL.RawQuery != "" {
+		t.Errorf("RawQuery = %q, want empty", e.URL.RawQuery)
+	}
+	if len(e.Route.Query) != 2 || e.Route.Query["utm_source"] != "news" || e.Route.Query["plan"] != "pro" {
+		t.Errorf("Route.Query = %v, want only utm_source and plan", e.Route.Query)
+	}
+	if e.URL.UTM.Source != "news" {
+		t.Errorf("UTM.Source = %q, want news", e.URL.UTM.Source)
+	}
+}
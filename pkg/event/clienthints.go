@@ -0,0 +1,150 @@
+package event
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HighEntropyClientHints are the hints GoTrack asks browsers for with
+// Accept-CH. The low-entropy Sec-CH-UA, Sec-CH-UA-Mobile and
+// Sec-CH-UA-Platform are sent without asking.
+var HighEntropyClientHints = []string{
+	"Sec-CH-UA-Platform-Version",
+	"Sec-CH-UA-Model",
+	"Sec-CH-UA-Full-Version-List",
+}
+
+// applyClientHints fills device fields from User-Agent Client Hints the
+// client didn't supply. Chromium browsers freeze the OS version and device
+// model in the User-Agent, so these run before it is parsed.
+func applyClientHints(h http.Header, e *Event) {
+	d := &e.Device
+
+	if brands := h.Get("Sec-CH-UA"); brands != "" && len(d.UABrands) == 0 {
+		d.UABrands = splitHintList(brands)
+	}
+	if d.UAMobile == nil {
+		switch h.Get("Sec-CH-UA-Mobile") {
+		case "?1":
+			d.UAMobile = boolPtr(true)
+		case "?0":
+			d.UAMobile = boolPtr(false)
+		}
+	}
+
+	platform := hintString(h.Get("Sec-CH-UA-Platform"))
+	if platform != "" && platform != "Unknown" {
+		setIfEmpty(&d.OS, platformNames[platform])
+		setIfEmpty(&d.OS, platform)
+	}
+	if version := hintString(h.Get("Sec-CH-UA-Platform-Version")); version != "" {
+		setIfEmpty(&d.OSVersion, platformVersion(platform, version))
+	}
+	setIfEmpty(&d.DeviceModel, hintString(h.Get("Sec-CH-UA-Model")))
+	if list := h.Get("Sec-CH-UA-Full-Version-List"); list != "" {
+		_, version := mainBrand(splitHintList(list))
+		setIfEmpty(&d.BrowserVersion, version)
+	}
+}
+
+// platformNames maps Sec-CH-UA-Platform values to the User-Agent parser's
+// names where they differ, so both sources group together
+var platformNames = map[string]string{
+	"macOS":       "Mac OS X",
+	"Chromium OS": "Chrome OS",
+}
+
+// platformVersion converts Sec-CH-UA-Platform-Version to the OS version.
+// Windows reports its UniversalApiContract version, 13 and up being
+// Windows 11; others send the OS version with trailing zeros.
+func platformVersion(platform, version string) string {
+	if platform == "Windows" {
+		major, _, _ := strings.Cut(version, ".")
+		n, err := strconv.Atoi(major)
+		switch {
+		case err != nil || n == 0:
+			return "" // Windows 7 to 8.1
+		case n >= 13:
+			return "11"
+		default:
+			return "10"
+		}
+	}
+	for strings.HasSuffix(version, ".0") {
+		version = strings.TrimSuffix(version, ".0")
+	}
+	return version
+}
+
+// mainBrand returns the browser brand and version from a brand list,
+// skipping GREASE entries such as "Not_A Brand" and preferring a browser's
+// own brand over Chromium, which every Chromium browser lists
+func mainBrand(items []string) (brand, version string) {
+	for _, item := range items {
+		name, params, _ := strings.Cut(item, ";")
+		name = hintString(name)
+		v := ""
+		if _, value, ok := strings.Cut(params, "v="); ok {
+			v = hintString(value)
+		}
+		if name == "" || strings.Contains(name, "Brand") {
+			continue
+		}
+		if brand == "" || brand == "Chromium" {
+			brand, version = name, v
+		}
+	}
+	return brand, version
+}
+
+// splitHintList splits a structured header list on the commas between
+// items, keeping the items' quoted strings whole
+func splitHintList(s string) []string {
+	var items []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				items = appendHintItem(items, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return appendHintItem(items, s[start:])
+}
+
+func appendHintItem(items []string, item string) []string {
+	if item = strings.TrimSpace(item); item != "" {
+		items = append(items, item)
+	}
+	return items
+}
+
+// hintString returns the value of a structured header string such as
+// "Windows", unescaping \" and \\
+func hintString(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func boolPtr(b bool) *bool { return &b }
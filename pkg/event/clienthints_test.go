@@ -0,0 +1,100 @@
+package event
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/config"
+)
+
+func TestApplyClientHints(t *testing.T) {
+	tests := []struct {
+		name  string
+		ua    string
+		hints map[string]string
+		want  DeviceInfo
+	}{
+		{
+			"windows 11",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			map[string]string{
+				"Sec-CH-UA":                   `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`,
+				"Sec-CH-UA-Mobile":            "?0",
+				"Sec-CH-UA-Platform":          `"Windows"`,
+				"Sec-CH-UA-Platform-Version":  `"15.0.0"`,
+				"Sec-CH-UA-Full-Version-List": `"Not_A Brand";v="8.0.0.0", "Chromium";v="120.0.6099.130", "Google Chrome";v="120.0.6099.130"`,
+			},
+			DeviceInfo{
+				UABrands: []string{`"Not_A Brand";v="8"`, `"Chromium";v="120"`, `"Google Chrome";v="120"`}, UAMobile: boolPtr(false),
+				OS: "Windows", OSVersion: "11", Browser: "Chrome", BrowserVersion: "120.0.6099.130", DeviceType: DeviceTypeDesktop,
+			},
+		},
+		{
+			"reduced android ua",
+			"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			map[string]string{
+				"Sec-CH-UA-Mobile":           "?1",
+				"Sec-CH-UA-Platform":         `"Android"`,
+				"Sec-CH-UA-Platform-Version": `"14.0.0"`,
+				"Sec-CH-UA-Model":            `"Pixel 7"`,
+			},
+			DeviceInfo{
+				UAMobile: boolPtr(true), OS: "Android", OSVersion: "14", DeviceModel: "Pixel 7",
+				Browser: "Chrome Mobile", BrowserVersion: "120.0.0", DeviceType: DeviceTypeMobile,
+			},
+		},
+		{
+			"macos",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			map[string]string{"Sec-CH-UA-Platform": `"macOS"`, "Sec-CH-UA-Platform-Version": `"14.2.1"`, "Sec-CH-UA-Model": `""`},
+			DeviceInfo{
+				OS: "Mac OS X", OSVersion: "14.2.1", Browser: "Chrome", BrowserVersion: "120.0.0",
+				DeviceBrand: "Apple", DeviceModel: "Mac", DeviceType: DeviceTypeDesktop,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/px.gif", nil)
+			req.Header.Set("User-Agent", tt.ua)
+			for k, v := range tt.hints {
+				req.Header.Set(k, v)
+			}
+			e := &Event{}
+			EnrichServerFields(req, e, config.Config{ParseUserAgent: true})
+			tt.want.UA = tt.ua
+			if !reflect.DeepEqual(e.Device, tt.want) {
+				t.Errorf("Device = %+v\nwant %+v", e.Device, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyClientHints_KeepsClientFields(t *testing.T) {
+	h := http.Header{}
+	h.Set("Sec-CH-UA", `"Chromium";v="120"`)
+	h.Set("Sec-CH-UA-Mobile", "?1")
+	h.Set("Sec-CH-UA-Model", `"Pixel 7"`)
+	e := &Event{Device: DeviceInfo{UABrands: []string{"Chromium 121"}, UAMobile: boolPtr(false), DeviceModel: "Pixel 8"}}
+	applyClientHints(h, e)
+	if len(e.Device.UABrands) != 1 || e.Device.UABrands[0] != "Chromium 121" || *e.Device.UAMobile || e.Device.DeviceModel != "Pixel 8" {
+		t.Errorf("client fields overwritten: %+v", e.Device)
+	}
+}
+
+func TestHintString(t *testing.T) {
+	tests := map[string]string{
+		`"Windows"`:        "Windows",
+		` "Pixel 7" `:      "Pixel 7",
+		`""`:               "",
+		`"say \"hi\" \\ "`: `say "hi" \ `,
+		`?1`:               "?1",
+	}
+	for in, want := range tests {
+		if got := hintString(in); got != want {
+			t.Errorf("hintString(%s) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package event
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/shortontech/gotrack/pkg/config"
+)
+
+// ClientIP returns the address of the client behind r. Forwarding headers
+// are only read when the direct peer is a trusted proxy (see TrustedPeer).
+// X-Forwarded-For is then walked right to left, past every hop inside
+// TRUSTED_PROXY_CIDRS, to the first one outside them; without CIDRs every
+// hop is trusted and the leftmost one is the client.
+func ClientIP(r *http.Request, cfg config.Config) string {
+	peer := remoteHost(r)
+	trusted := trustedProxies(cfg)
+	if !trustedPeer(r, cfg, trusted) {
+		return peer
+	}
+
+	if xff := strings.Join(r.Header.Values("X-Forwarded-For"), ","); strings.TrimSpace(xff) != "" {
+		hops := strings.Split(xff, ",")
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			addr, err := netip.ParseAddr(hop)
+			if err != nil {
+				// A malformed hop was not written by a trusted proxy, so
+				// the last address checked is the best we know
+				break
+			}
+			client = hop
+			if len(trusted) > 0 && !containsAddr(trusted, addr) {
+				break
+			}
+		}
+		return client
+	}
+	if xrip := strings.TrimSpace(r.Header.Get("X-Real-IP")); xrip != "" {
+		return xrip
+	}
+	return peer
+}
+
+// TrustedPeer reports whether r's direct peer may set forwarding headers.
+// With TRUSTED_PROXY_CIDRS only peers inside those ranges are trusted,
+// whatever TRUST_PROXY says; otherwise TRUST_PROXY trusts every peer.
+func TrustedPeer(r *http.Request, cfg config.Config) bool {
+	return trustedPeer(r, cfg, trustedProxies(cfg))
+}
+
+func trustedPeer(r *http.Request, cfg config.Config, trusted []netip.Prefix) bool {
+	if len(cfg.TrustedProxyCIDRs) == 0 {
+		return cfg.TrustProxy
+	}
+	addr, err := netip.ParseAddr(remoteHost(r))
+	return err == nil && containsAddr(trusted, addr)
+}
+
+// ParseTrustedProxies parses TRUSTED_PROXY_CIDRS entries. A bare address
+// is a single-host range.
+func ParseTrustedProxies(cidrs []string) ([]netip.Prefix, error) {
+	return config.ParseTrustedProxies(cidrs)
+}
+
+// trustedProxies returns the parsed TRUSTED_PROXY_CIDRS, parsing them only
+// for a Config that wasn't made by config.Load
+func trustedProxies(cfg config.Config) []netip.Prefix {
+	if cfg.TrustedProxies != nil || len(cfg.TrustedProxyCIDRs) == 0 {
+		return cfg.TrustedProxies
+	}
+	trusted, _ := config.ParseTrustedProxies(cfg.TrustedProxyCIDRs)
+	return trusted
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost returns r.RemoteAddr without its port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err == nil && host != "" {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,54 @@
+package detection
+
+import (
+	"net/http"
+	"time"
+)
+
+// AnalyzeServerDetectionSignals performs comprehensive server-side detection data collection
+func AnalyzeServerDetectionSignals(r *http.Request, body []byte) ServerDetectionSignals {
+	return AnalyzeServerDetectionSignalsWithTracker(r, body, DefaultTracker)
+}
+
+// AnalyzeServerDetectionSignalsForIP performs detection counting request
+// timing and rates by clientIP, the address event.ClientIP resolved
+func AnalyzeServerDetectionSignalsForIP(r *http.Request, body []byte, clientIP string) ServerDetectionSignals {
+	return analyzeServerDetectionSignals(r, body, hostIP(clientIP), DefaultTracker)
+}
+
+// AnalyzeServerDetectionSignalsWithTracker performs detection with a custom timing tracker
+// This allows for dependency injection and better testability. Timing and
+// rates are counted by the direct peer's address.
+func AnalyzeServerDetectionSignalsWithTracker(
+	r *http.Request,
+	body []byte,
+	tracker TimingTracker,
+) ServerDetectionSignals {
+	return analyzeServerDetectionSignals(r, body, peerIP(r), tracker)
+}
+
+func analyzeServerDetectionSignals(
+	r *http.Request,
+	body []byte,
+	clientIP string,
+	tracker TimingTracker,
+) ServerDetectionSignals {
+	signals := ServerDetectionSignals{}
+
+	// Analyze HTTP headers
+	signals.HeaderAnalysis = analyzeHeaders(r.Header)
+	signals.HeaderFingerprint = generateHeaderFingerprint(r.Header)
+	signals.TLSFingerprint = generateTLSFingerprint(r.TLS)
+	if hello := ClientHelloFromContext(r.Context()); hello != nil {
+		signals.JA3, signals.JA4 = hello.JA3(), hello.JA4()
+	}
+
+	// Analyze request payload
+	signals.RequestAnalysis = analyzeRequest(r, body)
+
+	// Analyze timing patterns
+	signals.TimingAnalysis = analyzeTimingPatterns(clientIP, tracker)
+	analyzeRates(&signals.TimingAnalysis, DefaultRateTracker, clientIP, signals.HeaderFingerprint, time.Now())
+
+	return signals
+}
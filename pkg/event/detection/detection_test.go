@@ -0,0 +1,1070 @@
+package detection
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/shortontech/gotrack/internal/kv"
+)
+
+func TestAnalyzeHeaders(t *testing.T) {
+	t.Run("detects missing expected headers", func(t *testing.T) {
+		headers := http.Header{}
+		headers.Set("Content-Type", "application/json")
+
+		analysis := analyzeHeaders(headers)
+
+		if len(analysis.MissingExpected) != 4 {
+			t.Errorf("expected 4 missing headers, got %d", len(analysis.MissingExpected))
+		}
+		expectedMissing := []string{"User-Agent", "Accept", "Accept-Language", "Accept-Encoding"}
+		for _, expected := range expectedMissing {
+			found := false
+			for _, missing := range analysis.MissingExpected {
+				if missing == expected {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("expected %s to be in missing headers", expected)
+			}
+		}
+	})
+
+	t.Run("detects automation headers", func(t *testing.T) {
+		headers := http.Header{}
+		headers.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 HeadlessChrome/90.0")
+		headers.Set("Accept", "*/*")
+		headers.Set("Accept-Language", "en-US")
+		headers.Set("Accept-Encoding", "gzip")
+
+		analysis := analyzeHeaders(headers)
+
+		if len(analysis.AutomationHeaders) == 0 {
+			t.Error("expected automation headers to be detected")
+		}
+	})
+
+	t.Run("counts headers correctly", func(t *testing.T) {
+		headers := http.Header{}
+		headers.Set("User-Agent", "Mozilla/5.0")
+		headers.Set("Accept", "text/html")
+		headers.Set("Accept-Language", "en-US")
+
+		analysis := analyzeHeaders(headers)
+
+		if analysis.HeaderCount != 3 {
+			t.Errorf("expected header count 3, got %d", analysis.HeaderCount)
+		}
+	})
+
+	t.Run("orders headers alphabetically", func(t *testing.T) {
+		headers := http.Header{}
+		headers.Set("User-Agent", "test")
+		headers.Set("Accept", "test")
+		headers.Set("Content-Type", "test")
+
+		analysis := analyzeHeaders(headers)
+
+		if len(analysis.HeaderOrder) != 3 {
+			t.Fatalf("expected 3 headers in order, got %d", len(analysis.HeaderOrder))
+		}
+
+		// Should be sorted alphabetically
+		expected := []string{"accept", "content-type", "user-agent"}
+		for i, header := range expected {
+			if analysis.HeaderOrder[i] != header {
+				t.Errorf("expected header[%d] = %s, got %s", i, header, analysis.HeaderOrder[i])
+			}
+		}
+	})
+}
+
+func TestDetectAutomationHeaders(t *testing.T) {
+	tests := []struct {
+		name         string
+		headers      http.Header
+		expectDetect bool
+		description  string
+	}{
+		{
+			name: "detects selenium in User-Agent",
+			headers: http.Header{
+				"User-Agent": []string{"Mozilla/5.0 (X11; Linux x86_64) selenium webdriver"},
+			},
+			expectDetect: true,
+			description:  "selenium keyword in user agent",
+		},
+		{
+			name: "detects puppeteer in custom header",
+			headers: http.Header{
+				"X-Custom": []string{"automated with puppeteer"},
+			},
+			expectDetect: true,
+			description:  "puppeteer keyword in custom header",
+		},
+		{
+			name: "detects headless in User-Agent",
+			headers: http.Header{
+				"User-Agent": []string{"Mozilla/5.0 (X11; Linux x86_64) HeadlessChrome/90.0"},
+			},
+			expectDetect: true,
+			description:  "headless keyword",
+		},
+		{
+			name: "normal headers not detected",
+			headers: http.Header{
+				"User-Agent": []string{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/91.0"},
+			},
+			expectDetect: false,
+			description:  "normal Chrome user agent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := detectAutomationHeaders(tt.headers)
+			detected := len(result) > 0
+
+			if detected != tt.expectDetect {
+				t.Errorf("expected detection=%v for %s, got %v (results: %v)",
+					tt.expectDetect, tt.description, detected, result)
+			}
+		})
+	}
+}
+
+func TestAnalyzeUserAgent(t *testing.T) {
+	tests := []struct {
+		name             string
+		userAgent        string
+		expectAutomation bool
+		expectedPlatform string
+		expectedBrowser  string
+	}{
+		{
+			name:             "Chrome on Windows",
+			userAgent:        "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
+			expectAutomation: false,
+			expectedPlatform: "Windows",
+			expectedBrowser:  "Chrome",
+		},
+		{
+			name:             "Firefox on macOS",
+			userAgent:        "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:89.0) Gecko/20100101 Firefox/89.0",
+			expectAutomation: false,
+			expectedPlatform: "macOS",
+			expectedBrowser:  "Firefox",
+		},
+		{
+			name:             "Selenium WebDriver",
+			userAgent:        "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36 selenium",
+			expectAutomation: true,
+			expectedPlatform: "Linux",
+			expectedBrowser:  "Chrome",
+		},
+		{
+			name:             "Puppeteer",
+			userAgent:        "Mozilla/5.0 puppeteer",
+			expectAutomation: true,
+			expectedPlatform: "",
+			expectedBrowser:  "",
+		},
+		{
+			name:             "Mobile Safari iOS",
+			userAgent:        "Mozilla/5.0 (iPhone; CPU iPhone OS 14_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1.1 Mobile/15E148 Safari/604.1",
+			expectAutomation: false,
+			expectedPlatform: "iOS",
+			expectedBrowser:  "Safari",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := analyzeUserAgent(tt.userAgent)
+
+			if analysis.ContainsAutomation != tt.expectAutomation {
+				t.Errorf("expected automation=%v, got %v", tt.expectAutomation, analysis.ContainsAutomation)
+			}
+			if analysis.Platform != tt.expectedPlatform {
+				t.Errorf("expected platform=%s, got %s", tt.expectedPlatform, analysis.Platform)
+			}
+			if analysis.Browser != tt.expectedBrowser {
+				t.Errorf("expected browser=%s, got %s", tt.expectedBrowser, analysis.Browser)
+			}
+			if analysis.Length != len(tt.userAgent) {
+				t.Errorf("expected length=%d, got %d", len(tt.userAgent), analysis.Length)
+			}
+		})
+	}
+}
+
+func TestCalculateEntropy(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected float64
+		delta    float64
+	}{
+		{
+			name:     "empty data",
+			data:     []byte{},
+			expected: 0.0,
+			delta:    0.001,
+		},
+		{
+			name:     "all same byte",
+			data:     []byte{0x41, 0x41, 0x41, 0x41},
+			expected: 0.0,
+			delta:    0.001,
+		},
+		{
+			name:     "high entropy random-like",
+			data:     []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07},
+			expected: 3.0, // Should be exactly 3.0 for 8 different bytes
+			delta:    0.001,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := calculateEntropy(tt.data)
+			if result < tt.expected-tt.delta || result > tt.expected+tt.delta {
+				t.Errorf("expected entropy ~%f, got %f", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestIsLanguageUAInconsistent(t *testing.T) {
+	tests := []struct {
+		name           string
+		userAgent      string
+		acceptLanguage string
+		expectMismatch bool
+	}{
+		{
+			name:           "consistent en-US",
+			userAgent:      "Mozilla/5.0 (Windows NT 10.0)",
+			acceptLanguage: "en-US,en;q=0.9",
+			expectMismatch: false,
+		},
+		{
+			name:           "Chinese UA without Chinese language",
+			userAgent:      "Mozilla/5.0 (zh-CN)",
+			acceptLanguage: "en-US",
+			expectMismatch: true,
+		},
+		{
+			name:           "Japanese UA without Japanese language",
+			userAgent:      "Mozilla/5.0 (ja)",
+			acceptLanguage: "en-US",
+			expectMismatch: true,
+		},
+		{
+			name:           "Korean UA without Korean language",
+			userAgent:      "Mozilla/5.0 (ko)",
+			acceptLanguage: "en-US",
+			expectMismatch: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := isLanguageUAInconsistent(tt.userAgent, tt.acceptLanguage)
+			if result != tt.expectMismatch {
+				t.Errorf("expected mismatch=%v, got %v", tt.expectMismatch, result)
+			}
+		})
+	}
+}
+
+func TestPeerIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		expectedIP string
+	}{
+		{name: "strips the port", remoteAddr: "192.168.1.1:8080", expectedIP: "192.168.1.1"},
+		{name: "strips the port from IPv6", remoteAddr: "[2001:db8::1]:8080", expectedIP: "2001:db8::1"},
+		{name: "keeps an address without port", remoteAddr: "203.0.113.42", expectedIP: "203.0.113.42"},
+		{name: "ignores X-Forwarded-For", remoteAddr: "10.0.0.1:8080", xff: "203.0.113.1", expectedIP: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+
+			if result := peerIP(req); result != tt.expectedIP {
+				t.Errorf("expected IP %s, got %s", tt.expectedIP, result)
+			}
+		})
+	}
+}
+
+func TestMemoryTimingTracker(t *testing.T) {
+	t.Run("records and retrieves request", func(t *testing.T) {
+		tracker := NewMemoryTimingTracker()
+		ip := "192.168.1.1"
+		timestamp := time.Now()
+
+		tracker.RecordRequest(ip, timestamp)
+
+		lastTime, exists := tracker.GetLastRequest(ip)
+		if !exists {
+			t.Error("expected request to exist")
+		}
+		if !lastTime.Equal(timestamp) {
+			t.Errorf("expected timestamp %v, got %v", timestamp, lastTime)
+		}
+	})
+
+	t.Run("returns false for non-existent IP", func(t *testing.T) {
+		tracker := NewMemoryTimingTracker()
+
+		_, exists := tracker.GetLastRequest("192.168.1.1")
+		if exists {
+			t.Error("expected request to not exist")
+		}
+	})
+
+	t.Run("concurrent access is safe", func(t *testing.T) {
+		tracker := NewMemoryTimingTracker()
+		done := make(chan bool)
+
+		// Concurrent writes
+		for i := 0; i < 100; i++ {
+			go func(id int) {
+				ip := "192.168.1." + string(rune('0'+id%10))
+				tracker.RecordRequest(ip, time.Now())
+				done <- true
+			}(i)
+		}
+
+		// Wait for all goroutines
+		for i := 0; i < 100; i++ {
+			<-done
+		}
+
+		// Should not panic and should have some entries
+		_, exists := tracker.GetLastRequest("192.168.1.0")
+		if !exists {
+			t.Error("expected at least one IP to be tracked")
+		}
+	})
+}
+
+func TestMemoryTimingTrackerTTL(t *testing.T) {
+	t.Run("expired entries are not returned", func(t *testing.T) {
+		tracker := NewMemoryTimingTrackerWithTTL(time.Minute)
+		tracker.RecordRequest("192.168.1.1", time.Now().Add(-2*time.Minute))
+
+		if _, exists := tracker.GetLastRequest("192.168.1.1"); exists {
+			t.Error("expected expired entry to be ignored")
+		}
+	})
+
+	t.Run("expired entries are evicted on sweep", func(t *testing.T) {
+		tracker := NewMemoryTimingTrackerWithTTL(time.Minute)
+		tracker.RecordRequest("192.168.1.1", time.Now().Add(-2*time.Minute))
+
+		// Force the next record to trigger a sweep
+		tracker.lastSweep = time.Now().Add(-2 * time.Minute)
+		tracker.RecordRequest("192.168.1.2", time.Now())
+
+		if tracker.Len() != 1 {
+			t.Errorf("expected 1 tracked IP after eviction, got %d", tracker.Len())
+		}
+	})
+}
+
+func TestStoreTimingTracker(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := kv.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	defer store.Close()
+
+	t.Run("records and retrieves request", func(t *testing.T) {
+		tracker := NewStoreTimingTracker(store, time.Minute)
+		timestamp := time.Now()
+
+		tracker.RecordRequest("192.168.1.1", timestamp)
+
+		lastTime, exists := tracker.GetLastRequest("192.168.1.1")
+		if !exists {
+			t.Fatal("expected request to exist")
+		}
+		if !lastTime.Equal(time.Unix(0, timestamp.UnixNano())) {
+			t.Errorf("expected timestamp %v, got %v", timestamp, lastTime)
+		}
+	})
+
+	t.Run("returns false for non-existent IP", func(t *testing.T) {
+		tracker := NewStoreTimingTracker(store, time.Minute)
+
+		if _, exists := tracker.GetLastRequest("10.0.0.1"); exists {
+			t.Error("expected request to not exist")
+		}
+	})
+
+	t.Run("entries expire after TTL", func(t *testing.T) {
+		tracker := NewStoreTimingTracker(store, time.Minute)
+		tracker.RecordRequest("192.168.1.3", time.Now())
+
+		mr.FastForward(2 * time.Minute)
+
+		if _, exists := tracker.GetLastRequest("192.168.1.3"); exists {
+			t.Error("expected entry to expire")
+		}
+	})
+
+	t.Run("shared across tracker instances", func(t *testing.T) {
+		replicaA := NewStoreTimingTracker(store, time.Minute)
+		replicaB := NewStoreTimingTracker(store, time.Minute)
+		replicaA.RecordRequest("192.168.1.4", time.Now())
+
+		if _, exists := replicaB.GetLastRequest("192.168.1.4"); !exists {
+			t.Error("expected second replica to see first replica's request")
+		}
+	})
+
+	t.Run("works with the in-memory store", func(t *testing.T) {
+		tracker := NewStoreTimingTracker(kv.NewMemoryStore(), time.Minute)
+		tracker.RecordRequest("192.168.1.6", time.Now())
+
+		if _, exists := tracker.GetLastRequest("192.168.1.6"); !exists {
+			t.Error("expected request to exist")
+		}
+	})
+
+	t.Run("unavailable redis degrades to no previous request", func(t *testing.T) {
+		down := kv.NewRedisStore(redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}))
+		defer down.Close()
+		tracker := NewStoreTimingTracker(down, time.Minute)
+
+		tracker.RecordRequest("192.168.1.5", time.Now())
+		if _, exists := tracker.GetLastRequest("192.168.1.5"); exists {
+			t.Error("expected no result when redis is unavailable")
+		}
+	})
+}
+
+func TestTrapTrackers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := kv.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	defer store.Close()
+
+	for name, tracker := range map[string]TrapTracker{
+		"memory": NewMemoryTrapTracker(time.Minute),
+		"store":  NewStoreTrapTracker(store, time.Minute),
+	} {
+		t.Run(name, func(t *testing.T) {
+			tracker.RecordTrap("203.0.113.7", "fp-bot")
+			if !tracker.Trapped("203.0.113.7", "") {
+				t.Error("trapped IP not flagged")
+			}
+			// A bot that moved to another IP is still caught by its headers
+			if !tracker.Trapped("198.51.100.1", "fp-bot") {
+				t.Error("trapped fingerprint not flagged")
+			}
+			if tracker.Trapped("198.51.100.1", "fp-browser") || tracker.Trapped("", "") {
+				t.Error("untrapped client flagged")
+			}
+		})
+	}
+
+	t.Run("store entries expire after TTL", func(t *testing.T) {
+		tracker := NewStoreTrapTracker(store, time.Minute)
+		tracker.RecordTrap("192.0.2.1", "")
+		mr.FastForward(2 * time.Minute)
+		if tracker.Trapped("192.0.2.1", "") {
+			t.Error("expected entry to expire")
+		}
+	})
+}
+
+func TestRateTrackers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := kv.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	defer store.Close()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, tracker := range map[string]RateTracker{
+		"memory": NewMemoryRateTracker(100),
+		"store":  NewStoreRateTracker(store),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var rate RequestRate
+			for i := range 12 {
+				rate, _ = tracker.RecordRate("ip:burst", start.Add(time.Duration(i)*100*time.Millisecond))
+			}
+			if rate != (RequestRate{Last10s: 12, Last1m: 12, Last10m: 12}) || !bursty(rate) {
+				t.Errorf("burst rate = %+v", rate)
+			}
+			// Counts from the previous window fade as the sliding window moves on
+			rate, _ = tracker.RecordRate("ip:burst", start.Add(15*time.Second))
+			if rate.Last10s != 7 || rate.Last1m != 13 {
+				t.Errorf("rate after 15s = %+v", rate)
+			}
+			if rate, _ = tracker.RecordRate("ip:other", start); rate.Last10m != 1 {
+				t.Errorf("other key rate = %+v", rate)
+			}
+		})
+	}
+
+	t.Run("periodic", func(t *testing.T) {
+		tracker := NewMemoryRateTracker(100)
+		var periodic bool
+		for i := range 6 {
+			_, periodic = tracker.RecordRate("fp:timer", start.Add(time.Duration(i)*30*time.Second))
+		}
+		if !periodic {
+			t.Error("requests every 30s not flagged")
+		}
+		for _, at := range []int{0, 3, 44, 53, 80, 95} {
+			_, periodic = tracker.RecordRate("fp:person", start.Add(time.Duration(at)*time.Second))
+		}
+		if periodic {
+			t.Error("irregular requests flagged")
+		}
+	})
+
+	t.Run("analysis counts IP and fingerprint", func(t *testing.T) {
+		saved := DefaultRateTracker
+		DefaultRateTracker = NewMemoryRateTracker(100)
+		defer func() { DefaultRateTracker = saved }()
+
+		var signals ServerDetectionSignals
+		for _, remote := range []string{"192.0.2.1:1234", "192.0.2.2:1234"} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = remote
+			signals = AnalyzeServerDetectionSignalsWithTracker(req, nil, NewMemoryTimingTracker())
+		}
+		// Same headers from a second IP: a new IP, the same fingerprint
+		timing := signals.TimingAnalysis
+		if timing.IPRate == nil || timing.IPRate.Last1m != 1 || timing.FingerprintRate == nil || timing.FingerprintRate.Last1m != 2 {
+			t.Errorf("timing analysis = %+v", signals.TimingAnalysis)
+		}
+	})
+
+	t.Run("ports of one address share a count", func(t *testing.T) {
+		saved := DefaultRateTracker
+		DefaultRateTracker = NewMemoryRateTracker(100)
+		defer func() { DefaultRateTracker = saved }()
+
+		tracker := NewMemoryTimingTracker()
+		var signals ServerDetectionSignals
+		for _, remote := range []string{"192.0.2.1:1234", "192.0.2.1:5678"} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = remote
+			signals = AnalyzeServerDetectionSignalsWithTracker(req, nil, tracker)
+		}
+		timing := signals.TimingAnalysis
+		if timing.IPRate == nil || timing.IPRate.Last1m != 2 || !timing.HasPreviousRequest {
+			t.Errorf("timing analysis = %+v", signals.TimingAnalysis)
+		}
+
+		// A client address resolved from a proxy may carry a port as well
+		req := httptest.NewRequest("GET", "/", nil)
+		signals = AnalyzeServerDetectionSignalsForIP(req, nil, "192.0.2.1:9999")
+		if rate := signals.TimingAnalysis.IPRate; rate == nil || rate.Last1m != 3 {
+			t.Errorf("ip rate = %+v, want 3 requests", rate)
+		}
+	})
+
+	t.Run("memory tracker stays within its bound", func(t *testing.T) {
+		tracker := NewMemoryRateTracker(10)
+		for i := range 50 {
+			tracker.RecordRate(fmt.Sprintf("ip:%d", i), start)
+		}
+		if n := tracker.Len(); n > 10 {
+			t.Errorf("Len() = %d, want at most 10", n)
+		}
+		// Idle keys are swept once the longest window has passed
+		tracker.RecordRate("ip:late", start.Add(time.Hour))
+		if n := tracker.Len(); n != 1 {
+			t.Errorf("Len() after an hour = %d, want 1", n)
+		}
+	})
+}
+
+func TestFingerprintTrackers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := kv.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	defer store.Close()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, tracker := range map[string]FingerprintTracker{
+		"memory": NewMemoryFingerprintTracker(time.Hour, 100),
+		"store":  NewStoreFingerprintTracker(store, time.Hour),
+	} {
+		t.Run(name, func(t *testing.T) {
+			tracker.ObserveFingerprint("fp-rotating", "203.0.113.1", start)
+			tracker.ObserveFingerprint("fp-rotating", "203.0.113.1", start.Add(time.Second))
+			var stats FingerprintStats
+			for i := range 5 {
+				stats = tracker.ObserveFingerprint("fp-rotating", fmt.Sprintf("198.51.100.%d", i), start.Add(time.Minute))
+			}
+			if !stats.FirstSeen.Equal(start) || stats.Requests != 7 || stats.IPs != 6 {
+				t.Errorf("stats = %+v", stats)
+			}
+			if stats = tracker.ObserveFingerprint("fp-other", "203.0.113.1", start); stats.Requests != 1 || stats.IPs != 1 {
+				t.Errorf("other fingerprint stats = %+v", stats)
+			}
+		})
+	}
+
+	t.Run("IP count is capped", func(t *testing.T) {
+		tracker := NewMemoryFingerprintTracker(time.Hour, 100)
+		var stats FingerprintStats
+		for i := range MaxFingerprintIPs + 20 {
+			stats = tracker.ObserveFingerprint("fp", fmt.Sprintf("10.0.%d.%d", i/256, i%256), start)
+		}
+		if stats.IPs != MaxFingerprintIPs {
+			t.Errorf("IPs = %d, want %d", stats.IPs, MaxFingerprintIPs)
+		}
+	})
+
+	t.Run("memory tracker forgets idle fingerprints", func(t *testing.T) {
+		tracker := NewMemoryFingerprintTracker(time.Hour, 10)
+		for i := range 50 {
+			tracker.ObserveFingerprint(fmt.Sprintf("fp-%d", i), "", start)
+		}
+		if n := tracker.Len(); n > 10 {
+			t.Errorf("Len() = %d, want at most 10", n)
+		}
+		tracker.ObserveFingerprint("fp-late", "", start.Add(2*time.Hour))
+		if n := tracker.Len(); n != 1 {
+			t.Errorf("Len() after two hours = %d, want 1", n)
+		}
+		if stats := tracker.ObserveFingerprint("fp-late", "", start.Add(4*time.Hour)); stats.Requests != 1 {
+			t.Errorf("expired fingerprint stats = %+v", stats)
+		}
+	})
+
+	t.Run("signals", func(t *testing.T) {
+		saved := DefaultFingerprintTracker
+		DefaultFingerprintTracker = NewMemoryFingerprintTracker(time.Hour, 100)
+		defer func() { DefaultFingerprintTracker = saved }()
+
+		signals := ServerDetectionSignals{HeaderFingerprint: "fp"}
+		signals.ObserveFingerprint("203.0.113.1", start)
+		signals.ObserveFingerprint("203.0.113.2", start.Add(90*time.Second))
+		if signals.FingerprintAge != 90 || signals.FingerprintRequests != 2 || signals.IPCount != 2 {
+			t.Errorf("signals = %+v", signals)
+		}
+	})
+}
+
+func TestAnalyzeTimingPatterns(t *testing.T) {
+	t.Run("first request has no previous", func(t *testing.T) {
+		tracker := NewMemoryTimingTracker()
+
+		analysis := analyzeTimingPatterns("192.168.1.1", tracker)
+
+		if analysis.HasPreviousRequest {
+			t.Error("expected no previous request")
+		}
+		if analysis.RequestInterval != 0 {
+			t.Error("expected zero interval")
+		}
+	})
+
+	t.Run("second request calculates interval", func(t *testing.T) {
+		tracker := NewMemoryTimingTracker()
+
+		// First request
+		analyzeTimingPatterns("192.168.1.1", tracker)
+
+		// Wait a bit
+		time.Sleep(10 * time.Millisecond)
+
+		// Second request
+		analysis := analyzeTimingPatterns("192.168.1.1", tracker)
+
+		if !analysis.HasPreviousRequest {
+			t.Error("expected previous request to exist")
+		}
+		if analysis.RequestInterval == 0 {
+			t.Error("expected non-zero interval")
+		}
+		if analysis.RequestsPerSecond == 0 {
+			t.Error("expected non-zero requests per second")
+		}
+	})
+
+	t.Run("detects round interval precision", func(t *testing.T) {
+		tracker := NewMemoryTimingTracker()
+		ip := "192.168.1.1"
+
+		// Manually set last request time to exactly 100ms ago
+		now := time.Now()
+		past := now.Add(-100 * time.Millisecond)
+		tracker.RecordRequest(ip, past)
+
+		// Simulate request at exact 100ms interval
+		analysis := analyzeTimingPatterns(ip, tracker)
+
+		// The precision detection might not be exactly 100 due to timing,
+		// but it should detect some precision
+		if analysis.IntervalPrecision == 0 && analysis.HasPreviousRequest {
+			// This is okay - timing might not be exact in tests
+			t.Logf("Note: interval precision was 0 (interval: %f ms)", analysis.RequestInterval)
+		}
+	})
+}
+
+func TestGenerateTLSFingerprint(t *testing.T) {
+	t.Run("empty for plaintext connections", func(t *testing.T) {
+		if fp := generateTLSFingerprint(nil); fp != "" {
+			t.Errorf("expected empty fingerprint, got %q", fp)
+		}
+	})
+
+	t.Run("same parameters produce same fingerprint", func(t *testing.T) {
+		a := &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, NegotiatedProtocol: "h2", ServerName: "example.com"}
+		b := &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, NegotiatedProtocol: "h2", ServerName: "other.com"}
+		if generateTLSFingerprint(a) != generateTLSFingerprint(b) {
+			t.Error("expected identical fingerprints for identical TLS parameters")
+		}
+		if len(generateTLSFingerprint(a)) != 16 {
+			t.Errorf("expected 16 hex chars, got %d", len(generateTLSFingerprint(a)))
+		}
+	})
+
+	t.Run("different cipher produces different fingerprint", func(t *testing.T) {
+		a := &tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+		b := &tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+		if generateTLSFingerprint(a) == generateTLSFingerprint(b) {
+			t.Error("expected different fingerprints for different cipher suites")
+		}
+	})
+}
+
+type testExtension struct {
+	typ  uint16
+	data []byte
+}
+
+func u16s(values ...uint16) []byte {
+	b := make([]byte, 0, 2*len(values))
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+func vec8(b []byte) []byte  { return append([]byte{byte(len(b))}, b...) }
+func vec16(b []byte) []byte { return append(u16s(uint16(len(b))), b...) }
+
+// clientHelloMessage builds a ClientHello handshake message
+func clientHelloMessage(ciphers []uint16, exts []testExtension) []byte {
+	body := u16s(0x0303)
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, vec8(nil)...)        // session ID
+	body = append(body, vec16(u16s(ciphers...))...)
+	body = append(body, vec8([]byte{0})...) // null compression
+	var ext []byte
+	for _, e := range exts {
+		ext = append(ext, u16s(e.typ)...)
+		ext = append(ext, vec16(e.data)...)
+	}
+	body = append(body, vec16(ext)...)
+	return append([]byte{1, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+// browserHello mirrors the example in the JA4 specification, plus GREASE
+func browserHello() []byte {
+	sni := vec16(append([]byte{0}, vec16([]byte("example.com"))...))
+	alpn := vec16(append(vec8([]byte("h2")), vec8([]byte("http/1.1"))...))
+	return clientHelloMessage(
+		[]uint16{0x0a0a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+		[]testExtension{
+			{0x1a1a, nil},
+			{0x0000, sni},
+			{0x0017, nil},
+			{0xff01, []byte{0}},
+			{0x000a, vec16(u16s(0x001d, 0x0017, 0x0018))},
+			{0x000b, vec8([]byte{0})},
+			{0x0023, nil},
+			{0x0010, alpn},
+			{0x0005, []byte{1, 0, 0, 0, 0}},
+			{0x000d, vec16(u16s(0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601))},
+			{0x0012, nil},
+			{0x0033, vec16(nil)},
+			{0x002d, vec8([]byte{1})},
+			{0x002b, vec8(u16s(0x2a2a, 0x0304, 0x0303))},
+			{0x001b, []byte{2, 0, 2}},
+			{0x0015, make([]byte, 8)},
+			{0x4469, nil},
+		},
+	)
+}
+
+func TestClientHelloFingerprints(t *testing.T) {
+	h, err := ParseClientHello(browserHello())
+	if err != nil {
+		t.Fatalf("ParseClientHello() error = %v", err)
+	}
+	if h.ServerName != "example.com" || len(h.ALPN) != 2 || h.ALPN[0] != "h2" {
+		t.Errorf("parsed SNI %q, ALPN %v", h.ServerName, h.ALPN)
+	}
+
+	wantJA3 := "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53," +
+		"0-23-65281-10-11-35-16-5-13-18-51-45-43-27-21-17513,29-23-24,0"
+	if got := h.ja3String(); got != wantJA3 {
+		t.Errorf("ja3String() = %q\nwant %q", got, wantJA3)
+	}
+	if len(h.JA3()) != 32 {
+		t.Errorf("JA3() = %q, want an MD5 hex digest", h.JA3())
+	}
+	if got := h.JA4(); got != "t13d1516h2_8daaf6152771_e5627efa2ab1" {
+		t.Errorf("JA4() = %q", got)
+	}
+
+	t.Run("minimal hello", func(t *testing.T) {
+		h, err := ParseClientHello(clientHelloMessage([]uint16{0x002f}, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := h.JA4(); got != "t12i010000_"+ja4Hash("002f")+"_000000000000" {
+			t.Errorf("JA4() = %q", got)
+		}
+	})
+
+	t.Run("ALPN without alphanumeric ends", func(t *testing.T) {
+		if got := ja4ALPN([]string{"\xabh2"}); got != "a2" {
+			t.Errorf("ja4ALPN() = %q, want a2 from the hex form ab6832", got)
+		}
+	})
+
+	t.Run("rejects malformed input", func(t *testing.T) {
+		msg := browserHello()
+		for _, bad := range [][]byte{nil, {2, 0, 0, 0}, msg[:40], append(msg[:len(msg)-3:len(msg)-3], 0, 9, 9)} {
+			if _, err := ParseClientHello(bad); err == nil {
+				t.Errorf("ParseClientHello(% x) expected error", bad)
+			}
+		}
+	})
+}
+
+func TestHelloConnRecord(t *testing.T) {
+	msg := browserHello()
+	// Split the message over two records, delivered in small reads
+	records := append([]byte{22, 3, 1}, vec16(msg[:100])...)
+	records = append(records, append([]byte{22, 3, 1}, vec16(msg[100:])...)...)
+	handshake := len(records)
+	records = append(records, 23, 3, 3, 0, 1, 0) // application data must not be kept
+
+	c := &helloConn{}
+	for i := 0; i < len(records); i += 7 {
+		if i < handshake && c.clientHello() != nil {
+			t.Fatalf("hello available after %d of %d bytes", i, len(records))
+		}
+		c.record(records[i:min(i+7, len(records))])
+	}
+	h := c.clientHello()
+	if h == nil || h.JA4() != "t13d1516h2_8daaf6152771_e5627efa2ab1" {
+		t.Fatalf("clientHello() = %+v", h)
+	}
+
+	plain := &helloConn{}
+	plain.record([]byte("GET / HTTP/1.1\r\n"))
+	if plain.clientHello() != nil {
+		t.Error("plaintext connection produced a hello")
+	}
+}
+
+func TestClientHelloListener(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signals := AnalyzeServerDetectionSignalsWithTracker(r, nil, NewMemoryTimingTracker())
+		_, _ = io.WriteString(w, signals.JA3+" "+signals.JA4)
+	}))
+	srv.Listener = ListenClientHellos(srv.Listener)
+	srv.Config.ConnContext = ClientHelloContext
+	srv.StartTLS()
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	ja3, ja4, _ := strings.Cut(string(body), " ")
+	if len(ja3) != 32 || !strings.HasPrefix(ja4, "t13i") {
+		t.Errorf("fingerprints = %q", body)
+	}
+
+	// Plain HTTP requests carry none
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if s := AnalyzeServerDetectionSignalsWithTracker(r, nil, NewMemoryTimingTracker()); s.JA3 != "" || s.JA4 != "" {
+		t.Errorf("plaintext request got %q, %q", s.JA3, s.JA4)
+	}
+}
+
+func TestGenerateHeaderFingerprint(t *testing.T) {
+	t.Run("generates consistent fingerprint", func(t *testing.T) {
+		headers := http.Header{}
+		headers.Set("User-Agent", "Mozilla/5.0")
+		headers.Set("Accept", "text/html")
+
+		fp1 := generateHeaderFingerprint(headers)
+		fp2 := generateHeaderFingerprint(headers)
+
+		if fp1 != fp2 {
+			t.Error("expected consistent fingerprints")
+		}
+	})
+
+	t.Run("different headers produce different fingerprints", func(t *testing.T) {
+		headers1 := http.Header{}
+		headers1.Set("User-Agent", "Mozilla/5.0")
+
+		headers2 := http.Header{}
+		headers2.Set("User-Agent", "Chrome/91.0")
+
+		fp1 := generateHeaderFingerprint(headers1)
+		fp2 := generateHeaderFingerprint(headers2)
+
+		if fp1 == fp2 {
+			t.Error("expected different fingerprints for different headers")
+		}
+	})
+
+	t.Run("fingerprint is hex string", func(t *testing.T) {
+		headers := http.Header{}
+		headers.Set("User-Agent", "test")
+
+		fp := generateHeaderFingerprint(headers)
+
+		// Should be 16 hex characters (8 bytes * 2)
+		if len(fp) != 16 {
+			t.Errorf("expected fingerprint length 16, got %d", len(fp))
+		}
+
+		// Should only contain hex characters
+		for _, c := range fp {
+			if !strings.ContainsRune("0123456789abcdef", c) {
+				t.Errorf("fingerprint contains non-hex character: %c", c)
+			}
+		}
+	})
+}
+
+func TestAnalyzeServerDetectionSignals(t *testing.T) {
+	t.Run("comprehensive analysis", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0)")
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("Accept-Language", "en-US")
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.RemoteAddr = "192.168.1.1:8080"
+
+		body := []byte(`{"test": "data"}`)
+
+		signals := AnalyzeServerDetectionSignals(req, body)
+
+		// Check all sections were analyzed
+		if signals.HeaderFingerprint == "" {
+			t.Error("expected header fingerprint to be generated")
+		}
+		if signals.HeaderAnalysis.HeaderCount == 0 {
+			t.Error("expected headers to be counted")
+		}
+		if signals.RequestAnalysis.RequestSize != len(body) {
+			t.Errorf("expected request size %d, got %d", len(body), signals.RequestAnalysis.RequestSize)
+		}
+		if signals.RequestAnalysis.PayloadEntropy == 0 {
+			t.Error("expected non-zero entropy for JSON payload")
+		}
+	})
+}
+
+func TestBotScore(t *testing.T) {
+	if got := (ServerDetectionSignals{}).BotScore(); got != 0 {
+		t.Errorf("no signals = %d, want 0", got)
+	}
+
+	var d ServerDetectionSignals
+	d.HeaderAnalysis.MissingExpected = []string{"accept-language", "accept-encoding", "sec-fetch-site", "sec-fetch-mode"}
+	if got := d.BotScore(); got != 30 {
+		t.Errorf("missing headers = %d, want 30 (capped)", got)
+	}
+
+	d.RequestAnalysis.UserAgentAnalysis.ContainsAutomation = true
+	d.HeaderAnalysis.AutomationHeaders = []string{"x-selenium"}
+	if got := d.BotScore(); got != 100 {
+		t.Errorf("all signals = %d, want 100", got)
+	}
+
+	if got := (ServerDetectionSignals{Honeypot: true}).BotScore(); got != 100 {
+		t.Errorf("honeypot = %d, want 100", got)
+	}
+}
+
+func TestIPClassifier(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "reputation.txt")
+	data := "# custom ranges\n198.51.100.0/24 vpn\n3.3.3.3 tor\n3.0.0.0/9 DATACENTER # keep\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadIPClassifier(file)
+	if err != nil {
+		t.Fatalf("LoadIPClassifier() error = %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"3.5.140.2", IPClassDatacenter},
+		{"34.120.1.1", IPClassDatacenter},
+		{"2001:db8::1", IPClassResidential},
+		{"::ffff:3.5.140.2", IPClassDatacenter},
+		{"198.51.100.77", IPClassVPN},
+		{"3.3.3.3", IPClassTor},
+		{"81.2.69.160", IPClassResidential},
+		{"10.1.2.3", ""},
+		{"127.0.0.1", ""},
+		{"not-an-ip", ""},
+	}
+	for _, tt := range tests {
+		if got := c.Classify(tt.ip); got != tt.want {
+			t.Errorf("Classify(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	if got := DefaultIPClassifier.Classify("3.3.3.3"); got != IPClassDatacenter {
+		t.Errorf("built-in Classify(3.3.3.3) = %q, want datacenter", got)
+	}
+	var none *IPClassifier
+	if got := none.Classify("3.3.3.3"); got != "" {
+		t.Errorf("nil classifier returned %q", got)
+	}
+
+	for _, bad := range []string{"1.2.3.0/24", "1.2.3.0/24 proxy", "1.2.3.0/33 vpn", "example.com tor"} {
+		if _, err := NewIPClassifier(strings.NewReader(bad)); err == nil {
+			t.Errorf("NewIPClassifier(%q) expected error", bad)
+		}
+	}
+	if _, err := LoadIPClassifier(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("expected error for a missing file")
+	}
+}
@@ -0,0 +1,210 @@
+package detection
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFingerprintTTL is how long an idle fingerprint's history is kept
+	DefaultFingerprintTTL = 24 * time.Hour
+	// DefaultFingerprintMaxKeys bounds how many fingerprints
+	// MemoryFingerprintTracker remembers at once
+	DefaultFingerprintMaxKeys = 100000
+	// MaxFingerprintIPs is where a fingerprint's IP count stops growing
+	MaxFingerprintIPs = 100
+)
+
+// FingerprintStats is what is known about a header fingerprint, counting the
+// current request
+type FingerprintStats struct {
+	FirstSeen time.Time
+	Requests  int64
+	IPs       int // distinct client IPs, up to MaxFingerprintIPs
+}
+
+// FingerprintTracker remembers header fingerprints across requests: when each
+// was first seen, how often and from how many IPs. One fingerprint turning up
+// from many IPs in a short time is a client rotating addresses, as credential
+// stuffing and scraping tools do.
+type FingerprintTracker interface {
+	// ObserveFingerprint records a request with fingerprint from ip at now
+	// and returns the fingerprint's history including it
+	ObserveFingerprint(fingerprint, ip string, now time.Time) FingerprintStats
+}
+
+// fingerprintState is what is kept per fingerprint. IPs are kept as
+// truncated hashes, so the store never holds client addresses.
+type fingerprintState struct {
+	FirstSeen int64    `json:"f"` // unix seconds
+	Requests  int64    `json:"r"`
+	IPs       []uint64 `json:"i"`
+}
+
+// observe counts a request from ip at now
+func (s *fingerprintState) observe(ip string, now time.Time) FingerprintStats {
+	if s.Requests == 0 {
+		s.FirstSeen = now.Unix()
+	}
+	s.Requests++
+	if ip != "" && len(s.IPs) < MaxFingerprintIPs {
+		if key := ipHash(ip); !slices.Contains(s.IPs, key) {
+			s.IPs = append(s.IPs, key)
+		}
+	}
+	return FingerprintStats{FirstSeen: time.Unix(s.FirstSeen, 0), Requests: s.Requests, IPs: len(s.IPs)}
+}
+
+// ipHash returns a short, one-way key for ip
+func ipHash(ip string) uint64 {
+	sum := sha256.Sum256([]byte(ip))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// MemoryFingerprintTracker implements FingerprintTracker in process memory. It
+// holds at most maxKeys fingerprints; idle ones are swept after the TTL and,
+// past the bound, arbitrary ones are dropped.
+// Note: Only suitable for single-instance deployments; use StoreFingerprintTracker across replicas
+type MemoryFingerprintTracker struct {
+	mu        sync.Mutex
+	states    map[string]*memoryFingerprintState
+	ttl       time.Duration
+	maxKeys   int
+	lastSweep time.Time
+}
+
+type memoryFingerprintState struct {
+	fingerprintState
+	lastSeen time.Time
+}
+
+// NewMemoryFingerprintTracker creates an in-memory fingerprint tracker that
+// forgets fingerprints idle for ttl and remembers at most maxKeys of them.
+// Zero or less selects the defaults.
+func NewMemoryFingerprintTracker(ttl time.Duration, maxKeys int) *MemoryFingerprintTracker {
+	if ttl <= 0 {
+		ttl = DefaultFingerprintTTL
+	}
+	if maxKeys <= 0 {
+		maxKeys = DefaultFingerprintMaxKeys
+	}
+	return &MemoryFingerprintTracker{
+		states:    make(map[string]*memoryFingerprintState),
+		ttl:       ttl,
+		maxKeys:   maxKeys,
+		lastSweep: time.Now(),
+	}
+}
+
+// ObserveFingerprint records a request with fingerprint from ip
+func (t *MemoryFingerprintTracker) ObserveFingerprint(fingerprint, ip string, now time.Time) FingerprintStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.states[fingerprint]
+	if ok && now.Sub(s.lastSeen) > t.ttl {
+		s, ok = nil, false // expired, start over
+	}
+	if !ok {
+		if len(t.states) >= t.maxKeys || now.Sub(t.lastSweep) >= time.Minute {
+			evictIdle(t.states, t.maxKeys, func(s *memoryFingerprintState) bool { return now.Sub(s.lastSeen) > t.ttl })
+			t.lastSweep = now
+		}
+		s = &memoryFingerprintState{}
+		t.states[fingerprint] = s
+	}
+	s.lastSeen = now
+	return s.observe(ip, now)
+}
+
+// Len returns the number of fingerprints currently remembered
+func (t *MemoryFingerprintTracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.states)
+}
+
+// evictIdle deletes the entries of states that are idle, then arbitrary ones
+// until there is room for one more below maxKeys
+func evictIdle[V any](states map[string]V, maxKeys int, idle func(V) bool) {
+	for key, s := range states {
+		if idle(s) {
+			delete(states, key)
+		}
+	}
+	for key := range states {
+		if len(states) < maxKeys {
+			break
+		}
+		delete(states, key)
+	}
+}
+
+// StoreFingerprintTracker implements FingerprintTracker on a shared store
+// (Redis or Postgres) so that a fingerprint's IPs are gathered across
+// replicas. Each fingerprint's history is read and written back without a
+// lock, so concurrent requests may undercount slightly.
+type StoreFingerprintTracker struct {
+	store   TimingStore
+	prefix  string
+	ttl     time.Duration
+	timeout time.Duration
+}
+
+// NewStoreFingerprintTracker creates a fingerprint tracker backed by store
+// that forgets fingerprints idle for ttl
+func NewStoreFingerprintTracker(store TimingStore, ttl time.Duration) *StoreFingerprintTracker {
+	if ttl <= 0 {
+		ttl = DefaultFingerprintTTL
+	}
+	return &StoreFingerprintTracker{
+		store:   store,
+		prefix:  "fingerprint:",
+		ttl:     ttl,
+		timeout: 50 * time.Millisecond, // detection must never stall the request path
+	}
+}
+
+// ObserveFingerprint records a request with fingerprint from ip
+func (t *StoreFingerprintTracker) ObserveFingerprint(fingerprint, ip string, now time.Time) FingerprintStats {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	var s fingerprintState
+	value, ok, err := t.store.Get(ctx, t.prefix+fingerprint)
+	if err != nil {
+		log.Printf("detection: fingerprint lookup failed: %v", err)
+	} else if ok {
+		_ = json.Unmarshal(value, &s) // a corrupt entry starts over
+	}
+	stats := s.observe(ip, now)
+	if value, err = json.Marshal(s); err == nil {
+		if err := t.store.Set(ctx, t.prefix+fingerprint, value, t.ttl); err != nil {
+			log.Printf("detection: fingerprint record failed: %v", err)
+		}
+	}
+	return stats
+}
+
+// ObserveFingerprint records the request on DefaultFingerprintTracker and
+// fills the signals' FingerprintAge, FingerprintRequests and IPCount. ip should
+// be the trusted client IP.
+func (s *ServerDetectionSignals) ObserveFingerprint(ip string, now time.Time) {
+	if s.HeaderFingerprint == "" || DefaultFingerprintTracker == nil {
+		return
+	}
+	stats := DefaultFingerprintTracker.ObserveFingerprint(s.HeaderFingerprint, ip, now)
+	s.FingerprintAge = int64(now.Sub(stats.FirstSeen).Seconds())
+	s.FingerprintRequests = stats.Requests
+	s.IPCount = stats.IPs
+}
+
+// DefaultFingerprintTracker is the global fingerprint tracker instance.
+// Replace it with a StoreFingerprintTracker for multi-instance deployments.
+var DefaultFingerprintTracker FingerprintTracker = NewMemoryFingerprintTracker(DefaultFingerprintTTL, DefaultFingerprintMaxKeys)
@@ -0,0 +1,151 @@
+package detection
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultTrapTTL is how long a client stays flagged after requesting a
+// honeypot path
+const DefaultTrapTTL = 24 * time.Hour
+
+// TrapTracker remembers clients that requested a honeypot path. Clients are
+// identified by IP and by header fingerprint, either of which may be empty.
+type TrapTracker interface {
+	RecordTrap(ip, fingerprint string)
+	Trapped(ip, fingerprint string) bool
+}
+
+// trapKeys returns the keys a client is flagged under
+func trapKeys(ip, fingerprint string) []string {
+	var keys []string
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	if fingerprint != "" {
+		keys = append(keys, "fp:"+fingerprint)
+	}
+	return keys
+}
+
+// MemoryTrapTracker implements TrapTracker in process memory
+// Note: Only suitable for single-instance deployments; use StoreTrapTracker across replicas
+type MemoryTrapTracker struct {
+	mu        sync.Mutex
+	expires   map[string]time.Time
+	ttl       time.Duration
+	lastSweep time.Time
+}
+
+// NewMemoryTrapTracker creates an in-memory trap tracker that flags clients
+// for ttl
+func NewMemoryTrapTracker(ttl time.Duration) *MemoryTrapTracker {
+	if ttl <= 0 {
+		ttl = DefaultTrapTTL
+	}
+	return &MemoryTrapTracker{
+		expires:   make(map[string]time.Time),
+		ttl:       ttl,
+		lastSweep: time.Now(),
+	}
+}
+
+// RecordTrap flags the client with the given IP and fingerprint
+func (t *MemoryTrapTracker) RecordTrap(ip, fingerprint string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range trapKeys(ip, fingerprint) {
+		t.expires[key] = now.Add(t.ttl)
+	}
+	// Sweep expired entries at most once per TTL window
+	if now.Sub(t.lastSweep) >= t.ttl {
+		for key, expires := range t.expires {
+			if !now.Before(expires) {
+				delete(t.expires, key)
+			}
+		}
+		t.lastSweep = now
+	}
+}
+
+// Trapped reports whether the client's IP or fingerprint is flagged
+func (t *MemoryTrapTracker) Trapped(ip, fingerprint string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range trapKeys(ip, fingerprint) {
+		if expires, ok := t.expires[key]; ok && now.Before(expires) {
+			return true
+		}
+	}
+	return false
+}
+
+// StoreTrapTracker implements TrapTracker on a shared store (Redis or
+// Postgres) so that a client trapped on one replica is flagged on all of
+// them. Entries expire via the store's TTLs.
+type StoreTrapTracker struct {
+	store   TimingStore
+	prefix  string
+	ttl     time.Duration
+	timeout time.Duration
+}
+
+// NewStoreTrapTracker creates a trap tracker backed by store
+func NewStoreTrapTracker(store TimingStore, ttl time.Duration) *StoreTrapTracker {
+	if ttl <= 0 {
+		ttl = DefaultTrapTTL
+	}
+	return &StoreTrapTracker{
+		store:   store,
+		prefix:  "trap:",
+		ttl:     ttl,
+		timeout: 50 * time.Millisecond, // detection must never stall the request path
+	}
+}
+
+// RecordTrap flags the client with the given IP and fingerprint
+func (t *StoreTrapTracker) RecordTrap(ip, fingerprint string) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	for _, key := range trapKeys(ip, fingerprint) {
+		if err := t.store.Set(ctx, t.prefix+key, []byte{'1'}, t.ttl); err != nil {
+			log.Printf("detection: trap record failed: %v", err)
+		}
+	}
+}
+
+// Trapped reports whether the client's IP or fingerprint is flagged
+func (t *StoreTrapTracker) Trapped(ip, fingerprint string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	for _, key := range trapKeys(ip, fingerprint) {
+		_, ok, err := t.store.Get(ctx, t.prefix+key)
+		if err != nil {
+			log.Printf("detection: trap lookup failed: %v", err)
+			return false
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// HeaderFingerprint returns the fingerprint of a request's headers, as
+// recorded in ServerDetectionSignals.HeaderFingerprint
+func HeaderFingerprint(headers http.Header) string {
+	return generateHeaderFingerprint(headers)
+}
+
+// DefaultTrapTracker is the global trap tracker instance. Replace it with a
+// StoreTrapTracker for multi-instance deployments.
+var DefaultTrapTracker TrapTracker = NewMemoryTrapTracker(DefaultTrapTTL)
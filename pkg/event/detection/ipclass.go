@@ -0,0 +1,145 @@
+package detection
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// IP classes reported in ServerDetectionSignals.IPClass
+const (
+	IPClassResidential = "residential" // public address outside every known range
+	IPClassDatacenter  = "datacenter"  // cloud and hosting providers
+	IPClassVPN         = "vpn"         // commercial VPN exits
+	IPClassTor         = "tor"         // Tor exit relays
+)
+
+//go:embed ipranges.txt
+var builtinIPRanges []byte
+
+// DefaultIPClassifier classifies client IPs during enrichment. It holds the
+// embedded datacenter ranges; main replaces it when IP_REPUTATION_FILE is set.
+var DefaultIPClassifier = mustBuiltinIPClassifier()
+
+// IPClassifier maps addresses to an IP class. Single addresses, such as Tor
+// exit lists, are looked up directly; ranges are checked most specific first.
+type IPClassifier struct {
+	addrs  map[netip.Addr]string
+	ranges []ipRange
+}
+
+type ipRange struct {
+	prefix netip.Prefix
+	class  string
+}
+
+// NewIPClassifier reads "<CIDR or address> <class>" lines from each source,
+// skipping blank lines and # comments. Later sources win for an identical
+// range, so a file can reclassify a built-in one.
+func NewIPClassifier(sources ...io.Reader) (*IPClassifier, error) {
+	c := &IPClassifier{addrs: make(map[netip.Addr]string)}
+	byPrefix := make(map[netip.Prefix]string)
+	for _, src := range sources {
+		scanner := bufio.NewScanner(src)
+		for line := 1; scanner.Scan(); line++ {
+			text, _, _ := strings.Cut(scanner.Text(), "#")
+			fields := strings.Fields(text)
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: want \"<range> <class>\", got %q", line, strings.TrimSpace(text))
+			}
+			class := strings.ToLower(fields[1])
+			if !validIPClass(class) {
+				return nil, fmt.Errorf("line %d: unknown IP class %q (want datacenter, vpn, tor or residential)", line, fields[1])
+			}
+			if !strings.Contains(fields[0], "/") {
+				addr, err := netip.ParseAddr(fields[0])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+				c.addrs[addr.Unmap()] = class
+				continue
+			}
+			p, err := netip.ParsePrefix(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			byPrefix[p.Masked()] = class
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for p, class := range byPrefix {
+		c.ranges = append(c.ranges, ipRange{prefix: p, class: class})
+	}
+	sort.Slice(c.ranges, func(i, j int) bool {
+		return c.ranges[i].prefix.Bits() > c.ranges[j].prefix.Bits()
+	})
+	return c, nil
+}
+
+// LoadIPClassifier returns a classifier with the built-in ranges plus those
+// in path
+func LoadIPClassifier(path string) (*IPClassifier, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := NewIPClassifier(bytes.NewReader(builtinIPRanges), f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func mustBuiltinIPClassifier() *IPClassifier {
+	c, err := NewIPClassifier(bytes.NewReader(builtinIPRanges))
+	if err != nil {
+		panic("detection: built-in IP ranges: " + err.Error())
+	}
+	return c
+}
+
+func validIPClass(class string) bool {
+	switch class {
+	case IPClassResidential, IPClassDatacenter, IPClassVPN, IPClassTor:
+		return true
+	}
+	return false
+}
+
+// Classify returns the class of ip. Unparsable, private, loopback and other
+// non-public addresses have no class and return "".
+func (c *IPClassifier) Classify(ip string) string {
+	if c == nil {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return ""
+	}
+	if class, ok := c.addrs[addr]; ok {
+		return class
+	}
+	for _, r := range c.ranges {
+		if r.prefix.Contains(addr) {
+			return r.class
+		}
+	}
+	return IPClassResidential
+}
@@ -0,0 +1,245 @@
+package detection
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Sliding windows requests are counted over, shortest first
+var rateWindows = [...]time.Duration{10 * time.Second, time.Minute, 10 * time.Minute}
+
+const (
+	// DefaultRateMaxKeys bounds how many IPs and fingerprints
+	// MemoryRateTracker counts at once
+	DefaultRateMaxKeys = 100000
+
+	// periodSamples is how many request times are kept per key to spot
+	// evenly spaced requests
+	periodSamples = 6
+	// periodTolerance is how far intervals may stray from their mean, as a
+	// fraction of it, for requests to count as periodic
+	periodTolerance = 0.05
+	// minPeriod ignores batches sent in one go, which are evenly spaced too
+	minPeriod = time.Second
+
+	// burstMinRequests and burstFactor flag a burst: at least this many
+	// requests in 10s, at this many times the key's 1-minute average rate
+	burstMinRequests = 10
+	burstFactor      = 3
+)
+
+// rateTTL is how long a key's counts are needed: the longest window plus the
+// previous one its estimate weighs in
+var rateTTL = 2 * rateWindows[len(rateWindows)-1]
+
+// RequestRate counts requests from one IP or fingerprint in sliding windows,
+// including the current request
+type RequestRate struct {
+	Last10s int `json:"last_10s"`
+	Last1m  int `json:"last_1m"`
+	Last10m int `json:"last_10m"`
+}
+
+// RateTracker counts requests per key in sliding windows. Keys are client IPs
+// and header fingerprints.
+type RateTracker interface {
+	// RecordRate records a request for key at now and returns its rates and
+	// whether the key's recent requests were evenly spaced
+	RecordRate(key string, now time.Time) (rate RequestRate, periodic bool)
+}
+
+// windowCount is a sliding window estimated from two fixed windows: the
+// current one and the one before it, weighted by how much of it still
+// overlaps the sliding window
+type windowCount struct {
+	Index int64 `json:"i"` // fixed window number, now / window length
+	Curr  int   `json:"c"`
+	Prev  int   `json:"p"`
+}
+
+// rateState is what is kept per key
+type rateState struct {
+	Windows [len(rateWindows)]windowCount `json:"w"`
+	Recent  []int64                       `json:"t"` // unix milliseconds of the latest requests, oldest first
+}
+
+// record counts a request at now
+func (s *rateState) record(now time.Time) (RequestRate, bool) {
+	var counts [len(rateWindows)]int
+	for i, window := range rateWindows {
+		w := &s.Windows[i]
+		index := now.UnixNano() / int64(window)
+		switch index {
+		case w.Index:
+			w.Curr++
+		case w.Index + 1:
+			w.Prev, w.Curr = w.Curr, 1
+		default:
+			w.Prev, w.Curr = 0, 1
+		}
+		w.Index = index
+		elapsed := float64(now.UnixNano()%int64(window)) / float64(window)
+		counts[i] = w.Curr + int(math.Round(float64(w.Prev)*(1-elapsed)))
+	}
+
+	s.Recent = append(s.Recent, now.UnixMilli())
+	if len(s.Recent) > periodSamples {
+		s.Recent = s.Recent[len(s.Recent)-periodSamples:]
+	}
+	return RequestRate{Last10s: counts[0], Last1m: counts[1], Last10m: counts[2]}, periodic(s.Recent)
+}
+
+// periodic reports whether times, in unix milliseconds, are a full set of
+// samples within the longest window whose intervals all lie within
+// periodTolerance of their mean. People don't click on a timer; scripts do.
+func periodic(times []int64) bool {
+	if len(times) < periodSamples {
+		return false
+	}
+	span := times[len(times)-1] - times[0]
+	if span > rateWindows[len(rateWindows)-1].Milliseconds() {
+		return false
+	}
+	mean := float64(span) / float64(len(times)-1)
+	if mean < float64(minPeriod.Milliseconds()) {
+		return false
+	}
+	for i := 1; i < len(times); i++ {
+		if math.Abs(float64(times[i]-times[i-1])-mean) > mean*periodTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// bursty reports whether the last 10 seconds hold a burst well above the
+// key's rate over the last minute
+func bursty(rate RequestRate) bool {
+	return rate.Last10s >= burstMinRequests && rate.Last10s*6 >= rate.Last1m*burstFactor
+}
+
+// MemoryRateTracker implements RateTracker in process memory. It holds at
+// most maxKeys keys; idle keys are swept and, past the bound, arbitrary keys
+// are dropped.
+// Note: Only suitable for single-instance deployments; use StoreRateTracker across replicas
+type MemoryRateTracker struct {
+	mu        sync.Mutex
+	states    map[string]*memoryRateState
+	maxKeys   int
+	lastSweep time.Time
+}
+
+type memoryRateState struct {
+	rateState
+	lastSeen time.Time
+}
+
+// NewMemoryRateTracker creates an in-memory rate tracker counting at most
+// maxKeys keys; zero or less uses DefaultRateMaxKeys
+func NewMemoryRateTracker(maxKeys int) *MemoryRateTracker {
+	if maxKeys <= 0 {
+		maxKeys = DefaultRateMaxKeys
+	}
+	return &MemoryRateTracker{
+		states:    make(map[string]*memoryRateState),
+		maxKeys:   maxKeys,
+		lastSweep: time.Now(),
+	}
+}
+
+// RecordRate records a request for key
+func (t *MemoryRateTracker) RecordRate(key string, now time.Time) (RequestRate, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.states[key]
+	if !ok {
+		if len(t.states) >= t.maxKeys || now.Sub(t.lastSweep) >= time.Minute {
+			evictIdle(t.states, t.maxKeys, func(s *memoryRateState) bool { return now.Sub(s.lastSeen) > rateTTL })
+			t.lastSweep = now
+		}
+		s = &memoryRateState{}
+		t.states[key] = s
+	}
+	s.lastSeen = now
+	return s.record(now)
+}
+
+// Len returns the number of keys currently counted
+func (t *MemoryRateTracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.states)
+}
+
+// RateStore is the key/value capability StoreRateTracker needs. GoTrack's
+// shared state stores (memory, Redis, Postgres) all satisfy it.
+type RateStore = TimingStore
+
+// StoreRateTracker implements RateTracker on a shared store (Redis or
+// Postgres) so that a client spreading requests across replicas is counted
+// once. Each key's counts are read and written back without a lock, so
+// concurrent requests for one key may undercount slightly.
+type StoreRateTracker struct {
+	store   RateStore
+	prefix  string
+	timeout time.Duration
+}
+
+// NewStoreRateTracker creates a rate tracker backed by store
+func NewStoreRateTracker(store RateStore) *StoreRateTracker {
+	return &StoreRateTracker{
+		store:   store,
+		prefix:  "rate:",
+		timeout: 50 * time.Millisecond, // detection must never stall the request path
+	}
+}
+
+// RecordRate records a request for key
+func (t *StoreRateTracker) RecordRate(key string, now time.Time) (RequestRate, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	var s rateState
+	value, ok, err := t.store.Get(ctx, t.prefix+key)
+	if err != nil {
+		log.Printf("detection: rate lookup failed: %v", err)
+	} else if ok {
+		_ = json.Unmarshal(value, &s) // a corrupt entry starts over
+	}
+	rate, periodic := s.record(now)
+	if value, err = json.Marshal(s); err == nil {
+		if err := t.store.Set(ctx, t.prefix+key, value, rateTTL); err != nil {
+			log.Printf("detection: rate record failed: %v", err)
+		}
+	}
+	return rate, periodic
+}
+
+// analyzeRates counts the request per IP and per header fingerprint and flags
+// bursts and evenly spaced requests from either
+func analyzeRates(analysis *TimingAnalysis, tracker RateTracker, ip, fingerprint string, now time.Time) {
+	if tracker == nil {
+		return
+	}
+	if ip != "" {
+		rate, periodic := tracker.RecordRate("ip:"+ip, now)
+		analysis.IPRate = &rate
+		analysis.Bursty = analysis.Bursty || bursty(rate)
+		analysis.Periodic = analysis.Periodic || periodic
+	}
+	if fingerprint != "" {
+		rate, periodic := tracker.RecordRate("fp:"+fingerprint, now)
+		analysis.FingerprintRate = &rate
+		analysis.Bursty = analysis.Bursty || bursty(rate)
+		analysis.Periodic = analysis.Periodic || periodic
+	}
+}
+
+// DefaultRateTracker is the global rate tracker instance. Replace it with a
+// StoreRateTracker for multi-instance deployments.
+var DefaultRateTracker RateTracker = NewMemoryRateTracker(DefaultRateMaxKeys)
@@ -0,0 +1,22 @@
+package detection
+
+// BotScore rates how automated a request looks, from 0 (no signs) to 100.
+// An automation user agent adds 50, automation headers 30, each missing
+// expected header 10 (up to 30) and each inconsistent value 10 (up to 20).
+// Clients that requested a honeypot path score 100. Events without signals,
+// such as relayed or imported ones, score 0.
+func (s ServerDetectionSignals) BotScore() int {
+	if s.Honeypot {
+		return 100
+	}
+	score := 0
+	if s.RequestAnalysis.UserAgentAnalysis.ContainsAutomation {
+		score += 50
+	}
+	if len(s.HeaderAnalysis.AutomationHeaders) > 0 {
+		score += 30
+	}
+	score += min(len(s.HeaderAnalysis.MissingExpected)*10, 30)
+	score += min(len(s.HeaderAnalysis.InconsistentValues)*10, 20)
+	return min(score, 100)
+}
@@ -1,15 +1,11 @@
 package detection
 
-import (
-	"net/http"
-	"time"
-)
+import "time"
 
-// analyzeTimingPatterns analyzes request timing patterns
-func analyzeTimingPatterns(r *http.Request, tracker TimingTracker) TimingAnalysis {
+// analyzeTimingPatterns analyzes the timing of clientIP's requests
+func analyzeTimingPatterns(clientIP string, tracker TimingTracker) TimingAnalysis {
 	analysis := TimingAnalysis{}
 
-	clientIP := getClientIP(r)
 	now := time.Now()
 
 	if lastTime, exists := tracker.GetLastRequest(clientIP); exists {
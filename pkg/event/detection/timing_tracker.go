@@ -0,0 +1,86 @@
+package detection
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultTimingTTL is how long a request timestamp is remembered per IP.
+// Intervals longer than this carry no useful automation signal.
+const DefaultTimingTTL = 10 * time.Minute
+
+// TimingTracker stores and analyzes request timing patterns
+type TimingTracker interface {
+	RecordRequest(ip string, timestamp time.Time)
+	GetLastRequest(ip string) (time.Time, bool)
+}
+
+// MemoryTimingTracker implements TimingTracker using in-memory storage
+// Note: Only suitable for single-instance deployments; use RedisTimingTracker across replicas
+type MemoryTimingTracker struct {
+	mu           sync.RWMutex
+	lastRequests map[string]time.Time
+	ttl          time.Duration
+	lastSweep    time.Time
+}
+
+// NewMemoryTimingTracker creates a new in-memory timing tracker
+func NewMemoryTimingTracker() *MemoryTimingTracker {
+	return NewMemoryTimingTrackerWithTTL(DefaultTimingTTL)
+}
+
+// NewMemoryTimingTrackerWithTTL creates an in-memory timing tracker that
+// forgets IPs which have not been seen for longer than ttl
+func NewMemoryTimingTrackerWithTTL(ttl time.Duration) *MemoryTimingTracker {
+	return &MemoryTimingTracker{
+		lastRequests: make(map[string]time.Time),
+		ttl:          ttl,
+		lastSweep:    time.Now(),
+	}
+}
+
+// RecordRequest records the timestamp of a request from the given IP
+func (t *MemoryTimingTracker) RecordRequest(ip string, timestamp time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastRequests[ip] = timestamp
+
+	// Sweep expired entries at most once per TTL window
+	now := time.Now()
+	if t.ttl > 0 && now.Sub(t.lastSweep) >= t.ttl {
+		t.evictExpired(now)
+		t.lastSweep = now
+	}
+}
+
+// GetLastRequest retrieves the last request time for the given IP
+func (t *MemoryTimingTracker) GetLastRequest(ip string) (time.Time, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	lastTime, exists := t.lastRequests[ip]
+	if exists && t.ttl > 0 && time.Since(lastTime) > t.ttl {
+		return time.Time{}, false
+	}
+	return lastTime, exists
+}
+
+// Len returns the number of IPs currently tracked
+func (t *MemoryTimingTracker) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.lastRequests)
+}
+
+// evictExpired removes entries older than the TTL (must be called with mutex held)
+func (t *MemoryTimingTracker) evictExpired(now time.Time) {
+	for ip, ts := range t.lastRequests {
+		if now.Sub(ts) > t.ttl {
+			delete(t.lastRequests, ip)
+		}
+	}
+}
+
+// DefaultTracker is the global timing tracker instance
+// This maintains backward compatibility with the original global variable.
+// Replace it with a RedisTimingTracker for multi-instance deployments.
+var DefaultTracker TimingTracker = NewMemoryTimingTracker()
@@ -0,0 +1,69 @@
+package detection
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+)
+
+// TimingStore is the key/value capability StoreTimingTracker needs. GoTrack's
+// shared state stores (memory, Redis, Postgres) all satisfy it.
+type TimingStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// StoreTimingTracker implements TimingTracker on a shared store (Redis or
+// Postgres) so that timing analysis is consistent across replicas. Entries
+// expire via the store's TTLs.
+type StoreTimingTracker struct {
+	store   TimingStore
+	prefix  string
+	ttl     time.Duration
+	timeout time.Duration
+}
+
+// NewStoreTimingTracker creates a timing tracker backed by store
+func NewStoreTimingTracker(store TimingStore, ttl time.Duration) *StoreTimingTracker {
+	if ttl <= 0 {
+		ttl = DefaultTimingTTL
+	}
+	return &StoreTimingTracker{
+		store:   store,
+		prefix:  "timing:",
+		ttl:     ttl,
+		timeout: 50 * time.Millisecond, // detection must never stall the request path
+	}
+}
+
+// RecordRequest records the timestamp of a request from the given IP
+func (t *StoreTimingTracker) RecordRequest(ip string, timestamp time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	value := strconv.FormatInt(timestamp.UnixNano(), 10)
+	if err := t.store.Set(ctx, t.prefix+ip, []byte(value), t.ttl); err != nil {
+		log.Printf("detection: timing record failed: %v", err)
+	}
+}
+
+// GetLastRequest retrieves the last request time for the given IP
+func (t *StoreTimingTracker) GetLastRequest(ip string) (time.Time, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	value, ok, err := t.store.Get(ctx, t.prefix+ip)
+	if err != nil {
+		log.Printf("detection: timing lookup failed: %v", err)
+		return time.Time{}, false
+	}
+	if !ok {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
@@ -0,0 +1,300 @@
+package detection
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+)
+
+// maxClientHelloBytes bounds how much of a connection is recorded while
+// waiting for the ClientHello
+const maxClientHelloBytes = 1 << 16
+
+// ClientHello holds the fields of a TLS ClientHello that JA3 and JA4 use.
+// Lists keep the client's order and include GREASE values.
+type ClientHello struct {
+	Version             uint16 // legacy_version of the hello
+	CipherSuites        []uint16
+	Extensions          []uint16
+	SupportedGroups     []uint16
+	PointFormats        []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+	ALPN                []string
+	ServerName          string
+}
+
+// ParseClientHello parses a handshake message (type, length and body, without
+// the record header) holding a ClientHello
+func ParseClientHello(msg []byte) (*ClientHello, error) {
+	errMalformed := errors.New("malformed ClientHello")
+	if len(msg) < 4 || msg[0] != 1 {
+		return nil, errors.New("not a ClientHello")
+	}
+	n := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+	if n > len(msg)-4 {
+		return nil, errMalformed
+	}
+	body := helloReader(msg[4 : 4+n])
+
+	h := &ClientHello{}
+	var ok bool
+	if h.Version, ok = body.uint16(); !ok {
+		return nil, errMalformed
+	}
+	if !body.skip(32) || !body.skipVector(1) { // random, session ID
+		return nil, errMalformed
+	}
+	ciphers, ok := body.vector(2)
+	if !ok || len(ciphers)%2 != 0 {
+		return nil, errMalformed
+	}
+	h.CipherSuites = ciphers.uint16s()
+	if !body.skipVector(1) { // compression methods
+		return nil, errMalformed
+	}
+	if len(body) == 0 {
+		return h, nil // no extensions
+	}
+
+	exts, ok := body.vector(2)
+	if !ok {
+		return nil, errMalformed
+	}
+	for len(exts) > 0 {
+		typ, ok := exts.uint16()
+		if !ok {
+			return nil, errMalformed
+		}
+		data, ok := exts.vector(2)
+		if !ok {
+			return nil, errMalformed
+		}
+		h.Extensions = append(h.Extensions, typ)
+		h.parseExtension(typ, data)
+	}
+	return h, nil
+}
+
+// parseExtension reads the extensions JA3 and JA4 look into. Malformed
+// contents are ignored; the extension type still counts.
+func (h *ClientHello) parseExtension(typ uint16, data helloReader) {
+	switch typ {
+	case 0x0000: // server_name
+		list, _ := data.vector(2)
+		for len(list) > 0 {
+			nameType, ok := list.uint8()
+			name, ok2 := list.vector(2)
+			if !ok || !ok2 {
+				return
+			}
+			if nameType == 0 {
+				h.ServerName = string(name)
+				return
+			}
+		}
+	case 0x000a: // supported_groups
+		if list, ok := data.vector(2); ok && len(list)%2 == 0 {
+			h.SupportedGroups = list.uint16s()
+		}
+	case 0x000b: // ec_point_formats
+		if list, ok := data.vector(1); ok {
+			h.PointFormats = append([]uint8(nil), list...)
+		}
+	case 0x000d: // signature_algorithms
+		if list, ok := data.vector(2); ok && len(list)%2 == 0 {
+			h.SignatureAlgorithms = list.uint16s()
+		}
+	case 0x0010: // application_layer_protocol_negotiation
+		list, _ := data.vector(2)
+		for len(list) > 0 {
+			proto, ok := list.vector(1)
+			if !ok {
+				return
+			}
+			h.ALPN = append(h.ALPN, string(proto))
+		}
+	case 0x002b: // supported_versions
+		if list, ok := data.vector(1); ok && len(list)%2 == 0 {
+			h.SupportedVersions = list.uint16s()
+		}
+	}
+}
+
+// helloReader consumes big-endian TLS fields
+type helloReader []byte
+
+func (r *helloReader) uint8() (uint8, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	v := (*r)[0]
+	*r = (*r)[1:]
+	return v, true
+}
+
+func (r *helloReader) uint16() (uint16, bool) {
+	if len(*r) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*r)
+	*r = (*r)[2:]
+	return v, true
+}
+
+func (r *helloReader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+// vector reads a field prefixed by a lenBytes-byte length
+func (r *helloReader) vector(lenBytes int) (helloReader, bool) {
+	if len(*r) < lenBytes {
+		return nil, false
+	}
+	n := 0
+	for _, b := range (*r)[:lenBytes] {
+		n = n<<8 | int(b)
+	}
+	*r = (*r)[lenBytes:]
+	if len(*r) < n {
+		return nil, false
+	}
+	v := (*r)[:n]
+	*r = (*r)[n:]
+	return v, true
+}
+
+func (r *helloReader) skipVector(lenBytes int) bool {
+	_, ok := r.vector(lenBytes)
+	return ok
+}
+
+func (r helloReader) uint16s() []uint16 {
+	out := make([]uint16, 0, len(r)/2)
+	for i := 0; i+1 < len(r); i += 2 {
+		out = append(out, binary.BigEndian.Uint16(r[i:]))
+	}
+	return out
+}
+
+// ListenClientHellos wraps l so TLS servers built on it can fingerprint
+// clients. Each connection records its first bytes until the ClientHello is
+// complete; set http.Server.ConnContext to ClientHelloContext to make the
+// hello available to handlers.
+func ListenClientHellos(l net.Listener) net.Listener {
+	return helloListener{l}
+}
+
+type helloListener struct {
+	net.Listener
+}
+
+func (l helloListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &helloConn{Conn: c}, nil
+}
+
+// helloConn records the TLS records carrying the ClientHello
+type helloConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	raw    []byte // records read so far, until done
+	done   bool
+	hello  *ClientHello
+	parsed bool
+}
+
+func (c *helloConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.record(p[:n])
+	}
+	return n, err
+}
+
+func (c *helloConn) record(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return
+	}
+	c.raw = append(c.raw, b...)
+	if msg, complete := handshakeMessage(c.raw); complete {
+		c.raw, c.done = msg, true
+	} else if len(c.raw) > maxClientHelloBytes {
+		c.raw, c.done = nil, true
+	}
+}
+
+// clientHello parses the recorded hello once it is complete
+func (c *helloConn) clientHello() *ClientHello {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.done {
+		return nil
+	}
+	if !c.parsed {
+		c.hello, _ = ParseClientHello(c.raw)
+		c.raw, c.parsed = nil, true
+	}
+	return c.hello
+}
+
+// handshakeMessage joins the handshake records at the start of raw and
+// reports whether they hold a whole first message. Anything that is not a
+// handshake record completes with a nil message.
+func handshakeMessage(raw []byte) ([]byte, bool) {
+	var msg []byte
+	for len(raw) >= 5 {
+		if raw[0] != 22 { // handshake
+			return nil, true
+		}
+		n := int(binary.BigEndian.Uint16(raw[3:5]))
+		if len(raw) < 5+n {
+			break
+		}
+		msg = append(msg, raw[5:5+n]...)
+		raw = raw[5+n:]
+		if len(msg) >= 4 {
+			if need := 4 + (int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])); len(msg) >= need {
+				return msg[:need], true
+			}
+		}
+	}
+	return nil, false
+}
+
+type clientHelloKey struct{}
+
+// ClientHelloContext is an http.Server ConnContext hook that attaches the
+// recorded ClientHello of connections accepted via ListenClientHellos
+func ClientHelloContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if hc, ok := c.(*helloConn); ok {
+		return context.WithValue(ctx, clientHelloKey{}, hc)
+	}
+	return ctx
+}
+
+// ClientHelloFromContext returns the ClientHello of the connection a request
+// arrived on, or nil when it wasn't recorded or couldn't be parsed
+func ClientHelloFromContext(ctx context.Context) *ClientHello {
+	hc, ok := ctx.Value(clientHelloKey{}).(*helloConn)
+	if !ok {
+		return nil
+	}
+	return hc.clientHello()
+}
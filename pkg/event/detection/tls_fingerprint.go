@@ -0,0 +1,23 @@
+package detection
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+)
+
+// generateTLSFingerprint creates a fingerprint from the negotiated TLS parameters.
+// Clients sharing a TLS stack negotiate the same version, cipher suite and ALPN,
+// so this groups automation built on one toolchain even across many IPs.
+// Returns an empty string for plaintext connections or when TLS terminates upstream.
+func generateTLSFingerprint(state *tls.ConnectionState) string {
+	if state == nil {
+		return ""
+	}
+
+	fingerprint := fmt.Sprintf("%04x|%04x|%s|%t",
+		state.Version, state.CipherSuite, state.NegotiatedProtocol, state.ServerName != "")
+	hash := sha256.Sum256([]byte(fingerprint))
+	return hex.EncodeToString(hash[:8]) // First 8 bytes as hex
+}
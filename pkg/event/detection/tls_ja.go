@@ -0,0 +1,149 @@
+package detection
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// isGREASE reports whether v is a GREASE value (RFC 8701), which clients
+// insert at random and fingerprints ignore
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGREASE(values []uint16) []uint16 {
+	out := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// JA3 returns the JA3 fingerprint of the hello: the MD5 of its version,
+// cipher suites, extensions, groups and point formats in client order
+func (h *ClientHello) JA3() string {
+	// nosemgrep: go.lang.security.audit.crypto.use_of_weak_crypto.use-of-md5 -- JA3 is defined as MD5, not used for security
+	sum := md5.Sum([]byte(h.ja3String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *ClientHello) ja3String() string {
+	join := func(values []uint16) string {
+		parts := make([]string, len(values))
+		for i, v := range values {
+			parts[i] = strconv.Itoa(int(v))
+		}
+		return strings.Join(parts, "-")
+	}
+	formats := make([]uint16, len(h.PointFormats))
+	for i, f := range h.PointFormats {
+		formats[i] = uint16(f)
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(h.Version)),
+		join(withoutGREASE(h.CipherSuites)),
+		join(withoutGREASE(h.Extensions)),
+		join(withoutGREASE(h.SupportedGroups)),
+		join(formats),
+	}, ",")
+}
+
+// JA4 returns the JA4 fingerprint of the hello, e.g.
+// t13d1516h2_8daaf6152771_e5627efa2ab1. Unlike JA3 it sorts cipher suites
+// and extensions, so clients randomizing their order keep one fingerprint.
+func (h *ClientHello) JA4() string {
+	ciphers := withoutGREASE(h.CipherSuites)
+	exts := withoutGREASE(h.Extensions)
+
+	sni := "i"
+	if slices.Contains(exts, 0x0000) {
+		sni = "d"
+	}
+	prefix := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(h), sni, min(len(ciphers), 99), min(len(exts), 99), ja4ALPN(h.ALPN))
+
+	// SNI and ALPN are already in the prefix
+	hashed := make([]uint16, 0, len(exts))
+	for _, e := range exts {
+		if e != 0x0000 && e != 0x0010 {
+			hashed = append(hashed, e)
+		}
+	}
+	extPart := ja4Hex(hashed, true)
+	if sigs := withoutGREASE(h.SignatureAlgorithms); len(sigs) > 0 {
+		extPart += "_" + ja4Hex(sigs, false)
+	}
+	extHash := ja4Hash(extPart)
+	if len(exts) == 0 {
+		extHash = ja4Hash("")
+	}
+	return prefix + "_" + ja4Hash(ja4Hex(ciphers, true)) + "_" + extHash
+}
+
+// ja4Version is the highest supported version, from supported_versions when
+// the client sends it
+func ja4Version(h *ClientHello) string {
+	version := h.Version
+	for _, v := range withoutGREASE(h.SupportedVersions) {
+		version = max(version, v)
+	}
+	switch version {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	default:
+		return "00"
+	}
+}
+
+// ja4ALPN is the first and last character of the first ALPN protocol, or of
+// its hex form when either isn't alphanumeric
+func ja4ALPN(protos []string) string {
+	if len(protos) == 0 || protos[0] == "" {
+		return "00"
+	}
+	p := protos[0]
+	if !isAlnum(p[0]) || !isAlnum(p[len(p)-1]) {
+		p = hex.EncodeToString([]byte(p))
+	}
+	return string(p[0]) + string(p[len(p)-1])
+}
+
+func isAlnum(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// ja4Hex lists values as 4-digit hex separated by commas
+func ja4Hex(values []uint16, sorted bool) string {
+	if sorted {
+		values = slices.Sorted(slices.Values(values))
+	}
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ja4Hash is the first 12 hex digits of the SHA-256 of s, or zeros for an
+// empty list
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,71 @@
+// Package detection collects raw server-side bot-detection signals (header,
+// TLS, user-agent, timing and IP reputation analysis) attached to each
+// event. BotScore condenses them into a coarse score for routing and
+// monitoring; finer scoring is left to downstream consumers.
+package detection
+
+// ServerDetectionSignals represents raw server-side detection data
+type ServerDetectionSignals struct {
+	HeaderFingerprint string          `json:"header_fingerprint"`
+	TLSFingerprint    string          `json:"tls_fingerprint,omitempty"`
+	JA3               string          `json:"ja3,omitempty"` // needs GoTrack to terminate TLS
+	JA4               string          `json:"ja4,omitempty"`
+	IPClass           string          `json:"ip_class,omitempty"` // residential, datacenter, vpn or tor; empty for non-public IPs
+	Honeypot          bool            `json:"honeypot,omitempty"` // the client's IP or header fingerprint requested a honeypot path
+	HeaderAnalysis    HeaderAnalysis  `json:"header_analysis"`
+	RequestAnalysis   RequestAnalysis `json:"request_analysis"`
+	TimingAnalysis    TimingAnalysis  `json:"timing_analysis"`
+
+	// History of the header fingerprint: seconds since it was first seen,
+	// requests and distinct client IPs with it so far, counting this one
+	FingerprintAge      int64 `json:"fingerprint_age_s,omitempty"`
+	FingerprintRequests int64 `json:"fingerprint_requests,omitempty"`
+	IPCount             int   `json:"ip_count,omitempty"`
+
+	// SuspectedBot is set on events whose bot score reached the server's
+	// threshold on an endpoint configured to tag them
+	SuspectedBot bool `json:"suspected_bot,omitempty"`
+}
+
+// HeaderAnalysis contains header-based detection signals
+type HeaderAnalysis struct {
+	MissingExpected    []string `json:"missing_expected"`
+	AutomationHeaders  []string `json:"automation_headers"`
+	InconsistentValues []string `json:"inconsistent_values"`
+	HeaderOrder        []string `json:"header_order"`
+	HeaderCount        int      `json:"header_count"`
+}
+
+// RequestAnalysis contains request payload analysis
+type RequestAnalysis struct {
+	PayloadEntropy    float64    `json:"payload_entropy"`
+	RequestSize       int        `json:"request_size"`
+	UserAgentAnalysis UAAnalysis `json:"user_agent_analysis"`
+}
+
+// UAAnalysis contains user-agent string analysis
+type UAAnalysis struct {
+	Length             int      `json:"length"`
+	ContainsAutomation bool     `json:"contains_automation"`
+	AutomationKeywords []string `json:"automation_keywords"`
+	Platform           string   `json:"platform"`
+	Browser            string   `json:"browser"`
+}
+
+// TimingAnalysis contains request timing pattern analysis
+type TimingAnalysis struct {
+	RequestInterval    float64 `json:"request_interval_ms"`
+	IntervalPrecision  int     `json:"interval_precision"` // How precise the timing is (e.g., exact 100ms intervals)
+	RequestsPerSecond  float64 `json:"requests_per_second"`
+	HasPreviousRequest bool    `json:"has_previous_request"`
+
+	// Requests in sliding windows from the client's IP and from its header
+	// fingerprint, counting this one; nil when the request wasn't counted
+	IPRate          *RequestRate `json:"ip_rate,omitempty"`
+	FingerprintRate *RequestRate `json:"fingerprint_rate,omitempty"`
+	// Bursty is set when either saw a burst of requests well above its rate
+	// over the last minute, Periodic when either's last requests were evenly
+	// spaced, as a script on a timer sends them
+	Bursty   bool `json:"bursty,omitempty"`
+	Periodic bool `json:"periodic,omitempty"`
+}
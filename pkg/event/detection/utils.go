@@ -0,0 +1,20 @@
+package detection
+
+import (
+	"net"
+	"net/http"
+)
+
+// hostIP returns addr without its port, if it has one
+func hostIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+		return host
+	}
+	return addr
+}
+
+// peerIP returns the address of r's direct peer. Forwarding headers are
+// left to event.ClientIP, which knows the trusted proxies.
+func peerIP(r *http.Request) string {
+	return hostIP(r.RemoteAddr)
+}
@@ -0,0 +1,229 @@
+package event
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event/detection"
+)
+
+// RequestIDHeader carries the request ID. GoTrack's middleware sets it on
+// every request and echoes it in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// Normalize fields that the server can set/augment safely.
+func EnrichServerFields(r *http.Request, e *Event, cfg config.Config) {
+	// UA
+	if e.Device.UA == "" {
+		e.Device.UA = r.UserAgent()
+	}
+	applyClientHints(r.Header, e)
+	if cfg.ParseUserAgent {
+		applyUserAgent(e)
+	}
+	// Referrer
+	if e.URL.Referrer == "" {
+		e.URL.Referrer = r.Referer()
+		if u, err := url.Parse(e.URL.Referrer); err == nil && u != nil {
+			e.URL.ReferrerHostname = u.Hostname()
+		}
+	}
+	// Raw query size (if not already set)
+	if e.URL.RawQuery == "" && r.URL != nil {
+		e.URL.RawQuery = r.URL.RawQuery
+		e.URL.QuerySize = len(e.URL.RawQuery)
+	}
+
+	// Parse common UTM/click-ids from URL if client didn't supply
+	parseUTMAndClickIDsFromRequest(r, e)
+
+	// IP hashing (coarse privacy)
+	e.Server.IP = ClientIP(r, cfg)
+	applyCDNGeo(r, e, cfg)
+
+	// Server-side detection signals (raw data, no scoring)
+	body := []byte{} // TODO: Pass actual body if available
+	e.Server.Detection = detection.AnalyzeServerDetectionSignalsForIP(r, body, e.Server.IP)
+	e.Server.Detection.IPClass = detection.DefaultIPClassifier.Classify(e.Server.IP)
+	e.Server.Detection.Honeypot = detection.DefaultTrapTracker.Trapped(e.Server.IP, e.Server.Detection.HeaderFingerprint)
+	e.Server.Detection.ObserveFingerprint(e.Server.IP, time.Now())
+
+	e.Server.RequestID = r.Header.Get(RequestIDHeader)
+
+	EnsureEventFields(e, cfg)
+}
+
+// EnsureEventFields gives e a UUID event_id, a ts, a type and a marketing
+// channel, and sets the server-owned received_at. It needs no request, so
+// imported events get the same guarantees as collected ones.
+func EnsureEventFields(e *Event, cfg config.Config) {
+	now := time.Now().UTC()
+	e.EventID = normalizeEventID(e.EventID)
+	if e.TS == "" {
+		e.TS = now.Format(time.RFC3339Nano)
+	}
+	e.ReceivedAt = ""
+	if cfg.RecordReceivedAt {
+		e.ReceivedAt = now.Format(time.RFC3339Nano)
+	}
+	e.Server.Instance = ""
+	if cfg.ClusterMode {
+		e.Server.Instance = cfg.InstanceID
+	}
+	if e.Type == "" {
+		e.Type = "pageview"
+	}
+	if e.URL.Channel == "" {
+		e.URL.Channel = DefaultChannelClassifier.Classify(*e)
+	}
+	if e.Ecommerce != nil {
+		e.Ecommerce.Currency = strings.ToUpper(e.Ecommerce.Currency)
+	}
+}
+
+// NewEventID returns a UUIDv7. Its leading bits are the creation time in
+// milliseconds, so generated IDs sort by time and keep B-tree indexes compact.
+func NewEventID() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.NewString()
+	}
+	return id.String()
+}
+
+// normalizeEventID returns a client-supplied ID in canonical UUID form, or a
+// new ID when it is missing or not a UUID. Sinks key and dedupe on event_id,
+// and Postgres stores it in a UUID column.
+func normalizeEventID(id string) string {
+	if id == "" {
+		return NewEventID()
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return NewEventID()
+	}
+	return parsed.String()
+}
+
+// ApplyPageURL fills the route from a page URL and, where not already set,
+// the UTM parameters and click IDs in its query. Server-side sources report
+// the page in a field rather than as the request URL.
+func ApplyPageURL(e *Event, raw string) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return
+	}
+	e.Route.Domain = u.Hostname()
+	e.Route.Path = u.Path
+	e.Route.FullPath = u.RequestURI()
+	e.Route.Protocol = u.Scheme
+	if u.Fragment != "" {
+		e.Route.Hash = "#" + u.Fragment
+	}
+	q := u.Query()
+	if len(q) > 0 {
+		e.Route.Query = make(map[string]string, len(q))
+		for k := range q {
+			e.Route.Query[k] = q.Get(k)
+		}
+	}
+
+	parseUTMParams(q, e)
+	parseGoogleParams(q, e)
+	parseMetaParams(q, e)
+	parseMicrosoftParams(q, e)
+	parseOtherClickIDs(q, e)
+}
+
+// PropValue formats a decoded JSON value for Props: numbers without exponent,
+// and objects and arrays, such as ecommerce items, as JSON
+func PropValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// Extract UTM & known click ids directly from the request URL (server-side fallback).
+func parseUTMAndClickIDsFromRequest(r *http.Request, e *Event) {
+	if r.URL == nil {
+		return
+	}
+	ApplyCampaignParams(r.URL.Query(), e)
+}
+
+// ApplyCampaignParams fills e's UTM parameters and click IDs from q, such as
+// the query string of a landing page URL, keeping values e already has
+func ApplyCampaignParams(q url.Values, e *Event) {
+	parseUTMParams(q, e)
+	parseGoogleParams(q, e)
+	parseMetaParams(q, e)
+	parseMicrosoftParams(q, e)
+	parseOtherClickIDs(q, e)
+}
+
+func parseUTMParams(q url.Values, e *Event) {
+	setIfEmpty(&e.URL.UTM.Source, q.Get("utm_source"))
+	setIfEmpty(&e.URL.UTM.Medium, q.Get("utm_medium"))
+	setIfEmpty(&e.URL.UTM.Campaign, q.Get("utm_campaign"))
+	setIfEmpty(&e.URL.UTM.Term, q.Get("utm_term"))
+	setIfEmpty(&e.URL.UTM.Content, q.Get("utm_content"))
+	setIfEmpty(&e.URL.UTM.ID, q.Get("utm_id"))
+	setIfEmpty(&e.URL.UTM.CampaignID, q.Get("utm_campaign_id"))
+}
+
+func parseGoogleParams(q url.Values, e *Event) {
+	setIfEmpty(&e.URL.Google.GCLID, q.Get("gclid"))
+	setIfEmpty(&e.URL.Google.GCLSRC, q.Get("gclsrc"))
+	setIfEmpty(&e.URL.Google.GBRAID, q.Get("gbraid"))
+	setIfEmpty(&e.URL.Google.WBRAID, q.Get("wbraid"))
+	setIfEmpty(&e.URL.Google.CampaignID, q.Get("campaignid"))
+	setIfEmpty(&e.URL.Google.AdGroupID, q.Get("adgroupid"))
+	setIfEmpty(&e.URL.Google.AdID, q.Get("creative"))
+	setIfEmpty(&e.URL.Google.KeywordID, q.Get("keyword"))
+	setIfEmpty(&e.URL.Google.MatchType, q.Get("matchtype"))
+	setIfEmpty(&e.URL.Google.Network, q.Get("network"))
+	setIfEmpty(&e.URL.Google.Device, q.Get("device"))
+	setIfEmpty(&e.URL.Google.Placement, q.Get("placement"))
+}
+
+func parseMetaParams(q url.Values, e *Event) {
+	setIfEmpty(&e.URL.Meta.FBCLID, q.Get("fbclid"))
+	setIfEmpty(&e.URL.Meta.FBC, q.Get("fbc"))
+	setIfEmpty(&e.URL.Meta.FBP, q.Get("fbp"))
+	setIfEmpty(&e.URL.Meta.CampaignID, q.Get("campaign_id"))
+	setIfEmpty(&e.URL.Meta.AdSetID, q.Get("adset_id"))
+	setIfEmpty(&e.URL.Meta.AdID, q.Get("ad_id"))
+}
+
+func parseMicrosoftParams(q url.Values, e *Event) {
+	setIfEmpty(&e.URL.Microsoft.MSCLKID, q.Get("msclkid"))
+}
+
+func parseOtherClickIDs(q url.Values, e *Event) {
+	if e.URL.OtherIDs == nil {
+		e.URL.OtherIDs = map[string]string{}
+	}
+	DefaultClickIDs.copyTo(q, e.URL.OtherIDs)
+}
+
+func setIfEmpty(dst *string, value string) {
+	if *dst == "" {
+		*dst = value
+	}
+}
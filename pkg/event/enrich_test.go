@@ -3,11 +3,12 @@ package event
 import (
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event/detection"
 )
 
 func TestEnrichServerFields_Timestamp(t *testing.T) {
@@ -79,6 +80,20 @@ func TestEnrichServerFields_UserAgent(t *testing.T) {
 			t.Errorf("UA = %v, want Client UA", e.Device.UA)
 		}
 	})
+
+	t.Run("parses device details only when enabled", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0")
+		e := &Event{}
+		EnrichServerFields(req, e, config.Config{})
+		if e.Device.Browser != "" {
+			t.Errorf("Browser = %v with parsing disabled", e.Device.Browser)
+		}
+		EnrichServerFields(req, e, config.Config{ParseUserAgent: true})
+		if e.Device.Browser != "Firefox" || e.Device.BrowserVersion != "121.0" || e.Device.DeviceType != DeviceTypeDesktop {
+			t.Errorf("Device = %+v, want Firefox 121.0 on desktop", e.Device)
+		}
+	})
 }
 
 func TestEnrichServerFields_Referrer(t *testing.T) {
@@ -93,6 +108,9 @@ func TestEnrichServerFields_Referrer(t *testing.T) {
 		if e.URL.ReferrerHostname != "google.com" {
 			t.Errorf("ReferrerHostname = %v, want google.com", e.URL.ReferrerHostname)
 		}
+		if e.URL.Channel != ChannelOrganicSearch {
+			t.Errorf("Channel = %v, want %v", e.URL.Channel, ChannelOrganicSearch)
+		}
 	})
 
 	t.Run("preserves existing referrer", func(t *testing.T) {
@@ -172,6 +190,120 @@ func TestEnrichServerFields_Detection(t *testing.T) {
 			t.Error("detection header fingerprint should be set")
 		}
 	})
+
+	t.Run("classifies the client IP", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/collect", nil)
+		req.RemoteAddr = "3.5.140.2:443"
+		e := &Event{}
+		EnrichServerFields(req, e, config.Config{})
+		if e.Server.Detection.IPClass != detection.IPClassDatacenter {
+			t.Errorf("IPClass = %q, want datacenter", e.Server.Detection.IPClass)
+		}
+	})
+}
+
+func TestEnrichServerFields_RequestID(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/collect", nil)
+	req.Header.Set(RequestIDHeader, "req-42")
+	e := &Event{Server: ServerMeta{RequestID: "client-supplied"}}
+	EnrichServerFields(req, e, config.Config{})
+	if e.Server.RequestID != "req-42" {
+		t.Errorf("Server.RequestID = %q, want req-42", e.Server.RequestID)
+	}
+}
+
+func TestEnrichServerFields_Currency(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/collect", nil)
+	e := &Event{Ecommerce: &EcommerceInfo{Value: 10, Currency: "eur"}}
+	EnrichServerFields(req, e, config.Config{})
+	if e.Ecommerce.Currency != "EUR" {
+		t.Errorf("Ecommerce.Currency = %q, want EUR", e.Ecommerce.Currency)
+	}
+}
+
+func TestEnrichServerFields_EventID(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/collect", nil)
+
+	t.Run("generates a UUIDv7 when empty", func(t *testing.T) {
+		e := &Event{}
+		EnrichServerFields(req, e, config.Config{})
+		id, err := uuid.Parse(e.EventID)
+		if err != nil || id.Version() != 7 {
+			t.Errorf("event_id = %q, want a UUIDv7", e.EventID)
+		}
+	})
+
+	t.Run("canonicalizes a client UUID", func(t *testing.T) {
+		e := &Event{EventID: "{0190B8A2-6C1E-7A3B-8F2D-1C4E5A6B7C8D}"}
+		EnrichServerFields(req, e, config.Config{})
+		if e.EventID != "0190b8a2-6c1e-7a3b-8f2d-1c4e5a6b7c8d" {
+			t.Errorf("event_id = %q, want canonical form", e.EventID)
+		}
+	})
+
+	t.Run("replaces a malformed client ID", func(t *testing.T) {
+		e := &Event{EventID: "evt-123"}
+		EnrichServerFields(req, e, config.Config{})
+		if _, err := uuid.Parse(e.EventID); err != nil {
+			t.Errorf("event_id = %q, want a generated UUID", e.EventID)
+		}
+	})
+}
+
+func TestNewEventID(t *testing.T) {
+	prev := NewEventID()
+	for i := 0; i < 100; i++ {
+		id := NewEventID()
+		if id <= prev {
+			t.Fatalf("NewEventID() = %s after %s, want increasing IDs", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestEnrichServerFields_ReceivedAt(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/collect", nil)
+
+	t.Run("disabled clears a client value", func(t *testing.T) {
+		e := &Event{ReceivedAt: "2020-01-01T00:00:00Z"}
+		EnrichServerFields(req, e, config.Config{})
+		if e.ReceivedAt != "" {
+			t.Errorf("received_at = %q, want empty", e.ReceivedAt)
+		}
+	})
+
+	t.Run("enabled records server time and keeps client ts", func(t *testing.T) {
+		e := &Event{TS: "2024-01-01T12:00:00Z", ReceivedAt: "2020-01-01T00:00:00Z"}
+		before := time.Now().UTC()
+		EnrichServerFields(req, e, config.Config{RecordReceivedAt: true})
+		received, err := time.Parse(time.RFC3339Nano, e.ReceivedAt)
+		if err != nil || received.Before(before) {
+			t.Errorf("received_at = %q, want the receive time", e.ReceivedAt)
+		}
+		if e.TS != "2024-01-01T12:00:00Z" {
+			t.Errorf("ts = %q, want the client value", e.TS)
+		}
+	})
+
+	t.Run("defaulted ts matches received_at", func(t *testing.T) {
+		e := &Event{}
+		EnrichServerFields(req, e, config.Config{RecordReceivedAt: true})
+		if e.TS != e.ReceivedAt {
+			t.Errorf("ts = %q, received_at = %q, want equal", e.TS, e.ReceivedAt)
+		}
+	})
+}
+
+func TestEnsureEventFields_Instance(t *testing.T) {
+	e := &Event{Server: ServerMeta{Instance: "spoofed"}}
+	EnsureEventFields(e, config.Config{InstanceID: "gotrack-0"})
+	if e.Server.Instance != "" {
+		t.Errorf("instance = %q outside cluster mode, want empty", e.Server.Instance)
+	}
+	EnsureEventFields(e, config.Config{ClusterMode: true, InstanceID: "gotrack-0"})
+	if e.Server.Instance != "gotrack-0" {
+		t.Errorf("instance = %q, want gotrack-0", e.Server.Instance)
+	}
 }
 
 func assertUTMFields(t *testing.T, utm UTMInfo, expected map[string]string) {
@@ -328,74 +460,41 @@ func TestParseUTMAndClickIDsFromRequest(t *testing.T) {
 	})
 }
 
-func TestCopyIf(t *testing.T) {
-	t.Run("copies non-empty values", func(t *testing.T) {
-		q := url.Values{
-			"key1": []string{"value1"},
-			"key2": []string{"value2"},
-			"key3": []string{"value3"},
-		}
-		dst := map[string]string{}
-
-		copyIf(q, dst, "key1", "key2")
-
-		if dst["key1"] != "value1" {
-			t.Errorf("dst[key1] = %v, want value1", dst["key1"])
-		}
-		if dst["key2"] != "value2" {
-			t.Errorf("dst[key2] = %v, want value2", dst["key2"])
-		}
-		if _, exists := dst["key3"]; exists {
-			t.Error("key3 should not be copied")
-		}
-	})
-
-	t.Run("skips empty and whitespace values", func(t *testing.T) {
-		q := url.Values{
-			"empty":      []string{""},
-			"whitespace": []string{"  \t  "},
-			"valid":      []string{"value"},
-		}
-		dst := map[string]string{}
-
-		copyIf(q, dst, "empty", "whitespace", "valid")
-
-		if _, exists := dst["empty"]; exists {
-			t.Error("empty key should not be copied")
+func TestApplyPageURL(t *testing.T) {
+	t.Run("fills route and attribution", func(t *testing.T) {
+		e := &Event{}
+		e.URL.UTM.Source = "newsletter"
+		ApplyPageURL(e, "https://shop.example.com/p/42?utm_source=google&utm_medium=cpc&gclid=abc#reviews")
+		if e.Route.Domain != "shop.example.com" || e.Route.Path != "/p/42" || e.Route.Protocol != "https" || e.Route.Hash != "#reviews" {
+			t.Errorf("Route = %+v", e.Route)
 		}
-		if _, exists := dst["whitespace"]; exists {
-			t.Error("whitespace key should not be copied")
+		if e.Route.FullPath != "/p/42?utm_source=google&utm_medium=cpc&gclid=abc" || e.Route.Query["gclid"] != "abc" {
+			t.Errorf("FullPath = %q, Query = %v", e.Route.FullPath, e.Route.Query)
 		}
-		if dst["valid"] != "value" {
-			t.Errorf("dst[valid] = %v, want value", dst["valid"])
+		if e.URL.UTM.Source != "newsletter" || e.URL.UTM.Medium != "cpc" || e.URL.Google.GCLID != "abc" {
+			t.Errorf("UTM = %+v, GCLID = %q", e.URL.UTM, e.URL.Google.GCLID)
 		}
 	})
 
-	t.Run("handles missing keys", func(t *testing.T) {
-		q := url.Values{
-			"exists": []string{"value"},
-		}
-		dst := map[string]string{}
-
-		copyIf(q, dst, "exists", "missing")
-
-		if dst["exists"] != "value" {
-			t.Errorf("dst[exists] = %v, want value", dst["exists"])
-		}
-		if _, exists := dst["missing"]; exists {
-			t.Error("missing key should not be copied")
+	t.Run("ignores relative and invalid URLs", func(t *testing.T) {
+		for _, raw := range []string{"", "/p/42?utm_source=x", "://bad"} {
+			e := &Event{}
+			ApplyPageURL(e, raw)
+			if e.Route.Path != "" || e.URL.UTM.Source != "" {
+				t.Errorf("ApplyPageURL(%q) set %+v", raw, e.Route)
+			}
 		}
 	})
 }
 
-func TestClientIPFromRequest(t *testing.T) {
+func TestClientIP(t *testing.T) {
 	t.Run("returns RemoteAddr when proxy not trusted", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
 		req.RemoteAddr = "192.168.1.100:12345"
 		req.Header.Set("X-Forwarded-For", "203.0.113.1")
 		req.Header.Set("X-Real-IP", "203.0.113.2")
 
-		ip := clientIPFromRequest(req, false)
+		ip := ClientIP(req, config.Config{TrustProxy: false})
 
 		if ip != "192.168.1.100" {
 			t.Errorf("ip = %v, want 192.168.1.100", ip)
@@ -407,7 +506,7 @@ func TestClientIPFromRequest(t *testing.T) {
 		req.RemoteAddr = "10.0.0.1:12345"
 		req.Header.Set("X-Forwarded-For", "203.0.113.1, 198.51.100.1, 10.0.0.2")
 
-		ip := clientIPFromRequest(req, true)
+		ip := ClientIP(req, config.Config{TrustProxy: true})
 
 		if ip != "203.0.113.1" {
 			t.Errorf("ip = %v, want 203.0.113.1", ip)
@@ -419,7 +518,7 @@ func TestClientIPFromRequest(t *testing.T) {
 		req.RemoteAddr = "10.0.0.1:12345"
 		req.Header.Set("X-Forwarded-For", "  203.0.113.1  , 198.51.100.1")
 
-		ip := clientIPFromRequest(req, true)
+		ip := ClientIP(req, config.Config{TrustProxy: true})
 
 		if ip != "203.0.113.1" {
 			t.Errorf("ip = %v, want 203.0.113.1", ip)
@@ -431,7 +530,7 @@ func TestClientIPFromRequest(t *testing.T) {
 		req.RemoteAddr = "10.0.0.1:12345"
 		req.Header.Set("X-Real-IP", "203.0.113.5")
 
-		ip := clientIPFromRequest(req, true)
+		ip := ClientIP(req, config.Config{TrustProxy: true})
 
 		if ip != "203.0.113.5" {
 			t.Errorf("ip = %v, want 203.0.113.5", ip)
@@ -443,7 +542,7 @@ func TestClientIPFromRequest(t *testing.T) {
 		req.RemoteAddr = "10.0.0.1:12345"
 		req.Header.Set("X-Real-IP", "  203.0.113.5  ")
 
-		ip := clientIPFromRequest(req, true)
+		ip := ClientIP(req, config.Config{TrustProxy: true})
 
 		if ip != "203.0.113.5" {
 			t.Errorf("ip = %v, want 203.0.113.5", ip)
@@ -454,7 +553,7 @@ func TestClientIPFromRequest(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
 		req.RemoteAddr = "192.168.1.100:12345"
 
-		ip := clientIPFromRequest(req, true)
+		ip := ClientIP(req, config.Config{TrustProxy: true})
 
 		if ip != "192.168.1.100" {
 			t.Errorf("ip = %v, want 192.168.1.100", ip)
@@ -465,7 +564,7 @@ func TestClientIPFromRequest(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
 		req.RemoteAddr = "192.168.1.100"
 
-		ip := clientIPFromRequest(req, false)
+		ip := ClientIP(req, config.Config{TrustProxy: false})
 
 		if ip != "192.168.1.100" {
 			t.Errorf("ip = %v, want 192.168.1.100", ip)
@@ -476,7 +575,7 @@ func TestClientIPFromRequest(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
 		req.RemoteAddr = "invalid::address::format"
 
-		ip := clientIPFromRequest(req, false)
+		ip := ClientIP(req, config.Config{TrustProxy: false})
 
 		if ip != "invalid::address::format" {
 			t.Errorf("ip = %v, want invalid::address::format", ip)
@@ -489,7 +588,7 @@ func TestClientIPFromRequest(t *testing.T) {
 		req.Header.Set("X-Forwarded-For", "203.0.113.1")
 		req.Header.Set("X-Real-IP", "203.0.113.2")
 
-		ip := clientIPFromRequest(req, true)
+		ip := ClientIP(req, config.Config{TrustProxy: true})
 
 		if ip != "203.0.113.1" {
 			t.Errorf("ip = %v, want 203.0.113.1 (X-Forwarded-For should take precedence)", ip)
@@ -500,7 +599,7 @@ func TestClientIPFromRequest(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
 		req.RemoteAddr = "[2001:db8::1]:12345"
 
-		ip := clientIPFromRequest(req, false)
+		ip := ClientIP(req, config.Config{TrustProxy: false})
 
 		if ip != "2001:db8::1" {
 			t.Errorf("ip = %v, want 2001:db8::1", ip)
@@ -508,6 +607,89 @@ func TestClientIPFromRequest(t *testing.T) {
 	})
 }
 
+// TestClientIPTrustedProxies tests TRUSTED_PROXY_CIDRS handling
+func TestClientIPTrustedProxies(t *testing.T) {
+	cfg := config.Config{TrustProxy: true, TrustedProxyCIDRs: []string{"10.0.0.0/8", "192.0.2.7"}}
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		want   string
+	}{
+		{"untrusted peer ignores headers", "198.51.100.9:1234", "203.0.113.1", "198.51.100.9"},
+		{"stops at first untrusted hop", "10.0.0.1:1234", "1.1.1.1, 203.0.113.1, 10.0.0.2", "203.0.113.1"},
+		{"bare address is trusted", "192.0.2.7:1234", "203.0.113.1, 192.0.2.7", "203.0.113.1"},
+		{"all hops trusted", "10.0.0.1:1234", "10.1.1.1, 10.0.0.2", "10.1.1.1"},
+		{"malformed hop", "10.0.0.1:1234", "203.0.113.1, unknown, 10.0.0.2", "10.0.0.2"},
+		{"ipv4-mapped peer", "[::ffff:10.0.0.1]:1234", "203.0.113.1", "203.0.113.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			req.Header.Set("X-Forwarded-For", tt.xff)
+			if ip := ClientIP(req, cfg); ip != tt.want {
+				t.Errorf("ClientIP() = %v, want %v", ip, tt.want)
+			}
+		})
+	}
+
+	t.Run("ranges override TRUST_PROXY=false", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", "203.0.113.1")
+		if !TrustedPeer(req, config.Config{TrustedProxyCIDRs: []string{"10.0.0.0/8"}}) {
+			t.Error("peer inside TRUSTED_PROXY_CIDRS should be trusted")
+		}
+	})
+}
+
+// TestEnrichServerFields_DetectionRates tests that detection counts requests
+// by the trusted client address
+func TestEnrichServerFields_DetectionRates(t *testing.T) {
+	saved := detection.DefaultRateTracker
+	detection.DefaultRateTracker = detection.NewMemoryRateTracker(100)
+	defer func() { detection.DefaultRateTracker = saved }()
+
+	enrich := func(remote, xff string, cfg config.Config) *detection.RequestRate {
+		req := httptest.NewRequest(http.MethodPost, "/collect", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-For", xff)
+		e := Event{}
+		EnrichServerFields(req, &e, cfg)
+		return e.Server.Detection.TimingAnalysis.IPRate
+	}
+
+	// A spoofed X-Forwarded-For from an untrusted peer doesn't split its
+	// count, whatever it claims and whichever port it comes from
+	enrich("198.51.100.9:1111", "203.0.113.1", config.Config{})
+	if rate := enrich("198.51.100.9:2222", "203.0.113.2", config.Config{}); rate == nil || rate.Last1m != 2 {
+		t.Errorf("untrusted peer rate = %+v, want 2 requests", rate)
+	}
+
+	// Behind a trusted proxy each forwarded client has its own count
+	cfg := config.Config{TrustedProxyCIDRs: []string{"10.0.0.0/8"}}
+	if rate := enrich("10.0.0.1:1111", "203.0.113.3", cfg); rate == nil || rate.Last1m != 1 {
+		t.Errorf("forwarded client rate = %+v, want 1 request", rate)
+	}
+}
+
+// TestParseTrustedProxies tests TRUSTED_PROXY_CIDRS parsing
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := ParseTrustedProxies([]string{"10.0.0.0/8", " 192.0.2.7 ", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies() error = %v", err)
+	}
+	if len(prefixes) != 3 || prefixes[1].Bits() != 32 {
+		t.Errorf("ParseTrustedProxies() = %v", prefixes)
+	}
+	for _, bad := range []string{"10.0.0.0/33", "proxy.internal", ""} {
+		if _, err := ParseTrustedProxies([]string{bad}); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) expected error", bad)
+		}
+	}
+}
+
 func TestEnrichServerFieldsIntegration(t *testing.T) {
 	t.Run("enriches complete event with all parameters", func(t *testing.T) {
 		reqURL := "/page?utm_source=google&utm_campaign=test&gclid=abc123&fbclid=fb456&ttclid=tt789&foo=bar"
@@ -560,3 +742,18 @@ func TestEnrichServerFieldsIntegration(t *testing.T) {
 		}
 	})
 }
+
+func BenchmarkEnrichServerFields(b *testing.B) {
+	req := httptest.NewRequest(http.MethodPost, "/collect?utm_source=google&utm_medium=cpc&gclid=Cj0KCQiA", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36")
+	req.Header.Set("Referer", "https://www.google.com/")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("X-Forwarded-For", "203.0.113.42")
+	cfg := config.Config{TrustProxy: true, TrustedProxyCIDRs: []string{"192.0.2.0/24"}}
+
+	b.ReportAllocs()
+	for range b.N {
+		e := Event{Type: "pageview", Route: RouteInfo{Domain: "example.com", Path: "/pricing"}}
+		EnrichServerFields(req, &e, cfg)
+	}
+}
@@ -0,0 +1,243 @@
+// Package event defines the GoTrack event schema and the server-side
+// enrichment applied to incoming requests.
+//
+// The JSON shape of Event is stable within a major version: fields may be
+// added, but existing fields are not renamed, retyped or removed. New fields
+// go at the end of their struct, since the Kafka sink's Avro and Protobuf
+// schemas are generated in declaration order.
+package event
+
+import "github.com/shortontech/gotrack/pkg/event/detection"
+
+// High-level envelope. Optional fields are omitted when empty.
+type Event struct {
+	EventID string `json:"event_id,omitempty"`
+	TS      string `json:"ts,omitempty"`   // ISO8601
+	Type    string `json:"type,omitempty"` // "pageview", "click", etc.
+
+	// SampleRate is the probability this event was kept (1 = unsampled); weight counts by 1/SampleRate
+	SampleRate float64 `json:"sample_rate,omitempty"`
+
+	URL     URLInfo     `json:"url,omitempty"`
+	Route   RouteInfo   `json:"route,omitempty"`
+	Device  DeviceInfo  `json:"device,omitempty"`
+	Session SessionInfo `json:"session,omitempty"`
+	Server  ServerMeta  `json:"server,omitempty"`
+
+	// SiteID is the tenant resolved from the request's write key; empty in single-tenant deployments
+	SiteID string `json:"site_id,omitempty"`
+
+	// ReceivedAt is the server time the event arrived, next to the client-reported TS (RECORD_RECEIVED_AT)
+	ReceivedAt string `json:"received_at,omitempty"`
+
+	// Props holds event properties without a dedicated field, such as GA4 event params.
+	// Values are strings so every sink and serialization can carry them; structured
+	// values are stored as JSON.
+	Props map[string]string `json:"props,omitempty"`
+
+	// Ecommerce is the cart, checkout step or order of an ecommerce event; nil for other events
+	Ecommerce *EcommerceInfo `json:"ecommerce,omitempty"`
+
+	// Consent is the visitor's IAB TCF consent and what GoTrack decoded from it; nil without TCF signals
+	Consent *ConsentInfo `json:"consent,omitempty"`
+
+	// Experiments maps each A/B experiment the visitor is in to their variant (EXPERIMENTS_FILE)
+	Experiments map[string]string `json:"experiments,omitempty"`
+}
+
+// --- URL / attribution ---
+
+type URLInfo struct {
+	UTM       UTMInfo           `json:"utm,omitempty"`
+	Google    GoogleAdsInfo     `json:"google,omitempty"`
+	Meta      MetaAdsInfo       `json:"meta,omitempty"`
+	Microsoft MicrosoftAdsInfo  `json:"microsoft,omitempty"`
+	OtherIDs  map[string]string `json:"other_click_ids,omitempty"` // ttclid, li_fat_id, epik, twclid, etc.; see ClickIDRegistry
+
+	Referrer         string `json:"referrer,omitempty"`
+	ReferrerHostname string `json:"referrer_hostname,omitempty"`
+	RawQuery         string `json:"raw_query,omitempty"`
+	QuerySize        int    `json:"query_size,omitempty"`
+	Channel          string `json:"channel,omitempty"` // marketing channel, e.g. organic_search; see ChannelClassifier
+}
+
+type UTMInfo struct {
+	Source     string `json:"source,omitempty"`
+	Medium     string `json:"medium,omitempty"`
+	Campaign   string `json:"campaign,omitempty"`
+	Term       string `json:"term,omitempty"`
+	Content    string `json:"content,omitempty"`
+	ID         string `json:"id,omitempty"`
+	CampaignID string `json:"campaign_id,omitempty"`
+}
+
+type GoogleAdsInfo struct {
+	GCLID  string `json:"gclid,omitempty"`
+	GCLSRC string `json:"gclsrc,omitempty"`
+	GBRAID string `json:"gbraid,omitempty"`
+	WBRAID string `json:"wbraid,omitempty"`
+
+	CampaignID string `json:"campaign_id,omitempty"`
+	AdGroupID  string `json:"ad_group_id,omitempty"`
+	AdID       string `json:"ad_id,omitempty"`
+	KeywordID  string `json:"keyword_id,omitempty"`
+
+	// Common ValueTrack extras (optional)
+	MatchType string `json:"matchtype,omitempty"`
+	Network   string `json:"network,omitempty"`
+	Device    string `json:"device,omitempty"`
+	Placement string `json:"placement,omitempty"`
+}
+
+type MetaAdsInfo struct {
+	FBCLID     string `json:"fbclid,omitempty"`
+	FBC        string `json:"fbc,omitempty"`
+	FBP        string `json:"fbp,omitempty"`
+	CampaignID string `json:"campaign_id,omitempty"`
+	AdSetID    string `json:"adset_id,omitempty"`
+	AdID       string `json:"ad_id,omitempty"`
+}
+
+type MicrosoftAdsInfo struct {
+	MSCLKID string `json:"msclkid,omitempty"`
+}
+
+// --- Ecommerce ---
+
+// EcommerceInfo is the cart, checkout step or order an ecommerce event is
+// about. OrderID is the transaction ID once there is one. Value is the
+// revenue in Currency, an ISO 4217 code upper-cased during enrichment; tax
+// and shipping are reported apart from it.
+type EcommerceInfo struct {
+	OrderID  string          `json:"order_id,omitempty"`
+	Value    float64         `json:"value"`
+	Currency string          `json:"currency,omitempty"`
+	Tax      float64         `json:"tax,omitempty"`
+	Shipping float64         `json:"shipping,omitempty"`
+	Coupon   string          `json:"coupon,omitempty"`
+	Items    []EcommerceItem `json:"items,omitempty"`
+
+	Subtotal       float64 `json:"subtotal,omitempty"`        // the items' total before discount, tax and shipping
+	Discount       float64 `json:"discount,omitempty"`        // order-level discount
+	CheckoutStep   int     `json:"checkout_step,omitempty"`   // step of the checkout funnel, from 1
+	CheckoutOption string  `json:"checkout_option,omitempty"` // choice made at the step, e.g. the shipping method
+}
+
+// EcommerceItem is one line of a cart or order, named after GA4's item
+// parameters. ItemID is the SKU.
+type EcommerceItem struct {
+	ItemID   string  `json:"item_id,omitempty"`
+	ItemName string  `json:"item_name,omitempty"`
+	Category string  `json:"item_category,omitempty"`
+	Brand    string  `json:"item_brand,omitempty"`
+	Variant  string  `json:"item_variant,omitempty"`
+	Price    float64 `json:"price,omitempty"` // unit price
+	Quantity int     `json:"quantity,omitempty"`
+	Discount float64 `json:"discount,omitempty"` // unit discount included in Price
+}
+
+// --- Consent ---
+
+// ConsentInfo is the visitor's IAB Transparency and Consent Framework
+// signal. Clients send TCString and GDPRApplies; with TCF_ACTION set the
+// server fills the rest from the decoded string.
+type ConsentInfo struct {
+	TCString    string `json:"tc_string,omitempty"`
+	GDPRApplies *bool  `json:"gdpr_applies,omitempty"`
+
+	CMPID               int   `json:"cmp_id,omitempty"`               // consent management platform that wrote TCString
+	Purposes            []int `json:"purposes,omitempty"`             // TCF purposes consented to
+	LegitimateInterests []int `json:"legitimate_interests,omitempty"` // TCF purposes with legitimate interest established
+	VendorConsent       *bool `json:"vendor_consent,omitempty"`       // consent or legitimate interest for TCF_VENDOR_ID; nil when unset
+	Measurement         *bool `json:"measurement,omitempty"`          // whether TCF_PURPOSES and the vendor are allowed
+}
+
+// --- Route ---
+
+type RouteInfo struct {
+	Domain       string            `json:"domain,omitempty"`
+	Path         string            `json:"path,omitempty"`
+	FullPath     string            `json:"fullPath,omitempty"`
+	Hash         string            `json:"hash,omitempty"`
+	CanonicalURL string            `json:"canonical_url,omitempty"`
+	Title        string            `json:"title,omitempty"`
+	Protocol     string            `json:"protocol,omitempty"`
+	Query        map[string]string `json:"query,omitempty"`
+}
+
+// --- Device ---
+
+type DeviceInfo struct {
+	UA       string   `json:"ua,omitempty"`
+	UABrands []string `json:"ua_brands,omitempty"`
+	UAMobile *bool    `json:"ua_mobile,omitempty"`
+
+	OS              string   `json:"os,omitempty"`
+	OSVersion       string   `json:"os_version,omitempty"`
+	Browser         string   `json:"browser,omitempty"`
+	BrowserVersion  string   `json:"browser_version,omitempty"`
+	DeviceType      string   `json:"device_type,omitempty"` // desktop, mobile, tablet or bot
+	DeviceBrand     string   `json:"brand,omitempty"`
+	DeviceModel     string   `json:"model,omitempty"`
+	Language        string   `json:"language,omitempty"`
+	Languages       []string `json:"languages,omitempty"`
+	TZ              string   `json:"tz,omitempty"`
+	TZOffsetMinutes int      `json:"tz_offset_minutes,omitempty"`
+
+	ViewportW        int     `json:"viewport_w,omitempty"`
+	ViewportH        int     `json:"viewport_h,omitempty"`
+	DevicePixelRatio float64 `json:"device_pixel_ratio,omitempty"`
+
+	HardwareConcurrency int `json:"hardware_concurrency,omitempty"`
+	DeviceMemoryGB      int `json:"device_memory,omitempty"`
+	MaxTouchPoints      int `json:"maxTouchPoints,omitempty"`
+
+	PrefersColorScheme   string `json:"prefers_color_scheme,omitempty"`
+	PrefersReducedMotion *bool  `json:"prefers_reduced_motion,omitempty"`
+
+	CookieEnabled    *bool `json:"cookie_enabled,omitempty"`
+	StorageAvailable *bool `json:"storage_available,omitempty"`
+
+	NetworkEffectiveType string  `json:"network_effective_type,omitempty"`
+	NetworkDownlinkMbps  float64 `json:"network_downlink,omitempty"`
+	NetworkRTT           int     `json:"network_rtt,omitempty"`
+	NetworkSaveData      *bool   `json:"network_save_data,omitempty"`
+
+	GPU      string       `json:"gpu,omitempty"`
+	Monitors int          `json:"monitors,omitempty"`
+	Screens  []ScreenInfo `json:"screens,omitempty"`
+}
+
+type ScreenInfo struct {
+	Width       int `json:"width,omitempty"`
+	Height      int `json:"height,omitempty"`
+	AvailWidth  int `json:"availWidth,omitempty"`
+	AvailHeight int `json:"availHeight,omitempty"`
+	ColorDepth  int `json:"colorDepth,omitempty"`
+	PixelDepth  int `json:"pixelDepth,omitempty"`
+}
+
+// --- Session / Event meta ---
+
+type SessionInfo struct {
+	VisitorID    string `json:"visitor_id,omitempty"`
+	SessionID    string `json:"session_id,omitempty"`
+	SessionStart string `json:"session_start_ts,omitempty"`
+	SessionSeq   int    `json:"session_seq,omitempty"`
+	FirstVisitTS string `json:"first_visit_ts,omitempty"`
+
+	// UserID is the application's ID for a signed-in user, as sent by server-side sources
+	UserID string `json:"user_id,omitempty"`
+}
+
+// --- Server enrich ---
+
+type ServerMeta struct {
+	IP               string                           `json:"ip_hash,omitempty"`           // hash of client IP (if enabled)
+	Geo              map[string]string                `json:"geo,omitempty"`               // coarse {country,region,city}
+	Detection        detection.ServerDetectionSignals `json:"detection,omitempty"`         // Raw detection signals
+	RequestID        string                           `json:"request_id,omitempty"`        // X-Request-ID of the ingesting request
+	ValidationIssues []string                         `json:"validation_issues,omitempty"` // rules the event broke, as field:code (VALIDATION_POLICY=flag)
+	Duplicate        bool                             `json:"duplicate,omitempty"`         // event_id was already seen within DEDUP_WINDOW (DEDUP_ACTION=flag)
+	Instance         string                           `json:"instance,omitempty"`          // INSTANCE_ID of the replica that ingested the event (CLUSTER_MODE)
+}
@@ -0,0 +1,43 @@
+package event
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/shortontech/gotrack/pkg/config"
+)
+
+// geoHeaders are the location headers a CDN adds, checked in order
+var geoHeaders = []struct{ country, region, city string }{
+	{"CF-IPCountry", "CF-Region-Code", "CF-IPCity"},
+	{"CloudFront-Viewer-Country", "CloudFront-Viewer-Country-Region", "CloudFront-Viewer-City"},
+}
+
+// applyCDNGeo replaces Server.Geo with the location a trusted CDN resolved
+// for the client. Without those headers the event keeps the geo it arrived
+// with, such as one an edge instance relayed.
+func applyCDNGeo(r *http.Request, e *Event, cfg config.Config) {
+	if !TrustedPeer(r, cfg) {
+		return
+	}
+	for _, h := range geoHeaders {
+		country := strings.ToUpper(strings.TrimSpace(r.Header.Get(h.country)))
+		// XX is unknown and T1 Tor on Cloudflare
+		if len(country) != 2 || country == "XX" || country == "T1" {
+			continue
+		}
+		geo := map[string]string{"country": country}
+		if region := strings.TrimSpace(r.Header.Get(h.region)); region != "" {
+			geo["region"] = region
+		}
+		if city := strings.TrimSpace(r.Header.Get(h.city)); city != "" {
+			if unescaped, err := url.QueryUnescape(city); err == nil {
+				city = unescaped
+			}
+			geo["city"] = city
+		}
+		e.Server.Geo = geo
+		return
+	}
+}
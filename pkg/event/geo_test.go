@@ -0,0 +1,71 @@
+package event
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/config"
+)
+
+func TestEnrichServerFields_Geo(t *testing.T) {
+	trusted := config.Config{TrustProxy: true}
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		headers map[string]string
+		geo     map[string]string // client-supplied
+		want    map[string]string
+	}{
+		{
+			name:    "cloudflare",
+			cfg:     trusted,
+			headers: map[string]string{"CF-IPCountry": "de", "CF-Region-Code": "BE", "CF-IPCity": "Berlin"},
+			want:    map[string]string{"country": "DE", "region": "BE", "city": "Berlin"},
+		},
+		{
+			name:    "cloudfront",
+			cfg:     trusted,
+			headers: map[string]string{"CloudFront-Viewer-Country": "FR", "CloudFront-Viewer-City": "Saint-%C3%89tienne"},
+			want:    map[string]string{"country": "FR", "city": "Saint-Étienne"},
+		},
+		{
+			name:    "replaces client geo",
+			cfg:     trusted,
+			headers: map[string]string{"CF-IPCountry": "NL"},
+			geo:     map[string]string{"country": "US", "city": "Austin"},
+			want:    map[string]string{"country": "NL"},
+		},
+		{
+			name:    "untrusted peer",
+			headers: map[string]string{"CF-IPCountry": "NL"},
+			geo:     map[string]string{"country": "US"},
+			want:    map[string]string{"country": "US"},
+		},
+		{
+			name:    "unknown country",
+			cfg:     trusted,
+			headers: map[string]string{"CF-IPCountry": "XX", "CloudFront-Viewer-Country": "IE"},
+			want:    map[string]string{"country": "IE"},
+		},
+		{
+			name:    "tor",
+			cfg:     trusted,
+			headers: map[string]string{"CF-IPCountry": "T1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/collect", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			e := &Event{Server: ServerMeta{Geo: tt.geo}}
+			EnrichServerFields(req, e, tt.cfg)
+			if !maps.Equal(e.Server.Geo, tt.want) {
+				t.Errorf("Server.Geo = %v, want %v", e.Server.Geo, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package event
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/ua-parser/uap-go/uaparser"
+)
+
+// uaCacheSize bounds the parsed user agents kept per lookup. An uncached
+// parse runs hundreds of regexes, while a handful of browser releases make up
+// most traffic.
+const uaCacheSize = 10000
+
+// Device types set in DeviceInfo.DeviceType
+const (
+	DeviceTypeDesktop = "desktop"
+	DeviceTypeMobile  = "mobile"
+	DeviceTypeTablet  = "tablet"
+	DeviceTypeBot     = "bot"
+)
+
+// uaParser compiles the ua-parser regexes on first use, so processes that
+// never enrich don't pay for them
+var uaParser = sync.OnceValue(func() *uaparser.Parser {
+	p, err := uaparser.New(uaparser.WithCacheSize(uaCacheSize))
+	if err != nil {
+		panic("event: ua-parser: " + err.Error())
+	}
+	return p
+})
+
+// applyUserAgent fills the browser, OS, device model and type the client
+// didn't supply from Device.UA
+func applyUserAgent(e *Event) {
+	ua := e.Device.UA
+	if ua == "" {
+		return
+	}
+	c := uaParser().Parse(ua)
+
+	brand, model := c.Device.Brand, c.Device.Model
+	// Chrome's reduced UA reports every Android phone as "Android 10; K"
+	frozen := model == "K" && c.Os.Family == "Android"
+	if frozen || strings.HasPrefix(brand, "Generic") {
+		brand = ""
+	}
+	if frozen || brand == "Spider" || c.Device.Family == "Other" {
+		brand, model = "", ""
+	}
+
+	d := &e.Device
+	if c.UserAgent.Family != "Other" {
+		setIfEmpty(&d.Browser, c.UserAgent.Family)
+		setIfEmpty(&d.BrowserVersion, joinVersion(c.UserAgent.Major, c.UserAgent.Minor, c.UserAgent.Patch))
+	}
+	if c.Os.Family != "Other" {
+		setIfEmpty(&d.OS, c.Os.Family)
+		if !frozen {
+			setIfEmpty(&d.OSVersion, joinVersion(c.Os.Major, c.Os.Minor, c.Os.Patch))
+		}
+	}
+	setIfEmpty(&d.DeviceBrand, brand)
+	setIfEmpty(&d.DeviceModel, model)
+	setIfEmpty(&d.DeviceType, deviceType(c, ua))
+}
+
+// deviceType classifies the parsed device. Android tablets are told apart
+// from phones by the missing "Mobile" token, as Google's UA guidelines ask.
+func deviceType(c *uaparser.Client, ua string) string {
+	switch {
+	case c.Device.Brand == "Spider":
+		return DeviceTypeBot
+	case c.Device.Family == "iPad" || c.Device.Brand == "Generic_Android_Tablet" || strings.Contains(ua, "Tablet"):
+		return DeviceTypeTablet
+	case c.Os.Family == "Android" && !strings.Contains(ua, "Mobile"):
+		return DeviceTypeTablet
+	case c.Os.Family == "iOS" || c.Os.Family == "Android" || strings.Contains(ua, "Mobi"):
+		return DeviceTypeMobile
+	case c.Os.Family != "Other":
+		return DeviceTypeDesktop
+	}
+	return ""
+}
+
+// joinVersion joins the non-empty leading version parts with dots
+func joinVersion(parts ...string) string {
+	n := 0
+	for n < len(parts) && parts[n] != "" {
+		n++
+	}
+	return strings.Join(parts[:n], ".")
+}
@@ -0,0 +1,80 @@
+package event
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want DeviceInfo
+	}{
+		{
+			"windows chrome",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			DeviceInfo{Browser: "Chrome", BrowserVersion: "120.0.0", OS: "Windows", OSVersion: "10", DeviceType: DeviceTypeDesktop},
+		},
+		{
+			"iphone",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			DeviceInfo{Browser: "Mobile Safari", BrowserVersion: "17.1", OS: "iOS", OSVersion: "17.1", DeviceBrand: "Apple", DeviceModel: "iPhone", DeviceType: DeviceTypeMobile},
+		},
+		{
+			"ipad",
+			"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			DeviceInfo{Browser: "Mobile Safari", BrowserVersion: "16.6", OS: "iOS", OSVersion: "16.6", DeviceBrand: "Apple", DeviceModel: "iPad", DeviceType: DeviceTypeTablet},
+		},
+		{
+			"android phone",
+			"Mozilla/5.0 (Linux; Android 13; SM-S911B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Mobile Safari/537.36",
+			DeviceInfo{Browser: "Chrome Mobile", BrowserVersion: "116.0.0", OS: "Android", OSVersion: "13", DeviceBrand: "Samsung", DeviceModel: "SM-S911B", DeviceType: DeviceTypeMobile},
+		},
+		{
+			"android tablet",
+			"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			DeviceInfo{Browser: "Chrome", BrowserVersion: "120.0.0", OS: "Android", OSVersion: "13", DeviceModel: "SM-X700", DeviceType: DeviceTypeTablet},
+		},
+		{
+			"reduced android ua",
+			"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			DeviceInfo{Browser: "Chrome Mobile", BrowserVersion: "120.0.0", OS: "Android", DeviceType: DeviceTypeMobile},
+		},
+		{
+			"crawler",
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			DeviceInfo{Browser: "Googlebot", BrowserVersion: "2.1", DeviceType: DeviceTypeBot},
+		},
+		{
+			"unknown",
+			"curl/8.4.0",
+			DeviceInfo{Browser: "curl", BrowserVersion: "8.4.0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Event{Device: DeviceInfo{UA: tt.ua}}
+			applyUserAgent(e)
+			tt.want.UA = tt.ua
+			if !reflect.DeepEqual(e.Device, tt.want) {
+				t.Errorf("Device = %+v\nwant %+v", e.Device, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyUserAgent_KeepsClientFields(t *testing.T) {
+	e := &Event{Device: DeviceInfo{
+		UA:      "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+		OS:      "macOS",
+		Browser: "Safari",
+	}}
+	applyUserAgent(e)
+	if e.Device.OS != "macOS" || e.Device.Browser != "Safari" {
+		t.Errorf("client fields overwritten: os = %q, browser = %q", e.Device.OS, e.Device.Browser)
+	}
+	if e.Device.BrowserVersion != "17.1" || e.Device.DeviceType != DeviceTypeDesktop {
+		t.Errorf("missing fields not filled: %+v", e.Device)
+	}
+}
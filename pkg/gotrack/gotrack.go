@@ -0,0 +1,95 @@
+// Package gotrack runs the GoTrack server inside another Go program, so it
+// can deliver events to sinks the program provides next to the built-in
+// outputs:
+//
+//	cfg, err := config.LoadWithFile()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Fatal(gotrack.New(cfg).RegisterSink(bigQuerySink).ListenAndServe())
+//
+// To serve GoTrack from the program's own HTTP server instead, mount the
+// result of Handler, with TRACKING_PATH_PREFIX set to the mount point so the
+// pixel script posts there:
+//
+//	cfg.TrackingPathPrefix = "/t"
+//	h, err := gotrack.New(cfg).RegisterSink(bigQuerySink).Handler()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer h.Shutdown(context.Background())
+//	mux.Handle("/t/", h)
+//	mux.Handle("/_gotrack/", h)
+//
+// A registered sink is addressed by its Name, like an OUTPUTS entry, in
+// OUTPUT_RULES, tenant outputs, transforms and the admin API. Its name must
+// not clash with a built-in output. Sinks may implement the optional
+// interfaces in pkg/sink, such as Reloadable and Flusher, to take part in
+// reloads and draining.
+//
+// The detection trackers and classifiers are process-wide, so a process runs
+// one server at a time: ListenAndServe and Handler return an error while
+// another server is running, until its ListenAndServe returns or its
+// Handler is shut down.
+package gotrack
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/shortontech/gotrack/internal/app"
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/sink"
+)
+
+// Server is a GoTrack server that has not started yet
+type Server struct {
+	cfg   config.Config
+	sinks []sink.Sink
+}
+
+// New returns a server for cfg. cfg.Outputs may be nil when every sink is
+// registered with RegisterSink.
+func New(cfg config.Config) *Server {
+	return &Server{cfg: cfg}
+}
+
+// RegisterSink adds s to the sinks events are delivered to. The server
+// starts s before serving and closes it on shutdown.
+func (s *Server) RegisterSink(sk sink.Sink) *Server {
+	s.sinks = append(s.sinks, sk)
+	return s
+}
+
+// ListenAndServe starts the sinks and listeners and serves until the process
+// receives SIGINT or SIGTERM, then drains and closes the sinks. It returns an
+// error if the configuration is invalid, a sink fails to start or a listener
+// fails.
+func (s *Server) ListenAndServe() error {
+	return app.Serve(s.cfg, s.sinks)
+}
+
+// Handler serves GoTrack's endpoints inside another HTTP server
+type Handler struct {
+	http.Handler
+	shutdown func(context.Context)
+}
+
+// Handler starts the sinks and returns the tracking endpoints, admin API
+// and, when FORWARD_DESTINATION is set, the proxy as one handler, without
+// listening. Unlike ListenAndServe it doesn't require FORWARD_DESTINATION,
+// and leaves LISTENERS, ACME and signal handling to the program.
+func (s *Server) Handler() (*Handler, error) {
+	h, shutdown, err := app.Handler(s.cfg, s.sinks)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{Handler: h, shutdown: shutdown}, nil
+}
+
+// Shutdown refuses further events, flushes and closes the sinks and stops
+// background work. Calling it before shutting down the program's server
+// lets /readyz report the drain to load balancers.
+func (h *Handler) Shutdown(ctx context.Context) {
+	h.shutdown(ctx)
+}
@@ -0,0 +1,116 @@
+package gotrack
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shortontech/gotrack/pkg/config"
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+type recordingSink struct {
+	started, closed bool
+}
+
+func (s *recordingSink) Start(ctx context.Context) error { s.started = true; return nil }
+func (s *recordingSink) Enqueue(e event.Event) error     { return nil }
+func (s *recordingSink) Close() error                    { s.closed = true; return nil }
+func (s *recordingSink) Name() string                    { return "recording" }
+
+func TestRegisterSink(t *testing.T) {
+	a, b := &recordingSink{}, &recordingSink{}
+	s := New(config.Config{})
+	if got := s.RegisterSink(a).RegisterSink(b); got != s {
+		t.Error("RegisterSink should return the server for chaining")
+	}
+	if len(s.sinks) != 2 || s.sinks[0] != a || s.sinks[1] != b {
+		t.Errorf("sinks = %v", s.sinks)
+	}
+}
+
+func TestListenAndServe_InvalidConfig(t *testing.T) {
+	rs := &recordingSink{}
+	err := New(config.Config{LogLevel: "info", DNTAction: "strip"}).RegisterSink(rs).ListenAndServe()
+	if err == nil || !strings.Contains(err.Error(), "HMAC_SECRET") {
+		t.Fatalf("ListenAndServe() error = %v, want missing HMAC_SECRET", err)
+	}
+	if rs.started {
+		t.Error("sink started despite invalid configuration")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	cfg := config.Load()
+	cfg.HMACSecret = "test-secret"
+	cfg.Outputs = nil
+	rs := &recordingSink{}
+
+	h, err := New(cfg).RegisterSink(rs).Handler()
+	if err != nil {
+		t.Fatalf("Handler() error = %v", err)
+	}
+	if !rs.started {
+		t.Error("registered sink not started")
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /healthz = %d, want 200", rec.Code)
+	}
+
+	h.Shutdown(context.Background())
+	if !rs.closed {
+		t.Error("registered sink not closed on shutdown")
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz after Shutdown = %d, want 503", rec.Code)
+	}
+}
+
+func TestHandler_SinkFailsToStart(t *testing.T) {
+	t.Setenv("LOG_PATH", filepath.Join(t.TempDir(), "missing", "events.ndjson"))
+	cfg := config.Load()
+	cfg.HMACSecret = "test-secret"
+	cfg.Outputs = []string{"log"}
+	rs := &recordingSink{}
+
+	h, err := New(cfg).RegisterSink(rs).Handler()
+	if err == nil || !strings.Contains(err.Error(), "failed to start log sink") {
+		t.Fatalf("Handler() = %v, %v, want log sink error", h, err)
+	}
+	if rs.started {
+		t.Error("registered sink started after a built-in output failed")
+	}
+}
+
+func TestHandler_OnePerProcess(t *testing.T) {
+	cfg := config.Load()
+	cfg.HMACSecret = "test-secret"
+	cfg.Outputs = nil
+
+	h, err := New(cfg).RegisterSink(&recordingSink{}).Handler()
+	if err != nil {
+		t.Fatalf("Handler() error = %v", err)
+	}
+	rs := &recordingSink{}
+	if _, err := New(cfg).RegisterSink(rs).Handler(); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Fatalf("second Handler() error = %v, want already running", err)
+	}
+	if rs.started {
+		t.Error("second server started its sinks")
+	}
+
+	h.Shutdown(context.Background())
+	h, err = New(cfg).RegisterSink(&recordingSink{}).Handler()
+	if err != nil {
+		t.Fatalf("Handler() after Shutdown error = %v", err)
+	}
+	h.Shutdown(context.Background())
+}
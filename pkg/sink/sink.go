@@ -0,0 +1,114 @@
+// Package sink defines the contract between GoTrack's ingestion pipeline and
+// the destinations events are written to.
+//
+// The interfaces in this package follow semantic versioning: methods are not
+// added to or removed from an existing interface within a major version.
+// New capabilities are introduced as separate optional interfaces that sinks
+// may implement, such as Reloadable, LoadReporter, HealthChecker and Flusher.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/shortontech/gotrack/pkg/event"
+)
+
+// Sink receives enriched events. Enqueue is called concurrently from request
+// handlers and must not block for long; sinks that talk to remote systems
+// should buffer and flush in the background.
+type Sink interface {
+	// Start connects to the destination and starts background work. It is
+	// called once, before the first Enqueue.
+	Start(ctx context.Context) error
+	// Enqueue accepts one event for delivery
+	Enqueue(e event.Event) error
+	// Close flushes buffered events and releases resources
+	Close() error
+	// Name returns the sink name used in metrics and logs
+	Name() string
+}
+
+// Reloadable is implemented by sinks that can apply configuration changes
+// (such as batch sizes) at runtime without being restarted
+type Reloadable interface {
+	Reload() error
+}
+
+// LoadReporter is implemented by sinks that buffer events, so the ingestion
+// path can shed load when a sink falls behind
+type LoadReporter interface {
+	// Load returns the number of buffered events and how long the last flush took
+	Load() (queueDepth int, flushLatency time.Duration)
+}
+
+// BacklogReporter is implemented by sinks that buffer events, so operators
+// can alert when events wait too long to be written
+type BacklogReporter interface {
+	// OldestPending returns when the oldest event not yet written was
+	// enqueued, or the zero time when there is none
+	OldestPending() time.Time
+}
+
+// HealthChecker is implemented by sinks that can verify their destination
+// is usable, such as a reachable broker or an open database connection. It
+// backs the /readyz endpoint.
+type HealthChecker interface {
+	// Ping returns an error when the sink cannot currently deliver events.
+	// It must return by the deadline of ctx.
+	Ping(ctx context.Context) error
+}
+
+// Flusher is implemented by sinks that buffer events, so a drain before
+// shutdown can write them out while the sink stays open
+type Flusher interface {
+	// Flush writes buffered events and returns how many it wrote. Events it
+	// could not write stay buffered. It should give up once ctx is done.
+	Flush(ctx context.Context) (int, error)
+}
+
+// ContextEnqueuer is implemented by sinks that use the request context, for
+// example to record trace spans for their writes. Callers prefer
+// EnqueueContext when a sink implements it and fall back to Enqueue.
+type ContextEnqueuer interface {
+	EnqueueContext(ctx context.Context, e event.Event) error
+}
+
+// Querier is implemented by sinks that store events and can read them back,
+// such as the Postgres sink. It backs the admin read API.
+type Querier interface {
+	// QueryEvents returns matching events, newest first
+	QueryEvents(ctx context.Context, q Query) (Page, error)
+}
+
+// Purger is implemented by sinks that store events and can delete them,
+// such as the Postgres sink. It backs "gotrack purge", which services data
+// subject deletion requests.
+type Purger interface {
+	// PurgeVisitor deletes the stored events of a visitor and returns how
+	// many it deleted. Events still buffered in the sink are not affected.
+	PurgeVisitor(ctx context.Context, visitorID string) (int64, error)
+}
+
+// Query selects stored events. Empty fields do not filter.
+type Query struct {
+	Type      string
+	VisitorID string
+	SessionID string
+	IP        string    // server.ip_hash as stored: raw, truncated or hashed by IP_PRIVACY_MODE
+	Since     time.Time // inclusive lower bound on the event timestamp
+	Until     time.Time // exclusive upper bound on the event timestamp
+	Cursor    string    // NextCursor of the previous page; empty starts at the newest event
+	Limit     int
+}
+
+// Page is one page of query results
+type Page struct {
+	Events     []json.RawMessage
+	NextCursor string // empty on the last page
+}
+
+// ErrInvalidCursor is returned by QueryEvents for a cursor it did not issue
+var ErrInvalidCursor = errors.New("invalid cursor")
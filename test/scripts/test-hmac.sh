@@ -67,22 +67,21 @@ def derive_client_key(secret, client_ip):
     message = f"client-key:{ip}".encode()
     return hmac.new(secret.encode(), message, hashlib.sha256).digest()
 
-def generate_hmac(payload, secret, client_ip="127.0.0.1"):
-    """Generate HMAC for payload"""
+def generate_hmac(payload, secret, client_ip, ts, nonce):
+    """Generate HMAC over timestamp, nonce and payload"""
     client_key = derive_client_key(secret, client_ip)
-    return hmac.new(client_key, payload.encode(), hashlib.sha256).hexdigest()
+    message = f"{ts}\n{nonce}\n{payload}".encode()
+    return hmac.new(client_key, message, hashlib.sha256).hexdigest()
 
 if __name__ == "__main__":
-    secret = sys.argv[1]
-    payload = sys.argv[2]
-    client_ip = sys.argv[3] if len(sys.argv) > 3 else "127.0.0.1"
-    
-    hmac_value = generate_hmac(payload, secret, client_ip)
-    print(hmac_value)
+    secret, payload, client_ip, ts, nonce = sys.argv[1:6]
+    print(generate_hmac(payload, secret, client_ip, ts, nonce))
 EOF
 
 PAYLOAD='{"e":"test","user":"123"}'
-HMAC_VALUE=$(python3 /tmp/hmac_test.py "test-secret-key-12345" "$PAYLOAD" "::1")
+TS=$(date +%s)
+NONCE=$(openssl rand -hex 16)
+HMAC_VALUE=$(python3 /tmp/hmac_test.py "test-secret-key-12345" "$PAYLOAD" "::1" "$TS" "$NONCE")
 echo "Generated HMAC for IPv6 localhost: $HMAC_VALUE"
 echo
 
@@ -91,6 +90,8 @@ echo "6. Testing with correct HMAC (should succeed)..."
 RESPONSE=$(curl -s -w "%{http_code}" -X POST \
     -H "Content-Type: application/json" \
     -H "X-GoTrack-HMAC: $HMAC_VALUE" \
+    -H "X-GoTrack-TS: $TS" \
+    -H "X-GoTrack-Nonce: $NONCE" \
     -d "$PAYLOAD" \
     http://localhost:19907/collect)
 
@@ -108,8 +109,28 @@ else
 fi
 echo
 
+# Replay the same signed request
+echo "7. Replaying the signed request (should fail with 401)..."
+RESPONSE=$(curl -s -w "%{http_code}" -X POST \
+    -H "Content-Type: application/json" \
+    -H "X-GoTrack-HMAC: $HMAC_VALUE" \
+    -H "X-GoTrack-TS: $TS" \
+    -H "X-GoTrack-Nonce: $NONCE" \
+    -d "$PAYLOAD" \
+    http://localhost:19907/collect)
+
+STATUS_CODE=$(echo "$RESPONSE" | tail -c 4)
+if [[ "$STATUS_CODE" == "401" ]]; then
+    echo "✓ Correctly rejected replayed request"
+else
+    echo "✗ Expected 401, got $STATUS_CODE"
+    kill $GOTRACK_PID 2>/dev/null || true
+    exit 1
+fi
+echo
+
 # Test with invalid HMAC
-echo "7. Testing with invalid HMAC (should fail)..."
+echo "8. Testing with invalid HMAC (should fail)..."
 INVALID_HMAC="deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef"
 RESPONSE=$(curl -s -w "%{http_code}" -X POST \
     -H "Content-Type: application/json" \
@@ -138,6 +159,7 @@ echo "Summary:"
 echo "✓ HMAC authentication properly rejects requests without signatures"
 echo "✓ HMAC authentication accepts requests with valid signatures"
 echo "✓ HMAC authentication rejects requests with invalid signatures"
+echo "✓ Replayed requests are rejected by nonce"
 echo "✓ Client IP is properly incorporated into HMAC calculation"
 echo "✓ Public key and script endpoints are available"
 echo
@@ -149,4 +171,5 @@ echo
 echo "Client integration:"
 echo "  GET /hmac/public-key            - Get public key for client-side HMAC"
 echo "  GET /hmac.js                    - Get JavaScript HMAC integration"
-echo "  Header: X-GoTrack-HMAC          - HMAC signature header"
\ No newline at end of file
+echo "  Header: X-GoTrack-HMAC          - HMAC signature header"
+echo "  Headers: X-GoTrack-TS, X-GoTrack-Nonce - signed timestamp and one-time nonce"
\ No newline at end of file